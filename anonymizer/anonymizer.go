@@ -0,0 +1,409 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package anonymizer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/vmware-tanzu/crash-diagnostics/bundle"
+)
+
+// Categories of pseudonymized values
+const (
+	Hostnames  = "hostnames"
+	IPs        = "ips"
+	Namespaces = "namespaces"
+	Accounts   = "accounts"
+)
+
+// AllCategories are the categories pseudonymized by default
+var AllCategories = []string{Hostnames, IPs, Namespaces, Accounts}
+
+// systemNamespaces are common to all clusters and are kept as is
+var systemNamespaces = map[string]bool{
+	"default":         true,
+	"kube-system":     true,
+	"kube-public":     true,
+	"kube-node-lease": true,
+}
+
+var (
+	ipPattern      = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	ipDirPattern   = regexp.MustCompile(`^\d{1,3}(?:_\d{1,3}){3}$`)
+	tokenPattern   = regexp.MustCompile(`[A-Za-z0-9](?:[A-Za-z0-9._-]*[A-Za-z0-9])?`)
+	nonAlnum       = regexp.MustCompile(`[^a-zA-Z0-9]`)
+	accountPattern = []*regexp.Regexp{
+		// AWS ARNs: arn:aws:iam::123456789012:role/name
+		regexp.MustCompile(`(arn:aws[a-z-]*:[a-z0-9-]*:[a-z0-9-]*:)(\d{12})`),
+		// AWS account IDs: "AccountId": "123456789012", owner-id=123456789012
+		regexp.MustCompile(`(?i)((?:account|owner)[-_]?id["']?\s*[:=]\s*["']?)(\d{12})`),
+		// Azure subscriptions: /subscriptions/00000000-0000-0000-0000-000000000000
+		regexp.MustCompile(`(?i)(/subscriptions/)([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})`),
+	}
+)
+
+// Anonymizer consistently replaces hostnames, IP addresses, namespaces, and cloud account IDs
+// with pseudonyms: a value is replaced with the same pseudonym in all the file names and
+// contents of a bundle. The mapping from values to pseudonyms is kept to be saved locally.
+type Anonymizer struct {
+	mu         sync.Mutex
+	categories map[string]bool
+	// mapping holds, for each category, the pseudonym of each value
+	mapping map[string]map[string]string
+	// hosts and namespaces are the pseudonyms of the hostnames and namespaces, replaced on token boundaries
+	hosts      map[string]string
+	namespaces map[string]string
+	// dirs are the host directory names, the hostnames with non alphanumeric characters replaced with _
+	dirs map[string]string
+}
+
+// New returns an Anonymizer of the categories of values (AllCategories if empty)
+func New(categories ...string) (*Anonymizer, error) {
+	if len(categories) == 0 {
+		categories = AllCategories
+	}
+	a := &Anonymizer{
+		categories: make(map[string]bool),
+		mapping:    make(map[string]map[string]string),
+		hosts:      make(map[string]string),
+		namespaces: make(map[string]string),
+		dirs:       make(map[string]string),
+	}
+	for _, category := range categories {
+		switch category {
+		case Hostnames, IPs, Namespaces, Accounts:
+			a.categories[category] = true
+			a.mapping[category] = make(map[string]string)
+		default:
+			return nil, fmt.Errorf("unsupported anonymize category %q (supported: %s)", category, strings.Join(AllCategories, ", "))
+		}
+	}
+	return a, nil
+}
+
+// AddHostname registers a hostname to pseudonymize. IP addresses and local hosts are ignored.
+func (a *Anonymizer) AddHostname(host string) {
+	if !a.categories[Hostnames] || len(host) == 0 || net.ParseIP(host) != nil || host == "local" || host == "localhost" {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	pseudonym := a.pseudonym(Hostnames, host, "host")
+	a.hosts[host] = pseudonym
+	a.dirs[nonAlnum.ReplaceAllString(host, "_")] = nonAlnum.ReplaceAllString(pseudonym, "_")
+}
+
+// AddNamespace registers a namespace to pseudonymize. System namespaces are kept as is.
+func (a *Anonymizer) AddNamespace(ns string) {
+	if !a.categories[Namespaces] || len(ns) == 0 || systemNamespaces[ns] {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.namespaces[ns] = a.pseudonym(Namespaces, ns, "ns")
+}
+
+// manifest holds the fields of the API objects, and lists of objects, used to discover namespaces and nodes
+type manifest struct {
+	Kind     string `json:"kind" yaml:"kind"`
+	Metadata struct {
+		Name      string `json:"name" yaml:"name"`
+		Namespace string `json:"namespace" yaml:"namespace"`
+	} `json:"metadata" yaml:"metadata"`
+	Items []manifest `json:"items" yaml:"items"`
+}
+
+// Discover registers the namespaces and the node names found in the objects and directories
+// saved by kube_capture under dir. Manifests are decoded from disk, whatever their size.
+func (a *Anonymizer) Discover(dir string) error {
+	return filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		kubeIdx := -1
+		for i, part := range parts {
+			if part == bundle.KubeDir {
+				kubeIdx = i
+				break
+			}
+		}
+		if kubeIdx < 0 {
+			return nil
+		}
+
+		// kubecapture/<namespace>/<resource>.json
+		if info.IsDir() && len(parts) == kubeIdx+2 {
+			a.AddNamespace(parts[kubeIdx+1])
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return a.discoverFile(file)
+	})
+}
+
+// discoverFile registers the namespaces and node names of the objects of a JSON or YAML manifest.
+// Files that are not manifests are ignored.
+func (a *Anonymizer) discoverFile(file string) error {
+	ext := filepath.Ext(file)
+	if ext != ".json" && ext != ".yaml" && ext != ".yml" {
+		return nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var add func(m manifest)
+	add = func(m manifest) {
+		for _, item := range m.Items {
+			add(item)
+		}
+		a.AddNamespace(m.Metadata.Namespace)
+		if m.Kind == "Node" {
+			a.AddHostname(m.Metadata.Name)
+		}
+	}
+	if ext == ".json" {
+		var m manifest
+		if err := json.NewDecoder(f).Decode(&m); err == nil {
+			add(m)
+		}
+		return nil
+	}
+	decoder := yaml.NewDecoder(f)
+	for {
+		var m manifest
+		if err := decoder.Decode(&m); err != nil {
+			return nil
+		}
+		add(m)
+	}
+}
+
+// Name returns the pseudonymized path of a file: IP addresses, host directories, and
+// namespaces are replaced in each element of the path
+func (a *Anonymizer) Name(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		a.mu.Lock()
+		dir, isDir := a.dirs[part]
+		a.mu.Unlock()
+		switch {
+		case isDir:
+			parts[i] = dir
+		case a.categories[IPs] && ipDirPattern.MatchString(part):
+			ip := strings.Replace(part, "_", ".", -1)
+			if pseudonym, ok := a.ip(ip); ok {
+				parts[i] = strings.Replace(pseudonym, ".", "_", -1)
+			}
+		default:
+			// a namespace, or a hostname, with an extension (i.e. payments.json)
+			ext := filepath.Ext(part)
+			if pseudonym, ok := a.exact(strings.TrimSuffix(part, ext)); ok && len(ext) > 0 {
+				parts[i] = pseudonym + ext
+				continue
+			}
+			parts[i] = string(a.Content([]byte(part)))
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// binaryCheckSize is the size of the beginning of the content checked for NUL bytes
+const binaryCheckSize = 8000
+
+// Content returns the pseudonymized content of a file. Binary content is returned as is.
+func (a *Anonymizer) Content(data []byte) []byte {
+	check := data
+	if len(check) > binaryCheckSize {
+		check = check[:binaryCheckSize]
+	}
+	if bytes.IndexByte(check, 0) >= 0 {
+		return data
+	}
+	return a.replace(data)
+}
+
+// Rewrite copies the content of r to w pseudonymized, line by line, so that files of any size are
+// anonymized without being read in memory. Binary content is copied as is.
+func (a *Anonymizer) Rewrite(w io.Writer, r io.Reader) error {
+	reader := bufio.NewReaderSize(r, binaryCheckSize)
+	check, err := reader.Peek(binaryCheckSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return err
+	}
+	if bytes.IndexByte(check, 0) >= 0 {
+		_, err := io.Copy(w, reader)
+		return err
+	}
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if _, writeErr := w.Write(a.replace(line)); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// replace returns text with its values pseudonymized. The values never span lines.
+func (a *Anonymizer) replace(data []byte) []byte {
+	if a.categories[IPs] {
+		data = ipPattern.ReplaceAllFunc(data, func(match []byte) []byte {
+			if pseudonym, ok := a.ip(string(match)); ok {
+				return []byte(pseudonym)
+			}
+			return match
+		})
+	}
+	if a.categories[Accounts] {
+		for _, re := range accountPattern {
+			data = re.ReplaceAllFunc(data, func(match []byte) []byte {
+				groups := re.FindSubmatch(match)
+				return append(append([]byte{}, groups[1]...), a.account(string(groups[2]))...)
+			})
+		}
+	}
+
+	a.mu.Lock()
+	hasTerms := len(a.hosts) > 0 || len(a.namespaces) > 0
+	a.mu.Unlock()
+	if hasTerms {
+		data = tokenPattern.ReplaceAllFunc(data, func(token []byte) []byte {
+			return []byte(a.term(string(token)))
+		})
+	}
+	return data
+}
+
+// exact returns the pseudonym of a token that is a hostname or a namespace
+func (a *Anonymizer) exact(token string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if pseudonym, ok := a.hosts[token]; ok {
+		return pseudonym, true
+	}
+	pseudonym, ok := a.namespaces[token]
+	return pseudonym, ok
+}
+
+// term returns the pseudonym of a hostname or namespace token. Values are only replaced on token
+// boundaries: a dotted token is a fully qualified hostname, whose first label is replaced when it is a
+// hostname, or the DNS name of a service or pod (<name>.<namespace>.svc[.cluster.local]), whose namespace
+// label is replaced. The labels of other dotted tokens (i.e. payments.example.io/owner) are kept.
+func (a *Anonymizer) term(token string) string {
+	if pseudonym, ok := a.exact(token); ok {
+		return pseudonym
+	}
+	if !strings.Contains(token, ".") {
+		return token
+	}
+	labels := strings.Split(token, ".")
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if pseudonym, ok := a.hosts[labels[0]]; ok {
+		labels[0] = pseudonym
+	}
+	if len(labels) > 2 && (labels[2] == "svc" || labels[2] == "pod") {
+		if pseudonym, ok := a.namespaces[labels[1]]; ok {
+			labels[1] = pseudonym
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// ip returns the pseudonym of an IP address, from the reserved range 240.0.0.0/4. Loopback,
+// unspecified, and broadcast addresses are kept as is.
+func (a *Anonymizer) ip(addr string) (string, bool) {
+	ip := net.ParseIP(addr).To4()
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.Equal(net.IPv4bcast) {
+		return "", false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if pseudonym, ok := a.mapping[IPs][addr]; ok {
+		return pseudonym, true
+	}
+	n := len(a.mapping[IPs]) + 1
+	pseudonym := fmt.Sprintf("240.%d.%d.%d", (n>>16)&0xff, (n>>8)&0xff, n&0xff)
+	a.mapping[IPs][addr] = pseudonym
+	return pseudonym, true
+}
+
+// account returns the pseudonym of an AWS account ID or Azure subscription ID, of the same format
+func (a *Anonymizer) account(id string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if pseudonym, ok := a.mapping[Accounts][id]; ok {
+		return pseudonym
+	}
+	n := len(a.mapping[Accounts]) + 1
+	pseudonym := fmt.Sprintf("%012d", n)
+	if len(id) == 36 {
+		pseudonym = fmt.Sprintf("00000000-0000-0000-0000-%012d", n)
+	}
+	a.mapping[Accounts][id] = pseudonym
+	return pseudonym
+}
+
+// pseudonym returns the pseudonym of value in category, prefix-N for new values.
+// It must be called with the lock held.
+func (a *Anonymizer) pseudonym(category, value, prefix string) string {
+	if pseudonym, ok := a.mapping[category][value]; ok {
+		return pseudonym
+	}
+	pseudonym := fmt.Sprintf("%s-%d", prefix, len(a.mapping[category])+1)
+	a.mapping[category][value] = pseudonym
+	return pseudonym
+}
+
+// Mapping returns, for each category, the pseudonym of each value replaced so far
+func (a *Anonymizer) Mapping() map[string]map[string]string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	mapping := make(map[string]map[string]string)
+	for category, values := range a.mapping {
+		mapping[category] = make(map[string]string)
+		for value, pseudonym := range values {
+			mapping[category][value] = pseudonym
+		}
+	}
+	return mapping
+}
+
+// WriteMapping saves the mapping as JSON, readable only by its owner, at path
+func (a *Anonymizer) WriteMapping(path string) error {
+	data, err := json.MarshalIndent(a.Mapping(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
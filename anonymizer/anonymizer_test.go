@@ -0,0 +1,177 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package anonymizer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vmware-tanzu/crash-diagnostics/bundle"
+)
+
+func TestAnonymizerContent(t *testing.T) {
+	tests := []struct {
+		name       string
+		categories []string
+		hosts      []string
+		namespaces []string
+		content    string
+		expected   string
+	}{
+		{
+			name:     "ips",
+			content:  "node 10.0.0.1 peer 10.0.0.2, again 10.0.0.1, local 127.0.0.1 0.0.0.0",
+			expected: "node 240.0.0.1 peer 240.0.0.2, again 240.0.0.1, local 127.0.0.1 0.0.0.0",
+		},
+		{
+			name:       "hostnames and namespaces",
+			hosts:      []string{"worker-a", "localhost"},
+			namespaces: []string{"payments", "kube-system"},
+			content:    "pod payments/api on worker-a.corp.example.com, kube-system/coredns on worker-a, localhost",
+			expected:   "pod ns-1/api on host-1.corp.example.com, kube-system/coredns on host-1, localhost",
+		},
+		{
+			name:       "token boundaries",
+			hosts:      []string{"worker-a"},
+			namespaces: []string{"payments", "api"},
+			content:    "web.payments.svc.cluster.local payments.example.io/owner api-gateway x.worker-a.example.com worker-a.example.com",
+			expected:   "web.ns-1.svc.cluster.local payments.example.io/owner api-gateway x.worker-a.example.com host-1.example.com",
+		},
+		{
+			name:     "accounts",
+			content:  `arn:aws:iam::123456789012:role/ops "OwnerId": "123456789012" /subscriptions/0a1b2c3d-0000-1111-2222-333344445555/resourceGroups`,
+			expected: `arn:aws:iam::000000000001:role/ops "OwnerId": "000000000001" /subscriptions/00000000-0000-0000-0000-000000000002/resourceGroups`,
+		},
+		{
+			name:       "selected categories",
+			categories: []string{IPs},
+			hosts:      []string{"worker-a"},
+			content:    "worker-a 10.0.0.1 arn:aws:iam::123456789012:root",
+			expected:   "worker-a 240.0.0.1 arn:aws:iam::123456789012:root",
+		},
+		{
+			name:     "binary content",
+			content:  "10.0.0.1\x00",
+			expected: "10.0.0.1\x00",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			anon, err := New(test.categories...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, host := range test.hosts {
+				anon.AddHostname(host)
+			}
+			for _, ns := range test.namespaces {
+				anon.AddNamespace(ns)
+			}
+			if result := string(anon.Content([]byte(test.content))); result != test.expected {
+				t.Errorf("unexpected content:\n%s\nexpecting:\n%s", result, test.expected)
+			}
+		})
+	}
+
+	if _, err := New("pods"); err == nil {
+		t.Error("expecting error for unsupported category")
+	}
+}
+
+func TestAnonymizerRewrite(t *testing.T) {
+	anon, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	anon.AddNamespace("payments")
+
+	// larger than the files kept in memory by bundles
+	content := strings.Repeat("filler line\n", bundle.MaxContentSize/10) + "pod payments/api 10.0.0.1\n"
+	var out bytes.Buffer
+	if err := anon.Rewrite(&out, strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(out.String(), "filler line\npod ns-1/api 240.0.0.1\n") || out.Len() != len(content)-3 {
+		t.Errorf("unexpected rewritten content ending with %q", out.String()[out.Len()-40:])
+	}
+
+	binary := "10.0.0.1\x00payments"
+	out.Reset()
+	if err := anon.Rewrite(&out, strings.NewReader(binary)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != binary {
+		t.Errorf("expecting binary content unchanged, got %q", out.String())
+	}
+}
+
+func TestAnonymizerDiscover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashd-anonymizer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"kubecapture/nodes.json":               `{"kind":"NodeList","items":[{"kind":"Node","metadata":{"name":"worker-a"}}]}`,
+		"kubecapture/payments/pods.json":       `{"kind":"PodList","items":[{"kind":"Pod","metadata":{"name":"api","namespace":"payments"}}]}`,
+		"kubecapture/kube-system/pods.json":    `{"kind":"PodList","items":[]}`,
+		"kubecapture/payments/api/api/api.log": "started",
+		"kubecapture/billing/events.json":      `{"kind":"EventList","items":[{"kind":"Event","metadata":{"name":"e","namespace":"billing"},"message":"` + strings.Repeat("x", bundle.MaxContentSize) + `"}]}`,
+		"kubecapture/nodes-large.yaml":         "kind: NodeList\nitems:\n- kind: Node\n  metadata:\n    name: worker-b\n  data: " + strings.Repeat("x", bundle.MaxContentSize) + "\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0744); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	anon, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := anon.Discover(dir); err != nil {
+		t.Fatal(err)
+	}
+	anon.AddHostname("db.example.com")
+
+	names := map[string]string{
+		"tmp/crashd/kubecapture/payments/pods.json":    "tmp/crashd/kubecapture/ns-2/pods.json",
+		"tmp/crashd/kubecapture/kube-system/pods.json": "tmp/crashd/kubecapture/kube-system/pods.json",
+		"tmp/crashd/10_0_0_1/df.txt":                   "tmp/crashd/240_0_0_1/df.txt",
+		"tmp/crashd/db_example_com/df.txt":             "tmp/crashd/host_3/df.txt",
+	}
+	for name, expected := range names {
+		if result := anon.Name(name); result != expected {
+			t.Errorf("unexpected name for %s: %s", name, result)
+		}
+	}
+
+	mapping := anon.Mapping()
+	// the nodes and namespaces of manifests larger than the files kept in memory by bundles are discovered too
+	if mapping[Hostnames]["worker-b"] != "host-1" || mapping[Hostnames]["worker-a"] != "host-2" || mapping[Namespaces]["billing"] != "ns-1" ||
+		mapping[Namespaces]["payments"] != "ns-2" || mapping[IPs]["10.0.0.1"] != "240.0.0.1" {
+		t.Errorf("unexpected mapping: %v", mapping)
+	}
+	if _, ok := mapping[Namespaces]["kube-system"]; ok {
+		t.Error("system namespace should not be pseudonymized")
+	}
+
+	mappingFile := filepath.Join(dir, "mapping.json")
+	if err := anon.WriteMapping(mappingFile); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(mappingFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("unexpected mapping file: %v %v", info, err)
+	}
+}
@@ -0,0 +1,7 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package anonymizer pseudonymizes hostnames, IP addresses, namespaces, and cloud account IDs
+// in the file names and contents of a bundle, so that it can be shared without leaking the
+// topology of the infrastructure.
+package anonymizer
@@ -0,0 +1,129 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package archiver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync"
+)
+
+// gzipBlockSize is the size of the data blocks compressed concurrently
+const gzipBlockSize = 1 << 20
+
+// parallelGzipWriter compresses blocks of data concurrently, each block as a gzip member,
+// and writes the members in order. Readers of multi-member gzip streams (gzip, tar, Go's
+// gzip.Reader) decompress the output as one stream. At most workers blocks are held in memory.
+type parallelGzipWriter struct {
+	w     io.Writer
+	level int
+	buf   []byte
+	count int
+
+	pending chan chan gzipBlock
+	done    chan struct{}
+	mu      sync.Mutex
+	err     error
+}
+
+type gzipBlock struct {
+	data []byte
+	err  error
+}
+
+func newParallelGzipWriter(w io.Writer, workers, level int) *parallelGzipWriter {
+	if workers < 1 {
+		workers = 1
+	}
+	pw := &parallelGzipWriter{
+		w:       w,
+		level:   level,
+		buf:     make([]byte, 0, gzipBlockSize),
+		pending: make(chan chan gzipBlock, workers),
+		done:    make(chan struct{}),
+	}
+	go pw.writeBlocks()
+	return pw
+}
+
+// writeBlocks writes the compressed blocks in submission order
+func (pw *parallelGzipWriter) writeBlocks() {
+	defer close(pw.done)
+	for result := range pw.pending {
+		block := <-result
+		if pw.getErr() != nil {
+			continue
+		}
+		if block.err != nil {
+			pw.setErr(block.err)
+			continue
+		}
+		if _, err := pw.w.Write(block.data); err != nil {
+			pw.setErr(err)
+		}
+	}
+}
+
+func (pw *parallelGzipWriter) getErr() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	return pw.err
+}
+
+func (pw *parallelGzipWriter) setErr(err error) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if pw.err == nil {
+		pw.err = err
+	}
+}
+
+func (pw *parallelGzipWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if err := pw.getErr(); err != nil {
+			return written, err
+		}
+		n := copy(pw.buf[len(pw.buf):cap(pw.buf)], p)
+		pw.buf = pw.buf[:len(pw.buf)+n]
+		p = p[n:]
+		written += n
+		if len(pw.buf) == cap(pw.buf) {
+			pw.submit()
+		}
+	}
+	return written, nil
+}
+
+// submit compresses the buffered block in the background; it blocks while workers blocks are pending
+func (pw *parallelGzipWriter) submit() {
+	data := pw.buf
+	pw.buf = make([]byte, 0, gzipBlockSize)
+	pw.count++
+
+	result := make(chan gzipBlock, 1)
+	pw.pending <- result
+	go func() {
+		var out bytes.Buffer
+		gz, err := gzip.NewWriterLevel(&out, pw.level)
+		if err == nil {
+			_, err = gz.Write(data)
+		}
+		if err == nil {
+			err = gz.Close()
+		}
+		result <- gzipBlock{data: out.Bytes(), err: err}
+	}()
+}
+
+// Close compresses the remaining data and waits until all the blocks are written
+func (pw *parallelGzipWriter) Close() error {
+	if len(pw.buf) > 0 || pw.count == 0 {
+		pw.submit()
+	}
+	close(pw.pending)
+	<-pw.done
+	return pw.getErr()
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package archiver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestParallelGzipWriter(t *testing.T) {
+	random := make([]byte, 3*gzipBlockSize+123)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		workers int
+	}{
+		{name: "empty", data: nil, workers: 2},
+		{name: "smaller than block", data: []byte("crashd"), workers: 4},
+		{name: "several blocks", data: random, workers: 4},
+		{name: "one worker", data: random, workers: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			gz := newParallelGzipWriter(&out, test.workers, gzip.DefaultCompression)
+			// write in uneven chunks to cross block boundaries
+			for data := test.data; len(data) > 0; {
+				n := 100000
+				if n > len(data) {
+					n = len(data)
+				}
+				if _, err := gz.Write(data[:n]); err != nil {
+					t.Fatal(err)
+				}
+				data = data[n:]
+			}
+			if err := gz.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			reader, err := gzip.NewReader(&out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, test.data) {
+				t.Errorf("decompressed %d bytes, expecting %d", len(data), len(test.data))
+			}
+		})
+	}
+}
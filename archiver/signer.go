@@ -0,0 +1,80 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package archiver
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/openpgp"
+)
+
+// SignatureSuffix is appended to the name of a file to name its detached signature
+const SignatureSuffix = ".asc"
+
+// Sign creates an ASCII armored, detached, OpenPGP signature of fileName in <fileName>.asc using
+// the first private key of the key ring key (armored or binary). An encrypted private key is
+// decrypted with passphrase. The signature can be verified with
+// `gpg --verify <fileName>.asc <fileName>`.
+func Sign(fileName string, key, passphrase []byte) (string, error) {
+	signer, err := readSigner(key, passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	sigName := fileName + SignatureSuffix
+	sig, err := os.Create(sigName)
+	if err != nil {
+		return "", err
+	}
+	defer sig.Close()
+
+	logrus.Debugf("Signing %s with key %X", fileName, signer.PrimaryKey.KeyId)
+	if err := openpgp.ArmoredDetachSign(sig, signer, file, nil); err != nil {
+		os.Remove(sigName)
+		return "", fmt.Errorf("sign: %s", err)
+	}
+	return sigName, nil
+}
+
+// readSigner returns the first entity of the key ring with a private key, decrypted with passphrase
+func readSigner(key, passphrase []byte) (*openpgp.Entity, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(key))
+	if err != nil {
+		if entities, err = openpgp.ReadKeyRing(bytes.NewReader(key)); err != nil {
+			return nil, fmt.Errorf("sign: failed to read key: %s", err)
+		}
+	}
+
+	for _, entity := range entities {
+		if entity.PrivateKey == nil {
+			continue
+		}
+		if entity.PrivateKey.Encrypted {
+			if len(passphrase) == 0 {
+				return nil, fmt.Errorf("sign: key %X is encrypted, passphrase required", entity.PrimaryKey.KeyId)
+			}
+			if err := entity.PrivateKey.Decrypt(passphrase); err != nil {
+				return nil, fmt.Errorf("sign: failed to decrypt key %X: %s", entity.PrimaryKey.KeyId, err)
+			}
+		}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+				if err := subkey.PrivateKey.Decrypt(passphrase); err != nil {
+					return nil, fmt.Errorf("sign: failed to decrypt subkey %X: %s", subkey.PublicKey.KeyId, err)
+				}
+			}
+		}
+		return entity, nil
+	}
+	return nil, fmt.Errorf("sign: no private key found")
+}
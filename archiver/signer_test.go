@@ -0,0 +1,71 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package archiver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+func TestSign(t *testing.T) {
+	entity, err := openpgp.NewEntity("crashd", "test", "crashd@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var privateKey bytes.Buffer
+	armored, err := armor.Encode(&privateKey, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.SerializePrivate(armored, nil); err != nil {
+		t.Fatal(err)
+	}
+	armored.Close()
+
+	dir, err := ioutil.TempDir("", "crashd-sign")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "archive.tar.gz")
+	if err := ioutil.WriteFile(fileName, []byte("archive content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sigName, err := Sign(fileName, privateKey.Bytes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sigName != fileName+SignatureSuffix {
+		t.Fatalf("unexpected signature file %s", sigName)
+	}
+
+	sig, err := os.Open(sigName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sig.Close()
+	signer, err := openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{entity}, bytes.NewReader([]byte("archive content")), sig)
+	if err != nil {
+		t.Fatalf("signature not verified: %s", err)
+	}
+	if signer.PrimaryKey.KeyId != entity.PrimaryKey.KeyId {
+		t.Errorf("unexpected signer %X", signer.PrimaryKey.KeyId)
+	}
+
+	sig.Seek(0, 0)
+	if _, err := openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{entity}, bytes.NewReader([]byte("tampered content")), sig); err == nil {
+		t.Error("expected verification of tampered content to fail")
+	}
+
+	if _, err := Sign(fileName, []byte("not a key"), nil); err == nil {
+		t.Error("expected failure with invalid key")
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package archiver
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Split breaks the file specified by fileName into volumes of at most partSize bytes
+// named <fileName>.part000, <fileName>.part001, etc. The original file is removed
+// once all volumes are written. The volumes can be reassembled with
+// `cat <fileName>.part* > <fileName>`.
+func Split(fileName string, partSize int64) ([]string, error) {
+	if partSize <= 0 {
+		return nil, fmt.Errorf("split: invalid part size %d", partSize)
+	}
+	logrus.Debugf("Splitting %s in parts of %d bytes", fileName, partSize)
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var parts []string
+	for i := 0; ; i++ {
+		partName := fmt.Sprintf("%s.part%03d", fileName, i)
+		written, err := writePart(partName, file, partSize)
+		if err != nil {
+			return nil, err
+		}
+		if written == 0 {
+			if err := os.Remove(partName); err != nil {
+				return nil, err
+			}
+			break
+		}
+		parts = append(parts, partName)
+		if written < partSize {
+			break
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(fileName); err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+func writePart(partName string, source io.Reader, partSize int64) (int64, error) {
+	part, err := os.Create(partName)
+	if err != nil {
+		return 0, err
+	}
+	defer part.Close()
+
+	written, err := io.CopyN(part, source, partSize)
+	if err != nil && err != io.EOF {
+		return written, err
+	}
+	return written, part.Close()
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package archiver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		partSize int64
+		parts    int
+	}{
+		{name: "smaller than part", size: 10, partSize: 64, parts: 1},
+		{name: "exact multiple", size: 128, partSize: 64, parts: 2},
+		{name: "with remainder", size: 130, partSize: 64, parts: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "crashd-split")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			content := bytes.Repeat([]byte("a"), test.size)
+			fileName := filepath.Join(dir, "archive.tar.gz")
+			if err := ioutil.WriteFile(fileName, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			parts, err := Split(fileName, test.partSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(parts) != test.parts {
+				t.Fatalf("expecting %d parts, got %d", test.parts, len(parts))
+			}
+			if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+				t.Error("original file should be removed")
+			}
+
+			var joined []byte
+			for _, part := range parts {
+				data, err := ioutil.ReadFile(part)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if int64(len(data)) > test.partSize {
+					t.Errorf("part %s is larger than %d bytes", part, test.partSize)
+				}
+				joined = append(joined, data...)
+			}
+			if !bytes.Equal(joined, content) {
+				t.Error("joined parts do not match original content")
+			}
+		})
+	}
+}
@@ -5,40 +5,175 @@ package archiver
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/sirupsen/logrus"
 )
 
+// File is in-memory content added to the root of a tarball
+type File struct {
+	Name string
+	Data []byte
+}
+
+// Stdout is the tarball name writing the tarball to the standard output
+const Stdout = "-"
+
+// Compression formats of tarballs
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// Options control the creation of a tarball
+type Options struct {
+	// Files are in-memory files added to the root of the tarball
+	Files []File
+	// Workers is the number of blocks compressed concurrently (runtime.NumCPU() if 0)
+	Workers int
+	// Compression is the compression format; when empty, it is derived from the tarball
+	// name (.gz for gzip, .zst for zstd)
+	Compression string
+	// Rename and Rewrite, when set, rewrite the names and the contents of the archived files
+	// (i.e. to anonymize them). Rewrite copies the content of r, rewritten, to w; the rewritten files
+	// are staged in temporary files, so that files of any size are rewritten without being read in memory.
+	Rename  func(name string) string
+	Rewrite func(w io.Writer, r io.Reader) error
+}
+
+// ValidateCompression returns an error when compression is not a supported compression format
+func ValidateCompression(compression string) error {
+	switch compression {
+	case "", CompressionNone, CompressionGzip, CompressionZstd:
+		return nil
+	default:
+		return fmt.Errorf("unsupported compression %q (supported: %s, %s, %s)", compression, CompressionGzip, CompressionZstd, CompressionNone)
+	}
+}
+
+// compressionOf returns the compression format implied by the tarball name
+func compressionOf(tarName string) string {
+	switch {
+	case strings.HasSuffix(tarName, ".gz"), strings.HasSuffix(tarName, ".gzip"), strings.HasSuffix(tarName, ".tgz"):
+		return CompressionGzip
+	case strings.HasSuffix(tarName, ".zst"), strings.HasSuffix(tarName, ".zstd"):
+		return CompressionZstd
+	default:
+		return CompressionNone
+	}
+}
+
 // Tar compresses the file sources specified by paths into a single
 // tarball specified by tarName.
 func Tar(tarName string, paths ...string) (err error) {
-	logrus.Debugf("Archiving %v in %s", paths, tarName)
-	tarFile, err := os.Create(tarName)
-	if err != nil {
+	return TarWithOptions(tarName, Options{}, paths...)
+}
+
+// TarWithFiles compresses the in-memory files and the file sources specified
+// by paths into a single tarball specified by tarName.
+func TarWithFiles(tarName string, files []File, paths ...string) (err error) {
+	return TarWithOptions(tarName, Options{Files: files}, paths...)
+}
+
+// TarWithOptions archives the file sources specified by paths into a single tarball
+// specified by tarName. Files are streamed into the tarball, which is compressed, with gzip
+// or zstd, by opts.Workers concurrent workers. When tarName is Stdout, the tarball is written
+// to the standard output, gzip compressed unless opts.Compression is set.
+func TarWithOptions(tarName string, opts Options, paths ...string) (err error) {
+	if err := ValidateCompression(opts.Compression); err != nil {
 		return err
 	}
-	defer func() {
-		err = tarFile.Close()
-	}()
+	compression := opts.Compression
+	if len(compression) == 0 {
+		compression = compressionOf(tarName)
+		if tarName == Stdout {
+			compression = CompressionGzip
+		}
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
 
-	absTar, err := filepath.Abs(tarName)
-	if err != nil {
-		return err
+	logrus.Debugf("Archiving %v in %s (compression: %s)", paths, tarName, compression)
+	var tarFile io.Writer = os.Stdout
+	var absTar string
+	if tarName != Stdout {
+		file, err := os.Create(tarName)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		tarFile = file
+
+		absTar, err = filepath.Abs(tarName)
+		if err != nil {
+			return err
+		}
+	}
+
+	var out io.Writer = tarFile
+	var compressor io.WriteCloser
+	switch compression {
+	case CompressionGzip:
+		compressor = newParallelGzipWriter(tarFile, workers, gzip.DefaultCompression)
+	case CompressionZstd:
+		compressor, err = zstd.NewWriter(tarFile, zstd.WithEncoderConcurrency(workers))
+		if err != nil {
+			return err
+		}
+	}
+	if compressor != nil {
+		defer func() {
+			if closeErr := compressor.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		out = compressor
 	}
+	tw := tar.NewWriter(out)
+	defer func() {
+		if closeErr := tw.Close(); err == nil {
+			err = closeErr
+		}
+	}()
 
-	// enable compression if file ends in .gz
-	tw := tar.NewWriter(tarFile)
-	if strings.HasSuffix(tarName, ".gz") || strings.HasSuffix(tarName, ".gzip") {
-		gz := gzip.NewWriter(tarFile)
-		defer gz.Close()
-		tw = tar.NewWriter(gz)
+	for _, file := range opts.Files {
+		name, data := file.Name, file.Data
+		if opts.Rename != nil {
+			name = opts.Rename(name)
+		}
+		if opts.Rewrite != nil {
+			var buf bytes.Buffer
+			if err := opts.Rewrite(&buf, bytes.NewReader(data)); err != nil {
+				return err
+			}
+			data = buf.Bytes()
+		}
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+		logrus.Debugf("Archived %s", file.Name)
 	}
-	defer tw.Close()
 
 	// walk each path and add encountered file to tar
 	for _, path := range paths {
@@ -49,11 +184,11 @@ func Tar(tarName string, paths ...string) (err error) {
 			logrus.Error(err)
 			continue
 		}
-		if absPath == absTar {
+		if len(absTar) > 0 && absPath == absTar {
 			logrus.Errorf("Tar file %s cannot be the source, skipping path", tarName)
 			continue
 		}
-		if absPath == filepath.Dir(absTar) {
+		if len(absTar) > 0 && absPath == filepath.Dir(absTar) {
 			logrus.Errorf("Tar file %s cannot be in source %s, skipping path", tarName, absPath)
 			continue
 		}
@@ -78,6 +213,12 @@ func Tar(tarName string, paths ...string) (err error) {
 			}
 			// ensure header has relative file path
 			hdr.Name = relFilePath
+			if opts.Rename != nil {
+				hdr.Name = opts.Rename(relFilePath)
+			}
+			if opts.Rewrite != nil && finfo.Mode().IsRegular() {
+				return writeRewritten(tw, hdr, file, opts.Rewrite)
+			}
 			if err := tw.WriteHeader(hdr); err != nil {
 				return err
 			}
@@ -104,3 +245,39 @@ func Tar(tarName string, paths ...string) (err error) {
 	}
 	return nil
 }
+
+// writeRewritten adds the file to the tarball with its content rewritten, staged in a temporary file
+// since the size of the rewritten content must be known before it is written
+func writeRewritten(tw *tar.Writer, hdr *tar.Header, file string, rewrite func(io.Writer, io.Reader) error) error {
+	src, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	staged, err := ioutil.TempFile("", "crashd-rewrite")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(staged.Name())
+	defer staged.Close()
+
+	if err := rewrite(staged, src); err != nil {
+		return fmt.Errorf("%s: %s", file, err)
+	}
+	size, err := staged.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := staged.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hdr.Size = size
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, staged); err != nil {
+		return err
+	}
+	logrus.Debugf("Archived %s", file)
+	return nil
+}
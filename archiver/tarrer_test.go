@@ -0,0 +1,154 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package archiver
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestTarWithOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashd-tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	srcDir := filepath.Join(dir, "workdir")
+	if err := os.MkdirAll(srcDir, 0744); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "kubelet.log"), []byte("kubelet started"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		file   string
+		opts   Options
+		reader func(io.Reader) (io.Reader, error)
+	}{
+		{
+			name:   "gzip from name",
+			file:   "bundle.tar.gz",
+			reader: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		},
+		{
+			name: "zstd from name",
+			file: "bundle.tar.zst",
+			reader: func(r io.Reader) (io.Reader, error) {
+				dec, err := zstd.NewReader(r)
+				return dec, err
+			},
+		},
+		{
+			name: "zstd option",
+			file: "bundle.tar",
+			opts: Options{Compression: CompressionZstd, Workers: 2},
+			reader: func(r io.Reader) (io.Reader, error) {
+				dec, err := zstd.NewReader(r)
+				return dec, err
+			},
+		},
+		{
+			name:   "no compression",
+			file:   "bundle.tar",
+			reader: func(r io.Reader) (io.Reader, error) { return r, nil },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tarName := filepath.Join(dir, test.file)
+			defer os.Remove(tarName)
+			test.opts.Files = []File{{Name: "provenance.yaml", Data: []byte("script: test")}}
+			if err := TarWithOptions(tarName, test.opts, srcDir); err != nil {
+				t.Fatal(err)
+			}
+
+			file, err := os.Open(tarName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			reader, err := test.reader(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			names := make(map[string]bool)
+			tr := tar.NewReader(reader)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				names[filepath.Base(hdr.Name)] = true
+			}
+			if !names["provenance.yaml"] || !names["kubelet.log"] {
+				t.Errorf("unexpected archive content: %v", names)
+			}
+		})
+	}
+
+	if err := TarWithOptions(filepath.Join(dir, "bundle.tar.xz"), Options{Compression: "xz"}, srcDir); err == nil {
+		t.Error("expecting error for unsupported compression")
+	}
+}
+
+func TestTarToStdout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashd-tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	srcDir := filepath.Join(dir, "workdir")
+	if err := os.MkdirAll(srcDir, 0744); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "kubelet.log"), []byte("kubelet started"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	saved := os.Stdout
+	os.Stdout = stdout
+	err = TarWithOptions(Stdout, Options{}, srcDir)
+	os.Stdout = saved
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(Stdout); !os.IsNotExist(err) {
+		t.Errorf("archive written to file %s", Stdout)
+	}
+
+	if _, err := stdout.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := gzip.NewReader(stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(reader)
+	hdr, err := tr.Next()
+	for err == nil && filepath.Base(hdr.Name) != "kubelet.log" {
+		hdr, err = tr.Next()
+	}
+	if err != nil {
+		t.Fatalf("kubelet.log not found in archive: %s", err)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package audit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Actions recorded in the audit trail
+const (
+	ActionSSHRun        = "ssh.run"
+	ActionSCPCopy       = "scp.copy"
+	ActionLocalRun      = "local.run"
+	ActionLocalCopy     = "local.copy"
+	ActionAPIList       = "api.list"
+	ActionAPIGet        = "api.get"
+	ActionAPIProxy      = "api.proxy"
+	ActionKubeletGet    = "kubelet.get"
+	ActionSecretFetch   = "secret.fetch"
+	ActionAssumeRole    = "sts.assume-role"
+	ActionAWSCall       = "aws.call"
+	ActionAzureCall     = "az.call"
+	ActionVSphere       = "vsphere.govc"
+	ActionPolicyRefusal = "policy.refuse"
+	ActionAgentDeploy   = "agent.deploy"
+	ActionAgentExec     = "agent.exec"
+	ActionAgentDelete   = "agent.delete"
+	ActionPortForward   = "api.port-forward"
+	ActionPodExec       = "api.exec"
+	ActionProbeCreate   = "probe.create"
+	ActionProbeDelete   = "probe.delete"
+	ActionLeaseAcquire  = "lease.acquire"
+	ActionLeaseRelease  = "lease.release"
+)
+
+var (
+	mu     sync.Mutex
+	output io.Writer
+	// file is the path of the audit file opened by the next entry (see SetFile)
+	file string
+
+	localUser = func() string {
+		if usr, err := user.Current(); err == nil {
+			return usr.Username
+		}
+		return os.Getenv("USER")
+	}()
+)
+
+// SetOutput sets where audit entries are written. A previous output
+// that implements io.Closer is closed. A nil writer disables the audit trail.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	file = ""
+	setOutput(w)
+}
+
+func setOutput(w io.Writer) {
+	if closer, ok := output.(io.Closer); ok && output != w {
+		closer.Close()
+	}
+	output = w
+}
+
+// SetFile uses the audit file at path as output. The file is opened, in append mode, by the next entry:
+// no file is created when the path changes before any action is recorded (i.e. when a script declares its
+// working directory after the default one was set).
+func SetFile(path string) {
+	mu.Lock()
+	defer mu.Unlock()
+	setOutput(nil)
+	file = path
+}
+
+// LocalUser returns the name of the user running crashd
+func LocalUser() string {
+	return localUser
+}
+
+// Record writes an audit entry for an action with its host, user, detail (i.e. the
+// command or path), and status. Entries are single lines formatted as:
+// <timestamp> host=<host> user=<user> action=<action> status=<ok|failed> detail="<detail>" [error="<error>"]
+func Record(host, user, action, detail string, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if output == nil && len(file) > 0 {
+		f, openErr := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if openErr != nil {
+			logrus.Errorf("audit: %s", openErr)
+		} else {
+			output = f
+		}
+		file = ""
+	}
+	if output == nil {
+		return
+	}
+
+	status := "ok"
+	if err != nil {
+		status = "failed"
+	}
+	entry := fmt.Sprintf("%s host=%s user=%s action=%s status=%s detail=%q",
+		time.Now().UTC().Format(time.RFC3339), host, user, action, status, detail)
+	if err != nil {
+		entry = fmt.Sprintf("%s error=%q", entry, err.Error())
+	}
+	fmt.Fprintln(output, entry)
+}
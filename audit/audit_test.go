@@ -0,0 +1,66 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecord(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(nil)
+
+	Record("10.0.0.1", "capv", ActionSSHRun, `sudo df -i`, nil)
+	Record("10.0.0.2", "capv", ActionSCPCopy, "/var/log/syslog", errors.New("exit status 1"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expecting 2 entries, got %d", len(lines))
+	}
+	if !strings.HasSuffix(lines[0], `host=10.0.0.1 user=capv action=ssh.run status=ok detail="sudo df -i"`) {
+		t.Errorf("unexpected entry: %s", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], `action=scp.copy status=failed detail="/var/log/syslog" error="exit status 1"`) {
+		t.Errorf("unexpected entry: %s", lines[1])
+	}
+}
+
+func TestRecordDisabled(t *testing.T) {
+	SetOutput(nil)
+	// must not panic
+	Record("host", "user", ActionLocalRun, "ls", nil)
+}
+
+func TestSetFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashd-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetOutput(nil)
+
+	// the file of a path replaced before any entry is not created
+	SetFile(filepath.Join(dir, "default.log"))
+	SetFile(filepath.Join(dir, "audit.log"))
+	Record("localhost", "ops", ActionLocalRun, "ls", nil)
+	Record("localhost", "ops", ActionLocalRun, "df", nil)
+
+	if _, err := os.Stat(filepath.Join(dir, "default.log")); !os.IsNotExist(err) {
+		t.Errorf("expecting no default audit file, got %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 {
+		t.Errorf("expecting 2 entries, got %q", data)
+	}
+}
@@ -0,0 +1,6 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package audit records an append-only trail of the commands executed, the files
+// copied, and the API requests issued during a crashd run.
+package audit
@@ -0,0 +1,200 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vladimirvivien/echo"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+	"github.com/vmware-tanzu/crash-diagnostics/proc"
+)
+
+// DefaultSessionName is the session name used when assuming a role
+const DefaultSessionName = "crashd"
+
+// static credential variables, which take precedence over a profile with the aws CLI
+var credentialVars = []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"}
+
+var (
+	// roleCredentials are the credentials of the assumed roles, by configuration, reused until they expire
+	roleCredentials = struct {
+		sync.Mutex
+		creds map[Config]Credentials
+	}{creds: map[Config]Credentials{}}
+
+	// runAWS runs the aws CLI with args, and the variables of env (NAME=value) added to its environment,
+	// and returns its output
+	runAWS = func(env []string, args ...string) (string, error) {
+		prog := echo.New().Prog.Avail("aws")
+		if len(prog) == 0 {
+			return "", fmt.Errorf("aws program not found")
+		}
+		result, err := proc.RunArgs(env, prog, args...)
+		if err == nil {
+			err = result.Err()
+		}
+		if err != nil {
+			return "", err
+		}
+		return result.Stdout, nil
+	}
+)
+
+// Config selects the AWS credentials to use
+type Config struct {
+	// Profile is a named profile of the AWS config files (static keys, SSO, or credential_process)
+	Profile string
+	// Region overrides the region of the profile
+	Region string
+	// RoleARN is a role assumed, with the profile credentials, before calling AWS
+	RoleARN string
+	// ExternalID is passed when assuming RoleARN
+	ExternalID string
+	// SessionName is the session name of the assumed role (DefaultSessionName if empty)
+	SessionName string
+	// DurationSeconds is the lifetime of the assumed role credentials (the role default if 0)
+	DurationSeconds int
+}
+
+// Validate returns an error when the configuration is inconsistent
+func (c Config) Validate() error {
+	for name, val := range map[string]string{
+		"profile": c.Profile, "region": c.Region, "role_arn": c.RoleARN,
+		"external_id": c.ExternalID, "session_name": c.SessionName,
+	} {
+		if strings.ContainsAny(val, `'"$`+"` \t\n") {
+			return fmt.Errorf("invalid %s %q", name, val)
+		}
+	}
+	if len(c.RoleARN) > 0 && !strings.HasPrefix(c.RoleARN, "arn:") {
+		return fmt.Errorf("invalid role_arn %q", c.RoleARN)
+	}
+	if len(c.RoleARN) == 0 && (len(c.ExternalID) > 0 || len(c.SessionName) > 0 || c.DurationSeconds != 0) {
+		return fmt.Errorf("external_id, session_name, and duration require role_arn")
+	}
+	if c.DurationSeconds < 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	return nil
+}
+
+// Env returns the variables (NAME=value) selecting the configured credentials, to be added to the
+// environment of the programs using them. A profile overrides the static credential variables of the
+// environment; an assumed role adds the temporary credentials of the role, assumed with the profile (or
+// environment) credentials. The environment of crashd is not changed.
+func (c Config) Env() ([]string, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	env := c.profileEnv()
+	if len(c.RoleARN) == 0 {
+		return env, nil
+	}
+	creds, err := c.roleCredentials()
+	if err != nil {
+		return nil, err
+	}
+	return append(env,
+		"AWS_ACCESS_KEY_ID="+creds.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY="+creds.SecretAccessKey,
+		"AWS_SESSION_TOKEN="+creds.SessionToken,
+	), nil
+}
+
+// profileEnv returns the variables of the region and profile of the configuration
+func (c Config) profileEnv() []string {
+	var env []string
+	if len(c.Region) > 0 {
+		env = append(env, "AWS_REGION="+c.Region, "AWS_DEFAULT_REGION="+c.Region)
+	}
+	if len(c.Profile) > 0 {
+		for _, name := range credentialVars {
+			env = append(env, name+"=")
+		}
+		env = append(env, "AWS_PROFILE="+c.Profile)
+	}
+	return env
+}
+
+// roleCredentials returns the credentials of the assumed role, assuming it when they are missing or about to expire
+func (c Config) roleCredentials() (Credentials, error) {
+	roleCredentials.Lock()
+	defer roleCredentials.Unlock()
+	if creds, ok := roleCredentials.creds[c]; ok && time.Until(creds.Expiration) > time.Minute {
+		return creds, nil
+	}
+	creds, err := c.assumeRole()
+	if err != nil {
+		return Credentials{}, err
+	}
+	logrus.Debugf("aws: assumed role %s, credentials expire at %s", c.RoleARN, creds.Expiration)
+	roleCredentials.creds[c] = creds
+	return creds, nil
+}
+
+// Credentials are temporary credentials returned by STS
+type Credentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	SessionToken    string    `json:"SessionToken"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// assumeRole assumes the role with the credentials of the profile, or of the environment
+func (c Config) assumeRole() (Credentials, error) {
+	sessionName := c.SessionName
+	if len(sessionName) == 0 {
+		sessionName = DefaultSessionName
+	}
+	args := []string{"sts", "assume-role", "--role-arn", c.RoleARN, "--role-session-name", sessionName, "--output", "json"}
+	if len(c.ExternalID) > 0 {
+		args = append(args, "--external-id", c.ExternalID)
+	}
+	if c.DurationSeconds > 0 {
+		args = append(args, "--duration-seconds", strconv.Itoa(c.DurationSeconds))
+	}
+
+	output, err := runAWS(c.profileEnv(), args...)
+	audit.Record(stsHost(c.Region), audit.LocalUser(), audit.ActionAssumeRole, c.RoleARN, err)
+	if err != nil {
+		if len(c.Profile) > 0 && strings.Contains(strings.ToLower(err.Error()), "sso") {
+			return Credentials{}, fmt.Errorf("assume role %s: %s (run 'aws sso login --profile %s')", c.RoleARN, err, c.Profile)
+		}
+		return Credentials{}, fmt.Errorf("assume role %s: %s", c.RoleARN, err)
+	}
+	return parseAssumeRoleOutput(output)
+}
+
+func parseAssumeRoleOutput(output string) (Credentials, error) {
+	var result struct {
+		Credentials Credentials `json:"Credentials"`
+	}
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		return Credentials{}, fmt.Errorf("invalid assume-role output: %s", err)
+	}
+	if len(result.Credentials.AccessKeyID) == 0 || len(result.Credentials.SecretAccessKey) == 0 {
+		return Credentials{}, fmt.Errorf("assume-role output has no credentials")
+	}
+	return result.Credentials, nil
+}
+
+func stsHost(region string) string {
+	return serviceHost("sts", region)
+}
+
+// serviceHost returns the endpoint host of an AWS service, recorded in the audit trail
+func serviceHost(service, region string) string {
+	if len(region) > 0 {
+		return fmt.Sprintf("%s.%s.amazonaws.com", service, region)
+	}
+	return fmt.Sprintf("%s.amazonaws.com", service)
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package aws
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       Config
+		shouldErr bool
+	}{
+		{name: "profile", cfg: Config{Profile: "prod-sso", Region: "us-west-2"}},
+		{name: "role", cfg: Config{Profile: "prod", RoleARN: "arn:aws:iam::123456789012:role/crashd", ExternalID: "abc"}},
+		{name: "bad role", cfg: Config{RoleARN: "crashd"}, shouldErr: true},
+		{name: "external id without role", cfg: Config{ExternalID: "abc"}, shouldErr: true},
+		{name: "quoted profile", cfg: Config{Profile: "prod' ; rm -rf /"}, shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.cfg.Validate()
+			if test.shouldErr && err == nil {
+				t.Fatal("expecting error")
+			}
+			if !test.shouldErr && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestEnv(t *testing.T) {
+	defer func(run func([]string, ...string) (string, error)) { runAWS = run }(runAWS)
+	defer os.Setenv("AWS_ACCESS_KEY_ID", os.Getenv("AWS_ACCESS_KEY_ID"))
+
+	var calls [][]string
+	var callEnv []string
+	runAWS = func(env []string, args ...string) (string, error) {
+		calls = append(calls, args)
+		callEnv = env
+		expiration := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		return `{"Credentials": {"AccessKeyId": "ASIAEXAMPLE", "SecretAccessKey": "secret", "SessionToken": "token", "Expiration": "` + expiration + `"}}`, nil
+	}
+
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKIASTATIC")
+	env, err := Config{Profile: "prod", Region: "eu-west-1"}.Env()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "AWS_REGION=eu-west-1 AWS_DEFAULT_REGION=eu-west-1 AWS_ACCESS_KEY_ID= AWS_SECRET_ACCESS_KEY= AWS_SESSION_TOKEN= AWS_PROFILE=prod"
+	if strings.Join(env, " ") != expected {
+		t.Errorf("unexpected profile environment: %v", env)
+	}
+	if len(calls) > 0 {
+		t.Errorf("unexpected aws calls: %v", calls)
+	}
+
+	roleCfg := Config{Profile: "prod", RoleARN: "arn:aws:iam::123456789012:role/crashd", ExternalID: "ext-123", DurationSeconds: 900}
+	env, err = roleCfg.Env()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 {
+		t.Fatalf("expecting the role to be assumed once, got %v", calls)
+	}
+	expected = "sts assume-role --role-arn arn:aws:iam::123456789012:role/crashd --role-session-name crashd --output json --external-id ext-123 --duration-seconds 900"
+	if strings.Join(calls[0], " ") != expected {
+		t.Errorf("unexpected assume-role call: %v", calls[0])
+	}
+	// the role is assumed with the credentials of the profile only
+	if strings.Join(callEnv, " ") != "AWS_ACCESS_KEY_ID= AWS_SECRET_ACCESS_KEY= AWS_SESSION_TOKEN= AWS_PROFILE=prod" {
+		t.Errorf("unexpected assume-role environment: %v", callEnv)
+	}
+	if !strings.Contains(strings.Join(env, " "), "AWS_ACCESS_KEY_ID=ASIAEXAMPLE AWS_SECRET_ACCESS_KEY=secret AWS_SESSION_TOKEN=token") {
+		t.Errorf("assumed role credentials missing: %v", env)
+	}
+	if os.Getenv("AWS_ACCESS_KEY_ID") != "AKIASTATIC" {
+		t.Errorf("expecting the process environment unchanged, got AWS_ACCESS_KEY_ID=%s", os.Getenv("AWS_ACCESS_KEY_ID"))
+	}
+
+	// the credentials of the role are reused until they expire
+	if _, err := roleCfg.Env(); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 {
+		t.Errorf("expecting the credentials of the role to be reused, got %v", calls)
+	}
+}
+
+func TestParseAssumeRoleOutput(t *testing.T) {
+	if _, err := parseAssumeRoleOutput(`{"Credentials": {}}`); err == nil {
+		t.Error("expecting error for missing credentials")
+	}
+	if _, err := parseAssumeRoleOutput(`not json`); err == nil {
+		t.Error("expecting error for invalid output")
+	}
+}
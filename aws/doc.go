@@ -0,0 +1,9 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package aws configures the AWS credentials used by crashd and the programs it starts
+// (aws CLI, kubectl, and kubeconfig exec plugins such as aws-iam-authenticator). Named
+// profiles, including SSO profiles, and assumed roles (with an optional external ID) are
+// supported. The configuration is only passed to the environment of the programs started with it,
+// the environment of crashd is not changed.
+package aws
@@ -0,0 +1,100 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+)
+
+var instanceIDPattern = regexp.MustCompile(`^i-[0-9a-f]{8,17}$`)
+
+// InstanceID returns the EC2 instance ID of id, either an instance ID or the provider ID
+// of a node (i.e. aws:///us-west-2a/i-0123456789abcdef0)
+func InstanceID(id string) (string, error) {
+	instance := id[strings.LastIndex(id, "/")+1:]
+	if !instanceIDPattern.MatchString(instance) {
+		return "", fmt.Errorf("invalid instance id %q", id)
+	}
+	return instance, nil
+}
+
+// InstanceStatus is the state and status checks of an EC2 instance
+type InstanceStatus struct {
+	State          string
+	SystemStatus   string
+	InstanceStatus string
+}
+
+// call runs an aws CLI command of service, with the credentials of the configuration, and records it in
+// the audit trail
+func (c Config) call(service string, args ...string) (string, error) {
+	if len(c.Region) > 0 {
+		args = append(args, "--region", c.Region)
+	}
+	env, err := c.Env()
+	output := ""
+	if err == nil {
+		output, err = runAWS(env, append([]string{service}, args...)...)
+	}
+	region := c.Region
+	if len(region) == 0 {
+		region = os.Getenv("AWS_REGION")
+	}
+	audit.Record(serviceHost(service, region), audit.LocalUser(), audit.ActionAWSCall, strings.Join(append([]string{service}, args...), " "), err)
+	return output, err
+}
+
+// DescribeInstanceStatus returns the describe-instance-status JSON document of an instance,
+// including stopped instances
+func (c Config) DescribeInstanceStatus(instance string) (string, error) {
+	return c.call("ec2", "describe-instance-status", "--include-all-instances", "--instance-ids", instance, "--output", "json")
+}
+
+// ConsoleOutput returns the system console output of an instance
+func (c Config) ConsoleOutput(instance string) (string, error) {
+	return c.call("ec2", "get-console-output", "--instance-id", instance, "--query", "Output", "--output", "text")
+}
+
+// CloudTrailEvents returns the lookup-events JSON document of the last count CloudTrail events
+// of an instance
+func (c Config) CloudTrailEvents(instance string, count int) (string, error) {
+	return c.call("cloudtrail", "lookup-events", "--lookup-attributes", "AttributeKey=ResourceName,AttributeValue="+instance,
+		"--max-results", strconv.Itoa(count), "--output", "json")
+}
+
+// ParseInstanceStatus returns the state and status checks of a describe-instance-status document
+func ParseInstanceStatus(output string) (InstanceStatus, error) {
+	var result struct {
+		InstanceStatuses []struct {
+			InstanceState struct {
+				Name string `json:"Name"`
+			} `json:"InstanceState"`
+			SystemStatus struct {
+				Status string `json:"Status"`
+			} `json:"SystemStatus"`
+			InstanceStatus struct {
+				Status string `json:"Status"`
+			} `json:"InstanceStatus"`
+		} `json:"InstanceStatuses"`
+	}
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		return InstanceStatus{}, fmt.Errorf("invalid describe-instance-status output: %s", err)
+	}
+	if len(result.InstanceStatuses) == 0 {
+		return InstanceStatus{}, fmt.Errorf("instance not found")
+	}
+	status := result.InstanceStatuses[0]
+	return InstanceStatus{
+		State:          status.InstanceState.Name,
+		SystemStatus:   status.SystemStatus.Status,
+		InstanceStatus: status.InstanceStatus.Status,
+	}, nil
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package aws
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInstanceID(t *testing.T) {
+	tests := []struct {
+		name      string
+		id        string
+		expected  string
+		shouldErr bool
+	}{
+		{name: "instance id", id: "i-0123456789abcdef0", expected: "i-0123456789abcdef0"},
+		{name: "provider id", id: "aws:///us-west-2a/i-0123456789abcdef0", expected: "i-0123456789abcdef0"},
+		{name: "node name", id: "ip-10-0-1-12.ec2.internal", shouldErr: true},
+		{name: "injection", id: "i-01234567; rm -rf /", shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id, err := InstanceID(test.id)
+			if err != nil {
+				if !test.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if test.shouldErr {
+				t.Fatal("expecting error, got none")
+			}
+			if id != test.expected {
+				t.Errorf("expecting %s, got %s", test.expected, id)
+			}
+		})
+	}
+}
+
+func TestEC2Calls(t *testing.T) {
+	defer func(run func([]string, ...string) (string, error)) { runAWS = run }(runAWS)
+
+	var calls []string
+	runAWS = func(env []string, args ...string) (string, error) {
+		calls = append(calls, strings.Join(args, " "))
+		return `{"InstanceStatuses":[{"InstanceState":{"Name":"running"},"SystemStatus":{"Status":"ok"},"InstanceStatus":{"Status":"impaired"}}]}`, nil
+	}
+
+	output, err := Config{Region: "us-west-2"}.DescribeInstanceStatus("i-0123456789abcdef0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := ParseInstanceStatus(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != (InstanceStatus{State: "running", SystemStatus: "ok", InstanceStatus: "impaired"}) {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	Config{}.CloudTrailEvents("i-0123456789abcdef0", 20)
+
+	expected := []string{
+		"ec2 describe-instance-status --include-all-instances --instance-ids i-0123456789abcdef0 --output json --region us-west-2",
+		"cloudtrail lookup-events --lookup-attributes AttributeKey=ResourceName,AttributeValue=i-0123456789abcdef0 --max-results 20 --output json",
+	}
+	if len(calls) != len(expected) {
+		t.Fatalf("expecting %d calls, got %v", len(expected), calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Errorf("expecting %q, got %q", expected[i], calls[i])
+		}
+	}
+
+	if _, err := ParseInstanceStatus(`{"InstanceStatuses":[]}`); err == nil {
+		t.Error("expecting error for a missing instance")
+	}
+}
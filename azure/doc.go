@@ -0,0 +1,7 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package azure collects the infrastructure-level state of Azure virtual machines (instance view,
+// boot diagnostics serial log and screenshot) with the az CLI, using the credentials of its
+// current login (az login) or of the AZURE_* environment variables it reads.
+package azure
@@ -0,0 +1,174 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package azure
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/vladimirvivien/echo"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+)
+
+// managementHost is the Azure Resource Manager endpoint, recorded in the audit trail
+const managementHost = "management.azure.com"
+
+var (
+	// runAZ runs the az CLI with args and returns its output
+	runAZ = func(args string) (string, error) {
+		e := echo.New()
+		prog := e.Prog.Avail("az")
+		if len(prog) == 0 {
+			return "", fmt.Errorf("az program not found")
+		}
+		proc := e.RunProc(fmt.Sprintf("%s %s", prog, args))
+		if proc.Err() != nil {
+			return "", fmt.Errorf("%s: %s", proc.Err(), proc.Result())
+		}
+		return proc.Result(), nil
+	}
+
+	vmIDPattern   = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.Compute/virtualMachines/[^/]+$`)
+	namePattern   = regexp.MustCompile(`^[A-Za-z0-9._()-]+$`)
+	vmssIDPattern = regexp.MustCompile(`(?i)/virtualMachineScaleSets/`)
+)
+
+// VM identifies an Azure virtual machine, by resource ID or by resource group and name
+type VM struct {
+	ID            string
+	ResourceGroup string
+	Name          string
+}
+
+// ParseVM returns the VM of id, either the resource ID of a VM or the provider ID of a node
+// (i.e. azure:///subscriptions/<id>/resourceGroups/<group>/providers/Microsoft.Compute/virtualMachines/<name>).
+// Scale set instances are not supported.
+func ParseVM(id string) (VM, error) {
+	resourceID := strings.TrimPrefix(id, "azure://")
+	if vmssIDPattern.MatchString(resourceID) {
+		return VM{}, fmt.Errorf("scale set instance %q not supported", id)
+	}
+	if !vmIDPattern.MatchString(resourceID) || strings.ContainsAny(resourceID, `'"$`+"` \t\n") {
+		return VM{}, fmt.Errorf("invalid vm id %q", id)
+	}
+	return VM{ID: resourceID, Name: resourceID[strings.LastIndex(resourceID, "/")+1:]}, nil
+}
+
+// NewVM returns the VM named name in a resource group
+func NewVM(resourceGroup, name string) (VM, error) {
+	if !namePattern.MatchString(resourceGroup) || !namePattern.MatchString(name) {
+		return VM{}, fmt.Errorf("invalid vm %q in resource group %q", name, resourceGroup)
+	}
+	return VM{ResourceGroup: resourceGroup, Name: name}, nil
+}
+
+// args returns the az arguments selecting the VM
+func (vm VM) args() string {
+	if len(vm.ID) > 0 {
+		return "--ids " + vm.ID
+	}
+	return fmt.Sprintf("--resource-group %s --name %s", vm.ResourceGroup, vm.Name)
+}
+
+// call runs an az CLI command and records it in the audit trail
+func call(args string) (string, error) {
+	output, err := runAZ(args)
+	audit.Record(managementHost, audit.LocalUser(), audit.ActionAzureCall, args, err)
+	return output, err
+}
+
+// GetInstanceView returns the instance view JSON document of the VM
+func GetInstanceView(vm VM) (string, error) {
+	return call(fmt.Sprintf("vm get-instance-view %s --output json", vm.args()))
+}
+
+// GetBootLog returns the serial console log of the VM boot diagnostics
+func GetBootLog(vm VM) (string, error) {
+	return call(fmt.Sprintf("vm boot-diagnostics get-boot-log %s", vm.args()))
+}
+
+// SaveScreenshot saves the console screenshot of the VM boot diagnostics at path
+func SaveScreenshot(vm VM, path string) error {
+	output, err := call(fmt.Sprintf("vm boot-diagnostics get-boot-log-uris %s --output json", vm.args()))
+	if err != nil {
+		return err
+	}
+	var uris struct {
+		ConsoleScreenshotBlobURI string `json:"consoleScreenshotBlobUri"`
+	}
+	if err := json.Unmarshal([]byte(output), &uris); err != nil {
+		return fmt.Errorf("invalid get-boot-log-uris output: %s", err)
+	}
+	if len(uris.ConsoleScreenshotBlobURI) == 0 {
+		return fmt.Errorf("boot diagnostics screenshot not available")
+	}
+
+	resp, err := http.Get(uris.ConsoleScreenshotBlobURI)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("screenshot download: %s", resp.Status)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(file, resp.Body)
+	return err
+}
+
+// InstanceView is the state of a VM summarized from its instance view
+type InstanceView struct {
+	ProvisioningState string
+	PowerState        string
+	// Extensions are the VM extensions as name: status
+	Extensions []string
+}
+
+// ParseInstanceView summarizes an instance view document of `az vm get-instance-view`
+func ParseInstanceView(output string) (InstanceView, error) {
+	type status struct {
+		Code          string `json:"code"`
+		DisplayStatus string `json:"displayStatus"`
+	}
+	var doc struct {
+		InstanceView struct {
+			Statuses   []status `json:"statuses"`
+			Extensions []struct {
+				Name     string   `json:"name"`
+				Statuses []status `json:"statuses"`
+			} `json:"extensions"`
+		} `json:"instanceView"`
+	}
+	if err := json.Unmarshal([]byte(output), &doc); err != nil {
+		return InstanceView{}, fmt.Errorf("invalid instance view: %s", err)
+	}
+
+	var view InstanceView
+	for _, s := range doc.InstanceView.Statuses {
+		switch {
+		case strings.HasPrefix(s.Code, "ProvisioningState/"):
+			view.ProvisioningState = strings.TrimPrefix(s.Code, "ProvisioningState/")
+		case strings.HasPrefix(s.Code, "PowerState/"):
+			view.PowerState = strings.TrimPrefix(s.Code, "PowerState/")
+		}
+	}
+	for _, ext := range doc.InstanceView.Extensions {
+		state := "unknown"
+		if len(ext.Statuses) > 0 {
+			state = ext.Statuses[0].DisplayStatus
+		}
+		view.Extensions = append(view.Extensions, fmt.Sprintf("%s: %s", ext.Name, state))
+	}
+	return view, nil
+}
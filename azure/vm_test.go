@@ -0,0 +1,82 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package azure
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVM(t *testing.T) {
+	const id = "/subscriptions/0000/resourceGroups/prod/providers/Microsoft.Compute/virtualMachines/prod-md-0-a"
+	tests := []struct {
+		name      string
+		id        string
+		expected  VM
+		shouldErr bool
+	}{
+		{name: "resource id", id: id, expected: VM{ID: id, Name: "prod-md-0-a"}},
+		{name: "provider id", id: "azure://" + id, expected: VM{ID: id, Name: "prod-md-0-a"}},
+		{name: "scale set instance", id: "azure:///subscriptions/0000/resourceGroups/prod/providers/Microsoft.Compute/virtualMachineScaleSets/pool/virtualMachines/0", shouldErr: true},
+		{name: "node name", id: "prod-md-0-a", shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vm, err := ParseVM(test.id)
+			if err != nil {
+				if !test.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if test.shouldErr {
+				t.Fatal("expecting error, got none")
+			}
+			if vm != test.expected {
+				t.Errorf("expecting %+v, got %+v", test.expected, vm)
+			}
+		})
+	}
+}
+
+func TestInstanceView(t *testing.T) {
+	defer func(run func(string) (string, error)) { runAZ = run }(runAZ)
+
+	var called string
+	runAZ = func(args string) (string, error) {
+		called = args
+		return `{"instanceView": {
+			"statuses": [{"code": "ProvisioningState/failed/VMExtensionProvisioningError"}, {"code": "PowerState/running"}],
+			"extensions": [{"name": "CAPZ.Linux.Bootstrapping", "statuses": [{"displayStatus": "Provisioning failed"}]}]
+		}}`, nil
+	}
+
+	vm, err := NewVM("prod", "prod-md-0-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := GetInstanceView(vm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if called != "vm get-instance-view --resource-group prod --name prod-md-0-a --output json" {
+		t.Errorf("unexpected call %q", called)
+	}
+	view, err := ParseInstanceView(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := InstanceView{
+		ProvisioningState: "failed/VMExtensionProvisioningError",
+		PowerState:        "running",
+		Extensions:        []string{"CAPZ.Linux.Bootstrapping: Provisioning failed"},
+	}
+	if !reflect.DeepEqual(view, expected) {
+		t.Errorf("expecting %+v, got %+v", expected, view)
+	}
+
+	if _, err := NewVM("prod", "vm; rm -rf /"); err == nil {
+		t.Error("expecting error for an invalid name")
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bundle
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// MaxContentSize is the size of the largest file whose content is kept in memory.
+// Larger files are only compared by checksum.
+const MaxContentSize = 1024 * 1024
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// File is a file of a bundle
+type File struct {
+	// Name is the path of the file relative to the root of the bundle
+	Name string
+	Size int64
+	// Sum is the SHA-256 checksum of the file
+	Sum string
+	// Data is the content of the file, nil for files larger than MaxContentSize
+	Data []byte
+}
+
+// Bundle is the content of a diagnostics bundle
+type Bundle struct {
+	// Path is the archive or directory the bundle was read from
+	Path  string
+	Files map[string]*File
+}
+
+// Names returns the names of the files of the bundle, sorted
+func (b *Bundle) Names() []string {
+	names := make([]string, 0, len(b.Files))
+	for name := range b.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ErrStopWalk is returned by a WalkFunc to stop walking the bundle without error
+var ErrStopWalk = errors.New("stop walking bundle")
+
+// WalkFunc is called for each file of a bundle with the file name, relative to the
+// working directory of the script that created the bundle, its size, and its content
+type WalkFunc func(name string, size int64, r io.Reader) error
+
+// Walk calls fn for each regular file of the bundle at path, a tarball (compressed with gzip or
+// zstd, or not compressed) or a working directory. Archives are read as a stream, without
+// extracting them. File names are relative to the working directory of the script that
+// created the bundle; files at the root of the archive, such as provenance.yaml, are kept as is.
+func Walk(path string, fn WalkFunc) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		err = walkDir(path, fn)
+	} else {
+		err = walkArchive(path, fn)
+	}
+	if err == ErrStopWalk {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("bundle %s: %s", path, err)
+	}
+	return nil
+}
+
+// Open reads the bundle at path (see Walk). The content of files up to MaxContentSize
+// is kept in memory, so that bundles created in different directories can be compared.
+func Open(path string) (*Bundle, error) {
+	b := &Bundle{Path: path, Files: make(map[string]*File)}
+	if err := Walk(path, func(name string, size int64, r io.Reader) error {
+		return addFile(b, name, size, r)
+	}); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func walkDir(root string, fn WalkFunc) error {
+	return filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return fn(filepath.ToSlash(rel), info.Size(), f)
+	})
+}
+
+func walkArchive(archive string, fn WalkFunc) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := decompress(bufio.NewReader(file))
+	if err != nil {
+		return err
+	}
+
+	// the first directory of the archive is the working directory archived by the script,
+	// it precedes the files it contains
+	prefix := ""
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(hdr.Name)
+		if hdr.Typeflag == tar.TypeDir && len(prefix) == 0 && name != "." {
+			prefix = name + "/"
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if err := fn(strings.TrimPrefix(name, prefix), hdr.Size, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// decompress returns a reader of the tarball, detecting the compression from its first bytes
+func decompress(r *bufio.Reader) (io.Reader, error) {
+	magic, err := r.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(r)
+	case bytes.HasPrefix(magic, zstdMagic):
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return r, nil
+	}
+}
+
+func addFile(b *Bundle, name string, size int64, r io.Reader) error {
+	hash := sha256.New()
+	var data bytes.Buffer
+	var w io.Writer = hash
+	if size <= MaxContentSize {
+		w = io.MultiWriter(hash, &data)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+
+	file := &File{Name: name, Size: size, Sum: hex.EncodeToString(hash.Sum(nil))}
+	if size <= MaxContentSize {
+		file.Data = data.Bytes()
+	}
+	b.Files[name] = file
+	return nil
+}
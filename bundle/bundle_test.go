@@ -0,0 +1,64 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bundle
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vmware-tanzu/crash-diagnostics/archiver"
+)
+
+// makeBundle writes files in a working directory named workdir and returns the archive of it
+func makeBundle(t *testing.T, root, workdir, archive string, files map[string]string) string {
+	dir := filepath.Join(root, workdir)
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0744); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tarName := filepath.Join(root, archive)
+	if err := archiver.TarWithFiles(tarName, []archiver.File{{Name: "provenance.yaml", Data: []byte(workdir)}}, dir); err != nil {
+		t.Fatal(err)
+	}
+	return tarName
+}
+
+func TestOpen(t *testing.T) {
+	root, err := ioutil.TempDir("", "crashd-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	files := map[string]string{"10_0_0_1/df.txt": "/dev/sda1 80%", "kubecapture/pods.json": podsBefore}
+	for _, archive := range []string{"bundle.tar", "bundle.tar.gz", "bundle.tar.zst"} {
+		b, err := Open(makeBundle(t, root, "crashd", archive, files))
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := strings.Join(b.Names(), ",")
+		if names != "10_0_0_1/df.txt,kubecapture/pods.json,provenance.yaml" {
+			t.Errorf("%s: unexpected files: %s", archive, names)
+		}
+		if string(b.Files["10_0_0_1/df.txt"].Data) != "/dev/sda1 80%" {
+			t.Errorf("%s: unexpected content", archive)
+		}
+	}
+
+	b, err := Open(filepath.Join(root, "crashd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := strings.Join(b.Names(), ","); names != "10_0_0_1/df.txt,kubecapture/pods.json" {
+		t.Errorf("unexpected files in directory bundle: %s", names)
+	}
+}
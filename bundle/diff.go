@@ -0,0 +1,303 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bundle
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Kinds of changes
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// volatileFiles are recreated by every run and are not compared
+var volatileFiles = map[string]bool{
+	"timings.txt":     true,
+	"index.json":      true,
+	"audit.log":       true,
+	"provenance.yaml": true,
+}
+
+// volatileFields are object fields updated by the API server on every write,
+// which are not compared
+var volatileFields = []string{
+	"metadata.resourceVersion",
+	"metadata.managedFields",
+	"metadata.generation",
+	"metadata.annotations.kubectl.kubernetes.io/last-applied-configuration",
+}
+
+// ObjectChange is an API object added, removed, or changed between two manifests
+type ObjectChange struct {
+	// Object identifies the object as kind namespace/name
+	Object string `json:"object"`
+	Change string `json:"change"`
+	// Fields are the paths of the changed fields (i.e. spec.replicas)
+	Fields []string `json:"fields,omitempty"`
+}
+
+// FileChange is a file added, removed, or changed between two bundles
+type FileChange struct {
+	Name   string `json:"name"`
+	Change string `json:"change"`
+	// AddedLines and RemovedLines count the lines that differ in changed text files
+	AddedLines   int `json:"addedLines,omitempty"`
+	RemovedLines int `json:"removedLines,omitempty"`
+	// Objects are the object changes of changed manifests (JSON or YAML files of API objects)
+	Objects []ObjectChange `json:"objects,omitempty"`
+}
+
+// Report is the difference between two bundles
+type Report struct {
+	From    string       `json:"from"`
+	To      string       `json:"to"`
+	Changes []FileChange `json:"changes"`
+}
+
+// Diff compares bundle a (before) with bundle b (after). Files matching any of
+// the exclude globs, matched against the file path and the file name, are ignored.
+func Diff(a, b *Bundle, excludes []string) (Report, error) {
+	for _, exclude := range excludes {
+		if _, err := path.Match(exclude, ""); err != nil {
+			return Report{}, fmt.Errorf("invalid exclude pattern %s: %s", exclude, err)
+		}
+	}
+	ignored := func(name string) bool {
+		return volatileFiles[name] || (len(excludes) > 0 && matchesAny(name, excludes))
+	}
+
+	report := Report{From: a.Path, To: b.Path}
+	for _, name := range a.Names() {
+		if ignored(name) {
+			continue
+		}
+		before := a.Files[name]
+		after, ok := b.Files[name]
+		if !ok {
+			report.Changes = append(report.Changes, FileChange{Name: name, Change: Removed})
+			continue
+		}
+		if before.Sum == after.Sum {
+			continue
+		}
+		report.Changes = append(report.Changes, diffFile(before, after))
+	}
+	for _, name := range b.Names() {
+		if ignored(name) {
+			continue
+		}
+		if _, ok := a.Files[name]; !ok {
+			report.Changes = append(report.Changes, FileChange{Name: name, Change: Added})
+		}
+	}
+	sort.SliceStable(report.Changes, func(i, j int) bool {
+		return report.Changes[i].Name < report.Changes[j].Name
+	})
+	return report, nil
+}
+
+// diffFile compares the content of a changed file
+func diffFile(before, after *File) FileChange {
+	change := FileChange{Name: before.Name, Change: Changed}
+	if before.Data == nil || after.Data == nil {
+		return change
+	}
+
+	objsBefore, okBefore := ParseObjects(before.Name, before.Data)
+	objsAfter, okAfter := ParseObjects(after.Name, after.Data)
+	if okBefore && okAfter {
+		change.Objects = diffObjects(objsBefore, objsAfter)
+		return change
+	}
+
+	if utf8.Valid(before.Data) && utf8.Valid(after.Data) {
+		change.AddedLines, change.RemovedLines = diffLines(before.Data, after.Data)
+	}
+	return change
+}
+
+// ParseObjects returns the API objects of a JSON or YAML manifest, keyed by kind namespace/name,
+// and false when the file is not a manifest.
+// Lists (i.e. the PodList files of kube_capture) are expanded to their items.
+func ParseObjects(name string, data []byte) (map[string]map[string]interface{}, bool) {
+	if !isManifest(name) {
+		return nil, false
+	}
+	ext := path.Ext(name)
+
+	var docs []map[string]interface{}
+	if ext == ".json" {
+		var obj map[string]interface{}
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return nil, false
+		}
+		docs = append(docs, obj)
+	} else {
+		for _, doc := range bytes.Split(data, []byte("\n---")) {
+			var obj map[string]interface{}
+			if err := yaml.Unmarshal(doc, &obj); err != nil {
+				return nil, false
+			}
+			if obj != nil {
+				docs = append(docs, obj)
+			}
+		}
+	}
+
+	objects := make(map[string]map[string]interface{})
+	var add func(obj map[string]interface{}) bool
+	add = func(obj map[string]interface{}) bool {
+		if items, ok := obj["items"].([]interface{}); ok {
+			for _, item := range items {
+				if itemObj, ok := item.(map[string]interface{}); ok && !add(itemObj) {
+					return false
+				}
+			}
+			return true
+		}
+		kind, _ := obj["kind"].(string)
+		meta, _ := obj["metadata"].(map[string]interface{})
+		if len(kind) == 0 || meta == nil {
+			return false
+		}
+		name, _ := meta["name"].(string)
+		if ns, _ := meta["namespace"].(string); len(ns) > 0 {
+			name = ns + "/" + name
+		}
+		objects[kind+" "+name] = obj
+		return true
+	}
+	for _, doc := range docs {
+		if !add(doc) {
+			return nil, false
+		}
+	}
+	return objects, true
+}
+
+// isManifest returns whether the file name is the name of a JSON or YAML manifest
+func isManifest(name string) bool {
+	ext := path.Ext(name)
+	return ext == ".json" || ext == ".yaml" || ext == ".yml"
+}
+
+func diffObjects(before, after map[string]map[string]interface{}) []ObjectChange {
+	var changes []ObjectChange
+	for id, objBefore := range before {
+		objAfter, ok := after[id]
+		if !ok {
+			changes = append(changes, ObjectChange{Object: id, Change: Removed})
+			continue
+		}
+		var fields []string
+		diffValues("", objBefore, objAfter, &fields)
+		if len(fields) > 0 {
+			sort.Strings(fields)
+			changes = append(changes, ObjectChange{Object: id, Change: Changed, Fields: fields})
+		}
+	}
+	for id := range after {
+		if _, ok := before[id]; !ok {
+			changes = append(changes, ObjectChange{Object: id, Change: Added})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Object < changes[j].Object })
+	return changes
+}
+
+// diffValues appends to fields the paths of the values that differ. Maps are compared key by
+// key, other values (including lists) are compared as a whole.
+func diffValues(prefix string, a, b interface{}, fields *[]string) {
+	for _, volatile := range volatileFields {
+		if prefix == volatile {
+			return
+		}
+	}
+	mapA, okA := a.(map[string]interface{})
+	mapB, okB := b.(map[string]interface{})
+	if !okA || !okB {
+		if !reflect.DeepEqual(a, b) {
+			*fields = append(*fields, prefix)
+		}
+		return
+	}
+
+	keys := make(map[string]bool)
+	for k := range mapA {
+		keys[k] = true
+	}
+	for k := range mapB {
+		keys[k] = true
+	}
+	for k := range keys {
+		field := k
+		if len(prefix) > 0 {
+			field = prefix + "." + k
+		}
+		diffValues(field, mapA[k], mapB[k], fields)
+	}
+}
+
+// diffLines returns the number of lines added and removed, regardless of their order
+func diffLines(before, after []byte) (added, removed int) {
+	counts := make(map[string]int)
+	for _, line := range strings.Split(string(before), "\n") {
+		counts[line]++
+	}
+	for _, line := range strings.Split(string(after), "\n") {
+		if counts[line] > 0 {
+			counts[line]--
+			continue
+		}
+		added++
+	}
+	for _, count := range counts {
+		removed += count
+	}
+	return added, removed
+}
+
+// WriteText writes the report as a list of changes, one file per line followed by its object changes
+func (r Report) WriteText(w io.Writer) error {
+	if len(r.Changes) == 0 {
+		_, err := fmt.Fprintf(w, "no differences between %s and %s\n", r.From, r.To)
+		return err
+	}
+
+	counts := make(map[string]int)
+	for _, change := range r.Changes {
+		counts[change.Change]++
+		line := fmt.Sprintf("%-8s %s", change.Change, change.Name)
+		if change.AddedLines > 0 || change.RemovedLines > 0 {
+			line += fmt.Sprintf(" (+%d -%d lines)", change.AddedLines, change.RemovedLines)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+		for _, obj := range change.Objects {
+			line := fmt.Sprintf("    %-8s %s", obj.Change, obj.Object)
+			if len(obj.Fields) > 0 {
+				line += ": " + strings.Join(obj.Fields, ", ")
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "\n%d changed, %d added, %d removed\n", counts[Changed], counts[Added], counts[Removed])
+	return err
+}
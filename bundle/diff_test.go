@@ -0,0 +1,100 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bundle
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+const podsBefore = `{"kind":"PodList","items":[
+{"kind":"Pod","metadata":{"name":"app-0","namespace":"default","resourceVersion":"1"},"spec":{"nodeName":"node-1"}},
+{"kind":"Pod","metadata":{"name":"app-1","namespace":"default"},"spec":{"nodeName":"node-1"}}
+]}`
+
+const podsAfter = `{"kind":"PodList","items":[
+{"kind":"Pod","metadata":{"name":"app-0","namespace":"default","resourceVersion":"2"},"spec":{"nodeName":"node-2"}},
+{"kind":"Pod","metadata":{"name":"app-2","namespace":"default"},"spec":{"nodeName":"node-1"}}
+]}`
+
+func TestDiff(t *testing.T) {
+	root, err := ioutil.TempDir("", "crashd-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	a, err := Open(makeBundle(t, root, "crashd-a", "a.tar.gz", map[string]string{
+		"10_0_0_1/df.txt":          "/dev/sda1 80%\n/dev/sdb1 10%",
+		"10_0_0_1/kubelet.log":     "started",
+		"10_0_0_1/sysctl.txt":      "net.ipv4.ip_forward = 1",
+		"kubecapture/pods.json":    podsBefore,
+		"kubecapture/removed.yaml": "kind: ConfigMap\nmetadata:\n  name: old\n",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Open(makeBundle(t, root, "crashd-b", "b.tar.gz", map[string]string{
+		"10_0_0_1/df.txt":       "/dev/sda1 95%\n/dev/sdb1 10%",
+		"10_0_0_1/kubelet.log":  "restarted",
+		"10_0_0_1/sysctl.txt":   "net.ipv4.ip_forward = 1",
+		"10_0_0_2/df.txt":       "/dev/sda1 20%",
+		"kubecapture/pods.json": podsAfter,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Diff(a, b, []string{"*.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []FileChange{
+		{Name: "10_0_0_1/df.txt", Change: Changed, AddedLines: 1, RemovedLines: 1},
+		{Name: "10_0_0_2/df.txt", Change: Added},
+		{Name: "kubecapture/pods.json", Change: Changed},
+		{Name: "kubecapture/removed.yaml", Change: Removed},
+	}
+	if len(report.Changes) != len(expected) {
+		t.Fatalf("expecting %d changes, got %#v", len(expected), report.Changes)
+	}
+	for i, change := range report.Changes {
+		if change.Name != expected[i].Name || change.Change != expected[i].Change ||
+			change.AddedLines != expected[i].AddedLines || change.RemovedLines != expected[i].RemovedLines {
+			t.Errorf("unexpected change: %#v", change)
+		}
+	}
+
+	objects := report.Changes[2].Objects
+	if len(objects) != 3 {
+		t.Fatalf("expecting 3 object changes, got %#v", objects)
+	}
+	if objects[0].Object != "Pod default/app-0" || objects[0].Change != Changed || strings.Join(objects[0].Fields, ",") != "spec.nodeName" {
+		t.Errorf("unexpected object change: %#v", objects[0])
+	}
+	if objects[1].Object != "Pod default/app-1" || objects[1].Change != Removed {
+		t.Errorf("unexpected object change: %#v", objects[1])
+	}
+	if objects[2].Object != "Pod default/app-2" || objects[2].Change != Added {
+		t.Errorf("unexpected object change: %#v", objects[2])
+	}
+
+	var out bytes.Buffer
+	if err := report.WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"changed  10_0_0_1/df.txt (+1 -1 lines)", "    changed  Pod default/app-0: spec.nodeName", "2 changed, 1 added, 1 removed"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("report missing %q:\n%s", line, out.String())
+		}
+	}
+
+	if _, err := Diff(a, b, []string{"["}); err == nil {
+		t.Error("expecting error for invalid exclude pattern")
+	}
+}
@@ -0,0 +1,6 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package bundle reads diagnostics bundles, the archives created by scripts with archive()
+// or their working directories, to compare, list, extract, and search them.
+package bundle
@@ -0,0 +1,104 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bundle
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+)
+
+// binaryCheckSize is the number of bytes checked for NUL bytes to detect binary files
+const binaryCheckSize = 8000
+
+// Extract writes the content of the named file of the bundle to w
+func Extract(bundlePath, name string, w io.Writer) error {
+	name = path.Clean(name)
+	found := false
+	if err := Walk(bundlePath, func(fileName string, _ int64, r io.Reader) error {
+		if fileName != name {
+			return nil
+		}
+		found = true
+		if _, err := io.Copy(w, r); err != nil {
+			return err
+		}
+		return ErrStopWalk
+	}); err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("file %s not found in bundle %s", name, bundlePath)
+	}
+	return nil
+}
+
+// Match is a line of a bundle file matching a grep pattern
+type Match struct {
+	Name string
+	// Line is the line number, starting at 1
+	Line int
+	Text string
+}
+
+// Grep calls fn for each line of the text files of the bundle matching re. When globs are
+// provided, only the files whose path or name matches one of them are searched. Binary files are skipped.
+func Grep(bundlePath string, re *regexp.Regexp, globs []string, fn func(Match) error) error {
+	for _, glob := range globs {
+		if _, err := path.Match(glob, ""); err != nil {
+			return fmt.Errorf("invalid file pattern %s: %s", glob, err)
+		}
+	}
+
+	return Walk(bundlePath, func(name string, _ int64, r io.Reader) error {
+		if !matchesAny(name, globs) {
+			return nil
+		}
+
+		reader := bufio.NewReaderSize(r, 64*1024)
+		head, err := reader.Peek(binaryCheckSize)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return err
+		}
+		if bytes.IndexByte(head, 0) >= 0 {
+			return nil
+		}
+
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		line := 0
+		for scanner.Scan() {
+			line++
+			if re.Match(scanner.Bytes()) {
+				if err := fn(Match{Name: name, Line: line, Text: scanner.Text()}); err != nil {
+					return err
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		return nil
+	})
+}
+
+// matchesAny reports whether the path or the base name of name matches one of the globs,
+// or true when there are no globs
+func matchesAny(name string, globs []string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(glob, path.Base(name)); ok {
+			return true
+		}
+	}
+	return false
+}
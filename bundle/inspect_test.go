@@ -0,0 +1,118 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bundle
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	root, err := ioutil.TempDir("", "crashd-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	archive := makeBundle(t, root, "crashd", "bundle.tar.gz", map[string]string{
+		"10_0_0_1/df.txt":     "/dev/sda1 80%",
+		"10_0_0_1/uptime.txt": "up 3 days",
+	})
+
+	tests := []struct {
+		name       string
+		file       string
+		expected   string
+		shouldFail bool
+	}{
+		{name: "host file", file: "10_0_0_1/uptime.txt", expected: "up 3 days"},
+		{name: "unclean path", file: "./10_0_0_1/../10_0_0_1/df.txt", expected: "/dev/sda1 80%"},
+		{name: "provenance", file: "provenance.yaml", expected: "crashd"},
+		{name: "missing file", file: "10_0_0_2/df.txt", shouldFail: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Extract(archive, test.file, &out)
+			if test.shouldFail {
+				if err == nil {
+					t.Fatal("expecting error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != test.expected {
+				t.Errorf("unexpected content: %q", out.String())
+			}
+		})
+	}
+}
+
+func TestGrep(t *testing.T) {
+	root, err := ioutil.TempDir("", "crashd-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	archive := makeBundle(t, root, "crashd", "bundle.tar.zst", map[string]string{
+		"10_0_0_1/kubelet.log": "started\nerror: image pull failed\nready",
+		"10_0_0_1/dmesg.txt":   "oom-killer: error",
+		"10_0_0_1/core.bin":    "error\x00\x01",
+	})
+
+	tests := []struct {
+		name       string
+		globs      []string
+		expected   []Match
+		shouldFail bool
+	}{
+		{
+			name: "all text files",
+			expected: []Match{
+				{Name: "10_0_0_1/dmesg.txt", Line: 1, Text: "oom-killer: error"},
+				{Name: "10_0_0_1/kubelet.log", Line: 2, Text: "error: image pull failed"},
+			},
+		},
+		{
+			name:     "glob",
+			globs:    []string{"*.log"},
+			expected: []Match{{Name: "10_0_0_1/kubelet.log", Line: 2, Text: "error: image pull failed"}},
+		},
+		{name: "invalid glob", globs: []string{"["}, shouldFail: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matches := make(map[Match]bool)
+			err := Grep(archive, regexp.MustCompile("error"), test.globs, func(m Match) error {
+				matches[m] = true
+				return nil
+			})
+			if test.shouldFail {
+				if err == nil {
+					t.Fatal("expecting error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(matches) != len(test.expected) {
+				t.Fatalf("expecting %d matches, got %v", len(test.expected), matches)
+			}
+			for _, m := range test.expected {
+				if !matches[m] {
+					t.Errorf("missing match %#v", m)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,446 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bundle
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Connectivity values of a ConnectivityMatrix. Connections allowed only on some ports are
+// reported as the list of ports (i.e. TCP/8080 UDP/53).
+const (
+	Allowed = "allow"
+	Denied  = "deny"
+)
+
+// anyPort is the port set entry of connections allowed on all protocols and ports
+const anyPort = "*"
+
+// Workload is a group of pods with the same controller (i.e. the pods of a deployment),
+// a row and a column of the connectivity matrix
+type Workload struct {
+	Namespace string
+	Name      string
+	// Labels are the labels common to all the pods of the workload
+	Labels map[string]string
+	// Ports are the named container ports of the pods, as protocol/port
+	Ports map[string]string
+}
+
+func (w Workload) String() string {
+	return w.Namespace + "/" + w.Name
+}
+
+// ConnectivityMatrix is the pod-to-pod connectivity allowed by the NetworkPolicies of a bundle.
+// Connections[i][j] is the connectivity from Workloads[i] to Workloads[j]: Allowed, Denied,
+// or the ports the connection is allowed on.
+type ConnectivityMatrix struct {
+	Workloads   []Workload
+	Policies    []string
+	Connections [][]string
+}
+
+// NetworkPolicyMatrix computes the connectivity matrix of the running pods captured in b,
+// grouped by workload, from the NetworkPolicies and Namespaces captured in the bundle.
+// Workloads are restricted to namespaces, when not empty, and to pods matching selector.
+// Host network pods are not affected by NetworkPolicies and are left out, as are ipBlock
+// peers which only apply to addresses outside of the cluster. Manifests larger than MaxContentSize
+// are read again from the bundle.
+func NetworkPolicyMatrix(b *Bundle, namespaces []string, selector string) (*ConnectivityMatrix, error) {
+	podSelector, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector: %s", err)
+	}
+	nsFilter := make(map[string]bool)
+	for _, ns := range namespaces {
+		nsFilter[ns] = true
+	}
+
+	var pods []corev1.Pod
+	var policies []networkingv1.NetworkPolicy
+	nsLabels := make(map[string]map[string]string)
+	collect := func(name string, data []byte) error {
+		objects, ok := ParseObjects(name, data)
+		if !ok {
+			return nil
+		}
+		for id, obj := range objects {
+			var err error
+			switch obj["kind"] {
+			case "Pod":
+				var pod corev1.Pod
+				if err = convertObject(obj, &pod); err == nil {
+					pods = append(pods, pod)
+				}
+			case "NetworkPolicy":
+				var policy networkingv1.NetworkPolicy
+				if err = convertObject(obj, &policy); err == nil {
+					policies = append(policies, policy)
+				}
+			case "Namespace":
+				var ns corev1.Namespace
+				if err = convertObject(obj, &ns); err == nil {
+					nsLabels[ns.Name] = ns.Labels
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %s: %s", name, id, err)
+			}
+		}
+		return nil
+	}
+
+	// the content of the files larger than MaxContentSize (i.e. the pods of large namespaces) is
+	// not kept in memory, they are read again from the bundle
+	large := make(map[string]bool)
+	for _, name := range b.Names() {
+		file := b.Files[name]
+		if file.Data == nil && file.Size > 0 {
+			large[name] = true
+			continue
+		}
+		if err := collect(name, file.Data); err != nil {
+			return nil, err
+		}
+	}
+	if len(large) > 0 {
+		if err := Walk(b.Path, func(name string, size int64, r io.Reader) error {
+			if !large[name] || !isManifest(name) {
+				return nil
+			}
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			return collect(name, data)
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	m := &ConnectivityMatrix{Workloads: groupWorkloads(pods, nsFilter, podSelector)}
+	if len(m.Workloads) == 0 {
+		return nil, fmt.Errorf("no running pod found in %s", b.Path)
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].Namespace+"/"+policies[i].Name < policies[j].Namespace+"/"+policies[j].Name
+	})
+	for _, policy := range policies {
+		m.Policies = append(m.Policies, policy.Namespace+"/"+policy.Name)
+	}
+
+	for _, src := range m.Workloads {
+		row := make([]string, len(m.Workloads))
+		for j, dst := range m.Workloads {
+			ports := intersectPorts(
+				allowedPorts(policies, networkingv1.PolicyTypeIngress, dst, src, dst, nsLabels),
+				allowedPorts(policies, networkingv1.PolicyTypeEgress, src, dst, dst, nsLabels),
+			)
+			row[j] = ports.String()
+		}
+		m.Connections = append(m.Connections, row)
+	}
+	return m, nil
+}
+
+// convertObject converts an object parsed from a manifest to its API type
+func convertObject(obj map[string]interface{}, into interface{}) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, into)
+}
+
+// groupWorkloads groups the running pods by controller. The pods of a deployment are grouped
+// under the deployment name, trimmed from the ReplicaSet pod-template-hash.
+func groupWorkloads(pods []corev1.Pod, namespaces map[string]bool, selector labels.Selector) []Workload {
+	workloads := make(map[string]*Workload)
+	for _, pod := range pods {
+		if len(namespaces) > 0 && !namespaces[pod.Namespace] {
+			continue
+		}
+		if pod.Spec.HostNetwork || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+
+		name := pod.Name
+		if owner := metav1.GetControllerOf(&pod); owner != nil {
+			name = owner.Name
+			if hash, ok := pod.Labels["pod-template-hash"]; ok && owner.Kind == "ReplicaSet" {
+				name = strings.TrimSuffix(name, "-"+hash)
+			}
+		}
+
+		key := pod.Namespace + "/" + name
+		w, ok := workloads[key]
+		if !ok {
+			w = &Workload{Namespace: pod.Namespace, Name: name, Labels: make(map[string]string), Ports: make(map[string]string)}
+			for k, v := range pod.Labels {
+				w.Labels[k] = v
+			}
+			workloads[key] = w
+		}
+		for k, v := range w.Labels {
+			if pod.Labels[k] != v {
+				delete(w.Labels, k)
+			}
+		}
+		for _, container := range pod.Spec.Containers {
+			for _, port := range container.Ports {
+				if len(port.Name) > 0 {
+					w.Ports[port.Name] = fmt.Sprintf("%s/%d", protocol(port.Protocol), port.ContainerPort)
+				}
+			}
+		}
+	}
+
+	var result []Workload
+	for _, w := range workloads {
+		result = append(result, *w)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].String() < result[j].String() })
+	return result
+}
+
+// portSet is a set of protocol/port, or anyPort
+type portSet map[string]bool
+
+func (p portSet) String() string {
+	if p[anyPort] {
+		return Allowed
+	}
+	if len(p) == 0 {
+		return Denied
+	}
+	var ports []string
+	for port := range p {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+	return strings.Join(ports, " ")
+}
+
+// allowedPorts returns the ports on which the policies of policyType selecting target allow
+// the connection with peer, or anyPort when target is not isolated by such a policy. Named
+// ports are resolved with the container ports of dst, the destination of the connection.
+func allowedPorts(policies []networkingv1.NetworkPolicy, policyType networkingv1.PolicyType, target, peer, dst Workload, nsLabels map[string]map[string]string) portSet {
+	ports := make(portSet)
+	isolated := false
+	for _, policy := range policies {
+		if policy.Namespace != target.Namespace || !hasPolicyType(policy, policyType) || !selectorMatches(&policy.Spec.PodSelector, target.Labels) {
+			continue
+		}
+		isolated = true
+
+		var rules [][]networkingv1.NetworkPolicyPeer
+		var rulePorts [][]networkingv1.NetworkPolicyPort
+		if policyType == networkingv1.PolicyTypeIngress {
+			for _, rule := range policy.Spec.Ingress {
+				rules = append(rules, rule.From)
+				rulePorts = append(rulePorts, rule.Ports)
+			}
+		} else {
+			for _, rule := range policy.Spec.Egress {
+				rules = append(rules, rule.To)
+				rulePorts = append(rulePorts, rule.Ports)
+			}
+		}
+
+		for i, peers := range rules {
+			if !peersMatch(peers, policy.Namespace, peer, nsLabels) {
+				continue
+			}
+			if len(rulePorts[i]) == 0 {
+				ports[anyPort] = true
+			}
+			for _, port := range rulePorts[i] {
+				ports[resolvePort(port, dst)] = true
+			}
+		}
+	}
+	if !isolated {
+		return portSet{anyPort: true}
+	}
+	return ports
+}
+
+// hasPolicyType returns whether policy applies to policyType connections. Policies without
+// policyTypes apply to ingress, and to egress when they have egress rules.
+func hasPolicyType(policy networkingv1.NetworkPolicy, policyType networkingv1.PolicyType) bool {
+	if len(policy.Spec.PolicyTypes) == 0 {
+		return policyType == networkingv1.PolicyTypeIngress || len(policy.Spec.Egress) > 0
+	}
+	for _, t := range policy.Spec.PolicyTypes {
+		if t == policyType {
+			return true
+		}
+	}
+	return false
+}
+
+// peersMatch returns whether peer is one of the peers of a rule of a policy in namespace.
+// A rule without peers matches all pods.
+func peersMatch(peers []networkingv1.NetworkPolicyPeer, namespace string, peer Workload, nsLabels map[string]map[string]string) bool {
+	if len(peers) == 0 {
+		return true
+	}
+	for _, p := range peers {
+		if p.IPBlock != nil {
+			continue
+		}
+		if p.NamespaceSelector == nil {
+			if peer.Namespace == namespace && selectorMatches(p.PodSelector, peer.Labels) {
+				return true
+			}
+			continue
+		}
+		if selectorMatches(p.NamespaceSelector, namespaceLabels(peer.Namespace, nsLabels)) &&
+			(p.PodSelector == nil || selectorMatches(p.PodSelector, peer.Labels)) {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaceLabels returns the labels of a namespace, including the kubernetes.io/metadata.name
+// label set by the API server, when the namespace was not captured
+func namespaceLabels(ns string, nsLabels map[string]map[string]string) map[string]string {
+	result := map[string]string{"kubernetes.io/metadata.name": ns}
+	for k, v := range nsLabels[ns] {
+		result[k] = v
+	}
+	return result
+}
+
+func selectorMatches(selector *metav1.LabelSelector, set map[string]string) bool {
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false
+	}
+	return s.Matches(labels.Set(set))
+}
+
+// resolvePort returns a policy port as protocol/port, named ports being resolved with the
+// container ports of dst when found
+func resolvePort(port networkingv1.NetworkPolicyPort, dst Workload) string {
+	proto := "TCP"
+	if port.Protocol != nil {
+		proto = protocol(*port.Protocol)
+	}
+	if port.Port == nil {
+		return proto + "/" + anyPort
+	}
+	if named, ok := dst.Ports[port.Port.String()]; ok && strings.HasPrefix(named, proto+"/") {
+		return named
+	}
+	return proto + "/" + port.Port.String()
+}
+
+func protocol(p corev1.Protocol) string {
+	if len(p) == 0 {
+		return string(corev1.ProtocolTCP)
+	}
+	return string(p)
+}
+
+// intersectPorts returns the ports of both sets. A protocol/* port matches all the ports of the protocol.
+func intersectPorts(a, b portSet) portSet {
+	if a[anyPort] {
+		return b
+	}
+	if b[anyPort] {
+		return a
+	}
+	result := make(portSet)
+	for pa := range a {
+		for pb := range b {
+			protoA, portA := splitPort(pa)
+			protoB, portB := splitPort(pb)
+			switch {
+			case protoA != protoB:
+			case portA == anyPort:
+				result[pb] = true
+			case portB == anyPort || portA == portB:
+				result[pa] = true
+			}
+		}
+	}
+	return result
+}
+
+func splitPort(port string) (string, string) {
+	parts := strings.SplitN(port, "/", 2)
+	if len(parts) < 2 {
+		return parts[0], anyPort
+	}
+	return parts[0], parts[1]
+}
+
+// WriteCSV writes the matrix as CSV, one row per source workload and one column per destination
+func (m *ConnectivityMatrix) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	header := []string{"from\\to"}
+	for _, dst := range m.Workloads {
+		header = append(header, dst.String())
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for i, src := range m.Workloads {
+		if err := writer.Write(append([]string{src.String()}, m.Connections[i]...)); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+const matrixTemplate = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>crashd - network policy matrix</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ddd; padding: 2px 8px; text-align: left; }
+.allow { background: #d8f0d8; }
+.deny { background: #f6d6d6; }
+.ports { background: #fbeccb; }
+</style></head>
+<body>
+<h1>Network policy matrix</h1>
+<p>{{len .Workloads}} workloads, {{len .Policies}} network policies: {{range $i, $p := .Policies}}{{if $i}}, {{end}}{{$p}}{{end}}</p>
+<table><tr><th>from \ to</th>{{range .Workloads}}<th>{{.}}</th>{{end}}</tr>
+{{range $i, $src := .Workloads}}<tr><th>{{$src}}</th>{{range index $.Connections $i}}<td class="{{cellClass .}}">{{.}}</td>{{end}}</tr>
+{{end}}</table>
+</body></html>
+`
+
+var matrixPage = template.Must(template.New("matrix").Funcs(template.FuncMap{
+	"cellClass": func(value string) string {
+		if value == Allowed || value == Denied {
+			return value
+		}
+		return "ports"
+	},
+}).Parse(matrixTemplate))
+
+// WriteHTML writes the matrix as an HTML page, connections colored by connectivity
+func (m *ConnectivityMatrix) WriteHTML(w io.Writer) error {
+	return matrixPage.Execute(w, m)
+}
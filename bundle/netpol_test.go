@@ -0,0 +1,173 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bundle
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const netpolPods = `{"kind":"PodList","items":[
+{"kind":"Pod","metadata":{"name":"web-5d8f-abcde","namespace":"shop","labels":{"app":"web","pod-template-hash":"5d8f"},
+ "ownerReferences":[{"kind":"ReplicaSet","name":"web-5d8f","controller":true}]},"status":{"phase":"Running"}},
+{"kind":"Pod","metadata":{"name":"db-0","namespace":"shop","labels":{"app":"db"},
+ "ownerReferences":[{"kind":"StatefulSet","name":"db","controller":true}]},
+ "spec":{"containers":[{"name":"db","ports":[{"name":"sql","containerPort":5432}]}]},"status":{"phase":"Running"}},
+{"kind":"Pod","metadata":{"name":"probe","namespace":"monitoring","labels":{"app":"probe"}},"status":{"phase":"Running"}},
+{"kind":"Pod","metadata":{"name":"done","namespace":"shop","labels":{"app":"job"}},"status":{"phase":"Succeeded"}}
+]}`
+
+const netpolPolicies = `kind: NetworkPolicy
+metadata:
+  name: db-from-web
+  namespace: shop
+spec:
+  podSelector:
+    matchLabels:
+      app: db
+  ingress:
+  - from:
+    - podSelector:
+        matchLabels:
+          app: web
+    ports:
+    - port: sql
+---
+kind: NetworkPolicy
+metadata:
+  name: web-from-monitoring
+  namespace: shop
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  policyTypes: [Ingress]
+  ingress:
+  - from:
+    - namespaceSelector:
+        matchLabels:
+          team: ops
+---
+kind: NetworkPolicy
+metadata:
+  name: deny-egress
+  namespace: monitoring
+spec:
+  podSelector: {}
+  policyTypes: [Egress]
+  egress:
+  - to:
+    - namespaceSelector: {}
+      podSelector:
+        matchLabels:
+          app: web
+`
+
+func TestNetworkPolicyMatrix(t *testing.T) {
+	b := &Bundle{Path: "test", Files: map[string]*File{
+		"kubecapture/core_v1/pods.json":            {Data: []byte(netpolPods)},
+		"kubecapture/core_v1/namespaces.yaml":      {Data: []byte("kind: Namespace\nmetadata:\n  name: monitoring\n  labels:\n    team: ops\n")},
+		"kubecapture/networking/policies.yaml":     {Data: []byte(netpolPolicies)},
+		"10_0_0_1/kubelet.log":                     {Data: []byte("started")},
+		"kubecapture/core_v1/shop/web/web/web.log": {Data: []byte("GET /")},
+	}}
+
+	m, err := NetworkPolicyMatrix(b, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var workloads []string
+	for _, w := range m.Workloads {
+		workloads = append(workloads, w.String())
+	}
+	if strings.Join(workloads, ",") != "monitoring/probe,shop/db,shop/web" {
+		t.Fatalf("unexpected workloads: %v", workloads)
+	}
+	if len(m.Policies) != 3 {
+		t.Errorf("expecting 3 policies, got %v", m.Policies)
+	}
+
+	expected := [][]string{
+		// from monitoring/probe: egress only to web pods, web allows the ops namespace
+		{Denied, Denied, Allowed},
+		// from shop/db: db only allows web, web only allows the ops namespace
+		{Allowed, Denied, Denied},
+		// from shop/web: db allows web on its named sql port
+		{Allowed, "TCP/5432", Denied},
+	}
+	for i := range expected {
+		for j := range expected[i] {
+			if m.Connections[i][j] != expected[i][j] {
+				t.Errorf("%s -> %s: expecting %s, got %s", workloads[i], workloads[j], expected[i][j], m.Connections[i][j])
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	if err := m.WriteCSV(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "from\\to,monitoring/probe,shop/db,shop/web\nmonitoring/probe,deny,deny,allow\n") {
+		t.Errorf("unexpected CSV:\n%s", out.String())
+	}
+	out.Reset()
+	if err := m.WriteHTML(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `<td class="ports">TCP/5432</td>`) {
+		t.Errorf("unexpected HTML:\n%s", out.String())
+	}
+
+	m, err = NetworkPolicyMatrix(b, []string{"shop"}, "app!=db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Workloads) != 1 || m.Workloads[0].String() != "shop/web" {
+		t.Errorf("unexpected selected workloads: %v", m.Workloads)
+	}
+	if _, err := NetworkPolicyMatrix(b, []string{"missing"}, ""); err == nil {
+		t.Error("expecting error when no pod is selected")
+	}
+}
+
+func TestNetworkPolicyMatrixLargeFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashd-netpol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		// larger than the files kept in memory
+		"kubecapture/core_v1/pods.json":        netpolPods + strings.Repeat(" ", MaxContentSize),
+		"kubecapture/networking/policies.yaml": netpolPolicies,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Files["kubecapture/core_v1/pods.json"].Data != nil {
+		t.Fatal("expecting the content of the pods not to be kept in memory")
+	}
+	m, err := NetworkPolicyMatrix(b, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Workloads) != 3 || len(m.Policies) != 3 {
+		t.Errorf("unexpected workloads %v and policies %v", m.Workloads, m.Policies)
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bundle
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MaxSearchMatches is the largest number of lines returned by a search of the bundle
+const MaxSearchMatches = 1000
+
+const layoutTemplate = `{{define "layout"}}<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>crashd - {{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+nav a { margin-right: 1em; }
+table { border-collapse: collapse; }
+td, th { border-bottom: 1px solid #ddd; padding: 2px 8px; text-align: left; vertical-align: top; }
+pre { background: #f6f6f6; padding: 1em; overflow-x: auto; }
+.Warning { color: #b35900; }
+</style></head>
+<body>
+<nav><a href="/">Overview</a><a href="/events">Events</a>
+<form action="/search" style="display:inline"><input name="q" placeholder="regexp" value="{{.Query}}"> <input type="submit" value="Search"></form></nav>
+<h1>{{.Title}}</h1>
+{{template "content" .}}
+</body></html>{{end}}`
+
+var pageTemplates = map[string]string{
+	"index": `{{define "content"}}
+<p>Bundle {{.View.Bundle.Path}}: {{len .View.Bundle.Files}} files</p>
+<h2>Namespaces</h2>
+<ul>{{range .View.Namespaces}}<li><a href="/namespaces/{{.}}">{{.}}</a></li>{{else}}<li>no API objects captured</li>{{end}}</ul>
+<h2>Nodes</h2>
+<ul>{{range .View.Nodes}}<li><a href="/nodes/{{.}}">{{.}}</a></li>{{else}}<li>no node outputs captured</li>{{end}}</ul>
+{{end}}`,
+
+	"namespace": `{{define "content"}}
+<h2>Workloads</h2>
+<table><tr><th>Kind</th><th>Name</th><th>Status</th></tr>
+{{range .Objects}}<tr><td>{{.Kind}}</td><td><a href="/files/{{.File}}?q={{.Name}}">{{.Name}}</a></td><td>{{.Status}}</td></tr>{{end}}
+</table>
+<h2>Logs</h2>
+<ul>{{range .Files}}<li><a href="/files/{{.}}">{{.}}</a></li>{{else}}<li>no logs captured</li>{{end}}</ul>
+<p><a href="/events?namespace={{.Title}}">Events of the namespace</a></p>
+{{end}}`,
+
+	"node": `{{define "content"}}
+<ul>{{range .Files}}<li><a href="/files/{{.}}">{{.}}</a></li>{{end}}</ul>
+{{end}}`,
+
+	"events": `{{define "content"}}
+<table><tr><th>Time</th><th>Namespace</th><th>Type</th><th>Reason</th><th>Object</th><th>Message</th></tr>
+{{range .Events}}<tr class="{{.Type}}"><td>{{.Time}}</td><td>{{.Namespace}}</td><td>{{.Type}}</td><td>{{.Reason}}</td><td>{{.Object}}</td><td>{{.Message}}</td></tr>{{end}}
+</table>
+{{end}}`,
+
+	"file": `{{define "content"}}
+<form><input name="q" placeholder="filter lines (regexp)" value="{{.Query}}"> <input type="submit" value="Filter"></form>
+{{if .Query}}<p>{{len .Matches}} matching lines</p>
+<pre>{{range .Matches}}{{.Line}}: {{.Text}}
+{{end}}</pre>{{else}}<pre>{{.Content}}</pre>{{end}}
+{{end}}`,
+
+	"search": `{{define "content"}}
+<p>{{len .Matches}} matching lines{{if .Truncated}} (first {{len .Matches}} shown){{end}}</p>
+<table>{{range .Matches}}<tr><td><a href="/files/{{.Name}}?q={{$.Query}}">{{.Name}}</a>:{{.Line}}</td><td><code>{{.Text}}</code></td></tr>{{end}}</table>
+{{end}}`,
+}
+
+// page is the data rendered by the page templates
+type page struct {
+	Title     string
+	Query     string
+	View      *View
+	Objects   []Object
+	Files     []string
+	Events    []Event
+	Content   string
+	Matches   []Match
+	Truncated bool
+}
+
+// Server is an HTTP handler serving a browsable view of a bundle: namespaces with their
+// workloads and logs, the events timeline, node outputs, and a search of the bundle files
+type Server struct {
+	view      *View
+	mux       *http.ServeMux
+	templates map[string]*template.Template
+}
+
+// NewServer returns a Server for the bundle b
+func NewServer(b *Bundle) (*Server, error) {
+	s := &Server{view: NewView(b), mux: http.NewServeMux(), templates: make(map[string]*template.Template)}
+	for name, content := range pageTemplates {
+		tmpl, err := template.New(name).Parse(layoutTemplate)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tmpl.Parse(content); err != nil {
+			return nil, fmt.Errorf("template %s: %s", name, err)
+		}
+		s.templates[name] = tmpl
+	}
+
+	s.mux.HandleFunc("/", s.index)
+	s.mux.HandleFunc("/namespaces/", s.namespace)
+	s.mux.HandleFunc("/nodes/", s.node)
+	s.mux.HandleFunc("/events", s.events)
+	s.mux.HandleFunc("/files/", s.file)
+	s.mux.HandleFunc("/search", s.search)
+	return s, nil
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	s.render(w, "index", page{Title: path.Base(s.view.Bundle.Path), View: s.view})
+}
+
+func (s *Server) namespace(w http.ResponseWriter, r *http.Request) {
+	ns := strings.TrimPrefix(r.URL.Path, "/namespaces/")
+	objects, hasObjects := s.view.Workloads[ns]
+	logs, hasLogs := s.view.Logs[ns]
+	if !hasObjects && !hasLogs {
+		http.NotFound(w, r)
+		return
+	}
+	s.render(w, "namespace", page{Title: ns, Objects: objects, Files: logs})
+}
+
+func (s *Server) node(w http.ResponseWriter, r *http.Request) {
+	node := strings.TrimPrefix(r.URL.Path, "/nodes/")
+	files, ok := s.view.NodeFiles[node]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s.render(w, "node", page{Title: node, Files: files})
+}
+
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	ns := r.URL.Query().Get("namespace")
+	title := "Events"
+	events := s.view.Events
+	if len(ns) > 0 {
+		title = "Events of " + ns
+		events = nil
+		for _, event := range s.view.Events {
+			if event.Namespace == ns {
+				events = append(events, event)
+			}
+		}
+	}
+	s.render(w, "events", page{Title: title, Events: events})
+}
+
+func (s *Server) file(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/files/")
+	file, ok := s.view.Bundle.Files[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	content := file.Data
+	if content == nil {
+		// the content of large files is not kept in memory
+		var buf bytes.Buffer
+		if err := Extract(s.view.Bundle.Path, name, &buf); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		content = buf.Bytes()
+	}
+
+	query := r.URL.Query().Get("q")
+	p := page{Title: name, Query: query}
+	if len(query) == 0 {
+		p.Content = string(content)
+		s.render(w, "file", p)
+		return
+	}
+
+	re, err := regexp.Compile(query)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid pattern: %s", err), http.StatusBadRequest)
+		return
+	}
+	for i, line := range strings.Split(string(content), "\n") {
+		if re.MatchString(line) {
+			p.Matches = append(p.Matches, Match{Name: name, Line: i + 1, Text: line})
+		}
+	}
+	s.render(w, "file", p)
+}
+
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	p := page{Title: "Search", Query: query}
+	if len(query) == 0 {
+		s.render(w, "search", p)
+		return
+	}
+
+	re, err := regexp.Compile(query)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid pattern: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err := Grep(s.view.Bundle.Path, re, nil, func(m Match) error {
+		if len(p.Matches) == MaxSearchMatches {
+			p.Truncated = true
+			return ErrStopWalk
+		}
+		p.Matches = append(p.Matches, m)
+		return nil
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.render(w, "search", p)
+}
+
+func (s *Server) render(w http.ResponseWriter, name string, p page) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.templates[name].ExecuteTemplate(w, "layout", p); err != nil {
+		logrus.Debugf("serve-bundle: %s: %s", name, err)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bundle
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+const deployments = `{"kind":"DeploymentList","items":[
+{"kind":"Deployment","metadata":{"name":"web","namespace":"default"},"spec":{"replicas":3},"status":{"readyReplicas":2}}
+]}`
+
+const events = `{"kind":"EventList","items":[
+{"kind":"Event","metadata":{"name":"e2","namespace":"default"},"type":"Warning","reason":"BackOff","involvedObject":{"kind":"Pod","name":"app-0"},"message":"back-off restarting","lastTimestamp":"2020-06-01T10:05:00Z"},
+{"kind":"Event","metadata":{"name":"e1","namespace":"default"},"type":"Normal","reason":"Scheduled","involvedObject":{"kind":"Pod","name":"app-0"},"message":"assigned","lastTimestamp":"2020-06-01T10:00:00Z"}
+]}`
+
+func TestServer(t *testing.T) {
+	root, err := ioutil.TempDir("", "crashd-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	b, err := Open(makeBundle(t, root, "crashd", "bundle.tar.gz", map[string]string{
+		"10_0_0_1/df.txt":                       "/dev/sda1 80%",
+		"kubecapture/default/pods.json":         podsBefore,
+		"kubecapture/default/deployments.json":  deployments,
+		"kubecapture/default/events.json":       events,
+		"kubecapture/default/app-0/app/app.log": "started\npanic: nil map\nexiting",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	view := NewView(b)
+	if strings.Join(view.Namespaces, ",") != "default" || strings.Join(view.Nodes, ",") != "10_0_0_1" {
+		t.Fatalf("unexpected namespaces %v or nodes %v", view.Namespaces, view.Nodes)
+	}
+	if len(view.Workloads["default"]) != 3 || view.Workloads["default"][0].Status != "2/3 ready" {
+		t.Errorf("unexpected workloads: %#v", view.Workloads["default"])
+	}
+	if len(view.Events) != 2 || view.Events[0].Reason != "Scheduled" {
+		t.Errorf("unexpected events: %#v", view.Events)
+	}
+
+	server, err := NewServer(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url      string
+		status   int
+		expected []string
+	}{
+		{url: "/", status: http.StatusOK, expected: []string{`href="/namespaces/default"`, `href="/nodes/10_0_0_1"`}},
+		{url: "/namespaces/default", status: http.StatusOK, expected: []string{"Deployment", "2/3 ready", "kubecapture/default/app-0/app/app.log"}},
+		{url: "/namespaces/missing", status: http.StatusNotFound},
+		{url: "/nodes/10_0_0_1", status: http.StatusOK, expected: []string{"10_0_0_1/df.txt"}},
+		{url: "/events?namespace=default", status: http.StatusOK, expected: []string{"BackOff", "Pod/app-0"}},
+		{url: "/files/10_0_0_1/df.txt", status: http.StatusOK, expected: []string{"/dev/sda1 80%"}},
+		{url: "/files/kubecapture/default/app-0/app/app.log?q=panic", status: http.StatusOK, expected: []string{"1 matching lines", "2: panic: nil map"}},
+		{url: "/files/missing.txt", status: http.StatusNotFound},
+		{url: "/search?q=panic", status: http.StatusOK, expected: []string{"kubecapture/default/app-0/app/app.log</a>:2"}},
+		{url: "/search?q=(", status: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.url, nil))
+			if rec.Code != test.status {
+				t.Fatalf("expecting status %d, got %d", test.status, rec.Code)
+			}
+			for _, s := range test.expected {
+				if !strings.Contains(rec.Body.String(), s) {
+					t.Errorf("page missing %q:\n%s", s, rec.Body.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package bundle
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// KubeDir is the directory of the bundle where kube_capture saves API objects and pod logs
+const KubeDir = "kubecapture"
+
+// workloadKinds are the kinds of objects listed as the workloads of a namespace
+var workloadKinds = map[string]bool{
+	"Deployment":  true,
+	"StatefulSet": true,
+	"DaemonSet":   true,
+	"ReplicaSet":  true,
+	"Job":         true,
+	"CronJob":     true,
+	"Pod":         true,
+}
+
+// Object is an API object captured in the bundle
+type Object struct {
+	Kind      string
+	Namespace string
+	Name      string
+	// Status summarizes the state of the object (i.e. the phase of a pod, the ready replicas of a deployment)
+	Status string
+	// File is the manifest the object was read from
+	File string
+}
+
+// Event is a Kubernetes event captured in the bundle
+type Event struct {
+	Time      string
+	Namespace string
+	Type      string
+	Reason    string
+	// Object is the involved object as kind/name
+	Object  string
+	Message string
+}
+
+// View is the content of a bundle organized for browsing: the workloads and pod logs of each
+// namespace, the events, and the command outputs of each node
+type View struct {
+	Bundle     *Bundle
+	Namespaces []string
+	// Workloads and Logs are keyed by namespace
+	Workloads map[string][]Object
+	Logs      map[string][]string
+	// Events are sorted by time
+	Events []Event
+	Nodes  []string
+	// NodeFiles are the command outputs and files copied from each node, keyed by node directory
+	NodeFiles map[string][]string
+}
+
+// NewView organizes the files of b. Manifests are read from the content kept in memory,
+// so manifests larger than MaxContentSize are only listed as files.
+func NewView(b *Bundle) *View {
+	v := &View{
+		Bundle:    b,
+		Workloads: make(map[string][]Object),
+		Logs:      make(map[string][]string),
+		NodeFiles: make(map[string][]string),
+	}
+	namespaces := make(map[string]bool)
+
+	for _, name := range b.Names() {
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) < 2 {
+			continue
+		}
+		if parts[0] != KubeDir {
+			if _, ok := v.NodeFiles[parts[0]]; !ok {
+				v.Nodes = append(v.Nodes, parts[0])
+			}
+			v.NodeFiles[parts[0]] = append(v.NodeFiles[parts[0]], name)
+			continue
+		}
+
+		// kubecapture/<namespace>/<pod>/<container>/<container>.log
+		if path.Ext(name) == ".log" {
+			ns := strings.SplitN(parts[1], "/", 2)[0]
+			namespaces[ns] = true
+			v.Logs[ns] = append(v.Logs[ns], name)
+			continue
+		}
+
+		objects, ok := ParseObjects(name, b.Files[name].Data)
+		if !ok {
+			continue
+		}
+		for _, obj := range objects {
+			v.addObject(name, obj, namespaces)
+		}
+	}
+
+	for ns := range namespaces {
+		v.Namespaces = append(v.Namespaces, ns)
+	}
+	sort.Strings(v.Namespaces)
+	for _, objs := range v.Workloads {
+		sort.Slice(objs, func(i, j int) bool {
+			if objs[i].Kind != objs[j].Kind {
+				return objs[i].Kind < objs[j].Kind
+			}
+			return objs[i].Name < objs[j].Name
+		})
+	}
+	sort.SliceStable(v.Events, func(i, j int) bool { return v.Events[i].Time < v.Events[j].Time })
+	return v
+}
+
+func (v *View) addObject(file string, obj map[string]interface{}, namespaces map[string]bool) {
+	kind, _ := obj["kind"].(string)
+	ns := stringField(obj, "metadata", "namespace")
+	name := stringField(obj, "metadata", "name")
+
+	if kind == "Event" {
+		event := Event{
+			Time:      firstField(obj, []string{"lastTimestamp"}, []string{"eventTime"}, []string{"firstTimestamp"}),
+			Namespace: ns,
+			Type:      stringField(obj, "type"),
+			Reason:    stringField(obj, "reason"),
+			Object:    stringField(obj, "involvedObject", "kind") + "/" + stringField(obj, "involvedObject", "name"),
+			Message:   stringField(obj, "message"),
+		}
+		v.Events = append(v.Events, event)
+		if len(ns) > 0 {
+			namespaces[ns] = true
+		}
+		return
+	}
+
+	if !workloadKinds[kind] || len(ns) == 0 {
+		return
+	}
+	namespaces[ns] = true
+	v.Workloads[ns] = append(v.Workloads[ns], Object{
+		Kind:      kind,
+		Namespace: ns,
+		Name:      name,
+		Status:    objectStatus(kind, obj),
+		File:      file,
+	})
+}
+
+// objectStatus summarizes the state of a workload
+func objectStatus(kind string, obj map[string]interface{}) string {
+	switch kind {
+	case "Pod":
+		return stringField(obj, "status", "phase")
+	case "Deployment", "StatefulSet", "ReplicaSet":
+		return fmt.Sprintf("%v/%v ready", numberField(obj, "status", "readyReplicas"), numberField(obj, "spec", "replicas"))
+	case "DaemonSet":
+		return fmt.Sprintf("%v/%v ready", numberField(obj, "status", "numberReady"), numberField(obj, "status", "desiredNumberScheduled"))
+	case "Job":
+		return fmt.Sprintf("%v succeeded, %v failed", numberField(obj, "status", "succeeded"), numberField(obj, "status", "failed"))
+	}
+	return ""
+}
+
+// field returns the value at the path of fields of obj, or nil
+func field(obj map[string]interface{}, fields ...string) interface{} {
+	var val interface{} = obj
+	for _, f := range fields {
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		val = m[f]
+	}
+	return val
+}
+
+func stringField(obj map[string]interface{}, fields ...string) string {
+	s, _ := field(obj, fields...).(string)
+	return s
+}
+
+// numberField returns the number at the path of fields of obj, or 0
+func numberField(obj map[string]interface{}, fields ...string) interface{} {
+	switch n := field(obj, fields...).(type) {
+	case float64:
+		return int64(n)
+	case int64:
+		return n
+	}
+	return 0
+}
+
+// firstField returns the first non-empty string of the paths of fields
+func firstField(obj map[string]interface{}, paths ...[]string) string {
+	for _, fields := range paths {
+		if s := stringField(obj, fields...); len(s) > 0 {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// bashCompletionFunc completes the keys of run --args with the arguments declared by the
+// script file of the command line (see run --list-args)
+const bashCompletionFunc = `__crashd_script_args()
+{
+    local script word
+    for word in "${words[@]}"; do
+        if [[ "${word}" != -* && -f "${word}" ]]; then
+            script="${word}"
+        fi
+    done
+    if [[ -z "${script}" ]]; then
+        return
+    fi
+    local prefix="${cur%,*}"
+    if [[ "${prefix}" == "${cur}" ]]; then
+        prefix=""
+    else
+        prefix="${prefix},"
+    fi
+    local keys
+    keys=$(crashd run "${script}" --list-args 2>/dev/null | sed "s/^/${prefix}/; s/$/=/")
+    COMPREPLY=( $(compgen -W "${keys}" -- "${cur}") )
+    compopt -o nospace 2>/dev/null
+}
+`
+
+// newCompletionCommand creates a command generating shell completion scripts
+func newCompletionCommand() *cobra.Command {
+	return &cobra.Command{
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{"bash", "zsh"},
+		Use:       "completion <bash|zsh>",
+		Short:     "Generates shell completion scripts",
+		Long:      "Generates the completion script of a shell, to be sourced (i.e. source <(crashd completion bash)). The bash completion also suggests the --args keys declared by the script of the command line.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch args[0] {
+			case "bash":
+				return cmd.Root().GenBashCompletion(os.Stdout)
+			case "zsh":
+				return cmd.Root().GenZshCompletion(os.Stdout)
+			default:
+				return fmt.Errorf("unsupported shell %s (supported: bash, zsh)", args[0])
+			}
+		},
+	}
+}
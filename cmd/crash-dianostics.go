@@ -19,12 +19,13 @@ const (
 
 // globalFlags flags for the command
 type globalFlags struct {
-	debug bool
+	debug     bool
+	logFormat string
 }
 
 // crashDiagnosticsCommand creates a main cli command
 func crashDiagnosticsCommand() *cobra.Command {
-	flags := &globalFlags{debug: false}
+	flags := &globalFlags{debug: false, logFormat: "text"}
 	cmd := &cobra.Command{
 		Args:  cobra.NoArgs,
 		Use:   CliName,
@@ -33,8 +34,9 @@ func crashDiagnosticsCommand() *cobra.Command {
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			return preRun(flags)
 		},
-		SilenceUsage: true,
-		Version:      buildinfo.Version,
+		SilenceUsage:           true,
+		Version:                buildinfo.Version,
+		BashCompletionFunction: bashCompletionFunc,
 	}
 
 	cmd.PersistentFlags().BoolVar(
@@ -43,8 +45,18 @@ func crashDiagnosticsCommand() *cobra.Command {
 		flags.debug,
 		"sets log level to debug",
 	)
+	cmd.PersistentFlags().StringVar(
+		&flags.logFormat,
+		"log-format",
+		flags.logFormat,
+		"log format: text or json (one JSON object per line, with the fields of progress reports)",
+	)
 
 	cmd.AddCommand(newRunCommand())
+	cmd.AddCommand(newDiffCommand())
+	cmd.AddCommand(newInspectCommand())
+	cmd.AddCommand(newServeBundleCommand())
+	cmd.AddCommand(newCompletionCommand())
 	cmd.AddCommand(newBuildinfoCommand())
 	return cmd
 }
@@ -56,6 +68,14 @@ func preRun(flags *globalFlags) error {
 	}
 	logrus.SetLevel(level)
 
+	switch flags.logFormat {
+	case "text":
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("unsupported log format %q: expecting text or json", flags.logFormat)
+	}
+
 	return nil
 }
 
@@ -0,0 +1,60 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/vmware-tanzu/crash-diagnostics/bundle"
+)
+
+// newDiffCommand creates a command comparing two bundles
+func newDiffCommand() *cobra.Command {
+	var output string
+	var excludes []string
+
+	cmd := &cobra.Command{
+		Args:  cobra.ExactArgs(2),
+		Use:   "diff <bundle-a> <bundle-b>",
+		Short: "Compares two diagnostics bundles",
+		Long:  "Compares two bundles (archives or working directories) and reports the files and API objects changed, added, or removed from the first to the second",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return diff(args[0], args[1], output, excludes)
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "text", "output format of the report: text or json")
+	cmd.Flags().StringSliceVar(&excludes, "exclude", nil, "comma-separated globs of files to ignore (i.e. '*.log')")
+	return cmd
+}
+
+func diff(pathA, pathB, output string, excludes []string) error {
+	if output != "text" && output != "json" {
+		return fmt.Errorf("unsupported output format %s", output)
+	}
+
+	a, err := bundle.Open(pathA)
+	if err != nil {
+		return err
+	}
+	b, err := bundle.Open(pathB)
+	if err != nil {
+		return err
+	}
+
+	report, err := bundle.Diff(a, b, excludes)
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	return report.WriteText(os.Stdout)
+}
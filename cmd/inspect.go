@@ -0,0 +1,167 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/spf13/cobra"
+
+	"github.com/vmware-tanzu/crash-diagnostics/bundle"
+)
+
+// newInspectCommand creates a command browsing a bundle without unpacking it
+func newInspectCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "inspect",
+		Short: "Browses a diagnostics bundle",
+		Long:  "Lists, prints, extracts, or searches the files of a bundle (archive or working directory) without unpacking it",
+	}
+	cmd.AddCommand(newInspectListCommand())
+	cmd.AddCommand(newInspectProvenanceCommand())
+	cmd.AddCommand(newInspectExtractCommand())
+	cmd.AddCommand(newInspectGrepCommand())
+	cmd.AddCommand(newInspectNetpolCommand())
+	return cmd
+}
+
+func newInspectListCommand() *cobra.Command {
+	return &cobra.Command{
+		Args:  cobra.ExactArgs(1),
+		Use:   "list <bundle>",
+		Short: "Lists the files of a bundle with their size",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return bundle.Walk(args[0], func(name string, size int64, _ io.Reader) error {
+				_, err := fmt.Fprintf(os.Stdout, "%12d  %s\n", size, name)
+				return err
+			})
+		},
+	}
+}
+
+func newInspectProvenanceCommand() *cobra.Command {
+	return &cobra.Command{
+		Args:  cobra.ExactArgs(1),
+		Use:   "provenance <bundle>",
+		Short: "Prints the provenance manifest of a bundle",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return bundle.Extract(args[0], "provenance.yaml", os.Stdout)
+		},
+	}
+}
+
+func newInspectExtractCommand() *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Args:  cobra.ExactArgs(2),
+		Use:   "extract <bundle> <file>",
+		Short: "Extracts a single file of a bundle",
+		Long:  "Extracts a single file of a bundle, named as printed by inspect list, to stdout or to the file set with --output",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return extract(args[0], args[1], output)
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "file to write the extracted content to (default stdout)")
+	return cmd
+}
+
+func extract(bundlePath, name, output string) error {
+	if len(output) == 0 {
+		return bundle.Extract(bundlePath, name, os.Stdout)
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := bundle.Extract(bundlePath, name, file); err != nil {
+		file.Close()
+		os.Remove(output)
+		return err
+	}
+	return file.Close()
+}
+
+func newInspectGrepCommand() *cobra.Command {
+	var files []string
+	var ignoreCase bool
+
+	cmd := &cobra.Command{
+		Args:  cobra.ExactArgs(2),
+		Use:   "grep <bundle> <pattern>",
+		Short: "Searches the text files of a bundle",
+		Long:  "Prints the lines of the text files of a bundle matching a regular expression, as file:line:text",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return grep(args[0], args[1], files, ignoreCase)
+		},
+	}
+	cmd.Flags().StringSliceVar(&files, "files", nil, "comma-separated globs of files to search (i.e. '*.log')")
+	cmd.Flags().BoolVarP(&ignoreCase, "ignore-case", "i", false, "ignores case when matching the pattern")
+	return cmd
+}
+
+func grep(bundlePath, pattern string, files []string, ignoreCase bool) error {
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %s", err)
+	}
+
+	matches := 0
+	if err := bundle.Grep(bundlePath, re, files, func(m bundle.Match) error {
+		matches++
+		_, err := fmt.Fprintf(os.Stdout, "%s:%d:%s\n", m.Name, m.Line, m.Text)
+		return err
+	}); err != nil {
+		return err
+	}
+	if matches == 0 {
+		return fmt.Errorf("no match for %s", pattern)
+	}
+	return nil
+}
+
+func newInspectNetpolCommand() *cobra.Command {
+	var namespaces []string
+	var selector, output string
+
+	cmd := &cobra.Command{
+		Args:  cobra.ExactArgs(1),
+		Use:   "netpol <bundle>",
+		Short: "Computes the network policy connectivity matrix of a bundle",
+		Long:  "Computes, from the NetworkPolicies, Namespaces, and pods of a bundle, the connectivity allowed between workloads and prints it as a CSV or HTML matrix",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return netpol(args[0], namespaces, selector, output)
+		},
+	}
+	cmd.Flags().StringSliceVarP(&namespaces, "namespaces", "n", nil, "comma-separated namespaces of the workloads (default all)")
+	cmd.Flags().StringVarP(&selector, "selector", "l", "", "label selector of the pods of the workloads (i.e. 'app=web')")
+	cmd.Flags().StringVarP(&output, "output", "o", "csv", "output format of the matrix: csv or html")
+	return cmd
+}
+
+func netpol(bundlePath string, namespaces []string, selector, output string) error {
+	if output != "csv" && output != "html" {
+		return fmt.Errorf("unsupported output format %s", output)
+	}
+
+	b, err := bundle.Open(bundlePath)
+	if err != nil {
+		return err
+	}
+	matrix, err := bundle.NetworkPolicyMatrix(b, namespaces, selector)
+	if err != nil {
+		return err
+	}
+	if output == "html" {
+		return matrix.WriteHTML(os.Stdout)
+	}
+	return matrix.WriteCSV(os.Stdout)
+}
@@ -4,32 +4,162 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+
+	"github.com/vmware-tanzu/crash-diagnostics/archiver"
 	"github.com/vmware-tanzu/crash-diagnostics/exec"
+	"github.com/vmware-tanzu/crash-diagnostics/policy"
 )
 
 // newRunCommand creates a command to run the Diagnostics script a file
 func newRunCommand() *cobra.Command {
 	scriptArgs := make(map[string]string)
+	var opts exec.Options
+	var defaults, listArgs bool
+	var policyFile string
+	var timeout time.Duration
 
 	cmd := &cobra.Command{
-		Args:  cobra.ExactArgs(1),
+		Args:  cobra.MaximumNArgs(1),
 		Use:   "run <file-name>",
 		Short: "Executes a diagnostics script file",
-		Long:  "Executes a diagnostics script and collects its output as an archive bundle",
+		Long:  "Executes a diagnostics script, or the built-in default script with --defaults, and collects its output as an archive bundle",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return run(scriptArgs, args[0])
+			p, err := loadPolicy(policyFile)
+			if err != nil {
+				return err
+			}
+			opts.Policy = p
+			if opts.Config.Output == archiver.Stdout {
+				// keep stdout for the archive stream
+				logrus.SetOutput(os.Stderr)
+			}
+			if defaults && len(args) > 0 {
+				return fmt.Errorf("--defaults cannot be used with a script file")
+			}
+			if !defaults && len(args) == 0 {
+				return fmt.Errorf("a script file is required (or --defaults to run the built-in default script)")
+			}
+			if listArgs && !defaults {
+				return listScriptArgs(args[0])
+			}
+
+			ctx, stop := runContext(timeout)
+			defer stop()
+			if defaults {
+				return runDefaults(ctx, scriptArgs, opts)
+			}
+			return run(ctx, scriptArgs, args[0], opts)
 		},
 	}
-	cmd.Flags().StringToStringVar(&scriptArgs, "args", scriptArgs, "comma-separated key=value arguments to pass to the diagnostics file")
+	defaultHelp := cmd.HelpFunc()
+	cmd.SetHelpFunc(func(cmd *cobra.Command, args []string) {
+		defaultHelp(cmd, args)
+		if cmd.Flags().NArg() > 0 {
+			printScriptArgs(cmd.OutOrStdout(), cmd.Flags().Arg(0))
+		}
+	})
+	cmd.Flags().BoolVar(&defaults, "defaults", false, "run the built-in default script collecting cluster objects, events, failing pod logs, and, with --args ssh_pk_path=<key>, node facts and logs")
+	cmd.Flags().StringToStringVar(&scriptArgs, "args", scriptArgs, "comma-separated key=value arguments to pass to the diagnostics file (see crashd run <file-name> --help for the arguments declared by the script)")
+	cmd.Flags().SetAnnotation("args", cobra.BashCompCustom, []string{"__crashd_script_args"})
+	cmd.Flags().BoolVar(&listArgs, "list-args", false, "print the names of the arguments declared by the script, used by shell completion")
+	cmd.Flags().MarkHidden("list-args")
+	cmd.Flags().BoolVar(&opts.Tee, "tee", false, "print the output of run and capture commands to the console as they complete")
+	cmd.Flags().StringSliceVar(&opts.Only, "only", nil, "comma-separated tags of the script steps to execute (untagged steps are skipped)")
+	cmd.Flags().StringSliceVar(&opts.Skip, "skip", nil, "comma-separated tags of the script steps to skip")
+	cmd.Flags().BoolVar(&opts.NonInteractive, "non-interactive", false, "disable prompts: prompt() returns its default and confirm() returns False, or fails when its default is True (see --yes)")
+	cmd.Flags().BoolVarP(&opts.AssumeYes, "yes", "y", false, "answer yes to confirm() when prompts are disabled")
+	cmd.Flags().DurationVar(&opts.TimeBudget, "time-budget", 0, "expected duration of the run (i.e. 10m): steps with priority low are skipped when less than 20% of it remains, steps with priority normal once it is exhausted")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "maximum duration of the run (i.e. 30m): once exceeded, the run is stopped like with Ctrl-C, and what was collected is archived")
+	cmd.Flags().DurationVar(&opts.ProgressInterval, "progress-interval", 30*time.Second, "interval at which the progress of long-running copy_from and kube_capture steps (elapsed time, files and bytes collected) is reported; 0 disables the reports")
+	cmd.Flags().BoolVar(&opts.Lock, "lock", false, "prevent concurrent runs of the script against the same cluster, with a lock file on this machine and, except with --read-only, a Lease in the kube-system namespace of the cluster")
+	cmd.Flags().BoolVar(&opts.Force, "force", false, "run the script even when --lock finds it already running")
+	cmd.Flags().BoolVar(&opts.FailFast, "fail-fast", false, "stop the script at the first step that fails on any host (by default, host errors are saved in errors.json and the script continues)")
+	cmd.Flags().BoolVar(&opts.ReadOnly, "read-only", false, "reject the run, run_local, capture, and capture_local commands that can mutate hosts or clusters (rm, systemctl restart, kubectl delete, output redirected to files, etc)")
+	cmd.Flags().StringSliceVar(&opts.DenyList, "deny", nil, "comma-separated commands (i.e. \"kubectl exec,curl -X\") rejected with --read-only, in addition to the default deny list")
+	cmd.Flags().StringVar(&policyFile, "policy", "", fmt.Sprintf("policy file restricting the transports, namespaces, commands, and bundle size of the script (default %s, when it exists)", policy.DefaultFile))
+	cmd.Flags().StringVar(&opts.OTelEndpoint, "otel-endpoint", "", "OTLP/HTTP endpoint (i.e. http://localhost:4318) receiving a trace of the run, with a span per built-in call and per host command; headers are read from OTEL_EXPORTER_OTLP_HEADERS")
+	cmd.Flags().StringVar(&opts.MetricsAddr, "metrics-addr", "", "address (i.e. :9090) on which Prometheus metrics are served, on /metrics, during the run")
+	cmd.Flags().StringVar(&opts.MetricsFile, "metrics-file", "", "file to which Prometheus metrics are written at the end of the run (i.e. for the node_exporter textfile collector)")
+	cmd.Flags().StringVar(&opts.Config.Workdir, "workdir", "", "working directory, overriding crashd_config(workdir=...) of the script")
+	cmd.Flags().StringVar(&opts.Config.UID, "uid", "", "user ID recorded for local commands (they run as the current user), overriding crashd_config(uid=...) of the script")
+	cmd.Flags().StringVar(&opts.Config.GID, "gid", "", "group ID recorded for local commands (they run as the current user), overriding crashd_config(gid=...) of the script")
+	cmd.Flags().StringVar(&opts.Config.KubeContext, "kube-context", "", "kubeconfig context used by the script, overriding kube_config(context=...) of the script")
+	cmd.Flags().StringVarP(&opts.Config.Output, "output", "o", "", "archive file created, overriding archive(output_file=...) of the script; with -, the archive is written to stdout and the console output to stderr")
+	cmd.Flags().StringSliceVar(&opts.PluginDirs, "plugin-dir", []string{defaultPluginDir()}, "directories searched for crashd-plugin-* executables providing additional built-ins")
 	return cmd
 }
 
-func run(scriptArgs map[string]string, path string) error {
+// defaultPluginDir returns $HOME/.crashd/plugins (%USERPROFILE%\.crashd\plugins on Windows)
+func defaultPluginDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.Getenv("HOME")
+	}
+	return filepath.Join(home, ".crashd", "plugins")
+}
+
+// loadPolicy reads the policy file, or the default policy file when path is empty
+func loadPolicy(path string) (*policy.Policy, error) {
+	if len(path) == 0 {
+		return policy.LoadDefault()
+	}
+	return policy.Load(path)
+}
+
+// runContext returns the context of a run, canceled on the first interrupt (Ctrl-C) or termination signal, or
+// once timeout, when set, is exceeded: the script stops at its next step, and what was collected is archived.
+// A second signal exits immediately, without cleanup. stop releases the context and the signal handler.
+func runContext(timeout time.Duration) (ctx context.Context, stop func()) {
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			logrus.Warnf("%s: stopping the run and archiving what was collected (repeat to exit immediately)", sig)
+			cancel()
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				logrus.Warnf("run timeout (%s) exceeded: stopping the run and archiving what was collected", timeout)
+			}
+		case <-done:
+			return
+		}
+		select {
+		case sig := <-signals:
+			logrus.Errorf("%s: exiting without cleanup", sig)
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+}
+
+func run(ctx context.Context, scriptArgs map[string]string, path string, opts exec.Options) error {
 	file, err := os.Open(path)
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("script file not found: %s", path))
@@ -37,9 +167,59 @@ func run(scriptArgs map[string]string, path string) error {
 
 	defer file.Close()
 
-	if err := exec.ExecuteFile(file, scriptArgs); err != nil {
+	if _, err := exec.Run(ctx, exec.Script{Name: file.Name(), Source: file}, scriptArgs, opts); err != nil {
 		return errors.Wrap(err, fmt.Sprintf("execution failed for %s", file.Name()))
 	}
 
 	return nil
 }
+
+// listScriptArgs prints the names of the arguments declared by the script, one per line
+func listScriptArgs(path string) error {
+	specs, err := exec.ScriptArgs(exec.Script{Name: path})
+	if err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		fmt.Println(spec.Name)
+	}
+	return nil
+}
+
+// printScriptArgs prints the arguments declared by the script, after the help of the run command
+func printScriptArgs(w io.Writer, path string) {
+	specs, err := exec.ScriptArgs(exec.Script{Name: path})
+	if err != nil {
+		fmt.Fprintf(w, "\nFailed to read the arguments of %s: %s\n", path, err)
+		return
+	}
+	if len(specs) == 0 {
+		fmt.Fprintf(w, "\nScript %s does not declare arguments (see declare_args())\n", path)
+		return
+	}
+
+	fmt.Fprintf(w, "\nScript arguments of %s (--args name=value,...):\n", path)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, spec := range specs {
+		desc := spec.Help
+		switch {
+		case spec.Required:
+			desc += " (required)"
+		case len(spec.Default) > 0 && spec.Type == "string":
+			desc += fmt.Sprintf(" (default %q)", spec.Default)
+		case len(spec.Default) > 0:
+			desc += fmt.Sprintf(" (default %s)", spec.Default)
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", spec.Name, spec.Type, strings.TrimSpace(desc))
+	}
+	tw.Flush()
+}
+
+// runDefaults executes the built-in default script
+func runDefaults(ctx context.Context, scriptArgs map[string]string, opts exec.Options) error {
+	script := exec.Script{Name: exec.DefaultScriptName, Source: strings.NewReader(exec.DefaultScript)}
+	if _, err := exec.Run(ctx, script, scriptArgs, opts); err != nil {
+		return errors.Wrap(err, "execution failed for the default script")
+	}
+	return nil
+}
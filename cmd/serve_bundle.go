@@ -0,0 +1,49 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/vmware-tanzu/crash-diagnostics/bundle"
+)
+
+// newServeBundleCommand creates a command serving a browsable view of a bundle
+func newServeBundleCommand() *cobra.Command {
+	var addr string
+
+	cmd := &cobra.Command{
+		Args:  cobra.ExactArgs(1),
+		Use:   "serve-bundle <bundle>",
+		Short: "Serves a web view of a diagnostics bundle",
+		Long:  "Starts a local web server to browse a bundle (archive or working directory): namespaces with their workloads and logs, the events timeline, node command outputs, and a search of the bundle files",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return serveBundle(args[0], addr)
+		},
+	}
+	cmd.Flags().StringVar(&addr, "addr", "localhost:8080", "address on which the bundle is served")
+	return cmd
+}
+
+func serveBundle(path, addr string) error {
+	b, err := bundle.Open(path)
+	if err != nil {
+		return err
+	}
+	server, err := bundle.NewServer(b)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("serving bundle %s on http://%s", path, listener.Addr())
+	return http.Serve(listener, server)
+}
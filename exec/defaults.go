@@ -0,0 +1,89 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package exec
+
+// DefaultScriptName identifies the built-in default script in errors and in the run provenance
+const DefaultScriptName = "crashd-defaults.crsh"
+
+// DefaultScript is the built-in script executed by `crashd run --defaults`. It collects the
+// API objects and events of all namespaces, the logs of failing pods and, when an SSH key is
+// provided, the facts and the kubelet and containerd logs of the cluster nodes.
+const DefaultScript = `# Built-in default diagnostics script (crashd run --defaults).
+#
+# Script arguments (--args), all optional:
+#   kubecfg      the kubeconfig of the cluster (default $HOME/.kube/config)
+#   workdir      the working directory (default /tmp/crashd, crashd in the temporary directory on Windows)
+#   output       the archive created (default crashd-defaults.tar.gz)
+#   ssh_pk_path  the SSH private key of the nodes; node facts and logs are only collected when set
+#   ssh_user     the SSH user of the nodes (default the current user)
+#   ssh_port     the SSH port of the nodes (default 22)
+#   since        the period of node logs collected (default 24h)
+
+def arg(name, default):
+    if hasattr(args, name):
+        return getattr(args, name)
+    return default
+
+conf = crashd_config(workdir=arg("workdir", ""))
+kube = kube_config(path=arg("kubecfg", "{0}/.kube/config".format(os.home)))
+set_defaults(kube)
+
+# API objects, including events, of all namespaces
+kube_capture(what="objects", namespaces=["*"])
+
+def is_failing(pod):
+    status = getattr(pod, "status", None)
+    if status == None:
+        return False
+    if getattr(status, "phase", "") not in ["Running", "Succeeded"]:
+        return True
+    for container in getattr(status, "containerStatuses", []):
+        if not getattr(container, "ready", False) or getattr(container, "restartCount", 0) > 0:
+            return True
+    return False
+
+def capture_failing_pod_logs():
+    pods = kube_get(kinds=["pods"], namespaces=["*"])
+    failing = {}
+    for result in pods.objs:
+        for pod in result.List.Items:
+            if is_failing(pod):
+                ns = pod.metadata.namespace
+                failing[ns] = failing.get(ns, []) + [pod.metadata.name]
+    for ns in failing:
+        kube_capture(what="logs", namespaces=[ns], names=failing[ns])
+    return failing
+
+failing_pods = capture_failing_pod_logs()
+
+def capture_nodes():
+    ssh = ssh_config(
+        username=arg("ssh_user", os.username),
+        private_key_path=args.ssh_pk_path,
+        port=arg("ssh_port", "22"),
+    )
+    nodes = resources(provider=kube_nodes_provider(kube_config=kube, ssh_config=ssh))
+    since = arg("since", "24h")
+
+    capture(cmd="uname -a", resources=nodes)
+    capture(cmd="uptime", resources=nodes)
+    capture(cmd="df -h", resources=nodes)
+    capture(cmd="free -m", resources=nodes)
+    capture(cmd="ip addr", resources=nodes)
+    capture(cmd="ip route", resources=nodes)
+    capture(cmd="ps aux", resources=nodes)
+    capture(cmd="dmesg", resources=nodes)
+    capture(cmd="sudo journalctl -u kubelet --no-pager --since=-{0}".format(since), resources=nodes, file_name="kubelet.log")
+    capture(cmd="sudo journalctl -u containerd --no-pager --since=-{0}".format(since), resources=nodes, file_name="containerd.log")
+
+def capture_nodes_if_configured():
+    if hasattr(args, "ssh_pk_path"):
+        capture_nodes()
+    else:
+        print("ssh_pk_path not set, skipping node facts and logs (use --args ssh_pk_path=<key>)")
+
+capture_nodes_if_configured()
+
+archive(output_file=arg("output", "crashd-defaults.tar.gz"), source_paths=[conf.workdir])
+`
@@ -0,0 +1,24 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package exec
+
+import (
+	"testing"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+func TestDefaultScript(t *testing.T) {
+	file, err := syntax.Parse(DefaultScriptName, DefaultScript, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// built-ins are resolved at execution, the script must only be valid for the resolver
+	predeclared := func(name string) bool { return true }
+	if err := resolve.File(file, predeclared, starlark.Universe.Has); err != nil {
+		t.Fatal(err)
+	}
+}
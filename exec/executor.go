@@ -4,34 +4,248 @@
 package exec
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"sync"
+	"time"
 
+	"github.com/sirupsen/logrus"
+
+	"github.com/vmware-tanzu/crash-diagnostics/archiver"
+	"github.com/vmware-tanzu/crash-diagnostics/metrics"
+	"github.com/vmware-tanzu/crash-diagnostics/policy"
 	"github.com/vmware-tanzu/crash-diagnostics/starlark"
+	"github.com/vmware-tanzu/crash-diagnostics/tracing"
 )
 
 type ArgMap map[string]string
 
+// runMu serializes the runs: the process-wide state set up for a script (the command context and guard,
+// the audit log, the secrets, the tracer, the metrics, and the exec environment of the pods) is
+// not per-run
+var runMu sync.Mutex
+
+// Options are the run-wide settings applied when executing a script
+type Options struct {
+	// Tee streams the output of run and capture commands to stdout
+	Tee bool
+	// Only and Skip select the tagged steps to execute
+	Only []string
+	Skip []string
+	// PluginDirs are searched for exec plugins providing additional built-ins
+	PluginDirs []string
+	// NonInteractive disables the prompt and confirm built-ins
+	NonInteractive bool
+	// AssumeYes answers yes to the confirm built-in when prompts are disabled
+	AssumeYes bool
+	// OTelEndpoint is the OTLP/HTTP endpoint (i.e. http://localhost:4318) receiving the
+	// run traces. Tracing is disabled when empty.
+	OTelEndpoint string
+	// MetricsAddr is the address (i.e. :9090) on which metrics are served, on /metrics,
+	// during the run. Metrics are not served when empty.
+	MetricsAddr string
+	// MetricsFile is a file to which metrics are written at the end of the run
+	// (i.e. for the node_exporter textfile collector)
+	MetricsFile string
+	// FailFast stops the script at the first step reporting an error for one of its targets
+	FailFast bool
+	// TimeBudget, when set, is the expected duration of the run: low priority steps are skipped
+	// when it is nearly exhausted, and normal priority steps once it is exhausted
+	TimeBudget time.Duration
+	// ProgressInterval, when set, is the interval at which the progress of long-running steps
+	// (files and bytes collected, elapsed time) is logged and added to the run traces
+	ProgressInterval time.Duration
+	// ReadOnly rejects the commands of the script that match the deny list (starlark.DefaultDenyList
+	// and DenyList), so that diagnostic scripts cannot mutate the hosts or the cluster
+	ReadOnly bool
+	// DenyList are the commands rejected in read-only mode, in addition to the default deny list
+	DenyList []string
+	// Policy, when set, restricts the transports, namespaces, commands, and bundle size of the
+	// script (see policy.Load)
+	Policy *policy.Policy
+	// Lock prevents concurrent runs of the same script against the same cluster, with a lock file on this
+	// machine and a Lease in the cluster of the default kube config
+	Lock bool
+	// Force runs the script even when it is locked by another run
+	Force bool
+	// Config overrides the crashd_config() values, and the archive() output file, of the script
+	Config ConfigOverrides
+}
+
+// ConfigOverrides are crashd_config() values, and the archive() output file, that replace
+// the values declared by the script
+type ConfigOverrides = starlark.ConfigOverrides
+
 func Execute(name string, source io.Reader, args ArgMap) error {
+	return ExecuteWithOptions(name, source, args, Options{})
+}
+
+// ExecuteWithOptions executes the script using the specified run-wide options
+func ExecuteWithOptions(name string, source io.Reader, args ArgMap, opts Options) error {
+	_, err := Run(context.Background(), Script{Name: name, Source: source}, args, opts)
+	return err
+}
+
+// Script is a crashd script to execute
+type Script struct {
+	// Name identifies the script in errors and in the run provenance
+	Name string
+	// Source is the content of the script. When nil, the script is read from the file Name.
+	Source io.Reader
+}
+
+// StepResult is the outcome of a built-in function invoked by the script
+type StepResult = starlark.StepResult
+
+// Finding is a known problem recorded by the script with finding() or a failed assert()
+type Finding = starlark.Finding
+
+// StepError is an error reported by a step for the whole step or for one of its targets
+type StepError = starlark.StepError
+
+// ArgSpec is a script argument declared with declare_args()
+type ArgSpec = starlark.ArgSpec
+
+// Results are the outcome of a script execution
+type Results struct {
+	// Steps are the built-in invocations, in order
+	Steps []StepResult
+	// Globals are the global variables of the script, converted to Go values
+	Globals map[string]interface{}
+	// Errors are the errors reported by the steps, also saved in the errors.json
+	// file of the working directory
+	Errors []StepError
+	// Findings are the findings recorded by the script, also saved in the findings.json
+	// file of the working directory
+	Findings []Finding
+}
+
+// Run executes the script and returns the results of its steps. It is the entry point for
+// programs embedding crashd. The script stops at its next step once ctx is done, and what was collected
+// is archived (see starlark.Executor.ExecWithContext). On failure, the results of the steps executed so
+// far are returned along with the error.
+// Run is safe to call from several goroutines, but the scripts are not executed concurrently: a run
+// waits for the previous one to finish.
+func Run(ctx context.Context, script Script, args ArgMap, opts Options) (_ Results, runErr error) {
+	runMu.Lock()
+	defer runMu.Unlock()
+
+	source := script.Source
+	if source == nil {
+		file, err := os.Open(script.Name)
+		if err != nil {
+			return Results{}, fmt.Errorf("script file not found: %s", err)
+		}
+		defer file.Close()
+		source = file
+	}
+
+	if len(opts.MetricsAddr) > 0 {
+		stop, err := metrics.Serve(opts.MetricsAddr)
+		if err != nil {
+			return Results{}, err
+		}
+		defer stop()
+	}
+	start := time.Now()
+	defer func() {
+		metrics.RecordRun(time.Since(start), runErr)
+		if len(opts.MetricsFile) > 0 {
+			if err := metrics.WriteFile(opts.MetricsFile); err != nil {
+				logrus.Warnf("failed to write metrics file: %s", err)
+			}
+		}
+	}()
+
+	if len(opts.OTelEndpoint) > 0 {
+		tracer, err := tracing.NewTracer(opts.OTelEndpoint, "crashd run", tracing.ParseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")),
+			tracing.String("crashd.script", script.Name))
+		if err != nil {
+			return Results{}, err
+		}
+		logrus.Infof("tracing run to %s (trace ID %s)", opts.OTelEndpoint, tracer.TraceID())
+		tracing.SetTracer(tracer)
+		defer func() {
+			tracing.SetTracer(nil)
+			if err := tracer.Shutdown(runErr); err != nil {
+				logrus.Warnf("tracing: %s", err)
+			}
+		}()
+	}
+
 	star := starlark.New()
+	execOpts := makeExecOptions(opts)
+	execOpts.Args = args
+	star.SetOptions(execOpts)
 
 	if args != nil {
 		starStruct, err := starlark.NewGoValue(args).ToStarlarkStruct("args")
 		if err != nil {
-			return err
+			return Results{}, err
 		}
 
 		star.AddPredeclared("args", starStruct)
 	}
 
-	if err := star.Exec(name, source); err != nil {
-		return fmt.Errorf("exec failed: %s", err)
+	err := star.ExecWithContext(ctx, script.Name, source)
+	results := Results{Steps: star.Steps(), Globals: star.Globals(), Errors: star.Errors(), Findings: star.Findings()}
+	if err != nil {
+		return results, fmt.Errorf("exec failed: %s", err)
 	}
 
-	return nil
+	return results, nil
+}
+
+// ScriptArgs returns the arguments declared by the script with declare_args(), without executing it
+func ScriptArgs(script Script) ([]ArgSpec, error) {
+	source := script.Source
+	if source == nil {
+		file, err := os.Open(script.Name)
+		if err != nil {
+			return nil, fmt.Errorf("script file not found: %s", err)
+		}
+		defer file.Close()
+		source = file
+	}
+	return starlark.ScriptArgs(script.Name, source)
 }
 
 func ExecuteFile(file *os.File, args ArgMap) error {
 	return Execute(file.Name(), file, args)
 }
+
+// ExecuteFileWithOptions executes the script file using the specified run-wide options
+func ExecuteFileWithOptions(file *os.File, args ArgMap, opts Options) error {
+	return ExecuteWithOptions(file.Name(), file, args, opts)
+}
+
+func makeExecOptions(opts Options) starlark.ExecOptions {
+	execOpts := starlark.ExecOptions{
+		Only:             opts.Only,
+		Skip:             opts.Skip,
+		PluginDirs:       opts.PluginDirs,
+		NonInteractive:   opts.NonInteractive,
+		AssumeYes:        opts.AssumeYes,
+		FailFast:         opts.FailFast,
+		TimeBudget:       opts.TimeBudget,
+		ProgressInterval: opts.ProgressInterval,
+		ReadOnly:         opts.ReadOnly,
+		DenyList:         opts.DenyList,
+		Policy:           opts.Policy,
+		Lock:             opts.Lock,
+		Force:            opts.Force,
+		Config:           opts.Config,
+	}
+	// an archive streamed to stdout leaves the console output to stderr
+	var console io.Writer = os.Stdout
+	if opts.Config.Output == archiver.Stdout {
+		console = os.Stderr
+		execOpts.Console = console
+	}
+	if opts.Tee {
+		execOpts.Tee = console
+	}
+	return execOpts
+}
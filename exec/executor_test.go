@@ -4,8 +4,13 @@
 package exec
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/sirupsen/logrus"
@@ -134,3 +139,102 @@ func TestExecute(t *testing.T) {
 		})
 	}
 }
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		exec   func(t *testing.T, script string)
+	}{
+		{
+			name: "step results",
+			script: `
+greeting = run_local("echo " + args.name)
+count = 2
+`,
+			exec: func(t *testing.T, script string) {
+				results, err := Run(context.Background(), Script{Name: "test.star", Source: strings.NewReader(script)}, ArgMap{"name": "crashd"}, Options{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(results.Steps) != 1 {
+					t.Fatalf("expecting 1 step, got %d", len(results.Steps))
+				}
+				step := results.Steps[0]
+				if step.Name != "run_local" || step.Result != "crashd" || step.Err != nil {
+					t.Errorf("unexpected step result: %+v", step)
+				}
+				if results.Globals["greeting"] != "crashd" || results.Globals["count"] != int64(2) {
+					t.Errorf("unexpected globals: %v", results.Globals)
+				}
+			},
+		},
+		{
+			name:   "canceled context",
+			script: `run_local("echo one")`,
+			exec: func(t *testing.T, script string) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				results, err := Run(ctx, Script{Name: "test.star", Source: strings.NewReader(script)}, nil, Options{})
+				if err == nil || !strings.Contains(err.Error(), "context canceled") {
+					t.Fatalf("expecting canceled error, got %v", err)
+				}
+				if len(results.Steps) != 0 {
+					t.Errorf("expecting no steps, got %d", len(results.Steps))
+				}
+			},
+		},
+		{
+			name: "parallel runs",
+			script: `
+crashd_config(workdir=args.workdir)
+greeting = run("echo " + args.name, resources=resources(provider=local_provider())).result
+`,
+			exec: func(t *testing.T, script string) {
+				var wg sync.WaitGroup
+				names := []string{"one", "two"}
+				workdirs := make([]string, len(names))
+				errs := make([]error, len(names))
+				for i, name := range names {
+					workdir, err := ioutil.TempDir("", "crashd-run")
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer os.RemoveAll(workdir)
+					workdirs[i] = workdir
+
+					wg.Add(1)
+					go func(i int, name string) {
+						defer wg.Done()
+						results, err := Run(context.Background(), Script{Name: "test.star", Source: strings.NewReader(script)}, ArgMap{"name": name, "workdir": workdirs[i]}, Options{})
+						if err == nil && results.Globals["greeting"] != name {
+							err = fmt.Errorf("unexpected greeting %v", results.Globals["greeting"])
+						}
+						errs[i] = err
+					}(i, name)
+				}
+				wg.Wait()
+
+				// each run records its commands in the audit log of its own workdir
+				for i, name := range names {
+					if errs[i] != nil {
+						t.Fatalf("%s: %s", name, errs[i])
+					}
+					audit, err := ioutil.ReadFile(filepath.Join(workdirs[i], "audit.log"))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !strings.Contains(string(audit), "echo "+name) || strings.Contains(string(audit), "echo "+names[1-i]) {
+						t.Errorf("%s: unexpected audit log:\n%s", name, audit)
+					}
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.exec(t, test.script)
+		})
+	}
+}
@@ -4,9 +4,10 @@ go 1.12
 
 require (
 	github.com/imdario/mergo v0.3.7 // indirect
+	github.com/klauspost/compress v1.11.0
 	github.com/onsi/ginkgo v1.10.1
 	github.com/onsi/gomega v1.7.0
-	github.com/pkg/errors v0.8.0
+	github.com/pkg/errors v0.9.0
 	github.com/sirupsen/logrus v1.4.2
 	github.com/spf13/cobra v0.0.5
 	github.com/vladimirvivien/echo v0.0.1-alpha.6
@@ -14,10 +15,12 @@ require (
 	golang.org/x/crypto v0.0.0-20190611184440-5c40567a22f8
 	golang.org/x/sys v0.0.0-20200113162924-86b910548bc1 // indirect
 	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4 // indirect
-	gopkg.in/yaml.v2 v2.2.7 // indirect
+	gopkg.in/yaml.v2 v2.2.7
 	k8s.io/api v0.0.0-20190828114745-198695d0603e
 	k8s.io/apimachinery v0.17.0
 	k8s.io/cli-runtime v0.0.0-20190828120509-9a5048624be8
 	k8s.io/client-go v0.0.0-20190828114957-b4d94f01600c
 	k8s.io/utils v0.0.0-20190809000727-6c36bc71fc4a // indirect
+	sigs.k8s.io/kind v0.7.0 // indirect
+	sigs.k8s.io/yaml v1.1.0
 )
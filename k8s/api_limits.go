@@ -0,0 +1,100 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"sync"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// DefaultAPIWorkers is the default number of namespaces searched, or pod logs retrieved, concurrently
+const DefaultAPIWorkers = 8
+
+var (
+	apiLimitsMu sync.Mutex
+	apiWorkers  = DefaultAPIWorkers
+	apiQPS      float32
+)
+
+// SetAPILimits sets the number of concurrent requests (namespace lists and pod logs) and the rate of
+// requests, in queries per second, of the clients created afterwards. Bursts of twice the rate are
+// allowed. A qps of 0 keeps the client-go rate, 5 queries per second with bursts of 10.
+func SetAPILimits(workers int, qps float32) {
+	apiLimitsMu.Lock()
+	defer apiLimitsMu.Unlock()
+	if workers < 1 {
+		workers = 1
+	}
+	apiWorkers, apiQPS = workers, qps
+}
+
+// getAPIWorkers returns the number of concurrent requests set with SetAPILimits
+func getAPIWorkers() int {
+	apiLimitsMu.Lock()
+	defer apiLimitsMu.Unlock()
+	return apiWorkers
+}
+
+// setRateLimiter makes the configs share one rate limiter, so that the rate set with SetAPILimits
+// bounds the requests of all the clients created from them, however many workers send them
+func setRateLimiter(configs ...*rest.Config) {
+	apiLimitsMu.Lock()
+	qps, burst := apiQPS, int(2*apiQPS)
+	apiLimitsMu.Unlock()
+	if qps <= 0 {
+		qps, burst = rest.DefaultQPS, rest.DefaultBurst
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	limiter := flowcontrol.NewTokenBucketRateLimiter(qps, burst)
+	for _, config := range configs {
+		config.RateLimiter = limiter
+	}
+}
+
+// runWorkers calls fn with each index from 0 to n-1, with at most workers calls running at once
+func runWorkers(n, workers int, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// firstError returns the first error that is not nil, if any
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
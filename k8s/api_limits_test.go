@@ -0,0 +1,58 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/client-go/rest"
+)
+
+var _ = Describe("API limits", func() {
+	AfterEach(func() {
+		SetAPILimits(DefaultAPIWorkers, 0)
+	})
+
+	It("runs every index with at most the given workers", func() {
+		var mu sync.Mutex
+		var running, maxRunning int
+		done := make([]bool, 20)
+		runWorkers(len(done), 4, func(i int) {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			running--
+			done[i] = true
+			mu.Unlock()
+		})
+		Expect(maxRunning).To(BeNumerically(">", 1))
+		Expect(maxRunning).To(BeNumerically("<=", 4))
+		Expect(done).NotTo(ContainElement(false))
+	})
+
+	It("returns the first error", func() {
+		Expect(firstError([]error{nil, errors.New("second"), errors.New("third")})).To(MatchError("second"))
+		Expect(firstError(make([]error, 3))).To(Succeed())
+	})
+
+	It("shares one rate limiter between the configs", func() {
+		SetAPILimits(0, 50)
+		Expect(getAPIWorkers()).To(Equal(1))
+		dyn, core := &rest.Config{}, &rest.Config{}
+		setRateLimiter(dyn, core)
+		Expect(dyn.RateLimiter).NotTo(BeNil())
+		Expect(dyn.RateLimiter).To(BeIdenticalTo(core.RateLimiter))
+		Expect(dyn.RateLimiter.QPS()).To(BeNumerically("==", 50))
+	})
+})
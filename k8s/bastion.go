@@ -11,6 +11,9 @@ func FetchBastionIpAddress(clusterName, namespace, kubeConfigPath string) (strin
 	if namespace == "" {
 		namespace = "default"
 	}
+	if err := CheckNamespace(namespace); err != nil {
+		return "", err
+	}
 	p := echo.New().RunProc(fmt.Sprintf(
 		`kubectl get awscluster/%s -o jsonpath='{.status.bastion.publicIp}' --namespace %s --kubeconfig %s`,
 		clusterName,
@@ -0,0 +1,99 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// KindStats are the statistics of the captured objects of a kind
+type KindStats struct {
+	// Kind is the resource name, qualified by its group (i.e. deployments.apps)
+	Kind    string
+	Objects int
+	// Bytes is the size of the objects serialized as JSON
+	Bytes int64
+	// Omitted is the number of objects saved without their content (see LimitObjectSize)
+	Omitted int
+	// Duration is the time taken to list the objects
+	Duration time.Duration
+}
+
+// CaptureStats returns the statistics of the search results per kind, largest kinds first
+func CaptureStats(results []SearchResult) []KindStats {
+	byKind := make(map[string]*KindStats)
+	var kinds []string
+	for _, result := range results {
+		kind := result.GroupVersionResource.Resource
+		if len(result.GroupVersionResource.Group) > 0 {
+			kind = kind + "." + result.GroupVersionResource.Group
+		}
+		stats, ok := byKind[kind]
+		if !ok {
+			stats = &KindStats{Kind: kind}
+			byKind[kind] = stats
+			kinds = append(kinds, kind)
+		}
+		stats.Duration += result.Duration
+		if result.List == nil {
+			continue
+		}
+		for _, item := range result.List.Items {
+			stats.Objects++
+			if data, err := json.Marshal(item.Object); err == nil {
+				stats.Bytes += int64(len(data))
+			}
+			if _, ok := item.GetAnnotations()[OmittedSizeAnnotation]; ok {
+				stats.Omitted++
+			}
+		}
+	}
+
+	var stats []KindStats
+	for _, kind := range kinds {
+		stats = append(stats, *byKind[kind])
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Bytes != stats[j].Bytes {
+			return stats[i].Bytes > stats[j].Bytes
+		}
+		return stats[i].Kind < stats[j].Kind
+	})
+	return stats
+}
+
+// WriteCaptureStats writes the statistics as a table, with the totals on the last line
+func WriteCaptureStats(w io.Writer, stats []KindStats) error {
+	var total KindStats
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tOBJECTS\tSIZE\tOMITTED\tLIST DURATION")
+	for _, s := range stats {
+		total.Objects += s.Objects
+		total.Bytes += s.Bytes
+		total.Omitted += s.Omitted
+		total.Duration += s.Duration
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%s\n", s.Kind, s.Objects, FormatBytes(s.Bytes), s.Omitted, s.Duration.Round(time.Millisecond))
+	}
+	fmt.Fprintf(tw, "TOTAL\t%d\t%s\t%d\t%s\n", total.Objects, FormatBytes(total.Bytes), total.Omitted, total.Duration.Round(time.Millisecond))
+	return tw.Flush()
+}
+
+// FormatBytes returns size in a human readable unit (i.e. 1.5MiB)
+func FormatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var _ = Describe("CaptureStats", func() {
+	item := func(name string, omitted bool) unstructured.Unstructured {
+		obj := unstructured.Unstructured{Object: map[string]interface{}{"kind": "Thing", "data": strings.Repeat("x", 100)}}
+		obj.SetName(name)
+		if omitted {
+			obj.SetAnnotations(map[string]string{OmittedSizeAnnotation: "2048"})
+		}
+		return obj
+	}
+	result := func(group, resource string, duration time.Duration, items ...unstructured.Unstructured) SearchResult {
+		return SearchResult{
+			GroupVersionResource: schema.GroupVersionResource{Group: group, Version: "v1", Resource: resource},
+			List:                 &unstructured.UnstructuredList{Items: items},
+			Duration:             duration,
+		}
+	}
+
+	It("sums the objects of each kind, largest kinds first", func() {
+		stats := CaptureStats([]SearchResult{
+			result("", "configmaps", time.Second, item("a", false)),
+			result("apps", "deployments", time.Second, item("b", false), item("c", true)),
+			result("", "configmaps", 2*time.Second, item("d", false), item("e", false), item("f", false)),
+			result("", "secrets", time.Second),
+		})
+		Expect(stats).To(HaveLen(3))
+		Expect(stats[0].Kind).To(Equal("configmaps"))
+		Expect(stats[0].Objects).To(Equal(4))
+		Expect(stats[0].Duration).To(Equal(3 * time.Second))
+		Expect(stats[1].Kind).To(Equal("deployments.apps"))
+		Expect(stats[1].Omitted).To(Equal(1))
+		Expect(stats[1].Bytes).To(BeNumerically(">", 200))
+		Expect(stats[2]).To(Equal(KindStats{Kind: "secrets", Duration: time.Second}))
+	})
+
+	It("writes the statistics with their totals", func() {
+		var buf bytes.Buffer
+		Expect(WriteCaptureStats(&buf, []KindStats{
+			{Kind: "pods", Objects: 3, Bytes: 3072, Duration: time.Second},
+			{Kind: "services", Objects: 1, Bytes: 100, Omitted: 1},
+		})).To(Succeed())
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		Expect(lines).To(HaveLen(4))
+		Expect(strings.Fields(lines[1])).To(Equal([]string{"pods", "3", "3.0KiB", "0", "1s"}))
+		Expect(strings.Fields(lines[3])).To(Equal([]string{"TOTAL", "4", "3.1KiB", "1", "1s"}))
+	})
+
+	It("formats sizes", func() {
+		Expect(FormatBytes(512)).To(Equal("512B"))
+		Expect(FormatBytes(1536)).To(Equal("1.5KiB"))
+		Expect(FormatBytes(5 << 30)).To(Equal("5.0GiB"))
+	})
+})
@@ -4,7 +4,11 @@
 package k8s
 
 import (
+	"fmt"
+	"path"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
@@ -16,7 +20,8 @@ import (
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
 )
 
 const (
@@ -29,31 +34,35 @@ type Client struct {
 	Disco       discovery.DiscoveryInterface
 	CoreRest    rest.Interface
 	JsonPrinter printers.JSONPrinter
+	Host        string
+	// Config is the configuration of CoreRest, used for streaming requests (i.e. exec)
+	Config *rest.Config
 }
 
 // New returns a *Client
 func New(kubeconfig string) (*Client, error) {
 	// creating cfg for each client type because each
 	// setup its own cfg default which may not be compatible
-	dynCfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	dynCfg, err := buildConfig(kubeconfig)
 	if err != nil {
 		return nil, err
 	}
-	client, err := dynamic.NewForConfig(dynCfg)
+	discoCfg, err := buildConfig(kubeconfig)
 	if err != nil {
 		return nil, err
 	}
-
-	discoCfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	restCfg, err := buildConfig(kubeconfig)
 	if err != nil {
 		return nil, err
 	}
-	disco, err := discovery.NewDiscoveryClientForConfig(discoCfg)
+	// discovery keeps its own rate limit, so that listing objects does not slow discovery down
+	setRateLimiter(dynCfg, restCfg)
+
+	client, err := dynamic.NewForConfig(dynCfg)
 	if err != nil {
 		return nil, err
 	}
-
-	restCfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	disco, err := discovery.NewDiscoveryClientForConfig(discoCfg)
 	if err != nil {
 		return nil, err
 	}
@@ -63,7 +72,7 @@ func New(kubeconfig string) (*Client, error) {
 		return nil, err
 	}
 
-	return &Client{Client: client, Disco: disco, CoreRest: restc}, nil
+	return &Client{Client: client, Disco: newCachedDiscovery(disco, dynCfg.Host), CoreRest: restc, Host: dynCfg.Host, Config: restCfg}, nil
 }
 
 func (k8sc *Client) Search(params SearchParams) ([]SearchResult, error) {
@@ -73,7 +82,9 @@ func (k8sc *Client) Search(params SearchParams) ([]SearchResult, error) {
 		strings.Join(params.Versions, " "),
 		strings.Join(params.Names, " "),
 		strings.Join(params.Labels, " "),
-		strings.Join(params.Containers, " "))
+		strings.Join(params.Containers, " "),
+		strings.Join(params.ExcludeNamespaces, " "),
+		strings.Join(params.ExcludeKinds, " "))
 }
 
 // Search does a drill-down search from group, version, resourceList, to resources.  The following rules are applied
@@ -82,18 +93,28 @@ func (k8sc *Client) Search(params SearchParams) ([]SearchResult, error) {
 // 3) kinds will match resource.Kind or resource.Name
 // 4) All search params are passed as comma- or space-separated sets that are matched using OR (i.e. kinds=pods services
 //    will match resouces of type pods or services)
-func (k8sc *Client) _search(groups, kinds, namespaces, versions, names, labels, containers string) ([]SearchResult, error) {
+// 5) namespaces can be glob patterns (i.e. kube-*); namespaces=* lists each resource across all namespaces in one request
+// 6) namespaces prefixed with ! (i.e. !kube-node-lease), and excludeNamespaces, are glob patterns of namespaces excluded
+//    from the search
+// 7) excludeKinds are kinds, or resource names, excluded from the search (i.e. secrets events)
+func (k8sc *Client) _search(groups, kinds, namespaces, versions, names, labels, containers, excludeNamespaces, excludeKinds string) ([]SearchResult, error) {
 	// normalize params
 	groups = strings.ToLower(groups)
 	kinds = strings.ToLower(kinds)
-	namespaces = strings.ToLower(namespaces)
+	excludedKinds := splitParamList(excludeKinds)
+	namespaces, excluded := splitNamespaceExclusions(splitParamList(strings.ToLower(namespaces)))
+	excluded = append(excluded, splitParamList(strings.ToLower(excludeNamespaces))...)
 	versions = strings.ToLower(versions)
 	labels = strings.ToLower(labels)
 	containers = strings.ToLower(containers)
+	containerFilter, err := NewContainerFilter(splitParamList(containers))
+	if err != nil {
+		return nil, err
+	}
 
 	logrus.Debugf(
-		"Search filters groups:[%v]; kinds:[%v]; namespaces:[%v]; versions:[%v]; names:[%v]; labels:[%v] containers:[%s]",
-		groups, kinds, namespaces, versions, names, labels, containers,
+		"Search filters groups:[%v]; kinds:[%v]; namespaces:[%v]; excluded namespaces:[%v]; versions:[%v]; names:[%v]; labels:[%v] containers:[%s]",
+		groups, kinds, namespaces, strings.Join(excluded, " "), versions, names, labels, containers,
 	)
 
 	grpList, err := k8sc.Disco.ServerGroups()
@@ -101,6 +122,29 @@ func (k8sc *Client) _search(groups, kinds, namespaces, versions, names, labels,
 		return nil, err
 	}
 
+	// namespace "*" searches all namespaces with one request per resource,
+	// other namespace patterns (i.e. "kube-*") and exclusions are matched against the cluster namespaces
+	// with a namespace policy, the namespaces are listed so that each of them is checked
+	allNamespaces := strings.TrimSpace(namespaces) == "*" && len(excluded) == 0 && !namespacesRestricted()
+	if !allNamespaces && (strings.ContainsAny(namespaces, "*?[") || len(excluded) > 0) {
+		nsNames, err := getNamespaces(k8sc)
+		if err != nil {
+			return nil, err
+		}
+		patterns := splitParamList(namespaces)
+		if len(patterns) == 0 {
+			patterns = []string{"*"}
+		}
+		namespaces, err = matchNamespaces(nsNames, patterns, excluded)
+		if err != nil {
+			return nil, err
+		}
+		if len(namespaces) == 0 {
+			logrus.Debugf("Search: no namespace matches the namespace filters")
+			return nil, nil
+		}
+	}
+
 	// if namespace filters not provided, assume all namespaces
 	if len(namespaces) == 0 {
 		nsNames, err := getNamespaces(k8sc)
@@ -109,6 +153,13 @@ func (k8sc *Client) _search(groups, kinds, namespaces, versions, names, labels,
 		}
 		namespaces = strings.Join(nsNames, " ")
 	}
+	if namespacesRestricted() {
+		namespaces = strings.Join(filterNamespaces(splitParamList(namespaces)), " ")
+		if len(namespaces) == 0 {
+			// cluster-scoped objects are still searched
+			logrus.Debugf("Search: no namespace allowed by the namespace policy")
+		}
+	}
 
 	var finalResults []SearchResult
 	logrus.Debugf("Searching in %d groups", len(grpList.Groups))
@@ -145,6 +196,10 @@ func (k8sc *Client) _search(groups, kinds, namespaces, versions, names, labels,
 				if len(kinds) > 0 && !strings.Contains(kinds, strings.ToLower(res.Kind)) {
 					continue
 				}
+				if strSliceContains(excludedKinds, res.Kind) || strSliceContains(excludedKinds, res.Name) {
+					logrus.Debugf("Search: skipping excluded kind %s", res.Name)
+					continue
+				}
 
 				gvr := schema.GroupVersionResource{
 					Group:    toLegacyGrpName(grpName),
@@ -161,19 +216,53 @@ func (k8sc *Client) _search(groups, kinds, namespaces, versions, names, labels,
 
 				// gather found resources
 				var results []SearchResult
-				if res.Namespaced {
-					for _, ns := range splitParamList(namespaces) {
+				if res.Namespaced && allNamespaces {
+					logrus.Debugf("Searching for %s in all namespaces [GroupRes: %v]", res.Name, gvr)
+					start := time.Now()
+					list, err := k8sc.Client.Resource(gvr).List(listOptions)
+					duration := time.Since(start)
+					audit.Record(k8sc.Host, audit.LocalUser(), audit.ActionAPIList, fmt.Sprintf("%s --all-namespaces", gvr.String()), err)
+					if err != nil {
+						logrus.Debugf(
+							"WARN: K8s.Search failed to get %s in all namespaces [GroupRes: %s][labels: %v]: %s",
+							res.Name, discoGV.GroupVersion, listOptions.LabelSelector, err,
+						)
+						continue
+					}
+					logrus.Debugf("Found %d %s in all namespaces", len(list.Items), res.Name)
+					for _, nsList := range splitListByNamespace(list) {
+						results = append(results, SearchResult{
+							ListKind:             list.GetKind(),
+							ResourceName:         res.Name,
+							ResourceKind:         res.Kind,
+							Namespaced:           res.Namespaced,
+							Namespace:            nsList.Items[0].GetNamespace(),
+							GroupVersionResource: gvr,
+							List:                 nsList,
+							// the duration of the single request is accounted to the first namespace
+							Duration: duration,
+						})
+						duration = 0
+					}
+				} else if res.Namespaced {
+					// namespaces are searched concurrently, results are kept in the order of the namespaces
+					nsNames := splitParamList(namespaces)
+					nsResults := make([]*SearchResult, len(nsNames))
+					runWorkers(len(nsNames), getAPIWorkers(), func(i int) {
+						ns := nsNames[i]
 						logrus.Debugf("Searching for %s in namespace %s [GroupRes: %v]", res.Name, ns, gvr)
+						start := time.Now()
 						list, err := k8sc.Client.Resource(gvr).Namespace(ns).List(listOptions)
+						audit.Record(k8sc.Host, audit.LocalUser(), audit.ActionAPIList, fmt.Sprintf("%s -n %s", gvr.String(), ns), err)
 						if err != nil {
 							logrus.Debugf(
 								"WARN: K8s.Search failed to get %s in %s [GroupRes: %s][labels: %v]: %s",
 								res.Name, ns, discoGV.GroupVersion, listOptions.LabelSelector, err,
 							)
-							continue
+							return
 						}
 						logrus.Debugf("Found %d %s in namespace [%s]", len(list.Items), res.Name, ns)
-						result := SearchResult{
+						nsResults[i] = &SearchResult{
 							ListKind:             list.GetKind(),
 							ResourceName:         res.Name,
 							ResourceKind:         res.Kind,
@@ -181,12 +270,19 @@ func (k8sc *Client) _search(groups, kinds, namespaces, versions, names, labels,
 							Namespace:            ns,
 							GroupVersionResource: gvr,
 							List:                 list,
+							Duration:             time.Since(start),
+						}
+					})
+					for _, result := range nsResults {
+						if result != nil {
+							results = append(results, *result)
 						}
-						results = append(results, result)
 					}
 				} else {
 					logrus.Debugf("Searching for resource %s (non-namespaced)", res.Name)
+					start := time.Now()
 					list, err := k8sc.Client.Resource(gvr).List(listOptions)
+					audit.Record(k8sc.Host, audit.LocalUser(), audit.ActionAPIList, gvr.String(), err)
 					if err != nil {
 						logrus.Debugf(
 							"WARN: K8s.Search failed to get %s: [GroupRes: %s] [labels: %v]: %s",
@@ -202,6 +298,7 @@ func (k8sc *Client) _search(groups, kinds, namespaces, versions, names, labels,
 						Namespaced:           res.Namespaced,
 						GroupVersionResource: gvr,
 						List:                 list,
+						Duration:             time.Since(start),
 					}
 					results = append(results, result)
 				}
@@ -209,12 +306,12 @@ func (k8sc *Client) _search(groups, kinds, namespaces, versions, names, labels,
 				// apply name filters
 				for _, result := range results {
 					filteredResult := result
-					if len(containers) > 0 && result.ListKind == "PodList" {
-						filteredResult = filterPodsByContainers(result, containers)
+					if !containerFilter.IsEmpty() && result.ListKind == "PodList" {
+						filteredResult = filterPodsByContainers(result, containerFilter)
 						logrus.Debugf("Found %d %s with container filter [%s]", len(filteredResult.List.Items), filteredResult.ResourceName, containers)
 					}
 					if len(names) > 0 {
-						filteredResult = filterByNames(result, names)
+						filteredResult = filterByNames(filteredResult, names)
 						logrus.Debugf("Found %d %s with name filter [%s]", len(filteredResult.List.Items), filteredResult.ResourceName, names)
 					}
 					finalResults = append(finalResults, filteredResult)
@@ -288,8 +385,9 @@ func filterByNames(result SearchResult, names string) SearchResult {
 	return result
 }
 
-func filterPodsByContainers(result SearchResult, containers string) SearchResult {
-	if result.ListKind != "PodList" {
+// filterPodsByContainers keeps the pods with at least one container selected by the filter
+func filterPodsByContainers(result SearchResult, filter ContainerFilter) SearchResult {
+	if result.ListKind != "PodList" || filter.IsEmpty() {
 		return result
 	}
 	var filteredItems []unstructured.Unstructured
@@ -306,11 +404,12 @@ func filterPodsByContainers(result SearchResult, containers string) SearchResult
 				logrus.Errorf("Failed to get container object name: %s", err)
 				continue
 			}
-			if len(containers) > 0 && !strings.Contains(containers, name) {
-				logrus.Debugf("Container %s not found in filter list %s", name, containers)
+			if !filter.Matches(name) {
+				logrus.Debugf("Container %s not selected by container filter", name)
 				continue
 			}
 			filteredItems = append(filteredItems, podItem)
+			break
 		}
 
 	}
@@ -342,6 +441,83 @@ func getPodContainers(podItem unstructured.Unstructured) []interface{} {
 	return result
 }
 
+// matchNamespaces returns, space-separated, the namespaces matching any of the glob patterns
+// and none of the excluded patterns
+func matchNamespaces(namespaces, patterns, excluded []string) (string, error) {
+	var matched []string
+	for _, ns := range namespaces {
+		ok, err := matchAnyNamespace(ns, patterns)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			continue
+		}
+		if ok, err = matchAnyNamespace(ns, excluded); err != nil {
+			return "", err
+		}
+		if !ok {
+			matched = append(matched, ns)
+		}
+	}
+	return strings.Join(matched, " "), nil
+}
+
+// matchAnyNamespace returns true if the namespace matches any of the glob patterns
+func matchAnyNamespace(ns string, patterns []string) (bool, error) {
+	for _, pattern := range patterns {
+		ok, err := path.Match(strings.TrimSpace(pattern), strings.ToLower(ns))
+		if err != nil {
+			return false, fmt.Errorf("invalid namespace pattern %q", pattern)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// splitNamespaceExclusions separates the namespace filters prefixed with ! (i.e. !kube-node-lease)
+// and returns the remaining filters, space-separated, and the excluded patterns
+func splitNamespaceExclusions(filters []string) (string, []string) {
+	var included, excluded []string
+	for _, filter := range filters {
+		filter = strings.TrimSpace(filter)
+		switch {
+		case len(filter) == 0:
+		case strings.HasPrefix(filter, "!"):
+			excluded = append(excluded, strings.TrimPrefix(filter, "!"))
+		default:
+			included = append(included, filter)
+		}
+	}
+	return strings.Join(included, " "), excluded
+}
+
+// splitListByNamespace splits a list of objects from all namespaces into one list
+// per namespace, ordered by namespace name
+func splitListByNamespace(list *unstructured.UnstructuredList) []*unstructured.UnstructuredList {
+	byNamespace := make(map[string]*unstructured.UnstructuredList)
+	var names []string
+	for _, item := range list.Items {
+		ns := item.GetNamespace()
+		nsList, ok := byNamespace[ns]
+		if !ok {
+			nsList = &unstructured.UnstructuredList{Object: list.Object}
+			byNamespace[ns] = nsList
+			names = append(names, ns)
+		}
+		nsList.Items = append(nsList.Items, item)
+	}
+	sort.Strings(names)
+
+	lists := make([]*unstructured.UnstructuredList, 0, len(names))
+	for _, ns := range names {
+		lists = append(lists, byNamespace[ns])
+	}
+	return lists
+}
+
 // getNamespaces collect all available namespaces in cluster
 func getNamespaces(k8sc *Client) ([]string, error) {
 	gvr := schema.GroupVersionResource{
@@ -350,6 +526,7 @@ func getNamespaces(k8sc *Client) ([]string, error) {
 		Resource: "namespaces",
 	}
 	objList, err := k8sc.Client.Resource(gvr).List(metav1.ListOptions{})
+	audit.Record(k8sc.Host, audit.LocalUser(), audit.ActionAPIList, gvr.String(), err)
 
 	if err != nil {
 		return nil, err
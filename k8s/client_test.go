@@ -0,0 +1,63 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var _ = Describe("Namespace filters", func() {
+
+	It("matches namespace patterns", func() {
+		namespaces := []string{"default", "kube-public", "kube-system", "ingress-nginx"}
+		matched, err := matchNamespaces(namespaces, []string{"kube-*", "ingress-nginx"}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(matched).To(Equal("kube-public kube-system ingress-nginx"))
+
+		_, err = matchNamespaces(namespaces, []string{"kube-["}, nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("excludes namespace patterns", func() {
+		namespaces := []string{"default", "kube-node-lease", "kube-public", "kube-system", "tenant-a"}
+		included, excluded := splitNamespaceExclusions([]string{"kube-*", "!kube-node-lease", " !tenant-*"})
+		Expect(included).To(Equal("kube-*"))
+		Expect(excluded).To(Equal([]string{"kube-node-lease", "tenant-*"}))
+
+		matched, err := matchNamespaces(namespaces, splitParamList(included), excluded)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(matched).To(Equal("kube-public kube-system"))
+
+		matched, err = matchNamespaces(namespaces, []string{"*"}, []string{"kube-*"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(matched).To(Equal("default tenant-a"))
+	})
+
+	It("splits lists from all namespaces by namespace", func() {
+		item := func(ns, name string) unstructured.Unstructured {
+			obj := unstructured.Unstructured{Object: map[string]interface{}{"kind": "Pod"}}
+			obj.SetNamespace(ns)
+			obj.SetName(name)
+			return obj
+		}
+		list := &unstructured.UnstructuredList{
+			Object: map[string]interface{}{"kind": "PodList", "apiVersion": "v1"},
+			Items: []unstructured.Unstructured{
+				item("ingress-nginx", "controller-1"),
+				item("apps", "web"),
+				item("ingress-nginx", "controller-2"),
+			},
+		}
+
+		lists := splitListByNamespace(list)
+		Expect(lists).To(HaveLen(2))
+		Expect(lists[0].Items).To(HaveLen(1))
+		Expect(lists[0].Items[0].GetNamespace()).To(Equal("apps"))
+		Expect(lists[1].Items).To(HaveLen(2))
+		Expect(lists[1].GetKind()).To(Equal("PodList"))
+	})
+})
@@ -0,0 +1,69 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ConditionDeleted is the condition met once no object matches the search
+const ConditionDeleted = "Deleted"
+
+// MeetsCondition reports whether the object has the condition. The condition is
+// matched, case insensitively, against the types of the status conditions with a
+// "True" status (i.e. Ready, Available) and then against the status phase (i.e. Running, Succeeded).
+func MeetsCondition(obj unstructured.Unstructured, condition string) bool {
+	conditions, found, err := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	if err == nil && found {
+		for _, c := range conditions {
+			cond, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			condType, _ := cond["type"].(string)
+			if strings.EqualFold(condType, condition) {
+				status, _ := cond["status"].(string)
+				return strings.EqualFold(status, "True")
+			}
+		}
+	}
+
+	phase, found, err := unstructured.NestedString(obj.Object, "status", "phase")
+	if err == nil && found {
+		return strings.EqualFold(phase, condition)
+	}
+	return false
+}
+
+// ResultsMeetCondition reports whether the search results contain at least one object and
+// all of the objects have the condition. The Deleted condition is met when there is no object.
+// It also returns the names of the objects that do not meet the condition.
+func ResultsMeetCondition(results []SearchResult, condition string) (bool, []string) {
+	var pending []string
+	count := 0
+	for _, result := range results {
+		if result.List == nil {
+			continue
+		}
+		for _, obj := range result.List.Items {
+			count++
+			if strings.EqualFold(condition, ConditionDeleted) || !MeetsCondition(obj, condition) {
+				pending = append(pending, objectName(obj))
+			}
+		}
+	}
+	if strings.EqualFold(condition, ConditionDeleted) {
+		return count == 0, pending
+	}
+	return count > 0 && len(pending) == 0, pending
+}
+
+func objectName(obj unstructured.Unstructured) string {
+	if ns := obj.GetNamespace(); len(ns) > 0 {
+		return ns + "/" + obj.GetName()
+	}
+	return obj.GetName()
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var _ = Describe("Conditions", func() {
+	pod := func(name, phase string, conditions ...map[string]interface{}) unstructured.Unstructured {
+		var conds []interface{}
+		for _, c := range conditions {
+			conds = append(conds, c)
+		}
+		return unstructured.Unstructured{Object: map[string]interface{}{
+			"kind":     "Pod",
+			"metadata": map[string]interface{}{"name": name, "namespace": "default"},
+			"status":   map[string]interface{}{"phase": phase, "conditions": conds},
+		}}
+	}
+	ready := map[string]interface{}{"type": "Ready", "status": "True"}
+	notReady := map[string]interface{}{"type": "Ready", "status": "False"}
+
+	DescribeTable("matching an object condition",
+		func(obj unstructured.Unstructured, condition string, expected bool) {
+			Expect(MeetsCondition(obj, condition)).To(Equal(expected))
+		},
+		Entry("true condition", pod("app", "Running", ready), "Ready", true),
+		Entry("false condition", pod("app", "Running", notReady), "Ready", false),
+		Entry("case insensitive", pod("app", "Running", ready), "ready", true),
+		Entry("phase", pod("app", "Succeeded"), "Succeeded", true),
+		Entry("other phase", pod("app", "Pending"), "Running", false),
+		Entry("unknown condition", unstructured.Unstructured{Object: map[string]interface{}{}}, "Ready", false),
+	)
+
+	It("requires all objects to meet the condition", func() {
+		list := &unstructured.UnstructuredList{Items: []unstructured.Unstructured{
+			pod("app-0", "Running", ready),
+			pod("app-1", "Running", notReady),
+		}}
+		met, pending := ResultsMeetCondition([]SearchResult{{List: list}}, "Ready")
+		Expect(met).To(BeFalse())
+		Expect(pending).To(Equal([]string{"default/app-1"}))
+
+		list.Items = list.Items[:1]
+		met, pending = ResultsMeetCondition([]SearchResult{{List: list}}, "Ready")
+		Expect(met).To(BeTrue())
+		Expect(pending).To(BeEmpty())
+	})
+
+	It("is not met without objects, unless waiting for deletion", func() {
+		met, _ := ResultsMeetCondition(nil, "Ready")
+		Expect(met).To(BeFalse())
+		met, _ = ResultsMeetCondition(nil, ConditionDeleted)
+		Expect(met).To(BeTrue())
+
+		list := &unstructured.UnstructuredList{Items: []unstructured.Unstructured{pod("app-0", "Running", ready)}}
+		met, pending := ResultsMeetCondition([]SearchResult{{List: list}}, ConditionDeleted)
+		Expect(met).To(BeFalse())
+		Expect(pending).To(Equal([]string{"default/app-0"}))
+	})
+})
@@ -0,0 +1,65 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ContainerFilter selects containers by name. Patterns are shell globs (i.e. "app*");
+// a pattern starting with "!" (i.e. "!istio-proxy") excludes the matching containers.
+// A container is selected when it matches an include pattern (or there are none) and
+// no exclude pattern.
+type ContainerFilter struct {
+	include []string
+	exclude []string
+}
+
+// NewContainerFilter returns a filter for the container name patterns
+func NewContainerFilter(patterns []string) (ContainerFilter, error) {
+	var filter ContainerFilter
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if len(pattern) == 0 {
+			continue
+		}
+		exclude := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+		if _, err := path.Match(pattern, ""); err != nil || len(pattern) == 0 {
+			return filter, fmt.Errorf("invalid container pattern %q", pattern)
+		}
+		if exclude {
+			filter.exclude = append(filter.exclude, pattern)
+		} else {
+			filter.include = append(filter.include, pattern)
+		}
+	}
+	return filter, nil
+}
+
+// IsEmpty returns true when the filter selects all containers
+func (f ContainerFilter) IsEmpty() bool {
+	return len(f.include) == 0 && len(f.exclude) == 0
+}
+
+// Matches returns true when the container name is selected by the filter
+func (f ContainerFilter) Matches(name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range f.exclude {
+		if ok, _ := path.Match(pattern, name); ok {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, pattern := range f.include {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var _ = Describe("ContainerFilter", func() {
+
+	DescribeTable("matching container names",
+		func(patterns []string, name string, expected bool) {
+			filter, err := NewContainerFilter(patterns)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(filter.Matches(name)).To(Equal(expected))
+		},
+		Entry("no patterns", nil, "app", true),
+		Entry("exact name", []string{"istio-proxy"}, "istio-proxy", true),
+		Entry("other name", []string{"istio-proxy"}, "app", false),
+		Entry("glob", []string{"app*"}, "app-worker", true),
+		Entry("excluded", []string{"!istio-proxy"}, "istio-proxy", false),
+		Entry("not excluded", []string{"!istio-proxy"}, "app", true),
+		Entry("included and excluded", []string{"app*", "!app-debug"}, "app-debug", false),
+		Entry("case insensitive", []string{"App*"}, "app", true),
+	)
+
+	It("rejects invalid patterns", func() {
+		_, err := NewContainerFilter([]string{"app["})
+		Expect(err).To(HaveOccurred())
+		_, err = NewContainerFilter([]string{"!"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("keeps pods with a selected container once", func() {
+		pod := func(name string, containers ...string) unstructured.Unstructured {
+			var specs []interface{}
+			for _, c := range containers {
+				specs = append(specs, map[string]interface{}{"name": c})
+			}
+			item := unstructured.Unstructured{Object: map[string]interface{}{
+				"kind": "Pod",
+				"spec": map[string]interface{}{"containers": specs},
+			}}
+			item.SetName(name)
+			return item
+		}
+		result := SearchResult{
+			ListKind: "PodList",
+			List: &unstructured.UnstructuredList{Items: []unstructured.Unstructured{
+				pod("web", "app", "app-sidecar", "istio-proxy"),
+				pod("mesh", "istio-proxy"),
+			}},
+		}
+
+		filter, err := NewContainerFilter([]string{"app*"})
+		Expect(err).NotTo(HaveOccurred())
+		filtered := filterPodsByContainers(result, filter)
+		Expect(filtered.List.Items).To(HaveLen(1))
+		Expect(filtered.List.Items[0].GetName()).To(Equal("web"))
+	})
+})
@@ -11,6 +11,8 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
 )
 
 type ContainerLogsImpl struct {
@@ -27,10 +29,15 @@ func NewContainerLogger(namespace, podName string, container corev1.Container) C
 	}
 }
 
+func (c ContainerLogsImpl) Name() string {
+	return c.container.Name
+}
+
 func (c ContainerLogsImpl) Fetch(restApi rest.Interface) (io.ReadCloser, error) {
 	opts := &corev1.PodLogOptions{Container: c.container.Name}
 	req := restApi.Get().Namespace(c.namespace).Name(c.podName).Resource("pods").SubResource("log").VersionedParams(opts, scheme.ParameterCodec)
 	stream, err := req.Stream()
+	audit.Record(req.URL().Host, audit.LocalUser(), audit.ActionAPIGet, req.URL().Path, err)
 	if err != nil {
 		err = errors.Wrap(err, "failed to create container log stream")
 	}
@@ -0,0 +1,148 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+)
+
+var (
+	discoveryCacheMu  sync.Mutex
+	discoveryCacheDir string
+	discoveryCacheTTL time.Duration
+
+	unsafeCacheChars = regexp.MustCompile(`[^a-zA-Z0-9.\-]`)
+)
+
+// DefaultDiscoveryCacheDir returns $HOME/.crashd/cache/discovery
+func DefaultDiscoveryCacheDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.TempDir()
+	}
+	return filepath.Join(home, ".crashd", "cache", "discovery")
+}
+
+// SetDiscoveryCache enables the cache, under dir, of the API discovery data (groups, versions, and
+// resources) of the clusters, shared by the clients created afterwards and by later runs. Cached data
+// older than ttl is refreshed. A ttl of 0 disables the cache.
+func SetDiscoveryCache(dir string, ttl time.Duration) {
+	discoveryCacheMu.Lock()
+	defer discoveryCacheMu.Unlock()
+	discoveryCacheDir, discoveryCacheTTL = dir, ttl
+}
+
+// newCachedDiscovery returns the discovery client of the cluster at host, cached when the cache is enabled
+func newCachedDiscovery(delegate discovery.DiscoveryInterface, host string) discovery.DiscoveryInterface {
+	discoveryCacheMu.Lock()
+	dir, ttl := discoveryCacheDir, discoveryCacheTTL
+	discoveryCacheMu.Unlock()
+	if ttl <= 0 || len(dir) == 0 {
+		return delegate
+	}
+	return &cachedDiscovery{
+		DiscoveryInterface: delegate,
+		dir:                filepath.Join(dir, unsafeCacheChars.ReplaceAllString(host, "_")),
+		ttl:                ttl,
+	}
+}
+
+// cachedDiscovery saves the server groups and the resources of each group version in files, like
+// kubectl, so that discovery against clusters with many CRDs is not repeated by every run.
+// Other discovery requests are sent to the server.
+type cachedDiscovery struct {
+	discovery.DiscoveryInterface
+	dir string
+	ttl time.Duration
+}
+
+// ServerGroups returns the cached server groups, or retrieves and caches them
+func (d *cachedDiscovery) ServerGroups() (*metav1.APIGroupList, error) {
+	groups := &metav1.APIGroupList{}
+	file := filepath.Join(d.dir, "servergroups.json")
+	if d.read(file, groups) {
+		return groups, nil
+	}
+	groups, err := d.DiscoveryInterface.ServerGroups()
+	if err != nil {
+		return nil, err
+	}
+	d.write(file, groups)
+	return groups, nil
+}
+
+// ServerResourcesForGroupVersion returns the cached resources of the group version, or retrieves and caches them
+func (d *cachedDiscovery) ServerResourcesForGroupVersion(groupVersion string) (*metav1.APIResourceList, error) {
+	resources := &metav1.APIResourceList{}
+	file := filepath.Join(d.dir, filepath.FromSlash(groupVersion), "serverresources.json")
+	if d.read(file, resources) {
+		return resources, nil
+	}
+	resources, err := d.DiscoveryInterface.ServerResourcesForGroupVersion(groupVersion)
+	if err != nil {
+		return nil, err
+	}
+	d.write(file, resources)
+	return resources, nil
+}
+
+// read decodes the cached file into obj, and returns false when the file is missing, expired, or invalid
+func (d *cachedDiscovery) read(file string, obj interface{}) bool {
+	info, err := os.Stat(file)
+	if err != nil || time.Since(info.ModTime()) > d.ttl {
+		return false
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false
+	}
+	if err := json.Unmarshal(data, obj); err != nil {
+		logrus.Debugf("Discovery cache: ignoring %s: %s", file, err)
+		return false
+	}
+	return true
+}
+
+// write saves obj in the cache file. Failures are logged: the cache is an optimization.
+func (d *cachedDiscovery) write(file string, obj interface{}) {
+	if err := writeCacheFile(file, obj); err != nil {
+		logrus.Debugf("Discovery cache: failed to save %s: %s", file, err)
+	}
+}
+
+// writeCacheFile writes obj in a temporary file renamed to file, so that concurrent runs never read a partial file
+func writeCacheFile(file string, obj interface{}) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
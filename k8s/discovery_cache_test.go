@@ -0,0 +1,79 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+)
+
+// countingDiscovery counts the discovery requests reaching the server
+type countingDiscovery struct {
+	discovery.DiscoveryInterface
+	groups, resources int
+}
+
+func (d *countingDiscovery) ServerGroups() (*metav1.APIGroupList, error) {
+	d.groups++
+	return &metav1.APIGroupList{Groups: []metav1.APIGroup{{Name: "apps"}}}, nil
+}
+
+func (d *countingDiscovery) ServerResourcesForGroupVersion(groupVersion string) (*metav1.APIResourceList, error) {
+	d.resources++
+	return &metav1.APIResourceList{GroupVersion: groupVersion, APIResources: []metav1.APIResource{{Name: "deployments", Kind: "Deployment"}}}, nil
+}
+
+var _ = Describe("Discovery cache", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "crashd-discovery")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		SetDiscoveryCache("", 0)
+		os.RemoveAll(dir)
+	})
+
+	It("serves discovery data from the cache until it expires", func() {
+		SetDiscoveryCache(dir, time.Hour)
+		server := &countingDiscovery{}
+		for i := 0; i < 2; i++ {
+			// each run creates a new client
+			disco := newCachedDiscovery(server, "https://127.0.0.1:6443")
+			groups, err := disco.ServerGroups()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(groups.Groups[0].Name).To(Equal("apps"))
+			resources, err := disco.ServerResourcesForGroupVersion("apps/v1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resources.APIResources[0].Kind).To(Equal("Deployment"))
+		}
+		Expect(server.groups).To(Equal(1))
+		Expect(server.resources).To(Equal(1))
+		Expect(filepath.Join(dir, "https___127.0.0.1_6443", "apps", "v1", "serverresources.json")).To(BeAnExistingFile())
+
+		// expired data is retrieved again
+		expired := time.Now().Add(-2 * time.Hour)
+		Expect(os.Chtimes(filepath.Join(dir, "https___127.0.0.1_6443", "servergroups.json"), expired, expired)).To(Succeed())
+		_, err := newCachedDiscovery(server, "https://127.0.0.1:6443").ServerGroups()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(server.groups).To(Equal(2))
+	})
+
+	It("is disabled with a ttl of 0", func() {
+		SetDiscoveryCache(dir, 0)
+		server := &countingDiscovery{}
+		Expect(newCachedDiscovery(server, "https://127.0.0.1:6443")).To(BeIdenticalTo(server))
+	})
+})
@@ -0,0 +1,55 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"strings"
+	"sync"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// execEnv are the variables added to the environment of the kubeconfig exec plugins
+var execEnv struct {
+	sync.RWMutex
+	env []string
+}
+
+// SetExecEnv adds the variables of env (NAME=value), i.e. the AWS credentials selected by the script, to
+// the environment of the kubeconfig exec plugins (i.e. aws eks get-token) of the clients created afterwards.
+// It returns the function restoring the previous variables.
+func SetExecEnv(env []string) func() {
+	execEnv.Lock()
+	defer execEnv.Unlock()
+	previous := execEnv.env
+	execEnv.env = env
+	return func() {
+		execEnv.Lock()
+		defer execEnv.Unlock()
+		execEnv.env = previous
+	}
+}
+
+// buildConfig returns the client configuration of kubeconfig, its exec plugin, if any, running with the
+// variables set with SetExecEnv
+func buildConfig(kubeconfig string) (*rest.Config, error) {
+	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ExecProvider == nil {
+		return cfg, nil
+	}
+	execEnv.RLock()
+	defer execEnv.RUnlock()
+	for _, v := range execEnv.env {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) == 2 {
+			cfg.ExecProvider.Env = append(cfg.ExecProvider.Env, clientcmdapi.ExecEnvVar{Name: parts[0], Value: parts[1]})
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+var _ = Describe("Exec plugin environment", func() {
+	It("adds the variables to the environment of the exec plugin", func() {
+		dir, err := ioutil.TempDir("", "crashd-exec-env")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		kubeconfig := filepath.Join(dir, "config")
+		Expect(ioutil.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: eks
+  cluster:
+    server: https://eks.example.com
+contexts:
+- name: eks
+  context:
+    cluster: eks
+    user: eks
+current-context: eks
+users:
+- name: eks
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: aws
+      args: ["eks", "get-token", "--cluster-name", "prod"]
+      env:
+      - name: AWS_STS_REGIONAL_ENDPOINTS
+        value: regional
+`), 0644)).To(Succeed())
+
+		defer SetExecEnv([]string{"AWS_PROFILE=prod", "AWS_REGION=us-west-2"})()
+		cfg, err := buildConfig(kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.ExecProvider.Env).To(Equal([]clientcmdapi.ExecEnvVar{
+			{Name: "AWS_STS_REGIONAL_ENDPOINTS", Value: "regional"},
+			{Name: "AWS_PROFILE", Value: "prod"},
+			{Name: "AWS_REGION", Value: "us-west-2"},
+		}))
+	})
+})
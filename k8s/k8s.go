@@ -10,6 +10,7 @@ import (
 const BaseDirname = "kubecapture"
 
 type Container interface {
+	Name() string
 	Fetch(rest.Interface) (io.ReadCloser, error)
 	Write(io.ReadCloser, string) error
 }
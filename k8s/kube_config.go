@@ -9,14 +9,25 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 	"github.com/vladimirvivien/echo"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	clientcmdlatest "k8s.io/client-go/tools/clientcmd/api/latest"
+	clientcmdapiv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+	"sigs.k8s.io/yaml"
 )
 
 // FetchWorkloadConfig...
 func FetchWorkloadConfig(clusterName, clusterNamespace, mgmtKubeConfigPath string) (string, error) {
 	var filePath string
+	if err := CheckNamespace(clusterNamespace); err != nil {
+		return filePath, err
+	}
 	cmdStr := fmt.Sprintf(`kubectl get secrets/%s-kubeconfig --template '{{.data.value}}' --namespace=%s --kubeconfig %s`, clusterName, clusterNamespace, mgmtKubeConfigPath)
 	p := echo.New().RunProc(cmdStr)
 	if p.Err() != nil {
@@ -36,3 +47,92 @@ func FetchWorkloadConfig(clusterName, clusterNamespace, mgmtKubeConfigPath strin
 	}
 	return filePath, nil
 }
+
+var (
+	contextConfigsMu sync.Mutex
+	contextConfigs   []string
+)
+
+// WriteContextConfig saves, in a temporary file readable only by the current user, the kubeconfig of the
+// context (the current context when empty) of the kubeconfig file, using the cluster when set instead of the
+// cluster of the context, so that the clients and tools reading the file target that cluster. The file is
+// removed by RemoveContextConfigs.
+func WriteContextConfig(kubeconfig, context, cluster string) (string, error) {
+	cfg, err := clientcmd.LoadFromFile(kubeconfig)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to load kubeconfig %s", kubeconfig)
+	}
+	// certificate and key files are relative to the original file
+	if err := clientcmd.ResolveLocalPaths(cfg); err != nil {
+		return "", errors.Wrapf(err, "failed to load kubeconfig %s", kubeconfig)
+	}
+
+	if len(context) == 0 {
+		context = cfg.CurrentContext
+	}
+	kubeContext, ok := cfg.Contexts[context]
+	if !ok {
+		var names []string
+		for name := range cfg.Contexts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("context %q not found in %s (contexts: %s)", context, kubeconfig, strings.Join(names, ", "))
+	}
+	if len(cluster) > 0 {
+		if _, ok := cfg.Clusters[cluster]; !ok {
+			var names []string
+			for name := range cfg.Clusters {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return "", fmt.Errorf("cluster %q not found in %s (clusters: %s)", cluster, kubeconfig, strings.Join(names, ", "))
+		}
+		selected := *kubeContext
+		selected.Cluster = cluster
+		cfg.Contexts[context] = &selected
+	}
+	cfg.CurrentContext = context
+	if err := clientcmdapi.MinifyConfig(cfg); err != nil {
+		return "", errors.Wrapf(err, "invalid context %q of %s", context, kubeconfig)
+	}
+	// clientcmd.Write encodes with json-iterator, which fails on maps with recent Go runtimes
+	var external clientcmdapiv1.Config
+	if err := clientcmdlatest.Scheme.Convert(cfg, &external, nil); err != nil {
+		return "", errors.Wrapf(err, "invalid context %q of %s", context, kubeconfig)
+	}
+	external.APIVersion, external.Kind = clientcmdlatest.ExternalVersion.Version, "Config"
+	data, err := yaml.Marshal(&external)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := ioutil.TempFile("", "crashd-kubeconfig-")
+	if err != nil {
+		return "", errors.Wrap(err, "Cannot create temporary file")
+	}
+	defer file.Close()
+	if err := file.Chmod(0600); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	if _, err := file.Write(data); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	contextConfigsMu.Lock()
+	contextConfigs = append(contextConfigs, file.Name())
+	contextConfigsMu.Unlock()
+	return file.Name(), nil
+}
+
+// RemoveContextConfigs removes the kubeconfig files saved by WriteContextConfig
+func RemoveContextConfigs() {
+	contextConfigsMu.Lock()
+	defer contextConfigsMu.Unlock()
+	for _, file := range contextConfigs {
+		os.Remove(file)
+	}
+	contextConfigs = nil
+}
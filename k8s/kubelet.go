@@ -0,0 +1,96 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/rest"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+	"github.com/vmware-tanzu/crash-diagnostics/tracing"
+)
+
+const (
+	KubeletDirname = "kubelet"
+
+	// KubeletPort is the port of the kubelet API
+	KubeletPort = 10250
+)
+
+// KubeletClient queries the kubelet API of nodes directly, authenticated with the credentials
+// (client certificates or token) of a kubeconfig
+type KubeletClient struct {
+	http      *http.Client
+	addresses map[string]string
+}
+
+// NewKubeletClient returns a client of the kubelets of the cluster of kubeconfig. The serving
+// certificates of kubelets are verified with the CA file, when not empty, or with the cluster CA,
+// unless insecure is set (kubelets default to self-signed serving certificates).
+func NewKubeletClient(kubeconfig, caFile string, insecure bool) (*KubeletClient, error) {
+	cfg, err := buildConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	if len(caFile) > 0 {
+		cfg.TLSClientConfig.CAFile = caFile
+		cfg.TLSClientConfig.CAData = nil
+	}
+	if insecure {
+		cfg.TLSClientConfig.Insecure = true
+		cfg.TLSClientConfig.CAFile = ""
+		cfg.TLSClientConfig.CAData = nil
+	}
+	// the kubelet serving certificate is not issued for the API server name
+	cfg.TLSClientConfig.ServerName = ""
+	transport, err := rest.TransportFor(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to configure kubelet transport")
+	}
+
+	client, err := New(kubeconfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not initialize search client")
+	}
+	nodes, err := getNodes(client, nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not fetch nodes")
+	}
+	addresses := make(map[string]string)
+	for _, node := range nodes {
+		addresses[node.Name] = getNodeInternalIP(node)
+	}
+	return &KubeletClient{http: &http.Client{Transport: transport, Timeout: 30 * time.Second}, addresses: addresses}, nil
+}
+
+// Get returns the response of a GET request sent to path on the kubelet API of node,
+// at the internal IP address of the node
+func (c *KubeletClient) Get(node, path string) (io.ReadCloser, error) {
+	address := c.addresses[node]
+	if len(address) == 0 {
+		return nil, fmt.Errorf("node %s has no internal IP address", node)
+	}
+	url := fmt.Sprintf("https://%s/%s", net.JoinHostPort(address, strconv.Itoa(KubeletPort)), strings.TrimPrefix(path, "/"))
+
+	span := tracing.Start(audit.ActionKubeletGet, tracing.String("net.peer.name", node), tracing.String("crashd.path", path))
+	resp, err := c.http.Get(url)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err = fmt.Errorf("%s", resp.Status)
+	}
+	audit.Record(address, audit.LocalUser(), audit.ActionKubeletGet, path, err)
+	span.End(err)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s from node %s", path, node)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+	"github.com/vmware-tanzu/crash-diagnostics/tracing"
+)
+
+// leaseRequestTimeout bounds the lease requests, so that an unreachable cluster does not hold the run
+const leaseRequestTimeout = 10 * time.Second
+
+// LeaseHeldError is returned by AcquireLease when the lease is held by another holder
+type LeaseHeldError struct {
+	Holder string
+	Since  time.Time
+}
+
+func (e *LeaseHeldError) Error() string {
+	return fmt.Sprintf("held by %s since %s", e.Holder, e.Since.Format(time.RFC3339))
+}
+
+// Lease is a coordination.k8s.io/v1 Lease held by crashd, renewed until it is released, so that
+// concurrent crashd runs can be excluded across machines
+type Lease struct {
+	client    *Client
+	Namespace string
+	Name      string
+	Holder    string
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// AcquireLease acquires the lease namespace/name for the holder, creating it when it does not exist. A lease
+// held by another holder is only taken over when it is expired (not renewed for its duration), or when force
+// is set; a *LeaseHeldError is returned otherwise. The lease is renewed every third of its duration until
+// Release is called.
+func (k8sc *Client) AcquireLease(namespace, name, holder string, duration time.Duration, force bool) (*Lease, error) {
+	lease := &Lease{client: k8sc, Namespace: namespace, Name: name, Holder: holder, done: make(chan struct{})}
+	leasePath := fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases/%s", namespace, name)
+
+	span := tracing.Start(audit.ActionLeaseAcquire, tracing.String("crashd.lease", namespace+"/"+name))
+	var current coordinationv1.Lease
+	err := k8sc.CoreRest.Get().AbsPath(leasePath).Timeout(leaseRequestTimeout).Do().Into(&current)
+	switch {
+	case apierrors.IsNotFound(err):
+		err = k8sc.CoreRest.Post().AbsPath(fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", namespace)).Timeout(leaseRequestTimeout).
+			SetHeader("Content-Type", "application/json").Body(lease.marshal(nil, duration)).Do().Error()
+	case err == nil:
+		heldErr := leaseHeld(current, holder, time.Now())
+		if heldErr != nil && !force {
+			err = heldErr
+			break
+		}
+		if heldErr != nil {
+			logrus.Warnf("lease %s/%s %s: taking it over (forced)", namespace, name, heldErr)
+		}
+		err = k8sc.CoreRest.Put().AbsPath(leasePath).Timeout(leaseRequestTimeout).
+			SetHeader("Content-Type", "application/json").Body(lease.marshal(&current, duration)).Do().Error()
+	}
+	audit.Record(k8sc.Host, audit.LocalUser(), audit.ActionLeaseAcquire, leasePath, err)
+	span.End(err)
+	if err != nil {
+		if _, held := err.(*LeaseHeldError); held {
+			return nil, err
+		}
+		return nil, errors.Wrapf(err, "failed to acquire lease %s/%s", namespace, name)
+	}
+	logrus.Debugf("Lease %s/%s acquired by %s", namespace, name, holder)
+
+	lease.wg.Add(1)
+	go lease.renew(duration)
+	return lease, nil
+}
+
+// leaseHeld returns a *LeaseHeldError when the lease is held by another holder, and not expired at now
+func leaseHeld(lease coordinationv1.Lease, holder string, now time.Time) error {
+	spec := lease.Spec
+	if spec.HolderIdentity == nil || len(*spec.HolderIdentity) == 0 || *spec.HolderIdentity == holder {
+		return nil
+	}
+	if spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return nil
+	}
+	if now.After(spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second)) {
+		return nil
+	}
+	held := &LeaseHeldError{Holder: *spec.HolderIdentity, Since: spec.RenewTime.Time}
+	if spec.AcquireTime != nil {
+		held.Since = spec.AcquireTime.Time
+	}
+	return held
+}
+
+// marshal returns the JSON of the lease, held by l.Holder from now, replacing current when set
+func (l *Lease) marshal(current *coordinationv1.Lease, duration time.Duration) []byte {
+	lease := coordinationv1.Lease{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: l.Namespace, Name: l.Name},
+	}
+	if current != nil {
+		lease.ObjectMeta = current.ObjectMeta
+	}
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(duration.Seconds())
+	lease.Spec = coordinationv1.LeaseSpec{
+		HolderIdentity:       &l.Holder,
+		LeaseDurationSeconds: &seconds,
+		AcquireTime:          &now,
+		RenewTime:            &now,
+	}
+	data, _ := json.Marshal(&lease)
+	return data
+}
+
+// renew renews the lease every third of its duration, until it is released
+func (l *Lease) renew(duration time.Duration) {
+	defer l.wg.Done()
+	leasePath := fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases/%s", l.Namespace, l.Name)
+	ticker := time.NewTicker(duration / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+		}
+		var current coordinationv1.Lease
+		err := l.client.CoreRest.Get().AbsPath(leasePath).Timeout(leaseRequestTimeout).Do().Into(&current)
+		if err == nil {
+			if current.Spec.HolderIdentity == nil || *current.Spec.HolderIdentity != l.Holder {
+				logrus.Warnf("lease %s/%s: taken over by another run", l.Namespace, l.Name)
+				return
+			}
+			now := metav1.NewMicroTime(time.Now())
+			current.Spec.RenewTime = &now
+			data, _ := json.Marshal(&current)
+			err = l.client.CoreRest.Put().AbsPath(leasePath).Timeout(leaseRequestTimeout).SetHeader("Content-Type", "application/json").Body(data).Do().Error()
+		}
+		if err != nil {
+			logrus.Warnf("lease %s/%s: failed to renew: %s", l.Namespace, l.Name, err)
+		}
+	}
+}
+
+// Release stops renewing the lease, and deletes it unless it was taken over by another holder
+func (l *Lease) Release() error {
+	close(l.done)
+	l.wg.Wait()
+	leasePath := fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases/%s", l.Namespace, l.Name)
+	var current coordinationv1.Lease
+	if err := l.client.CoreRest.Get().AbsPath(leasePath).Timeout(leaseRequestTimeout).Do().Into(&current); err == nil {
+		if current.Spec.HolderIdentity == nil || *current.Spec.HolderIdentity != l.Holder {
+			return nil
+		}
+	}
+	span := tracing.Start(audit.ActionLeaseRelease, tracing.String("crashd.lease", l.Namespace+"/"+l.Name))
+	err := l.client.CoreRest.Delete().AbsPath(leasePath).Timeout(leaseRequestTimeout).Do().Error()
+	audit.Record(l.client.Host, audit.LocalUser(), audit.ActionLeaseRelease, leasePath, err)
+	span.End(err)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to release lease %s/%s", l.Namespace, l.Name)
+	}
+	logrus.Debugf("Lease %s/%s released", l.Namespace, l.Name)
+	return nil
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("leaseHeld", func() {
+	now := time.Now()
+	lease := func(holder string, renewed time.Duration) coordinationv1.Lease {
+		seconds := int32(60)
+		acquired := metav1.NewMicroTime(now.Add(-10 * time.Minute))
+		renew := metav1.NewMicroTime(now.Add(-renewed))
+		return coordinationv1.Lease{Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &seconds,
+			AcquireTime:          &acquired,
+			RenewTime:            &renew,
+		}}
+	}
+
+	It("reports a lease renewed by another holder", func() {
+		err := leaseHeld(lease("alice@laptop", 10*time.Second), "bob@jumphost", now)
+		Expect(err).To(HaveOccurred())
+		held, ok := err.(*LeaseHeldError)
+		Expect(ok).To(BeTrue())
+		Expect(held.Holder).To(Equal("alice@laptop"))
+		Expect(held.Since).To(BeTemporally("~", now.Add(-10*time.Minute), time.Millisecond))
+	})
+
+	It("ignores expired leases, released leases, and leases of the same holder", func() {
+		Expect(leaseHeld(lease("alice@laptop", 2*time.Minute), "bob@jumphost", now)).To(Succeed())
+		Expect(leaseHeld(lease("", 10*time.Second), "bob@jumphost", now)).To(Succeed())
+		Expect(leaseHeld(lease("bob@jumphost", 10*time.Second), "bob@jumphost", now)).To(Succeed())
+	})
+})
@@ -0,0 +1,217 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/printers"
+	"k8s.io/client-go/rest"
+)
+
+// Layouts of the files written for search results
+const (
+	// LayoutKubeCapture saves one file per resource and namespace under BaseDirname
+	LayoutKubeCapture = "kubecapture"
+	// LayoutMustGather saves files in the layout of `oc adm must-gather` under MustGatherDirname
+	LayoutMustGather = "must-gather"
+)
+
+// MustGatherDirname is the directory where search results are saved with LayoutMustGather
+const MustGatherDirname = "must-gather"
+
+// ValidateLayout returns an error when layout is not a supported layout
+func ValidateLayout(layout string) error {
+	switch layout {
+	case LayoutKubeCapture, LayoutMustGather:
+		return nil
+	default:
+		return fmt.Errorf("unsupported layout %q (supported: %s, %s)", layout, LayoutKubeCapture, LayoutMustGather)
+	}
+}
+
+// MustGatherWriter saves search results as YAML in the directory layout of `oc adm must-gather`,
+// so that bundles can be read by the tools consuming must-gather archives:
+//
+//	cluster-scoped-resources/<group>/<resource>/<name>.yaml
+//	namespaces/<namespace>/<namespace>.yaml
+//	namespaces/<namespace>/<group>/<resource>.yaml
+//	namespaces/<namespace>/pods/<pod>/<pod>.yaml
+//	namespaces/<namespace>/pods/<pod>/<container>/<container>/logs/current.log
+//
+// The core API group is named core.
+type MustGatherWriter struct {
+	workdir    string
+	writeLogs  bool
+	containers ContainerFilter
+	restApi    rest.Interface
+	workers    int
+}
+
+// NewMustGatherWriter returns a writer that saves search results under the working directory
+func NewMustGatherWriter(workdir, what string, restApi rest.Interface) (*MustGatherWriter, error) {
+	workdir = filepath.Join(workdir, MustGatherDirname)
+	if err := os.MkdirAll(workdir, 0744); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	return &MustGatherWriter{
+		workdir:   workdir,
+		writeLogs: what == "logs" || what == "all",
+		restApi:   restApi,
+		workers:   getAPIWorkers(),
+	}, nil
+}
+
+// SetContainers limits the logs written to the containers selected by the name patterns (see ContainerFilter)
+func (w *MustGatherWriter) SetContainers(patterns []string) error {
+	filter, err := NewContainerFilter(patterns)
+	if err != nil {
+		return err
+	}
+	w.containers = filter
+	return nil
+}
+
+func (w *MustGatherWriter) GetResultDir() string {
+	return w.workdir
+}
+
+func (w *MustGatherWriter) Write(searchResults []SearchResult) error {
+	if len(searchResults) == 0 {
+		return fmt.Errorf("cannot write empty (or nil) search result")
+	}
+
+	for _, result := range searchResults {
+		if result.List == nil || len(result.List.Items) == 0 {
+			continue
+		}
+		group := result.GroupVersionResource.Group
+		if len(group) == 0 {
+			group = "core"
+		}
+		resource := result.GroupVersionResource.Resource
+		if len(resource) == 0 {
+			resource = result.ResourceName
+		}
+
+		switch {
+		case !result.Namespaced && group == "core" && resource == "namespaces":
+			for i := range result.List.Items {
+				item := &result.List.Items[i]
+				path := filepath.Join(w.workdir, "namespaces", item.GetName(), item.GetName()+".yaml")
+				if err := writeYAML(item, path); err != nil {
+					return err
+				}
+			}
+		case !result.Namespaced:
+			for i := range result.List.Items {
+				item := &result.List.Items[i]
+				path := filepath.Join(w.workdir, "cluster-scoped-resources", group, resource, item.GetName()+".yaml")
+				if err := writeYAML(item, path); err != nil {
+					return err
+				}
+			}
+		case result.ListKind == "PodList":
+			if err := w.writePods(result); err != nil {
+				return err
+			}
+		default:
+			path := filepath.Join(w.workdir, "namespaces", result.Namespace, group, resource+".yaml")
+			if err := writeYAML(result.List, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writePods saves each pod of a namespace, and the logs of its containers, in its own directory.
+// Pods are saved concurrently.
+func (w *MustGatherWriter) writePods(result SearchResult) error {
+	errs := make([]error, len(result.List.Items))
+	runWorkers(len(result.List.Items), w.workers, func(i int) {
+		errs[i] = w.writePod(result.Namespace, result.List.Items[i])
+	})
+	return firstError(errs)
+}
+
+// writePod saves the pod, and the logs of its containers, in its own directory
+func (w *MustGatherWriter) writePod(namespace string, podItem unstructured.Unstructured) error {
+	podDir := filepath.Join(w.workdir, "namespaces", namespace, "pods", podItem.GetName())
+	if err := writeYAML(&podItem, filepath.Join(podDir, podItem.GetName()+".yaml")); err != nil {
+		return err
+	}
+	if !w.writeLogs {
+		return nil
+	}
+
+	containers, err := GetContainers(podItem)
+	if err != nil {
+		return err
+	}
+	for _, container := range containers {
+		if !w.containers.Matches(container.Name()) {
+			continue
+		}
+		reader, err := container.Fetch(w.restApi)
+		if err != nil {
+			return err
+		}
+		logPath := filepath.Join(podDir, container.Name(), container.Name(), "logs", "current.log")
+		if err := writeLog(reader, logPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeYAML saves obj, an unstructured object or list, as YAML at path
+func writeYAML(obj runtime.Object, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0744); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("failed to create search result dir: %s", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	logrus.Debugf("kube_capture(): saving %s", path)
+	if err := new(printers.YAMLPrinter).PrintObj(obj, file); err != nil {
+		if wErr := writeError(err, file); wErr != nil {
+			return fmt.Errorf("failed to write previous err [%s] to file: %s", err, wErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// writeLog saves a container log stream at path
+func writeLog(reader io.ReadCloser, path string) error {
+	defer reader.Close()
+	if err := os.MkdirAll(filepath.Dir(path), 0744); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("error creating container log dir: %s", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	logrus.Debugf("Writing pod container log %s", path)
+	if _, err := io.Copy(file, reader); err != nil {
+		cpErr := fmt.Errorf("failed to copy container log:\n%s", err)
+		if wErr := writeError(cpErr, file); wErr != nil {
+			return fmt.Errorf("failed to write previous err [%s] to file: %s", err, wErr)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MustGatherWriter", func() {
+
+	var workdir string
+
+	BeforeEach(func() {
+		dir, err := ioutil.TempDir("", "crashd-must-gather")
+		Expect(err).NotTo(HaveOccurred())
+		workdir = dir
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(workdir)
+	})
+
+	newList := func(kind, namespace string, names ...string) *unstructured.UnstructuredList {
+		list := &unstructured.UnstructuredList{Object: map[string]interface{}{"kind": kind + "List", "apiVersion": "v1"}}
+		for _, name := range names {
+			item := unstructured.Unstructured{Object: map[string]interface{}{"kind": kind, "apiVersion": "v1"}}
+			item.SetName(name)
+			item.SetNamespace(namespace)
+			list.Items = append(list.Items, item)
+		}
+		return list
+	}
+
+	It("saves search results in the must-gather layout", func() {
+		writer, err := NewMustGatherWriter(workdir, "objects", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		err = writer.Write([]SearchResult{
+			{
+				ListKind:             "ClusterOperatorList",
+				GroupVersionResource: schema.GroupVersionResource{Group: "config.openshift.io", Version: "v1", Resource: "clusteroperators"},
+				List:                 newList("ClusterOperator", "", "dns", "network"),
+			},
+			{
+				ListKind:             "NamespaceList",
+				GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "namespaces"},
+				List:                 newList("Namespace", "", "openshift-dns"),
+			},
+			{
+				ListKind:             "ConfigMapList",
+				GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+				Namespaced:           true,
+				Namespace:            "openshift-dns",
+				List:                 newList("ConfigMap", "openshift-dns", "dns-default"),
+			},
+			{
+				ListKind:             "PodList",
+				GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "pods"},
+				Namespaced:           true,
+				Namespace:            "openshift-dns",
+				List:                 newList("Pod", "openshift-dns", "dns-default-abcde"),
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		root := writer.GetResultDir()
+		Expect(root).To(Equal(filepath.Join(workdir, MustGatherDirname)))
+		for _, file := range []string{
+			"cluster-scoped-resources/config.openshift.io/clusteroperators/dns.yaml",
+			"cluster-scoped-resources/config.openshift.io/clusteroperators/network.yaml",
+			"namespaces/openshift-dns/openshift-dns.yaml",
+			"namespaces/openshift-dns/core/configmaps.yaml",
+			"namespaces/openshift-dns/pods/dns-default-abcde/dns-default-abcde.yaml",
+		} {
+			Expect(filepath.Join(root, filepath.FromSlash(file))).To(BeARegularFile())
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(root, "namespaces", "openshift-dns", "core", "configmaps.yaml"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(ContainSubstring("name: dns-default"))
+	})
+
+	It("rejects unsupported layouts", func() {
+		Expect(ValidateLayout(LayoutMustGather)).To(Succeed())
+		Expect(ValidateLayout("omg")).NotTo(Succeed())
+	})
+})
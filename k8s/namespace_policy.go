@@ -0,0 +1,65 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// namespacePolicy, when set, returns an error for the namespaces whose objects must not be collected
+var namespacePolicy struct {
+	sync.RWMutex
+	check func(namespace string) error
+}
+
+// SetNamespacePolicy restricts the namespaces of the objects collected by the clients (searches, pods
+// exec and port forwards, agents, probes, ...) to the namespaces accepted by check. It returns the
+// function restoring the previous restriction.
+func SetNamespacePolicy(check func(namespace string) error) func() {
+	namespacePolicy.Lock()
+	defer namespacePolicy.Unlock()
+	previous := namespacePolicy.check
+	namespacePolicy.check = check
+	return func() {
+		namespacePolicy.Lock()
+		defer namespacePolicy.Unlock()
+		namespacePolicy.check = previous
+	}
+}
+
+// CheckNamespace returns an error when the objects of the namespace must not be collected
+func CheckNamespace(namespace string) error {
+	namespacePolicy.RLock()
+	check := namespacePolicy.check
+	namespacePolicy.RUnlock()
+	if check == nil {
+		return nil
+	}
+	return check(namespace)
+}
+
+// namespacesRestricted returns whether a namespace policy is set
+func namespacesRestricted() bool {
+	namespacePolicy.RLock()
+	defer namespacePolicy.RUnlock()
+	return namespacePolicy.check != nil
+}
+
+// filterNamespaces returns the namespaces accepted by the namespace policy
+func filterNamespaces(namespaces []string) []string {
+	if !namespacesRestricted() {
+		return namespaces
+	}
+	var allowed []string
+	for _, ns := range namespaces {
+		if err := CheckNamespace(ns); err != nil {
+			logrus.Debugf("Skipping namespace %s: %s", ns, err)
+			continue
+		}
+		allowed = append(allowed, ns)
+	}
+	return allowed
+}
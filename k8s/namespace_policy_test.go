@@ -0,0 +1,34 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("namespace policy", func() {
+	It("accepts all namespaces without a policy", func() {
+		Expect(CheckNamespace("kube-system")).To(Succeed())
+		Expect(filterNamespaces([]string{"default", "kube-system"})).To(Equal([]string{"default", "kube-system"}))
+	})
+
+	It("filters the namespaces refused by the policy", func() {
+		restore := SetNamespacePolicy(func(namespace string) error {
+			if namespace != "team-a" {
+				return fmt.Errorf("namespace %s not allowed", namespace)
+			}
+			return nil
+		})
+		defer restore()
+
+		Expect(CheckNamespace("team-a")).To(Succeed())
+		Expect(CheckNamespace("kube-system")).NotTo(Succeed())
+		Expect(filterNamespaces([]string{"team-a", "kube-system"})).To(Equal([]string{"team-a"}))
+		_, err := PprofPath(PprofTargetPod, "kube-system", "etcd", 2381, "heap")
+		Expect(err).To(HaveOccurred())
+	})
+})
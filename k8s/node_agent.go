@@ -0,0 +1,251 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+	"github.com/vmware-tanzu/crash-diagnostics/tracing"
+)
+
+const (
+	// DefaultNodeAgentImage is the image of the node agent, which only needs sh, sleep, and chroot
+	DefaultNodeAgentImage = "busybox:1.36"
+	// DefaultNodeAgentNamespace is the namespace of the node agent
+	DefaultNodeAgentNamespace = "kube-system"
+	// NodeAgentHostRoot is where the root file system of the node is mounted in the agent pods
+	NodeAgentHostRoot = "/host"
+	// NodeAgentLabel labels the DaemonSet, and the pods, of the node agents
+	NodeAgentLabel = "app.kubernetes.io/name=crashd-agent"
+
+	nodeAgentContainer = "agent"
+)
+
+// NodeAgentOptions configure the node agent deployed by DeployNodeAgent
+type NodeAgentOptions struct {
+	// Namespace of the DaemonSet, DefaultNodeAgentNamespace when empty
+	Namespace string
+	// Image of the agent, DefaultNodeAgentImage when empty
+	Image string
+	// Nodes running the agent, all the nodes when empty
+	Nodes []string
+	// Timeout is the time allowed for the agent to be running on the nodes
+	Timeout time.Duration
+}
+
+// NodeAgent is a short-lived privileged DaemonSet, with the root file system of each node mounted
+// at NodeAgentHostRoot, running commands on the nodes through the API server (pods/exec), for
+// clusters where SSH is not allowed. The agent must be deleted once the commands are done.
+type NodeAgent struct {
+	client    *Client
+	Namespace string
+	Name      string
+	// pods are the agent pods, by node
+	pods map[string]string
+}
+
+// DeployNodeAgent creates the node agent DaemonSet, and waits for its pods to be running on the nodes.
+// The DaemonSet is deleted when its pods are not running in time.
+func (k8sc *Client) DeployNodeAgent(opts NodeAgentOptions) (*NodeAgent, error) {
+	if len(opts.Namespace) == 0 {
+		opts.Namespace = DefaultNodeAgentNamespace
+	}
+	if err := CheckNamespace(opts.Namespace); err != nil {
+		return nil, errors.Wrap(err, "failed to create node agent")
+	}
+	if len(opts.Image) == 0 {
+		opts.Image = DefaultNodeAgentImage
+	}
+	agent := &NodeAgent{
+		client:    k8sc,
+		Namespace: opts.Namespace,
+		Name:      "crashd-agent-" + utilrand.String(5),
+	}
+
+	data, err := json.Marshal(nodeAgentDaemonSet(agent.Namespace, agent.Name, opts))
+	if err != nil {
+		return nil, err
+	}
+	dsPath := fmt.Sprintf("/apis/apps/v1/namespaces/%s/daemonsets", agent.Namespace)
+	span := tracing.Start(audit.ActionAgentDeploy, tracing.String("crashd.agent", agent.Name))
+	err = k8sc.CoreRest.Post().AbsPath(dsPath).SetHeader("Content-Type", "application/json").Body(data).Do().Error()
+	audit.Record(k8sc.Host, audit.LocalUser(), audit.ActionAgentDeploy, fmt.Sprintf("%s/%s", dsPath, agent.Name), err)
+	span.End(err)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create node agent %s/%s", agent.Namespace, agent.Name)
+	}
+	logrus.Debugf("Node agent %s/%s created", agent.Namespace, agent.Name)
+
+	if err := agent.waitRunning(opts.Nodes, opts.Timeout); err != nil {
+		if deleteErr := agent.Delete(); deleteErr != nil {
+			logrus.Error(deleteErr)
+		}
+		return nil, err
+	}
+	return agent, nil
+}
+
+// nodeAgentDaemonSet returns the DaemonSet of the node agent: privileged pods, in the host PID and
+// network namespaces, tolerating all taints, that sleep until they are deleted
+func nodeAgentDaemonSet(namespace, name string, opts NodeAgentOptions) *appsv1.DaemonSet {
+	labels := map[string]string{"app.kubernetes.io/name": "crashd-agent", "app.kubernetes.io/instance": name}
+	privileged := true
+	gracePeriod := int64(1)
+	hostPathType := corev1.HostPathDirectory
+
+	var affinity *corev1.Affinity
+	if len(opts.Nodes) > 0 {
+		affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchFields: []corev1.NodeSelectorRequirement{{
+						Key:      "metadata.name",
+						Operator: corev1.NodeSelectorOpIn,
+						Values:   opts.Nodes,
+					}},
+				}},
+			},
+		}}
+	}
+
+	return &appsv1.DaemonSet{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "DaemonSet"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					HostPID:                       true,
+					HostNetwork:                   true,
+					Affinity:                      affinity,
+					Tolerations:                   []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+					TerminationGracePeriodSeconds: &gracePeriod,
+					Containers: []corev1.Container{{
+						Name:            nodeAgentContainer,
+						Image:           opts.Image,
+						Command:         []string{"sh", "-c", "trap 'exit 0' TERM; sleep 86400 & wait"},
+						SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+						VolumeMounts:    []corev1.VolumeMount{{Name: "host", MountPath: NodeAgentHostRoot, ReadOnly: true}},
+					}},
+					Volumes: []corev1.Volume{{
+						Name:         "host",
+						VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/", Type: &hostPathType}},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// waitRunning waits for an agent pod to be running on each node (on the nodes of the
+// DaemonSet when nodes is empty), and records the pods
+func (a *NodeAgent) waitRunning(nodes []string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		pods, scheduled, err := a.runningPods()
+		if err != nil {
+			return err
+		}
+		a.pods = pods
+
+		var pending []string
+		if len(nodes) == 0 {
+			pending = scheduled
+		}
+		for _, node := range nodes {
+			if _, ok := pods[node]; !ok {
+				pending = append(pending, node)
+			}
+		}
+		if len(pending) == 0 && len(pods) > 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("node agent %s/%s not running on nodes %v after %s", a.Namespace, a.Name, pending, timeout)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// runningPods returns the running agent pods by node, and the nodes of the agent pods that are not running yet
+func (a *NodeAgent) runningPods() (map[string]string, []string, error) {
+	var pods corev1.PodList
+	err := a.client.CoreRest.Get().
+		AbsPath(fmt.Sprintf("/api/v1/namespaces/%s/pods", a.Namespace)).
+		Param("labelSelector", "app.kubernetes.io/instance="+a.Name).
+		Do().Into(&pods)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to list the pods of node agent %s/%s", a.Namespace, a.Name)
+	}
+
+	running := make(map[string]string)
+	var pending []string
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodRunning && pod.DeletionTimestamp == nil {
+			running[pod.Spec.NodeName] = pod.Name
+			continue
+		}
+		pending = append(pending, pod.Spec.NodeName)
+	}
+	return running, pending, nil
+}
+
+// Nodes returns the nodes running the agent
+func (a *NodeAgent) Nodes() []string {
+	var nodes []string
+	for node := range a.pods {
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// Exec runs the command (program and arguments) in the agent pod of the node, streaming its stdout
+// and stderr, and returns its exit status
+func (a *NodeAgent) Exec(node string, command []string, stdout, stderr io.Writer) (int, error) {
+	pod, ok := a.pods[node]
+	if !ok {
+		return -1, fmt.Errorf("node agent %s/%s is not running on node %s", a.Namespace, a.Name, node)
+	}
+
+	span := tracing.Start(audit.ActionAgentExec, tracing.String("net.peer.name", node), tracing.String("crashd.pod", pod))
+	exitCode, err := a.client.streamExec(a.Namespace, pod, nodeAgentContainer, command, stdout, stderr)
+	audit.Record(node, audit.LocalUser(), audit.ActionAgentExec, fmt.Sprintf("%v", command), err)
+	span.End(err)
+
+	if exitCode >= 0 {
+		return exitCode, nil
+	}
+	return exitCode, errors.Wrapf(err, "failed to exec in node agent pod %s/%s", a.Namespace, pod)
+}
+
+// Delete deletes the DaemonSet, and its pods, of the node agent
+func (a *NodeAgent) Delete() error {
+	dsPath := fmt.Sprintf("/apis/apps/v1/namespaces/%s/daemonsets/%s", a.Namespace, a.Name)
+	propagation := metav1.DeletePropagationBackground
+	data, err := json.Marshal(&metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil {
+		return err
+	}
+	span := tracing.Start(audit.ActionAgentDelete, tracing.String("crashd.agent", a.Name))
+	err = a.client.CoreRest.Delete().AbsPath(dsPath).SetHeader("Content-Type", "application/json").Body(data).Do().Error()
+	audit.Record(a.client.Host, audit.LocalUser(), audit.ActionAgentDelete, dsPath, err)
+	span.End(err)
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete node agent %s/%s", a.Namespace, a.Name)
+	}
+	logrus.Debugf("Node agent %s/%s deleted", a.Namespace, a.Name)
+	return nil
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("nodeAgentDaemonSet", func() {
+	It("runs privileged pods with the root of the nodes mounted", func() {
+		ds := nodeAgentDaemonSet("kube-system", "crashd-agent-abcde", NodeAgentOptions{Image: "busybox:1.36"})
+		Expect(ds.Namespace).To(Equal("kube-system"))
+		Expect(ds.Spec.Selector.MatchLabels).To(Equal(ds.Spec.Template.Labels))
+		Expect(ds.Spec.Template.Labels).To(HaveKeyWithValue("app.kubernetes.io/instance", "crashd-agent-abcde"))
+
+		spec := ds.Spec.Template.Spec
+		Expect(spec.HostPID).To(BeTrue())
+		Expect(spec.Affinity).To(BeNil())
+		Expect(spec.Tolerations).To(ConsistOf(corev1.Toleration{Operator: corev1.TolerationOpExists}))
+		Expect(spec.Containers).To(HaveLen(1))
+		Expect(*spec.Containers[0].SecurityContext.Privileged).To(BeTrue())
+		Expect(spec.Containers[0].VolumeMounts[0].MountPath).To(Equal(NodeAgentHostRoot))
+		Expect(spec.Containers[0].VolumeMounts[0].ReadOnly).To(BeTrue())
+		Expect(spec.Volumes[0].HostPath.Path).To(Equal("/"))
+	})
+
+	It("is scheduled on the selected nodes", func() {
+		ds := nodeAgentDaemonSet("default", "crashd-agent-abcde", NodeAgentOptions{Nodes: []string{"node-1", "node-2"}})
+		terms := ds.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+		Expect(terms).To(HaveLen(1))
+		Expect(terms[0].MatchFields[0].Key).To(Equal("metadata.name"))
+		Expect(terms[0].MatchFields[0].Values).To(Equal([]string{"node-1", "node-2"}))
+	})
+})
@@ -0,0 +1,44 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+	"github.com/vmware-tanzu/crash-diagnostics/tracing"
+)
+
+// NodeProxyPath returns the API server path used to reach path on a node-local service.
+// A port of 0 targets the kubelet API; otherwise scheme (http when empty) and port select
+// the service listening on the node.
+func NodeProxyPath(node, scheme string, port int, path string) string {
+	target := node
+	if port > 0 {
+		target = fmt.Sprintf("%s:%d", node, port)
+		if len(scheme) > 0 && scheme != "http" {
+			target = fmt.Sprintf("%s:%s", scheme, target)
+		}
+	}
+	return fmt.Sprintf("/api/v1/nodes/%s/proxy/%s", target, strings.TrimPrefix(path, "/"))
+}
+
+// NodeProxyGet returns the response of a GET request sent, through the API server proxy,
+// to path on a node-local service (see NodeProxyPath)
+func (k8sc *Client) NodeProxyGet(node, scheme string, port int, path string) (io.ReadCloser, error) {
+	proxyPath := NodeProxyPath(node, scheme, port, path)
+	span := tracing.Start(audit.ActionAPIProxy, tracing.String("net.peer.name", node), tracing.String("crashd.path", path))
+	req := k8sc.CoreRest.Get().AbsPath(proxyPath)
+	stream, err := req.Stream()
+	audit.Record(node, audit.LocalUser(), audit.ActionAPIProxy, proxyPath, err)
+	span.End(err)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s from node %s", path, node)
+	}
+	return stream, nil
+}
@@ -0,0 +1,22 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NodeProxyPath", func() {
+	DescribeTable("building the API server proxy path of a node-local service",
+		func(scheme string, port int, path, expected string) {
+			Expect(NodeProxyPath("node-1", scheme, port, path)).To(Equal(expected))
+		},
+		Entry("kubelet API", "", 0, "/metrics/cadvisor", "/api/v1/nodes/node-1/proxy/metrics/cadvisor"),
+		Entry("node port", "", 9100, "metrics", "/api/v1/nodes/node-1:9100/proxy/metrics"),
+		Entry("http scheme", "http", 9100, "/metrics", "/api/v1/nodes/node-1:9100/proxy/metrics"),
+		Entry("https scheme", "https", 9443, "/healthz", "/api/v1/nodes/https:node-1:9443/proxy/healthz"),
+	)
+})
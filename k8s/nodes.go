@@ -9,7 +9,8 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
-func GetNodeAddresses(kubeconfigPath string, labels, names []string) ([]string, error) {
+// GetNodeAddresses returns the internal IP addresses of cluster nodes matching the names and labels filters
+func GetNodeAddresses(kubeconfigPath string, names, labels []string) ([]string, error) {
 	client, err := New(kubeconfigPath)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not initialize search client")
@@ -27,6 +28,42 @@ func GetNodeAddresses(kubeconfigPath string, labels, names []string) ([]string,
 	return nodeIps, nil
 }
 
+// GetNodeProviderIDs returns the provider IDs (spec.providerID, i.e. the resource IDs of the VMs of
+// the nodes) of cluster nodes matching the names and labels filters. Nodes without provider ID are skipped.
+func GetNodeProviderIDs(kubeconfigPath string, names, labels []string) ([]string, error) {
+	client, err := New(kubeconfigPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not initialize search client")
+	}
+
+	nodes, err := getNodes(client, names, labels)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not fetch nodes")
+	}
+
+	var providerIDs []string
+	for _, node := range nodes {
+		if len(node.Spec.ProviderID) > 0 {
+			providerIDs = append(providerIDs, node.Spec.ProviderID)
+		}
+	}
+	return providerIDs, nil
+}
+
+// GetNodeNames returns the names of cluster nodes matching the names and labels filters
+func GetNodeNames(k8sc *Client, names, labels []string) ([]string, error) {
+	nodes, err := getNodes(k8sc, names, labels)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not fetch nodes")
+	}
+
+	var nodeNames []string
+	for _, node := range nodes {
+		nodeNames = append(nodeNames, node.Name)
+	}
+	return nodeNames, nil
+}
+
 func getNodes(k8sc *Client, names, labels []string) ([]*coreV1.Node, error) {
 	nodeResults, err := k8sc.Search(SearchParams{
 		Groups: []string{"core"},
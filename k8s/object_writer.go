@@ -9,8 +9,26 @@ import (
 	"k8s.io/cli-runtime/pkg/printers"
 )
 
+// Output formats of the files written for search results. Object fields are written in
+// sorted order in both formats, so that files from different runs can be compared.
+const (
+	OutputFormatJSON = "json"
+	OutputFormatYAML = "yaml"
+)
+
+// ValidateOutputFormat returns an error when format is not a supported output format
+func ValidateOutputFormat(format string) error {
+	switch format {
+	case OutputFormatJSON, OutputFormatYAML:
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format %q (supported: %s, %s)", format, OutputFormatJSON, OutputFormatYAML)
+	}
+}
+
 type ObjectWriter struct {
 	writeDir string
+	format   string
 }
 
 func (w ObjectWriter) Write(result SearchResult) (string, error) {
@@ -22,7 +40,11 @@ func (w ObjectWriter) Write(result SearchResult) (string, error) {
 		return "", fmt.Errorf("failed to create search result dir: %s", err)
 	}
 
-	path := filepath.Join(resultDir, fmt.Sprintf("%s.json", result.ResourceName))
+	format := w.format
+	if len(format) == 0 {
+		format = OutputFormatJSON
+	}
+	path := filepath.Join(resultDir, fmt.Sprintf("%s.%s", result.ResourceName, format))
 	file, err := os.Create(path)
 	if err != nil {
 		return "", err
@@ -31,7 +53,10 @@ func (w ObjectWriter) Write(result SearchResult) (string, error) {
 
 	logrus.Debugf("kube_capture(): saving %s search results to: %s", result.ResourceName, path)
 
-	printer := new(printers.JSONPrinter)
+	var printer printers.ResourcePrinter = new(printers.JSONPrinter)
+	if format == OutputFormatYAML {
+		printer = new(printers.YAMLPrinter)
+	}
 	if err := printer.PrintObj(result.List, file); err != nil {
 		if wErr := writeError(err, file); wErr != nil {
 			return "", fmt.Errorf("failed to write previous err [%s] to file: %s", err, wErr)
@@ -0,0 +1,60 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+	"github.com/vmware-tanzu/crash-diagnostics/tracing"
+)
+
+// ExecPod runs the command (program and arguments) in a container of the pod (the only container of the pod
+// when empty), streaming its stdout and stderr, and returns its exit status
+func (k8sc *Client) ExecPod(namespace, pod, container string, command []string, stdout, stderr io.Writer) (int, error) {
+	if err := CheckNamespace(namespace); err != nil {
+		return -1, errors.Wrapf(err, "failed to exec in pod %s/%s", namespace, pod)
+	}
+	span := tracing.Start(audit.ActionPodExec, tracing.String("crashd.pod", namespace+"/"+pod))
+	exitCode, err := k8sc.streamExec(namespace, pod, container, command, stdout, stderr)
+	audit.Record(k8sc.Host, audit.LocalUser(), audit.ActionPodExec, fmt.Sprintf("%s/%s %v", namespace, pod, command), err)
+	span.End(err)
+
+	if exitCode >= 0 {
+		return exitCode, nil
+	}
+	return exitCode, errors.Wrapf(err, "failed to exec in pod %s/%s", namespace, pod)
+}
+
+// streamExec runs the command in the container with the exec subresource of the pod. The exit status is -1
+// when the command could not be run, with the error.
+func (k8sc *Client) streamExec(namespace, pod, container string, command []string, stdout, stderr io.Writer) (int, error) {
+	req := k8sc.CoreRest.Post().
+		AbsPath(fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/exec", namespace, pod)).
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(k8sc.Config, "POST", req.URL())
+	if err == nil {
+		err = executor.Stream(remotecommand.StreamOptions{Stdout: stdout, Stderr: stderr})
+	}
+	if err == nil {
+		return 0, nil
+	}
+	if exitErr, ok := err.(utilexec.ExitError); ok {
+		return exitErr.ExitStatus(), err
+	}
+	return -1, err
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+	"github.com/vmware-tanzu/crash-diagnostics/tracing"
+)
+
+// PortForward forwards a port of the local machine, on 127.0.0.1, to a port of a pod through the API server
+type PortForward struct {
+	Namespace string
+	Pod       string
+	Port      int
+	LocalPort int
+
+	stopCh chan struct{}
+	done   chan struct{}
+}
+
+// PortForward forwards localPort (a free port when 0) to port of the pod, and returns once the local port
+// is listening. The forward runs until it is closed.
+func (k8sc *Client) PortForward(namespace, pod string, port, localPort int, timeout time.Duration) (*PortForward, error) {
+	if err := CheckNamespace(namespace); err != nil {
+		return nil, errors.Wrapf(err, "failed to forward port %d of pod %s/%s", port, namespace, pod)
+	}
+	forwardPath := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/portforward", namespace, pod)
+	span := tracing.Start(audit.ActionPortForward, tracing.String("crashd.pod", namespace+"/"+pod))
+	fwd, err := k8sc.portForward(forwardPath, port, localPort, timeout)
+	audit.Record(k8sc.Host, audit.LocalUser(), audit.ActionPortForward, fmt.Sprintf("%s %d", forwardPath, port), err)
+	span.End(err)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to forward port %d of pod %s/%s", port, namespace, pod)
+	}
+	fwd.Namespace, fwd.Pod = namespace, pod
+	logrus.Debugf("Forwarding 127.0.0.1:%d to %s/%s:%d", fwd.LocalPort, namespace, pod, port)
+	return fwd, nil
+}
+
+func (k8sc *Client) portForward(forwardPath string, port, localPort int, timeout time.Duration) (*PortForward, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(k8sc.Config)
+	if err != nil {
+		return nil, err
+	}
+	req := k8sc.CoreRest.Post().AbsPath(forwardPath)
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", req.URL())
+
+	fwd := &PortForward{Port: port, stopCh: make(chan struct{}), done: make(chan struct{})}
+	readyCh := make(chan struct{})
+	var errOut bytes.Buffer
+	forwarder, err := portforward.NewOnAddresses(
+		dialer, []string{"127.0.0.1"}, []string{fmt.Sprintf("%d:%d", localPort, port)},
+		fwd.stopCh, readyCh, ioutil.Discard, &errOut,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(fwd.done)
+		errCh <- forwarder.ForwardPorts()
+	}()
+
+	select {
+	case <-readyCh:
+	case err := <-errCh:
+		if err == nil {
+			err = fmt.Errorf("port forward stopped: %s", strings.TrimSpace(errOut.String()))
+		}
+		return nil, err
+	case <-time.After(timeout):
+		close(fwd.stopCh)
+		return nil, fmt.Errorf("port forward not ready after %s", timeout)
+	}
+
+	ports, err := forwarder.GetPorts()
+	if err != nil || len(ports) == 0 {
+		close(fwd.stopCh)
+		return nil, fmt.Errorf("port forward: local port not found: %v", err)
+	}
+	fwd.LocalPort = int(ports[0].Local)
+	return fwd, nil
+}
+
+// Address returns the local address of the forward (127.0.0.1:<local port>)
+func (f *PortForward) Address() string {
+	return fmt.Sprintf("127.0.0.1:%d", f.LocalPort)
+}
+
+// Close stops the forward, and waits for the local port to be closed
+func (f *PortForward) Close() {
+	close(f.stopCh)
+	<-f.done
+	logrus.Debugf("Port forward 127.0.0.1:%d to %s/%s:%d closed", f.LocalPort, f.Namespace, f.Pod, f.Port)
+}
+
+// RunningPod returns the name of a running pod of the namespace matching the labels
+func (k8sc *Client) RunningPod(namespace string, labels []string) (string, error) {
+	pods, err := k8sc.RunningPods(namespace, labels)
+	if err != nil {
+		return "", err
+	}
+	if len(pods) == 0 {
+		return "", fmt.Errorf("no running pod with labels %s in namespace %s", strings.Join(labels, ","), namespace)
+	}
+	return pods[0].Name, nil
+}
+
+// RunningPods returns the running pods of the namespace (all namespaces when empty) matching the labels.
+// The pods of the namespaces refused by the namespace policy are not returned.
+func (k8sc *Client) RunningPods(namespace string, labels []string) ([]corev1.Pod, error) {
+	podsPath := "/api/v1/pods"
+	if len(namespace) > 0 {
+		if err := CheckNamespace(namespace); err != nil {
+			return nil, err
+		}
+		podsPath = fmt.Sprintf("/api/v1/namespaces/%s/pods", namespace)
+	}
+	var pods corev1.PodList
+	err := k8sc.CoreRest.Get().
+		AbsPath(podsPath).
+		Param("labelSelector", strings.Join(labels, ",")).
+		Do().Into(&pods)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list pods with labels %s", strings.Join(labels, ","))
+	}
+	var running []corev1.Pod
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodRunning && pod.DeletionTimestamp == nil && CheckNamespace(pod.Namespace) == nil {
+			running = append(running, pod)
+		}
+	}
+	return running, nil
+}
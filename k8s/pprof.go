@@ -0,0 +1,64 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+)
+
+const (
+	PprofDirname = "pprof"
+
+	PprofTargetAPIServer = "apiserver"
+	PprofTargetKubelet   = "kubelet"
+	PprofTargetPod       = "pod"
+)
+
+// PprofPath returns the API server path used to reach a pprof profile for the
+// specified target.  Kubelet and pod endpoints are reached via the API server proxy.
+func PprofPath(target, namespace, name string, port int, profile string) (string, error) {
+	switch target {
+	case PprofTargetAPIServer:
+		return fmt.Sprintf("/debug/pprof/%s", profile), nil
+	case PprofTargetKubelet:
+		if name == "" {
+			return "", errors.New("pprof: kubelet target requires a node name")
+		}
+		return fmt.Sprintf("/api/v1/nodes/%s/proxy/debug/pprof/%s", name, profile), nil
+	case PprofTargetPod:
+		if name == "" {
+			return "", errors.New("pprof: pod target requires a pod name")
+		}
+		if namespace == "" {
+			namespace = "default"
+		}
+		if err := CheckNamespace(namespace); err != nil {
+			return "", errors.Wrap(err, "pprof")
+		}
+		return fmt.Sprintf("/api/v1/namespaces/%s/pods/%s:%d/proxy/debug/pprof/%s", namespace, name, port, profile), nil
+	default:
+		return "", errors.Errorf("pprof: unsupported target %s", target)
+	}
+}
+
+// FetchPprof returns a stream for the profile found at the specified API server path.
+// The seconds param is only applied to profiles that sample over a period of time.
+func (k8sc *Client) FetchPprof(path, profile string, seconds int) (io.ReadCloser, error) {
+	req := k8sc.CoreRest.Get().AbsPath(path)
+	if seconds > 0 && (profile == "profile" || profile == "trace") {
+		req = req.Param("seconds", strconv.Itoa(seconds))
+	}
+	stream, err := req.Stream()
+	audit.Record(req.URL().Host, audit.LocalUser(), audit.ActionAPIGet, req.URL().Path, err)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch pprof %s", path)
+	}
+	return stream, nil
+}
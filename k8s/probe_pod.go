@@ -0,0 +1,135 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+	"github.com/vmware-tanzu/crash-diagnostics/tracing"
+)
+
+const (
+	// ProbePodLabel labels the probe pods
+	ProbePodLabel = "app.kubernetes.io/name=crashd-probe"
+
+	probePodContainer = "probe"
+	// probePodDeadline bounds the life of a probe pod that could not be deleted
+	probePodDeadline = int64(3600)
+)
+
+// ProbePod is a short-lived unprivileged pod, running commands through the API server (pods/exec) to
+// probe the cluster from the pod network (i.e. DNS lookups). The pod must be deleted once the commands
+// are done; it is stopped by its active deadline otherwise.
+type ProbePod struct {
+	client    *Client
+	Namespace string
+	Name      string
+}
+
+// CreateProbePod creates a probe pod running the image (DefaultNodeAgentImage when empty), and waits
+// for it to be running. The pod is deleted when it is not running in time.
+func (k8sc *Client) CreateProbePod(namespace, image string, timeout time.Duration) (*ProbePod, error) {
+	if err := CheckNamespace(namespace); err != nil {
+		return nil, errors.Wrap(err, "failed to create probe pod")
+	}
+	if len(image) == 0 {
+		image = DefaultNodeAgentImage
+	}
+	probe := &ProbePod{client: k8sc, Namespace: namespace, Name: "crashd-probe-" + utilrand.String(5)}
+
+	data, err := json.Marshal(probePod(probe.Namespace, probe.Name, image))
+	if err != nil {
+		return nil, err
+	}
+	podsPath := fmt.Sprintf("/api/v1/namespaces/%s/pods", probe.Namespace)
+	span := tracing.Start(audit.ActionProbeCreate, tracing.String("crashd.pod", probe.Namespace+"/"+probe.Name))
+	err = k8sc.CoreRest.Post().AbsPath(podsPath).SetHeader("Content-Type", "application/json").Body(data).Do().Error()
+	audit.Record(k8sc.Host, audit.LocalUser(), audit.ActionProbeCreate, fmt.Sprintf("%s/%s", podsPath, probe.Name), err)
+	span.End(err)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create probe pod %s/%s", probe.Namespace, probe.Name)
+	}
+	logrus.Debugf("Probe pod %s/%s created", probe.Namespace, probe.Name)
+
+	if err := probe.waitRunning(timeout); err != nil {
+		if deleteErr := probe.Delete(); deleteErr != nil {
+			logrus.Error(deleteErr)
+		}
+		return nil, err
+	}
+	return probe, nil
+}
+
+// probePod returns the probe pod, that sleeps until it is deleted, or its deadline
+func probePod(namespace, name, image string) *corev1.Pod {
+	labels := map[string]string{"app.kubernetes.io/name": "crashd-probe", "app.kubernetes.io/instance": name}
+	gracePeriod := int64(1)
+	deadline := probePodDeadline
+	return &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels},
+		Spec: corev1.PodSpec{
+			RestartPolicy:                 corev1.RestartPolicyNever,
+			ActiveDeadlineSeconds:         &deadline,
+			TerminationGracePeriodSeconds: &gracePeriod,
+			Containers: []corev1.Container{{
+				Name:    probePodContainer,
+				Image:   image,
+				Command: []string{"sh", "-c", fmt.Sprintf("trap 'exit 0' TERM; sleep %d & wait", probePodDeadline)},
+			}},
+		},
+	}
+}
+
+// waitRunning waits for the probe pod to be running
+func (p *ProbePod) waitRunning(timeout time.Duration) error {
+	podPath := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", p.Namespace, p.Name)
+	deadline := time.Now().Add(timeout)
+	for {
+		var pod corev1.Pod
+		if err := p.client.CoreRest.Get().AbsPath(podPath).Do().Into(&pod); err != nil {
+			return errors.Wrapf(err, "failed to get probe pod %s/%s", p.Namespace, p.Name)
+		}
+		switch pod.Status.Phase {
+		case corev1.PodRunning:
+			return nil
+		case corev1.PodFailed, corev1.PodSucceeded:
+			return fmt.Errorf("probe pod %s/%s stopped: %s %s", p.Namespace, p.Name, pod.Status.Reason, pod.Status.Message)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("probe pod %s/%s not running after %s", p.Namespace, p.Name, timeout)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// Exec runs the command (program and arguments) in the probe pod, streaming its stdout and stderr, and
+// returns its exit status
+func (p *ProbePod) Exec(command []string, stdout, stderr io.Writer) (int, error) {
+	return p.client.ExecPod(p.Namespace, p.Name, probePodContainer, command, stdout, stderr)
+}
+
+// Delete deletes the probe pod
+func (p *ProbePod) Delete() error {
+	podPath := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", p.Namespace, p.Name)
+	span := tracing.Start(audit.ActionProbeDelete, tracing.String("crashd.pod", p.Namespace+"/"+p.Name))
+	err := p.client.CoreRest.Delete().AbsPath(podPath).Do().Error()
+	audit.Record(p.client.Host, audit.LocalUser(), audit.ActionProbeDelete, podPath, err)
+	span.End(err)
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete probe pod %s/%s", p.Namespace, p.Name)
+	}
+	logrus.Debugf("Probe pod %s/%s deleted", p.Namespace, p.Name)
+	return nil
+}
@@ -0,0 +1,25 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("probePod", func() {
+	It("runs an unprivileged pod bounded by a deadline", func() {
+		pod := probePod("default", "crashd-probe-abcde", "busybox:1.36")
+		Expect(pod.Namespace).To(Equal("default"))
+		Expect(pod.Labels).To(HaveKeyWithValue("app.kubernetes.io/name", "crashd-probe"))
+		Expect(pod.Spec.RestartPolicy).To(Equal(corev1.RestartPolicyNever))
+		Expect(*pod.Spec.ActiveDeadlineSeconds).To(Equal(probePodDeadline))
+		Expect(pod.Spec.HostNetwork).To(BeFalse())
+		Expect(pod.Spec.Containers).To(HaveLen(1))
+		Expect(pod.Spec.Containers[0].Name).To(Equal(probePodContainer))
+		Expect(pod.Spec.Containers[0].Image).To(Equal("busybox:1.36"))
+		Expect(pod.Spec.Containers[0].SecurityContext).To(BeNil())
+	})
+})
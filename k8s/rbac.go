@@ -0,0 +1,256 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+)
+
+const (
+	RBACDirname = "rbac"
+
+	// RBACClusterScope is the scope of the permissions granted by ClusterRoleBindings
+	RBACClusterScope = "*"
+)
+
+// RBACSnapshot holds the RBAC objects of a cluster
+type RBACSnapshot struct {
+	Roles               []rbacv1.Role               `json:"roles"`
+	ClusterRoles        []rbacv1.ClusterRole        `json:"clusterRoles"`
+	RoleBindings        []rbacv1.RoleBinding        `json:"roleBindings"`
+	ClusterRoleBindings []rbacv1.ClusterRoleBinding `json:"clusterRoleBindings"`
+}
+
+// RBACSubject is a user, group, or service account whose effective permissions are computed
+type RBACSubject struct {
+	// Kind is rbacv1.UserKind, rbacv1.GroupKind, or rbacv1.ServiceAccountKind
+	Kind      string
+	Name      string
+	Namespace string
+}
+
+// String returns the name of the subject as seen by the API server (i.e. system:serviceaccount:<namespace>:<name>)
+func (s RBACSubject) String() string {
+	if s.Kind == rbacv1.ServiceAccountKind {
+		return fmt.Sprintf("system:serviceaccount:%s:%s", s.Namespace, s.Name)
+	}
+	return s.Name
+}
+
+// ScopedRules are the policy rules granted to a subject in a namespace, or cluster-wide (RBACClusterScope)
+type ScopedRules struct {
+	Namespace string
+	Rules     []rbacv1.PolicyRule
+}
+
+// GetRBACSnapshot lists the Roles, ClusterRoles, RoleBindings, and ClusterRoleBindings of the cluster
+func (k8sc *Client) GetRBACSnapshot() (*RBACSnapshot, error) {
+	snapshot := &RBACSnapshot{}
+	lists := []struct {
+		resource string
+		add      func(obj map[string]interface{}) error
+	}{
+		{"roles", func(obj map[string]interface{}) error {
+			var role rbacv1.Role
+			err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj, &role)
+			snapshot.Roles = append(snapshot.Roles, role)
+			return err
+		}},
+		{"clusterroles", func(obj map[string]interface{}) error {
+			var role rbacv1.ClusterRole
+			err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj, &role)
+			snapshot.ClusterRoles = append(snapshot.ClusterRoles, role)
+			return err
+		}},
+		{"rolebindings", func(obj map[string]interface{}) error {
+			var binding rbacv1.RoleBinding
+			err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj, &binding)
+			snapshot.RoleBindings = append(snapshot.RoleBindings, binding)
+			return err
+		}},
+		{"clusterrolebindings", func(obj map[string]interface{}) error {
+			var binding rbacv1.ClusterRoleBinding
+			err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj, &binding)
+			snapshot.ClusterRoleBindings = append(snapshot.ClusterRoleBindings, binding)
+			return err
+		}},
+	}
+
+	for _, l := range lists {
+		gvr := schema.GroupVersionResource{Group: rbacv1.GroupName, Version: "v1", Resource: l.resource}
+		list, err := k8sc.Client.Resource(gvr).List(metav1.ListOptions{})
+		audit.Record(k8sc.Host, audit.LocalUser(), audit.ActionAPIList, fmt.Sprintf("%s --all-namespaces", gvr.String()), err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %s", l.resource, err)
+		}
+		for _, item := range list.Items {
+			if ns := item.GetNamespace(); len(ns) > 0 && CheckNamespace(ns) != nil {
+				continue
+			}
+			if err := l.add(item.Object); err != nil {
+				return nil, fmt.Errorf("failed to read %s %s: %s", l.resource, item.GetName(), err)
+			}
+		}
+	}
+	return snapshot, nil
+}
+
+// Permissions returns the effective permissions of the subject: the rules of the roles bound to the
+// subject, or to its groups (including the implicit groups of service accounts and authenticated
+// users), cluster-wide first, then per namespace
+func (s *RBACSnapshot) Permissions(subject RBACSubject) []ScopedRules {
+	clusterRoles := make(map[string]rbacv1.ClusterRole)
+	for _, role := range s.ClusterRoles {
+		clusterRoles[role.Name] = role
+	}
+	roles := make(map[string]rbacv1.Role)
+	for _, role := range s.Roles {
+		roles[role.Namespace+"/"+role.Name] = role
+	}
+
+	byScope := make(map[string][]rbacv1.PolicyRule)
+	for _, binding := range s.ClusterRoleBindings {
+		if binding.RoleRef.Kind == "ClusterRole" && bindsSubject(binding.Subjects, "", subject) {
+			byScope[RBACClusterScope] = append(byScope[RBACClusterScope], clusterRoles[binding.RoleRef.Name].Rules...)
+		}
+	}
+	for _, binding := range s.RoleBindings {
+		if !bindsSubject(binding.Subjects, binding.Namespace, subject) {
+			continue
+		}
+		switch binding.RoleRef.Kind {
+		case "ClusterRole":
+			byScope[binding.Namespace] = append(byScope[binding.Namespace], clusterRoles[binding.RoleRef.Name].Rules...)
+		case "Role":
+			byScope[binding.Namespace] = append(byScope[binding.Namespace], roles[binding.Namespace+"/"+binding.RoleRef.Name].Rules...)
+		}
+	}
+
+	var scopes []string
+	for scope, rules := range byScope {
+		if len(rules) > 0 {
+			scopes = append(scopes, scope)
+		}
+	}
+	// RBACClusterScope sorts first
+	sort.Strings(scopes)
+
+	var permissions []ScopedRules
+	for _, scope := range scopes {
+		permissions = append(permissions, ScopedRules{Namespace: scope, Rules: byScope[scope]})
+	}
+	return permissions
+}
+
+// bindsSubject returns true if the subjects of a binding, in namespace (empty for ClusterRoleBindings),
+// include the subject or one of its groups
+func bindsSubject(subjects []rbacv1.Subject, namespace string, subject RBACSubject) bool {
+	groups := subjectGroups(subject)
+	for _, s := range subjects {
+		switch s.Kind {
+		case rbacv1.UserKind:
+			if subject.Kind != rbacv1.GroupKind && s.Name == subject.String() {
+				return true
+			}
+		case rbacv1.GroupKind:
+			for _, group := range groups {
+				if s.Name == group {
+					return true
+				}
+			}
+		case rbacv1.ServiceAccountKind:
+			saNamespace := s.Namespace
+			if len(saNamespace) == 0 {
+				saNamespace = namespace
+			}
+			if subject.Kind == rbacv1.ServiceAccountKind && s.Name == subject.Name && saNamespace == subject.Namespace {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// subjectGroups returns the groups of the subject, as authenticated by the API server
+func subjectGroups(subject RBACSubject) []string {
+	switch subject.Kind {
+	case rbacv1.GroupKind:
+		return []string{subject.Name}
+	case rbacv1.ServiceAccountKind:
+		return []string{"system:serviceaccounts", "system:serviceaccounts:" + subject.Namespace, "system:authenticated"}
+	default:
+		return []string{"system:authenticated"}
+	}
+}
+
+// WritePermissions writes the permissions in the format of kubectl auth can-i --list, one table
+// per scope, merging the verbs of rules on the same resources
+func WritePermissions(w io.Writer, subject RBACSubject, permissions []ScopedRules) error {
+	fmt.Fprintf(w, "Subject: %s %s\n", subject.Kind, subject)
+	if len(permissions) == 0 {
+		fmt.Fprintln(w, "\nNo permissions")
+		return nil
+	}
+
+	for _, scoped := range permissions {
+		scope := "Namespace: " + scoped.Namespace
+		if scoped.Namespace == RBACClusterScope {
+			scope = "Cluster-wide"
+		}
+		fmt.Fprintf(w, "\n%s\n", scope)
+
+		verbs := make(map[string]map[string]bool)
+		var keys []string
+		addRow := func(key string, ruleVerbs []string) {
+			if _, ok := verbs[key]; !ok {
+				verbs[key] = make(map[string]bool)
+				keys = append(keys, key)
+			}
+			for _, verb := range ruleVerbs {
+				verbs[key][verb] = true
+			}
+		}
+		for _, rule := range scoped.Rules {
+			names := "[" + strings.Join(rule.ResourceNames, " ") + "]"
+			for _, group := range rule.APIGroups {
+				for _, resource := range rule.Resources {
+					if len(group) > 0 {
+						resource = resource + "." + group
+					}
+					addRow(strings.Join([]string{resource, "[]", names}, "\t"), rule.Verbs)
+				}
+			}
+			for _, url := range rule.NonResourceURLs {
+				addRow(strings.Join([]string{"", "[" + url + "]", "[]"}, "\t"), rule.Verbs)
+			}
+		}
+		sort.Strings(keys)
+
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "Resources\tNon-Resource URLs\tResource Names\tVerbs")
+		for _, key := range keys {
+			var verbList []string
+			for verb := range verbs[key] {
+				verbList = append(verbList, verb)
+			}
+			sort.Strings(verbList)
+			fmt.Fprintf(tw, "%s\t[%s]\n", key, strings.Join(verbList, " "))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
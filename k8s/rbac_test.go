@@ -0,0 +1,91 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("RBAC effective permissions", func() {
+	var snapshot *RBACSnapshot
+
+	BeforeEach(func() {
+		rule := func(group, resource string, verbs ...string) rbacv1.PolicyRule {
+			return rbacv1.PolicyRule{APIGroups: []string{group}, Resources: []string{resource}, Verbs: verbs}
+		}
+		snapshot = &RBACSnapshot{
+			ClusterRoles: []rbacv1.ClusterRole{
+				{ObjectMeta: metav1.ObjectMeta{Name: "node-reader"}, Rules: []rbacv1.PolicyRule{rule("", "nodes", "get", "list")}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "discovery"}, Rules: []rbacv1.PolicyRule{{NonResourceURLs: []string{"/version"}, Verbs: []string{"get"}}}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "edit"}, Rules: []rbacv1.PolicyRule{rule("apps", "deployments", "get", "update")}},
+			},
+			Roles: []rbacv1.Role{
+				{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "pod-reader"}, Rules: []rbacv1.PolicyRule{rule("", "pods", "get"), rule("", "pods", "list")}},
+			},
+			ClusterRoleBindings: []rbacv1.ClusterRoleBinding{
+				{
+					RoleRef:  rbacv1.RoleRef{Kind: "ClusterRole", Name: "node-reader"},
+					Subjects: []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Namespace: "shop", Name: "web"}},
+				},
+				{
+					RoleRef:  rbacv1.RoleRef{Kind: "ClusterRole", Name: "discovery"},
+					Subjects: []rbacv1.Subject{{Kind: rbacv1.GroupKind, Name: "system:authenticated"}},
+				},
+			},
+			RoleBindings: []rbacv1.RoleBinding{
+				{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "readers"},
+					RoleRef:    rbacv1.RoleRef{Kind: "Role", Name: "pod-reader"},
+					Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "web"}, {Kind: rbacv1.UserKind, Name: "alice"}},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "editors"},
+					RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "edit"},
+					Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, Name: "developers"}},
+				},
+			},
+		}
+	})
+
+	It("computes the permissions of a service account", func() {
+		permissions := snapshot.Permissions(RBACSubject{Kind: rbacv1.ServiceAccountKind, Namespace: "shop", Name: "web"})
+		Expect(permissions).To(HaveLen(2))
+		Expect(permissions[0].Namespace).To(Equal(RBACClusterScope))
+		Expect(permissions[0].Rules).To(HaveLen(2))
+		Expect(permissions[1].Namespace).To(Equal("shop"))
+		Expect(permissions[1].Rules).To(HaveLen(2))
+
+		Expect(snapshot.Permissions(RBACSubject{Kind: rbacv1.ServiceAccountKind, Namespace: "other", Name: "web"})).To(HaveLen(1))
+	})
+
+	It("computes the permissions of users and groups", func() {
+		permissions := snapshot.Permissions(RBACSubject{Kind: rbacv1.UserKind, Name: "alice"})
+		Expect(permissions).To(HaveLen(2))
+		Expect(permissions[1].Rules[0].Resources).To(Equal([]string{"pods"}))
+
+		permissions = snapshot.Permissions(RBACSubject{Kind: rbacv1.GroupKind, Name: "developers"})
+		Expect(permissions).To(HaveLen(1))
+		Expect(permissions[0].Namespace).To(Equal("shop"))
+	})
+
+	It("writes the permissions as kubectl auth can-i --list", func() {
+		subject := RBACSubject{Kind: rbacv1.ServiceAccountKind, Namespace: "shop", Name: "web"}
+		var buf bytes.Buffer
+		Expect(WritePermissions(&buf, subject, snapshot.Permissions(subject))).To(Succeed())
+
+		output := buf.String()
+		Expect(output).To(ContainSubstring("Subject: ServiceAccount system:serviceaccount:shop:web"))
+		Expect(output).To(ContainSubstring("Cluster-wide"))
+		Expect(output).To(ContainSubstring("Namespace: shop"))
+		Expect(output).To(MatchRegexp(`nodes\s+\[\]\s+\[\]\s+\[get list\]`))
+		Expect(output).To(MatchRegexp(`\s+\[/version\]\s+\[\]\s+\[get\]`))
+		Expect(output).To(MatchRegexp(`pods\s+\[\]\s+\[\]\s+\[get list\]`))
+	})
+})
@@ -0,0 +1,270 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+)
+
+// relatedResource is a resource of the objects of a related object graph
+type relatedResource struct {
+	gvr        schema.GroupVersionResource
+	kind       string
+	namespaced bool
+}
+
+var (
+	// childResources are searched for the objects owned by the objects of the graph
+	childResources = []relatedResource{
+		{gvr: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}, kind: "ReplicaSet", namespaced: true},
+		{gvr: schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}, kind: "Job", namespaced: true},
+		{gvr: schema.GroupVersionResource{Version: "v1", Resource: "pods"}, kind: "Pod", namespaced: true},
+	}
+	servicesResource  = relatedResource{gvr: schema.GroupVersionResource{Version: "v1", Resource: "services"}, kind: "Service", namespaced: true}
+	endpointsResource = relatedResource{gvr: schema.GroupVersionResource{Version: "v1", Resource: "endpoints"}, kind: "Endpoints", namespaced: true}
+)
+
+// relatedGraph is a set of related objects, keyed by UID
+type relatedGraph struct {
+	objects   map[types.UID]unstructured.Unstructured
+	resources map[types.UID]relatedResource
+	order     []types.UID
+}
+
+func newRelatedGraph() *relatedGraph {
+	return &relatedGraph{
+		objects:   make(map[types.UID]unstructured.Unstructured),
+		resources: make(map[types.UID]relatedResource),
+	}
+}
+
+// add adds the object to the graph and returns false if it was already in the graph
+func (g *relatedGraph) add(res relatedResource, obj unstructured.Unstructured) bool {
+	if _, ok := g.objects[obj.GetUID()]; ok {
+		return false
+	}
+	g.objects[obj.GetUID()] = obj
+	g.resources[obj.GetUID()] = res
+	g.order = append(g.order, obj.GetUID())
+	return true
+}
+
+// ownedBy returns true if an owner of obj is in the graph
+func (g *relatedGraph) ownedBy(obj unstructured.Unstructured) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if _, ok := g.objects[ref.UID]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pods returns the pods of the graph in namespace
+func (g *relatedGraph) pods(namespace string) []unstructured.Unstructured {
+	var pods []unstructured.Unstructured
+	for _, uid := range g.order {
+		obj := g.objects[uid]
+		if g.resources[uid].kind == "Pod" && obj.GetNamespace() == namespace {
+			pods = append(pods, obj)
+		}
+	}
+	return pods
+}
+
+// namespaces returns the namespaces of the objects of the graph
+func (g *relatedGraph) namespaces() []string {
+	seen := make(map[string]bool)
+	var namespaces []string
+	for _, uid := range g.order {
+		obj := g.objects[uid]
+		ns := obj.GetNamespace()
+		if len(ns) > 0 && !seen[ns] {
+			seen[ns] = true
+			namespaces = append(namespaces, ns)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// results returns the objects of the graph as search results, one per resource and namespace
+func (g *relatedGraph) results() []SearchResult {
+	byKey := make(map[string]*SearchResult)
+	var keys []string
+	for _, uid := range g.order {
+		obj, res := g.objects[uid], g.resources[uid]
+		key := fmt.Sprintf("%s/%s", res.gvr.String(), obj.GetNamespace())
+		result, ok := byKey[key]
+		if !ok {
+			list := &unstructured.UnstructuredList{}
+			list.SetAPIVersion("v1")
+			list.SetKind(res.kind + "List")
+			result = &SearchResult{
+				ListKind:             res.kind + "List",
+				ResourceName:         res.gvr.Resource,
+				ResourceKind:         res.kind,
+				Namespaced:           res.namespaced,
+				Namespace:            obj.GetNamespace(),
+				GroupVersionResource: res.gvr,
+				List:                 list,
+			}
+			byKey[key] = result
+			keys = append(keys, key)
+		}
+		result.List.Items = append(result.List.Items, obj)
+	}
+
+	sort.Strings(keys)
+	results := make([]SearchResult, 0, len(keys))
+	for _, key := range keys {
+		results = append(results, *byKey[key])
+	}
+	return results
+}
+
+// selectsAny returns true if the selector of the service matches the labels of any of the pods
+func selectsAny(service unstructured.Unstructured, pods []unstructured.Unstructured) bool {
+	selector, found, err := unstructured.NestedStringMap(service.Object, "spec", "selector")
+	if err != nil || !found || len(selector) == 0 {
+		return false
+	}
+	sel := labels.SelectorFromSet(selector)
+	for _, pod := range pods {
+		if sel.Matches(labels.Set(pod.GetLabels())) {
+			return true
+		}
+	}
+	return false
+}
+
+// RelatedObjects returns the graph of objects related to the objects of the search results: their owners,
+// walking ownerReferences up (i.e. Pod, ReplicaSet, Deployment), the objects they own, walking down
+// (ReplicaSets, Jobs, and Pods), and the Services selecting the pods of the graph with their Endpoints.
+// The objects are returned as search results, one per resource and namespace.
+func (k8sc *Client) RelatedObjects(roots []SearchResult) ([]SearchResult, error) {
+	graph := newRelatedGraph()
+	for _, root := range roots {
+		if root.List == nil {
+			continue
+		}
+		res := relatedResource{gvr: root.GroupVersionResource, kind: root.ResourceKind, namespaced: root.Namespaced}
+		for _, item := range root.List.Items {
+			graph.add(res, item)
+		}
+	}
+	if len(graph.order) == 0 {
+		return nil, nil
+	}
+
+	// walk up the owner references
+	resources := make(map[string]relatedResource)
+	for i := 0; i < len(graph.order); i++ {
+		obj := graph.objects[graph.order[i]]
+		for _, ref := range obj.GetOwnerReferences() {
+			if _, ok := graph.objects[ref.UID]; ok {
+				continue
+			}
+			res, err := k8sc.resourceForKind(resources, ref.APIVersion, ref.Kind)
+			if err != nil {
+				logrus.Warnf("RelatedObjects: skipping owner %s/%s of %s: %s", ref.Kind, ref.Name, obj.GetName(), err)
+				continue
+			}
+			owner, err := k8sc.Client.Resource(res.gvr).Namespace(obj.GetNamespace()).Get(ref.Name, metav1.GetOptions{})
+			audit.Record(k8sc.Host, audit.LocalUser(), audit.ActionAPIGet, fmt.Sprintf("%s %s -n %s", res.gvr.String(), ref.Name, obj.GetNamespace()), err)
+			if err != nil {
+				logrus.Warnf("RelatedObjects: failed to get owner %s/%s of %s: %s", ref.Kind, ref.Name, obj.GetName(), err)
+				continue
+			}
+			graph.add(res, *owner)
+		}
+	}
+
+	for _, ns := range graph.namespaces() {
+		// walk down to the owned objects, until no object is added
+		var candidates []unstructured.Unstructured
+		var candidateResources []relatedResource
+		for _, res := range childResources {
+			list, err := k8sc.Client.Resource(res.gvr).Namespace(ns).List(metav1.ListOptions{})
+			audit.Record(k8sc.Host, audit.LocalUser(), audit.ActionAPIList, fmt.Sprintf("%s -n %s", res.gvr.String(), ns), err)
+			if err != nil {
+				logrus.Warnf("RelatedObjects: failed to list %s in %s: %s", res.gvr.Resource, ns, err)
+				continue
+			}
+			for _, item := range list.Items {
+				candidates = append(candidates, item)
+				candidateResources = append(candidateResources, res)
+			}
+		}
+		for added := true; added; {
+			added = false
+			for i, candidate := range candidates {
+				if graph.ownedBy(candidate) && graph.add(candidateResources[i], candidate) {
+					added = true
+				}
+			}
+		}
+
+		// services selecting the pods, and their endpoints
+		pods := graph.pods(ns)
+		if len(pods) == 0 {
+			continue
+		}
+		services, err := k8sc.Client.Resource(servicesResource.gvr).Namespace(ns).List(metav1.ListOptions{})
+		audit.Record(k8sc.Host, audit.LocalUser(), audit.ActionAPIList, fmt.Sprintf("%s -n %s", servicesResource.gvr.String(), ns), err)
+		if err != nil {
+			logrus.Warnf("RelatedObjects: failed to list services in %s: %s", ns, err)
+			continue
+		}
+		for _, service := range services.Items {
+			if !selectsAny(service, pods) || !graph.add(servicesResource, service) {
+				continue
+			}
+			endpoints, err := k8sc.Client.Resource(endpointsResource.gvr).Namespace(ns).Get(service.GetName(), metav1.GetOptions{})
+			audit.Record(k8sc.Host, audit.LocalUser(), audit.ActionAPIGet, fmt.Sprintf("%s %s -n %s", endpointsResource.gvr.String(), service.GetName(), ns), err)
+			if err != nil {
+				logrus.Debugf("RelatedObjects: no endpoints for service %s in %s: %s", service.GetName(), ns, err)
+				continue
+			}
+			graph.add(endpointsResource, *endpoints)
+		}
+	}
+
+	return graph.results(), nil
+}
+
+// resourceForKind returns the resource of kind in the API group version, using discovery,
+// with resources caching the resources found
+func (k8sc *Client) resourceForKind(resources map[string]relatedResource, apiVersion, kind string) (relatedResource, error) {
+	key := fmt.Sprintf("%s/%s", apiVersion, kind)
+	if res, ok := resources[key]; ok {
+		return res, nil
+	}
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return relatedResource{}, err
+	}
+	resourceList, err := k8sc.Disco.ServerResourcesForGroupVersion(apiVersion)
+	if err != nil {
+		return relatedResource{}, err
+	}
+	for _, apiRes := range resourceList.APIResources {
+		if apiRes.Kind == kind && !strings.Contains(apiRes.Name, "/") {
+			res := relatedResource{gvr: gv.WithResource(apiRes.Name), kind: kind, namespaced: apiRes.Namespaced}
+			resources[key] = res
+			return res, nil
+		}
+	}
+	return relatedResource{}, fmt.Errorf("resource of kind %s not found in %s", kind, apiVersion)
+}
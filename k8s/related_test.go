@@ -0,0 +1,71 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Related object graph", func() {
+	object := func(kind, name, uid string, labels map[string]string, owner string) unstructured.Unstructured {
+		obj := unstructured.Unstructured{Object: map[string]interface{}{"kind": kind}}
+		obj.SetNamespace("shop")
+		obj.SetName(name)
+		obj.SetUID(types.UID(uid))
+		obj.SetLabels(labels)
+		if len(owner) > 0 {
+			obj.SetOwnerReferences([]metav1.OwnerReference{{Kind: "ReplicaSet", Name: owner, UID: types.UID(owner)}})
+		}
+		return obj
+	}
+	podsResource := childResources[2]
+	replicaSetsResource := childResources[0]
+
+	It("selects objects owned by the graph", func() {
+		graph := newRelatedGraph()
+		Expect(graph.add(replicaSetsResource, object("ReplicaSet", "web-1", "web-1", nil, ""))).To(BeTrue())
+		Expect(graph.add(replicaSetsResource, object("ReplicaSet", "web-1", "web-1", nil, ""))).To(BeFalse())
+
+		Expect(graph.ownedBy(object("Pod", "web-1-a", "a", nil, "web-1"))).To(BeTrue())
+		Expect(graph.ownedBy(object("Pod", "db-1-a", "b", nil, "db-1"))).To(BeFalse())
+	})
+
+	It("selects services by the labels of the pods", func() {
+		pods := []unstructured.Unstructured{object("Pod", "web-1-a", "a", map[string]string{"app": "web", "tier": "front"}, "")}
+		service := func(selector map[string]interface{}) unstructured.Unstructured {
+			svc := object("Service", "svc", "svc", nil, "")
+			if selector != nil {
+				Expect(unstructured.SetNestedMap(svc.Object, selector, "spec", "selector")).To(Succeed())
+			}
+			return svc
+		}
+
+		Expect(selectsAny(service(map[string]interface{}{"app": "web"}), pods)).To(BeTrue())
+		Expect(selectsAny(service(map[string]interface{}{"app": "db"}), pods)).To(BeFalse())
+		Expect(selectsAny(service(nil), pods)).To(BeFalse())
+	})
+
+	It("returns the graph as search results per resource and namespace", func() {
+		graph := newRelatedGraph()
+		graph.add(podsResource, object("Pod", "web-1-a", "a", nil, "web-1"))
+		graph.add(replicaSetsResource, object("ReplicaSet", "web-1", "web-1", nil, ""))
+		graph.add(podsResource, object("Pod", "web-1-b", "b", nil, "web-1"))
+
+		Expect(graph.namespaces()).To(Equal([]string{"shop"}))
+		Expect(graph.pods("shop")).To(HaveLen(2))
+
+		results := graph.results()
+		Expect(results).To(HaveLen(2))
+		Expect(results[0].ResourceName).To(Equal("pods"))
+		Expect(results[0].ListKind).To(Equal("PodList"))
+		Expect(results[0].Namespace).To(Equal("shop"))
+		Expect(results[0].List.Items).To(HaveLen(2))
+		Expect(results[1].ResourceName).To(Equal("replicasets"))
+	})
+})
@@ -5,17 +5,29 @@ import (
 	"os"
 	"path/filepath"
 
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/client-go/rest"
 )
 
 type ResultWriter struct {
-	workdir   string
-	writeLogs bool
-	restApi   rest.Interface
+	workdir    string
+	writeLogs  bool
+	format     string
+	containers ContainerFilter
+	restApi    rest.Interface
+	workers    int
 }
 
-func NewResultWriter(workdir, what string, restApi rest.Interface) (*ResultWriter, error) {
+// NewResultWriter returns a writer that saves search results, in the output format
+// (OutputFormatJSON if empty), under the working directory
+func NewResultWriter(workdir, what, format string, restApi rest.Interface) (*ResultWriter, error) {
 	var err error
+	if len(format) == 0 {
+		format = OutputFormatJSON
+	}
+	if err := ValidateOutputFormat(format); err != nil {
+		return nil, err
+	}
 	workdir = filepath.Join(workdir, BaseDirname)
 	if err := os.MkdirAll(workdir, 0744); err != nil && !os.IsExist(err) {
 		return nil, err
@@ -25,10 +37,22 @@ func NewResultWriter(workdir, what string, restApi rest.Interface) (*ResultWrite
 	return &ResultWriter{
 		workdir:   workdir,
 		writeLogs: writeLogs,
+		format:    format,
 		restApi:   restApi,
+		workers:   getAPIWorkers(),
 	}, err
 }
 
+// SetContainers limits the logs written to the containers selected by the name patterns (see ContainerFilter)
+func (w *ResultWriter) SetContainers(patterns []string) error {
+	filter, err := NewContainerFilter(patterns)
+	if err != nil {
+		return err
+	}
+	w.containers = filter
+	return nil
+}
+
 func (w *ResultWriter) GetResultDir() string {
 	return w.workdir
 }
@@ -43,6 +67,7 @@ func (w *ResultWriter) Write(searchResults []SearchResult) error {
 	for _, result := range searchResults {
 		objWriter := ObjectWriter{
 			writeDir: w.workdir,
+			format:   w.format,
 		}
 		writeDir, err := objWriter.Write(result)
 		if err != nil {
@@ -50,32 +75,44 @@ func (w *ResultWriter) Write(searchResults []SearchResult) error {
 		}
 
 		if w.writeLogs && result.ListKind == "PodList" {
-			if len(result.List.Items) == 0 {
-				continue
-			}
-			for _, podItem := range result.List.Items {
-				logDir := filepath.Join(writeDir, podItem.GetName())
-				if err := os.MkdirAll(logDir, 0744); err != nil && !os.IsExist(err) {
-					return fmt.Errorf("failed to create pod log dir: %s", err)
-				}
-
-				containers, err := GetContainers(podItem)
-				if err != nil {
-					return err
-				}
-				for _, containerLogger := range containers {
-					reader, err := containerLogger.Fetch(w.restApi)
-					if err != nil {
-						return err
-					}
-					err = containerLogger.Write(reader, logDir)
-					if err != nil {
-						return err
-					}
-				}
+			// the logs of the pods are retrieved concurrently
+			pods := result.List.Items
+			errs := make([]error, len(pods))
+			runWorkers(len(pods), w.workers, func(i int) {
+				errs[i] = w.writePodLogs(pods[i], writeDir)
+			})
+			if err := firstError(errs); err != nil {
+				return err
 			}
 		}
 	}
 
 	return nil
 }
+
+// writePodLogs saves the logs of the containers of the pod in a directory, named after the pod, of writeDir
+func (w *ResultWriter) writePodLogs(podItem unstructured.Unstructured, writeDir string) error {
+	logDir := filepath.Join(writeDir, podItem.GetName())
+	if err := os.MkdirAll(logDir, 0744); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("failed to create pod log dir: %s", err)
+	}
+
+	containers, err := GetContainers(podItem)
+	if err != nil {
+		return err
+	}
+	for _, containerLogger := range containers {
+		if !w.containers.Matches(containerLogger.Name()) {
+			continue
+		}
+		reader, err := containerLogger.Fetch(w.restApi)
+		if err != nil {
+			return err
+		}
+		err = containerLogger.Write(reader, logDir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+)
+
+const SchedulingDirname = "scheduling"
+
+// NodeAllocation is the allocatable resources of a node, and the resources requested by its pods
+type NodeAllocation struct {
+	Name          string
+	Unschedulable bool
+	Taints        []string
+	// CPU in millicores, and memory in bytes
+	AllocatableCPU    int64
+	AllocatableMemory int64
+	AllocatablePods   int64
+	RequestedCPU      int64
+	RequestedMemory   int64
+	Pods              int64
+}
+
+// PendingPod is a pod waiting to be scheduled, with the reasons reported by the scheduler and autoscalers
+type PendingPod struct {
+	Namespace     string
+	Name          string
+	PriorityClass string
+	Priority      int32
+	Created       time.Time
+	// Reasons are the latest event of each reason (i.e. FailedScheduling, NotTriggerScaleUp), most recent first
+	Reasons []string
+}
+
+// SchedulingSnapshot is the state of the scheduling of a cluster
+type SchedulingSnapshot struct {
+	Nodes   []NodeAllocation
+	Pending []PendingPod
+}
+
+// GetSchedulingSnapshot computes the allocatable and requested resources of the nodes, and lists the pending pods
+// with the reasons of their scheduling events
+func (k8sc *Client) GetSchedulingSnapshot() (*SchedulingSnapshot, error) {
+	var nodes corev1.NodeList
+	if err := k8sc.list("/api/v1/nodes", "", &nodes); err != nil {
+		return nil, err
+	}
+	// pods using resources, or waiting for them
+	var pods corev1.PodList
+	if err := k8sc.list("/api/v1/pods", "status.phase!=Succeeded,status.phase!=Failed", &pods); err != nil {
+		return nil, err
+	}
+	var events corev1.EventList
+	if err := k8sc.list("/api/v1/events", "involvedObject.kind=Pod", &events); err != nil {
+		return nil, err
+	}
+	// the requests of the pods of namespaces refused by the namespace policy are counted, but they are not listed
+	var listed []corev1.Event
+	for _, event := range events.Items {
+		if CheckNamespace(event.Namespace) == nil {
+			listed = append(listed, event)
+		}
+	}
+	snapshot := newSchedulingSnapshot(nodes.Items, pods.Items, listed)
+	pending := snapshot.Pending[:0]
+	for _, pod := range snapshot.Pending {
+		if CheckNamespace(pod.Namespace) == nil {
+			pending = append(pending, pod)
+		}
+	}
+	snapshot.Pending = pending
+	return snapshot, nil
+}
+
+// list gets the list of all the objects of the API path, matching the field selector when not empty
+func (k8sc *Client) list(path, fieldSelector string, into runtime.Object) error {
+	req := k8sc.CoreRest.Get().AbsPath(path)
+	if len(fieldSelector) > 0 {
+		req = req.Param("fieldSelector", fieldSelector)
+	}
+	err := req.Do().Into(into)
+	audit.Record(k8sc.Host, audit.LocalUser(), audit.ActionAPIList, path, err)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %s", path, err)
+	}
+	return nil
+}
+
+func newSchedulingSnapshot(nodes []corev1.Node, pods []corev1.Pod, events []corev1.Event) *SchedulingSnapshot {
+	snapshot := &SchedulingSnapshot{}
+	allocations := make(map[string]*NodeAllocation)
+	for _, node := range nodes {
+		alloc := NodeAllocation{
+			Name:              node.Name,
+			Unschedulable:     node.Spec.Unschedulable,
+			AllocatableCPU:    node.Status.Allocatable.Cpu().MilliValue(),
+			AllocatableMemory: node.Status.Allocatable.Memory().Value(),
+			AllocatablePods:   node.Status.Allocatable.Pods().Value(),
+		}
+		for _, taint := range node.Spec.Taints {
+			alloc.Taints = append(alloc.Taints, taint.ToString())
+		}
+		snapshot.Nodes = append(snapshot.Nodes, alloc)
+	}
+	sort.Slice(snapshot.Nodes, func(i, j int) bool { return snapshot.Nodes[i].Name < snapshot.Nodes[j].Name })
+	for i := range snapshot.Nodes {
+		allocations[snapshot.Nodes[i].Name] = &snapshot.Nodes[i]
+	}
+
+	pending := make(map[string]*PendingPod)
+	for _, pod := range pods {
+		if len(pod.Spec.NodeName) == 0 {
+			if pod.Status.Phase == corev1.PodPending && pod.DeletionTimestamp == nil {
+				p := PendingPod{
+					Namespace:     pod.Namespace,
+					Name:          pod.Name,
+					PriorityClass: pod.Spec.PriorityClassName,
+					Created:       pod.CreationTimestamp.Time,
+				}
+				if pod.Spec.Priority != nil {
+					p.Priority = *pod.Spec.Priority
+				}
+				snapshot.Pending = append(snapshot.Pending, p)
+			}
+			continue
+		}
+		alloc, ok := allocations[pod.Spec.NodeName]
+		if !ok {
+			continue
+		}
+		cpu, memory := podRequests(pod)
+		alloc.RequestedCPU += cpu
+		alloc.RequestedMemory += memory
+		alloc.Pods++
+	}
+	sort.Slice(snapshot.Pending, func(i, j int) bool {
+		if snapshot.Pending[i].Namespace != snapshot.Pending[j].Namespace {
+			return snapshot.Pending[i].Namespace < snapshot.Pending[j].Namespace
+		}
+		return snapshot.Pending[i].Name < snapshot.Pending[j].Name
+	})
+	for i := range snapshot.Pending {
+		pending[snapshot.Pending[i].Namespace+"/"+snapshot.Pending[i].Name] = &snapshot.Pending[i]
+	}
+
+	// latest event of each reason of the pending pods
+	sort.Slice(events, func(i, j int) bool { return eventTime(events[i]).After(eventTime(events[j])) })
+	seen := make(map[string]bool)
+	for _, event := range events {
+		p, ok := pending[event.InvolvedObject.Namespace+"/"+event.InvolvedObject.Name]
+		if !ok {
+			continue
+		}
+		key := event.InvolvedObject.Namespace + "/" + event.InvolvedObject.Name + "/" + event.Reason
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		p.Reasons = append(p.Reasons, fmt.Sprintf("%s: %s", event.Reason, strings.TrimSpace(event.Message)))
+	}
+	return snapshot
+}
+
+// podRequests returns the CPU (in millicores) and memory (in bytes) requested by the pod, computed like the
+// scheduler: the largest of the sum of the containers and of each init container, plus the pod overhead
+func podRequests(pod corev1.Pod) (int64, int64) {
+	var cpu, memory int64
+	for _, c := range pod.Spec.Containers {
+		cpu += c.Resources.Requests.Cpu().MilliValue()
+		memory += c.Resources.Requests.Memory().Value()
+	}
+	for _, c := range pod.Spec.InitContainers {
+		if initCPU := c.Resources.Requests.Cpu().MilliValue(); initCPU > cpu {
+			cpu = initCPU
+		}
+		if initMemory := c.Resources.Requests.Memory().Value(); initMemory > memory {
+			memory = initMemory
+		}
+	}
+	cpu += pod.Spec.Overhead.Cpu().MilliValue()
+	memory += pod.Spec.Overhead.Memory().Value()
+	return cpu, memory
+}
+
+func eventTime(event corev1.Event) time.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+	if !event.EventTime.IsZero() {
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
+
+// CPUPercent returns the percentage of the allocatable CPU of the node requested by its pods
+func (n NodeAllocation) CPUPercent() int64 {
+	return percent(n.RequestedCPU, n.AllocatableCPU)
+}
+
+// MemoryPercent returns the percentage of the allocatable memory of the node requested by its pods
+func (n NodeAllocation) MemoryPercent() int64 {
+	return percent(n.RequestedMemory, n.AllocatableMemory)
+}
+
+func percent(requested, allocatable int64) int64 {
+	if allocatable == 0 {
+		return 0
+	}
+	return requested * 100 / allocatable
+}
+
+// WriteNodeAllocations writes the allocatable and requested resources of the nodes as a table
+func WriteNodeAllocations(w io.Writer, nodes []NodeAllocation) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tCPU REQUESTS\tMEMORY REQUESTS\tPODS\tSCHEDULABLE\tTAINTS")
+	for _, n := range nodes {
+		taints := strings.Join(n.Taints, ",")
+		if len(taints) == 0 {
+			taints = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s/%s (%d%%)\t%s/%s (%d%%)\t%d/%d\t%t\t%s\n",
+			n.Name,
+			resource.NewMilliQuantity(n.RequestedCPU, resource.DecimalSI), resource.NewMilliQuantity(n.AllocatableCPU, resource.DecimalSI),
+			n.CPUPercent(),
+			resource.NewQuantity(n.RequestedMemory, resource.BinarySI), resource.NewQuantity(n.AllocatableMemory, resource.BinarySI),
+			n.MemoryPercent(),
+			n.Pods, n.AllocatablePods, !n.Unschedulable, taints,
+		)
+	}
+	return tw.Flush()
+}
+
+// WritePendingPods writes the pending pods, and the reasons they are not scheduled, as a table
+func WritePendingPods(w io.Writer, pending []PendingPod, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tPOD\tPRIORITY\tAGE\tREASON")
+	for _, p := range pending {
+		priority := fmt.Sprintf("%d", p.Priority)
+		if len(p.PriorityClass) > 0 {
+			priority = fmt.Sprintf("%s (%d)", p.PriorityClass, p.Priority)
+		}
+		age := now.Sub(p.Created).Round(time.Second).String()
+		reasons := p.Reasons
+		if len(reasons) == 0 {
+			reasons = []string{"-"}
+		}
+		for i, reason := range reasons {
+			if i == 0 {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", p.Namespace, p.Name, priority, age, reason)
+				continue
+			}
+			fmt.Fprintf(tw, "\t\t\t\t%s\n", reason)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"bytes"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Scheduling snapshot", func() {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	requests := func(cpu, memory string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpu),
+			corev1.ResourceMemory: resource.MustParse(memory),
+		}}
+	}
+	nodes := []corev1.Node{{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-1"},
+		Spec:       corev1.NodeSpec{Taints: []corev1.Taint{{Key: "gpu", Value: "true", Effect: corev1.TaintEffectNoSchedule}}},
+		Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("4"),
+			corev1.ResourceMemory: resource.MustParse("8Gi"),
+			corev1.ResourcePods:   resource.MustParse("110"),
+		}},
+	}}
+	pods := []corev1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web-0"},
+			Spec: corev1.PodSpec{
+				NodeName:       "worker-1",
+				Containers:     []corev1.Container{{Resources: requests("500m", "1Gi")}, {Resources: requests("500m", "1Gi")}},
+				InitContainers: []corev1.Container{{Resources: requests("2", "512Mi")}},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "batch-0", CreationTimestamp: metav1.NewTime(now.Add(-time.Hour))},
+			Spec:       corev1.PodSpec{PriorityClassName: "low", Priority: new(int32)},
+			Status:     corev1.PodStatus{Phase: corev1.PodPending},
+		},
+	}
+	event := func(reason, message string, at time.Time) corev1.Event {
+		return corev1.Event{
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: "shop", Name: "batch-0"},
+			Reason:         reason,
+			Message:        message,
+			LastTimestamp:  metav1.NewTime(at),
+		}
+	}
+	events := []corev1.Event{
+		event("FailedScheduling", "0/1 nodes are available: 1 Insufficient cpu.", now.Add(-30*time.Minute)),
+		event("FailedScheduling", "0/1 nodes are available: 1 node(s) had taint {gpu: true}.", now.Add(-time.Minute)),
+		event("NotTriggerScaleUp", "pod didn't trigger scale-up: 1 max node group size reached", now.Add(-2*time.Minute)),
+	}
+
+	It("sums the requests of the pods of each node", func() {
+		snapshot := newSchedulingSnapshot(nodes, pods, events)
+		Expect(snapshot.Nodes).To(HaveLen(1))
+		node := snapshot.Nodes[0]
+		Expect(node.RequestedCPU).To(Equal(int64(2000)))
+		Expect(node.RequestedMemory).To(Equal(int64(2 << 30)))
+		Expect(node.Pods).To(Equal(int64(1)))
+		Expect(node.Taints).To(Equal([]string{"gpu=true:NoSchedule"}))
+
+		var out bytes.Buffer
+		Expect(WriteNodeAllocations(&out, snapshot.Nodes)).To(Succeed())
+		Expect(out.String()).To(ContainSubstring("2/4 (50%)"))
+		Expect(out.String()).To(ContainSubstring("2Gi/8Gi (25%)"))
+	})
+
+	It("reports the latest event of each reason of the pending pods", func() {
+		snapshot := newSchedulingSnapshot(nodes, pods, events)
+		Expect(snapshot.Pending).To(HaveLen(1))
+		Expect(snapshot.Pending[0].Reasons).To(Equal([]string{
+			"FailedScheduling: 0/1 nodes are available: 1 node(s) had taint {gpu: true}.",
+			"NotTriggerScaleUp: pod didn't trigger scale-up: 1 max node group size reached",
+		}))
+
+		var out bytes.Buffer
+		Expect(WritePendingPods(&out, snapshot.Pending, now)).To(Succeed())
+		Expect(out.String()).To(ContainSubstring("batch-0  low (0)   1h0m0s"))
+	})
+})
@@ -17,6 +17,10 @@ type SearchParams struct {
 	Names      []string
 	Labels     []string
 	Containers []string
+	// ExcludeNamespaces are glob patterns of namespaces excluded from the search
+	ExcludeNamespaces []string
+	// ExcludeKinds are kinds, or resource names, excluded from the search
+	ExcludeKinds []string
 }
 
 func (sp SearchParams) ContainsGroup(group string) bool {
@@ -4,6 +4,9 @@
 package k8s
 
 import (
+	"strconv"
+	"time"
+
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -20,6 +23,52 @@ type SearchResult struct {
 	List                 *unstructured.UnstructuredList
 	Namespaced           bool
 	Namespace            string
+	// Duration is the time taken to list the objects
+	Duration time.Duration
+}
+
+// OmittedSizeAnnotation is set, to the size of the object in bytes, on objects whose content
+// was omitted by LimitObjectSize
+const OmittedSizeAnnotation = "crashd.vmware-tanzu.io/omitted-size"
+
+// lastAppliedAnnotation holds a copy of the object content, omitted along with it
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// LimitObjectSize replaces the objects of the results larger than maxSize bytes, as JSON, with their
+// apiVersion, kind, and metadata, so that large ConfigMaps or Secrets do not bloat bundles. Replaced
+// objects are annotated with OmittedSizeAnnotation. It returns the number of objects replaced.
+func LimitObjectSize(results []SearchResult, maxSize int64) int {
+	omitted := 0
+	for _, result := range results {
+		if result.List == nil {
+			continue
+		}
+		for i, item := range result.List.Items {
+			data, err := item.MarshalJSON()
+			if err != nil || int64(len(data)) <= maxSize {
+				continue
+			}
+
+			obj := item.DeepCopy()
+			stub := unstructured.Unstructured{Object: map[string]interface{}{}}
+			stub.SetAPIVersion(obj.GetAPIVersion())
+			stub.SetKind(obj.GetKind())
+			if metadata, ok := obj.Object["metadata"]; ok {
+				stub.Object["metadata"] = metadata
+			}
+			annotations := stub.GetAnnotations()
+			if annotations == nil {
+				annotations = make(map[string]string)
+			}
+			delete(annotations, lastAppliedAnnotation)
+			annotations[OmittedSizeAnnotation] = strconv.Itoa(len(data))
+			stub.SetAnnotations(annotations)
+
+			result.List.Items[i] = stub
+			omitted++
+		}
+	}
+	return omitted
 }
 
 // ToStarlarkValue converts the SearchResult object to a starlark dictionary
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"strings"
 
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
@@ -184,3 +185,34 @@ var _ = Describe("SearchResult", func() {
 		})
 	})
 })
+
+var _ = Describe("LimitObjectSize", func() {
+	It("replaces objects larger than the maximum size with their metadata", func() {
+		configMap := func(name, data string) unstructured.Unstructured {
+			obj := unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"data":       map[string]interface{}{"payload": data},
+			}}
+			obj.SetName(name)
+			obj.SetAnnotations(map[string]string{lastAppliedAnnotation: data})
+			return obj
+		}
+		results := []SearchResult{{List: &unstructured.UnstructuredList{
+			Items: []unstructured.Unstructured{
+				configMap("small", "x"),
+				configMap("large", strings.Repeat("x", 2048)),
+			},
+		}}}
+
+		Expect(LimitObjectSize(results, 1024)).To(Equal(1))
+		items := results[0].List.Items
+		Expect(items[0].Object).To(HaveKey("data"))
+
+		Expect(items[1].GetName()).To(Equal("large"))
+		Expect(items[1].GetKind()).To(Equal("ConfigMap"))
+		Expect(items[1].Object).NotTo(HaveKey("data"))
+		Expect(items[1].GetAnnotations()).NotTo(HaveKey(lastAppliedAnnotation))
+		Expect(items[1].GetAnnotations()[OmittedSizeAnnotation]).NotTo(BeEmpty())
+	})
+})
@@ -0,0 +1,351 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+)
+
+const WebhooksDirname = "webhooks"
+
+// webhookConfigurationResources are the resources of the admission webhook configurations
+var webhookConfigurationResources = []relatedResource{
+	{gvr: schema.GroupVersionResource{Group: "admissionregistration.k8s.io", Resource: "validatingwebhookconfigurations"}, kind: "ValidatingWebhookConfiguration"},
+	{gvr: schema.GroupVersionResource{Group: "admissionregistration.k8s.io", Resource: "mutatingwebhookconfigurations"}, kind: "MutatingWebhookConfiguration"},
+}
+
+// WebhookStatus is the health of an admission webhook
+type WebhookStatus struct {
+	// Configuration is the kind and name of the webhook configuration
+	Configuration string
+	Webhook       string
+	FailurePolicy string
+	// Service is the webhook service, nil for webhooks called at a URL
+	Service *admissionv1.ServiceReference
+	URL     string
+	// ReadyEndpoints is the number of ready endpoints of the service
+	ReadyEndpoints int
+	// Reachable is set when the webhook answered a probe
+	Reachable bool
+	// CAExpiry is the earliest expiration of the certificates of the CA bundle
+	CAExpiry time.Time
+	// Problems are the reasons the webhook is failing, empty when healthy
+	Problems []string
+}
+
+// Target returns the webhook service (namespace/name:port/path) or URL
+func (s WebhookStatus) Target() string {
+	if s.Service == nil {
+		return s.URL
+	}
+	port := int32(443)
+	if s.Service.Port != nil {
+		port = *s.Service.Port
+	}
+	path := ""
+	if s.Service.Path != nil {
+		path = *s.Service.Path
+	}
+	return fmt.Sprintf("%s/%s:%d%s", s.Service.Namespace, s.Service.Name, port, path)
+}
+
+// GetWebhookConfigurations returns the validating and mutating webhook configurations, in the most
+// recent admissionregistration.k8s.io version served (v1 or v1beta1)
+func (k8sc *Client) GetWebhookConfigurations() ([]SearchResult, error) {
+	version := "v1"
+	if _, err := k8sc.Disco.ServerResourcesForGroupVersion("admissionregistration.k8s.io/v1"); err != nil {
+		version = "v1beta1"
+	}
+
+	var results []SearchResult
+	for _, res := range webhookConfigurationResources {
+		gvr := res.gvr
+		gvr.Version = version
+		list, err := k8sc.Client.Resource(gvr).List(metav1.ListOptions{})
+		audit.Record(k8sc.Host, audit.LocalUser(), audit.ActionAPIList, gvr.String(), err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %s", gvr.Resource, err)
+		}
+		results = append(results, SearchResult{
+			ListKind:             list.GetKind(),
+			ResourceName:         gvr.Resource,
+			ResourceKind:         res.kind,
+			GroupVersionResource: gvr,
+			List:                 list,
+		})
+	}
+	return results, nil
+}
+
+// CheckWebhooks returns the status of the webhooks of the configurations: the ready endpoints of their
+// service, the expiration of their CA bundle and, when probe is set, whether they answer a request sent
+// within timeout (through the API server service proxy for service webhooks, with TLS verified against
+// the CA bundle for URL webhooks)
+func (k8sc *Client) CheckWebhooks(configurations []SearchResult, probe bool, timeout time.Duration) ([]WebhookStatus, error) {
+	var statuses []WebhookStatus
+	for _, result := range configurations {
+		for _, item := range result.List.Items {
+			webhooks, err := webhooksOf(item)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s %s: %s", result.ResourceKind, item.GetName(), err)
+			}
+			for _, webhook := range webhooks {
+				status := newWebhookStatus(fmt.Sprintf("%s/%s", result.ResourceKind, item.GetName()), webhook, time.Now())
+				if status.Service != nil {
+					k8sc.checkWebhookService(&status, probe, timeout)
+				} else if probe {
+					if err := probeWebhookURL(status.URL, webhook.ClientConfig.CABundle, timeout); err != nil {
+						status.Problems = append(status.Problems, fmt.Sprintf("unreachable: %s", err))
+					} else {
+						status.Reachable = true
+					}
+				}
+				statuses = append(statuses, status)
+			}
+		}
+	}
+	return statuses, nil
+}
+
+// webhookEntry holds the fields, common to validating and mutating webhooks, used to check them
+type webhookEntry struct {
+	Name          string                          `json:"name"`
+	ClientConfig  admissionv1.WebhookClientConfig `json:"clientConfig"`
+	FailurePolicy *admissionv1.FailurePolicyType  `json:"failurePolicy,omitempty"`
+}
+
+// webhooksOf returns the webhooks of a webhook configuration
+func webhooksOf(configuration unstructured.Unstructured) ([]webhookEntry, error) {
+	var config struct {
+		Webhooks []webhookEntry `json:"webhooks"`
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(configuration.Object, &config); err != nil {
+		return nil, err
+	}
+	return config.Webhooks, nil
+}
+
+// newWebhookStatus returns the status of a webhook with its CA bundle checked at now
+func newWebhookStatus(configuration string, webhook webhookEntry, now time.Time) WebhookStatus {
+	status := WebhookStatus{
+		Configuration: configuration,
+		Webhook:       webhook.Name,
+		FailurePolicy: string(admissionv1.Fail),
+		Service:       webhook.ClientConfig.Service,
+	}
+	if webhook.FailurePolicy != nil {
+		status.FailurePolicy = string(*webhook.FailurePolicy)
+	}
+	if webhook.ClientConfig.URL != nil {
+		status.URL = *webhook.ClientConfig.URL
+	}
+
+	if len(webhook.ClientConfig.CABundle) == 0 {
+		return status
+	}
+	expiry, err := caBundleExpiry(webhook.ClientConfig.CABundle)
+	switch {
+	case err != nil:
+		status.Problems = append(status.Problems, fmt.Sprintf("invalid CA bundle: %s", err))
+	case expiry.Before(now):
+		status.CAExpiry = expiry
+		status.Problems = append(status.Problems, fmt.Sprintf("CA bundle expired on %s", expiry.Format(time.RFC3339)))
+	default:
+		status.CAExpiry = expiry
+	}
+	return status
+}
+
+// caBundleExpiry returns the earliest expiration of the PEM certificates of bundle
+func caBundleExpiry(bundle []byte) (time.Time, error) {
+	var expiry time.Time
+	for block, rest := pem.Decode(bundle); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if expiry.IsZero() || cert.NotAfter.Before(expiry) {
+			expiry = cert.NotAfter
+		}
+	}
+	if expiry.IsZero() {
+		return time.Time{}, fmt.Errorf("no certificate found")
+	}
+	return expiry, nil
+}
+
+// checkWebhookService counts the ready endpoints of the webhook service and, when probe is set,
+// sends a request to the webhook path through the API server service proxy
+func (k8sc *Client) checkWebhookService(status *WebhookStatus, probe bool, timeout time.Duration) {
+	svc := status.Service
+	endpointsPath := fmt.Sprintf("/api/v1/namespaces/%s/endpoints/%s", svc.Namespace, svc.Name)
+	var endpoints corev1.Endpoints
+	err := k8sc.CoreRest.Get().AbsPath(endpointsPath).Timeout(timeout).Do().Into(&endpoints)
+	audit.Record(k8sc.Host, audit.LocalUser(), audit.ActionAPIGet, endpointsPath, err)
+	if err != nil {
+		status.Problems = append(status.Problems, fmt.Sprintf("service endpoints: %s", err))
+		return
+	}
+	for _, subset := range endpoints.Subsets {
+		status.ReadyEndpoints += len(subset.Addresses)
+	}
+	if status.ReadyEndpoints == 0 {
+		status.Problems = append(status.Problems, "no ready endpoints")
+		return
+	}
+	if !probe {
+		return
+	}
+
+	port := int32(443)
+	if svc.Port != nil {
+		port = *svc.Port
+	}
+	path := "/"
+	if svc.Path != nil {
+		path = *svc.Path
+	}
+	proxyPath := fmt.Sprintf("/api/v1/namespaces/%s/services/https:%s:%d/proxy/%s", svc.Namespace, svc.Name, port, strings.TrimPrefix(path, "/"))
+	_, err = k8sc.CoreRest.Get().AbsPath(proxyPath).Timeout(timeout).DoRaw()
+	audit.Record(k8sc.Host, audit.LocalUser(), audit.ActionAPIProxy, proxyPath, err)
+
+	// webhooks expect POSTed admission reviews: any answer of the webhook proves it is reachable,
+	// while the proxy answers 502, 503, or 504 when it cannot reach it
+	if statusErr, ok := err.(*apierrors.StatusError); ok {
+		switch statusErr.Status().Code {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		default:
+			err = nil
+		}
+	}
+	if err != nil {
+		logrus.Debugf("webhook %s: probe failed: %s", status.Webhook, err)
+		status.Problems = append(status.Problems, fmt.Sprintf("unreachable: %s", err))
+		return
+	}
+	status.Reachable = true
+}
+
+// probeWebhookURL opens a TLS connection to the host of the webhook URL, verifying its serving
+// certificate with the CA bundle (or the system CAs when empty)
+func probeWebhookURL(webhookURL string, caBundle []byte, timeout time.Duration) error {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return err
+	}
+	host := u.Host
+	if len(u.Port()) == 0 {
+		host = net.JoinHostPort(u.Hostname(), "443")
+	}
+
+	config := &tls.Config{ServerName: u.Hostname()}
+	if len(caBundle) > 0 {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(caBundle) {
+			return fmt.Errorf("invalid CA bundle")
+		}
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", host, config)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// WebhookPods returns the pods selected by the services of the webhooks, one search result per namespace
+func (k8sc *Client) WebhookPods(statuses []WebhookStatus) ([]SearchResult, error) {
+	podsResource := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	seen := make(map[string]bool)
+	var results []SearchResult
+	for _, status := range statuses {
+		if status.Service == nil {
+			continue
+		}
+		key := status.Service.Namespace + "/" + status.Service.Name
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if err := CheckNamespace(status.Service.Namespace); err != nil {
+			logrus.Debugf("webhook %s: skipping service %s: %s", status.Webhook, key, err)
+			continue
+		}
+
+		servicePath := fmt.Sprintf("/api/v1/namespaces/%s/services/%s", status.Service.Namespace, status.Service.Name)
+		var service corev1.Service
+		err := k8sc.CoreRest.Get().AbsPath(servicePath).Do().Into(&service)
+		audit.Record(k8sc.Host, audit.LocalUser(), audit.ActionAPIGet, servicePath, err)
+		if err != nil {
+			logrus.Warnf("webhook %s: failed to get service %s: %s", status.Webhook, key, err)
+			continue
+		}
+		if len(service.Spec.Selector) == 0 {
+			continue
+		}
+
+		listOptions := metav1.ListOptions{LabelSelector: labels.SelectorFromSet(service.Spec.Selector).String()}
+		list, err := k8sc.Client.Resource(podsResource).Namespace(service.Namespace).List(listOptions)
+		audit.Record(k8sc.Host, audit.LocalUser(), audit.ActionAPIList, fmt.Sprintf("%s -n %s", podsResource.String(), service.Namespace), err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods of service %s: %s", key, err)
+		}
+		results = append(results, SearchResult{
+			ListKind:             list.GetKind(),
+			ResourceName:         podsResource.Resource,
+			ResourceKind:         "Pod",
+			Namespaced:           true,
+			Namespace:            service.Namespace,
+			GroupVersionResource: podsResource,
+			List:                 list,
+		})
+	}
+	return results, nil
+}
+
+// WriteWebhookReport writes a table of the status of each webhook
+func WriteWebhookReport(w io.Writer, statuses []WebhookStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONFIGURATION\tWEBHOOK\tTARGET\tFAILURE POLICY\tENDPOINTS\tREACHABLE\tCA EXPIRY\tSTATUS")
+	for _, s := range statuses {
+		endpoints := "-"
+		if s.Service != nil {
+			endpoints = fmt.Sprintf("%d", s.ReadyEndpoints)
+		}
+		expiry := "-"
+		if !s.CAExpiry.IsZero() {
+			expiry = s.CAExpiry.Format(time.RFC3339)
+		}
+		status := "ok"
+		if len(s.Problems) > 0 {
+			status = "failing: " + strings.Join(s.Problems, "; ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%t\t%s\t%s\n", s.Configuration, s.Webhook, s.Target(), s.FailurePolicy, endpoints, s.Reachable, expiry, status)
+	}
+	return tw.Flush()
+}
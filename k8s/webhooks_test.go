@@ -0,0 +1,91 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"bytes"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var _ = Describe("Admission webhooks", func() {
+	var (
+		server   *httptest.Server
+		caBundle []byte
+	)
+
+	BeforeEach(func() {
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		caBundle = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("reads the webhooks of a configuration", func() {
+		config := unstructured.Unstructured{Object: map[string]interface{}{
+			"kind": "ValidatingWebhookConfiguration",
+			"webhooks": []interface{}{
+				map[string]interface{}{
+					"name":          "policy.example.com",
+					"failurePolicy": "Ignore",
+					"clientConfig": map[string]interface{}{
+						"service": map[string]interface{}{"namespace": "policy", "name": "webhook", "path": "/validate"},
+					},
+				},
+			},
+		}}
+		webhooks, err := webhooksOf(config)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(webhooks).To(HaveLen(1))
+
+		status := newWebhookStatus("ValidatingWebhookConfiguration/policy", webhooks[0], time.Now())
+		Expect(status.FailurePolicy).To(Equal("Ignore"))
+		Expect(status.Target()).To(Equal("policy/webhook:443/validate"))
+		Expect(status.Problems).To(BeEmpty())
+	})
+
+	It("reports expired and invalid CA bundles", func() {
+		url := server.URL
+		webhook := webhookEntry{Name: "hook", ClientConfig: admissionv1.WebhookClientConfig{URL: &url, CABundle: caBundle}}
+
+		status := newWebhookStatus("MutatingWebhookConfiguration/hooks", webhook, time.Now())
+		Expect(status.Problems).To(BeEmpty())
+		Expect(status.CAExpiry).To(Equal(server.Certificate().NotAfter))
+		Expect(status.FailurePolicy).To(Equal(string(admissionv1.Fail)))
+
+		status = newWebhookStatus("MutatingWebhookConfiguration/hooks", webhook, server.Certificate().NotAfter.Add(time.Hour))
+		Expect(status.Problems).To(HaveLen(1))
+		Expect(status.Problems[0]).To(ContainSubstring("expired"))
+
+		webhook.ClientConfig.CABundle = []byte("not a certificate")
+		status = newWebhookStatus("MutatingWebhookConfiguration/hooks", webhook, time.Now())
+		Expect(status.Problems).To(HaveLen(1))
+	})
+
+	It("probes webhook URLs with their CA bundle", func() {
+		Expect(probeWebhookURL(server.URL, caBundle, time.Second)).To(Succeed())
+		Expect(probeWebhookURL(server.URL, nil, time.Second)).NotTo(Succeed())
+	})
+
+	It("writes the webhook report", func() {
+		statuses := []WebhookStatus{
+			{Configuration: "ValidatingWebhookConfiguration/policy", Webhook: "policy.example.com", FailurePolicy: "Fail", URL: "https://policy.example.com", Reachable: true},
+			{Configuration: "MutatingWebhookConfiguration/inject", Webhook: "inject.example.com", FailurePolicy: "Fail", Service: &admissionv1.ServiceReference{Namespace: "mesh", Name: "injector"}, Problems: []string{"no ready endpoints"}},
+		}
+		var buf bytes.Buffer
+		Expect(WriteWebhookReport(&buf, statuses)).To(Succeed())
+		Expect(buf.String()).To(MatchRegexp(`policy.example.com\s+https://policy.example.com\s+Fail\s+-\s+true\s+-\s+ok`))
+		Expect(buf.String()).To(MatchRegexp(`mesh/injector:443\s+Fail\s+0\s+false\s+-\s+failing: no ready endpoints`))
+	})
+})
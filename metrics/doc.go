@@ -0,0 +1,7 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package metrics collects run and step metrics (counts, failures, durations, bytes captured)
+// and exposes them in the Prometheus text format, either served on /metrics or written to a
+// file read by the node_exporter textfile collector for scheduled runs.
+package metrics
@@ -0,0 +1,218 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Metrics path served by Serve
+const Path = "/metrics"
+
+// Run and step statuses
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+)
+
+type stepKey struct {
+	builtin string
+	status  string
+}
+
+type duration struct {
+	sum   float64
+	count float64
+}
+
+var (
+	mu            sync.Mutex
+	runs          = make(map[string]float64)
+	steps         = make(map[stepKey]float64)
+	stepDurations = make(map[string]*duration)
+	runDuration   duration
+	capturedBytes float64
+	lastRunTime   float64
+	lastRunOK     float64
+)
+
+func status(err error) string {
+	if err != nil {
+		return StatusFailed
+	}
+	return StatusOK
+}
+
+// RecordStep records the outcome and duration of a built-in invocation
+func RecordStep(builtin string, d time.Duration, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+	steps[stepKey{builtin: builtin, status: status(err)}]++
+	dur, ok := stepDurations[builtin]
+	if !ok {
+		dur = &duration{}
+		stepDurations[builtin] = dur
+	}
+	dur.sum += d.Seconds()
+	dur.count++
+}
+
+// RecordRun records the outcome and duration of a script run
+func RecordRun(d time.Duration, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+	runs[status(err)]++
+	runDuration.sum += d.Seconds()
+	runDuration.count++
+	lastRunTime = float64(time.Now().Unix())
+	lastRunOK = 0
+	if err == nil {
+		lastRunOK = 1
+	}
+}
+
+// AddCapturedBytes adds n to the bytes captured
+func AddCapturedBytes(n int64) {
+	mu.Lock()
+	defer mu.Unlock()
+	capturedBytes += float64(n)
+}
+
+// Reset clears the collected metrics
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	runs = make(map[string]float64)
+	steps = make(map[stepKey]float64)
+	stepDurations = make(map[string]*duration)
+	runDuration = duration{}
+	capturedBytes, lastRunTime, lastRunOK = 0, 0, 0
+}
+
+// WriteText writes the metrics in the Prometheus text exposition format
+func WriteText(w io.Writer) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var buf bytes.Buffer
+	header := func(name, kind, help string) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	header("crashd_runs_total", "counter", "Script runs by status.")
+	for _, st := range []string{StatusOK, StatusFailed} {
+		fmt.Fprintf(&buf, "crashd_runs_total{status=%q} %g\n", st, runs[st])
+	}
+
+	header("crashd_run_duration_seconds", "summary", "Duration of script runs.")
+	fmt.Fprintf(&buf, "crashd_run_duration_seconds_sum %g\ncrashd_run_duration_seconds_count %g\n", runDuration.sum, runDuration.count)
+
+	header("crashd_last_run_timestamp_seconds", "gauge", "Time, in seconds since the epoch, at which the last run ended.")
+	fmt.Fprintf(&buf, "crashd_last_run_timestamp_seconds %g\n", lastRunTime)
+	header("crashd_last_run_success", "gauge", "Whether the last run succeeded (1) or failed (0).")
+	fmt.Fprintf(&buf, "crashd_last_run_success %g\n", lastRunOK)
+
+	keys := make([]stepKey, 0, len(steps))
+	for key := range steps {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].builtin != keys[j].builtin {
+			return keys[i].builtin < keys[j].builtin
+		}
+		return keys[i].status < keys[j].status
+	})
+	header("crashd_steps_total", "counter", "Built-in invocations by built-in and status.")
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "crashd_steps_total{builtin=%q,status=%q} %g\n", key.builtin, key.status, steps[key])
+	}
+
+	builtins := make([]string, 0, len(stepDurations))
+	for name := range stepDurations {
+		builtins = append(builtins, name)
+	}
+	sort.Strings(builtins)
+	header("crashd_step_duration_seconds", "summary", "Duration of built-in invocations.")
+	for _, name := range builtins {
+		fmt.Fprintf(&buf, "crashd_step_duration_seconds_sum{builtin=%q} %g\n", name, stepDurations[name].sum)
+		fmt.Fprintf(&buf, "crashd_step_duration_seconds_count{builtin=%q} %g\n", name, stepDurations[name].count)
+	}
+
+	header("crashd_captured_bytes_total", "counter", "Bytes written to the working directory by runs.")
+	fmt.Fprintf(&buf, "crashd_captured_bytes_total %g\n", capturedBytes)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Handler returns an HTTP handler serving the metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := WriteText(w); err != nil {
+			logrus.Debugf("metrics: %s", err)
+		}
+	})
+}
+
+// Serve serves the metrics on addr (i.e. :9090) until the returned stop function is called
+func Serve(addr string) (func(), error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("metrics: %s", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(Path, Handler())
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logrus.Errorf("metrics: %s", err)
+		}
+	}()
+	logrus.Infof("serving metrics on http://%s%s", listener.Addr(), Path)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}, nil
+}
+
+// WriteFile atomically writes the metrics to path, so that the file is never read partially
+// written by the node_exporter textfile collector
+func WriteFile(path string) error {
+	var buf bytes.Buffer
+	if err := WriteText(&buf); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".crashd-metrics")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteText(t *testing.T) {
+	Reset()
+	defer Reset()
+
+	RecordStep("capture", 2*time.Second, nil)
+	RecordStep("capture", time.Second, errors.New("failed"))
+	RecordStep("kube_capture", 500*time.Millisecond, nil)
+	AddCapturedBytes(2048)
+	RecordRun(5*time.Second, errors.New("failed"))
+
+	var buf bytes.Buffer
+	if err := WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	text := buf.String()
+	for _, expected := range []string{
+		`crashd_runs_total{status="ok"} 0`,
+		`crashd_runs_total{status="failed"} 1`,
+		`crashd_run_duration_seconds_sum 5`,
+		`crashd_last_run_success 0`,
+		`crashd_steps_total{builtin="capture",status="failed"} 1`,
+		`crashd_steps_total{builtin="capture",status="ok"} 1`,
+		`crashd_step_duration_seconds_sum{builtin="capture"} 3`,
+		`crashd_step_duration_seconds_count{builtin="kube_capture"} 1`,
+		`crashd_captured_bytes_total 2048`,
+		`# TYPE crashd_steps_total counter`,
+	} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("metrics missing %q:\n%s", expected, text)
+		}
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	Reset()
+	defer Reset()
+	RecordRun(time.Second, nil)
+
+	dir, err := ioutil.TempDir("", "crashd-metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "crashd.prom")
+	if err := WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "crashd_last_run_success 1") {
+		t.Errorf("unexpected metrics file:\n%s", data)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	Reset()
+	defer Reset()
+
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "crashd_runs_total") {
+		t.Errorf("unexpected response %s:\n%s", resp.Status, body)
+	}
+}
@@ -0,0 +1,7 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package policy reads the policy file, provided by the administrators of a machine, restricting
+// what crashd scripts may do on it: the transports used to reach hosts, the namespaces of the API
+// objects collected, the commands executed, and the size of the bundle.
+package policy
@@ -0,0 +1,243 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+)
+
+// DefaultFile is the policy file loaded, when it exists, if no policy file is specified
+const DefaultFile = "/etc/crashd/policy.yaml"
+
+var (
+	// shellSeparators split a command line into the commands it runs, including the substituted
+	// commands ($(...) and `...`) and the commands of subshells
+	shellSeparators = regexp.MustCompile("&&|\\|\\||[;&|\n`(]|\\$\\(|\\)")
+	// redirectAmpersands are the & of redirections (i.e. 2>&1, &>file), which do not separate commands
+	redirectAmpersands = regexp.MustCompile(`[<>]&|&>`)
+	// noopCommands are the commands allowed whatever the policy (i.e. the true of cmd || true)
+	noopCommands = map[string]bool{"true": true, "false": true, ":": true}
+)
+
+// Policy restricts the steps of the scripts. Empty restrictions allow everything.
+type Policy struct {
+	// Transports are the allowed transports of the hosts (ssh, local, kube_proxy)
+	Transports []string `yaml:"transports"`
+	// Namespaces are the glob patterns of the namespaces whose objects can be collected
+	Namespaces []string `yaml:"namespaces"`
+	// Commands are regular expressions, one of which the commands executed must match
+	Commands []string `yaml:"commands"`
+	// MaxBundleSize is the maximum size of the working directory (i.e. 1GiB)
+	MaxBundleSize string `yaml:"max_bundle_size"`
+
+	// Path is the file the policy was read from
+	Path string `yaml:"-"`
+
+	commands      []*regexp.Regexp
+	maxBundleSize int64
+}
+
+// Load reads the policy file at path
+func Load(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("policy: %s", err)
+	}
+	policy := &Policy{Path: path}
+	if err := yaml.UnmarshalStrict(data, policy); err != nil {
+		return nil, fmt.Errorf("policy %s: %s", path, err)
+	}
+	if err := policy.compile(); err != nil {
+		return nil, fmt.Errorf("policy %s: %s", path, err)
+	}
+	return policy, nil
+}
+
+// LoadDefault reads DefaultFile, and returns nil when it does not exist
+func LoadDefault() (*Policy, error) {
+	if _, err := os.Stat(DefaultFile); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return Load(DefaultFile)
+}
+
+func (p *Policy) compile() error {
+	for _, expr := range p.Commands {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("commands: %s", err)
+		}
+		p.commands = append(p.commands, re)
+	}
+	for _, pattern := range p.Namespaces {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("namespaces: invalid pattern %s", pattern)
+		}
+	}
+	if len(p.MaxBundleSize) > 0 {
+		qty, err := resource.ParseQuantity(strings.TrimSuffix(p.MaxBundleSize, "B"))
+		if err != nil || qty.Sign() < 0 {
+			return fmt.Errorf("max_bundle_size: invalid size %s", p.MaxBundleSize)
+		}
+		p.maxBundleSize = qty.Value()
+	}
+	return nil
+}
+
+// MaxBundleSizeBytes returns the maximum size of the bundle in bytes, zero when not restricted
+func (p *Policy) MaxBundleSizeBytes() int64 {
+	if p == nil {
+		return 0
+	}
+	return p.maxBundleSize
+}
+
+// CheckTransport returns an error when transport is not allowed
+func (p *Policy) CheckTransport(transport string) error {
+	if p == nil || len(p.Transports) == 0 {
+		return nil
+	}
+	for _, allowed := range p.Transports {
+		if transport == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("transport %s not allowed by policy %s", transport, p.Path)
+}
+
+// RestrictNamespaces returns the namespaces (or namespace patterns), or an error when one of them is
+// not allowed. No namespaces, that is all namespaces, are restricted to the allowed namespaces.
+// Excluded namespaces (prefixed with !) are allowed. Namespace lists packed in one value (separated
+// with commas or spaces, as accepted by the searches) are split before they are checked.
+func (p *Policy) RestrictNamespaces(namespaces []string) ([]string, error) {
+	if p == nil || len(p.Namespaces) == 0 {
+		return namespaces, nil
+	}
+	namespaces = SplitNamespaces(namespaces)
+	var included int
+	for _, ns := range namespaces {
+		if strings.HasPrefix(ns, "!") {
+			continue
+		}
+		if !p.namespaceAllowed(ns) {
+			return nil, fmt.Errorf("namespace %s not allowed by policy %s", ns, p.Path)
+		}
+		included++
+	}
+	if included == 0 {
+		return append(append([]string{}, p.Namespaces...), namespaces...), nil
+	}
+	return namespaces, nil
+}
+
+// CheckNamespace returns an error when the objects of the namespace must not be collected
+func (p *Policy) CheckNamespace(namespace string) error {
+	if p == nil || len(p.Namespaces) == 0 {
+		return nil
+	}
+	if !p.namespaceAllowed(strings.ToLower(strings.TrimSpace(namespace))) {
+		return fmt.Errorf("namespace %s not allowed by policy %s", namespace, p.Path)
+	}
+	return nil
+}
+
+// SplitNamespaces returns the namespaces, with the lists packed in one value (separated with commas or
+// spaces) split, trimmed and lowercased
+func SplitNamespaces(namespaces []string) []string {
+	var split []string
+	for _, value := range namespaces {
+		for _, ns := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+			split = append(split, strings.ToLower(ns))
+		}
+	}
+	return split
+}
+
+func (p *Policy) namespaceAllowed(ns string) bool {
+	for _, pattern := range p.Namespaces {
+		if matched, _ := filepath.Match(pattern, ns); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckCommand returns an error when one of the commands of the command line cmd (the commands of lists
+// and pipelines, and the substituted commands) does not match one of the allowed commands
+func (p *Policy) CheckCommand(cmd string) error {
+	if p == nil || len(p.commands) == 0 {
+		return nil
+	}
+	for _, segment := range commandSegments(cmd) {
+		if !p.commandAllowed(segment) {
+			return fmt.Errorf("command [%s] not allowed by policy %s: [%s] does not match the allowed commands", cmd, p.Path, segment)
+		}
+	}
+	return nil
+}
+
+func (p *Policy) commandAllowed(segment string) bool {
+	if noopCommands[segment] {
+		return true
+	}
+	for _, re := range p.commands {
+		if re.MatchString(segment) {
+			return true
+		}
+	}
+	return false
+}
+
+// commandSegments returns the commands of the command line, split on the shell separators that are not
+// quoted or escaped. Command substitutions are split in double quoted strings too.
+func commandSegments(cmd string) []string {
+	masked := []byte(cmd)
+	var quote byte
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		switch {
+		case c == '\\' && quote != '\'':
+			if i+1 < len(cmd) {
+				i++
+				masked[i] = '_'
+			}
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case c == quote:
+			quote = 0
+		case quote == '\'', quote == '"' && strings.IndexByte(";&|\n(", c) >= 0:
+			masked[i] = '_'
+		}
+	}
+	maskedCmd := redirectAmpersands.ReplaceAllStringFunc(string(masked), func(redirect string) string {
+		return strings.Replace(redirect, "&", "_", 1)
+	})
+
+	var segments []string
+	start := 0
+	for _, loc := range append(shellSeparators.FindAllStringIndex(maskedCmd, -1), []int{len(cmd), len(cmd)}) {
+		if segment := strings.TrimSpace(cmd[start:loc[0]]); len(segment) > 0 {
+			segments = append(segments, segment)
+		}
+		start = loc[1]
+	}
+	return segments
+}
+
+// Refuse records the refusal of a step in the audit trail and returns err
+func Refuse(step string, err error) error {
+	audit.Record("localhost", audit.LocalUser(), audit.ActionPolicyRefusal, step, err)
+	return err
+}
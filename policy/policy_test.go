@@ -0,0 +1,163 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+)
+
+func loadPolicy(t *testing.T, content string) (*Policy, error) {
+	dir, err := ioutil.TempDir("", "crashd-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestLoad(t *testing.T) {
+	p, err := loadPolicy(t, `
+transports: [ssh, local]
+namespaces: ["kube-system", "app-*"]
+commands: ["^(journalctl|df|uptime)( |$)"]
+max_bundle_size: 1GiB
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.CheckTransport("ssh"); err != nil {
+		t.Error(err)
+	}
+	if err := p.CheckTransport("kube_proxy"); err == nil {
+		t.Error("expecting kube_proxy transport not allowed")
+	}
+
+	if err := p.CheckCommand("journalctl -u kubelet"); err != nil {
+		t.Error(err)
+	}
+	if err := p.CheckCommand("rm -rf /"); err == nil {
+		t.Error("expecting rm not allowed")
+	}
+
+	if p.MaxBundleSizeBytes() != 1<<30 {
+		t.Errorf("unexpected max bundle size %d", p.MaxBundleSizeBytes())
+	}
+
+	namespaces, err := p.RestrictNamespaces([]string{"app-1", "!app-2"})
+	if err != nil || !reflect.DeepEqual(namespaces, []string{"app-1", "!app-2"}) {
+		t.Errorf("unexpected namespaces %v: %v", namespaces, err)
+	}
+	namespaces, err = p.RestrictNamespaces(nil)
+	if err != nil || !reflect.DeepEqual(namespaces, []string{"kube-system", "app-*"}) {
+		t.Errorf("unexpected namespaces %v: %v", namespaces, err)
+	}
+	if _, err := p.RestrictNamespaces([]string{"default"}); err == nil {
+		t.Error("expecting namespace default not allowed")
+	}
+	if _, err := p.RestrictNamespaces([]string{"*"}); err == nil {
+		t.Error("expecting all namespaces not allowed")
+	}
+	if _, err := p.RestrictNamespaces([]string{"app-1,kube-public"}); err == nil {
+		t.Error("expecting packed namespace kube-public not allowed")
+	}
+	namespaces, err = p.RestrictNamespaces([]string{" app-1, App-2 kube-system"})
+	if err != nil || !reflect.DeepEqual(namespaces, []string{"app-1", "app-2", "kube-system"}) {
+		t.Errorf("unexpected namespaces %v: %v", namespaces, err)
+	}
+
+	if err := p.CheckNamespace("app-3"); err != nil {
+		t.Error(err)
+	}
+	if err := p.CheckNamespace("default"); err == nil {
+		t.Error("expecting namespace default not allowed")
+	}
+}
+
+func TestCheckCommandSegments(t *testing.T) {
+	p, err := loadPolicy(t, `commands: ["^(sudo )?(journalctl|df|free|uptime|crictl (ps|logs|info))( |$)", "^(grep|tail)( |$)"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		cmd     string
+		allowed bool
+	}{
+		{cmd: "journalctl -u kubelet", allowed: true},
+		{cmd: "sudo df -h 2>&1 || true", allowed: true},
+		{cmd: "journalctl -u kubelet | tail -n 100", allowed: true},
+		{cmd: "journalctl -u kubelet | grep 'failed; rm -rf /|$(reboot)'", allowed: true},
+		{cmd: `journalctl -g "a|b" && uptime`, allowed: true},
+		{cmd: "journalctl; rm -rf /"},
+		{cmd: "journalctl && rm -rf /"},
+		{cmd: "journalctl || rm -rf /"},
+		{cmd: "journalctl | sh"},
+		{cmd: "journalctl & rm -rf /"},
+		{cmd: "journalctl -u $(rm -rf /)"},
+		{cmd: `journalctl -u "$(rm -rf /)"`},
+		{cmd: "journalctl -u `rm -rf /`"},
+		{cmd: "journalctl\nrm -rf /"},
+		{cmd: "journalctl -u kubelet\nrm -rf /"},
+		{cmd: "uptime; (rm -rf /)"},
+	}
+	for _, test := range tests {
+		if err := p.CheckCommand(test.cmd); (err == nil) != test.allowed {
+			t.Errorf("%q: expecting allowed %t, got %v", test.cmd, test.allowed, err)
+		}
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []string{
+		"commands: ['(']",
+		"namespaces: ['[']",
+		"max_bundle_size: lots",
+		"unknown: true",
+	}
+	for _, content := range tests {
+		if _, err := loadPolicy(t, content); err == nil {
+			t.Errorf("expecting error for policy %q", content)
+		}
+	}
+}
+
+func TestNilPolicy(t *testing.T) {
+	var p *Policy
+	if err := p.CheckCommand("rm -rf /"); err != nil {
+		t.Error(err)
+	}
+	if err := p.CheckTransport("ssh"); err != nil {
+		t.Error(err)
+	}
+	if namespaces, err := p.RestrictNamespaces(nil); err != nil || namespaces != nil {
+		t.Errorf("unexpected namespaces %v: %v", namespaces, err)
+	}
+}
+
+func TestRefuse(t *testing.T) {
+	var buf bytes.Buffer
+	audit.SetOutput(&buf)
+	defer audit.SetOutput(nil)
+
+	err := Refuse("run", errors.New("command [rm] not allowed"))
+	if err == nil {
+		t.Fatal("expecting the refusal error")
+	}
+	if !strings.Contains(buf.String(), "action=policy.refuse status=failed detail=\"run\"") {
+		t.Errorf("unexpected audit entry: %s", buf.String())
+	}
+}
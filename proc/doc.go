@@ -0,0 +1,6 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package proc runs the programs of the commands executed by crashd scripts, locally or through
+// the ssh client, and captures their output streams, stdout and stderr separately, and exit status.
+package proc
@@ -0,0 +1,202 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package proc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/vladimirvivien/echo"
+)
+
+var (
+	ctxMu sync.Mutex
+	// runCtx is the context of the programs started by Run and RunArgs
+	runCtx = context.Background()
+)
+
+// SetContext sets the context of the programs started by Run and RunArgs: once it is done, the
+// running programs are killed and new ones fail to start. It returns a function restoring the
+// previous context.
+func SetContext(ctx context.Context) func() {
+	ctxMu.Lock()
+	defer ctxMu.Unlock()
+	prev := runCtx
+	runCtx = ctx
+	return func() {
+		ctxMu.Lock()
+		defer ctxMu.Unlock()
+		runCtx = prev
+	}
+}
+
+// Stopped returns the error of the context of the programs once it is done, nil otherwise. Callers
+// retrying programs stop when it is not nil.
+func Stopped() error {
+	return currentContext().Err()
+}
+
+func currentContext() context.Context {
+	ctxMu.Lock()
+	defer ctxMu.Unlock()
+	return runCtx
+}
+
+// Result is the output and exit status of a program
+type Result struct {
+	Stdout string
+	Stderr string
+	// Output is the standard output and error combined, in the order they were written
+	Output string
+	// ExitCode is the exit status of the program, -1 when it did not exit (i.e. killed by a signal)
+	ExitCode int
+	Duration time.Duration
+}
+
+// Success returns true when the program exited with status 0
+func (r Result) Success() bool {
+	return r.ExitCode == 0
+}
+
+// Err returns an error, with the standard error of the program, when it did not exit with status 0
+func (r Result) Err() error {
+	if r.Success() {
+		return nil
+	}
+	if stderr := strings.TrimSpace(r.Stderr); len(stderr) > 0 {
+		return fmt.Errorf("exit status %d: %s", r.ExitCode, stderr)
+	}
+	return fmt.Errorf("exit status %d", r.ExitCode)
+}
+
+// Run runs the command line cmdStr. Like the commands run with echo (github.com/vladimirvivien/echo),
+// environment variables ($NAME) are expanded, and the words are split on spaces, except within
+// quotes; shell constructs (pipes, redirections, etc) are not supported. It returns an error when the
+// program could not be started; its exit status, when not 0, is returned in the result. The variables
+// of env (NAME=value) are added to the environment of the program.
+func Run(cmdStr string, env ...string) (Result, error) {
+	words, err := Split(echo.New().Eval(cmdStr))
+	if err != nil {
+		return Result{ExitCode: -1}, err
+	}
+	if len(words) == 0 {
+		return Result{ExitCode: -1}, fmt.Errorf("empty command")
+	}
+	return RunArgs(env, words[0], words[1:]...)
+}
+
+// RunArgs runs the program name with args, and the variables of env added to its environment, see Run.
+// The program is killed, and an error returned, once the context set with SetContext is done.
+func RunArgs(env []string, name string, args ...string) (Result, error) {
+	var out Output
+	result, err := RunArgsTo(env, out.Stdout(), out.Stderr(), name, args...)
+	return out.Result(result), err
+}
+
+// RunArgsTo runs the program like RunArgs, but writes its standard output and standard error to stdout
+// and stderr as they are produced: the returned result has no output.
+func RunArgsTo(env []string, stdout, stderr io.Writer, name string, args ...string) (Result, error) {
+	ctx := currentContext()
+	cmd := exec.CommandContext(ctx, name, args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	result := Result{Duration: time.Since(start)}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		result.ExitCode = -1
+		return result, fmt.Errorf("%s stopped: %s", name, ctxErr)
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		result.ExitCode = exitErr.ExitCode()
+		return result, nil
+	}
+	if err != nil {
+		result.ExitCode = -1
+	}
+	return result, err
+}
+
+// Output collects the standard output and error of a program, separately and combined
+type Output struct {
+	mu                       sync.Mutex
+	stdout, stderr, combined bytes.Buffer
+}
+
+// Stdout returns the writer of the standard output
+func (o *Output) Stdout() io.Writer {
+	return outputWriter{o, &o.stdout}
+}
+
+// Stderr returns the writer of the standard error
+func (o *Output) Stderr() io.Writer {
+	return outputWriter{o, &o.stderr}
+}
+
+// Result returns the result with the output collected
+func (o *Output) Result(result Result) Result {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	result.Stdout, result.Stderr, result.Output = o.stdout.String(), o.stderr.String(), o.combined.String()
+	return result
+}
+
+type outputWriter struct {
+	out *Output
+	buf *bytes.Buffer
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	w.out.mu.Lock()
+	defer w.out.mu.Unlock()
+	w.out.combined.Write(p)
+	return w.buf.Write(p)
+}
+
+// Split splits the command line cmdStr in words separated by spaces. Quotes, single or double,
+// group the words they enclose, including spaces and the other kind of quote, and are removed.
+func Split(cmdStr string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	inWord := false
+	for _, r := range cmdStr {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote %c in [%s]", quote, cmdStr)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package proc
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		cmd   string
+		words []string
+	}{
+		{cmd: "uptime", words: []string{"uptime"}},
+		{cmd: "  df   -h ", words: []string{"df", "-h"}},
+		{cmd: `sh -c 'echo "a b"; exit 1'`, words: []string{"sh", "-c", `echo "a b"; exit 1`}},
+		{cmd: `ssh -o "ProxyCommand ssh -W %h:%p jump" user@host "uptime"`, words: []string{"ssh", "-o", "ProxyCommand ssh -W %h:%p jump", "user@host", "uptime"}},
+		{cmd: `echo ''`, words: []string{"echo", ""}},
+		{cmd: ""},
+	}
+	for _, test := range tests {
+		words, err := Split(test.cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(words, test.words) {
+			t.Errorf("unexpected words for [%s]: %q", test.cmd, words)
+		}
+	}
+
+	if _, err := Split(`echo "unterminated`); err == nil {
+		t.Error("expecting error for unterminated quote")
+	}
+}
+
+func TestRun(t *testing.T) {
+	os.Setenv("CRASHD_PROC_TEST", "expanded")
+	defer os.Unsetenv("CRASHD_PROC_TEST")
+
+	result, err := Run(`sh -c 'echo $CRASHD_PROC_TEST; echo failed >&2; exit 3'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ExitCode != 3 || result.Success() {
+		t.Errorf("unexpected exit code %d", result.ExitCode)
+	}
+	if strings.TrimSpace(result.Stdout) != "expanded" || strings.TrimSpace(result.Stderr) != "failed" {
+		t.Errorf("unexpected output: stdout=%q stderr=%q", result.Stdout, result.Stderr)
+	}
+	if !strings.Contains(result.Output, "expanded\n") || !strings.Contains(result.Output, "failed\n") {
+		t.Errorf("unexpected combined output: %q", result.Output)
+	}
+	if err := result.Err(); err == nil || err.Error() != "exit status 3: failed" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	result, err = Run("echo ok")
+	if err != nil || !result.Success() || result.Stdout != "ok\n" || result.Err() != nil {
+		t.Errorf("unexpected result: %+v, %v", result, err)
+	}
+
+	if _, err := Run("crashd-proc-test-missing-program"); err == nil {
+		t.Error("expecting error for missing program")
+	}
+}
+
+func TestRunArgsTo(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	result, err := RunArgsTo(nil, &stdout, &stderr, "sh", "-c", "echo out; echo failed >&2; exit 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ExitCode != 3 || len(result.Stdout) > 0 || len(result.Stderr) > 0 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if stdout.String() != "out\n" || stderr.String() != "failed\n" {
+		t.Errorf("unexpected output: stdout=%q stderr=%q", stdout.String(), stderr.String())
+	}
+}
+
+func TestSetContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	restore := SetContext(ctx)
+	defer restore()
+
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	result, err := RunArgs(nil, "sleep", "10")
+	if err == nil || result.ExitCode != -1 {
+		t.Errorf("expecting the program to be stopped, got %+v, %v", result, err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("program not killed after %s", time.Since(start))
+	}
+	if Stopped() == nil {
+		t.Error("expecting Stopped to report the context error")
+	}
+	if _, err := Run("echo ok"); err == nil {
+		t.Error("expecting programs to fail to start once stopped")
+	}
+
+	restore()
+	if Stopped() != nil {
+		t.Error("expecting the previous context to be restored")
+	}
+	if result, err := Run("echo ok"); err != nil || !result.Success() {
+		t.Errorf("unexpected result: %+v, %v", result, err)
+	}
+}
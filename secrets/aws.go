@@ -0,0 +1,78 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/vladimirvivien/echo"
+
+	"github.com/vmware-tanzu/crash-diagnostics/proc"
+)
+
+// AWSSecretScheme references AWS Secrets Manager secrets: aws-secret://<secret id>[#<field>]
+const AWSSecretScheme = "aws-secret"
+
+// AWSSecretsProvider reads secrets with the aws CLI, which must be installed and configured
+// (credentials, region). When a field is specified, the secret string is a JSON object and
+// the value of field is returned; otherwise the secret string is returned.
+type AWSSecretsProvider struct {
+	// Env are the variables (NAME=value), i.e. the credentials selected by aws_config(), added to the
+	// environment of the aws CLI
+	Env []string
+}
+
+// NewAWSSecretsProvider returns an AWS Secrets Manager provider running the aws CLI with the variables
+// of env added to its environment
+func NewAWSSecretsProvider(env ...string) *AWSSecretsProvider {
+	return &AWSSecretsProvider{Env: env}
+}
+
+func (p *AWSSecretsProvider) Host() string {
+	region := os.Getenv("AWS_REGION")
+	for _, v := range p.Env {
+		if strings.HasPrefix(v, "AWS_REGION=") {
+			region = strings.TrimPrefix(v, "AWS_REGION=")
+		}
+	}
+	if len(region) > 0 {
+		return fmt.Sprintf("secretsmanager.%s.amazonaws.com", region)
+	}
+	return "secretsmanager.amazonaws.com"
+}
+
+func (p *AWSSecretsProvider) Fetch(path, field string) ([]byte, error) {
+	prog := echo.New().Prog.Avail("aws")
+	if len(prog) == 0 {
+		return nil, fmt.Errorf("aws-secret: aws program not found")
+	}
+
+	result, err := proc.RunArgs(p.Env, prog, "secretsmanager", "get-secret-value", "--secret-id", path, "--query", "SecretString", "--output", "text")
+	if err == nil {
+		err = result.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("aws-secret: %s", err)
+	}
+	return secretField(strings.TrimSuffix(result.Stdout, "\n"), field)
+}
+
+// secretField returns the value of field from the JSON secret, or the secret when field is empty
+func secretField(secret, field string) ([]byte, error) {
+	if len(field) == 0 {
+		return []byte(secret), nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return nil, fmt.Errorf("aws-secret: secret is not a JSON object: %s", err)
+	}
+	value, ok := fields[field].(string)
+	if !ok {
+		return nil, fmt.Errorf("aws-secret: string field %s not found", field)
+	}
+	return []byte(value), nil
+}
@@ -0,0 +1,6 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package secrets retrieves credentials, such as SSH private keys, from secret managers
+// referenced as <scheme>://<path>[#<field>] (i.e. vault://secret/ssh/prod#private_key).
+package secrets
@@ -0,0 +1,122 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package secrets
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+)
+
+// Provider retrieves secrets from a secret manager
+type Provider interface {
+	// Fetch returns the value of field of the secret at path. When field is empty,
+	// the provider uses its default field.
+	Fetch(path, field string) ([]byte, error)
+	// Host returns the address of the secret manager, recorded in the audit trail
+	Host() string
+}
+
+// Ref is a parsed secret reference <scheme>://<path>[#<field>]
+type Ref struct {
+	Scheme string
+	Path   string
+	Field  string
+}
+
+var (
+	mu        sync.Mutex
+	providers = map[string]Provider{
+		VaultScheme:     NewVaultProvider(),
+		AWSSecretScheme: NewAWSSecretsProvider(),
+	}
+	keyFiles []string
+)
+
+// Register adds, or replaces, the provider for scheme
+func Register(scheme string, provider Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[scheme] = provider
+}
+
+// ParseRef parses a secret reference
+func ParseRef(ref string) (Ref, error) {
+	parts := strings.SplitN(ref, "://", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return Ref{}, fmt.Errorf("invalid secret reference %q: expecting <scheme>://<path>[#<field>]", ref)
+	}
+	path, field := parts[1], ""
+	if i := strings.LastIndex(path, "#"); i >= 0 {
+		path, field = path[:i], path[i+1:]
+	}
+	return Ref{Scheme: parts[0], Path: strings.Trim(path, "/"), Field: field}, nil
+}
+
+// Fetch returns the secret referenced by ref
+func Fetch(ref string) ([]byte, error) {
+	r, err := ParseRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	provider, ok := providers[r.Scheme]
+	mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("secret %s: unsupported scheme %s", ref, r.Scheme)
+	}
+
+	// the secret value is never recorded, only its reference
+	data, err := provider.Fetch(r.Path, r.Field)
+	audit.Record(provider.Host(), audit.LocalUser(), audit.ActionSecretFetch, ref, err)
+	if err != nil {
+		return nil, fmt.Errorf("secret %s: %s", ref, err)
+	}
+	return data, nil
+}
+
+// FetchKeyFile retrieves the SSH private key referenced by ref and saves it in a temporary
+// file, readable only by the current user, that is removed by Cleanup
+func FetchKeyFile(ref string) (string, error) {
+	key, err := Fetch(ref)
+	if err != nil {
+		return "", err
+	}
+	if len(key) > 0 && key[len(key)-1] != '\n' {
+		key = append(key, '\n') // ssh rejects keys without a final line ending
+	}
+
+	file, err := ioutil.TempFile("", "crashd-key-")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if err := file.Chmod(0600); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	if _, err := file.Write(key); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	mu.Lock()
+	keyFiles = append(keyFiles, file.Name())
+	mu.Unlock()
+	return file.Name(), nil
+}
+
+// Cleanup removes the key files saved by FetchKeyFile
+func Cleanup() {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, file := range keyFiles {
+		os.Remove(file)
+	}
+	keyFiles = nil
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package secrets
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestParseRef(t *testing.T) {
+	tests := []struct {
+		ref       string
+		expected  Ref
+		shouldErr bool
+	}{
+		{ref: "vault://secret/ssh/prod", expected: Ref{Scheme: "vault", Path: "secret/ssh/prod"}},
+		{ref: "vault://secret/ssh/prod#key", expected: Ref{Scheme: "vault", Path: "secret/ssh/prod", Field: "key"}},
+		{ref: "aws-secret://prod/ssh-key", expected: Ref{Scheme: "aws-secret", Path: "prod/ssh-key"}},
+		{ref: "/home/user/.ssh/id_rsa", shouldErr: true},
+		{ref: "vault://", shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.ref, func(t *testing.T) {
+			ref, err := ParseRef(test.ref)
+			if err != nil {
+				if !test.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if test.shouldErr {
+				t.Fatal("expecting error")
+			}
+			if ref != test.expected {
+				t.Errorf("unexpected ref: %+v", ref)
+			}
+		})
+	}
+}
+
+func TestVaultProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "test-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv/ssh/prod":
+			fmt.Fprint(w, `{"data": {"private_key": "v1-key"}}`)
+		case "/v1/secret/data/ssh/prod":
+			fmt.Fprint(w, `{"data": {"data": {"private_key": "v2-key", "user": "capv"}, "metadata": {"version": 1}}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	os.Setenv("VAULT_ADDR", server.URL)
+	os.Setenv("VAULT_TOKEN", "test-token")
+	defer os.Unsetenv("VAULT_ADDR")
+	defer os.Unsetenv("VAULT_TOKEN")
+
+	tests := []struct {
+		ref       string
+		expected  string
+		shouldErr bool
+	}{
+		{ref: "vault://kv/ssh/prod", expected: "v1-key"},
+		{ref: "vault://secret/ssh/prod", expected: "v2-key"},
+		{ref: "vault://secret/data/ssh/prod#user", expected: "capv"},
+		{ref: "vault://secret/ssh/prod#missing", shouldErr: true},
+		{ref: "vault://secret/ssh/dev", shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.ref, func(t *testing.T) {
+			data, err := Fetch(test.ref)
+			if err != nil {
+				if !test.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if test.shouldErr {
+				t.Fatal("expecting error")
+			}
+			if string(data) != test.expected {
+				t.Errorf("unexpected secret: %s", data)
+			}
+		})
+	}
+}
+
+type fakeProvider map[string]string
+
+func (p fakeProvider) Host() string { return "fake" }
+func (p fakeProvider) Fetch(path, field string) ([]byte, error) {
+	if val, ok := p[path]; ok {
+		return []byte(val), nil
+	}
+	return nil, fmt.Errorf("%s not found", path)
+}
+
+func TestFetchKeyFile(t *testing.T) {
+	Register("fake", fakeProvider{"ssh/prod": "-----BEGIN KEY-----"})
+	defer func() {
+		mu.Lock()
+		delete(providers, "fake")
+		mu.Unlock()
+	}()
+
+	path, err := FetchKeyFile("fake://ssh/prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("unexpected key file mode: %s", info.Mode())
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "-----BEGIN KEY-----\n" {
+		t.Errorf("unexpected key file content: %q", content)
+	}
+
+	Cleanup()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("key file should be removed by Cleanup")
+	}
+
+	if _, err := FetchKeyFile("unknown://ssh/prod"); err == nil {
+		t.Error("expecting error for unsupported scheme")
+	}
+}
+
+func TestSecretField(t *testing.T) {
+	val, err := secretField(`{"private_key": "key", "user": "capv"}`, "private_key")
+	if err != nil || string(val) != "key" {
+		t.Errorf("unexpected field value %s: %v", val, err)
+	}
+	val, err = secretField("plain-key", "")
+	if err != nil || string(val) != "plain-key" {
+		t.Errorf("unexpected secret %s: %v", val, err)
+	}
+	if _, err := secretField("plain-key", "user"); err == nil {
+		t.Error("expecting error for non-JSON secret with field")
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// VaultScheme references HashiCorp Vault secrets: vault://<path>[#<field>]
+	VaultScheme = "vault"
+	// vaultDefaultField is the secret field holding the private key
+	vaultDefaultField = "private_key"
+)
+
+// VaultProvider reads secrets with the Vault HTTP API. It uses the VAULT_ADDR and VAULT_TOKEN
+// environment variables, or the token saved by `vault login` in ~/.vault-token. Both KV
+// version 1 and 2 engines are supported: for a version 2 engine, the path may omit the data/
+// segment (i.e. secret/ssh/prod for secret/data/ssh/prod).
+type VaultProvider struct {
+	client *http.Client
+}
+
+// NewVaultProvider returns a Vault provider
+func NewVaultProvider() *VaultProvider {
+	return &VaultProvider{client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (p *VaultProvider) Host() string {
+	return os.Getenv("VAULT_ADDR")
+}
+
+func (p *VaultProvider) Fetch(path, field string) ([]byte, error) {
+	addr := strings.TrimRight(os.Getenv("VAULT_ADDR"), "/")
+	if len(addr) == 0 {
+		return nil, fmt.Errorf("vault: VAULT_ADDR not set")
+	}
+	token, err := vaultToken()
+	if err != nil {
+		return nil, err
+	}
+	if len(field) == 0 {
+		field = vaultDefaultField
+	}
+
+	data, status, err := p.read(addr, token, path)
+	if err != nil {
+		return nil, err
+	}
+	// KV version 2: retry with the data/ segment after the mount
+	if status == http.StatusNotFound {
+		if parts := strings.SplitN(path, "/", 2); len(parts) == 2 && !strings.HasPrefix(parts[1], "data/") {
+			data, status, err = p.read(addr, token, parts[0]+"/data/"+parts[1])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("vault: reading %s: %s", path, http.StatusText(status))
+	}
+
+	value, ok := data[field]
+	if !ok {
+		return nil, fmt.Errorf("vault: field %s not found in %s", field, path)
+	}
+	str, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("vault: field %s of %s is not a string", field, path)
+	}
+	return []byte(str), nil
+}
+
+// read returns the fields of the secret at path along with the HTTP status of the request
+func (p *VaultProvider) read(addr, token, path string) (map[string]interface{}, int, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v1/%s", addr, path), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); len(ns) > 0 {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("vault: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, nil
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, 0, fmt.Errorf("vault: invalid response: %s", err)
+	}
+	// KV version 2 nests the secret fields under data.data
+	if nested, ok := secret.Data["data"].(map[string]interface{}); ok {
+		if _, ok := secret.Data["metadata"]; ok {
+			return nested, resp.StatusCode, nil
+		}
+	}
+	return secret.Data, resp.StatusCode, nil
+}
+
+func vaultToken() (string, error) {
+	if token := os.Getenv("VAULT_TOKEN"); len(token) > 0 {
+		return token, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("vault: VAULT_TOKEN not set and no token file: %s", err)
+	}
+	token, err := ioutil.ReadFile(filepath.Join(home, ".vault-token"))
+	if err != nil {
+		return "", fmt.Errorf("vault: VAULT_TOKEN not set and no token found: %s", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package ssh
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Host key checking modes
+const (
+	HostKeyCheckingStrict    = "strict"
+	HostKeyCheckingAcceptNew = "accept-new"
+	HostKeyCheckingOff       = "off"
+)
+
+// hostKeyFailures are messages printed by ssh when a host key cannot be verified
+var hostKeyFailures = []string{
+	"Host key verification failed",
+	"REMOTE HOST IDENTIFICATION HAS CHANGED",
+	"No matching host key",
+}
+
+// ValidateHostKeyChecking returns an error if mode is not a supported host key checking mode
+func ValidateHostKeyChecking(mode string) error {
+	switch mode {
+	case "", HostKeyCheckingStrict, HostKeyCheckingAcceptNew, HostKeyCheckingOff:
+		return nil
+	default:
+		return fmt.Errorf("unsupported host key checking mode %q (expecting %s, %s, or %s)",
+			mode, HostKeyCheckingStrict, HostKeyCheckingAcceptNew, HostKeyCheckingOff)
+	}
+}
+
+// makeHostKeyOptsStr returns the ssh/scp options for the host key checking mode and known_hosts file.
+// When checking is disabled (the default), output is kept quiet. Otherwise, errors are
+// logged so that verification failures can be reported.
+func makeHostKeyOptsStr(args SSHArgs) (string, error) {
+	if err := ValidateHostKeyChecking(args.HostKeyChecking); err != nil {
+		return "", err
+	}
+
+	var opts string
+	switch args.HostKeyChecking {
+	case HostKeyCheckingStrict:
+		opts = "-o LogLevel=ERROR -o StrictHostKeyChecking=yes"
+	case HostKeyCheckingAcceptNew:
+		opts = "-o LogLevel=ERROR -o StrictHostKeyChecking=accept-new"
+	default:
+		opts = "-o StrictHostKeyChecking=no"
+	}
+	if args.KnownHostsFile != "" {
+		opts = fmt.Sprintf("%s -o UserKnownHostsFile=%s", opts, args.KnownHostsFile)
+	}
+	return opts, nil
+}
+
+// hostKeyError returns an error if the output of ssh/scp reports a host key verification failure.
+// Such failures are not retried.
+func hostKeyError(args SSHArgs, output string) error {
+	for _, failure := range hostKeyFailures {
+		if strings.Contains(output, failure) {
+			knownHosts := args.KnownHostsFile
+			if knownHosts == "" {
+				knownHosts = "~/.ssh/known_hosts"
+			}
+			return fmt.Errorf("ssh: host key verification failed for %s (host_key_checking=%s, known_hosts=%s): %s",
+				args.Host, args.HostKeyChecking, knownHosts, strings.TrimSpace(output))
+		}
+	}
+	return nil
+}
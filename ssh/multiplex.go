@@ -0,0 +1,88 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vladimirvivien/echo"
+)
+
+// controlPersist is how long an idle master connection is kept open
+const controlPersist = "60s"
+
+var (
+	mastersMu sync.Mutex
+	// masters are the destinations (user@host:port) of the multiplexed commands run by this process
+	masters = make(map[string]SSHArgs)
+)
+
+// controlDir returns the directory, private to the current user, where
+// SSH control sockets are created
+func controlDir() (string, error) {
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("crashd-ssh-%d", os.Getuid()))
+	if err := os.MkdirAll(dir, 0700); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	return dir, nil
+}
+
+// makeMultiplexOptsStr returns the options that enable OpenSSH connection multiplexing.
+// The first command to a host starts a master connection that is reused by subsequent
+// ssh/scp commands to the same host (user and port), avoiding a handshake per command.
+func makeMultiplexOptsStr(args SSHArgs) (string, error) {
+	if !args.Multiplex {
+		return "", nil
+	}
+	dir, err := controlDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to create control socket dir: %s", err)
+	}
+	mastersMu.Lock()
+	masters[fmt.Sprintf("%s@%s:%s", args.User, args.Host, args.Port)] = args
+	mastersMu.Unlock()
+	return fmt.Sprintf(
+		"-o ControlMaster=auto -o ControlPath=%s -o ControlPersist=%s",
+		filepath.Join(dir, "%C"), controlPersist,
+	), nil
+}
+
+// CloseMasters asks the master connections of the multiplexed commands run by this process to exit
+// (ssh -O exit). Closing a master connection tears down the sessions multiplexed over it, including
+// the remote commands of ssh clients that were killed, i.e. when a run is interrupted.
+func CloseMasters() {
+	mastersMu.Lock()
+	hosts := masters
+	masters = make(map[string]SSHArgs)
+	mastersMu.Unlock()
+	if len(hosts) == 0 {
+		return
+	}
+
+	prog := echo.New().Prog.Avail("ssh")
+	dir, err := controlDir()
+	if len(prog) == 0 || err != nil {
+		return
+	}
+	for _, args := range hosts {
+		port := args.Port
+		if len(port) == 0 {
+			port = "22"
+		}
+		dest := fmt.Sprintf("%s@%s", args.User, args.Host)
+		// not run with proc, whose context is done when the run is interrupted
+		out, err := exec.Command(prog, "-O", "exit", "-o", "ControlPath="+filepath.Join(dir, "%C"), "-p", port, dest).CombinedOutput()
+		if err != nil {
+			logrus.Debugf("ssh: no master connection to close for %s: %s", dest, strings.TrimSpace(string(out)))
+			continue
+		}
+		logrus.Debugf("ssh: closed master connection to %s", dest)
+	}
+}
@@ -4,20 +4,35 @@
 package ssh
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/vladimirvivien/echo"
 	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+	"github.com/vmware-tanzu/crash-diagnostics/proc"
+	"github.com/vmware-tanzu/crash-diagnostics/tracing"
 )
 
 // CopyFrom copies one or more files using SCP from remote host
 // and returns the paths of files that were successfully copied.
-func CopyFrom(args SSHArgs, rootDir string, sourcePath string) error {
+func CopyFrom(args SSHArgs, rootDir string, sourcePath string) (err error) {
+	span := tracing.Start(audit.ActionSCPCopy, tracing.String("net.peer.name", args.Host), tracing.String("crashd.path", sourcePath))
+	defer func() {
+		audit.Record(args.Host, args.User, audit.ActionSCPCopy, sourcePath, err)
+		span.End(err)
+	}()
+
 	e := echo.New()
 	prog := e.Prog.Avail("scp")
 	if len(prog) == 0 {
@@ -48,15 +63,28 @@ func CopyFrom(args SSHArgs, rootDir string, sourcePath string) error {
 	if maxRetries == 0 {
 		maxRetries = 10
 	}
+	var keyErr error
 	retries := wait.Backoff{Steps: maxRetries, Duration: time.Millisecond * 80, Jitter: 0.1}
 	if err := wait.ExponentialBackoff(retries, func() (bool, error) {
+		if stopErr := proc.Stopped(); stopErr != nil {
+			return false, stopErr
+		}
 		p := e.RunProc(effectiveCmd)
 		if p.Err() != nil {
+			if keyErr = hostKeyError(args, p.Result()); keyErr != nil {
+				return false, keyErr
+			}
 			logrus.Warn(fmt.Sprintf("scp: failed to connect to %s: error '%s %s': retrying connection", args.Host, p.Err(), p.Result()))
 			return false, nil
 		}
 		return true, nil // worked
 	}); err != nil {
+		if keyErr != nil {
+			return keyErr
+		}
+		if stopErr := proc.Stopped(); stopErr != nil {
+			return fmt.Errorf("scp: stopped: %s", stopErr)
+		}
 		logrus.Debugf("scp failed after %d tries", maxRetries)
 		return fmt.Errorf("scp: failed after %d attempt(s): %s", maxRetries, err)
 	}
@@ -65,6 +93,399 @@ func CopyFrom(args SSHArgs, rootDir string, sourcePath string) error {
 	return nil
 }
 
+// CopyTailFrom copies the last tailBytes bytes, or the last tailLines lines, of each remote
+// file matching sourcePath. The tail is produced on the remote host so that complete files
+// are never transferred. Files are saved under rootDir using their remote paths.
+func CopyTailFrom(args SSHArgs, rootDir string, sourcePath string, tailBytes, tailLines int) error {
+	if tailBytes > 0 && tailLines > 0 {
+		return fmt.Errorf("scp: only one of tail bytes or tail lines can be specified")
+	}
+	tailFlag := fmt.Sprintf("-n %d", tailLines)
+	if tailBytes > 0 {
+		tailFlag = fmt.Sprintf("-c %d", tailBytes)
+	}
+
+	files, err := FindFiles(args, []string{sourcePath}, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		targetPath := filepath.Join(rootDir, file)
+		if err := os.MkdirAll(filepath.Dir(targetPath), 0744); err != nil && !os.IsExist(err) {
+			return err
+		}
+
+		reader, err := RunRead(args, fmt.Sprintf("tail %s '%s'", tailFlag, file))
+		if err != nil {
+			return fmt.Errorf("scp: failed to tail %s: %s", file, err)
+		}
+		if err := writeFile(reader, targetPath); err != nil {
+			return err
+		}
+		logrus.Debugf("scp: copied tail (%s) of %s", tailFlag, file)
+	}
+
+	return nil
+}
+
+// FindFiles returns the regular files, on the remote host, that match any of the path
+// globs and none of the exclude globs. Globs in paths are expanded by the remote shell.
+// An exclude glob containing a "/" is matched against the full file path, otherwise
+// it is matched against the file name.
+func FindFiles(args SSHArgs, paths, excludes []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("scp: no paths to search")
+	}
+
+	cmd, err := makeFindCmdStr(paths, excludes)
+	if err != nil {
+		return nil, err
+	}
+
+	fileList, err := Run(args, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("scp: failed to list remote files %s: %s", strings.Join(paths, " "), err)
+	}
+
+	var files []string
+	for _, file := range strings.Split(fileList, "\n") {
+		file = strings.TrimSpace(file)
+		if len(file) == 0 {
+			continue
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// FileStat is the size and modification time (unix seconds) of a remote file
+type FileStat struct {
+	Path    string
+	Size    int64
+	ModTime int64
+}
+
+// FindFileStats is similar to FindFiles but also returns the size and modification time of each file
+func FindFileStats(args SSHArgs, paths, excludes []string) ([]FileStat, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("scp: no paths to search")
+	}
+
+	cmd, err := makeFindCmdStr(paths, excludes, `-exec stat -c '%s %Y %n' {} +`)
+	if err != nil {
+		return nil, err
+	}
+
+	statList, err := Run(args, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("scp: failed to list remote files %s: %s", strings.Join(paths, " "), err)
+	}
+	return parseFileStats(statList)
+}
+
+// parseFileStats parses lines of `stat -c '%s %Y %n'` output
+func parseFileStats(output string) ([]FileStat, error) {
+	var stats []FileStat
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("scp: invalid file size in %q: %s", line, err)
+		}
+		modTime, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("scp: invalid modification time in %q: %s", line, err)
+		}
+		stats = append(stats, FileStat{Path: fields[2], Size: size, ModTime: modTime})
+	}
+	return stats, nil
+}
+
+// FindFileChecksums returns the SHA-256 checksum, computed on the remote host, of each file
+// that FindFiles returns for the same paths and excludes, keyed by remote path.
+func FindFileChecksums(args SSHArgs, paths, excludes []string) (map[string]string, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("scp: no paths to search")
+	}
+
+	cmd, err := makeFindCmdStr(paths, excludes, "-exec sha256sum {} +")
+	if err != nil {
+		return nil, err
+	}
+
+	sumList, err := Run(args, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("scp: failed to compute checksums of %s: %s", strings.Join(paths, " "), err)
+	}
+	return parseChecksums(sumList)
+}
+
+// parseChecksums parses lines of sha256sum output (<checksum>  <path>)
+func parseChecksums(output string) (map[string]string, error) {
+	sums := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		fields := strings.SplitN(line, "  ", 2)
+		if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+			return nil, fmt.Errorf("scp: invalid checksum line %q", line)
+		}
+		sums[fields[1]] = fields[0]
+	}
+	return sums, nil
+}
+
+// VerifyChecksums compares the remote checksums with the SHA-256 of the files copied under rootDir.
+// It returns an error listing each file that is missing or has a different checksum.
+func VerifyChecksums(rootDir string, sums map[string]string) error {
+	var mismatches []string
+	for path, sum := range sums {
+		localPath := filepath.Join(rootDir, path)
+		localSum, err := fileChecksum(localPath)
+		if err != nil {
+			mismatches = append(mismatches, fmt.Sprintf("%s (%s)", path, err))
+			continue
+		}
+		if localSum != sum {
+			mismatches = append(mismatches, fmt.Sprintf("%s (expected %s, got %s)", path, sum, localSum))
+			continue
+		}
+		logrus.Debugf("scp: verified checksum of %s", path)
+	}
+	if len(mismatches) > 0 {
+		sort.Strings(mismatches)
+		return fmt.Errorf("scp: checksum verification failed for %d file(s), remote files may have changed during the copy: %s",
+			len(mismatches), strings.Join(mismatches, ", "))
+	}
+	return nil
+}
+
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// CopyFromOffset copies the content of the remote file sourcePath starting at byte offset.
+// It is used to collect only the data appended to a file since a previous capture.
+// The file is saved under rootDir using its remote path.
+func CopyFromOffset(args SSHArgs, rootDir string, sourcePath string, offset int64) error {
+	targetPath := filepath.Join(rootDir, sourcePath)
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0744); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	reader, err := RunRead(args, fmt.Sprintf("tail -c +%d '%s'", offset+1, sourcePath))
+	if err != nil {
+		return fmt.Errorf("scp: failed to read %s from offset %d: %s", sourcePath, offset, err)
+	}
+	if err := writeFile(reader, targetPath); err != nil {
+		return err
+	}
+	logrus.Debugf("scp: copied %s from offset %d", sourcePath, offset)
+	return nil
+}
+
+// ResumeChunkSize is the number of bytes transferred per request by CopyResumableFrom
+const ResumeChunkSize int64 = 64 * 1024 * 1024
+
+// minThrottledChunkSize is the smallest chunk requested when a bandwidth limit is set
+const minThrottledChunkSize int64 = 64 * 1024
+
+// partialSuffix is appended to the local path of a file while it is being copied
+const partialSuffix = ".partial"
+
+// CopyResumableFrom copies each remote file matching sourcePath in chunks of chunkSize bytes.
+// Chunks are appended to a local <file>.partial, which is renamed once the file is complete.
+// A dropped connection only restarts the current chunk, and a partial file left by
+// an interrupted run is continued from its current size instead of from zero.
+func CopyResumableFrom(args SSHArgs, rootDir string, sourcePath string, chunkSize int64) error {
+	if chunkSize <= 0 {
+		chunkSize = ResumeChunkSize
+	}
+	// with a bandwidth limit, chunks are sized to about a second of transfer so that
+	// pausing between them keeps the rate close to the limit
+	if args.BandwidthLimit > 0 && args.BandwidthLimit < chunkSize {
+		chunkSize = args.BandwidthLimit
+		if chunkSize < minThrottledChunkSize {
+			chunkSize = minThrottledChunkSize
+		}
+	}
+
+	stats, err := FindFileStats(args, []string{sourcePath}, nil)
+	if err != nil {
+		return err
+	}
+	if len(stats) == 0 {
+		return fmt.Errorf("scp: no remote file matches %s", sourcePath)
+	}
+
+	for _, stat := range stats {
+		if err := copyResumableFile(args, rootDir, stat, chunkSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyResumableFile(args SSHArgs, rootDir string, stat FileStat, chunkSize int64) error {
+	targetPath := filepath.Join(rootDir, stat.Path)
+	partialPath := targetPath + partialSuffix
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0744); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	offset, err := partialOffset(partialPath, stat.Size)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		logrus.Infof("scp: resuming %s at byte %d of %d", stat.Path, offset, stat.Size)
+	}
+
+	file, err := os.OpenFile(partialPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	throttle := newThrottle(args.BandwidthLimit)
+	for offset < stat.Size {
+		reader, err := RunRead(args, makeRangeCmdStr(stat.Path, offset, chunkSize))
+		if err != nil {
+			return fmt.Errorf("scp: failed to read %s at offset %d (partial file kept): %s", stat.Path, offset, err)
+		}
+		n, err := io.Copy(file, reader)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("scp: no data read from %s at offset %d, file may have been truncated", stat.Path, offset)
+		}
+		offset += n
+		throttle.wait(n)
+		logrus.Debugf("scp: copied %d of %d bytes of %s", offset, stat.Size, stat.Path)
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(partialPath, targetPath)
+}
+
+// partialOffset returns the size of an existing partial file, or 0 when there is none.
+// A partial file larger than the remote file is stale (the remote file was rotated) and is removed.
+func partialOffset(partialPath string, remoteSize int64) (int64, error) {
+	info, err := os.Stat(partialPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if info.Size() > remoteSize {
+		logrus.Debugf("scp: discarding stale partial file %s", partialPath)
+		return 0, os.Remove(partialPath)
+	}
+	return info.Size(), nil
+}
+
+// throttle paces transfers done in several requests to an average of limit bytes per second
+type throttle struct {
+	limit int64
+	start time.Time
+	total int64
+}
+
+func newThrottle(limit int64) *throttle {
+	return &throttle{limit: limit, start: time.Now()}
+}
+
+// wait records n transferred bytes and sleeps until the average rate is within the limit
+func (t *throttle) wait(n int64) {
+	if t.limit <= 0 {
+		return
+	}
+	t.total += n
+	expected := time.Duration(float64(t.total) / float64(t.limit) * float64(time.Second))
+	if delay := expected - time.Since(t.start); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// bandwidthKbits converts a limit in bytes per second to the Kbit/s used by scp -l
+func bandwidthKbits(bytesPerSec int64) int64 {
+	kbits := bytesPerSec * 8 / 1024
+	if kbits < 1 {
+		return 1
+	}
+	return kbits
+}
+
+// makeRangeCmdStr builds a command that prints length bytes of path starting at byte offset
+func makeRangeCmdStr(path string, offset, length int64) string {
+	return fmt.Sprintf("tail -c +%d '%s' | head -c %d", offset+1, path, length)
+}
+
+// makeFindCmdStr builds a command as
+// find <path0> <path1> -type f ! -name '<exclude0>' ! -path '<exclude1>' [action] 2>/dev/null || true
+// Errors for paths with no match are ignored so that one missing glob does not fail the search.
+func makeFindCmdStr(paths, excludes []string, action ...string) (string, error) {
+	var cmd strings.Builder
+	cmd.WriteString("find")
+	for _, path := range paths {
+		if strings.ContainsAny(path, `"'`) {
+			return "", fmt.Errorf("scp: path %s must not contain quotes", path)
+		}
+		cmd.WriteString(" ")
+		cmd.WriteString(path)
+	}
+	cmd.WriteString(" -type f")
+	for _, exclude := range excludes {
+		if strings.ContainsAny(exclude, `"'`) {
+			return "", fmt.Errorf("scp: exclude pattern %s must not contain quotes", exclude)
+		}
+		test := "-name"
+		if strings.Contains(exclude, "/") {
+			test = "-path"
+		}
+		cmd.WriteString(fmt.Sprintf(" ! %s '%s'", test, exclude))
+	}
+	for _, a := range action {
+		cmd.WriteString(" ")
+		cmd.WriteString(a)
+	}
+	cmd.WriteString(" 2>/dev/null || true")
+	return cmd.String(), nil
+}
+
+func writeFile(reader io.Reader, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, reader); err != nil {
+		return err
+	}
+	return nil
+}
+
 func makeSCPCmdStr(progName string, args SSHArgs, sourcePath string) (string, error) {
 	if args.User == "" {
 		return "", fmt.Errorf("scp: user is required")
@@ -79,8 +500,27 @@ func makeSCPCmdStr(progName string, args SSHArgs, sourcePath string) (string, er
 		}
 	}
 
+	hostKeyOpts, err := makeHostKeyOptsStr(args)
+	if err != nil {
+		return "", fmt.Errorf("scp: %s", err)
+	}
+	multiplexOpts, err := makeMultiplexOptsStr(args)
+	if err != nil {
+		return "", fmt.Errorf("scp: %s", err)
+	}
+
 	scpCmdPrefix := func() string {
-		return fmt.Sprintf("%s -rpq -o StrictHostKeyChecking=no", progName)
+		flags := "-rpq"
+		if args.HostKeyChecking == HostKeyCheckingStrict || args.HostKeyChecking == HostKeyCheckingAcceptNew {
+			flags = "-rp"
+		}
+		if args.Compression {
+			flags += "C"
+		}
+		if args.BandwidthLimit > 0 {
+			flags += fmt.Sprintf(" -l %d", bandwidthKbits(args.BandwidthLimit))
+		}
+		return fmt.Sprintf("%s %s %s", progName, flags, hostKeyOpts)
 	}
 
 	pkPath := func() string {
@@ -106,8 +546,8 @@ func makeSCPCmdStr(progName string, args SSHArgs, sourcePath string) (string, er
 	// build command as
 	// scp -i <pkpath> -P <port> -J <proxyjump> user@host:path
 	cmd := fmt.Sprintf(
-		`%s %s %s %s %s@%s:%s`,
-		scpCmdPrefix(), pkPath(), port(), proxyJump(), args.User, args.Host, sourcePath,
+		`%s %s %s %s %s %s %s@%s:%s`,
+		scpCmdPrefix(), multiplexOpts, makeDialOptsStr(args), pkPath(), port(), proxyJump(), args.User, args.Host, sourcePath,
 	)
 	return cmd, nil
 }
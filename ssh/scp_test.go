@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestCopy(t *testing.T) {
@@ -82,6 +83,303 @@ func TestCopy(t *testing.T) {
 	}
 }
 
+func TestCopyTail(t *testing.T) {
+	tests := []struct {
+		name        string
+		sshArgs     SSHArgs
+		remoteFiles map[string]string
+		srcFile     string
+		tailBytes   int
+		tailLines   int
+		fileContent string
+	}{
+		{
+			name:        "tail bytes of single file",
+			sshArgs:     testSSHArgs,
+			remoteFiles: map[string]string{"tail-foo.txt": "FooBar"},
+			srcFile:     "tail-foo.txt",
+			tailBytes:   4,
+			fileContent: "Bar",
+		},
+		{
+			name:        "tail lines of files in dir",
+			sshArgs:     testSSHArgs,
+			remoteFiles: map[string]string{"tail-bar/foo.txt": "FooBar", "tail-bar/bar.txt": "BarBar"},
+			srcFile:     "tail-bar/foo.txt",
+			tailLines:   1,
+			fileContent: "FooBar",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				for file := range test.remoteFiles {
+					RemoveTestSSHFile(t, test.sshArgs, file)
+				}
+			}()
+
+			for file, content := range test.remoteFiles {
+				MakeTestSSHFile(t, test.sshArgs, file, content)
+			}
+
+			if err := CopyTailFrom(test.sshArgs, support.TmpDirRoot(), test.srcFile, test.tailBytes, test.tailLines); err != nil {
+				t.Fatal(err)
+			}
+
+			expectedPath := filepath.Join(support.TmpDirRoot(), test.srcFile)
+			if getTestFileContent(t, expectedPath) != test.fileContent {
+				t.Error("unexpected file content")
+			}
+		})
+	}
+}
+
+func TestCopyResumable(t *testing.T) {
+	tests := []struct {
+		name        string
+		sshArgs     SSHArgs
+		remoteFiles map[string]string
+		partial     string
+		srcFile     string
+		chunkSize   int64
+		fileContent string
+	}{
+		{
+			name:        "copy in chunks",
+			sshArgs:     testSSHArgs,
+			remoteFiles: map[string]string{"resume-foo.txt": "FooBarBaz"},
+			srcFile:     "resume-foo.txt",
+			chunkSize:   4,
+			fileContent: "FooBarBaz",
+		},
+		{
+			name:        "resume from partial file",
+			sshArgs:     testSSHArgs,
+			remoteFiles: map[string]string{"resume-bar.txt": "FooBarBaz"},
+			partial:     "Foo",
+			srcFile:     "resume-bar.txt",
+			chunkSize:   4,
+			fileContent: "FooBarBaz",
+		},
+		{
+			name:        "stale partial file",
+			sshArgs:     testSSHArgs,
+			remoteFiles: map[string]string{"resume-baz.txt": "Foo"},
+			partial:     "FooBarBaz",
+			srcFile:     "resume-baz.txt",
+			fileContent: "Foo",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				for file := range test.remoteFiles {
+					RemoveTestSSHFile(t, test.sshArgs, file)
+				}
+			}()
+
+			for file, content := range test.remoteFiles {
+				MakeTestSSHFile(t, test.sshArgs, file, content)
+			}
+
+			expectedPath := filepath.Join(support.TmpDirRoot(), test.srcFile)
+			if test.partial != "" {
+				if err := os.MkdirAll(filepath.Dir(expectedPath), 0744); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(expectedPath+partialSuffix, []byte(test.partial), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := CopyResumableFrom(test.sshArgs, support.TmpDirRoot(), test.srcFile, test.chunkSize); err != nil {
+				t.Fatal(err)
+			}
+
+			if getTestFileContent(t, expectedPath) != test.fileContent {
+				t.Error("unexpected file content")
+			}
+			if _, err := os.Stat(expectedPath + partialSuffix); !os.IsNotExist(err) {
+				t.Error("expecting partial file to be removed")
+			}
+		})
+	}
+}
+
+func TestPartialOffset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashd-partial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file.partial")
+	if offset, err := partialOffset(path, 10); err != nil || offset != 0 {
+		t.Fatalf("expecting offset 0 without partial file, got %d: %v", offset, err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("Foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if offset, err := partialOffset(path, 10); err != nil || offset != 3 {
+		t.Fatalf("expecting offset 3, got %d: %v", offset, err)
+	}
+
+	if offset, err := partialOffset(path, 2); err != nil || offset != 0 {
+		t.Fatalf("expecting offset 0 for stale partial file, got %d: %v", offset, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expecting stale partial file to be removed")
+	}
+}
+
+func TestMakeRangeCmdStr(t *testing.T) {
+	cmd := makeRangeCmdStr("/var/log/syslog", 1024, 512)
+	if cmd != "tail -c +1025 '/var/log/syslog' | head -c 512" {
+		t.Errorf("unexpected command string: %s", cmd)
+	}
+}
+
+func TestBandwidthKbits(t *testing.T) {
+	tests := []struct {
+		bytesPerSec int64
+		kbits       int64
+	}{
+		{bytesPerSec: 1024 * 1024, kbits: 8192},
+		{bytesPerSec: 128 * 1024, kbits: 1024},
+		{bytesPerSec: 10, kbits: 1},
+	}
+	for _, test := range tests {
+		if kbits := bandwidthKbits(test.bytesPerSec); kbits != test.kbits {
+			t.Errorf("expecting %d Kbit/s for %d bytes/s, got %d", test.kbits, test.bytesPerSec, kbits)
+		}
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	start := time.Now()
+	throttle := newThrottle(1000)
+	throttle.wait(100)
+	throttle.wait(100)
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond {
+		t.Errorf("expecting transfer paced to about 200ms, took %s", elapsed)
+	}
+
+	start = time.Now()
+	newThrottle(0).wait(1024 * 1024)
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("expecting no pause without limit, took %s", elapsed)
+	}
+}
+
+func TestParseChecksums(t *testing.T) {
+	sum := "0d749abe1377573493e0df74df8d1282e46967754a1ebc7cc6323923a788ad5c"
+	sums, err := parseChecksums(sum + "  /var/log/syslog\n\n" + sum + "  /var/log/with space.log\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sums) != 2 || sums["/var/log/syslog"] != sum || sums["/var/log/with space.log"] != sum {
+		t.Errorf("unexpected checksums: %v", sums)
+	}
+
+	if _, err := parseChecksums("sha256sum: /var/log/secure: Permission denied"); err == nil {
+		t.Error("expecting error for invalid checksum line")
+	}
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashd-checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "foo.txt"), []byte("FooBar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fooSum := "0d749abe1377573493e0df74df8d1282e46967754a1ebc7cc6323923a788ad5c"
+
+	if err := VerifyChecksums(dir, map[string]string{"foo.txt": fooSum}); err != nil {
+		t.Fatal(err)
+	}
+
+	badSum := "0000000000000000000000000000000000000000000000000000000000000000"
+	if err := VerifyChecksums(dir, map[string]string{"foo.txt": badSum}); err == nil {
+		t.Error("expecting error for checksum mismatch")
+	}
+	if err := VerifyChecksums(dir, map[string]string{"missing.txt": fooSum}); err == nil {
+		t.Error("expecting error for missing file")
+	}
+}
+
+func TestParseFileStats(t *testing.T) {
+	output := `1024 1600000000 /var/log/syslog
+2048 1600000100 /var/log/pods/with space.log
+
+bad line`
+	stats, err := parseFileStats(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []FileStat{
+		{Path: "/var/log/syslog", Size: 1024, ModTime: 1600000000},
+		{Path: "/var/log/pods/with space.log", Size: 2048, ModTime: 1600000100},
+	}
+	if len(stats) != len(expected) {
+		t.Fatalf("expecting %d stats, got %d", len(expected), len(stats))
+	}
+	for i := range stats {
+		if stats[i] != expected[i] {
+			t.Errorf("unexpected stat: %#v", stats[i])
+		}
+	}
+}
+
+func TestMakeFindCmdStr(t *testing.T) {
+	tests := []struct {
+		name       string
+		paths      []string
+		excludes   []string
+		cmdStr     string
+		shouldFail bool
+	}{
+		{
+			name:   "single path",
+			paths:  []string{"/var/log/*.log"},
+			cmdStr: "find /var/log/*.log -type f 2>/dev/null || true",
+		},
+		{
+			name:     "paths with excludes",
+			paths:    []string{"/var/log/containers/*.log", "/var/log/pods"},
+			excludes: []string{"*istio*", "/var/log/pods/kube-system_*"},
+			cmdStr:   "find /var/log/containers/*.log /var/log/pods -type f ! -name '*istio*' ! -path '/var/log/pods/kube-system_*' 2>/dev/null || true",
+		},
+		{
+			name:       "quoted exclude",
+			paths:      []string{"/var/log"},
+			excludes:   []string{"'*'"},
+			shouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmdStr, err := makeFindCmdStr(test.paths, test.excludes)
+			if err != nil && !test.shouldFail {
+				t.Fatal(err)
+			}
+			if err == nil && test.shouldFail {
+				t.Fatal("expecting failure, but got none")
+			}
+			if cmdStr != test.cmdStr {
+				t.Errorf("unexpected command string: %s", cmdStr)
+			}
+		})
+	}
+}
+
 //
 //func TestMakeSCPCmdStr(t *testing.T) {
 //	tests := []struct {
@@ -13,6 +13,10 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/vladimirvivien/echo"
 	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+	"github.com/vmware-tanzu/crash-diagnostics/proc"
+	"github.com/vmware-tanzu/crash-diagnostics/tracing"
 )
 
 type ProxyJumpArgs struct {
@@ -27,6 +31,22 @@ type SSHArgs struct {
 	Port           string
 	MaxRetries     int
 	ProxyJump      *ProxyJumpArgs
+	// Compression enables SSH compression (-C) for data sent over the connection
+	Compression bool
+	// HostKeyChecking is one of strict, accept-new, or off (default)
+	HostKeyChecking string
+	// KnownHostsFile is the known_hosts file used to verify host keys
+	KnownHostsFile string
+	// Multiplex reuses one connection per host for all commands
+	Multiplex bool
+	// ConnectTimeout is the timeout, in seconds, to establish a connection
+	ConnectTimeout int
+	// KeepAliveInterval is the interval, in seconds, between keepalive messages
+	// and KeepAliveCount the number of unanswered messages before disconnecting
+	KeepAliveInterval int
+	KeepAliveCount    int
+	// BandwidthLimit limits file transfers to the number of bytes per second (0 for no limit)
+	BandwidthLimit int64
 }
 
 // Run runs a command over SSH and returns the result as a string
@@ -47,7 +67,115 @@ func RunRead(args SSHArgs, cmd string) (io.Reader, error) {
 	return sshRunProc(args, cmd)
 }
 
-func sshRunProc(args SSHArgs, cmd string) (io.Reader, error) {
+// sshConnectionError is the exit status of the ssh client when the connection fails
+const sshConnectionError = 255
+
+// RunCommand runs a command over SSH and returns its output, combined and stdout and stderr separately,
+// and its exit status. The command is sent verbatim, to be run by the shell of the user on the host. The
+// connection is retried, but not the command: a command exiting with a status other than 0 is not an
+// error. The exit status of the ssh client, 255, is retried as a connection error, unless the command
+// produced output or the host can be reached (the command exited with 255).
+func RunCommand(args SSHArgs, cmd string) (proc.Result, error) {
+	var out proc.Output
+	result, err := RunCommandTo(args, cmd, out.Stdout(), out.Stderr())
+	return out.Result(result), err
+}
+
+// RunCommandTo runs a command over SSH like RunCommand, but writes its standard output and standard
+// error to stdout and stderr as they are produced. Only the standard error is kept in the result, for
+// its error (see proc.Result.Err).
+func RunCommandTo(args SSHArgs, cmd string, stdout, stderr io.Writer) (result proc.Result, err error) {
+	span := tracing.Start(audit.ActionSSHRun, tracing.String("net.peer.name", args.Host), tracing.String("crashd.command", cmd))
+	defer func() {
+		auditErr := err
+		if auditErr == nil {
+			auditErr = result.Err()
+		}
+		audit.Record(args.Host, args.User, audit.ActionSSHRun, cmd, auditErr)
+		span.End(auditErr)
+	}()
+
+	prog := echo.New().Prog.Avail("ssh")
+	if len(prog) == 0 {
+		return proc.Result{ExitCode: -1}, fmt.Errorf("ssh program not found")
+	}
+	sshCmd, err := makeSSHCmdStr(prog, args)
+	if err != nil {
+		return proc.Result{ExitCode: -1}, err
+	}
+	sshWords, err := proc.Split(echo.New().Eval(sshCmd))
+	if err != nil {
+		return proc.Result{ExitCode: -1}, err
+	}
+	sshWords = append(sshWords, cmd)
+	logrus.Debugf("ssh.run: %s [%s]", sshCmd, cmd)
+
+	var keyErr, lastErr error
+	maxRetries := args.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = 10
+	}
+	retries := wait.Backoff{Steps: maxRetries, Duration: time.Millisecond * 80, Jitter: 0.1}
+	if err := wait.ExponentialBackoff(retries, func() (bool, error) {
+		// the output of the attempt is counted, a command that produced output is not run again
+		out := &countingWriter{w: stdout}
+		var errOut bytes.Buffer
+		result, lastErr = proc.RunArgsTo(nil, out, io.MultiWriter(stderr, &errOut), sshWords[0], sshWords[1:]...)
+		result.Stderr = errOut.String()
+		if lastErr == nil && (result.ExitCode != sshConnectionError || out.n > 0 || connected(sshWords)) {
+			return true, nil
+		}
+		if stopErr := proc.Stopped(); stopErr != nil {
+			return false, stopErr
+		}
+		if keyErr = hostKeyError(args, result.Stderr); keyErr != nil {
+			return false, keyErr
+		}
+		if lastErr == nil {
+			lastErr = result.Err()
+		}
+		logrus.Warn(fmt.Sprintf("ssh: failed to connect to %s: error '%s': retrying connection", args.Host, lastErr))
+		return false, nil
+	}); err != nil {
+		if keyErr != nil {
+			return proc.Result{ExitCode: -1}, keyErr
+		}
+		if proc.Stopped() != nil {
+			return proc.Result{ExitCode: -1}, fmt.Errorf("ssh: %s", lastErr)
+		}
+		logrus.Debugf("ssh.run failed after %d tries", maxRetries)
+		return proc.Result{ExitCode: -1}, fmt.Errorf("ssh: failed after %d attempt(s): %s", maxRetries, lastErr)
+	}
+	return result, nil
+}
+
+// connected returns whether the host of the ssh command sshWords can be reached, to tell the status 255
+// of a remote command from a connection failure
+func connected(sshWords []string) bool {
+	probe := append(append([]string{}, sshWords[1:len(sshWords)-1]...), "exit 0")
+	result, err := proc.RunArgs(nil, sshWords[0], probe...)
+	return err == nil && result.Success()
+}
+
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func sshRunProc(args SSHArgs, cmd string) (reader io.Reader, err error) {
+	span := tracing.Start(audit.ActionSSHRun, tracing.String("net.peer.name", args.Host), tracing.String("crashd.command", cmd))
+	defer func() {
+		audit.Record(args.Host, args.User, audit.ActionSSHRun, cmd, err)
+		span.End(err)
+	}()
+
 	e := echo.New()
 	prog := e.Prog.Avail("ssh")
 	if len(prog) == 0 {
@@ -61,30 +189,43 @@ func sshRunProc(args SSHArgs, cmd string) (io.Reader, error) {
 	effectiveCmd := fmt.Sprintf(`%s "%s"`, sshCmd, cmd)
 	logrus.Debug("ssh.run: ", effectiveCmd)
 
-	var proc *echo.Proc
+	var sshProc *echo.Proc
+	var keyErr error
 	maxRetries := args.MaxRetries
 	if maxRetries == 0 {
 		maxRetries = 10
 	}
 	retries := wait.Backoff{Steps: maxRetries, Duration: time.Millisecond * 80, Jitter: 0.1}
 	if err := wait.ExponentialBackoff(retries, func() (bool, error) {
+		if stopErr := proc.Stopped(); stopErr != nil {
+			return false, stopErr
+		}
 		p := e.RunProc(effectiveCmd)
 		if p.Err() != nil {
+			if keyErr = hostKeyError(args, p.Result()); keyErr != nil {
+				return false, keyErr
+			}
 			logrus.Warn(fmt.Sprintf("ssh: failed to connect to %s: error '%s %s': retrying connection", args.Host, p.Err(), p.Result()))
 			return false, nil
 		}
-		proc = p
+		sshProc = p
 		return true, nil // worked
 	}); err != nil {
+		if keyErr != nil {
+			return nil, keyErr
+		}
+		if stopErr := proc.Stopped(); stopErr != nil {
+			return nil, fmt.Errorf("ssh: stopped: %s", stopErr)
+		}
 		logrus.Debugf("ssh.run failed after %d tries", maxRetries)
 		return nil, fmt.Errorf("ssh: failed after %d attempt(s): %s", maxRetries, err)
 	}
 
-	if proc == nil {
+	if sshProc == nil {
 		return nil, fmt.Errorf("ssh.run: did get process result")
 	}
 
-	return proc.Out(), nil
+	return sshProc.Out(), nil
 }
 
 func makeSSHCmdStr(progName string, args SSHArgs) (string, error) {
@@ -101,8 +242,24 @@ func makeSSHCmdStr(progName string, args SSHArgs) (string, error) {
 		}
 	}
 
+	hostKeyOpts, err := makeHostKeyOptsStr(args)
+	if err != nil {
+		return "", fmt.Errorf("SSH: %s", err)
+	}
+	multiplexOpts, err := makeMultiplexOptsStr(args)
+	if err != nil {
+		return "", fmt.Errorf("SSH: %s", err)
+	}
+
 	sshCmdPrefix := func() string {
-		return fmt.Sprintf("%s -q -o StrictHostKeyChecking=no", progName)
+		quiet := "-q"
+		if args.HostKeyChecking == HostKeyCheckingStrict || args.HostKeyChecking == HostKeyCheckingAcceptNew {
+			quiet = ""
+		}
+		if args.Compression {
+			return fmt.Sprintf("%s %s -C %s", progName, quiet, hostKeyOpts)
+		}
+		return fmt.Sprintf("%s %s %s", progName, quiet, hostKeyOpts)
 	}
 
 	pkPath := func() string {
@@ -121,7 +278,7 @@ func makeSSHCmdStr(progName string, args SSHArgs) (string, error) {
 
 	proxyJump := func() string {
 		if args.ProxyJump != nil {
-			return fmt.Sprintf("%s@%s", args.User, args.Host) + ` -o "ProxyCommand ssh -o StrictHostKeyChecking=no -W %h:%p ` + fmt.Sprintf("%s %s@%s\"", pkPath(), args.ProxyJump.User, args.ProxyJump.Host)
+			return fmt.Sprintf("%s@%s", args.User, args.Host) + fmt.Sprintf(` -o "ProxyCommand ssh %s -W `, hostKeyOpts) + `%h:%p ` + fmt.Sprintf("%s %s@%s\"", pkPath(), args.ProxyJump.User, args.ProxyJump.Host)
 		}
 		return ""
 	}
@@ -130,7 +287,7 @@ func makeSSHCmdStr(progName string, args SSHArgs) (string, error) {
 	// ssh -i <pkpath> -P <port> user@host OR
 	// ssh -i <pkpath> -P <port> user@host -o "ProxyCommand ssh -W %h:%p -i <pkpath> <proxyJump>"
 	cmd := func() string {
-		cmdStr := fmt.Sprintf("%s %s %s ", sshCmdPrefix(), pkPath(), port())
+		cmdStr := fmt.Sprintf("%s %s %s %s %s ", sshCmdPrefix(), multiplexOpts, makeDialOptsStr(args), pkPath(), port())
 
 		if proxyDetails := proxyJump(); proxyDetails != "" {
 			cmdStr += proxyDetails
@@ -143,3 +300,18 @@ func makeSSHCmdStr(progName string, args SSHArgs) (string, error) {
 
 	return cmd(), nil
 }
+
+// makeDialOptsStr returns the connection timeout and keepalive options, if set
+func makeDialOptsStr(args SSHArgs) string {
+	var opts []string
+	if args.ConnectTimeout > 0 {
+		opts = append(opts, fmt.Sprintf("-o ConnectTimeout=%d", args.ConnectTimeout))
+	}
+	if args.KeepAliveInterval > 0 {
+		opts = append(opts, fmt.Sprintf("-o ServerAliveInterval=%d", args.KeepAliveInterval))
+	}
+	if args.KeepAliveCount > 0 {
+		opts = append(opts, fmt.Sprintf("-o ServerAliveCountMax=%d", args.KeepAliveCount))
+	}
+	return strings.Join(opts, " ")
+}
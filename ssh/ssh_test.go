@@ -5,6 +5,9 @@ package ssh
 
 import (
 	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -37,6 +40,30 @@ func TestRun(t *testing.T) {
 	}
 }
 
+func TestRunCommand(t *testing.T) {
+	result, err := RunCommand(testSSHArgs, "echo out; echo err >&2; exit 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ExitCode != 3 {
+		t.Errorf("unexpected exit code %d", result.ExitCode)
+	}
+	if strings.TrimSpace(result.Stdout) != "out" || strings.TrimSpace(result.Stderr) != "err" {
+		t.Errorf("unexpected output: stdout=%q stderr=%q", result.Stdout, result.Stderr)
+	}
+	if !strings.Contains(result.Output, "out\n") || !strings.Contains(result.Output, "err\n") {
+		t.Errorf("unexpected combined output: %q", result.Output)
+	}
+
+	// the exit status of the ssh client, when the command exits with it, is not retried
+	args := testSSHArgs
+	args.MaxRetries = 2
+	result, err = RunCommand(args, "exit 255")
+	if err != nil || result.ExitCode != 255 {
+		t.Errorf("expecting the exit status of the command, got %+v, %v", result, err)
+	}
+}
+
 func TestRunRead(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -97,6 +124,39 @@ func TestSSHRunMakeCmdStr(t *testing.T) {
 			args:   SSHArgs{User: "sshuser", Host: "local.host", ProxyJump: &ProxyJumpArgs{User: "juser", Host: "jhost"}},
 			cmdStr: "ssh -q -o StrictHostKeyChecking=no -p 22 sshuser@local.host -o \"ProxyCommand ssh -o StrictHostKeyChecking=no -W %h:%p juser@jhost\"",
 		},
+		{
+			name:   "user host and compression",
+			args:   SSHArgs{User: "sshuser", Host: "local.host", Compression: true},
+			cmdStr: "ssh -q -C -o StrictHostKeyChecking=no -p 22 sshuser@local.host",
+		},
+		{
+			name:   "strict host key checking with known hosts",
+			args:   SSHArgs{User: "sshuser", Host: "local.host", HostKeyChecking: HostKeyCheckingStrict, KnownHostsFile: "/crashd/known_hosts"},
+			cmdStr: "ssh -o LogLevel=ERROR -o StrictHostKeyChecking=yes -o UserKnownHostsFile=/crashd/known_hosts -p 22 sshuser@local.host",
+		},
+		{
+			name:   "accept new host keys",
+			args:   SSHArgs{User: "sshuser", Host: "local.host", HostKeyChecking: HostKeyCheckingAcceptNew},
+			cmdStr: "ssh -o LogLevel=ERROR -o StrictHostKeyChecking=accept-new -p 22 sshuser@local.host",
+		},
+		{
+			name: "user host and multiplexing",
+			args: SSHArgs{User: "sshuser", Host: "local.host", Multiplex: true},
+			cmdStr: fmt.Sprintf(
+				"ssh -q -o StrictHostKeyChecking=no -o ControlMaster=auto -o ControlPath=%s -o ControlPersist=60s -p 22 sshuser@local.host",
+				filepath.Join(os.TempDir(), fmt.Sprintf("crashd-ssh-%d", os.Getuid()), "%C"),
+			),
+		},
+		{
+			name:   "user host and dial options",
+			args:   SSHArgs{User: "sshuser", Host: "local.host", Port: "2222", ConnectTimeout: 10, KeepAliveInterval: 15, KeepAliveCount: 3},
+			cmdStr: "ssh -q -o StrictHostKeyChecking=no -o ConnectTimeout=10 -o ServerAliveInterval=15 -o ServerAliveCountMax=3 -p 2222 sshuser@local.host",
+		},
+		{
+			name:       "unsupported host key checking",
+			args:       SSHArgs{User: "sshuser", Host: "local.host", HostKeyChecking: "sometimes"},
+			shouldFail: true,
+		},
 		{
 			name:       "missing host",
 			args:       SSHArgs{User: "sshuser"},
@@ -121,3 +181,28 @@ func TestSSHRunMakeCmdStr(t *testing.T) {
 		})
 	}
 }
+
+func TestHostKeyError(t *testing.T) {
+	args := SSHArgs{User: "sshuser", Host: "local.host", HostKeyChecking: HostKeyCheckingStrict}
+	tests := []struct {
+		name   string
+		output string
+		isErr  bool
+	}{
+		{name: "verification failed", output: "Host key verification failed.", isErr: true},
+		{name: "key changed", output: "@ WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED! @", isErr: true},
+		{name: "connection refused", output: "ssh: connect to host local.host port 22: Connection refused"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := hostKeyError(args, test.output)
+			if test.isErr && err == nil {
+				t.Fatal("expecting host key error, got none")
+			}
+			if !test.isErr && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
+}
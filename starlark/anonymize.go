@@ -0,0 +1,61 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+
+	"go.starlark.net/starlark"
+
+	"github.com/vmware-tanzu/crash-diagnostics/anonymizer"
+)
+
+// mappingFileSuffix is appended to the archive name to name the anonymization mapping file
+const mappingFileSuffix = ".mapping.json"
+
+// makeAnonymizer returns the anonymizer of archive() for the anonymize argument, True for all
+// categories or a list of categories, or nil when it is not set. The anonymizer knows the hosts
+// targeted by the steps of the run and the namespaces and nodes found in paths.
+func makeAnonymizer(thread *starlark.Thread, val starlark.Value, paths []string) (*anonymizer.Anonymizer, error) {
+	var categories []string
+	switch v := val.(type) {
+	case nil, starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		if !v {
+			return nil, nil
+		}
+	case *starlark.List:
+		for i := 0; i < v.Len(); i++ {
+			category, ok := v.Index(i).(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("anonymize: expecting a list of strings, got %s", v.Index(i).Type())
+			}
+			categories = append(categories, string(category))
+		}
+		if len(categories) == 0 {
+			return nil, nil
+		}
+	default:
+		return nil, fmt.Errorf("anonymize: expecting a bool or a list of categories, got %s", val.Type())
+	}
+
+	anon, err := anonymizer.New(categories...)
+	if err != nil {
+		return nil, err
+	}
+	if timings, ok := thread.Local(stepTimingsLocal).(*stepTimings); ok {
+		for _, step := range timings.list() {
+			for _, row := range summaryRowsOf(step.result) {
+				anon.AddHostname(row.target)
+			}
+		}
+	}
+	for _, path := range paths {
+		if err := anon.Discover(path); err != nil {
+			return nil, err
+		}
+	}
+	return anon, nil
+}
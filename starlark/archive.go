@@ -5,40 +5,177 @@ package starlark
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 
+	"github.com/sirupsen/logrus"
 	"go.starlark.net/starlark"
 
 	"github.com/vmware-tanzu/crash-diagnostics/archiver"
+	"github.com/vmware-tanzu/crash-diagnostics/secrets"
 )
 
 // archiveFunc is a built-in starlark function that bundles specified directories into
-// an arhive format (i.e. tar.gz)
-// Starlark format: archive(output_file=<file name> ,source_paths=list)
+// an arhive format (i.e. tar.gz). When split_size is provided, the archive is split into
+// volumes of at most split_size bytes and the list of volume file names is returned. When anonymize
+// is set, hostnames, IP addresses, namespaces, and cloud account IDs are pseudonymized and the
+// mapping of the pseudonyms is saved, outside of the archive, in mapping_file. The output file
+// set on the command line (ExecOptions.Config.Output) replaces output_file. When it is "-", the
+// archive is streamed to stdout and the log output is moved to stderr; scripts cannot set output_file
+// to "-" themselves, since their console output would corrupt the stream. The support case metadata
+// (case_id, customer, notes) is recorded in the provenance file and, with case_in_filename, the case ID
+// and customer prefix the archive file name. When sign_key_path, or sign_key_secret, is set, a detached
+// OpenPGP signature of the archive is saved in <output_file>.asc.
+// Starlark format: archive(output_file=<file name> ,source_paths=list [, split_size="1GiB"][, compression="gzip|zstd|none"]
+// [, anonymize=True|[categories]][, mapping_file=<file name>][, case_id=id][, customer=name][, notes=text][, case_in_filename=False]
+// [, sign_key_path=<key file>][, sign_key_secret="vault://path#field"][, sign_passphrase=passphrase])
 func archiveFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-	var outputFile string
+	var outputFile, compression, mappingFile string
+	var signKeyPath, signKeySecret, signPassphrase string
 	var paths *starlark.List
+	var splitSize, anonymize starlark.Value
+	var sc supportCase
+	var caseInFileName bool
 
 	if err := starlark.UnpackArgs(
 		identifiers.archive, args, kwargs,
 		"output_file?", &outputFile,
 		"source_paths", &paths,
+		"split_size?", &splitSize,
+		"compression?", &compression,
+		"anonymize?", &anonymize,
+		"mapping_file?", &mappingFile,
+		"case_id?", &sc.ID,
+		"customer?", &sc.Customer,
+		"notes?", &sc.Notes,
+		"case_in_filename?", &caseInFileName,
+		"sign_key_path?", &signKeyPath,
+		"sign_key_secret?", &signKeySecret,
+		"sign_passphrase?", &signPassphrase,
 	); err != nil {
 		return starlark.None, fmt.Errorf("%s: %s", identifiers.archive, err)
 	}
 
+	if err := archiver.ValidateCompression(compression); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.archive, err)
+	}
+	// the console output of the run (logs, echo) goes to stdout unless it is moved by --output -
+	if output := getExecOptions(thread).Config.Output; len(output) > 0 {
+		outputFile = output
+	} else if outputFile == archiver.Stdout {
+		return starlark.None, fmt.Errorf("%s: output_file=%q is not supported, use --output %s to stream the archive to stdout", identifiers.archive, archiver.Stdout, archiver.Stdout)
+	}
 	if len(outputFile) == 0 {
-		outputFile = "archive.tar.gz"
+		switch compression {
+		case archiver.CompressionZstd:
+			outputFile = "archive.tar.zst"
+		case archiver.CompressionNone:
+			outputFile = "archive.tar"
+		default:
+			outputFile = "archive.tar.gz"
+		}
 	}
 
 	if paths != nil && paths.Len() == 0 {
 		return starlark.None, fmt.Errorf("%s: one or more paths required", identifiers.archive)
 	}
 
-	if err := archiver.Tar(outputFile, getPathElements(paths)...); err != nil {
+	partSize, err := parseByteSize(splitSize)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: split_size: %s", identifiers.archive, err)
+	}
+	toStdout := outputFile == archiver.Stdout
+	if toStdout && partSize > 0 {
+		return starlark.None, fmt.Errorf("%s: split_size cannot be used when the archive is written to stdout", identifiers.archive)
+	}
+	if toStdout && anonymize != nil && bool(anonymize.Truth()) && len(mappingFile) == 0 {
+		return starlark.None, fmt.Errorf("%s: mapping_file is required to anonymize an archive written to stdout", identifiers.archive)
+	}
+	if caseInFileName {
+		if len(sc.ID) == 0 && len(sc.Customer) == 0 {
+			return starlark.None, fmt.Errorf("%s: case_in_filename requires case_id or customer", identifiers.archive)
+		}
+		if toStdout {
+			return starlark.None, fmt.Errorf("%s: case_in_filename cannot be used when the archive is written to stdout", identifiers.archive)
+		}
+		outputFile = sc.fileName(outputFile)
+	}
+	signKey, err := readSignKey(signKeyPath, signKeySecret)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.archive, err)
+	}
+	if toStdout && signKey != nil {
+		return starlark.None, fmt.Errorf("%s: signing cannot be used when the archive is written to stdout", identifiers.archive)
+	}
+
+	var files []archiver.File
+	if prov, err := makeProvenanceFile(thread, sc); err != nil {
+		logrus.Warnf("%s: skipping %s: %s", identifiers.archive, provenanceFileName, err)
+	} else {
+		files = append(files, archiver.File{Name: provenanceFileName, Data: prov})
+	}
+
+	opts := archiver.Options{Files: files, Workers: getArchiveWorkers(thread), Compression: compression}
+	anon, err := makeAnonymizer(thread, anonymize, getPathElements(paths))
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.archive, err)
+	}
+	if anon != nil {
+		opts.Rename, opts.Rewrite = anon.Name, anon.Rewrite
+	}
+	if toStdout {
+		// keep the archive stream free of log lines
+		logrus.SetOutput(os.Stderr)
+	}
+	if err := archiver.TarWithOptions(outputFile, opts, getPathElements(paths)...); err != nil {
 		return starlark.None, fmt.Errorf("%s failed: %s", identifiers.archive, err)
 	}
+	if anon != nil {
+		if len(mappingFile) == 0 {
+			mappingFile = outputFile + mappingFileSuffix
+		}
+		if err := anon.WriteMapping(mappingFile); err != nil {
+			return starlark.None, fmt.Errorf("%s: failed to save anonymization mapping: %s", identifiers.archive, err)
+		}
+		logrus.Infof("%s: anonymization mapping saved in %s, keep it locally", identifiers.archive, mappingFile)
+	}
+
+	if signKey != nil {
+		// the signature covers the whole archive, verified once the volumes are joined
+		sigFile, err := archiver.Sign(outputFile, signKey, []byte(signPassphrase))
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: failed to sign %s: %s", identifiers.archive, outputFile, err)
+		}
+		logrus.Infof("%s: signature saved in %s", identifiers.archive, sigFile)
+	}
+
+	if partSize == 0 {
+		return starlark.String(outputFile), nil
+	}
+
+	parts, err := archiver.Split(outputFile, partSize)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: failed to split %s: %s", identifiers.archive, outputFile, err)
+	}
+	var partList []starlark.Value
+	for _, part := range parts {
+		partList = append(partList, starlark.String(part))
+	}
+	return starlark.NewList(partList), nil
+}
 
-	return starlark.String(outputFile), nil
+// readSignKey returns the signing key read from keyPath, or fetched from the secret manager with
+// keySecret, or nil when neither is set
+func readSignKey(keyPath, keySecret string) ([]byte, error) {
+	switch {
+	case len(keyPath) > 0 && len(keySecret) > 0:
+		return nil, fmt.Errorf("only one of sign_key_path or sign_key_secret can be specified")
+	case len(keySecret) > 0:
+		return secrets.Fetch(keySecret)
+	case len(keyPath) > 0:
+		return ioutil.ReadFile(keyPath)
+	}
+	return nil, nil
 }
 
 func getPathElements(paths *starlark.List) []string {
@@ -4,11 +4,24 @@
 package starlark
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"go.starlark.net/starlark"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"gopkg.in/yaml.v2"
+
+	"github.com/vmware-tanzu/crash-diagnostics/archiver"
+	"github.com/vmware-tanzu/crash-diagnostics/bundle"
 )
 
 func TestArchiveFunc(t *testing.T) {
@@ -45,6 +58,75 @@ func TestArchiveFunc(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "archive with zstd compression",
+			args: func(t *testing.T) []starlark.Tuple {
+				return []starlark.Tuple{
+					{starlark.String("source_paths"), starlark.NewList([]starlark.Value{starlark.String(defaults.workdir)})},
+					{starlark.String("compression"), starlark.String("zstd")},
+				}
+			},
+			eval: func(t *testing.T, kwargs []starlark.Tuple) {
+				val, err := archiveFunc(newTestThreadLocal(t), nil, nil, kwargs)
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected := "archive.tar.zst"
+				defer func() {
+					os.RemoveAll(expected)
+					os.RemoveAll(defaults.workdir)
+				}()
+				if result, ok := val.(starlark.String); !ok || string(result) != expected {
+					t.Errorf("unexpected result: %s", val)
+				}
+				if _, err := os.Stat(expected); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "archive with output override",
+			args: func(t *testing.T) []starlark.Tuple {
+				return []starlark.Tuple{
+					{starlark.String("output_file"), starlark.String("/tmp/out.tar.gz")},
+					{starlark.String("source_paths"), starlark.NewList([]starlark.Value{starlark.String(defaults.workdir)})},
+				}
+			},
+			eval: func(t *testing.T, kwargs []starlark.Tuple) {
+				thread := newTestThreadLocal(t)
+				thread.SetLocal(execOptionsLocal, ExecOptions{Config: ConfigOverrides{Output: "/tmp/override.tar.gz"}})
+				val, err := archiveFunc(thread, nil, nil, kwargs)
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected := "/tmp/override.tar.gz"
+				defer func() {
+					os.RemoveAll(expected)
+					os.RemoveAll(defaults.workdir)
+				}()
+				if result, ok := val.(starlark.String); !ok || string(result) != expected {
+					t.Errorf("unexpected result: %s", val)
+				}
+				if _, err := os.Stat("/tmp/out.tar.gz"); !os.IsNotExist(err) {
+					t.Error("archive created with the overridden output_file")
+				}
+			},
+		},
+		{
+			name: "archive with unsupported compression",
+			args: func(t *testing.T) []starlark.Tuple {
+				return []starlark.Tuple{
+					{starlark.String("source_paths"), starlark.NewList([]starlark.Value{starlark.String(defaults.workdir)})},
+					{starlark.String("compression"), starlark.String("xz")},
+				}
+			},
+			eval: func(t *testing.T, kwargs []starlark.Tuple) {
+				defer os.RemoveAll(defaults.workdir)
+				if _, err := archiveFunc(newTestThreadLocal(t), nil, nil, kwargs); err == nil {
+					t.Fatal("expecting error for unsupported compression")
+				}
+			},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -91,6 +173,228 @@ result = archive(output_file="/tmp/archive.tar.gz", source_paths=["/tmp/crashd"]
 				}
 			},
 		},
+		{
+			name: "archive split",
+			script: `
+result = archive(output_file="/tmp/archive-split.tar.gz", source_paths=["/tmp/crashd"], split_size=100)
+`,
+			eval: func(t *testing.T, script string) {
+				exe := New()
+				if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(defaults.workdir)
+
+				res, ok := exe.result["result"].(*starlark.List)
+				if !ok {
+					t.Fatalf("archive() with split_size should return a list, got %T", exe.result["result"])
+				}
+				if res.Len() == 0 {
+					t.Fatal("archive() returned no volumes")
+				}
+				for i := 0; i < res.Len(); i++ {
+					part := string(res.Index(i).(starlark.String))
+					defer os.RemoveAll(part)
+					if !strings.HasPrefix(part, "/tmp/archive-split.tar.gz.part") {
+						t.Errorf("unexpected volume name: %s", part)
+					}
+				}
+			},
+		},
+		{
+			name: "archive provenance",
+			script: `
+result = archive(output_file="/tmp/archive-prov.tar.gz", source_paths=["/tmp/crashd"])
+`,
+			eval: func(t *testing.T, script string) {
+				exe := New()
+				exe.SetOptions(ExecOptions{Args: map[string]string{"cluster": "test", "vcenter_password": "s3cret", "ssh_pk_path": "/home/ops/.ssh/id_rsa"}})
+				if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+					t.Fatal(err)
+				}
+				defer func() {
+					os.RemoveAll("/tmp/archive-prov.tar.gz")
+					os.RemoveAll(defaults.workdir)
+				}()
+
+				file, err := os.Open("/tmp/archive-prov.tar.gz")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer file.Close()
+				gz, err := gzip.NewReader(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tr := tar.NewReader(gz)
+				hdr, err := tr.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if hdr.Name != provenanceFileName {
+					t.Fatalf("expecting %s as first archive entry, got %s", provenanceFileName, hdr.Name)
+				}
+				data, err := ioutil.ReadAll(tr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var prov provenance
+				if err := yaml.Unmarshal(data, &prov); err != nil {
+					t.Fatal(err)
+				}
+				if prov.Script != "test.star" || prov.ScriptSHA256 != fmt.Sprintf("%x", sha256.Sum256([]byte(script))) {
+					t.Errorf("unexpected script provenance: %s %s", prov.Script, prov.ScriptSHA256)
+				}
+				if prov.Args["cluster"] != "test" || prov.Args["ssh_pk_path"] != "/home/ops/.ssh/id_rsa" {
+					t.Errorf("unexpected args: %v", prov.Args)
+				}
+				if prov.Args["vcenter_password"] != redactedValue {
+					t.Errorf("expecting the password to be redacted, got %s", prov.Args["vcenter_password"])
+				}
+				if prov.EndTime.Before(prov.StartTime) {
+					t.Errorf("end time %s before start time %s", prov.EndTime, prov.StartTime)
+				}
+			},
+		},
+		{
+			name: "archive case metadata",
+			script: `
+result = archive(output_file="/tmp/archive-case.tar.gz", source_paths=["/tmp/crashd"], case_id="12345", customer="ACME Corp", notes="nodes NotReady", case_in_filename=True)
+`,
+			eval: func(t *testing.T, script string) {
+				exe := New()
+				if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+					t.Fatal(err)
+				}
+				expected := "/tmp/12345_ACME_Corp_archive-case.tar.gz"
+				defer func() {
+					os.RemoveAll(expected)
+					os.RemoveAll(defaults.workdir)
+				}()
+				if result := exe.result["result"].String(); result != fmt.Sprintf("%q", expected) {
+					t.Fatalf("unexpected archive file name: %s", result)
+				}
+
+				var buf bytes.Buffer
+				if err := bundle.Extract(expected, provenanceFileName, &buf); err != nil {
+					t.Fatal(err)
+				}
+				var prov provenance
+				if err := yaml.Unmarshal(buf.Bytes(), &prov); err != nil {
+					t.Fatal(err)
+				}
+				if prov.Case == nil || prov.Case.ID != "12345" || prov.Case.Customer != "ACME Corp" || prov.Case.Notes != "nodes NotReady" {
+					t.Errorf("unexpected case metadata: %#v", prov.Case)
+				}
+
+				exe = New()
+				if err := exe.Exec("test.star", strings.NewReader(`archive(output_file="/tmp/archive-case.tar.gz", source_paths=["/tmp/crashd"], case_in_filename=True)`)); err == nil {
+					t.Error("expecting error for case_in_filename without case_id or customer")
+				}
+			},
+		},
+		{
+			name: "archive signed",
+			script: `
+result = archive(output_file="/tmp/archive-signed.tar.gz", source_paths=["/tmp/crashd"], sign_key_path="/tmp/crashd-sign-key.asc")
+`,
+			eval: func(t *testing.T, script string) {
+				entity, err := openpgp.NewEntity("crashd", "test", "crashd@example.com", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var key bytes.Buffer
+				armored, err := armor.Encode(&key, openpgp.PrivateKeyType, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := entity.SerializePrivate(armored, nil); err != nil {
+					t.Fatal(err)
+				}
+				armored.Close()
+				if err := ioutil.WriteFile("/tmp/crashd-sign-key.asc", key.Bytes(), 0600); err != nil {
+					t.Fatal(err)
+				}
+				defer func() {
+					os.RemoveAll("/tmp/crashd-sign-key.asc")
+					os.RemoveAll("/tmp/archive-signed.tar.gz")
+					os.RemoveAll("/tmp/archive-signed.tar.gz" + archiver.SignatureSuffix)
+					os.RemoveAll(defaults.workdir)
+				}()
+
+				exe := New()
+				if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+					t.Fatal(err)
+				}
+
+				archive, err := os.Open("/tmp/archive-signed.tar.gz")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer archive.Close()
+				sig, err := os.Open("/tmp/archive-signed.tar.gz" + archiver.SignatureSuffix)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer sig.Close()
+				if _, err := openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{entity}, archive, sig); err != nil {
+					t.Errorf("signature not verified: %s", err)
+				}
+
+				exe = New()
+				exe.SetOptions(ExecOptions{Config: ConfigOverrides{Output: archiver.Stdout}})
+				if err := exe.Exec("test.star", strings.NewReader(`archive(source_paths=["/tmp/crashd"], sign_key_path="/tmp/crashd-sign-key.asc")`)); err == nil {
+					t.Error("expecting error for signing an archive written to stdout")
+				}
+
+				// only --output - streams the archive to stdout
+				exe = New()
+				err = exe.Exec("test.star", strings.NewReader(`archive(output_file="-", source_paths=["/tmp/crashd"])`))
+				if err == nil || !strings.Contains(err.Error(), "--output -") {
+					t.Errorf("expecting output_file=\"-\" to be rejected, got %v", err)
+				}
+			},
+		},
+		{
+			name: "archive anonymize",
+			script: `
+result = archive(output_file="/tmp/archive-anon.tar.gz", source_paths=["/tmp/crashd"], anonymize=True)
+`,
+			eval: func(t *testing.T, script string) {
+				hostDir := filepath.Join(defaults.workdir, "10_0_0_1")
+				if err := os.MkdirAll(hostDir, 0744); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(filepath.Join(hostDir, "ip.txt"), []byte("inet 10.0.0.1/24"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				defer func() {
+					os.RemoveAll("/tmp/archive-anon.tar.gz")
+					os.RemoveAll("/tmp/archive-anon.tar.gz" + mappingFileSuffix)
+					os.RemoveAll(defaults.workdir)
+				}()
+
+				exe := New()
+				if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+					t.Fatal(err)
+				}
+
+				var content bytes.Buffer
+				if err := bundle.Extract("/tmp/archive-anon.tar.gz", "240_0_0_1/ip.txt", &content); err != nil {
+					t.Fatal(err)
+				}
+				if content.String() != "inet 240.0.0.1/24" {
+					t.Errorf("unexpected anonymized content: %s", content.String())
+				}
+				mapping, err := ioutil.ReadFile("/tmp/archive-anon.tar.gz" + mappingFileSuffix)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(string(mapping), `"10.0.0.1": "240.0.0.1"`) {
+					t.Errorf("unexpected mapping: %s", mapping)
+				}
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -0,0 +1,205 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// auditTimeLayout is the layout of the audit event timestamps compared by the time window filter
+// (RFC3339 in UTC without fractional seconds)
+const auditTimeLayout = "2006-01-02T15:04:05"
+
+// auditFilterPattern restricts the values of audit log filters to user, verb, and resource names
+var auditFilterPattern = regexp.MustCompile(`^[A-Za-z0-9:._@/-]+$`)
+
+// auditLogFilter selects the events of an API server audit log
+type auditLogFilter struct {
+	// paths are the audit log files, looked up from the API server manifest when empty
+	paths    []string
+	users    []string
+	verbs    []string
+	kinds    []string
+	since    time.Time
+	until    time.Time
+	maxLines int
+}
+
+// auditLogCaptureFunc is a built-in starlark function that collects the events of the API server
+// audit logs (log backend) of control-plane nodes. Logs are located with the --audit-log-path flag of
+// the API server static pod manifest, or with paths, including their rotated (and compressed) files.
+// Events are filtered remotely by user, verb, resource, and time window, so only the matching lines,
+// up to max_lines, are copied. If resources are not provided, control-plane nodes are selected from
+// the cluster using node labels.
+// Starlark format: audit_log_capture([users=["name"]][, verbs=["delete"]][, kinds=["secrets"]][, since="24h"]
+// [, until="2020-01-01T00:00:00Z"][, paths=["/var/log/kubernetes/audit.log"]][, max_lines=50000]
+// [, resources=resources][, labels=["node-role.kubernetes.io/master"]][, workdir=path]
+// [, kube_config=kube_config()][, ssh_config=ssh_config()|"<name>"])
+func auditLogCaptureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir, since, until string
+	var users, verbs, kinds, paths, resources, labels *starlark.List
+	var kubeConfig *starlarkstruct.Struct
+	var sshConfigVal starlark.Value
+	maxLines := defaults.auditLogMaxLines
+
+	if err := starlark.UnpackArgs(
+		identifiers.auditLogCapture, args, kwargs,
+		"users?", &users,
+		"verbs?", &verbs,
+		"kinds?", &kinds,
+		"since?", &since,
+		"until?", &until,
+		"paths?", &paths,
+		"max_lines?", &maxLines,
+		"resources?", &resources,
+		"labels?", &labels,
+		"workdir?", &workdir,
+		"kube_config?", &kubeConfig,
+		"ssh_config?", &sshConfigVal,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.auditLogCapture, err)
+	}
+
+	if len(since) == 0 {
+		since = defaults.logSince
+	}
+	filter, err := newAuditLogFilter(time.Now(), since, until, maxLines)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.auditLogCapture, err)
+	}
+	filter.paths = toSlice(paths)
+	filter.users = toSlice(users)
+	filter.verbs = toSlice(verbs)
+	filter.kinds = toSlice(kinds)
+
+	cmd, err := auditLogCommand(filter)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.auditLogCapture, err)
+	}
+
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+
+	if resources == nil {
+		if kubeConfig == nil {
+			kubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
+		}
+		kubeConfigPath, err := getKubeConfigFromStruct(kubeConfig)
+		if err != nil {
+			return starlark.None, errors.Wrap(err, "failed to kubeconfig")
+		}
+		sshConfig, err := getSSHConfig(thread, sshConfigVal)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.auditLogCapture, err)
+		}
+		nodeLabels := toSlice(labels)
+		if len(nodeLabels) == 0 {
+			nodeLabels = defaults.controlPlaneLabels
+		}
+		provider, err := newKubeNodesProvider(kubeConfigPath, sshConfig, nil, nodeLabels)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.auditLogCapture, err)
+		}
+		res, err := enum(provider)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.auditLogCapture, err)
+		}
+		resources = res
+	}
+
+	results, err := execCaptureCommands(filepath.Join(workdir, "audit"), []captureCommand{
+		{cmd: cmd, fileName: "audit.log", desc: "API server audit events " + filter.String()},
+	}, resources)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.auditLogCapture, err)
+	}
+	return commandResultsToList(results), nil
+}
+
+// newAuditLogFilter returns a filter of the events received in the time window starting since
+// (a duration) before now, and ending at until (RFC3339), or now when until is empty
+func newAuditLogFilter(now time.Time, since, until string, maxLines int) (auditLogFilter, error) {
+	filter := auditLogFilter{until: now.UTC(), maxLines: maxLines}
+	duration, err := time.ParseDuration(since)
+	if err != nil {
+		return filter, fmt.Errorf("invalid since %q: %s", since, err)
+	}
+	if len(until) > 0 {
+		if filter.until, err = time.Parse(time.RFC3339, until); err != nil {
+			return filter, fmt.Errorf("invalid until %q: %s", until, err)
+		}
+		filter.until = filter.until.UTC()
+	}
+	filter.since = filter.until.Add(-duration)
+	if maxLines <= 0 {
+		return filter, fmt.Errorf("max_lines must be positive")
+	}
+	return filter, nil
+}
+
+func (f auditLogFilter) String() string {
+	desc := fmt.Sprintf("from %s to %s", f.since.Format(auditTimeLayout), f.until.Format(auditTimeLayout))
+	for _, values := range []struct {
+		name   string
+		values []string
+	}{{"users", f.users}, {"verbs", f.verbs}, {"resources", f.kinds}} {
+		if len(values.values) > 0 {
+			desc = fmt.Sprintf("%s, %s %s", desc, values.name, strings.Join(values.values, ","))
+		}
+	}
+	return desc
+}
+
+// auditLogCommand returns the command printing the matching events of the audit logs, oldest first.
+// Events are JSON lines; as double quotes cannot be used in the command (it is quoted by the ssh
+// transport), they are matched by any character in the grep patterns.
+func auditLogCommand(f auditLogFilter) (string, error) {
+	paths := `\$(sudo grep -hoE -- '--audit-log-path=[^ ]+' /etc/kubernetes/manifests/kube-apiserver.yaml 2>/dev/null | cut -d= -f2)`
+	if len(f.paths) > 0 {
+		for _, path := range f.paths {
+			if !auditFilterPattern.MatchString(path) {
+				return "", fmt.Errorf("invalid path %q", path)
+			}
+		}
+		paths = strings.Join(f.paths, " ")
+	}
+
+	// rotated files are named <name>-<timestamp>.log, possibly compressed
+	cmd := fmt.Sprintf(`for p in %s; do for f in \$(sudo ls -1tr \${p%%.log}*.log* 2>/dev/null); do sudo zcat -f \$f; done; done`, paths)
+
+	for _, field := range []struct {
+		name   string
+		values []string
+	}{{"username", f.users}, {"verb", f.verbs}, {"resource", f.kinds}} {
+		if len(field.values) == 0 {
+			continue
+		}
+		var values []string
+		for _, value := range field.values {
+			if !auditFilterPattern.MatchString(value) {
+				return "", fmt.Errorf("invalid %s filter %q", field.name, value)
+			}
+			values = append(values, regexp.QuoteMeta(value))
+		}
+		cmd = fmt.Sprintf(`%s | grep -E '[^a-z]%s.:.(%s).'`, cmd, field.name, strings.Join(values, "|"))
+	}
+
+	window := `match(\$0, /requestReceivedTimestamp.:.[0-9T:.Z-]+/) { t = substr(\$0, RSTART + 27, 19); if (t >= since && t <= until) print }`
+	return fmt.Sprintf(`%s | awk -v since=%s -v until=%s '%s' | tail -n %d`,
+		cmd, f.since.Format(auditTimeLayout), f.until.Format(auditTimeLayout), window, f.maxLines), nil
+}
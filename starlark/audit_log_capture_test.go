@@ -0,0 +1,77 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLogCommand(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		since     string
+		until     string
+		paths     []string
+		users     []string
+		verbs     []string
+		kinds     []string
+		expected  []string
+		shouldErr bool
+	}{
+		{
+			name:     "apiserver manifest",
+			since:    "1h",
+			expected: []string{"--audit-log-path=", "awk -v since=2020-06-01T11:00:00 -v until=2020-06-01T12:00:00", "tail -n 100"},
+		},
+		{
+			name:     "filters",
+			since:    "24h",
+			until:    "2020-05-01T00:00:00Z",
+			paths:    []string{"/var/lib/rancher/k3s/server/logs/audit.log"},
+			users:    []string{"system:admin", "kubelet.node"},
+			verbs:    []string{"delete", "patch"},
+			kinds:    []string{"secrets"},
+			expected: []string{"for p in /var/lib/rancher/k3s/server/logs/audit.log;", `grep -E '[^a-z]username.:.(system:admin|kubelet\.node).'`, "grep -E '[^a-z]verb.:.(delete|patch).'", "grep -E '[^a-z]resource.:.(secrets).'", "since=2020-04-30T00:00:00 -v until=2020-05-01T00:00:00"},
+		},
+		{name: "invalid since", since: "yesterday", shouldErr: true},
+		{name: "invalid until", since: "1h", until: "today", shouldErr: true},
+		{name: "invalid user", since: "1h", users: []string{"admin' /etc/shadow"}, shouldErr: true},
+		{name: "invalid path", since: "1h", paths: []string{"$(reboot)"}, shouldErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter, err := newAuditLogFilter(now, test.since, test.until, 100)
+			if err == nil {
+				filter.paths, filter.users, filter.verbs, filter.kinds = test.paths, test.users, test.verbs, test.kinds
+				_, err = auditLogCommand(filter)
+			}
+			if err != nil {
+				if !test.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if test.shouldErr {
+				t.Fatal("expecting error, got none")
+			}
+
+			cmd, _ := auditLogCommand(filter)
+			if strings.Count(cmd, "$") != strings.Count(cmd, `\$`) {
+				t.Errorf("command has unescaped shell variables: %s", cmd)
+			}
+			if strings.Contains(cmd, `"`) {
+				t.Errorf("command has double quotes: %s", cmd)
+			}
+			for _, expected := range test.expected {
+				if !strings.Contains(cmd, expected) {
+					t.Errorf("command does not contain %s: %s", expected, cmd)
+				}
+			}
+		})
+	}
+}
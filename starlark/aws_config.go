@@ -0,0 +1,105 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/aws"
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+	"github.com/vmware-tanzu/crash-diagnostics/secrets"
+)
+
+// awsConfigLocal is the thread local key used to store the AWS configuration declared by the script
+const awsConfigLocal = "crashd_aws_config"
+
+// awsConfigFn is a built-in starlark function that selects the AWS credentials used by crashd, the aws CLI,
+// kubectl, and kubeconfig exec plugins. The configuration is applied when declared and returned as a struct.
+// Starlark format: aws_config([profile=name][, region=name][, role_arn=arn][, external_id=id][, session_name=name][, duration=seconds])
+func awsConfigFn(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var cfg aws.Config
+	if err := starlark.UnpackArgs(
+		identifiers.awsCfg, args, kwargs,
+		"profile?", &cfg.Profile,
+		"region?", &cfg.Region,
+		"role_arn?", &cfg.RoleARN,
+		"external_id?", &cfg.ExternalID,
+		"session_name?", &cfg.SessionName,
+		"duration?", &cfg.DurationSeconds,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.awsCfg, err)
+	}
+
+	if err := applyAWSConfig(thread, cfg); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.awsCfg, err)
+	}
+
+	dict := starlark.StringDict{}
+	for key, val := range map[string]string{
+		"profile": cfg.Profile, "region": cfg.Region, "role_arn": cfg.RoleARN,
+		"external_id": cfg.ExternalID, "session_name": cfg.SessionName,
+	} {
+		if len(val) > 0 {
+			dict[key] = starlark.String(val)
+		}
+	}
+	if cfg.DurationSeconds > 0 {
+		dict["duration"] = starlark.MakeInt(cfg.DurationSeconds)
+	}
+	return starlarkstruct.FromStringDict(starlark.String(identifiers.awsCfg), dict), nil
+}
+
+// applyAWSConfig selects the credentials of cfg for the rest of the run. They are passed to the programs
+// started by crashd (aws CLI, local commands, kubeconfig exec plugins), the environment of crashd is not changed.
+// Roles are assumed when the configuration is applied, so that their errors are reported by the step declaring it.
+func applyAWSConfig(thread *starlark.Thread, cfg aws.Config) error {
+	env, err := cfg.Env()
+	if err != nil {
+		return err
+	}
+	thread.SetLocal(awsConfigLocal, cfg)
+	k8s.SetExecEnv(env)
+	secrets.Register(secrets.AWSSecretScheme, secrets.NewAWSSecretsProvider(env...))
+	return nil
+}
+
+// getAWSConfig returns the AWS configuration applied by the script, the environment credentials when none is
+func getAWSConfig(thread *starlark.Thread) aws.Config {
+	cfg, _ := thread.Local(awsConfigLocal).(aws.Config)
+	return cfg
+}
+
+// getAWSEnv returns the variables selecting the credentials of the AWS configuration applied by the script
+func getAWSEnv(thread *starlark.Thread) ([]string, error) {
+	cfg, ok := thread.Local(awsConfigLocal).(aws.Config)
+	if !ok {
+		return nil, nil
+	}
+	return cfg.Env()
+}
+
+// applyAWSConfigStruct applies the AWS configuration of a struct returned by aws_config()
+func applyAWSConfigStruct(thread *starlark.Thread, awsCfg *starlarkstruct.Struct) error {
+	var cfg aws.Config
+	for key, dest := range map[string]*string{
+		"profile": &cfg.Profile, "region": &cfg.Region, "role_arn": &cfg.RoleARN,
+		"external_id": &cfg.ExternalID, "session_name": &cfg.SessionName,
+	} {
+		if val, err := awsCfg.Attr(key); err == nil {
+			if str, ok := val.(starlark.String); ok {
+				*dest = string(str)
+			}
+		}
+	}
+	if val, err := awsCfg.Attr("duration"); err == nil {
+		if duration, ok := val.(starlark.Int); ok {
+			d, _ := duration.Int64()
+			cfg.DurationSeconds = int(d)
+		}
+	}
+	return applyAWSConfig(thread, cfg)
+}
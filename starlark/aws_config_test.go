@@ -0,0 +1,72 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+func TestAWSConfigFunc(t *testing.T) {
+	for _, name := range []string{"AWS_PROFILE", "AWS_REGION", "AWS_DEFAULT_REGION", "AWS_ACCESS_KEY_ID"} {
+		defer os.Setenv(name, os.Getenv(name))
+	}
+	os.Setenv("AWS_PROFILE", "")
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKIASTATIC")
+
+	tests := []struct {
+		name   string
+		script string
+		eval   func(t *testing.T, script string)
+	}{
+		{
+			name: "profile applied",
+			script: `cfg = aws_config(profile="crashd-test-sso", region="ap-south-1")
+env = run_local("printenv AWS_PROFILE AWS_REGION AWS_ACCESS_KEY_ID")`,
+			eval: func(t *testing.T, script string) {
+				exe := New()
+				if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+					t.Fatal(err)
+				}
+				cfg, ok := exe.result["cfg"].(*starlarkstruct.Struct)
+				if !ok {
+					t.Fatalf("unexpected type for aws_config: %T", exe.result["cfg"])
+				}
+				val, err := cfg.Attr("profile")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if trimQuotes(val.String()) != "crashd-test-sso" {
+					t.Errorf("unexpected profile: %s", val.String())
+				}
+				if env := string(exe.result["env"].(starlark.String)); env != "crashd-test-sso\nap-south-1" {
+					t.Errorf("aws configuration not passed to local commands: %s", env)
+				}
+				if os.Getenv("AWS_PROFILE") == "crashd-test-sso" || os.Getenv("AWS_ACCESS_KEY_ID") != "AKIASTATIC" {
+					t.Errorf("expecting the crashd environment unchanged: AWS_PROFILE=%s AWS_ACCESS_KEY_ID=%s", os.Getenv("AWS_PROFILE"), os.Getenv("AWS_ACCESS_KEY_ID"))
+				}
+			},
+		},
+		{
+			name:   "external_id without role_arn",
+			script: `aws_config(profile="crashd-test", external_id="abc")`,
+			eval: func(t *testing.T, script string) {
+				exe := New()
+				if err := exe.Exec("test.star", strings.NewReader(script)); err == nil {
+					t.Fatal("expecting error")
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.eval(t, test.script)
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/azure"
+)
+
+// azureCaptureFunc is a built-in starlark function that collects the infrastructure-level state of
+// Azure node VMs with the az CLI: the VM instance view (provisioning and power states, extensions),
+// and the boot diagnostics serial log and console screenshot. VMs are resource IDs or node provider
+// IDs (azure:///subscriptions/...), or names of vms in resource_group, and the nodes of a capz_provider.
+// Files are saved under <workdir>/azure/<vm>.
+// Starlark format: azure_capture([vms=["id"|"name"], provider=capz_provider()][, resource_group=name][, screenshots=True][, workdir=path])
+func azureCaptureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir, resourceGroup string
+	var vmList *starlark.List
+	var capz *starlarkstruct.Struct
+	screenshots := true
+
+	if err := starlark.UnpackArgs(
+		identifiers.azureCapture, args, kwargs,
+		"vms?", &vmList,
+		"provider?", &capz,
+		"resource_group?", &resourceGroup,
+		"screenshots?", &screenshots,
+		"workdir?", &workdir,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.azureCapture, err)
+	}
+
+	ids := toSlice(vmList)
+	if capz != nil {
+		providerIDs, err := getCapzProviderIDs(capz)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.azureCapture, err)
+		}
+		ids = append(ids, providerIDs...)
+	}
+
+	var vms []azure.VM
+	for _, id := range ids {
+		var vm azure.VM
+		var err error
+		if len(resourceGroup) > 0 && !strings.Contains(id, "/") {
+			vm, err = azure.NewVM(resourceGroup, id)
+		} else {
+			vm, err = azure.ParseVM(id)
+		}
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.azureCapture, err)
+		}
+		vms = append(vms, vm)
+	}
+	if len(vms) == 0 {
+		return starlark.None, fmt.Errorf("%s: vms or provider required", identifiers.azureCapture)
+	}
+
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+
+	var results []starlark.Value
+	for _, vm := range vms {
+		results = append(results, captureAzureVM(filepath.Join(workdir, "azure", sanitizeStr(vm.Name)), vm, screenshots))
+	}
+	return starlark.NewList(results), nil
+}
+
+// captureAzureVM saves the state of a VM in dir. VMs that are not provisioned are logged as warnings.
+func captureAzureVM(dir string, vm azure.VM, screenshot bool) *starlarkstruct.Struct {
+	var view azure.InstanceView
+	c, ok := newInfraCapture(identifiers.azureCapture, "vm", vm.Name, dir)
+	result := func() *starlarkstruct.Struct {
+		var extensions []starlark.Value
+		for _, ext := range view.Extensions {
+			extensions = append(extensions, starlark.String(ext))
+		}
+		return c.result("azure_vm", starlark.StringDict{
+			"provisioning_state": starlark.String(view.ProvisioningState),
+			"power_state":        starlark.String(view.PowerState),
+			"extensions":         starlark.NewList(extensions),
+		})
+	}
+	if !ok {
+		return result()
+	}
+
+	if output, ok := c.save("instance view", "instance-view.json", func() (string, error) { return azure.GetInstanceView(vm) }); ok {
+		var err error
+		if view, err = azure.ParseInstanceView(output); err != nil {
+			c.fail("instance view", err)
+		}
+		if len(view.ProvisioningState) > 0 && view.ProvisioningState != "succeeded" {
+			logrus.Warnf("%s: vm %s: provisioning state is %s", identifiers.azureCapture, vm.Name, view.ProvisioningState)
+		}
+	}
+	// boot diagnostics are only available when enabled on the VM
+	c.save("boot log", "serial.log", func() (string, error) { return azure.GetBootLog(vm) })
+	if screenshot {
+		if err := azure.SaveScreenshot(vm, c.path("console.bmp")); err != nil {
+			c.fail("screenshot", err)
+		}
+	}
+	return result()
+}
+
+// getCapzProviderIDs returns the provider IDs of the nodes of a capz_provider
+func getCapzProviderIDs(capz *starlarkstruct.Struct) ([]string, error) {
+	if kind, err := capz.Attr("kind"); err != nil || kind != starlark.String(identifiers.capzProvider) {
+		return nil, fmt.Errorf("provider: expecting %s()", identifiers.capzProvider)
+	}
+	val, err := capz.Attr("provider_ids")
+	if err != nil {
+		return nil, fmt.Errorf("provider: %s", err)
+	}
+	ids, ok := val.(*starlark.List)
+	if !ok {
+		return nil, fmt.Errorf("provider: unexpected type for provider_ids: %s", val.Type())
+	}
+	return toSlice(ids), nil
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"reflect"
+	"testing"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+func TestGetCapzProviderIDs(t *testing.T) {
+	id := "azure:///subscriptions/123/resourceGroups/prod/providers/Microsoft.Compute/virtualMachines/prod-md-0-x2lz8"
+	capz := starlarkstruct.FromStringDict(starlark.String(identifiers.capzProvider), starlark.StringDict{
+		"kind":         starlark.String(identifiers.capzProvider),
+		"hosts":        starlark.NewList([]starlark.Value{starlark.String("10.1.0.4")}),
+		"provider_ids": starlark.NewList([]starlark.Value{starlark.String(id)}),
+	})
+	ids, err := getCapzProviderIDs(capz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{id}) {
+		t.Errorf("unexpected provider IDs: %v", ids)
+	}
+
+	// the nodes of other providers are not Azure VMs
+	capv := starlarkstruct.FromStringDict(starlark.String(identifiers.capvProvider), starlark.StringDict{
+		"kind":  starlark.String(identifiers.capvProvider),
+		"hosts": starlark.NewList([]starlark.Value{starlark.String("10.1.0.4")}),
+	})
+	if _, err := getCapzProviderIDs(capv); err == nil {
+		t.Error("expecting capv_provider to be rejected")
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// getMaxBundleSizeFromThread returns the max_bundle_size saved by crashd_config, or the max_bundle_size
+// of the policy of the run when smaller or not set in the config, or zero when neither is set
+func getMaxBundleSizeFromThread(thread *starlark.Thread) int64 {
+	policySize := getExecOptions(thread).Policy.MaxBundleSizeBytes()
+	cfgSize := getConfigMaxBundleSize(thread)
+	if policySize > 0 && (cfgSize == 0 || policySize < cfgSize) {
+		return policySize
+	}
+	return cfgSize
+}
+
+// getConfigMaxBundleSize returns the max_bundle_size saved by crashd_config, or zero when not set
+func getConfigMaxBundleSize(thread *starlark.Thread) int64 {
+	cfg, ok := thread.Local(identifiers.crashdCfg).(*starlarkstruct.Struct)
+	if !ok {
+		return 0
+	}
+	val, err := cfg.Attr("max_bundle_size")
+	if err != nil {
+		return 0
+	}
+	size, ok := val.(starlark.Int)
+	if !ok {
+		return 0
+	}
+	bytes, _ := size.Int64()
+	return bytes
+}
+
+// bundleQuotaLocal is the thread local key used to store the bundle size tracked for max_bundle_size
+const bundleQuotaLocal = "crashd_bundle_quota"
+
+// bundleQuota tracks the size of the working directory from the outputs of the steps, so that the
+// working directory is only walked once per run
+type bundleQuota struct {
+	mu    sync.Mutex
+	files map[string]int64
+	size  int64
+}
+
+// seed records the files already in the working directory, once
+func (q *bundleQuota) seed(workdir string) error {
+	if q.files != nil {
+		return nil
+	}
+	q.files = make(map[string]int64)
+	return q.add([]string{workdir})
+}
+
+// add records the sizes of the regular files of paths (files or directories), replacing the sizes
+// recorded for the files written again
+func (q *bundleQuota) add(paths []string) error {
+	for _, path := range paths {
+		err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if info.Mode().IsRegular() {
+				q.size += info.Size() - q.files[file]
+				q.files[file] = info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withBundleQuota wraps a collection built-in so that it is skipped (returning an empty list) once the
+// size of the working directory reaches max_bundle_size. The size is updated with the outputs of
+// each step. A step started under the quota runs to completion; the remaining collections are then
+// skipped, but the script goes on so that archive() still produces a bundle of what was collected.
+func withBundleQuota(name string, fn BuiltinFunc) BuiltinFunc {
+	return func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := checkBundleQuota(thread, nil); err != nil {
+			logrus.Warnf("%s: skipped: %s", name, err)
+			return starlark.NewList(nil), nil
+		}
+
+		result, err := fn(thread, b, args, kwargs)
+		if err != nil {
+			return result, err
+		}
+
+		workdir, wdErr := getWorkdirFromThread(thread)
+		if wdErr != nil {
+			return result, nil
+		}
+		if err := checkBundleQuota(thread, resultPaths(result, workdir)); err != nil {
+			logrus.Warnf("%s: %s: remaining collections will be skipped", name, err)
+		}
+		return result, nil
+	}
+}
+
+// checkBundleQuota records the output paths of a step, and returns an error when the size of the
+// working directory has reached max_bundle_size
+func checkBundleQuota(thread *starlark.Thread, outputs []string) error {
+	maxSize := getMaxBundleSizeFromThread(thread)
+	quota, ok := thread.Local(bundleQuotaLocal).(*bundleQuota)
+	if maxSize == 0 || !ok {
+		return nil
+	}
+
+	workdir, err := getWorkdirFromThread(thread)
+	if err != nil {
+		return err
+	}
+	quota.mu.Lock()
+	defer quota.mu.Unlock()
+	if err := quota.seed(workdir); err != nil {
+		return fmt.Errorf("failed to compute bundle size: %s", err)
+	}
+	if err := quota.add(outputs); err != nil {
+		return fmt.Errorf("failed to compute bundle size: %s", err)
+	}
+	if quota.size >= maxSize {
+		return fmt.Errorf("bundle size quota exceeded: %d bytes written, max_bundle_size is %d bytes", quota.size, maxSize)
+	}
+	return nil
+}
+
+// resultPaths returns the paths under workdir (files or directories) found in the result of a step
+func resultPaths(val starlark.Value, workdir string) []string {
+	var paths []string
+	switch v := val.(type) {
+	case starlark.String:
+		path := filepath.Clean(string(v))
+		if rel, err := filepath.Rel(workdir, path); err == nil && rel != "." && rel != ".." &&
+			!strings.HasPrefix(rel, ".."+string(filepath.Separator)) && filepath.IsAbs(path) == filepath.IsAbs(workdir) {
+			paths = append(paths, path)
+		}
+	case *starlark.List:
+		for i := 0; i < v.Len(); i++ {
+			paths = append(paths, resultPaths(v.Index(i), workdir)...)
+		}
+	case starlark.Tuple:
+		for _, item := range v {
+			paths = append(paths, resultPaths(item, workdir)...)
+		}
+	case *starlark.Dict:
+		for _, item := range v.Items() {
+			paths = append(paths, resultPaths(item[1], workdir)...)
+		}
+	case *starlarkstruct.Struct:
+		for _, name := range v.AttrNames() {
+			if attr, err := v.Attr(name); err == nil {
+				paths = append(paths, resultPaths(attr, workdir)...)
+			}
+		}
+	}
+	return paths
+}
+
+// dirSize returns the total size, in bytes, of the regular files under path
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		val        starlark.Value
+		size       int64
+		shouldFail bool
+	}{
+		{name: "not set", val: nil, size: 0},
+		{name: "bytes", val: starlark.MakeInt(1024), size: 1024},
+		{name: "binary quantity", val: starlark.String("2Mi"), size: 2 * 1024 * 1024},
+		{name: "decimal quantity", val: starlark.String("1G"), size: 1000 * 1000 * 1000},
+		{name: "quantity with byte suffix", val: starlark.String("1GiB"), size: 1024 * 1024 * 1024},
+		{name: "negative", val: starlark.MakeInt(-1), shouldFail: true},
+		{name: "bad quantity", val: starlark.String("lots"), shouldFail: true},
+		{name: "bad type", val: starlark.Bool(true), shouldFail: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			size, err := parseByteSize(test.val)
+			if err != nil && !test.shouldFail {
+				t.Fatal(err)
+			}
+			if err == nil && test.shouldFail {
+				t.Fatal("expecting failure, but got none")
+			}
+			if size != test.size {
+				t.Errorf("unexpected size: %d", size)
+			}
+		})
+	}
+}
+
+func TestBundleQuota(t *testing.T) {
+	workdir, err := ioutil.TempDir("", "crashd-quota")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+
+	var writes int
+	fileWriter := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		writes++
+		path := filepath.Join(workdir, fmt.Sprintf("out-%d.txt", writes))
+		return starlark.String(path), ioutil.WriteFile(path, make([]byte, 8192), 0644)
+	}
+
+	exe := New()
+	exe.AddPredeclared("write_file", starlark.NewBuiltin("write_file", withBundleQuota("write_file", fileWriter)))
+	script := `crashd_config(workdir="` + workdir + `", max_bundle_size=4096)
+write_file()
+skipped = write_file()
+archive(output_file="` + filepath.Join(workdir, "bundle.tar.gz") + `", source_paths=["` + workdir + `/out-1.txt"])`
+	if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+	if writes != 1 {
+		t.Errorf("expecting the collection to stop once the quota is exceeded, got %d writes", writes)
+	}
+	if skipped, ok := exe.result["skipped"].(*starlark.List); !ok || skipped.Len() != 0 {
+		t.Errorf("expecting the skipped step to return an empty list, got %v", exe.result["skipped"])
+	}
+	if _, err := os.Stat(filepath.Join(workdir, "bundle.tar.gz")); err != nil {
+		t.Errorf("expecting the bundle to be archived: %s", err)
+	}
+}
+
+func TestResultPaths(t *testing.T) {
+	workdir := "/tmp/crashd"
+	result := starlark.NewList([]starlark.Value{
+		starlark.String("/tmp/crashd/node-1/df.txt"),
+		starlark.Tuple{starlark.String("/tmp/crashd-other/x"), starlark.String("/tmp/crashd/../etc/hosts")},
+		starlark.String("output of a command"),
+	})
+	paths := resultPaths(result, workdir)
+	if len(paths) != 1 || paths[0] != "/tmp/crashd/node-1/df.txt" {
+		t.Errorf("unexpected paths %v", paths)
+	}
+}
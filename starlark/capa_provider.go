@@ -12,29 +12,42 @@ import (
 )
 
 // CapaProviderFn is a built-in starlark function that collects compute resources from a k8s cluster
-// Starlark format: capa_provider(kube_config=kube_config(), ssh_config=ssh_config()[workload_cluster=<name>, namespace=<namespace>, nodes=["foo", "bar], labels=["bar", "baz"]])
+// Starlark format: capa_provider(kube_config=kube_config(), ssh_config=ssh_config()|"<name>"[workload_cluster=<name>, namespace=<namespace>, nodes=["foo", "bar], labels=["bar", "baz"], aws_config=aws_config()])
 func CapaProviderFn(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 
 	var (
 		workloadCluster, namespace string
 		names, labels              *starlark.List
-		sshConfig, mgmtKubeConfig  *starlarkstruct.Struct
+		mgmtKubeConfig             *starlarkstruct.Struct
+		sshConfigVal               starlark.Value
+		awsConfig                  *starlarkstruct.Struct
 	)
 
 	err := starlark.UnpackArgs("capa_provider", args, kwargs,
-		"ssh_config", &sshConfig,
+		"ssh_config", &sshConfigVal,
 		"mgmt_kube_config", &mgmtKubeConfig,
 		"workload_cluster?", &workloadCluster,
 		"namespace?", &namespace,
 		"labels?", &labels,
-		"nodes?", &names)
+		"nodes?", &names,
+		"aws_config?", &awsConfig)
 	if err != nil {
 		return starlark.None, errors.Wrap(err, "failed to unpack input arguments")
 	}
 
-	if sshConfig == nil || mgmtKubeConfig == nil {
+	if sshConfigVal == nil || mgmtKubeConfig == nil {
 		return starlark.None, errors.New("capa_provider requires the name of the management cluster, the ssh configuration and the management cluster kubeconfig")
 	}
+	sshConfig, err := getSSHConfig(thread, sshConfigVal)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "capa_provider")
+	}
+
+	if awsConfig != nil {
+		if err := applyAWSConfigStruct(thread, awsConfig); err != nil {
+			return starlark.None, errors.Wrap(err, "failed to apply aws configuration")
+		}
+	}
 
 	if mgmtKubeConfig == nil {
 		mgmtKubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
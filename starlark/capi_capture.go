@@ -0,0 +1,211 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+// capiMachine correlates a Cluster API Machine to its node and provider ID
+type capiMachine struct {
+	namespace  string
+	name       string
+	cluster    string
+	phase      string
+	node       string
+	providerID string
+	failure    string
+}
+
+func (m capiMachine) toStarlarkStruct() *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(
+		starlark.String("machine"),
+		starlark.StringDict{
+			"namespace":   starlark.String(m.namespace),
+			"name":        starlark.String(m.name),
+			"cluster":     starlark.String(m.cluster),
+			"phase":       starlark.String(m.phase),
+			"node":        starlark.String(m.node),
+			"provider_id": starlark.String(m.providerID),
+			"failure":     starlark.String(m.failure),
+		})
+}
+
+// capiCaptureFunc is a built-in starlark function that collects the machine health of a Cluster API
+// management cluster: the Clusters, Machines, MachineSets, MachineDeployments, MachineHealthChecks,
+// and KubeadmControlPlanes objects, and the logs of the CAPI controller pods (labeled with
+// cluster.x-k8s.io/provider). Each Machine is correlated to its node and provider ID in
+// <workdir>/capi/machines.txt; machines without a node are logged as warnings.
+// Starlark format: capi_capture([namespaces=["default"]][, workdir=path][, kube_config=kube_config()])
+func capiCaptureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir string
+	var namespaces *starlark.List
+	var kubeConfig *starlarkstruct.Struct
+
+	if err := starlark.UnpackArgs(
+		identifiers.capiCapture, args, kwargs,
+		"namespaces?", &namespaces,
+		"workdir?", &workdir,
+		"kube_config?", &kubeConfig,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.capiCapture, err)
+	}
+
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+	nsList := toSlice(namespaces)
+	if len(nsList) == 0 {
+		nsList = []string{"*"}
+	}
+
+	if kubeConfig == nil {
+		kubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
+	}
+	path, err := getKubeConfigFromStruct(kubeConfig)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "failed to kubeconfig")
+	}
+	client, err := k8s.New(path)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "could not initialize search client")
+	}
+
+	searchResults, err := client.Search(k8s.SearchParams{
+		Groups:     defaults.capiGroups,
+		Kinds:      defaults.capiKinds,
+		Namespaces: nsList,
+	})
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.capiCapture, err)
+	}
+
+	var captureErrs []string
+	resultDir := filepath.Join(workdir, k8s.BaseDirname)
+	if len(searchResults) > 0 {
+		resultWriter, err := k8s.NewResultWriter(workdir, "objects", getOutputFormat(thread), client.CoreRest)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.capiCapture, err)
+		}
+		if err := resultWriter.Write(searchResults); err != nil {
+			captureErrs = append(captureErrs, fmt.Sprintf("objects: %s", err))
+		}
+	}
+
+	if _, _, err := write(workdir, "logs", "", k8s.LayoutKubeCapture, client, nil, 0, k8s.SearchParams{
+		Namespaces: []string{"*"},
+		Labels:     []string{defaults.capiProviderLabel},
+	}); err != nil {
+		captureErrs = append(captureErrs, fmt.Sprintf("controller logs: %s", err))
+	}
+
+	machines := capiMachines(searchResults)
+	for _, m := range machines {
+		if len(m.node) == 0 {
+			logrus.Warnf("%s: machine %s/%s has no node (phase: %s) %s", identifiers.capiCapture, m.namespace, m.name, m.phase, m.failure)
+		}
+	}
+	machinesFile := filepath.Join(workdir, "capi", "machines.txt")
+	if err := writeCapiMachines(machinesFile, machines); err != nil {
+		captureErrs = append(captureErrs, fmt.Sprintf("machines: %s", err))
+	}
+
+	var machineVals []starlark.Value
+	for _, m := range machines {
+		machineVals = append(machineVals, m.toStarlarkStruct())
+	}
+	var captureErr string
+	if len(captureErrs) > 0 {
+		captureErr = strings.Join(captureErrs, "; ")
+		logrus.Errorf("%s: %s", identifiers.capiCapture, captureErr)
+	}
+
+	return starlarkstruct.FromStringDict(
+		starlark.String(identifiers.capiCapture),
+		starlark.StringDict{
+			"file":     starlark.String(resultDir),
+			"machines": starlark.NewList(machineVals),
+			"error":    starlark.String(captureErr),
+		}), nil
+}
+
+// capiMachines returns the Machines of the search results, sorted by namespace and name
+func capiMachines(results []k8s.SearchResult) []capiMachine {
+	var machines []capiMachine
+	for _, result := range results {
+		if result.List == nil || result.ResourceKind != "Machine" {
+			continue
+		}
+		for _, item := range result.List.Items {
+			m := capiMachine{namespace: item.GetNamespace(), name: item.GetName()}
+			m.cluster, _, _ = unstructured.NestedString(item.Object, "spec", "clusterName")
+			m.providerID, _, _ = unstructured.NestedString(item.Object, "spec", "providerID")
+			m.phase, _, _ = unstructured.NestedString(item.Object, "status", "phase")
+			m.node, _, _ = unstructured.NestedString(item.Object, "status", "nodeRef", "name")
+			reason, _, _ := unstructured.NestedString(item.Object, "status", "failureReason")
+			message, _, _ := unstructured.NestedString(item.Object, "status", "failureMessage")
+			switch {
+			case len(reason) > 0 && len(message) > 0:
+				m.failure = reason + ": " + message
+			default:
+				m.failure = reason + message
+			}
+			machines = append(machines, m)
+		}
+	}
+	sort.Slice(machines, func(i, j int) bool {
+		if machines[i].namespace != machines[j].namespace {
+			return machines[i].namespace < machines[j].namespace
+		}
+		return machines[i].name < machines[j].name
+	})
+	return machines
+}
+
+// writeCapiMachines saves the machine correlation table at path
+func writeCapiMachines(path string, machines []capiMachine) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0744); err != nil && !os.IsExist(err) {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return printCapiMachines(file, machines)
+}
+
+func printCapiMachines(w io.Writer, machines []capiMachine) error {
+	dash := func(s string) string {
+		if len(s) == 0 {
+			return "-"
+		}
+		return s
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tNAME\tCLUSTER\tPHASE\tNODE\tPROVIDER ID\tFAILURE")
+	for _, m := range machines {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", m.namespace, m.name, m.cluster, m.phase, dash(m.node), dash(m.providerID), m.failure)
+	}
+	return tw.Flush()
+}
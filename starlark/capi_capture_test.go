@@ -0,0 +1,65 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+func TestCapiMachines(t *testing.T) {
+	machine := func(name string, fields map[string]interface{}) unstructured.Unstructured {
+		obj := unstructured.Unstructured{Object: fields}
+		obj.SetNamespace("default")
+		obj.SetName(name)
+		return obj
+	}
+	results := []k8s.SearchResult{
+		{
+			ResourceKind: "Machine",
+			List: &unstructured.UnstructuredList{Items: []unstructured.Unstructured{
+				machine("md-0-b", map[string]interface{}{
+					"spec":   map[string]interface{}{"clusterName": "prod"},
+					"status": map[string]interface{}{"phase": "Failed", "failureReason": "CreateError", "failureMessage": "quota exceeded"},
+				}),
+				machine("md-0-a", map[string]interface{}{
+					"spec":   map[string]interface{}{"clusterName": "prod", "providerID": "vsphere://4201"},
+					"status": map[string]interface{}{"phase": "Running", "nodeRef": map[string]interface{}{"name": "prod-md-0-a"}},
+				}),
+			}},
+		},
+		{
+			ResourceKind: "MachineSet",
+			List:         &unstructured.UnstructuredList{Items: []unstructured.Unstructured{machine("md-0", nil)}},
+		},
+	}
+
+	machines := capiMachines(results)
+	if len(machines) != 2 {
+		t.Fatalf("expecting 2 machines, got %d", len(machines))
+	}
+	if machines[0].name != "md-0-a" || machines[0].node != "prod-md-0-a" || machines[0].providerID != "vsphere://4201" {
+		t.Errorf("unexpected machine: %+v", machines[0])
+	}
+	if machines[1].node != "" || machines[1].failure != "CreateError: quota exceeded" {
+		t.Errorf("unexpected machine: %+v", machines[1])
+	}
+
+	var out bytes.Buffer
+	if err := printCapiMachines(&out, machines); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expecting 3 lines, got:\n%s", out.String())
+	}
+	if fields := strings.Fields(lines[2]); fields[4] != "-" || fields[5] != "-" {
+		t.Errorf("expecting missing node and provider ID as -, got: %s", lines[2])
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 	"go.starlark.net/starlark"
@@ -17,29 +19,57 @@ import (
 	"github.com/vmware-tanzu/crash-diagnostics/ssh"
 )
 
+// captureDestPattern matches the placeholders of capture dest templates
+var captureDestPattern = regexp.MustCompile(`\{[^}]*\}`)
+
 // captureFunc is a built-in starlark function that runs a provided command and
 // captures the result of the command in a specified file stored in workdir.
 // If resources and workdir are not provided, captureFunc uses defaults from starlark thread generated
-// by previous calls to resources() and crashd_config().
-// Starlark format: capture(command-string, cmd="command" [,resources=resources][,workdir=path][,file_name=name][,desc=description])
+// by previous calls to resources() and crashd_config(). When echo is True, the captured output
+// is also printed to the console. When ssh_config is provided, it is used instead of the ssh_config
+// of the resources. The output file is named by the dest template, or crashd_config.capture_dest, when set
+// (see expandCaptureDest), or saved as <workdir>/<host>/<file_name>. The command, a command line or a list
+// of words, is run by shell (see newCommand) with the variables of env set in its environment.
+// Starlark format: capture(command-string, cmd="command"|["word", ...] [,resources=resources][,workdir=path][,file_name=name]
+// [,dest=template][,desc=description][,echo=bool][,ssh_config=ssh_config()|"<name>"][,shell="bash|sh|powershell|none"]
+// [,env={"NAME": "value"}])
 func captureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-	var cmdStr, workdir, fileName, desc string
+	var workdir, fileName, dest, desc, shell string
+	var cmdVal starlark.Value
 	var resources *starlark.List
+	var echo bool
+	var sshCfgVal starlark.Value
+	var envDict *starlark.Dict
 
 	if err := starlark.UnpackArgs(
 		identifiers.capture, args, kwargs,
-		"cmd", &cmdStr,
+		"cmd", &cmdVal,
 		"resources?", &resources,
 		"workdir?", &workdir,
 		"file_name?", &fileName,
+		"dest?", &dest,
 		"desc?", &desc,
+		"echo?", &echo,
+		"ssh_config?", &sshCfgVal,
+		"shell?", &shell,
+		"env?", &envDict,
 	); err != nil {
 		return starlark.None, fmt.Errorf("%s: %s", identifiers.capture, err)
 	}
+	cmd, err := newCommand(thread, cmdVal, shell, envDict)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.capture, err)
+	}
 
-	if len(cmdStr) == 0 {
+	if len(cmd.line) == 0 {
 		return starlark.None, fmt.Errorf("%s: missing command string", identifiers.capture)
 	}
+	if err := checkCommand(thread, identifiers.capture, cmd.line); err != nil {
+		return starlark.None, err
+	}
+	if len(dest) == 0 {
+		dest = getCaptureDest(thread)
+	}
 
 	if len(workdir) == 0 {
 		if dir, err := getWorkdirFromThread(thread); err == nil {
@@ -57,11 +87,22 @@ func captureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tup
 		}
 		resources = res
 	}
+	if sshCfgVal != nil {
+		sshCfg, err := getSSHConfig(thread, sshCfgVal)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.capture, err)
+		}
+		resources = withSSHConfig(resources, sshCfg)
+	}
+	if len(dest) > 0 && resources.Len() > 1 && !strings.Contains(dest, "{host}") {
+		return starlark.None, fmt.Errorf("%s: dest %s must contain {host} to capture from several resources", identifiers.capture, dest)
+	}
 
-	results, err := execCapture(cmdStr, workdir, fileName, desc, resources)
+	results, err := execCapture(cmd, workdir, fileName, dest, desc, resources)
 	if err != nil {
 		return starlark.None, fmt.Errorf("%s: %s", identifiers.capture, err)
 	}
+	teeCaptureResults(getTeeWriter(thread, echo), cmd.line, results)
 
 	// build list of struct as result
 	var resultList []starlark.Value
@@ -75,11 +116,17 @@ func captureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tup
 	return starlark.NewList(resultList), nil
 }
 
-func execCapture(cmdStr, rootPath, fileName, desc string, resources *starlark.List) ([]commandResult, error) {
+// execCapture runs and captures the command on each resource.
+// The output is saved under rootPath, as named by the dest template when not empty, or as <host>/<fileName>.
+func execCapture(cmd command, rootPath, fileName, dest, desc string, resources *starlark.List) ([]commandResult, error) {
 	if resources == nil {
 		return nil, fmt.Errorf("%s: missing resources", identifiers.capture)
 	}
 
+	if err := guardCommand(identifiers.capture, cmd.line); err != nil {
+		return nil, err
+	}
+
 	logrus.Debugf("%s: executing command on %d resources", identifiers.capture, resources.Len())
 	var results []commandResult
 	for i := 0; i < resources.Len(); i++ {
@@ -100,6 +147,9 @@ func execCapture(cmdStr, rootPath, fileName, desc string, resources *starlark.Li
 			return nil, fmt.Errorf("%s: resource.transport: %s", identifiers.capture, err)
 		}
 		transport := val.(starlark.String)
+		if err := guardTransport(identifiers.capture, string(transport)); err != nil {
+			return nil, err
+		}
 
 		val, err = res.Attr("host")
 		if err != nil {
@@ -107,12 +157,41 @@ func execCapture(cmdStr, rootPath, fileName, desc string, resources *starlark.Li
 		}
 		host := string(val.(starlark.String))
 		rootDir := filepath.Join(rootPath, sanitizeStr(host))
+		hostFileName := fileName
+		if len(dest) > 0 {
+			path, err := expandCaptureDest(dest, host, cmd.line, fileName)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", identifiers.capture, err)
+			}
+			rootDir, hostFileName = filepath.Split(filepath.Join(rootPath, path))
+		}
 
 		switch {
 		case string(kind) == identifiers.hostResource && string(transport) == "ssh":
-			result, err := execCaptureSSH(host, cmdStr, rootDir, fileName, desc, res)
+			result, err := execCaptureSSH(host, cmd, rootDir, hostFileName, desc, res)
+			if err != nil {
+				logrus.Errorf("%s failed: cmd=[%s]: %s", identifiers.capture, cmd.line, err)
+			}
+			results = append(results, result)
+		case string(kind) == identifiers.hostResource && string(transport) == localTransport:
+			result, err := execCaptureLocal(cmd, rootDir, hostFileName, desc)
+			if err != nil {
+				logrus.Errorf("%s failed: cmd=[%s]: %s", identifiers.capture, cmd.line, err)
+			}
+			results = append(results, result)
+		case string(kind) == identifiers.hostResource && string(transport) == kubeProxyTransport:
+			if len(cmd.env) > 0 {
+				logrus.Warnf("%s: env is ignored by the %s transport", identifiers.capture, kubeProxyTransport)
+			}
+			result, err := execCaptureKubeProxy(cmd.line, rootDir, hostFileName, desc, res)
 			if err != nil {
-				logrus.Errorf("%s failed: cmd=[%s]: %s", identifiers.capture, cmdStr, err)
+				logrus.Errorf("%s failed: path=[%s]: %s", identifiers.capture, cmd.line, err)
+			}
+			results = append(results, result)
+		case string(kind) == identifiers.hostResource && string(transport) == kubeAgentTransport:
+			result, err := execCaptureKubeAgent(cmd, rootDir, hostFileName, desc, res)
+			if err != nil {
+				logrus.Errorf("%s failed: cmd=[%s]: %s", identifiers.capture, cmd.line, err)
 			}
 			results = append(results, result)
 		default:
@@ -124,7 +203,72 @@ func execCapture(cmdStr, rootPath, fileName, desc string, resources *starlark.Li
 	return results, nil
 }
 
-func execCaptureSSH(host, cmdStr, rootDir, fileName, desc string, res *starlarkstruct.Struct) (commandResult, error) {
+// expandCaptureDest returns the path, relative to the working directory, of the output of cmd on host
+// named by the dest template. Placeholders are {host} and {cmd}, sanitized, and {file_name}, the
+// file_name of the capture or, when not set, the default file name of the command (<cmd>.txt).
+func expandCaptureDest(dest, host, cmd, fileName string) (string, error) {
+	if len(fileName) == 0 {
+		fileName = fmt.Sprintf("%s.txt", sanitizeStr(cmd))
+	}
+	values := map[string]string{"{host}": sanitizeStr(host), "{cmd}": sanitizeStr(cmd), "{file_name}": fileName}
+
+	var err error
+	path := captureDestPattern.ReplaceAllStringFunc(dest, func(placeholder string) string {
+		val, ok := values[placeholder]
+		if !ok {
+			err = fmt.Errorf("dest %s: unsupported placeholder %s (supported: {host}, {cmd}, {file_name})", dest, placeholder)
+		}
+		return val
+	})
+	if err != nil {
+		return "", err
+	}
+	path = filepath.Clean(path)
+	if filepath.IsAbs(path) || path == "." || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) || strings.HasSuffix(dest, "/") {
+		return "", fmt.Errorf("dest %s must be a file path relative to the working directory", dest)
+	}
+	return path, nil
+}
+
+// captureCommand is a command, and the file name used to save its output, that is
+// executed as part of a built-in collecting several related captures.
+type captureCommand struct {
+	cmd      string
+	fileName string
+	desc     string
+	// shell is the shell running the command, sh when not set
+	shell string
+}
+
+// execCaptureCommands runs and captures each command on all resources. The output of each
+// command is saved in a file under rootPath/<host>. The commands are run by sh (or their shell),
+// on local resources too, so that they can use pipes and redirections.
+func execCaptureCommands(rootPath string, cmds []captureCommand, resources *starlark.List) ([]commandResult, error) {
+	var results []commandResult
+	for _, cmd := range cmds {
+		shell := cmd.shell
+		if len(shell) == 0 {
+			shell = shellSh
+		}
+		cmdResults, err := execCapture(command{line: cmd.cmd, shell: shell}, rootPath, cmd.fileName, "", cmd.desc, resources)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, cmdResults...)
+	}
+	return results, nil
+}
+
+// commandResultsToList returns results as a starlark list of command_result structs
+func commandResultsToList(results []commandResult) *starlark.List {
+	var resultList []starlark.Value
+	for _, result := range results {
+		resultList = append(resultList, result.toStarlarkStruct())
+	}
+	return starlark.NewList(resultList)
+}
+
+func execCaptureSSH(host string, cmd command, rootDir, fileName, desc string, res *starlarkstruct.Struct) (commandResult, error) {
 	sshCfg := starlarkstruct.FromKeywords(starlarkstruct.Default, makeDefaultSSHConfig())
 	if val, err := res.Attr(identifiers.sshCfg); err == nil {
 		if cfg, ok := val.(*starlarkstruct.Struct); ok {
@@ -145,27 +289,56 @@ func execCaptureSSH(host, cmdStr, rootDir, fileName, desc string, res *starlarks
 	logrus.Debugf("%s: created capture dir: %s", identifiers.capture, rootDir)
 
 	if len(fileName) == 0 {
-		fileName = fmt.Sprintf("%s.txt", sanitizeStr(cmdStr))
+		fileName = fmt.Sprintf("%s.txt", sanitizeStr(cmd.line))
 	}
 	filePath := filepath.Join(rootDir, fileName)
 
-	logrus.Debugf("%s: capturing output of [cmd=%s] => [%s] from %s using ssh", identifiers.capture, cmdStr, filePath, args.Host)
+	remoteCmd, err := cmd.remote()
+	if err != nil {
+		return commandResult{}, err
+	}
+
+	logrus.Debugf("%s: capturing output of [cmd=%s] => [%s] from %s using ssh", identifiers.capture, remoteCmd, filePath, args.Host)
 
-	reader, err := ssh.RunRead(args, cmdStr)
+	file, err := os.Create(filePath)
 	if err != nil {
-		logrus.Errorf("%s failed: %s", identifiers.capture, err)
-		if err := captureOutput(strings.NewReader(err.Error()), filePath, fmt.Sprintf("%s: failed", cmdStr)); err != nil {
-			logrus.Errorf("%s output failed: %s", identifiers.capture, err)
+		return commandResult{resource: args.Host, result: filePath, err: err}, err
+	}
+	defer file.Close()
+	if len(desc) > 0 {
+		if _, err := fmt.Fprintln(file, desc); err != nil {
 			return commandResult{resource: args.Host, result: filePath, err: err}, err
 		}
 	}
 
-	if err := captureOutput(reader, filePath, desc); err != nil {
-		logrus.Errorf("%s output failed: %s", identifiers.capture, err)
-		return commandResult{resource: args.Host, result: filePath, err: err}, err
+	// stdout and stderr are written to the file as they are produced, the error is appended to them
+	out := &syncWriter{w: file}
+	result, err := ssh.RunCommandTo(args, remoteCmd, out, out)
+	if err == nil {
+		err = result.Err()
 	}
+	if err != nil {
+		logrus.Errorf("%s failed: %s", identifiers.capture, err)
+		if _, writeErr := fmt.Fprintf(file, "\n%s: failed: %s\n", cmd.line, err); writeErr != nil {
+			logrus.Errorf("%s output failed: %s", identifiers.capture, writeErr)
+			return commandResult{resource: args.Host, result: filePath, err: writeErr}, writeErr
+		}
+		return commandResult{resource: args.Host, result: filePath, err: err}, nil
+	}
+
+	return commandResult{resource: args.Host, result: filePath}, nil
+}
+
+// syncWriter serializes the writes to w, shared by the standard output and error of a command
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
 
-	return commandResult{resource: args.Host, result: filePath, err: err}, nil
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
 }
 
 func captureOutput(source io.Reader, filePath, desc string) error {
@@ -0,0 +1,144 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.starlark.net/starlark"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+	"github.com/vmware-tanzu/crash-diagnostics/ssh"
+)
+
+// captureIndexLocal is the thread local key used to store the capture index
+const captureIndexLocal = "crashd_capture_index"
+
+// fileIndexEntry records the state of a remote file when it was last copied
+type fileIndexEntry struct {
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"modTime"`
+}
+
+// captureIndex is a lightweight record of what was collected by previous runs.
+// It is used, in incremental mode, to collect only what changed since the last run:
+// unchanged remote files are skipped, grown files are copied from their previous size,
+// and API objects with an unchanged resourceVersion are not saved again.
+type captureIndex struct {
+	// mu guards the index against steps running in parallel
+	mu      sync.Mutex
+	path    string
+	Files   map[string]fileIndexEntry `json:"files"`
+	Objects map[string]string         `json:"objects"`
+}
+
+// loadCaptureIndex reads the index file at path. A missing file returns an empty index.
+func loadCaptureIndex(path string) (*captureIndex, error) {
+	idx := &captureIndex{path: path, Files: map[string]fileIndexEntry{}, Objects: map[string]string{}}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return idx, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("invalid capture index %s: %s", path, err)
+	}
+	if idx.Files == nil {
+		idx.Files = map[string]fileIndexEntry{}
+	}
+	if idx.Objects == nil {
+		idx.Objects = map[string]string{}
+	}
+	return idx, nil
+}
+
+// save writes the index back to its file
+func (idx *captureIndex) save() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(idx.path), 0744); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return ioutil.WriteFile(idx.path, data, 0644)
+}
+
+// fileOffset compares a remote file with its indexed state. It returns false when the file
+// is unchanged. Otherwise, it returns the offset from which the file should be copied:
+// the previous size when the file only grew, zero when it is new, truncated, or rotated.
+func (idx *captureIndex) fileOffset(host string, stat ssh.FileStat) (int64, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	prev, found := idx.Files[fileIndexKey(host, stat.Path)]
+	if !found {
+		return 0, true
+	}
+	if prev.Size == stat.Size && prev.ModTime == stat.ModTime {
+		return 0, false
+	}
+	if stat.Size > prev.Size {
+		return prev.Size, true
+	}
+	return 0, true
+}
+
+func (idx *captureIndex) updateFile(host string, stat ssh.FileStat) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.Files[fileIndexKey(host, stat.Path)] = fileIndexEntry{Size: stat.Size, ModTime: stat.ModTime}
+}
+
+// filterUnchangedObjects removes, from the search results, objects whose resourceVersion
+// is unchanged since they were indexed, and records the resourceVersion of the others.
+func (idx *captureIndex) filterUnchangedObjects(results []k8s.SearchResult) []k8s.SearchResult {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	var filtered []k8s.SearchResult
+	for _, result := range results {
+		if result.List == nil {
+			continue
+		}
+		var items []unstructured.Unstructured
+		for _, item := range result.List.Items {
+			uid := string(item.GetUID())
+			if len(uid) > 0 {
+				if idx.Objects[uid] == item.GetResourceVersion() {
+					continue
+				}
+				idx.Objects[uid] = item.GetResourceVersion()
+			}
+			items = append(items, item)
+		}
+		if len(items) == 0 {
+			continue
+		}
+		list := result.List.DeepCopy()
+		list.Items = items
+		result.List = list
+		filtered = append(filtered, result)
+	}
+	return filtered
+}
+
+func fileIndexKey(host, path string) string {
+	return fmt.Sprintf("%s:%s", host, path)
+}
+
+// getCaptureIndexFromThread returns the capture index loaded by crashd_config, or nil
+// when incremental capture is not enabled
+func getCaptureIndexFromThread(thread *starlark.Thread) *captureIndex {
+	idx, _ := thread.Local(captureIndexLocal).(*captureIndex)
+	return idx
+}
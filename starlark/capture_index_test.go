@@ -0,0 +1,109 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+	"github.com/vmware-tanzu/crash-diagnostics/ssh"
+)
+
+func TestCaptureIndexFileOffset(t *testing.T) {
+	idx := &captureIndex{Files: map[string]fileIndexEntry{
+		fileIndexKey("host0", "/var/log/syslog"): {Size: 100, ModTime: 10},
+	}}
+
+	tests := []struct {
+		name    string
+		stat    ssh.FileStat
+		offset  int64
+		changed bool
+	}{
+		{name: "unchanged", stat: ssh.FileStat{Path: "/var/log/syslog", Size: 100, ModTime: 10}},
+		{name: "grown", stat: ssh.FileStat{Path: "/var/log/syslog", Size: 150, ModTime: 20}, offset: 100, changed: true},
+		{name: "rotated", stat: ssh.FileStat{Path: "/var/log/syslog", Size: 20, ModTime: 30}, changed: true},
+		{name: "new file", stat: ssh.FileStat{Path: "/var/log/messages", Size: 20, ModTime: 30}, changed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			offset, changed := idx.fileOffset("host0", test.stat)
+			if changed != test.changed {
+				t.Errorf("expecting changed %t, got %t", test.changed, changed)
+			}
+			if offset != test.offset {
+				t.Errorf("expecting offset %d, got %d", test.offset, offset)
+			}
+		})
+	}
+}
+
+func TestCaptureIndexFilterUnchangedObjects(t *testing.T) {
+	makeObj := func(uid, version string) unstructured.Unstructured {
+		obj := unstructured.Unstructured{Object: map[string]interface{}{}}
+		obj.SetUID(types.UID(uid))
+		obj.SetResourceVersion(version)
+		return obj
+	}
+	idx := &captureIndex{Objects: map[string]string{"uid-0": "1", "uid-1": "1"}}
+	results := []k8s.SearchResult{
+		{ListKind: "PodList", List: &unstructured.UnstructuredList{Items: []unstructured.Unstructured{
+			makeObj("uid-0", "1"), makeObj("uid-1", "2"), makeObj("uid-2", "1"),
+		}}},
+		{ListKind: "ServiceList", List: &unstructured.UnstructuredList{Items: []unstructured.Unstructured{
+			makeObj("uid-0", "1"),
+		}}},
+	}
+
+	filtered := idx.filterUnchangedObjects(results)
+	if len(filtered) != 1 {
+		t.Fatalf("expecting 1 result, got %d", len(filtered))
+	}
+	if len(filtered[0].List.Items) != 2 {
+		t.Fatalf("expecting 2 changed objects, got %d", len(filtered[0].List.Items))
+	}
+	if idx.Objects["uid-1"] != "2" || idx.Objects["uid-2"] != "1" {
+		t.Errorf("index not updated: %v", idx.Objects)
+	}
+	if len(results[0].List.Items) != 3 {
+		t.Error("original search result should not be modified")
+	}
+}
+
+func TestCaptureIndexSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashd-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "index.json")
+	idx, err := loadCaptureIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.updateFile("host0", ssh.FileStat{Path: "/var/log/syslog", Size: 100, ModTime: 10})
+	idx.Objects["uid-0"] = "1"
+	if err := idx.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadCaptureIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Files[fileIndexKey("host0", "/var/log/syslog")] != (fileIndexEntry{Size: 100, ModTime: 10}) {
+		t.Errorf("unexpected file entries: %v", loaded.Files)
+	}
+	if loaded.Objects["uid-0"] != "1" {
+		t.Errorf("unexpected object entries: %v", loaded.Objects)
+	}
+}
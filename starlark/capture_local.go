@@ -7,8 +7,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
-	"github.com/vladimirvivien/echo"
 	"go.starlark.net/starlark"
 )
 
@@ -26,6 +26,9 @@ func captureLocalFunc(thread *starlark.Thread, b *starlark.Builtin, args starlar
 	); err != nil {
 		return starlark.None, fmt.Errorf("%s: %s", identifiers.captureLocal, err)
 	}
+	if err := checkCommand(thread, identifiers.captureLocal, cmdStr); err != nil {
+		return starlark.None, err
+	}
 
 	if len(workdir) == 0 {
 		dir, err := getWorkdirFromThread(thread)
@@ -43,12 +46,12 @@ func captureLocalFunc(thread *starlark.Thread, b *starlark.Builtin, args starlar
 		return starlark.None, fmt.Errorf("%s: %s", identifiers.captureLocal, err)
 	}
 
-	p := echo.New().RunProc(cmdStr)
-	if p.Err() != nil {
-		return starlark.None, fmt.Errorf("%s: %s", identifiers.captureLocal, p.Err())
+	out, err := runLocalProc(thread, cmdStr)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.captureLocal, err)
 	}
 
-	if err := captureOutput(p.Out(), filePath, desc); err != nil {
+	if err := captureOutput(strings.NewReader(out.Output), filePath, desc); err != nil {
 		return starlark.None, fmt.Errorf("%s: %s", identifiers.captureLocal, err)
 	}
 
@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -292,3 +293,83 @@ func TestCaptureFuncSSHAll(t *testing.T) {
 		})
 	}
 }
+
+func TestExpandCaptureDest(t *testing.T) {
+	tests := []struct {
+		name      string
+		dest      string
+		fileName  string
+		expected  string
+		shouldErr bool
+	}{
+		{name: "default layout", dest: "{host}/{file_name}", expected: "10_0_0_1/sudo_df__i.txt"},
+		{name: "file name", dest: "nodes/{host}/{file_name}", fileName: "df.txt", expected: "nodes/10_0_0_1/df.txt"},
+		{name: "command", dest: "{cmd}/{host}.out", expected: "sudo_df__i/10_0_0_1.out"},
+		{name: "unsupported placeholder", dest: "{node}/df.txt", shouldErr: true},
+		{name: "outside workdir", dest: "../{host}.txt", shouldErr: true},
+		{name: "dot dot prefix", dest: "..{host}/{file_name}", expected: "..10_0_0_1/sudo_df__i.txt"},
+		{name: "cleaned outside workdir", dest: "{host}/../../{file_name}", shouldErr: true},
+		{name: "absolute", dest: "/tmp/{host}.txt", shouldErr: true},
+		{name: "directory", dest: "{host}/", shouldErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, err := expandCaptureDest(test.dest, "10.0.0.1", "sudo df -i", test.fileName)
+			if err != nil {
+				if !test.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if test.shouldErr {
+				t.Fatalf("expecting error, got %s", path)
+			}
+			if path != filepath.FromSlash(test.expected) {
+				t.Errorf("expecting %s, got %s", test.expected, path)
+			}
+		})
+	}
+}
+
+func TestCaptureDestScript(t *testing.T) {
+	workdir, err := ioutil.TempDir("", "crashd-capture-dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+
+	script := fmt.Sprintf(`
+crashd_config(workdir="%s", capture_dest="nodes/{host}/{file_name}")
+set_defaults(resources(provider=local_provider()))
+global_dest = capture("echo global", file_name="global.txt")
+dest = capture("echo dest", dest="echo/{host}.txt")
+`, workdir)
+	exe := New()
+	if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a default template without the command would save all the captures in the same file
+	if err := New().Exec("test.star", strings.NewReader(`crashd_config(capture_dest="nodes/{host}.txt")`)); err == nil {
+		t.Error("expecting capture_dest without {cmd} or {file_name} to fail")
+	}
+
+	for name, expected := range map[string]string{"global_dest": "nodes/*/global.txt", "dest": "echo/*.txt"} {
+		result, ok := exe.result[name].(*starlarkstruct.Struct)
+		if !ok {
+			t.Fatalf("%s: unexpected result %s", name, exe.result[name])
+		}
+		path := structString(result, "result")
+		rel, err := filepath.Rel(workdir, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matched, _ := filepath.Match(filepath.FromSlash(expected), rel); !matched {
+			t.Errorf("%s: expecting file %s, got %s", name, expected, rel)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+}
@@ -12,17 +12,18 @@ import (
 )
 
 // CapvProviderFn is a built-in starlark function that collects compute resources from a k8s cluster
-// Starlark format: capv_provider(kube_config=kube_config(), ssh_config=ssh_config()[workload_cluster=<name>, namespace=<namespace>, nodes=["foo", "bar], labels=["bar", "baz"]])
+// Starlark format: capv_provider(kube_config=kube_config(), ssh_config=ssh_config()|"<name>"[workload_cluster=<name>, namespace=<namespace>, nodes=["foo", "bar], labels=["bar", "baz"]])
 func CapvProviderFn(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 
 	var (
 		workloadCluster, namespace string
 		names, labels              *starlark.List
-		sshConfig, mgmtKubeConfig  *starlarkstruct.Struct
+		mgmtKubeConfig             *starlarkstruct.Struct
+		sshConfigVal               starlark.Value
 	)
 
 	err := starlark.UnpackArgs("capv_provider", args, kwargs,
-		"ssh_config", &sshConfig,
+		"ssh_config", &sshConfigVal,
 		"mgmt_kube_config", &mgmtKubeConfig,
 		"workload_cluster?", &workloadCluster,
 		"namespace?", &namespace,
@@ -32,9 +33,13 @@ func CapvProviderFn(thread *starlark.Thread, _ *starlark.Builtin, args starlark.
 		return starlark.None, errors.Wrap(err, "failed to unpack input arguments")
 	}
 
-	if sshConfig == nil || mgmtKubeConfig == nil {
+	if sshConfigVal == nil || mgmtKubeConfig == nil {
 		return starlark.None, errors.New("capv_provider requires the name of the management cluster, the ssh configuration and the management cluster kubeconfig")
 	}
+	sshConfig, err := getSSHConfig(thread, sshConfigVal)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "capv_provider")
+	}
 
 	if mgmtKubeConfig == nil {
 		mgmtKubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
@@ -0,0 +1,85 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"github.com/pkg/errors"
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+	"github.com/vmware-tanzu/crash-diagnostics/provider"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// CapzProviderFn is a built-in starlark function that collects compute resources from a Cluster-API cluster
+// running on Azure. Besides the node addresses, it returns the provider IDs of the nodes, used to select the
+// VMs collected by azure_capture.
+// Starlark format: capz_provider(mgmt_kube_config=kube_config()[, ssh_config=ssh_config()|"<name>", workload_cluster=<name>, namespace=<namespace>, nodes=["foo", "bar], labels=["bar", "baz"]])
+func CapzProviderFn(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	var (
+		workloadCluster, namespace string
+		names, labels              *starlark.List
+		mgmtKubeConfig             *starlarkstruct.Struct
+		sshConfigVal               starlark.Value
+	)
+
+	err := starlark.UnpackArgs(identifiers.capzProvider, args, kwargs,
+		"mgmt_kube_config", &mgmtKubeConfig,
+		"ssh_config?", &sshConfigVal,
+		"workload_cluster?", &workloadCluster,
+		"namespace?", &namespace,
+		"labels?", &labels,
+		"nodes?", &names)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "failed to unpack input arguments")
+	}
+
+	sshConfig, err := getSSHConfig(thread, sshConfigVal)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, identifiers.capzProvider)
+	}
+
+	mgmtKubeConfigPath, err := getKubeConfigFromStruct(mgmtKubeConfig)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "failed to extract management kubeconfig")
+	}
+
+	providerConfigPath, err := provider.KubeConfig(mgmtKubeConfigPath, workloadCluster, namespace)
+	if err != nil {
+		return starlark.None, err
+	}
+
+	nodeAddresses, err := k8s.GetNodeAddresses(providerConfigPath, toSlice(names), toSlice(labels))
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "could not fetch host addresses")
+	}
+	providerIDs, err := k8s.GetNodeProviderIDs(providerConfigPath, toSlice(names), toSlice(labels))
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "could not fetch node provider IDs")
+	}
+
+	// dictionary for capz provider struct
+	capzProviderDict := starlark.StringDict{
+		"kind":        starlark.String(identifiers.capzProvider),
+		"transport":   starlark.String("ssh"),
+		"kube_config": starlark.String(providerConfigPath),
+	}
+
+	// add node info to dictionary
+	var nodeIps []starlark.Value
+	for _, node := range nodeAddresses {
+		nodeIps = append(nodeIps, starlark.String(node))
+	}
+	capzProviderDict["hosts"] = starlark.NewList(nodeIps)
+
+	var ids []starlark.Value
+	for _, id := range providerIDs {
+		ids = append(ids, starlark.String(id))
+	}
+	capzProviderDict["provider_ids"] = starlark.NewList(ids)
+
+	capzProviderDict[identifiers.sshCfg] = sshConfig
+
+	return starlarkstruct.FromStringDict(starlark.String(identifiers.capzProvider), capzProviderDict), nil
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"go.starlark.net/starlark"
+)
+
+// conntrackCaptureFunc is a built-in starlark function that captures conntrack statistics,
+// table counts and entries, and socket summaries on each compute resource. Listings of
+// conntrack entries and sockets are capped at max_entries lines.
+// Starlark format: conntrack_capture([resources=resources][, workdir=path][, max_entries=10000])
+func conntrackCaptureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir string
+	var maxEntries int
+	var resources *starlark.List
+
+	if err := starlark.UnpackArgs(
+		identifiers.conntrackCapture, args, kwargs,
+		"resources?", &resources,
+		"workdir?", &workdir,
+		"max_entries?", &maxEntries,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.conntrackCapture, err)
+	}
+
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+	if maxEntries == 0 {
+		maxEntries = defaults.conntrackMaxEntries
+	}
+
+	if resources == nil {
+		res, err := getResourcesFromThread(thread)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.conntrackCapture, err)
+		}
+		resources = res
+	}
+
+	results, err := execCaptureCommands(filepath.Join(workdir, "conntrack"), conntrackCaptureCommands(maxEntries), resources)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.conntrackCapture, err)
+	}
+
+	return commandResultsToList(results), nil
+}
+
+func conntrackCaptureCommands(maxEntries int) []captureCommand {
+	return []captureCommand{
+		{cmd: "sudo conntrack -S", fileName: "conntrack-stats.txt", desc: "conntrack per-CPU statistics"},
+		{
+			cmd:      "sudo sysctl net.netfilter.nf_conntrack_count net.netfilter.nf_conntrack_max net.netfilter.nf_conntrack_buckets",
+			fileName: "conntrack-count.txt",
+			desc:     "conntrack table usage",
+		},
+		{
+			cmd:      fmt.Sprintf("sudo conntrack -L 2>/dev/null | head -n %d", maxEntries),
+			fileName: "conntrack-entries.txt",
+			desc:     fmt.Sprintf("conntrack entries (first %d)", maxEntries),
+		},
+		{cmd: "ss -s", fileName: "ss-summary.txt", desc: "Socket summary"},
+		{cmd: "cat /proc/net/sockstat /proc/net/sockstat6", fileName: "sockstat.txt", desc: "Socket usage per protocol"},
+		{cmd: fmt.Sprintf("sudo ss -tanp | head -n %d", maxEntries), fileName: "ss-tcp.txt", desc: fmt.Sprintf("TCP sockets (first %d)", maxEntries)},
+		{cmd: fmt.Sprintf("sudo ss -uanp | head -n %d", maxEntries), fileName: "ss-udp.txt", desc: fmt.Sprintf("UDP sockets (first %d)", maxEntries)},
+		{cmd: "nstat -az", fileName: "nstat.txt", desc: "Network protocol counters"},
+	}
+}
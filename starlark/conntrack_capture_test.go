@@ -0,0 +1,66 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func testConntrackCaptureScriptForHostResources(t *testing.T, port, privateKey, username string) {
+	workdir := filepath.Join(testSupport.TmpDirRoot(), "conntrack-test")
+	defer os.RemoveAll(workdir)
+
+	script := fmt.Sprintf(`
+set_defaults(resources(provider = host_list_provider(hosts=["127.0.0.1"], ssh_config=ssh_config(username="%s", port="%s", private_key_path="%s"))))
+result = conntrack_capture(workdir="%s", max_entries=10)
+`, username, port, privateKey, workdir)
+
+	exe := New()
+	if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+
+	list, ok := exe.result["result"].(*starlark.List)
+	if !ok {
+		t.Fatalf("unexpected result type %T", exe.result["result"])
+	}
+	if list.Len() != len(conntrackCaptureCommands(10)) {
+		t.Errorf("unexpected number of results: %d", list.Len())
+	}
+
+	for _, cmd := range conntrackCaptureCommands(10) {
+		path := filepath.Join(workdir, "conntrack", sanitizeStr("127.0.0.1"), cmd.fileName)
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("capture file not found: %s", err)
+		}
+	}
+}
+
+func TestConntrackCaptureSSHAll(t *testing.T) {
+	if err := testSupport.SetupSSHServer(); err != nil {
+		t.Fatalf("failed to start SSH server: %s", err)
+	}
+	port := testSupport.PortValue()
+	privateKey := testSupport.PrivateKeyPath()
+	username := testSupport.CurrentUsername()
+
+	tests := []struct {
+		name string
+		test func(t *testing.T, port, key, username string)
+	}{
+		{name: "conntrack_capture script for host resources", test: testConntrackCaptureScriptForHostResources},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, port, privateKey, username)
+		})
+	}
+}
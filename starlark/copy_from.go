@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	"go.starlark.net/starlark"
@@ -22,23 +23,73 @@ import (
 // If resources and workdir are not provided, copyFromFunc uses defaults from starlark thread generated
 // by previous calls to resources(), ssh_config, and crashd_config().
 //
-// Starlark format: copy_from([<path>] [,path=<list>, resources=resources, workdir=path])
+// When tail_bytes or tail_lines is provided, only the end of each matched file is copied.
+// Multiple globs can be provided with paths, and files matching any of the exclude globs
+// are skipped. Both are evaluated on the remote host. When compress is True, the
+// transfer is compressed by the SSH transport. In incremental mode (crashd_config.index_file),
+// only files that changed since the previous run are copied. When resume is True, files are
+// copied in chunks and an interrupted transfer continues from where it stopped. bwlimit limits
+// the transfer rate and defaults to crashd_config.bwlimit. When verify is True, the SHA-256 of
+// each remote file is computed before the transfer and compared with the copied file. When
+// ssh_config is provided, it is used instead of the ssh_config of the resources.
+//
+// Starlark format: copy_from([<path>] [,path=<path>, paths=<list>, exclude=<list>, resources=resources, workdir=path, tail_bytes=N, tail_lines=N, compress=bool, resume=bool, bwlimit=rate, verify=bool,
+// ssh_config=ssh_config()|"<name>"])
 func copyFromFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var sourcePath, workdir string
-	var resources *starlark.List
+	var resources, paths, excludes *starlark.List
+	var bwLimit, sshCfgVal starlark.Value
+	var opts copyOptions
 
 	if err := starlark.UnpackArgs(
 		identifiers.capture, args, kwargs,
-		"path", &sourcePath,
+		"path?", &sourcePath,
+		"paths?", &paths,
+		"exclude?", &excludes,
 		"resources?", &resources,
 		"workdir?", &workdir,
+		"tail_bytes?", &opts.tailBytes,
+		"tail_lines?", &opts.tailLines,
+		"compress?", &opts.compress,
+		"resume?", &opts.resume,
+		"bwlimit?", &bwLimit,
+		"verify?", &opts.verify,
+		"ssh_config?", &sshCfgVal,
 	); err != nil {
 		return starlark.None, fmt.Errorf("%s: %s", identifiers.capture, err)
 	}
 
-	if len(sourcePath) == 0 {
+	limit, err := parseBandwidthLimit(bwLimit)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: bwlimit: %s", identifiers.copyFrom, err)
+	}
+	if limit == 0 {
+		limit = getBandwidthLimit(thread)
+	}
+	opts.bwLimit = limit
+
+	sourcePaths := toSlice(paths)
+	if len(sourcePath) > 0 {
+		sourcePaths = append([]string{sourcePath}, sourcePaths...)
+	}
+	if len(sourcePaths) == 0 {
 		return starlark.None, fmt.Errorf("%s: path arg not set", identifiers.copyFrom)
 	}
+	opts.excludes = toSlice(excludes)
+	opts.index = getCaptureIndexFromThread(thread)
+
+	if opts.tailBytes < 0 || opts.tailLines < 0 {
+		return starlark.None, fmt.Errorf("%s: tail_bytes and tail_lines must be positive", identifiers.copyFrom)
+	}
+	if opts.tailBytes > 0 && opts.tailLines > 0 {
+		return starlark.None, fmt.Errorf("%s: specify only one of tail_bytes or tail_lines", identifiers.copyFrom)
+	}
+	if opts.resume && opts.isTail() {
+		return starlark.None, fmt.Errorf("%s: resume cannot be used with tail_bytes or tail_lines", identifiers.copyFrom)
+	}
+	if opts.verify && opts.isTail() {
+		return starlark.None, fmt.Errorf("%s: verify cannot be used with tail_bytes or tail_lines", identifiers.copyFrom)
+	}
 
 	if len(workdir) == 0 {
 		if dir, err := getWorkdirFromThread(thread); err == nil {
@@ -56,11 +107,23 @@ func copyFromFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tu
 		}
 		resources = res
 	}
+	if sshCfgVal != nil {
+		sshCfg, err := getSSHConfig(thread, sshCfgVal)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.copyFrom, err)
+		}
+		resources = withSSHConfig(resources, sshCfg)
+	}
 
-	results, err := execCopy(workdir, sourcePath, resources)
+	results, err := execCopy(workdir, sourcePaths, resources, opts)
 	if err != nil {
 		return starlark.None, fmt.Errorf("%s: %s", identifiers.copyFrom, err)
 	}
+	if opts.index != nil {
+		if err := opts.index.save(); err != nil {
+			return starlark.None, fmt.Errorf("%s: failed to save capture index: %s", identifiers.copyFrom, err)
+		}
+	}
 
 	// build list of struct as result
 	var resultList []starlark.Value
@@ -74,7 +137,23 @@ func copyFromFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tu
 	return starlark.NewList(resultList), nil
 }
 
-func execCopy(rootPath string, path string, resources *starlark.List) ([]commandResult, error) {
+// copyOptions are the optional settings applied when copying files from resources
+type copyOptions struct {
+	tailBytes int
+	tailLines int
+	excludes  []string
+	compress  bool
+	resume    bool
+	bwLimit   int64
+	verify    bool
+	index     *captureIndex
+}
+
+func (o copyOptions) isTail() bool {
+	return o.tailBytes > 0 || o.tailLines > 0
+}
+
+func execCopy(rootPath string, paths []string, resources *starlark.List, opts copyOptions) ([]commandResult, error) {
 	if resources == nil {
 		return nil, fmt.Errorf("%s: missing resources", identifiers.copyFrom)
 	}
@@ -98,6 +177,9 @@ func execCopy(rootPath string, path string, resources *starlark.List) ([]command
 			return nil, fmt.Errorf("%s: resource.transport: %s", identifiers.copyFrom, err)
 		}
 		transport := val.(starlark.String)
+		if err := guardTransport(identifiers.copyFrom, string(transport)); err != nil {
+			return nil, err
+		}
 
 		val, err = res.Attr("host")
 		if err != nil {
@@ -108,9 +190,27 @@ func execCopy(rootPath string, path string, resources *starlark.List) ([]command
 
 		switch {
 		case string(kind) == identifiers.hostResource && string(transport) == "ssh":
-			result, err := execCopySCP(host, rootDir, path, res)
+			result, err := execCopySCP(host, rootDir, paths, res, opts)
 			if err != nil {
-				logrus.Errorf("%s: failed to copyFrom %s: %s", identifiers.copyFrom, path, err)
+				logrus.Errorf("%s: failed to copyFrom %s: %s", identifiers.copyFrom, strings.Join(paths, " "), err)
+			}
+			results = append(results, result)
+		case string(kind) == identifiers.hostResource && string(transport) == localTransport:
+			result, err := execCopyLocal(rootDir, paths, opts)
+			if err != nil {
+				logrus.Errorf("%s: failed to copyFrom %s: %s", identifiers.copyFrom, strings.Join(paths, " "), err)
+			}
+			results = append(results, result)
+		case string(kind) == identifiers.hostResource && string(transport) == kubeProxyTransport:
+			result, err := execCopyKubeProxy(rootDir, paths, res)
+			if err != nil {
+				logrus.Errorf("%s: failed to copyFrom %s: %s", identifiers.copyFrom, strings.Join(paths, " "), err)
+			}
+			results = append(results, result)
+		case string(kind) == identifiers.hostResource && string(transport) == kubeAgentTransport:
+			result, err := execCopyKubeAgent(rootDir, paths, res, opts)
+			if err != nil {
+				logrus.Errorf("%s: failed to copyFrom %s: %s", identifiers.copyFrom, strings.Join(paths, " "), err)
 			}
 			results = append(results, result)
 		default:
@@ -122,7 +222,7 @@ func execCopy(rootPath string, path string, resources *starlark.List) ([]command
 	return results, nil
 }
 
-func execCopySCP(host, rootDir, path string, res *starlarkstruct.Struct) (commandResult, error) {
+func execCopySCP(host, rootDir string, paths []string, res *starlarkstruct.Struct, opts copyOptions) (commandResult, error) {
 	sshCfg := starlarkstruct.FromKeywords(starlarkstruct.Default, makeDefaultSSHConfig())
 	if val, err := res.Attr(identifiers.sshCfg); err == nil {
 		if cfg, ok := val.(*starlarkstruct.Struct); ok {
@@ -135,12 +235,102 @@ func execCopySCP(host, rootDir, path string, res *starlarkstruct.Struct) (comman
 		return commandResult{}, err
 	}
 	args.Host = host
+	args.Compression = opts.compress
+	args.BandwidthLimit = opts.bwLimit
 
 	// create dir for the host
 	if err := os.MkdirAll(rootDir, 0744); err != nil && !os.IsExist(err) {
 		return commandResult{}, err
 	}
 
-	err = ssh.CopyFrom(args, rootDir, path)
-	return commandResult{resource: args.Host, result: filepath.Join(rootDir, path), err: err}, err
+	if opts.index != nil && !opts.isTail() {
+		return execCopyIncremental(args, rootDir, paths, opts)
+	}
+
+	// checksums are computed before the transfer, so that a file corrupted while
+	// it is copied is not mistaken for a good one
+	var sums map[string]string
+	if opts.verify {
+		sums, err = ssh.FindFileChecksums(args, paths, opts.excludes)
+		if err != nil {
+			return commandResult{resource: args.Host, result: rootDir, err: err}, err
+		}
+	}
+
+	// a single path without exclusions is copied as is (directories included)
+	if len(paths) == 1 && len(opts.excludes) == 0 {
+		switch {
+		case opts.isTail():
+			err = ssh.CopyTailFrom(args, rootDir, paths[0], opts.tailBytes, opts.tailLines)
+		case opts.resume:
+			err = ssh.CopyResumableFrom(args, rootDir, paths[0], ssh.ResumeChunkSize)
+		default:
+			err = ssh.CopyFrom(args, rootDir, paths[0])
+		}
+		if err == nil && opts.verify {
+			err = ssh.VerifyChecksums(rootDir, sums)
+		}
+		return commandResult{resource: args.Host, result: filepath.Join(rootDir, paths[0]), err: err}, err
+	}
+
+	files, err := ssh.FindFiles(args, paths, opts.excludes)
+	if err != nil {
+		return commandResult{resource: args.Host, result: rootDir, err: err}, err
+	}
+	for _, file := range files {
+		switch {
+		case opts.isTail():
+			err = ssh.CopyTailFrom(args, rootDir, file, opts.tailBytes, opts.tailLines)
+		case opts.resume:
+			err = ssh.CopyResumableFrom(args, rootDir, file, ssh.ResumeChunkSize)
+		default:
+			err = ssh.CopyFrom(args, rootDir, file)
+		}
+		if err != nil {
+			return commandResult{resource: args.Host, result: rootDir, err: err}, err
+		}
+	}
+	if opts.verify {
+		if err := ssh.VerifyChecksums(rootDir, sums); err != nil {
+			return commandResult{resource: args.Host, result: rootDir, err: err}, err
+		}
+	}
+	return commandResult{resource: args.Host, result: rootDir}, nil
+}
+
+// execCopyIncremental copies only the remote files that changed since they were last indexed.
+// Files that only grew are copied from their previously indexed size. With verify, only
+// files copied in full are verified.
+func execCopyIncremental(args ssh.SSHArgs, rootDir string, paths []string, opts copyOptions) (commandResult, error) {
+	stats, err := ssh.FindFileStats(args, paths, opts.excludes)
+	if err != nil {
+		return commandResult{resource: args.Host, result: rootDir, err: err}, err
+	}
+	var sums map[string]string
+	if opts.verify {
+		sums, err = ssh.FindFileChecksums(args, paths, opts.excludes)
+		if err != nil {
+			return commandResult{resource: args.Host, result: rootDir, err: err}, err
+		}
+	}
+	for _, stat := range stats {
+		offset, changed := opts.index.fileOffset(args.Host, stat)
+		if !changed {
+			logrus.Debugf("%s: skipping unchanged file %s", identifiers.copyFrom, stat.Path)
+			continue
+		}
+		if offset > 0 {
+			err = ssh.CopyFromOffset(args, rootDir, stat.Path, offset)
+		} else {
+			err = ssh.CopyFrom(args, rootDir, stat.Path)
+			if sum, ok := sums[stat.Path]; err == nil && ok {
+				err = ssh.VerifyChecksums(rootDir, map[string]string{stat.Path: sum})
+			}
+		}
+		if err != nil {
+			return commandResult{resource: args.Host, result: rootDir, err: err}, err
+		}
+		opts.index.updateFile(args.Host, stat)
+	}
+	return commandResult{resource: args.Host, result: rootDir}, nil
 }
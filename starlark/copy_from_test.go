@@ -352,6 +352,124 @@ result = cp(hosts)`, username, port, privateKey),
 				}
 			},
 		},
+
+		{
+			name:        "tail bytes copy",
+			remoteFiles: map[string]string{"bar/tail.txt": "FooBar"},
+			script: fmt.Sprintf(`
+set_defaults(resources(provider = host_list_provider(hosts=["127.0.0.1"], ssh_config = ssh_config(username="%s", port="%s", private_key_path="%s"))))
+result = copy_from(path="bar/tail.txt", tail_bytes=4)`, username, port, privateKey),
+			eval: func(t *testing.T, script string) {
+				exe := New()
+				if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+					t.Fatal(err)
+				}
+
+				strct, ok := exe.result["result"].(*starlarkstruct.Struct)
+				if !ok {
+					t.Fatalf("expecting type *starlarkstruct.Struct, got %T", exe.result["result"])
+				}
+				if val, err := strct.Attr("err"); err == nil && string(val.(starlark.String)) != "" {
+					t.Fatal(val)
+				}
+
+				path := filepath.Join(defaults.workdir, sanitizeStr("127.0.0.1"), "bar/tail.txt")
+				content, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if strings.TrimSpace(string(content)) != "Bar" {
+					t.Errorf("unexpected tail content: %s", content)
+				}
+				os.RemoveAll(path)
+			},
+		},
+
+		{
+			name:        "resumable copy",
+			remoteFiles: map[string]string{"bar/resume.txt": "FooBar"},
+			script: fmt.Sprintf(`
+set_defaults(resources(provider = host_list_provider(hosts=["127.0.0.1"], ssh_config = ssh_config(username="%s", port="%s", private_key_path="%s"))))
+result = copy_from(path="bar/resume.txt", resume=True)`, username, port, privateKey),
+			eval: func(t *testing.T, script string) {
+				exe := New()
+				if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+					t.Fatal(err)
+				}
+
+				strct, ok := exe.result["result"].(*starlarkstruct.Struct)
+				if !ok {
+					t.Fatalf("expecting type *starlarkstruct.Struct, got %T", exe.result["result"])
+				}
+				if val, err := strct.Attr("err"); err == nil && string(val.(starlark.String)) != "" {
+					t.Fatal(val)
+				}
+
+				path := filepath.Join(defaults.workdir, sanitizeStr("127.0.0.1"), "bar/resume.txt")
+				content, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(content) != "FooBar" {
+					t.Errorf("unexpected content: %s", content)
+				}
+				os.RemoveAll(path)
+			},
+		},
+
+		{
+			name:        "verified copy",
+			remoteFiles: map[string]string{"bar/verify.txt": "FooBar"},
+			script: fmt.Sprintf(`
+set_defaults(resources(provider = host_list_provider(hosts=["127.0.0.1"], ssh_config = ssh_config(username="%s", port="%s", private_key_path="%s"))))
+result = copy_from(path="bar/verify.txt", verify=True)`, username, port, privateKey),
+			eval: func(t *testing.T, script string) {
+				exe := New()
+				if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+					t.Fatal(err)
+				}
+
+				strct, ok := exe.result["result"].(*starlarkstruct.Struct)
+				if !ok {
+					t.Fatalf("expecting type *starlarkstruct.Struct, got %T", exe.result["result"])
+				}
+				if val, err := strct.Attr("err"); err == nil && string(val.(starlark.String)) != "" {
+					t.Fatal(val)
+				}
+				os.RemoveAll(filepath.Join(defaults.workdir, sanitizeStr("127.0.0.1"), "bar/verify.txt"))
+			},
+		},
+
+		{
+			name:        "paths with exclude",
+			remoteFiles: map[string]string{"glob/foo.log": "Foo", "glob/istio-proxy.log": "Istio"},
+			script: fmt.Sprintf(`
+set_defaults(resources(provider = host_list_provider(hosts=["127.0.0.1"], ssh_config = ssh_config(username="%s", port="%s", private_key_path="%s"))))
+result = copy_from(paths=["glob/*.log"], exclude=["*istio*"])`, username, port, privateKey),
+			eval: func(t *testing.T, script string) {
+				exe := New()
+				if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+					t.Fatal(err)
+				}
+
+				strct, ok := exe.result["result"].(*starlarkstruct.Struct)
+				if !ok {
+					t.Fatalf("expecting type *starlarkstruct.Struct, got %T", exe.result["result"])
+				}
+				if val, err := strct.Attr("err"); err == nil && string(val.(starlark.String)) != "" {
+					t.Fatal(val)
+				}
+
+				rootDir := filepath.Join(defaults.workdir, sanitizeStr("127.0.0.1"))
+				if _, err := os.Stat(filepath.Join(rootDir, "glob/foo.log")); err != nil {
+					t.Error(err)
+				}
+				if _, err := os.Stat(filepath.Join(rootDir, "glob/istio-proxy.log")); !os.IsNotExist(err) {
+					t.Error("excluded file should not be copied")
+				}
+				os.RemoveAll(filepath.Join(rootDir, "glob"))
+			},
+		},
 	}
 
 	sshArgs := ssh.SSHArgs{User: username, Host: "127.0.0.1", Port: port, PrivateKeyPath: privateKey}
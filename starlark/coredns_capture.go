@@ -0,0 +1,256 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+// dnsLookup is the result of a lookup run from the probe pod
+type dnsLookup struct {
+	name   string
+	ok     bool
+	file   string
+	output string
+}
+
+func (l dnsLookup) toStarlarkStruct() *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(
+		starlark.String("dns_lookup"),
+		starlark.StringDict{
+			"name":   starlark.String(l.name),
+			"ok":     starlark.Bool(l.ok),
+			"file":   starlark.String(l.file),
+			"output": starlark.String(l.output),
+		})
+}
+
+// corednsCaptureFunc is a built-in starlark function that packages the DNS triage workflow: the CoreDNS and
+// NodeLocal DNSCache ConfigMaps, workloads, services, endpoints, and pod logs, the resolv.conf, systemd-resolved
+// state, kubelet DNS settings, and nodelocaldns interface and iptables rules of each compute resource, and
+// lookups of the names run from a temporary probe pod in the pod network. The probe pod is deleted once the
+// lookups are done; lookups are skipped in read-only mode, since the pod is created in the cluster.
+// Starlark format: coredns_capture([resources=resources][, names=["name"]][, probe=True][, image="busybox:1.36"]
+// [, namespace="default"][, timeout="2m"][, workdir=path][, kube_config=kube_config()])
+func corednsCaptureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir, image, namespace string
+	var resources, names *starlark.List
+	probe := true
+	var timeoutVal starlark.Value
+	var kubeConfig *starlarkstruct.Struct
+
+	if err := starlark.UnpackArgs(
+		identifiers.corednsCapture, args, kwargs,
+		"resources?", &resources,
+		"names?", &names,
+		"probe?", &probe,
+		"image?", &image,
+		"namespace?", &namespace,
+		"timeout?", &timeoutVal,
+		"workdir?", &workdir,
+		"kube_config?", &kubeConfig,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.corednsCapture, err)
+	}
+
+	lookupNames := toSlice(names)
+	if len(lookupNames) == 0 {
+		lookupNames = defaults.dnsLookupNames
+	}
+	if len(namespace) == 0 {
+		namespace = "default"
+	}
+	timeout, err := toDuration(timeoutVal, defaults.dnsProbeTimeout)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: timeout: %s", identifiers.corednsCapture, err)
+	}
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+
+	if kubeConfig == nil {
+		kubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
+	}
+	path, err := getKubeConfigFromStruct(kubeConfig)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "failed to kubeconfig")
+	}
+	client, err := k8s.New(path)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "could not initialize search client")
+	}
+
+	resultDir := filepath.Join(workdir, "coredns")
+	var captureErrs []string
+
+	// CoreDNS and NodeLocal DNSCache objects and logs
+	configMaps, err := client.Search(k8s.SearchParams{
+		Groups:     []string{"core"},
+		Kinds:      []string{"configmaps"},
+		Namespaces: []string{"kube-system"},
+		Names:      defaults.dnsConfigMaps,
+	})
+	if err != nil {
+		captureErrs = append(captureErrs, fmt.Sprintf("configmaps: %s", err))
+	}
+	if len(configMaps) > 0 {
+		resultWriter, err := k8s.NewResultWriter(resultDir, "objects", getOutputFormat(thread), client.CoreRest)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.corednsCapture, err)
+		}
+		if err := resultWriter.Write(configMaps); err != nil {
+			captureErrs = append(captureErrs, fmt.Sprintf("configmaps: %s", err))
+		}
+	}
+	for _, label := range defaults.dnsLabels {
+		params := k8s.SearchParams{
+			Kinds:      []string{"deployments", "daemonsets", "services", "endpoints", "pods"},
+			Namespaces: []string{"kube-system"},
+			Labels:     []string{label},
+		}
+		if _, _, err := write(resultDir, "objects", getOutputFormat(thread), k8s.LayoutKubeCapture, client, nil, 0, params); err != nil {
+			captureErrs = append(captureErrs, fmt.Sprintf("objects (%s): %s", label, err))
+		}
+		if _, _, err := write(resultDir, "logs", "", k8s.LayoutKubeCapture, client, nil, 0, params); err != nil {
+			captureErrs = append(captureErrs, fmt.Sprintf("logs (%s): %s", label, err))
+		}
+	}
+
+	// DNS configuration of the nodes
+	var nodeResults []commandResult
+	if resources == nil {
+		if res, err := getResourcesFromThread(thread); err == nil {
+			resources = res
+		} else {
+			logrus.Debugf("%s: no resources, skipping the DNS configuration of the nodes", identifiers.corednsCapture)
+		}
+	}
+	if resources != nil {
+		if nodeResults, err = execCaptureCommands(filepath.Join(resultDir, "nodes"), corednsNodeCommands(), resources); err != nil {
+			captureErrs = append(captureErrs, fmt.Sprintf("nodes: %s", err))
+		}
+	}
+
+	// lookups from the pod network
+	var lookups []dnsLookup
+	switch {
+	case !probe:
+	case getExecOptions(thread).ReadOnly:
+		logrus.Warnf("%s: lookups skipped in read-only mode", identifiers.corednsCapture)
+	default:
+		lookups, err = runDNSLookups(client, namespace, image, lookupNames, filepath.Join(resultDir, "lookups"), timeout)
+		if err != nil {
+			captureErrs = append(captureErrs, fmt.Sprintf("lookups: %s", err))
+		}
+	}
+	var lookupVals []starlark.Value
+	for _, l := range lookups {
+		if !l.ok {
+			logrus.Warnf("%s: lookup of %s failed", identifiers.corednsCapture, l.name)
+		}
+		lookupVals = append(lookupVals, l.toStarlarkStruct())
+	}
+
+	var captureErr string
+	if len(captureErrs) > 0 {
+		captureErr = strings.Join(captureErrs, "; ")
+		logrus.Errorf("%s: %s", identifiers.corednsCapture, captureErr)
+	}
+
+	return starlarkstruct.FromStringDict(
+		starlark.String(identifiers.corednsCapture),
+		starlark.StringDict{
+			"file":    starlark.String(resultDir),
+			"nodes":   commandResultsToList(nodeResults),
+			"lookups": starlark.NewList(lookupVals),
+			"error":   starlark.String(captureErr),
+		}), nil
+}
+
+func corednsNodeCommands() []captureCommand {
+	return []captureCommand{
+		{cmd: "cat /etc/resolv.conf", fileName: "resolv.conf.txt", desc: "resolv.conf"},
+		{cmd: "cat /run/systemd/resolve/resolv.conf", fileName: "resolved-resolv.conf.txt", desc: "systemd-resolved upstream resolv.conf"},
+		{cmd: "resolvectl status 2>/dev/null || systemd-resolve --status", fileName: "resolved-status.txt", desc: "systemd-resolved status"},
+		{
+			cmd:      "sudo grep -i -A3 -e clusterDNS -e clusterDomain -e resolvConf /var/lib/kubelet/config.yaml",
+			fileName: "kubelet-dns.txt",
+			desc:     "kubelet DNS settings",
+		},
+		{cmd: "ip addr show nodelocaldns", fileName: "nodelocaldns-link.txt", desc: "nodelocaldns interface"},
+		{cmd: "sudo iptables-save -t raw", fileName: "iptables-raw.txt", desc: "iptables raw table (nodelocaldns NOTRACK rules)"},
+	}
+}
+
+// runDNSLookups runs nslookup of each name from a probe pod, and saves the output of each lookup, with the
+// resolv.conf of the pod, under dir. The probe pod is deleted once the lookups are done.
+func runDNSLookups(client *k8s.Client, namespace, image string, names []string, dir string, timeout time.Duration) ([]dnsLookup, error) {
+	probe, err := client.CreateProbePod(namespace, image, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := probe.Delete(); err != nil {
+			logrus.Errorf("%s: %s (delete it with: kubectl delete pod -n %s %s)", identifiers.corednsCapture, err, probe.Namespace, probe.Name)
+		}
+	}()
+
+	if err := os.MkdirAll(dir, 0744); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	var resolvConf bytes.Buffer
+	if _, err := execProbe(probe, []string{"cat", "/etc/resolv.conf"}, &resolvConf); err != nil {
+		return nil, err
+	}
+	if err := captureOutput(&resolvConf, filepath.Join(dir, "resolv.conf.txt"), "resolv.conf of the probe pod"); err != nil {
+		return nil, err
+	}
+
+	var lookups []dnsLookup
+	for _, name := range names {
+		var out bytes.Buffer
+		exitCode, err := execProbe(probe, []string{"nslookup", name}, &out)
+		if err != nil {
+			return lookups, err
+		}
+		l := dnsLookup{
+			name:   name,
+			ok:     exitCode == 0,
+			file:   filepath.Join(dir, fmt.Sprintf("%s.txt", sanitizeStr(name))),
+			output: strings.TrimSpace(out.String()),
+		}
+		if err := captureOutput(strings.NewReader(l.output), l.file, fmt.Sprintf("nslookup %s (exit status %d)", name, exitCode)); err != nil {
+			return lookups, err
+		}
+		lookups = append(lookups, l)
+	}
+	return lookups, nil
+}
+
+// execProbe runs the command in the probe pod, once checked against the read-only mode and the policy of the
+// running script, and writes its combined output to out
+func execProbe(probe *k8s.ProbePod, command []string, out io.Writer) (int, error) {
+	if err := guardCommand(identifiers.corednsCapture, joinWords(command)); err != nil {
+		return -1, err
+	}
+	return probe.Exec(command, out, out)
+}
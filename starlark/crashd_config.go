@@ -6,12 +6,20 @@ package starlark
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
 )
 
+// auditFileName is the audit trail saved in the working directory
+const auditFileName = "audit.log"
+
 // addDefaultCrashdConf initalizes a Starlark Dict with default
 // crashd_config configuration data
 func addDefaultCrashdConf(thread *starlark.Thread) error {
@@ -30,9 +38,14 @@ func addDefaultCrashdConf(thread *starlark.Thread) error {
 }
 
 // crashConfig is built-in starlark function that saves and returns the kwargs as a struct value.
-// Starlark format: crashd_config(workdir=path, default_shell=shellpath, requires=["command0",...,"commandN"])
+// The workdir, uid, and gid set on the command line (ExecOptions.Config) replace the declared values.
+// Starlark format: crashd_config(workdir=path, default_shell=shellpath, requires=["command0",...,"commandN"], max_bundle_size=size, index_file=path, output_format="json|yaml", archive_workers=count, bwlimit=rate,
+// capture_dest=template, discovery_cache_ttl="10m", kube_workers=8, kube_qps=5)
 func crashdConfigFn(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-	var workdir, gid, uid, defaultShell string
+	var workdir, gid, uid, defaultShell, indexFile, outputFormat, captureDest string
+	var maxBundleSize, bwLimit, discoveryCacheTTL, kubeQPS starlark.Value
+	var archiveWorkers int
+	kubeWorkers := k8s.DefaultAPIWorkers
 	requires := starlark.NewList([]starlark.Value{})
 
 	if err := starlark.UnpackArgs(
@@ -42,15 +55,85 @@ func crashdConfigFn(thread *starlark.Thread, b *starlark.Builtin, args starlark.
 		"uid?", &uid,
 		"default_shell?", &defaultShell,
 		"requires?", &requires,
+		"max_bundle_size?", &maxBundleSize,
+		"index_file?", &indexFile,
+		"output_format?", &outputFormat,
+		"archive_workers?", &archiveWorkers,
+		"bwlimit?", &bwLimit,
+		"capture_dest?", &captureDest,
+		"discovery_cache_ttl?", &discoveryCacheTTL,
+		"kube_workers?", &kubeWorkers,
+		"kube_qps?", &kubeQPS,
 	); err != nil {
 		return starlark.None, fmt.Errorf("%s: %s", identifiers.crashdCfg, err)
 	}
 
+	// values set on the command line take precedence over the script
+	overrides := getExecOptions(thread).Config
+	if len(overrides.Workdir) > 0 {
+		workdir = overrides.Workdir
+	}
+	if len(overrides.UID) > 0 {
+		uid = overrides.UID
+	}
+	if len(overrides.GID) > 0 {
+		gid = overrides.GID
+	}
+
+	maxBundleBytes, err := parseByteSize(maxBundleSize)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: max_bundle_size: %s", identifiers.crashdCfg, err)
+	}
+
+	bwLimitBytes, err := parseBandwidthLimit(bwLimit)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: bwlimit: %s", identifiers.crashdCfg, err)
+	}
+
+	cacheTTL, err := toDuration(discoveryCacheTTL, defaults.discoveryCacheTTL)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: discovery_cache_ttl: %s", identifiers.crashdCfg, err)
+	}
+
 	// validate
 	if len(workdir) == 0 {
 		workdir = defaults.workdir
 	}
 
+	if len(outputFormat) == 0 {
+		outputFormat = k8s.OutputFormatJSON
+	}
+	if err := k8s.ValidateOutputFormat(outputFormat); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.crashdCfg, err)
+	}
+
+	if len(captureDest) > 0 {
+		if _, err := expandCaptureDest(captureDest, "host", "cmd", ""); err != nil {
+			return starlark.None, fmt.Errorf("%s: capture_dest: %s", identifiers.crashdCfg, err)
+		}
+		// the default template names the files of all the captures: without the command, they would overwrite each other
+		if !strings.Contains(captureDest, "{cmd}") && !strings.Contains(captureDest, "{file_name}") {
+			return starlark.None, fmt.Errorf("%s: capture_dest %s must contain {cmd} or {file_name}", identifiers.crashdCfg, captureDest)
+		}
+	}
+
+	if kubeWorkers < 1 {
+		return starlark.None, fmt.Errorf("%s: kube_workers must be at least 1", identifiers.crashdCfg)
+	}
+
+	var qps float64
+	if kubeQPS != nil {
+		val, ok := starlark.AsFloat(kubeQPS)
+		if !ok || val < 0 {
+			return starlark.None, fmt.Errorf("%s: kube_qps must be a positive number", identifiers.crashdCfg)
+		}
+		qps = val
+	}
+
+	if archiveWorkers < 0 {
+		return starlark.None, fmt.Errorf("%s: archive_workers must be positive", identifiers.crashdCfg)
+	}
+
 	if len(gid) == 0 {
 		gid = getGid()
 	}
@@ -58,17 +141,50 @@ func crashdConfigFn(thread *starlark.Thread, b *starlark.Builtin, args starlark.
 	if len(uid) == 0 {
 		uid = getUid()
 	}
+	// local commands run as the user running crashd
+	if uid != getUid() || gid != getGid() {
+		logrus.Warnf("%s: uid %s and gid %s are not applied, local commands run as the current user (uid %s, gid %s)", identifiers.crashdCfg, uid, gid, getUid(), getGid())
+	}
 
 	if err := makeCrashdWorkdir(workdir); err != nil {
 		return starlark.None, fmt.Errorf("%s: %s", identifiers.crashdCfg, err)
 	}
 
+	// audit trail of commands, copies, and API requests, created in the workdir of the script by the first action
+	audit.SetFile(filepath.Join(workdir, auditFileName))
+
+	// API discovery data of the clusters, cached between runs
+	k8s.SetDiscoveryCache(k8s.DefaultDiscoveryCacheDir(), cacheTTL)
+
+	// concurrency and rate of the API requests of kube_capture and the other API functions
+	k8s.SetAPILimits(kubeWorkers, float32(qps))
+
+	// incremental capture: load the index of previous runs
+	if len(indexFile) > 0 {
+		idx, err := loadCaptureIndex(indexFile)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.crashdCfg, err)
+		}
+		thread.SetLocal(captureIndexLocal, idx)
+	} else {
+		thread.SetLocal(captureIndexLocal, nil)
+	}
+
 	cfgStruct := starlarkstruct.FromStringDict(starlark.String(identifiers.crashdCfg), starlark.StringDict{
-		"workdir":       starlark.String(workdir),
-		"gid":           starlark.String(gid),
-		"uid":           starlark.String(uid),
-		"default_shell": starlark.String(defaultShell),
-		"requires":      requires,
+		"workdir":             starlark.String(workdir),
+		"gid":                 starlark.String(gid),
+		"uid":                 starlark.String(uid),
+		"default_shell":       starlark.String(defaultShell),
+		"requires":            requires,
+		"max_bundle_size":     starlark.MakeInt64(maxBundleBytes),
+		"index_file":          starlark.String(indexFile),
+		"output_format":       starlark.String(outputFormat),
+		"archive_workers":     starlark.MakeInt(archiveWorkers),
+		"bwlimit":             starlark.MakeInt64(bwLimitBytes),
+		"capture_dest":        starlark.String(captureDest),
+		"discovery_cache_ttl": durationValue(cacheTTL),
+		"kube_workers":        starlark.MakeInt(kubeWorkers),
+		"kube_qps":            starlark.Float(qps),
 	})
 
 	// save values to be used as default
@@ -77,6 +193,83 @@ func crashdConfigFn(thread *starlark.Thread, b *starlark.Builtin, args starlark.
 	return cfgStruct, nil
 }
 
+// getOutputFormat returns the default output format of captured objects (crashd_config.output_format)
+func getOutputFormat(thread *starlark.Thread) string {
+	if cfg, ok := thread.Local(identifiers.crashdCfg).(*starlarkstruct.Struct); ok {
+		if val, err := cfg.Attr("output_format"); err == nil {
+			if format, ok := val.(starlark.String); ok && len(format) > 0 {
+				return string(format)
+			}
+		}
+	}
+	return k8s.OutputFormatJSON
+}
+
+// getCaptureDest returns the default file naming template of capture() (crashd_config.capture_dest),
+// empty when not set
+func getCaptureDest(thread *starlark.Thread) string {
+	if cfg, ok := thread.Local(identifiers.crashdCfg).(*starlarkstruct.Struct); ok {
+		if val, err := cfg.Attr("capture_dest"); err == nil {
+			if dest, ok := val.(starlark.String); ok {
+				return string(dest)
+			}
+		}
+	}
+	return ""
+}
+
+// getDefaultShell returns the shell running the commands of run() and capture() (crashd_config.default_shell),
+// empty when not set
+func getDefaultShell(thread *starlark.Thread) string {
+	if cfg, ok := thread.Local(identifiers.crashdCfg).(*starlarkstruct.Struct); ok {
+		if val, err := cfg.Attr("default_shell"); err == nil {
+			if shell, ok := val.(starlark.String); ok {
+				return string(shell)
+			}
+		}
+	}
+	return ""
+}
+
+// getArchiveWorkers returns the number of archive compression workers (crashd_config.archive_workers),
+// 0 when not set
+func getArchiveWorkers(thread *starlark.Thread) int {
+	if cfg, ok := thread.Local(identifiers.crashdCfg).(*starlarkstruct.Struct); ok {
+		if val, err := cfg.Attr("archive_workers"); err == nil {
+			if workers, ok := val.(starlark.Int); ok {
+				if n, ok := workers.Int64(); ok {
+					return int(n)
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// getBandwidthLimit returns the default transfer rate limit, in bytes per second,
+// of remote copies (crashd_config.bwlimit), 0 when not set
+func getBandwidthLimit(thread *starlark.Thread) int64 {
+	if cfg, ok := thread.Local(identifiers.crashdCfg).(*starlarkstruct.Struct); ok {
+		if val, err := cfg.Attr("bwlimit"); err == nil {
+			if limit, ok := val.(starlark.Int); ok {
+				if n, ok := limit.Int64(); ok {
+					return n
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// parseBandwidthLimit parses a rate in bytes per second, given as an int or
+// as a quantity string with an optional "/s" suffix (i.e. "10Mi", "500K/s")
+func parseBandwidthLimit(val starlark.Value) (int64, error) {
+	if rate, ok := val.(starlark.String); ok {
+		val = starlark.String(strings.TrimSuffix(string(rate), "/s"))
+	}
+	return parseByteSize(val)
+}
+
 func makeCrashdWorkdir(path string) error {
 	if _, err := os.Stat(path); err != nil && !os.IsNotExist(err) {
 		return err
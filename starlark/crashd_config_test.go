@@ -4,11 +4,14 @@
 package starlark
 
 import (
+	"io/ioutil"
 	"os"
 	"strings"
 	"testing"
 
 	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
 )
 
 func testCrashdConfigNew(t *testing.T) {
@@ -41,7 +44,7 @@ func testCrashdConfigFunc(t *testing.T) {
 				if !ok {
 					t.Fatalf("unexpected type for thread local key configs.crashd: %T", data)
 				}
-				if len(cfg.AttrNames()) != 5 {
+				if len(cfg.AttrNames()) != 14 {
 					t.Fatalf("unexpected item count in configs.crashd: %d", len(cfg.AttrNames()))
 				}
 
@@ -80,7 +83,7 @@ func testCrashdConfigFunc(t *testing.T) {
 				if !ok {
 					t.Fatalf("unexpected type for thread local key crashd_config: %T", data)
 				}
-				if len(cfg.AttrNames()) != 5 {
+				if len(cfg.AttrNames()) != 14 {
 					t.Fatalf("unexpected item count in configs.crashd: %d", len(cfg.AttrNames()))
 				}
 				val, err := cfg.Attr("uid")
@@ -90,6 +93,97 @@ func testCrashdConfigFunc(t *testing.T) {
 				if trimQuotes(val.String()) != getUid() {
 					t.Fatalf("unexpected value for key %s in configs.crashd", val.String())
 				}
+				if format := getOutputFormat(exe.thread); format != "json" {
+					t.Fatalf("unexpected default output format: %s", format)
+				}
+			},
+		},
+
+		{
+			name:   "crash_config output_format",
+			script: `crashd_config(output_format="yaml")`,
+			eval: func(t *testing.T, script string) {
+				exe := New()
+				if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+					t.Fatal(err)
+				}
+				if format := getOutputFormat(exe.thread); format != "yaml" {
+					t.Fatalf("unexpected output format: %s", format)
+				}
+				if err := New().Exec("test.star", strings.NewReader(`crashd_config(output_format="xml")`)); err == nil {
+					t.Fatal("expecting error for unsupported output format")
+				}
+			},
+		},
+
+		{
+			name:   "crash_config bwlimit",
+			script: `crashd_config(bwlimit="2Mi/s")`,
+			eval: func(t *testing.T, script string) {
+				exe := New()
+				if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+					t.Fatal(err)
+				}
+				if limit := getBandwidthLimit(exe.thread); limit != 2*1024*1024 {
+					t.Fatalf("unexpected bandwidth limit: %d", limit)
+				}
+				if err := New().Exec("test.star", strings.NewReader(`crashd_config(bwlimit="fast")`)); err == nil {
+					t.Fatal("expecting error for invalid bwlimit")
+				}
+			},
+		},
+
+		{
+			name:   "crash_config kube limits",
+			script: `cfg = crashd_config(kube_workers=16, kube_qps=20)`,
+			eval: func(t *testing.T, script string) {
+				defer k8s.SetAPILimits(k8s.DefaultAPIWorkers, 0)
+				exe := New()
+				if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+					t.Fatal(err)
+				}
+				cfg := exe.result["cfg"].(*starlarkstruct.Struct)
+				if val, _ := cfg.Attr("kube_workers"); val.String() != "16" {
+					t.Errorf("unexpected kube_workers: %s", val)
+				}
+				if val, _ := cfg.Attr("kube_qps"); val.String() != "20" {
+					t.Errorf("unexpected kube_qps: %s", val)
+				}
+				if err := New().Exec("test.star", strings.NewReader(`crashd_config(kube_workers=0)`)); err == nil {
+					t.Fatal("expecting error for invalid kube_workers")
+				}
+			},
+		},
+
+		{
+			name:   "crash_config command line overrides",
+			script: `cfg = crashd_config(workdir="fooval", uid="1000")`,
+			eval: func(t *testing.T, script string) {
+				workdir, err := ioutil.TempDir("", "crashd-override")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(workdir)
+				defer os.RemoveAll("fooval")
+
+				exe := New()
+				exe.SetOptions(ExecOptions{Config: ConfigOverrides{Workdir: workdir, GID: "2000"}})
+				if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+					t.Fatal(err)
+				}
+				cfg := exe.result["cfg"].(*starlarkstruct.Struct)
+				for name, expected := range map[string]string{"workdir": workdir, "uid": "1000", "gid": "2000"} {
+					val, err := cfg.Attr(name)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if trimQuotes(val.String()) != expected {
+						t.Errorf("unexpected %s: %s", name, val)
+					}
+				}
+				if _, err := os.Stat("fooval"); !os.IsNotExist(err) {
+					t.Error("overridden workdir was created")
+				}
 			},
 		},
 	}
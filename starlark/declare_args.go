@@ -0,0 +1,259 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// Types of script arguments
+const (
+	argTypeString = "string"
+	argTypeInt    = "int"
+	argTypeBool   = "bool"
+)
+
+// ArgSpec is a script argument declared with declare_args()
+type ArgSpec struct {
+	Name string
+	// Type is string, int, or bool
+	Type string
+	// Default is the default value, empty for required arguments
+	Default  string
+	Help     string
+	Required bool
+}
+
+// argSpecFunc is a built-in starlark function that describes a script argument for declare_args().
+// The type is derived from the default value when not set.
+// Starlark format: arg_spec([type="string|int|bool"][, default=value][, help="..."][, required=False])
+func argSpecFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var argType, help string
+	var defaultVal starlark.Value = starlark.None
+	var required bool
+
+	if err := starlark.UnpackArgs(
+		identifiers.argSpec, args, kwargs,
+		"type?", &argType,
+		"default?", &defaultVal,
+		"help?", &help,
+		"required?", &required,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.argSpec, err)
+	}
+
+	if len(argType) == 0 {
+		switch defaultVal.(type) {
+		case starlark.Int:
+			argType = argTypeInt
+		case starlark.Bool:
+			argType = argTypeBool
+		default:
+			argType = argTypeString
+		}
+	}
+	switch argType {
+	case argTypeString, argTypeInt, argTypeBool:
+	default:
+		return starlark.None, fmt.Errorf("%s: unsupported type %q (supported: %s, %s, %s)", identifiers.argSpec, argType, argTypeString, argTypeInt, argTypeBool)
+	}
+	if defaultVal != starlark.None {
+		if _, err := convertArg(argType, argString(defaultVal)); err != nil {
+			return starlark.None, fmt.Errorf("%s: default: %s", identifiers.argSpec, err)
+		}
+	}
+
+	return starlarkstruct.FromStringDict(starlark.String(identifiers.argSpec), starlark.StringDict{
+		"type":     starlark.String(argType),
+		"default":  defaultVal,
+		"help":     starlark.String(help),
+		"required": starlark.Bool(required),
+	}), nil
+}
+
+// declareArgsFunc is a built-in starlark function that declares the arguments of the script, each
+// described with arg_spec(). It returns a struct of the argument values passed with --args, converted
+// to their type, or their default. Missing required arguments are reported as errors.
+// Starlark format: declare_args(<name>=arg_spec(...), ...)
+func declareArgsFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	specs, err := parseArgSpecs(args, kwargs)
+	if err != nil {
+		return starlark.None, err
+	}
+
+	passed := getExecOptions(thread).Args
+	declared := make(map[string]bool)
+	values := make(starlark.StringDict)
+	for _, spec := range specs {
+		declared[spec.Name] = true
+		val, ok := passed[spec.Name]
+		switch {
+		case ok:
+		case spec.Required:
+			return starlark.None, fmt.Errorf("%s: missing required argument %s (--args %s=<%s>)", identifiers.declareArgs, spec.Name, spec.Name, spec.Type)
+		case len(spec.Default) == 0 && spec.Type == argTypeString:
+			values[spec.Name] = starlark.String("")
+			continue
+		case len(spec.Default) == 0 && spec.Type == argTypeBool:
+			values[spec.Name] = starlark.False
+			continue
+		case len(spec.Default) == 0:
+			values[spec.Name] = starlark.None
+			continue
+		default:
+			val = spec.Default
+		}
+		converted, err := convertArg(spec.Type, val)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: argument %s: %s", identifiers.declareArgs, spec.Name, err)
+		}
+		values[spec.Name] = converted
+	}
+
+	var unknown []string
+	for name := range passed {
+		if !declared[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		logrus.Warnf("%s: argument %s is not declared by the script", identifiers.declareArgs, name)
+	}
+
+	return starlarkstruct.FromStringDict(starlark.String("args"), values), nil
+}
+
+// parseArgSpecs returns the argument specs of the declare_args() keyword arguments, in order
+func parseArgSpecs(args starlark.Tuple, kwargs []starlark.Tuple) ([]ArgSpec, error) {
+	if len(args) > 0 {
+		return nil, fmt.Errorf("%s: arguments must be declared as keyword arguments", identifiers.declareArgs)
+	}
+	var specs []ArgSpec
+	for _, kwarg := range kwargs {
+		name := string(kwarg[0].(starlark.String))
+		spec, ok := kwarg[1].(*starlarkstruct.Struct)
+		if !ok || spec.Constructor() != starlark.String(identifiers.argSpec) {
+			return nil, fmt.Errorf("%s: argument %s: expecting %s(), got %s", identifiers.declareArgs, name, identifiers.argSpec, kwarg[1].Type())
+		}
+		argSpec := ArgSpec{
+			Name:     name,
+			Type:     structString(spec, "type"),
+			Help:     structString(spec, "help"),
+			Required: structBool(spec, "required"),
+		}
+		if defaultVal, err := spec.Attr("default"); err == nil && defaultVal != starlark.None {
+			argSpec.Default = argString(defaultVal)
+		}
+		specs = append(specs, argSpec)
+	}
+	return specs, nil
+}
+
+// convertArg converts an argument value passed on the command line to its type
+func convertArg(argType, val string) (starlark.Value, error) {
+	switch argType {
+	case argTypeInt:
+		i, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, fmt.Errorf("expecting an int, got %q", val)
+		}
+		return starlark.MakeInt(i), nil
+	case argTypeBool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("expecting a bool, got %q", val)
+		}
+		return starlark.Bool(b), nil
+	default:
+		return starlark.String(val), nil
+	}
+}
+
+// argString returns a value as passed on the command line
+func argString(val starlark.Value) string {
+	switch v := val.(type) {
+	case starlark.String:
+		return string(v)
+	case starlark.Bool:
+		return strconv.FormatBool(bool(v))
+	default:
+		return v.String()
+	}
+}
+
+func structBool(s *starlarkstruct.Struct, name string) bool {
+	val, err := s.Attr(name)
+	if err != nil {
+		return false
+	}
+	return bool(val.Truth())
+}
+
+// ScriptArgs returns the arguments declared by the script with declare_args(), without executing
+// the script: only the top-level declare_args() call, which must not depend on the script globals,
+// is evaluated, with arg_spec() as the only built-in. Scripts without declare_args() have no declared
+// arguments.
+func ScriptArgs(name string, source io.Reader) ([]ArgSpec, error) {
+	src, err := ioutil.ReadAll(source)
+	if err != nil {
+		return nil, err
+	}
+	file, err := syntax.Parse(name, src, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var call *syntax.CallExpr
+	for _, stmt := range file.Stmts {
+		var expr syntax.Expr
+		switch s := stmt.(type) {
+		case *syntax.ExprStmt:
+			expr = s.X
+		case *syntax.AssignStmt:
+			expr = s.RHS
+		}
+		if c, ok := expr.(*syntax.CallExpr); ok {
+			if fn, ok := c.Fn.(*syntax.Ident); ok && fn.Name == identifiers.declareArgs {
+				call = c
+				break
+			}
+		}
+	}
+	if call == nil {
+		return nil, nil
+	}
+
+	// only arg_spec() is available: the other built-ins are stubbed, so that reading the arguments
+	// (i.e. for --help or the shell completion) never runs a step of the script
+	var specs []ArgSpec
+	env := make(starlark.StringDict)
+	for name := range corePredeclareds() {
+		env[name] = starlark.NewBuiltin(name, declareArgsStub)
+	}
+	env[identifiers.argSpec] = starlark.NewBuiltin(identifiers.argSpec, argSpecFunc)
+	env[identifiers.declareArgs] = starlark.NewBuiltin(identifiers.declareArgs, func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		specs, err = parseArgSpecs(args, kwargs)
+		return starlark.None, err
+	})
+	thread := &starlark.Thread{Name: name}
+	if _, evalErr := starlark.EvalExpr(thread, call, env); evalErr != nil {
+		return nil, fmt.Errorf("%s: %s", identifiers.declareArgs, evalErr)
+	}
+	return specs, err
+}
+
+// declareArgsStub replaces the built-ins other than arg_spec() while the arguments are read by ScriptArgs
+func declareArgsStub(_ *starlark.Thread, b *starlark.Builtin, _ starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+	return starlark.None, fmt.Errorf("%s cannot be used in %s", b.Name(), identifiers.declareArgs)
+}
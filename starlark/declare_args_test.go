@@ -0,0 +1,124 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+const declareArgsScript = `
+params = declare_args(
+    kubecfg=arg_spec(default="/tmp/kubeconfig", help="the kubeconfig of the cluster"),
+    node=arg_spec(required=True, help="the node to inspect"),
+    retries=arg_spec(default=3),
+    verbose=arg_spec(type="bool"),
+)
+`
+
+func TestDeclareArgsScript(t *testing.T) {
+	tests := []struct {
+		name       string
+		script     string
+		args       map[string]string
+		shouldFail bool
+		expected   map[string]starlark.Value
+	}{
+		{
+			name:   "defaults",
+			script: declareArgsScript,
+			args:   map[string]string{"node": "worker-0"},
+			expected: map[string]starlark.Value{
+				"kubecfg": starlark.String("/tmp/kubeconfig"),
+				"node":    starlark.String("worker-0"),
+				"retries": starlark.MakeInt(3),
+				"verbose": starlark.False,
+			},
+		},
+		{
+			name:   "converted values",
+			script: declareArgsScript,
+			args:   map[string]string{"node": "worker-0", "retries": "5", "verbose": "true", "other": "ignored"},
+			expected: map[string]starlark.Value{
+				"retries": starlark.MakeInt(5),
+				"verbose": starlark.True,
+			},
+		},
+		{name: "missing required", script: declareArgsScript, args: map[string]string{}, shouldFail: true},
+		{name: "invalid int", script: declareArgsScript, args: map[string]string{"node": "n", "retries": "many"}, shouldFail: true},
+		{name: "invalid type", script: `declare_args(x=arg_spec(type="float"))`, shouldFail: true},
+		{name: "invalid default", script: `declare_args(x=arg_spec(type="int", default="many"))`, shouldFail: true},
+		{name: "not an arg_spec", script: `declare_args(x="foo")`, shouldFail: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exe := New()
+			exe.SetOptions(ExecOptions{Args: test.args})
+			err := exe.Exec("test.star", strings.NewReader(test.script))
+			if test.shouldFail {
+				if err == nil {
+					t.Fatal("expecting error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			params, ok := exe.result["params"].(*starlarkstruct.Struct)
+			if !ok {
+				t.Fatalf("unexpected result type %T", exe.result["params"])
+			}
+			for name, expected := range test.expected {
+				val, err := params.Attr(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if eq, err := starlark.Equal(val, expected); err != nil || !eq {
+					t.Errorf("argument %s: expecting %s, got %s", name, expected, val)
+				}
+			}
+		})
+	}
+}
+
+func TestScriptArgs(t *testing.T) {
+	specs, err := ScriptArgs("test.star", strings.NewReader(declareArgsScript+"\nkube_capture(what=\"logs\")\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ArgSpec{
+		{Name: "kubecfg", Type: "string", Default: "/tmp/kubeconfig", Help: "the kubeconfig of the cluster"},
+		{Name: "node", Type: "string", Help: "the node to inspect", Required: true},
+		{Name: "retries", Type: "int", Default: "3"},
+		{Name: "verbose", Type: "bool"},
+	}
+	if len(specs) != len(expected) {
+		t.Fatalf("expecting %d args, got %#v", len(expected), specs)
+	}
+	for i := range expected {
+		if specs[i] != expected[i] {
+			t.Errorf("expecting %#v, got %#v", expected[i], specs[i])
+		}
+	}
+
+	specs, err = ScriptArgs("test.star", strings.NewReader(`print("no args")`))
+	if err != nil || len(specs) != 0 {
+		t.Errorf("expecting no args, got %v %v", specs, err)
+	}
+
+	// the built-ins other than arg_spec are not run
+	script := `declare_args(node=arg_spec(default=run_local("touch /tmp/crashd-script-args")))`
+	if _, err := ScriptArgs("test.star", strings.NewReader(script)); err == nil || !strings.Contains(err.Error(), "run_local cannot be used") {
+		t.Errorf("expecting run_local to be refused, got %v", err)
+	}
+	if _, err := os.Stat("/tmp/crashd-script-args"); !os.IsNotExist(err) {
+		os.Remove("/tmp/crashd-script-args")
+		t.Error("expecting run_local not to be run")
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// platformProbe prints the platform of POSIX hosts as name=value lines
+var platformProbe = strings.Join([]string{
+	`echo "os=$(uname -s)"`,
+	`echo "kernel=$(uname -r)"`,
+	`echo "arch=$(uname -m)"`,
+	`if [ -r /etc/os-release ]; then . /etc/os-release; echo "distro=$ID"; echo "distro_like=$ID_LIKE"; echo "version=$VERSION_ID"; echo "pretty_name=$PRETTY_NAME"; fi`,
+	`if [ -d /run/systemd/system ]; then echo init=systemd; elif command -v rc-service >/dev/null 2>&1; then echo init=openrc; elif [ "$(uname -s)" = Darwin ]; then echo init=launchd; elif [ -r /proc/1/comm ]; then echo "init=$(cat /proc/1/comm)"; fi`,
+	`for s in /run/containerd/containerd.sock:containerd /run/k3s/containerd/containerd.sock:containerd /var/run/crio/crio.sock:cri-o /var/run/docker.sock:docker; do if [ -S "${s%:*}" ]; then echo "runtime=${s##*:}"; break; fi; done`,
+	`for p in apt-get:apt dnf:dnf yum:yum zypper:zypper apk:apk tdnf:tdnf pacman:pacman brew:brew; do if command -v "${p%:*}" >/dev/null 2>&1; then echo "package_manager=${p##*:}"; break; fi; done`,
+	`if command -v journalctl >/dev/null 2>&1; then echo journald=true; fi`,
+}, "\n")
+
+// windowsPlatformProbe prints the platform of Windows hosts, where the POSIX probe fails, as name=value lines
+var windowsPlatformProbe = strings.Join([]string{
+	`$os = Get-CimInstance Win32_OperatingSystem`,
+	`"os=Windows"`,
+	`"kernel=" + $os.Version`,
+	`"arch=" + $env:PROCESSOR_ARCHITECTURE`,
+	`"distro=windows"`,
+	`"version=" + $os.Version`,
+	`"pretty_name=" + $os.Caption`,
+	`"init=windows-services"`,
+	`foreach ($s in 'containerd','docker') { if (Get-Service $s -ErrorAction SilentlyContinue) { "runtime=" + $s; break } }`,
+	`if (Get-Command choco -ErrorAction SilentlyContinue) { "package_manager=choco" }`,
+}, "\n")
+
+// platform is the platform of a compute resource, as reported by the platform probes
+type platform struct {
+	resource         string
+	osFamily         string
+	distro           string
+	distroLike       string
+	version          string
+	prettyName       string
+	kernel           string
+	arch             string
+	initSystem       string
+	containerRuntime string
+	packageManager   string
+	journald         bool
+	err              error
+}
+
+// parsePlatform returns the platform printed by a platform probe
+func parsePlatform(resource, output string) platform {
+	p := platform{resource: resource}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(scanner.Text()), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.Trim(parts[1], `"`)
+		switch parts[0] {
+		case "os":
+			p.osFamily = strings.ToLower(value)
+		case "kernel":
+			p.kernel = value
+		case "arch":
+			p.arch = value
+		case "distro":
+			p.distro = value
+		case "distro_like":
+			p.distroLike = value
+		case "version":
+			p.version = value
+		case "pretty_name":
+			p.prettyName = value
+		case "init":
+			p.initSystem = value
+		case "runtime":
+			p.containerRuntime = value
+		case "package_manager":
+			p.packageManager = value
+		case "journald":
+			p.journald = value == "true"
+		}
+	}
+	return p
+}
+
+// toStarlarkStruct returns the platform as a starlark struct
+func (p platform) toStarlarkStruct() *starlarkstruct.Struct {
+	errStr := ""
+	if p.err != nil {
+		errStr = p.err.Error()
+	}
+	return starlarkstruct.FromStringDict(starlark.String("platform"), starlark.StringDict{
+		"resource":          starlark.String(p.resource),
+		"os_family":         starlark.String(p.osFamily),
+		"distro":            starlark.String(p.distro),
+		"distro_like":       starlark.String(p.distroLike),
+		"version":           starlark.String(p.version),
+		"pretty_name":       starlark.String(p.prettyName),
+		"kernel":            starlark.String(p.kernel),
+		"arch":              starlark.String(p.arch),
+		"init_system":       starlark.String(p.initSystem),
+		"container_runtime": starlark.String(p.containerRuntime),
+		"package_manager":   starlark.String(p.packageManager),
+		"journald":          starlark.Bool(p.journald),
+		"err":               starlark.String(errStr),
+	})
+}
+
+// detectPlatformFunc is a built-in starlark function that probes each compute resource for its OS family,
+// distribution, init system, container runtime, and package manager, so that scripts can choose the steps
+// of each host (i.e. journalctl or /var/log, apt or rpm). The probe is run by sh, then by PowerShell on the
+// hosts where sh is missing (Windows). It returns a list of platform structs (the struct itself when there
+// is only one resource). A host that cannot be probed has an empty os_family and its error in err.
+// Starlark format: detect_platform([resources=resources][,ssh_config=ssh_config()|"<name>"])
+func detectPlatformFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	platforms, err := detectPlatforms(thread, identifiers.detectPlatform, args, kwargs)
+	if err != nil {
+		return starlark.None, err
+	}
+	var values []starlark.Value
+	for _, p := range platforms {
+		if len(platforms) == 1 {
+			return p.toStarlarkStruct(), nil
+		}
+		values = append(values, p.toStarlarkStruct())
+	}
+	return starlark.NewList(values), nil
+}
+
+// osFamilyFunc is a built-in starlark function that returns the OS family (i.e. "linux", "windows",
+// or "darwin") of each compute resource, probed like detect_platform(), as a list of strings (the
+// string itself when there is only one resource). The OS family of a host that cannot be probed is empty.
+// Starlark format: os_family([resources=resources][,ssh_config=ssh_config()|"<name>"])
+func osFamilyFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	platforms, err := detectPlatforms(thread, identifiers.osFamily, args, kwargs)
+	if err != nil {
+		return starlark.None, err
+	}
+	var values []starlark.Value
+	for _, p := range platforms {
+		if len(platforms) == 1 {
+			return starlark.String(p.osFamily), nil
+		}
+		values = append(values, starlark.String(p.osFamily))
+	}
+	return starlark.NewList(values), nil
+}
+
+// detectPlatforms unpacks the arguments of detect_platform() and os_family(), and probes the platform of each resource
+func detectPlatforms(thread *starlark.Thread, name string, args starlark.Tuple, kwargs []starlark.Tuple) ([]platform, error) {
+	var resources *starlark.List
+	var sshCfgVal starlark.Value
+	if err := starlark.UnpackArgs(
+		name, args, kwargs,
+		"resources?", &resources,
+		"ssh_config?", &sshCfgVal,
+	); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+
+	if resources == nil {
+		res, err := getResourcesFromThread(thread)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		resources = res
+	}
+	if sshCfgVal != nil {
+		sshCfg, err := getSSHConfig(thread, sshCfgVal)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		resources = withSSHConfig(resources, sshCfg)
+	}
+
+	var platforms []platform
+	for i := 0; i < resources.Len(); i++ {
+		p, err := probePlatform(resources.Index(i))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		platforms = append(platforms, p...)
+	}
+	return platforms, nil
+}
+
+// probePlatform runs the platform probe on the resource, and the Windows probe when the POSIX probe fails
+func probePlatform(res starlark.Value) ([]platform, error) {
+	resource := starlark.NewList([]starlark.Value{res})
+	results, err := execRun(command{line: platformProbe, shell: shellSh}, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	var platforms []platform
+	for _, result := range results {
+		if result.err == nil && result.exitCode == 0 {
+			platforms = append(platforms, parsePlatform(result.resource, result.stdout))
+			continue
+		}
+
+		logrus.Debugf("%s: probing %s with PowerShell: %s", identifiers.detectPlatform, result.resource, result.err)
+		winResults, err := execRun(command{line: windowsPlatformProbe, shell: shellPowerShell}, resource)
+		if err == nil && len(winResults) == 1 && winResults[0].err == nil && winResults[0].exitCode == 0 {
+			platforms = append(platforms, parsePlatform(result.resource, winResults[0].stdout))
+			continue
+		}
+		platforms = append(platforms, platform{resource: result.resource, err: result.err})
+	}
+	return platforms, nil
+}
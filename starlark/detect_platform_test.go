@@ -0,0 +1,80 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+func TestParsePlatform(t *testing.T) {
+	output := `os=Linux
+kernel=5.15.0-91-generic
+arch=x86_64
+distro=ubuntu
+distro_like=debian
+version=22.04
+pretty_name=Ubuntu 22.04.3 LTS
+init=systemd
+runtime=containerd
+package_manager=apt
+journald=true
+`
+	p := parsePlatform("node-1", output)
+	expected := platform{
+		resource:         "node-1",
+		osFamily:         "linux",
+		distro:           "ubuntu",
+		distroLike:       "debian",
+		version:          "22.04",
+		prettyName:       "Ubuntu 22.04.3 LTS",
+		kernel:           "5.15.0-91-generic",
+		arch:             "x86_64",
+		initSystem:       "systemd",
+		containerRuntime: "containerd",
+		packageManager:   "apt",
+		journald:         true,
+	}
+	if p != expected {
+		t.Errorf("unexpected platform: %+v", p)
+	}
+
+	p = parsePlatform("win-1", "os=Windows\r\ndistro=windows\r\ninit=windows-services\r\n")
+	if p.osFamily != "windows" || p.distro != "windows" || p.initSystem != "windows-services" || p.journald {
+		t.Errorf("unexpected Windows platform: %+v", p)
+	}
+}
+
+func TestDetectPlatformLocal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("probes POSIX hosts")
+	}
+	script := `
+set_defaults(resources(provider=local_provider()))
+platform = detect_platform()
+family = os_family()
+`
+	exe := New()
+	if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+
+	platform := exe.result["platform"].(*starlarkstruct.Struct)
+	if val, _ := platform.Attr("err"); val != starlark.String("") {
+		t.Fatalf("unexpected error: %s", val)
+	}
+	if val, _ := platform.Attr("os_family"); val != starlark.String(runtime.GOOS) {
+		t.Errorf("unexpected os_family: %s", val)
+	}
+	if val, _ := platform.Attr("kernel"); val == starlark.String("") {
+		t.Error("expecting kernel release")
+	}
+	if family := exe.result["family"]; family != starlark.String(runtime.GOOS) {
+		t.Errorf("unexpected os_family(): %s", family)
+	}
+}
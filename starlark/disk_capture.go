@@ -0,0 +1,163 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+const (
+	dfUsageCmd = "df -P -x tmpfs -x devtmpfs -x overlay -x squashfs"
+	dfInodeCmd = "df -Pi -x tmpfs -x devtmpfs -x overlay -x squashfs"
+)
+
+// diskUsage is the usage percentage of a mounted filesystem
+type diskUsage struct {
+	mount   string
+	usedPct int
+}
+
+// diskFinding records a filesystem that exceeded the usage threshold on a resource
+type diskFinding struct {
+	resource string
+	mount    string
+	kind     string
+	usedPct  int
+}
+
+func (f diskFinding) toStarlarkStruct() *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(
+		starlark.String("disk_finding"),
+		starlark.StringDict{
+			"resource": starlark.String(f.resource),
+			"mount":    starlark.String(f.mount),
+			"kind":     starlark.String(f.kind),
+			"used_pct": starlark.MakeInt(f.usedPct),
+			"message":  starlark.String(f.message()),
+		},
+	)
+}
+
+func (f diskFinding) message() string {
+	return fmt.Sprintf("%s: %s usage of %s is at %d%%", f.resource, f.kind, f.mount, f.usedPct)
+}
+
+// diskCaptureFunc is a built-in starlark function that captures disk and inode usage on each
+// compute resource, along with the size of the specified paths. Filesystems with disk or inode
+// usage at or above warn_pct are reported as findings.
+// Starlark format: disk_capture([paths=["/var/log"]][, warn_pct=85][, resources=resources][, workdir=path])
+func diskCaptureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir string
+	var warnPct int
+	var paths, resources *starlark.List
+
+	if err := starlark.UnpackArgs(
+		identifiers.diskCapture, args, kwargs,
+		"paths?", &paths,
+		"warn_pct?", &warnPct,
+		"resources?", &resources,
+		"workdir?", &workdir,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.diskCapture, err)
+	}
+
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+	if warnPct == 0 {
+		warnPct = defaults.diskWarnPct
+	}
+	if warnPct < 0 || warnPct > 100 {
+		return starlark.None, fmt.Errorf("%s: warn_pct must be between 1 and 100", identifiers.diskCapture)
+	}
+	pathList := toSlice(paths)
+	if len(pathList) == 0 {
+		pathList = defaults.diskPaths
+	}
+
+	if resources == nil {
+		res, err := getResourcesFromThread(thread)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.diskCapture, err)
+		}
+		resources = res
+	}
+
+	cmds := []captureCommand{
+		{cmd: "df -h", fileName: "df.txt", desc: "Disk usage"},
+		{cmd: "df -i", fileName: "df-inodes.txt", desc: "Inode usage"},
+		{cmd: duCommand(pathList), fileName: "du.txt", desc: "Path sizes"},
+	}
+	results, err := execCaptureCommands(filepath.Join(workdir, "disk"), cmds, resources)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.diskCapture, err)
+	}
+
+	var findings []starlark.Value
+	for _, check := range []struct{ kind, cmd string }{{"disk", dfUsageCmd}, {"inode", dfInodeCmd}} {
+		checkResults, err := execRun(command{line: check.cmd}, resources)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.diskCapture, err)
+		}
+		for _, result := range checkResults {
+			if result.err != nil {
+				logrus.Errorf("%s: %s: %s", identifiers.diskCapture, result.resource, result.err)
+				continue
+			}
+			for _, usage := range parseDfUsage(result.result) {
+				if usage.usedPct < warnPct {
+					continue
+				}
+				finding := diskFinding{resource: result.resource, mount: usage.mount, kind: check.kind, usedPct: usage.usedPct}
+				logrus.Warnf("%s: %s", identifiers.diskCapture, finding.message())
+				findings = append(findings, finding.toStarlarkStruct())
+			}
+		}
+	}
+
+	return starlarkstruct.FromStringDict(
+		starlark.String(identifiers.diskCapture),
+		starlark.StringDict{
+			"results":  commandResultsToList(results),
+			"findings": starlark.NewList(findings),
+		}), nil
+}
+
+// duCommand returns the command reporting the size of paths. The paths are quoted: they are not expanded
+// by the shell of the host.
+func duCommand(paths []string) string {
+	return fmt.Sprintf("sudo du -sh -- %s 2>&1 || true", joinWords(paths))
+}
+
+// parseDfUsage parses the output of `df -P` or `df -Pi` and returns the usage
+// percentage for each mounted filesystem. Filesystems with no usage (i.e. "-") are skipped.
+func parseDfUsage(output string) []diskUsage {
+	var usages []diskUsage
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[0] == "Filesystem" {
+			continue
+		}
+		pct, err := strconv.Atoi(strings.TrimSuffix(fields[4], "%"))
+		if err != nil {
+			continue
+		}
+		usages = append(usages, diskUsage{mount: strings.Join(fields[5:], " "), usedPct: pct})
+	}
+	return usages
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseDfUsage(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected []diskUsage
+	}{
+		{
+			name: "disk usage",
+			output: `Filesystem     1024-blocks     Used Available Capacity Mounted on
+/dev/sda1         40581564 34493328   6071852      86% /
+/dev/sdb1        102687672  1052376  96376032       2% /var/lib/containerd`,
+			expected: []diskUsage{{mount: "/", usedPct: 86}, {mount: "/var/lib/containerd", usedPct: 2}},
+		},
+		{
+			name: "inode usage with unsupported fs",
+			output: `Filesystem      Inodes  IUsed   IFree IUse% Mounted on
+/dev/sda1      2580480 2580001     479  100% /
+vfat                 0      0       0     - /boot/efi`,
+			expected: []diskUsage{{mount: "/", usedPct: 100}},
+		},
+		{
+			name:   "empty output",
+			output: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			usages := parseDfUsage(test.output)
+			if len(usages) != len(test.expected) {
+				t.Fatalf("expecting %d usages, got %d", len(test.expected), len(usages))
+			}
+			for i, usage := range usages {
+				if usage != test.expected[i] {
+					t.Errorf("unexpected usage: %#v", usage)
+				}
+			}
+		})
+	}
+}
+
+func TestDuCommand(t *testing.T) {
+	cmd := duCommand([]string{"/var/log", "/data/my app", "/tmp/$(reboot)", "-x"})
+	expected := `sudo du -sh -- /var/log '/data/my app' '/tmp/$(reboot)' -x 2>&1 || true`
+	if cmd != expected {
+		t.Errorf("expecting %s, got %s", expected, cmd)
+	}
+}
+
+func TestDiskCaptureLocal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("disk_capture runs sh")
+	}
+	workdir, err := ioutil.TempDir("", "crashd-disk-capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+
+	script := fmt.Sprintf(`
+crashd_config(workdir="%s")
+set_defaults(resources(provider=local_provider()))
+disk = disk_capture(paths=["%s"])
+`, workdir, workdir)
+
+	exe := New()
+	if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the shell syntax of the du command is interpreted by sh, not passed as arguments to sudo
+	content, err := ioutil.ReadFile(filepath.Join(workdir, "disk", sanitizeStr(localHost), "du.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "2>&1") || strings.Contains(string(content), "||") {
+		t.Errorf("unexpected du output: %s", content)
+	}
+	content, err = ioutil.ReadFile(filepath.Join(workdir, "disk", sanitizeStr(localHost), "df.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "Filesystem") {
+		t.Errorf("unexpected df output: %s", content)
+	}
+}
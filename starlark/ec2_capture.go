@@ -0,0 +1,106 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/aws"
+)
+
+// ec2CaptureFunc is a built-in starlark function that collects the infrastructure-level state of
+// AWS-backed nodes with the aws CLI: the EC2 instance state and status checks, the system console
+// output, and the recent CloudTrail events of each instance. Instances are EC2 instance IDs or node
+// provider IDs (aws:///<zone>/<instance id>). Files are saved under <workdir>/ec2/<instance id>.
+// Credentials are selected with aws_config().
+// Starlark format: ec2_capture(instances=["i-0123456789abcdef0"][, region=name][, max_events=50][, workdir=path])
+func ec2CaptureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir, region string
+	var instanceList *starlark.List
+	maxEvents := defaults.ec2MaxEvents
+
+	if err := starlark.UnpackArgs(
+		identifiers.ec2Capture, args, kwargs,
+		"instances", &instanceList,
+		"region?", &region,
+		"max_events?", &maxEvents,
+		"workdir?", &workdir,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.ec2Capture, err)
+	}
+
+	var instances []string
+	for _, id := range toSlice(instanceList) {
+		instance, err := aws.InstanceID(id)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.ec2Capture, err)
+		}
+		instances = append(instances, instance)
+	}
+	if len(instances) == 0 {
+		return starlark.None, fmt.Errorf("%s: instances required", identifiers.ec2Capture)
+	}
+	cfg := getAWSConfig(thread)
+	if len(region) > 0 {
+		cfg.Region = region
+	}
+	if err := cfg.Validate(); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.ec2Capture, err)
+	}
+	if maxEvents <= 0 || maxEvents > 50 {
+		return starlark.None, fmt.Errorf("%s: max_events must be between 1 and 50", identifiers.ec2Capture)
+	}
+
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+
+	var results []starlark.Value
+	for _, instance := range instances {
+		results = append(results, captureEC2Instance(cfg, filepath.Join(workdir, "ec2", instance), instance, maxEvents))
+	}
+	return starlark.NewList(results), nil
+}
+
+// captureEC2Instance saves the state of an instance, with the credentials of cfg, in dir. Failed status
+// checks are logged as warnings.
+func captureEC2Instance(cfg aws.Config, dir, instance string, maxEvents int) *starlarkstruct.Struct {
+	var status aws.InstanceStatus
+	c, ok := newInfraCapture(identifiers.ec2Capture, "instance", instance, dir)
+	result := func() *starlarkstruct.Struct {
+		return c.result("ec2_instance", starlark.StringDict{
+			"state":           starlark.String(status.State),
+			"system_status":   starlark.String(status.SystemStatus),
+			"instance_status": starlark.String(status.InstanceStatus),
+		})
+	}
+	if !ok {
+		return result()
+	}
+
+	if output, ok := c.save("status", "status.json", func() (string, error) { return cfg.DescribeInstanceStatus(instance) }); ok {
+		var err error
+		if status, err = aws.ParseInstanceStatus(output); err != nil {
+			c.fail("status", err)
+		}
+		for check, value := range map[string]string{"system": status.SystemStatus, "instance": status.InstanceStatus} {
+			if len(value) > 0 && value != "ok" && value != "not-applicable" {
+				logrus.Warnf("%s: instance %s: %s status check is %s", identifiers.ec2Capture, instance, check, value)
+			}
+		}
+	}
+	c.save("console", "console.txt", func() (string, error) { return cfg.ConsoleOutput(instance) })
+	c.save("cloudtrail", "cloudtrail.json", func() (string, error) { return cfg.CloudTrailEvents(instance, maxEvents) })
+	return result()
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/vladimirvivien/echo"
+	"go.starlark.net/starlark"
+)
+
+// envNamePattern matches the names of environment variables
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// toEnv returns the variables of the env dict of a command (i.e. {"KUBECONFIG": "/etc/kubernetes/admin.conf"})
+// as NAME=value pairs, sorted by name
+func toEnv(dict *starlark.Dict) ([]string, error) {
+	if dict == nil {
+		return nil, nil
+	}
+	var env []string
+	for _, item := range dict.Items() {
+		name, ok := item[0].(starlark.String)
+		if !ok || !envNamePattern.MatchString(string(name)) {
+			return nil, fmt.Errorf("env: invalid variable name %s", item[0])
+		}
+		value, ok := item[1].(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("env: %s: expecting a string, got %s", name, item[1].Type())
+		}
+		env = append(env, fmt.Sprintf("%s=%s", string(name), string(value)))
+	}
+	sort.Strings(env)
+	return env, nil
+}
+
+// withRemoteEnv returns the command, run on remote hosts, with the variables of env set. The variables are
+// set by env(1), and the command run by sh, so that it does not depend on the shell of the user (i.e. the
+// syntax of export in csh or fish): env KUBECONFIG='/etc/kubernetes/admin.conf' sh -c 'kubectl get pods'
+func withRemoteEnv(cmdStr string, env []string) string {
+	if len(env) == 0 {
+		return cmdStr
+	}
+	return fmt.Sprintf("env %s sh -c %s", envAssignments(env), shellQuote(cmdStr))
+}
+
+// expandRemote returns the command line sent to remote hosts by the default shell, with the $NAME variables
+// expanded by crashd, except the variables of env, which are set on the host
+func expandRemote(cmdStr string, env []string) string {
+	if len(env) == 0 {
+		return echo.New().Eval(cmdStr)
+	}
+	names := make(map[string]bool)
+	for _, pair := range env {
+		names[strings.SplitN(pair, "=", 2)[0]] = true
+	}
+	return os.Expand(cmdStr, func(name string) string {
+		if names[name] {
+			return "${" + name + "}"
+		}
+		return os.Getenv(name)
+	})
+}
+
+// envAssignments returns the variables of env as assignments, with their values quoted for POSIX shells
+func envAssignments(env []string) string {
+	assignments := make([]string, len(env))
+	for i, pair := range env {
+		parts := strings.SplitN(pair, "=", 2)
+		assignments[i] = fmt.Sprintf("%s=%s", parts[0], shellQuote(parts[1]))
+	}
+	return strings.Join(assignments, " ")
+}
+
+// shellQuote returns s quoted for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+func TestToEnv(t *testing.T) {
+	dict := starlark.NewDict(2)
+	dict.SetKey(starlark.String("KUBECONFIG"), starlark.String("/etc/kubernetes/admin.conf"))
+	dict.SetKey(starlark.String("A_1"), starlark.String("x y"))
+	env, err := toEnv(dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(env, ";") != "A_1=x y;KUBECONFIG=/etc/kubernetes/admin.conf" {
+		t.Errorf("unexpected env: %q", env)
+	}
+
+	for _, item := range [][2]starlark.Value{
+		{starlark.String("1NAME"), starlark.String("value")},
+		{starlark.String("NAME;rm"), starlark.String("value")},
+		{starlark.String("NAME"), starlark.MakeInt(1)},
+	} {
+		dict := starlark.NewDict(1)
+		dict.SetKey(item[0], item[1])
+		if _, err := toEnv(dict); err == nil {
+			t.Errorf("expecting error for %s=%s", item[0], item[1])
+		}
+	}
+}
+
+func TestWithRemoteEnv(t *testing.T) {
+	if cmd := withRemoteEnv("uptime", nil); cmd != "uptime" {
+		t.Errorf("unexpected command without env: %s", cmd)
+	}
+
+	cmd := withRemoteEnv(`echo "$GREETING" | tr a-z A-Z; echo 'done'`, []string{"GREETING=it's me"})
+	if cmd != `env GREETING='it'\''s me' sh -c 'echo "$GREETING" | tr a-z A-Z; echo '\''done'\'''` {
+		t.Errorf("unexpected command: %s", cmd)
+	}
+	output, err := exec.Command("sh", "-c", cmd).CombinedOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "IT'S ME\ndone\n" {
+		t.Errorf("unexpected output: %q", output)
+	}
+}
+
+func TestCommandRemoteEnv(t *testing.T) {
+	env := []string{"GREETING=it's me"}
+	tests := []struct {
+		name     string
+		cmd      command
+		expected string
+		// output is the output of the command run by sh, empty when it is not run
+		output string
+	}{
+		{
+			name: "default shell",
+			cmd:  command{line: `echo "$GREETING"`, env: env},
+			// the variables of env are expanded on the host
+			expected: `env GREETING='it'\''s me' sh -c 'echo "${GREETING}"'`,
+			output:   "it's me\n",
+		},
+		{
+			name:     "list of words",
+			cmd:      command{line: joinWords([]string{"printenv", "GREETING"}), args: []string{"printenv", "GREETING"}, env: env},
+			expected: `env GREETING='it'\''s me' printenv GREETING`,
+			output:   "it's me\n",
+		},
+		{
+			name:     "no shell",
+			cmd:      command{line: "printenv GREETING", shell: shellNone, env: env},
+			expected: `env GREETING='it'\''s me' printenv GREETING`,
+			output:   "it's me\n",
+		},
+		{
+			name:     "posix shell",
+			cmd:      command{line: `echo "$GREETING" | tr a-z A-Z`, shell: shellSh, env: env},
+			expected: `env GREETING='it'\''s me' sh -c 'echo "$GREETING" | tr a-z A-Z'`,
+			output:   "IT'S ME\n",
+		},
+		{
+			name:     "powershell",
+			cmd:      command{line: "Write-Output $env:GREETING", shell: shellPowerShell, env: env},
+			expected: powershellCmd("$env:GREETING = 'it''s me'; Write-Output $env:GREETING"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			line, err := test.cmd.remote()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line != test.expected {
+				t.Errorf("unexpected remote command:\n%s\nexpecting:\n%s", line, test.expected)
+			}
+			if len(test.output) == 0 {
+				return
+			}
+			// the remote command is run by the shell of the user on the host
+			output, err := exec.Command("sh", "-c", line).CombinedOutput()
+			if err != nil {
+				t.Fatalf("%s: %s", err, output)
+			}
+			if string(output) != test.output {
+				t.Errorf("unexpected output: %q", output)
+			}
+		})
+	}
+}
+
+func TestRunEnv(t *testing.T) {
+	workdir, err := ioutil.TempDir("", "crashd-env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+
+	script := fmt.Sprintf(`
+crashd_config(workdir="%s")
+set_defaults(resources(provider=local_provider()))
+ran = run("printenv CRASHD_ENV_TEST", env={"CRASHD_ENV_TEST": "from run"})
+capture("printenv CRASHD_ENV_TEST", file_name="env.txt", env={"CRASHD_ENV_TEST": "from capture"})
+`, workdir)
+	exe := New()
+	if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+
+	ran := exe.result["ran"].(*starlarkstruct.Struct)
+	if val, _ := ran.Attr("result"); val != starlark.String("from run") {
+		t.Errorf("unexpected run result: %s", val)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(workdir, sanitizeStr(localHost), "env.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(content)) != "from capture" {
+		t.Errorf("unexpected captured content: %q", content)
+	}
+
+	if err := New().Exec("test.star", strings.NewReader(`run("uptime", resources=resources(provider=local_provider()), env={"A-B": "c"})`)); err == nil {
+		t.Error("expecting error for invalid variable name")
+	}
+}
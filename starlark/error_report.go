@@ -0,0 +1,96 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// errorReportFileName is the error report saved in the working directory
+const errorReportFileName = "errors.json"
+
+// StepError is an error reported by a step, either for the whole step or for
+// one of its targets (i.e. a host that could not be reached)
+type StepError struct {
+	// Step is the name of the built-in (i.e. copy_from)
+	Step string `json:"step"`
+	// Position is the location, in the script, of the invocation
+	Position string `json:"position,omitempty"`
+	// Target is the host or object the error is about, empty for errors of the whole step
+	Target  string    `json:"target,omitempty"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"error"`
+}
+
+// errorReport is the content of the error report file
+type errorReport struct {
+	Errors []StepError `json:"errors"`
+}
+
+// stepErrorsOf returns the error of the step, or the errors reported for each of its
+// targets when the step itself succeeded
+func stepErrorsOf(step stepTiming) []StepError {
+	if step.err != nil {
+		return []StepError{{Step: step.name, Position: step.pos, Time: step.start, Message: step.err.Error()}}
+	}
+
+	var errs []StepError
+	for _, row := range summaryRowsOf(step.result) {
+		if !row.failed {
+			continue
+		}
+		errs = append(errs, StepError{Step: step.name, Position: step.pos, Target: row.target, Time: step.start, Message: row.output})
+	}
+	return errs
+}
+
+// collectStepErrors returns the errors of the steps, in invocation order
+func collectStepErrors(steps []stepTiming) []StepError {
+	var errs []StepError
+	for _, step := range steps {
+		errs = append(errs, stepErrorsOf(step)...)
+	}
+	return errs
+}
+
+// writeErrorReport saves the errors of the steps, as JSON, in the working directory.
+// No report is written for runs without errors.
+func writeErrorReport(steps []stepTiming, workdir string) error {
+	errs := collectStepErrors(steps)
+	if len(errs) == 0 {
+		return nil
+	}
+	if _, err := os.Stat(workdir); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(errorReport{Errors: errs}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(workdir, errorReportFileName), data, 0644)
+}
+
+// failFastError returns the error stopping the script, when --fail-fast is set,
+// for a step that reported errors for some of its targets
+func failFastError(errs []StepError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	first := errs[0]
+	return fmt.Errorf("%s: stopped by fail-fast: %d error(s), first on %s: %s", first.Step, len(errs), first.Target, first.Message)
+}
+
+// Errors returns the errors reported by the steps of the last execution, in order
+func (e *Executor) Errors() []StepError {
+	timings, ok := e.thread.Local(stepTimingsLocal).(*stepTimings)
+	if !ok {
+		return nil
+	}
+	return collectStepErrors(timings.list())
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStepErrors(t *testing.T) {
+	steps := []stepTiming{
+		{name: "run", pos: "test.star:3:4", result: commandResultsToList([]commandResult{
+			{resource: "10.0.0.1", result: "ok"},
+			{resource: "10.0.0.2", err: errors.New("connection refused")},
+		})},
+		{name: "kube_capture", pos: "test.star:4:13", err: errors.New("kube_capture: failed to kubeconfig")},
+		{name: "capture", result: commandResultsToList([]commandResult{{resource: "10.0.0.1", result: "ok"}})},
+	}
+
+	errs := collectStepErrors(steps)
+	if len(errs) != 2 {
+		t.Fatalf("expecting 2 errors, got %d: %v", len(errs), errs)
+	}
+	if errs[0].Step != "run" || errs[0].Target != "10.0.0.2" || errs[0].Message != "connection refused" {
+		t.Errorf("unexpected host error: %#v", errs[0])
+	}
+	if errs[1].Step != "kube_capture" || errs[1].Target != "" || errs[1].Position != "test.star:4:13" {
+		t.Errorf("unexpected step error: %#v", errs[1])
+	}
+
+	if err := failFastError(stepErrorsOf(steps[0])); err == nil || !strings.Contains(err.Error(), "10.0.0.2") {
+		t.Errorf("unexpected fail-fast error: %v", err)
+	}
+	if err := failFastError(stepErrorsOf(steps[2])); err != nil {
+		t.Errorf("unexpected fail-fast error for successful step: %s", err)
+	}
+}
+
+func TestErrorReportFile(t *testing.T) {
+	workdir, err := ioutil.TempDir("", "crashd-errors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+
+	ok := []stepTiming{{name: "run", start: time.Now(), result: commandResultsToList([]commandResult{{resource: "10.0.0.1"}})}}
+	if err := writeErrorReport(ok, workdir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(workdir, errorReportFileName)); !os.IsNotExist(err) {
+		t.Fatal("expecting no error report for a run without errors")
+	}
+
+	failed := append(ok, stepTiming{name: "copy_from", start: time.Now(), result: commandResultsToList([]commandResult{
+		{resource: "10.0.0.2", err: errors.New("no route to host")},
+	})})
+	if err := writeErrorReport(failed, workdir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(workdir, errorReportFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report errorReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Errors) != 1 || report.Errors[0].Target != "10.0.0.2" || report.Errors[0].Message != "no route to host" {
+		t.Errorf("unexpected error report: %s", data)
+	}
+}
+
+func TestErrorReportScript(t *testing.T) {
+	workdir, err := ioutil.TempDir("", "crashd-errors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+
+	script := fmt.Sprintf(`
+crashd_config(workdir="%s")
+set_defaults(resources(provider=local_provider()))
+run("ls /crashd-missing-dir")
+run("echo after")
+`, workdir)
+
+	// by default, the script continues after a failed host
+	exe := New()
+	if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+	if errs := exe.Errors(); len(errs) != 1 || errs[0].Step != "run" || errs[0].Target != localHost {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if _, err := os.Stat(filepath.Join(workdir, errorReportFileName)); err != nil {
+		t.Fatal(err)
+	}
+	if steps := exe.Steps(); len(steps) != 6 {
+		t.Errorf("expecting all steps to be executed, got %d", len(steps))
+	}
+
+	// with fail-fast, the script stops at the failed step
+	exe = New()
+	exe.SetOptions(ExecOptions{FailFast: true})
+	if err := exe.Exec("test.star", strings.NewReader(script)); err == nil || !strings.Contains(err.Error(), "fail-fast") {
+		t.Fatalf("expecting fail-fast error, got %v", err)
+	}
+	if steps := exe.Steps(); len(steps) != 5 {
+		t.Errorf("expecting script to stop at the failed step, got %d steps", len(steps))
+	}
+}
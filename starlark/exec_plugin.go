@@ -0,0 +1,233 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+)
+
+// execPluginPrefix is the file name prefix of exec plugins found in plugin directories
+const execPluginPrefix = "crashd-plugin-"
+
+// An exec plugin is an executable, named crashd-plugin-<name>, that implements one or more
+// built-ins. It is invoked as:
+//
+//	crashd-plugin-<name> describe
+//	  prints the plugin description, as JSON, on stdout (i.e. {"builtins": ["myapp_capture"]})
+//	crashd-plugin-<name> call <builtin>
+//	  reads the call request as JSON on stdin and prints the response, as JSON, on stdout
+type execPluginDescription struct {
+	Builtins []string `json:"builtins"`
+}
+
+type execPluginRequest struct {
+	Builtin string                 `json:"builtin"`
+	Args    []interface{}          `json:"args"`
+	Kwargs  map[string]interface{} `json:"kwargs"`
+	Workdir string                 `json:"workdir"`
+}
+
+type execPluginResponse struct {
+	Result interface{} `json:"result"`
+	Error  string      `json:"error"`
+}
+
+// loadExecPlugins adds the built-ins of the exec plugins found in dirs to the predeclared values.
+// Plugins are only described when the script src uses names that are not declared otherwise, and
+// plugins failing to describe themselves are skipped. Built-ins already declared (by crashd, by
+// registered Go plugins, or by a previous plugin) are skipped.
+func (e *Executor) loadExecPlugins(dirs []string, name string, src []byte) error {
+	undeclared := e.undeclaredNames(name, src)
+	if len(undeclared) == 0 {
+		return nil
+	}
+	logrus.Debugf("describing exec plugins for %s", strings.Join(undeclared, ", "))
+
+	for _, dir := range dirs {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				logrus.Debugf("plugin directory %s not found", dir)
+				continue
+			}
+			return err
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasPrefix(entry.Name(), execPluginPrefix) || entry.Mode()&0111 == 0 {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			desc, err := describeExecPlugin(path)
+			if err != nil {
+				logrus.Warnf("plugin %s: %s, skipping", path, err)
+				continue
+			}
+			for _, name := range desc.Builtins {
+				if !builtinNamePattern.MatchString(name) {
+					logrus.Warnf("plugin %s: invalid built-in name %q, skipping", path, name)
+					continue
+				}
+				if _, ok := e.predecs[name]; ok {
+					logrus.Warnf("plugin %s: built-in %s already declared, skipping", path, name)
+					continue
+				}
+				logrus.Debugf("plugin %s: adding built-in %s", path, name)
+				e.predecs[name] = withTiming(newStepBuiltin(name, execPluginBuiltin(path, name)))
+			}
+		}
+	}
+	return nil
+}
+
+// undeclaredNames returns the names used by the script src that are neither predeclared nor universal,
+// nor defined by the script. Scripts that cannot be parsed have none: their error is reported when they run.
+func (e *Executor) undeclaredNames(name string, src []byte) []string {
+	file, err := syntax.Parse(name, src, 0)
+	if err != nil {
+		return nil
+	}
+	var undeclared []string
+	isPredeclared := func(name string) bool {
+		_, ok := e.predecs[name]
+		return ok
+	}
+	isUniversal := func(name string) bool {
+		if _, ok := starlark.Universe[name]; !ok {
+			undeclared = append(undeclared, name)
+		}
+		return true
+	}
+	resolve.File(file, isPredeclared, isUniversal)
+	return undeclared
+}
+
+func describeExecPlugin(path string) (execPluginDescription, error) {
+	var desc execPluginDescription
+	output, err := exec.Command(path, "describe").Output()
+	if err != nil {
+		return desc, fmt.Errorf("describe failed: %s", err)
+	}
+	if err := json.Unmarshal(output, &desc); err != nil {
+		return desc, fmt.Errorf("invalid description: %s", err)
+	}
+	return desc, nil
+}
+
+// execPluginBuiltin returns the implementation of a built-in provided by the exec plugin at path
+func execPluginBuiltin(path, name string) BuiltinFunc {
+	return func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		workdir, err := getWorkdirFromThread(thread)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", name, err)
+		}
+
+		req := execPluginRequest{Builtin: name, Kwargs: make(map[string]interface{}), Workdir: workdir}
+		for _, arg := range args {
+			val, err := starlarkToGoValue(arg)
+			if err != nil {
+				return starlark.None, fmt.Errorf("%s: %s", name, err)
+			}
+			req.Args = append(req.Args, val)
+		}
+		for _, kwarg := range kwargs {
+			val, err := starlarkToGoValue(kwarg[1])
+			if err != nil {
+				return starlark.None, fmt.Errorf("%s: %s: %s", name, kwarg[0], err)
+			}
+			req.Kwargs[string(kwarg[0].(starlark.String))] = val
+		}
+		input, err := json.Marshal(req)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", name, err)
+		}
+
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(path, "call", name)
+		cmd.Stdin = bytes.NewReader(input)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err = cmd.Run()
+		audit.Record(localHost, audit.LocalUser(), audit.ActionLocalRun, fmt.Sprintf("%s call %s", path, name), err)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: plugin failed: %s: %s", name, err, strings.TrimSpace(stderr.String()))
+		}
+
+		var resp execPluginResponse
+		decoder := json.NewDecoder(&stdout)
+		decoder.UseNumber()
+		if err := decoder.Decode(&resp); err != nil {
+			return starlark.None, fmt.Errorf("%s: invalid plugin response: %s", name, err)
+		}
+		if len(resp.Error) > 0 {
+			return starlark.None, fmt.Errorf("%s: %s", name, resp.Error)
+		}
+		return fromJSONValue(resp.Result)
+	}
+}
+
+// fromJSONValue converts a value, decoded from JSON, to its starlark value
+func fromJSONValue(val interface{}) (starlark.Value, error) {
+	switch v := val.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return starlark.MakeInt64(i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return starlark.None, err
+		}
+		return starlark.Float(f), nil
+	case string:
+		return starlark.String(v), nil
+	case []interface{}:
+		var list []starlark.Value
+		for _, elem := range v {
+			starElem, err := fromJSONValue(elem)
+			if err != nil {
+				return starlark.None, err
+			}
+			list = append(list, starElem)
+		}
+		return starlark.NewList(list), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		dict := starlark.NewDict(len(v))
+		for _, key := range keys {
+			starElem, err := fromJSONValue(v[key])
+			if err != nil {
+				return starlark.None, err
+			}
+			if err := dict.SetKey(starlark.String(key), starElem); err != nil {
+				return starlark.None, err
+			}
+		}
+		return dict, nil
+	default:
+		return starlark.None, fmt.Errorf("unsupported plugin value type %T", val)
+	}
+}
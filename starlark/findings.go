@@ -0,0 +1,195 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+const (
+	// findingsLocal is the thread local key used to store the findings of the run
+	findingsLocal = "crashd_findings"
+	// findingsFileName is the findings report saved in the working directory
+	findingsFileName = "findings.json"
+
+	severityInfo = "info"
+	severityWarn = "warn"
+	severityFail = "fail"
+)
+
+// Finding is a known problem recorded by the script with finding() or a failed assert()
+type Finding struct {
+	Title    string `json:"title"`
+	Detail   string `json:"detail,omitempty"`
+	Severity string `json:"severity"`
+	// Position is the location, in the script, of the finding() or assert() call
+	Position string    `json:"position,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+func (f Finding) toStarlarkStruct() *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(
+		starlark.String(identifiers.finding),
+		starlark.StringDict{
+			"title":    starlark.String(f.Title),
+			"detail":   starlark.String(f.Detail),
+			"severity": starlark.String(f.Severity),
+			"position": starlark.String(f.Position),
+		},
+	)
+}
+
+// findingList records the findings of a run
+type findingList struct {
+	mu       sync.Mutex
+	findings []Finding
+}
+
+func (l *findingList) add(f Finding) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.findings = append(l.findings, f)
+}
+
+// list returns the findings in the order they were recorded
+func (l *findingList) list() []Finding {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	findings := make([]Finding, len(l.findings))
+	copy(findings, l.findings)
+	return findings
+}
+
+// failed returns the number of findings with the fail severity
+func (l *findingList) failed() int {
+	count := 0
+	for _, f := range l.list() {
+		if f.Severity == severityFail {
+			count++
+		}
+	}
+	return count
+}
+
+// findingFunc is a built-in starlark function that records a finding: a known problem
+// detected by the script. Findings with the fail severity fail the run once the script completes.
+// Starlark format: finding(title[, detail=text][, severity="info|warn|fail"])
+func findingFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var title, detail string
+	severity := severityWarn
+
+	if err := starlark.UnpackArgs(
+		identifiers.finding, args, kwargs,
+		"title", &title,
+		"detail?", &detail,
+		"severity?", &severity,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.finding, err)
+	}
+
+	if len(title) == 0 {
+		return starlark.None, fmt.Errorf("%s: title is required", identifiers.finding)
+	}
+	switch severity {
+	case severityInfo, severityWarn, severityFail:
+	default:
+		return starlark.None, fmt.Errorf("%s: severity must be one of info, warn, or fail, got %s", identifiers.finding, severity)
+	}
+
+	finding := Finding{Title: title, Detail: detail, Severity: severity, Position: callerPosition(thread), Time: time.Now()}
+	recordFinding(thread, finding)
+	return finding.toStarlarkStruct(), nil
+}
+
+// assertFunc is a built-in starlark function that records a finding when cond is false.
+// It returns the truth value of cond so that scripts can react to failed checks.
+// Starlark format: assert(cond[, msg=text][, severity="warn|fail"])
+func assertFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var cond starlark.Value
+	msg := "assertion failed"
+	severity := severityFail
+
+	if err := starlark.UnpackArgs(
+		identifiers.assert, args, kwargs,
+		"cond", &cond,
+		"msg?", &msg,
+		"severity?", &severity,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.assert, err)
+	}
+
+	if severity != severityWarn && severity != severityFail {
+		return starlark.None, fmt.Errorf("%s: severity must be warn or fail, got %s", identifiers.assert, severity)
+	}
+
+	if cond.Truth() {
+		return starlark.True, nil
+	}
+	recordFinding(thread, Finding{Title: msg, Severity: severity, Position: callerPosition(thread), Time: time.Now()})
+	return starlark.False, nil
+}
+
+// recordFinding adds the finding to the run and updates the findings report in the working directory
+func recordFinding(thread *starlark.Thread, finding Finding) {
+	switch finding.Severity {
+	case severityFail:
+		logrus.Errorf("finding: %s: %s", finding.Title, finding.Detail)
+	case severityWarn:
+		logrus.Warnf("finding: %s: %s", finding.Title, finding.Detail)
+	default:
+		logrus.Infof("finding: %s: %s", finding.Title, finding.Detail)
+	}
+
+	findings, ok := thread.Local(findingsLocal).(*findingList)
+	if !ok {
+		return
+	}
+	findings.add(finding)
+
+	if workdir, err := getWorkdirFromThread(thread); err == nil {
+		if err := writeFindingsFile(findings, workdir); err != nil {
+			logrus.Debugf("failed to write findings report: %s", err)
+		}
+	}
+}
+
+func writeFindingsFile(findings *findingList, workdir string) error {
+	if _, err := os.Stat(workdir); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(struct {
+		Findings []Finding `json:"findings"`
+	}{Findings: findings.list()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(workdir, findingsFileName), data, 0644)
+}
+
+// callerPosition returns the location, in the script, of the call to the running built-in
+func callerPosition(thread *starlark.Thread) string {
+	if thread.CallStackDepth() > 1 {
+		return thread.CallFrame(1).Pos.String()
+	}
+	return ""
+}
+
+// Findings returns the findings recorded by the script during the last execution
+func (e *Executor) Findings() []Finding {
+	findings, ok := e.thread.Local(findingsLocal).(*findingList)
+	if !ok {
+		return nil
+	}
+	return findings.list()
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+func TestFindingsScript(t *testing.T) {
+	workdir, err := ioutil.TempDir("", "crashd-findings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+
+	tests := []struct {
+		name       string
+		script     string
+		findings   int
+		shouldFail bool
+		eval       func(t *testing.T, exe *Executor)
+	}{
+		{
+			name:     "finding defaults to warn",
+			script:   `f = finding("etcd slow", detail="fsync above 100ms")`,
+			findings: 1,
+			eval: func(t *testing.T, exe *Executor) {
+				f, ok := exe.result["f"].(*starlarkstruct.Struct)
+				if !ok {
+					t.Fatalf("unexpected finding type %T", exe.result["f"])
+				}
+				if structString(f, "severity") != severityWarn || structString(f, "detail") != "fsync above 100ms" {
+					t.Errorf("unexpected finding: %s", f)
+				}
+				if pos := structString(f, "position"); !strings.HasPrefix(pos, "test.star:3:") {
+					t.Errorf("unexpected finding position: %s", pos)
+				}
+			},
+		},
+		{
+			name:     "passing assert",
+			script:   `ok = assert(1 + 1 == 2, "math is broken")`,
+			findings: 0,
+			eval: func(t *testing.T, exe *Executor) {
+				if exe.result["ok"] != starlark.True {
+					t.Errorf("expecting assert to return True, got %s", exe.result["ok"])
+				}
+			},
+		},
+		{
+			name:     "failed assert with warn severity",
+			script:   `ok = assert(False, "no ingress controller", severity="warn")`,
+			findings: 1,
+			eval: func(t *testing.T, exe *Executor) {
+				if exe.result["ok"] != starlark.False {
+					t.Errorf("expecting assert to return False, got %s", exe.result["ok"])
+				}
+				if f := exe.Findings()[0]; f.Title != "no ingress controller" || f.Severity != severityWarn {
+					t.Errorf("unexpected finding: %#v", f)
+				}
+			},
+		},
+		{
+			name:       "failed assert fails the run",
+			script:     `assert([], "no nodes found")`,
+			findings:   1,
+			shouldFail: true,
+		},
+		{
+			name:       "fail finding fails the run",
+			script:     `finding("OOMKilled pods", severity="fail")`,
+			findings:   1,
+			shouldFail: true,
+		},
+		{
+			name:       "bad severity",
+			script:     `finding("disk full", severity="critical")`,
+			shouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exe := New()
+			script := fmt.Sprintf("crashd_config(workdir=%q)\n\n%s\n", workdir, test.script)
+			err := exe.Exec("test.star", strings.NewReader(script))
+			if err != nil && !test.shouldFail {
+				t.Fatal(err)
+			}
+			if err == nil && test.shouldFail {
+				t.Fatal("expecting failure, but got none")
+			}
+			if len(exe.Findings()) != test.findings {
+				t.Fatalf("expecting %d findings, got %d", test.findings, len(exe.Findings()))
+			}
+			if test.eval != nil {
+				test.eval(t, exe)
+			}
+		})
+	}
+}
+
+func TestFindingsFile(t *testing.T) {
+	workdir, err := ioutil.TempDir("", "crashd-findings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+
+	findings := &findingList{}
+	findings.add(Finding{Title: "etcd slow", Severity: severityWarn})
+	findings.add(Finding{Title: "no nodes found", Severity: severityFail})
+	if findings.failed() != 1 {
+		t.Errorf("expecting 1 failed finding, got %d", findings.failed())
+	}
+
+	if err := writeFindingsFile(findings, workdir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(workdir, findingsFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		Findings []Finding `json:"findings"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Findings) != 2 || report.Findings[1].Title != "no nodes found" {
+		t.Errorf("unexpected findings report: %s", data)
+	}
+}
@@ -6,6 +6,7 @@ package starlark
 import (
 	"fmt"
 	"reflect"
+	"time"
 
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
@@ -191,3 +192,68 @@ func GoToStarlarkValue(val interface{}) (starlark.Value, error) {
 		return starlark.None, fmt.Errorf("unable to assert Go type %T as Starlark type", val)
 	}
 }
+
+// starlarkToGoValue converts a starlark value to its Go value. Lists and tuples are converted
+// to []interface{}; dicts, with string keys, and structs to map[string]interface{}.
+func starlarkToGoValue(val starlark.Value) (interface{}, error) {
+	switch v := val.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.Int:
+		i, ok := v.Int64()
+		if !ok {
+			return nil, fmt.Errorf("integer %s out of range", v)
+		}
+		return i, nil
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.String:
+		return string(v), nil
+	case timeValue:
+		return time.Time(v), nil
+	case durationValue:
+		return time.Duration(v), nil
+	case starlark.Indexable: // list, tuple
+		var list []interface{}
+		for i := 0; i < v.Len(); i++ {
+			elem, err := starlarkToGoValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, elem)
+		}
+		return list, nil
+	case *starlark.Dict:
+		dict := make(map[string]interface{})
+		for _, item := range v.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("dict key %s is not a string", item[0])
+			}
+			elem, err := starlarkToGoValue(item[1])
+			if err != nil {
+				return nil, err
+			}
+			dict[string(key)] = elem
+		}
+		return dict, nil
+	case *starlarkstruct.Struct:
+		dict := make(map[string]interface{})
+		for _, name := range v.AttrNames() {
+			attr, err := v.Attr(name)
+			if err != nil {
+				return nil, err
+			}
+			elem, err := starlarkToGoValue(attr)
+			if err != nil {
+				return nil, err
+			}
+			dict[name] = elem
+		}
+		return dict, nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %s", val.Type())
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+// gpuCaptureFunc is a built-in starlark function that collects nvidia-smi output, driver versions,
+// and XID errors from GPU nodes along with the logs of the GPU device plugin pods.
+// If resources are not provided, GPU nodes are selected from the cluster using node labels.
+// Starlark format: gpu_capture([resources=resources][, labels=["nvidia.com/gpu.present=true"]]
+// [, device_plugin_labels=["app=nvidia-device-plugin-daemonset"]][, workdir=path][, kube_config=kube_config()][, ssh_config=ssh_config()|"<name>"])
+func gpuCaptureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir string
+	var resources, labels, pluginLabels *starlark.List
+	var kubeConfig *starlarkstruct.Struct
+	var sshConfigVal starlark.Value
+
+	if err := starlark.UnpackArgs(
+		identifiers.gpuCapture, args, kwargs,
+		"resources?", &resources,
+		"labels?", &labels,
+		"device_plugin_labels?", &pluginLabels,
+		"workdir?", &workdir,
+		"kube_config?", &kubeConfig,
+		"ssh_config?", &sshConfigVal,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.gpuCapture, err)
+	}
+
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+	gpuDir := filepath.Join(workdir, "gpu")
+
+	if kubeConfig == nil {
+		kubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
+	}
+	kubeConfigPath, err := getKubeConfigFromStruct(kubeConfig)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "failed to kubeconfig")
+	}
+
+	if resources == nil {
+		sshConfig, err := getSSHConfig(thread, sshConfigVal)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.gpuCapture, err)
+		}
+		nodeLabels := toSlice(labels)
+		if len(nodeLabels) == 0 {
+			nodeLabels = defaults.gpuNodeLabels
+		}
+		provider, err := newKubeNodesProvider(kubeConfigPath, sshConfig, nil, nodeLabels)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.gpuCapture, err)
+		}
+		res, err := enum(provider)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.gpuCapture, err)
+		}
+		resources = res
+	}
+
+	results, err := execCaptureCommands(gpuDir, gpuCaptureCommands(), resources)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.gpuCapture, err)
+	}
+
+	selectors := toSlice(pluginLabels)
+	if len(selectors) == 0 {
+		selectors = defaults.gpuDevicePluginLabels
+	}
+	logsDir, logsErr := writePodLogsForSelectors(kubeConfigPath, gpuDir, selectors)
+	if logsErr != nil {
+		logrus.Errorf("%s: device plugin logs: %s", identifiers.gpuCapture, logsErr)
+	}
+
+	return starlarkstruct.FromStringDict(
+		starlark.String(identifiers.gpuCapture),
+		starlark.StringDict{
+			"results": commandResultsToList(results),
+			"logs":    starlark.String(logsDir),
+			"error": func() starlark.String {
+				if logsErr != nil {
+					return starlark.String(logsErr.Error())
+				}
+				return ""
+			}(),
+		}), nil
+}
+
+func gpuCaptureCommands() []captureCommand {
+	return []captureCommand{
+		{cmd: "nvidia-smi", fileName: "nvidia-smi.txt"},
+		{cmd: "nvidia-smi -q", fileName: "nvidia-smi-query.txt"},
+		{
+			cmd:      "nvidia-smi --query-gpu=index,name,uuid,driver_version,vbios_version,pci.bus_id --format=csv",
+			fileName: "gpu-inventory.csv",
+		},
+		{cmd: "cat /proc/driver/nvidia/version", fileName: "driver-version.txt"},
+		{cmd: "sudo dmesg -T | grep -i 'NVRM: Xid' || true", fileName: "xid-errors.txt", desc: "NVIDIA XID errors (dmesg)"},
+	}
+}
+
+// writePodLogsForSelectors saves the logs of pods, in all namespaces, that match any of
+// the label selectors. It returns the directory where the logs are saved.
+func writePodLogsForSelectors(kubeConfigPath, workdir string, selectors []string) (string, error) {
+	client, err := k8s.New(kubeConfigPath)
+	if err != nil {
+		return "", errors.Wrap(err, "could not initialize search client")
+	}
+
+	resultWriter, err := k8s.NewResultWriter(workdir, "logs", "", client.CoreRest)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to initialize writer")
+	}
+
+	for _, selector := range selectors {
+		searchResults, err := client.Search(k8s.SearchParams{
+			Groups: []string{"core"},
+			Kinds:  []string{"pods"},
+			Labels: []string{selector},
+		})
+		if err != nil {
+			return "", err
+		}
+
+		var found []k8s.SearchResult
+		for _, result := range searchResults {
+			if result.List != nil && len(result.List.Items) > 0 {
+				found = append(found, result)
+			}
+		}
+		if len(found) == 0 {
+			logrus.Debugf("no pods found for selector %s", selector)
+			continue
+		}
+
+		if err := resultWriter.Write(found); err != nil {
+			return "", errors.Wrap(err, "failed to write search results")
+		}
+	}
+
+	return resultWriter.GetResultDir(), nil
+}
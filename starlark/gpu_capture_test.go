@@ -0,0 +1,57 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("gpu_capture", func() {
+
+	var (
+		executor *Executor
+		err      error
+	)
+
+	execSetup := func(crashdScript string) {
+		executor = New()
+		err = executor.Exec("test.gpu.capture", strings.NewReader(crashdScript))
+	}
+
+	It("returns no host results when no GPU nodes are labeled", func() {
+		crashdScript := fmt.Sprintf(`
+crashd_config(workdir="%s")
+set_defaults(kube_config(path="%s"))
+set_defaults(ssh_config(username="uname"))
+gpu_data = gpu_capture(labels=["crashd.test/gpu=none"])
+		`, workdir, k8sconfig)
+		execSetup(crashdScript)
+		Expect(err).NotTo(HaveOccurred())
+
+		data := executor.result["gpu_data"]
+		Expect(data).NotTo(BeNil())
+
+		dataStruct, ok := data.(*starlarkstruct.Struct)
+		Expect(ok).To(BeTrue())
+
+		resultsVal, err := dataStruct.Attr("results")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resultsVal.(*starlark.List).Len()).To(Equal(0))
+
+		errVal, err := dataStruct.Attr("error")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(errVal.(starlark.String).GoString()).To(BeEmpty())
+
+		logsVal, err := dataStruct.Attr("logs")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(logsVal.(starlark.String).GoString()).To(BeADirectory())
+	})
+})
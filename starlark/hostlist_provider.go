@@ -11,15 +11,15 @@ import (
 )
 
 // hostListProvider is a built-in starlark function that collects compute resources as a list of host IPs
-// Starlark format: host_list_provider(hosts=<host-list> [, ssh_config=ssh_config()])
+// Starlark format: host_list_provider(hosts=<host-list> [, ssh_config=ssh_config()|"<name>"])
 func hostListProvider(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var hosts *starlark.List
-	var sshCfg *starlarkstruct.Struct
+	var sshCfgVal starlark.Value
 
 	if err := starlark.UnpackArgs(
 		identifiers.crashdCfg, args, kwargs,
 		"hosts", &hosts,
-		"ssh_config?", &sshCfg,
+		"ssh_config?", &sshCfgVal,
 	); err != nil {
 		return starlark.None, fmt.Errorf("%s: %s", identifiers.hostListProvider, err)
 	}
@@ -28,13 +28,9 @@ func hostListProvider(thread *starlark.Thread, b *starlark.Builtin, args starlar
 		return starlark.None, fmt.Errorf("%s: missing argument: hosts", identifiers.hostListProvider)
 	}
 
-	if sshCfg == nil {
-		data := thread.Local(identifiers.sshCfg)
-		cfg, ok := data.(*starlarkstruct.Struct)
-		if !ok {
-			return nil, fmt.Errorf("%s: default ssh_config not found", identifiers.hostListProvider)
-		}
-		sshCfg = cfg
+	sshCfg, err := getSSHConfig(thread, sshCfgVal)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", identifiers.hostListProvider, err)
 	}
 
 	cfgStruct := starlark.StringDict{
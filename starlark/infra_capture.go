@@ -0,0 +1,77 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// infraCapture collects the files of one resource (a VM or an instance) for the built-ins capturing the
+// state of the infrastructure under the nodes (vsphere_capture, ec2_capture, azure_capture). The errors
+// are recorded in the result of the resource instead of stopping the capture of the others, and the
+// files collected before an error are kept.
+type infraCapture struct {
+	builtin  string
+	kind     string
+	resource string
+	dir      string
+	errs     []string
+}
+
+// newInfraCapture returns the capture of the resource of kind (i.e. vm) saving its files in dir. It returns
+// false when dir cannot be created, the error being recorded.
+func newInfraCapture(builtin, kind, resource, dir string) (*infraCapture, bool) {
+	c := &infraCapture{builtin: builtin, kind: kind, resource: resource, dir: dir}
+	if err := os.MkdirAll(dir, 0744); err != nil && !os.IsExist(err) {
+		c.fail("workdir", err)
+		return c, false
+	}
+	return c, true
+}
+
+// fail records the error of the collection of what
+func (c *infraCapture) fail(what string, err error) {
+	logrus.Errorf("%s: %s %s: %s: %s", c.builtin, c.kind, c.resource, what, err)
+	c.errs = append(c.errs, fmt.Sprintf("%s: %s", what, err))
+}
+
+// path returns the path of the file in the directory of the resource
+func (c *infraCapture) path(file string) string {
+	return filepath.Join(c.dir, file)
+}
+
+// save saves the output of get in file. It returns the output, and false when it could not be retrieved.
+func (c *infraCapture) save(what, file string, get func() (string, error)) (string, bool) {
+	output, err := get()
+	if err != nil {
+		c.fail(what, err)
+		return "", false
+	}
+	if err := ioutil.WriteFile(c.path(file), []byte(output), 0644); err != nil {
+		c.fail(what, err)
+	}
+	return output, true
+}
+
+// result returns the result of the capture: the fields of the resource, with its name (resource), its
+// directory (file), and the errors encountered (err)
+func (c *infraCapture) result(constructor string, fields starlark.StringDict) *starlarkstruct.Struct {
+	dict := starlark.StringDict{
+		"resource": starlark.String(c.resource),
+		"file":     starlark.String(c.dir),
+		"err":      starlark.String(strings.Join(c.errs, "; ")),
+	}
+	for name, value := range fields {
+		dict[name] = value
+	}
+	return starlarkstruct.FromStringDict(starlark.String(constructor), dict)
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestInfraCapture(t *testing.T) {
+	workdir, err := ioutil.TempDir("", "crashd-infra-capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+	dir := filepath.Join(workdir, "ec2", "i-0123")
+
+	c, ok := newInfraCapture(identifiers.ec2Capture, "instance", "i-0123", dir)
+	if !ok {
+		t.Fatalf("unexpected error: %v", c.errs)
+	}
+	if output, ok := c.save("status", "status.json", func() (string, error) { return `{"state":"running"}`, nil }); !ok || output != `{"state":"running"}` {
+		t.Errorf("unexpected output %q (%t)", output, ok)
+	}
+	// the failure of a file does not stop the capture
+	if _, ok := c.save("console", "console.txt", func() (string, error) { return "", errors.New("throttled") }); ok {
+		t.Error("expecting the console output to fail")
+	}
+	c.save("cloudtrail", "cloudtrail.json", func() (string, error) { return "[]", nil })
+
+	for file, expected := range map[string]bool{"status.json": true, "console.txt": false, "cloudtrail.json": true} {
+		if _, err := os.Stat(filepath.Join(dir, file)); (err == nil) != expected {
+			t.Errorf("%s: expecting file saved %t, got %v", file, expected, err)
+		}
+	}
+
+	result := c.result("ec2_instance", starlark.StringDict{"state": starlark.String("running")})
+	for field, expected := range map[string]string{"resource": "i-0123", "state": "running", "file": dir, "err": "console: throttled"} {
+		if value := structString(result, field); value != expected {
+			t.Errorf("%s: expecting %q, got %q", field, expected, value)
+		}
+	}
+
+	// the resource is not collected when its directory cannot be created
+	if c, ok := newInfraCapture(identifiers.ec2Capture, "instance", "i-4567", filepath.Join(dir, "status.json", "i-4567")); ok || len(c.errs) != 1 {
+		t.Errorf("expecting the directory to fail, got %v", c.errs)
+	}
+}
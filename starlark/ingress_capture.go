@@ -0,0 +1,543 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+const (
+	// ingressReportFile is the report of the load balancer services saved by ingress_capture
+	ingressReportFile = "report.txt"
+	// ingressFlavorAuto detects the ingress controllers running in the cluster
+	ingressFlavorAuto = "auto"
+)
+
+// ingressFlavor describes how the pods of an ingress controller are found, and how its configuration is dumped:
+// with a command run in the pod, or with a GET of an admin endpoint of the pod, through a port forward
+type ingressFlavor struct {
+	name string
+	// labels are alternative selectors of the controller pods (i.e. for the different installation methods)
+	labels []string
+	// configLabels select the pods the configuration is dumped from, the controller pods when empty
+	configLabels []string
+	// groups and kinds are the API groups and kinds of the custom resources of the controller
+	groups     []string
+	kinds      []string
+	configExec []string
+	configPort int
+	configPath string
+}
+
+// ingressFlavors are the supported ingress controllers, in the order they are detected
+var ingressFlavors = []ingressFlavor{
+	{
+		name:       "nginx",
+		labels:     []string{"app.kubernetes.io/name=ingress-nginx", "app=nginx-ingress"},
+		configExec: []string{"nginx", "-T"},
+	},
+	{
+		name:         "contour",
+		labels:       []string{"app=contour", "app=envoy", "app.kubernetes.io/name=contour"},
+		configLabels: []string{"app=envoy", "app.kubernetes.io/component=envoy"},
+		groups:       []string{"projectcontour.io"},
+		kinds:        []string{"httpproxies", "tlscertificatedelegations", "contourconfigurations"},
+		configPort:   9001,
+		configPath:   "/config_dump",
+	},
+	{
+		name:   "traefik",
+		labels: []string{"app.kubernetes.io/name=traefik", "app=traefik"},
+		groups: []string{"traefik.io", "traefik.containo.us"},
+		kinds: []string{
+			"ingressroutes", "ingressroutetcps", "ingressrouteudps", "middlewares", "middlewaretcps",
+			"tlsoptions", "tlsstores", "serverstransports", "traefikservices",
+		},
+		configPort: 9000,
+		configPath: "/api/rawdata",
+	},
+}
+
+func getIngressFlavor(name string) (ingressFlavor, bool) {
+	for _, flavor := range ingressFlavors {
+		if flavor.name == name {
+			return flavor, true
+		}
+	}
+	return ingressFlavor{}, false
+}
+
+// ingressController is a running controller pod found by the labels of a flavor
+type ingressController struct {
+	flavor string
+	label  string
+	pod    corev1.Pod
+}
+
+// ingressService is a load balancer (or node port) service of the namespace of an ingress controller
+type ingressService struct {
+	namespace string
+	name      string
+	svcType   string
+	external  string
+	ports     string
+	ready     int
+	notReady  int
+}
+
+func (s ingressService) toStarlarkStruct() *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(
+		starlark.String("ingress_service"),
+		starlark.StringDict{
+			"namespace": starlark.String(s.namespace),
+			"name":      starlark.String(s.name),
+			"type":      starlark.String(s.svcType),
+			"external":  starlark.String(s.external),
+			"ports":     starlark.String(s.ports),
+			"ready":     starlark.MakeInt(s.ready),
+			"not_ready": starlark.MakeInt(s.notReady),
+		})
+}
+
+// ingressCaptureFunc is a built-in starlark function that collects the state of the ingress controllers of the
+// cluster in one call: the Ingress, IngressClass, and Gateway API objects, and the custom resources of the
+// controller, the configuration dump of the controller (nginx -T for nginx, the Envoy config_dump for contour,
+// and the raw data of the API for traefik), the logs of the controller pods, and the load balancer Services,
+// and their Endpoints, of the controller namespaces. With flavor "auto", every supported controller found
+// running is captured. Files are saved under <workdir>/ingress.
+// Starlark format: ingress_capture([flavor="auto"][, timeout="30s"][, workdir=path][, kube_config=kube_config()])
+func ingressCaptureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir, flavor string
+	var timeoutVal starlark.Value
+	var kubeConfig *starlarkstruct.Struct
+
+	if err := starlark.UnpackArgs(
+		identifiers.ingressCapture, args, kwargs,
+		"flavor?", &flavor,
+		"timeout?", &timeoutVal,
+		"workdir?", &workdir,
+		"kube_config?", &kubeConfig,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.ingressCapture, err)
+	}
+
+	if len(flavor) == 0 {
+		flavor = ingressFlavorAuto
+	}
+	flavors := ingressFlavors
+	if flavor != ingressFlavorAuto {
+		f, ok := getIngressFlavor(flavor)
+		if !ok {
+			return starlark.None, fmt.Errorf("%s: unsupported flavor %q (expecting nginx, contour, traefik, or auto)", identifiers.ingressCapture, flavor)
+		}
+		flavors = []ingressFlavor{f}
+	}
+	timeout, err := toDuration(timeoutVal, defaults.ingressTimeout)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: timeout: %s", identifiers.ingressCapture, err)
+	}
+
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+
+	if kubeConfig == nil {
+		kubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
+	}
+	path, err := getKubeConfigFromStruct(kubeConfig)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "failed to kubeconfig")
+	}
+	client, err := k8s.New(path)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "could not initialize search client")
+	}
+
+	resultDir := filepath.Join(workdir, "ingress")
+	var captureErrs []string
+
+	// controllers
+	var controllers []ingressController
+	var found []ingressFlavor
+	for _, f := range flavors {
+		fc, err := findIngressControllers(client, f, f.labels)
+		if err != nil {
+			captureErrs = append(captureErrs, fmt.Sprintf("%s: %s", f.name, err))
+			continue
+		}
+		if len(fc) > 0 {
+			controllers = append(controllers, fc...)
+			found = append(found, f)
+		}
+	}
+	if len(found) == 0 {
+		captureErrs = append(captureErrs, fmt.Sprintf("no %s ingress controller found", flavor))
+	}
+
+	// objects
+	groups := append([]string{}, defaults.ingressGroups...)
+	kinds := append([]string{}, defaults.ingressKinds...)
+	for _, f := range found {
+		groups = append(groups, f.groups...)
+		kinds = append(kinds, f.kinds...)
+	}
+	objects, err := client.Search(k8s.SearchParams{Groups: groups, Kinds: kinds})
+	if err != nil {
+		captureErrs = append(captureErrs, fmt.Sprintf("objects: %s", err))
+	}
+
+	// load balancer services of the controller namespaces
+	namespaces := ingressNamespaces(controllers)
+	var services []ingressService
+	if len(namespaces) > 0 {
+		svcResults, err := client.Search(k8s.SearchParams{
+			Groups:     []string{"core"},
+			Kinds:      []string{"services", "endpoints"},
+			Namespaces: namespaces,
+		})
+		if err != nil {
+			captureErrs = append(captureErrs, fmt.Sprintf("services: %s", err))
+		}
+		objects = append(objects, svcResults...)
+		services = ingressServices(svcResults)
+	}
+	if len(objects) > 0 {
+		resultWriter, err := k8s.NewResultWriter(resultDir, "objects", getOutputFormat(thread), client.CoreRest)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.ingressCapture, err)
+		}
+		if err := resultWriter.Write(objects); err != nil {
+			captureErrs = append(captureErrs, fmt.Sprintf("objects: %s", err))
+		}
+	}
+
+	// logs of the controller pods
+	logged := make(map[string]bool)
+	for _, c := range controllers {
+		key := c.pod.Namespace + " " + c.label
+		if logged[key] {
+			continue
+		}
+		logged[key] = true
+		if _, _, err := write(resultDir, "logs", "", k8s.LayoutKubeCapture, client, nil, 0, k8s.SearchParams{
+			Namespaces: []string{c.pod.Namespace},
+			Labels:     []string{c.label},
+		}); err != nil {
+			captureErrs = append(captureErrs, fmt.Sprintf("logs (%s): %s", c.label, err))
+		}
+	}
+
+	// configuration dumps
+	var configs []starlark.Value
+	for _, f := range found {
+		files, errs := dumpIngressConfig(client, f, controllers, filepath.Join(resultDir, "config", f.name), timeout)
+		for _, file := range files {
+			configs = append(configs, starlark.String(file))
+		}
+		captureErrs = append(captureErrs, errs...)
+	}
+
+	reportFile := filepath.Join(resultDir, ingressReportFile)
+	if err := writeIngressReport(reportFile, controllers, services); err != nil {
+		captureErrs = append(captureErrs, fmt.Sprintf("report: %s", err))
+	}
+	for _, s := range services {
+		if s.svcType == string(corev1.ServiceTypeLoadBalancer) && len(s.external) == 0 {
+			logrus.Warnf("%s: load balancer %s/%s has no external address", identifiers.ingressCapture, s.namespace, s.name)
+		}
+		if s.ready == 0 {
+			logrus.Warnf("%s: service %s/%s has no ready endpoint", identifiers.ingressCapture, s.namespace, s.name)
+		}
+	}
+
+	var flavorVals, controllerVals, serviceVals []starlark.Value
+	for _, f := range found {
+		flavorVals = append(flavorVals, starlark.String(f.name))
+	}
+	for _, c := range controllers {
+		controllerVals = append(controllerVals, starlark.String(fmt.Sprintf("%s/%s", c.pod.Namespace, c.pod.Name)))
+	}
+	for _, s := range services {
+		serviceVals = append(serviceVals, s.toStarlarkStruct())
+	}
+	var captureErr string
+	if len(captureErrs) > 0 {
+		captureErr = strings.Join(captureErrs, "; ")
+		logrus.Errorf("%s: %s", identifiers.ingressCapture, captureErr)
+	}
+
+	return starlarkstruct.FromStringDict(
+		starlark.String(identifiers.ingressCapture),
+		starlark.StringDict{
+			"file":        starlark.String(resultDir),
+			"report":      starlark.String(reportFile),
+			"flavors":     starlark.NewList(flavorVals),
+			"controllers": starlark.NewList(controllerVals),
+			"configs":     starlark.NewList(configs),
+			"services":    starlark.NewList(serviceVals),
+			"error":       starlark.String(captureErr),
+		}), nil
+}
+
+// findIngressControllers returns the running pods, in all namespaces, matching any of the labels of the flavor
+func findIngressControllers(client *k8s.Client, flavor ingressFlavor, labels []string) ([]ingressController, error) {
+	var controllers []ingressController
+	seen := make(map[string]bool)
+	for _, label := range labels {
+		pods, err := client.RunningPods("", []string{label})
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range pods {
+			key := pod.Namespace + "/" + pod.Name
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			controllers = append(controllers, ingressController{flavor: flavor.name, label: label, pod: pod})
+		}
+	}
+	return controllers, nil
+}
+
+// ingressNamespaces returns the sorted namespaces of the controllers
+func ingressNamespaces(controllers []ingressController) []string {
+	seen := make(map[string]bool)
+	var namespaces []string
+	for _, c := range controllers {
+		if !seen[c.pod.Namespace] {
+			seen[c.pod.Namespace] = true
+			namespaces = append(namespaces, c.pod.Namespace)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// dumpIngressConfig saves the configuration of the controller under dir, from one pod per namespace (the
+// replicas of a controller share the same configuration), and returns the files saved and the errors
+func dumpIngressConfig(client *k8s.Client, flavor ingressFlavor, controllers []ingressController, dir string, timeout time.Duration) ([]string, []string) {
+	var pods []corev1.Pod
+	if len(flavor.configLabels) > 0 {
+		configControllers, err := findIngressControllers(client, flavor, flavor.configLabels)
+		if err != nil {
+			return nil, []string{fmt.Sprintf("%s config: %s", flavor.name, err)}
+		}
+		for _, c := range configControllers {
+			pods = append(pods, c.pod)
+		}
+	} else {
+		for _, c := range controllers {
+			if c.flavor == flavor.name {
+				pods = append(pods, c.pod)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0744); err != nil && !os.IsExist(err) {
+		return nil, []string{err.Error()}
+	}
+	var files, errs []string
+	dumped := make(map[string]bool)
+	for _, pod := range pods {
+		if dumped[pod.Namespace] {
+			continue
+		}
+		dumped[pod.Namespace] = true
+
+		filePath := filepath.Join(dir, fmt.Sprintf("%s_%s.txt", pod.Namespace, pod.Name))
+		var config bytes.Buffer
+		var err error
+		if len(flavor.configExec) > 0 {
+			err = execIngressConfig(client, pod, flavor.configExec, &config)
+		} else {
+			err = getIngressConfig(client, pod, flavor.configPort, flavor.configPath, timeout, &config)
+		}
+		desc := ""
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s config of %s/%s: %s", flavor.name, pod.Namespace, pod.Name, err))
+			config.Reset()
+			config.WriteString(err.Error())
+			desc = fmt.Sprintf("%s config: failed", flavor.name)
+		}
+		if err := captureOutput(&config, filePath, desc); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		files = append(files, filePath)
+	}
+	return files, errs
+}
+
+// execIngressConfig writes the output of the configuration command run in the pod
+func execIngressConfig(client *k8s.Client, pod corev1.Pod, command []string, config io.Writer) error {
+	if err := guardCommand(identifiers.ingressCapture, joinWords(command)); err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	exitCode, err := client.ExecPod(pod.Namespace, pod.Name, "", command, config, &stderr)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("%s: exit status %d: %s", strings.Join(command, " "), exitCode, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// getIngressConfig writes the response of the admin endpoint of the pod, reached through a port forward
+func getIngressConfig(client *k8s.Client, pod corev1.Pod, port int, path string, timeout time.Duration, config io.Writer) error {
+	bodies, err := getPodPaths(client, pod.Namespace, pod.Name, port, []string{path}, timeout)
+	if err != nil {
+		return err
+	}
+	_, err = config.Write(bodies[0])
+	return err
+}
+
+// ingressServices returns the LoadBalancer and NodePort services of the search results, with the number of ready
+// and not ready addresses of their endpoints, sorted by namespace and name
+func ingressServices(results []k8s.SearchResult) []ingressService {
+	endpoints := make(map[string]unstructured.Unstructured)
+	for _, result := range results {
+		if result.List == nil || result.ResourceKind != "Endpoints" {
+			continue
+		}
+		for _, item := range result.List.Items {
+			endpoints[item.GetNamespace()+"/"+item.GetName()] = item
+		}
+	}
+
+	var services []ingressService
+	for _, result := range results {
+		if result.List == nil || result.ResourceKind != "Service" {
+			continue
+		}
+		for _, item := range result.List.Items {
+			svcType, _, _ := unstructured.NestedString(item.Object, "spec", "type")
+			if svcType != string(corev1.ServiceTypeLoadBalancer) && svcType != string(corev1.ServiceTypeNodePort) {
+				continue
+			}
+			s := ingressService{namespace: item.GetNamespace(), name: item.GetName(), svcType: svcType}
+
+			lbIngress, _, _ := unstructured.NestedSlice(item.Object, "status", "loadBalancer", "ingress")
+			var external []string
+			for _, ing := range lbIngress {
+				if m, ok := ing.(map[string]interface{}); ok {
+					if ip, ok := m["ip"].(string); ok && len(ip) > 0 {
+						external = append(external, ip)
+					} else if host, ok := m["hostname"].(string); ok && len(host) > 0 {
+						external = append(external, host)
+					}
+				}
+			}
+			s.external = strings.Join(external, ",")
+
+			svcPorts, _, _ := unstructured.NestedSlice(item.Object, "spec", "ports")
+			var ports []string
+			for _, p := range svcPorts {
+				if m, ok := p.(map[string]interface{}); ok {
+					port, _, _ := unstructured.NestedInt64(m, "port")
+					nodePort, _, _ := unstructured.NestedInt64(m, "nodePort")
+					protocol, _, _ := unstructured.NestedString(m, "protocol")
+					if len(protocol) == 0 {
+						protocol = "TCP"
+					}
+					if nodePort > 0 {
+						ports = append(ports, fmt.Sprintf("%d:%d/%s", port, nodePort, protocol))
+					} else {
+						ports = append(ports, fmt.Sprintf("%d/%s", port, protocol))
+					}
+				}
+			}
+			s.ports = strings.Join(ports, ",")
+
+			if ep, ok := endpoints[s.namespace+"/"+s.name]; ok {
+				subsets, _, _ := unstructured.NestedSlice(ep.Object, "subsets")
+				for _, subset := range subsets {
+					if m, ok := subset.(map[string]interface{}); ok {
+						ready, _, _ := unstructured.NestedSlice(m, "addresses")
+						notReady, _, _ := unstructured.NestedSlice(m, "notReadyAddresses")
+						s.ready += len(ready)
+						s.notReady += len(notReady)
+					}
+				}
+			}
+			services = append(services, s)
+		}
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].namespace != services[j].namespace {
+			return services[i].namespace < services[j].namespace
+		}
+		return services[i].name < services[j].name
+	})
+	return services
+}
+
+// writeIngressReport saves the report of the controllers and services at path
+func writeIngressReport(path string, controllers []ingressController, services []ingressService) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0744); err != nil && !os.IsExist(err) {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return printIngressReport(file, controllers, services)
+}
+
+func printIngressReport(w io.Writer, controllers []ingressController, services []ingressService) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "INGRESS CONTROLLERS")
+	fmt.Fprintln(tw, "FLAVOR\tNAMESPACE\tPOD\tNODE\tRESTARTS")
+	for _, c := range controllers {
+		var restarts int32
+		for _, status := range c.pod.Status.ContainerStatuses {
+			restarts += status.RestartCount
+		}
+		node := c.pod.Spec.NodeName
+		if len(node) == 0 {
+			node = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\n", c.flavor, c.pod.Namespace, c.pod.Name, node, restarts)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "SERVICES")
+	fmt.Fprintln(tw, "NAMESPACE\tNAME\tTYPE\tEXTERNAL\tPORTS\tREADY\tNOT READY")
+	for _, s := range services {
+		external := s.external
+		if len(external) == 0 {
+			external = "-"
+			if s.svcType == string(corev1.ServiceTypeLoadBalancer) {
+				external = "<pending>"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%d\n", s.namespace, s.name, s.svcType, external, s.ports, s.ready, s.notReady)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+func TestIngressServices(t *testing.T) {
+	object := func(name string, fields map[string]interface{}) unstructured.Unstructured {
+		obj := unstructured.Unstructured{Object: fields}
+		obj.SetNamespace("ingress-nginx")
+		obj.SetName(name)
+		return obj
+	}
+	results := []k8s.SearchResult{
+		{
+			ResourceKind: "Service",
+			List: &unstructured.UnstructuredList{Items: []unstructured.Unstructured{
+				object("ingress-nginx-controller", map[string]interface{}{
+					"spec": map[string]interface{}{
+						"type": "LoadBalancer",
+						"ports": []interface{}{
+							map[string]interface{}{"port": int64(80), "nodePort": int64(31080), "protocol": "TCP"},
+							map[string]interface{}{"port": int64(443), "nodePort": int64(31443)},
+						},
+					},
+					"status": map[string]interface{}{"loadBalancer": map[string]interface{}{}},
+				}),
+				object("ingress-nginx-controller-admission", map[string]interface{}{
+					"spec": map[string]interface{}{"type": "ClusterIP"},
+				}),
+				object("edge", map[string]interface{}{
+					"spec": map[string]interface{}{"type": "LoadBalancer"},
+					"status": map[string]interface{}{"loadBalancer": map[string]interface{}{
+						"ingress": []interface{}{map[string]interface{}{"hostname": "lb.example.com"}},
+					}},
+				}),
+			}},
+		},
+		{
+			ResourceKind: "Endpoints",
+			List: &unstructured.UnstructuredList{Items: []unstructured.Unstructured{
+				object("ingress-nginx-controller", map[string]interface{}{
+					"subsets": []interface{}{map[string]interface{}{
+						"addresses":         []interface{}{map[string]interface{}{"ip": "10.0.0.1"}, map[string]interface{}{"ip": "10.0.0.2"}},
+						"notReadyAddresses": []interface{}{map[string]interface{}{"ip": "10.0.0.3"}},
+					}},
+				}),
+			}},
+		},
+	}
+
+	services := ingressServices(results)
+	if len(services) != 2 {
+		t.Fatalf("expecting 2 services, got %d", len(services))
+	}
+	if s := services[0]; s.name != "edge" || s.external != "lb.example.com" || s.ready != 0 {
+		t.Errorf("unexpected service: %+v", s)
+	}
+	if s := services[1]; s.external != "" || s.ports != "80:31080/TCP,443:31443/TCP" || s.ready != 2 || s.notReady != 1 {
+		t.Errorf("unexpected service: %+v", s)
+	}
+
+	controllers := []ingressController{{
+		flavor: "nginx",
+		pod: corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ingress-nginx", Name: "ingress-nginx-controller-7d9f"},
+			Spec:       corev1.PodSpec{NodeName: "worker-1"},
+			Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{RestartCount: 3}}},
+		},
+	}}
+	var out bytes.Buffer
+	if err := printIngressReport(&out, controllers, services); err != nil {
+		t.Fatal(err)
+	}
+	report := out.String()
+	for _, expected := range []string{"ingress-nginx-controller-7d9f  worker-1  3", "<pending>", "lb.example.com"} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("expecting %q in report:\n%s", expected, report)
+		}
+	}
+}
+
+func TestIngressCaptureFlavor(t *testing.T) {
+	for _, flavor := range []string{"nginx", "contour", "traefik"} {
+		if _, ok := getIngressFlavor(flavor); !ok {
+			t.Errorf("expecting flavor %s", flavor)
+		}
+	}
+	err := New().Exec("test.star", strings.NewReader(`ingress_capture(flavor="haproxy")`))
+	if err == nil || !strings.Contains(err.Error(), "unsupported flavor") {
+		t.Errorf("expecting unsupported flavor error, got %v", err)
+	}
+}
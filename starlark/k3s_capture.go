@@ -0,0 +1,153 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// Distributions supported by k3s_capture
+const (
+	distroK3s  = "k3s"
+	distroRKE2 = "rke2"
+)
+
+// k3sCaptureFunc is a built-in starlark function that collects the diagnostics of k3s and RKE2
+// nodes from their own layout: the journald logs of the server and agent units, the configuration
+// and registries files (tokens and passwords redacted), the embedded etcd (or sqlite) datastore
+// files, the containerd log, the auto-deploy manifests, and the containers and pods listed by the
+// bundled crictl. For RKE2, the kubelet log and the static pod manifests are also collected.
+// Starlark format: k3s_capture([distro="k3s|rke2"][, since="24h"][, resources=resources][, workdir=path])
+func k3sCaptureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir, distro, since string
+	var resources *starlark.List
+
+	if err := starlark.UnpackArgs(
+		identifiers.k3sCapture, args, kwargs,
+		"distro?", &distro,
+		"since?", &since,
+		"resources?", &resources,
+		"workdir?", &workdir,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.k3sCapture, err)
+	}
+
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+	if len(distro) == 0 {
+		distro = distroK3s
+	}
+	if len(since) == 0 {
+		since = defaults.logSince
+	}
+
+	cmds, err := k3sCaptureCommands(distro, since, defaults.tailLines)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.k3sCapture, err)
+	}
+
+	if resources == nil {
+		res, err := getResourcesFromThread(thread)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.k3sCapture, err)
+		}
+		resources = res
+	}
+
+	results, err := execCaptureCommands(filepath.Join(workdir, distro), cmds, resources)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.k3sCapture, err)
+	}
+
+	return commandResultsToList(results), nil
+}
+
+// k3sCaptureCommands returns the commands collecting the diagnostics of a k3s or RKE2 node.
+// Log files are limited to their last tailLines lines.
+func k3sCaptureCommands(distro, since string, tailLines int) ([]captureCommand, error) {
+	var units []string
+	var crictl string
+	switch distro {
+	case distroK3s:
+		units = []string{"k3s", "k3s-agent"}
+		crictl = "sudo k3s crictl"
+	case distroRKE2:
+		units = []string{"rke2-server", "rke2-agent"}
+		crictl = "sudo /var/lib/rancher/rke2/bin/crictl --runtime-endpoint unix:///run/k3s/containerd/containerd.sock"
+	default:
+		return nil, fmt.Errorf("unsupported distro %q (supported: %s, %s)", distro, distroK3s, distroRKE2)
+	}
+	if _, err := time.ParseDuration(since); err != nil {
+		return nil, fmt.Errorf("invalid since %q: %s", since, err)
+	}
+
+	dataDir := "/var/lib/rancher/" + distro
+	configDir := "/etc/rancher/" + distro
+	redact := `sudo sed -E 's/^([[:space:]]*-?[[:space:]]*(token|agent-token|password|auth|identitytoken):).*/\1 <redacted>/' \$f`
+
+	cmds := []captureCommand{
+		{cmd: fmt.Sprintf("%s --version", distro), fileName: "version.txt"},
+		{
+			cmd:      fmt.Sprintf("sudo journalctl -u %s -u %s --no-pager --since=-%s", units[0], units[1], since),
+			fileName: fmt.Sprintf("%s.log", distro),
+			desc:     fmt.Sprintf("%s and %s logs (journald)", units[0], units[1]),
+		},
+		{
+			cmd:      fmt.Sprintf("sudo systemctl cat %s %s 2>/dev/null | sed -E 's/(--(agent-)?token[= ])[^ ]+/\\1<redacted>/g'", units[0], units[1]),
+			fileName: "units.txt",
+			desc:     "Service units (tokens redacted)",
+		},
+		{
+			cmd:      forEachFileCmd(fmt.Sprintf("%s/config.yaml %s/config.yaml.d/* %s/registries.yaml", configDir, configDir, configDir), redact),
+			fileName: "config.yaml",
+			desc:     fmt.Sprintf("%s configuration files (tokens and passwords redacted)", configDir),
+		},
+		{
+			cmd:      fmt.Sprintf("sudo ls -laR %s/server/db", dataDir),
+			fileName: "db-files.txt",
+			desc:     "Datastore files (embedded etcd or sqlite)",
+		},
+		{
+			cmd:      forEachFileCmd(dataDir+"/server/db/etcd/config", `sudo cat \$f`),
+			fileName: "etcd-config.yaml",
+			desc:     "Embedded etcd configuration",
+		},
+		{
+			cmd:      fmt.Sprintf("sudo ls -la %s/server/manifests", dataDir),
+			fileName: "server-manifests.txt",
+			desc:     "Auto-deploy manifests",
+		},
+		{
+			cmd:      fmt.Sprintf("sudo tail -n %d %s/agent/containerd/containerd.log", tailLines, dataDir),
+			fileName: "containerd.log",
+		},
+		{cmd: crictl + " ps -a", fileName: "crictl-ps.txt", desc: "Containers"},
+		{cmd: crictl + " pods", fileName: "crictl-pods.txt", desc: "Pod sandboxes"},
+	}
+
+	if distro == distroRKE2 {
+		cmds = append(cmds,
+			captureCommand{
+				cmd:      fmt.Sprintf("sudo tail -n %d %s/agent/logs/kubelet.log", tailLines, dataDir),
+				fileName: "kubelet.log",
+			},
+			captureCommand{
+				cmd:      forEachFileCmd(`\$(sudo find `+dataDir+`/agent/pod-manifests -maxdepth 1 -type f | sort)`, `sudo cat \$f`),
+				fileName: "pod-manifests.yaml",
+				desc:     "Static pod manifests",
+			},
+		)
+	}
+	return cmds, nil
+}
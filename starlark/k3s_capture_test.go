@@ -0,0 +1,61 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestK3sCaptureCommands(t *testing.T) {
+	tests := []struct {
+		name      string
+		distro    string
+		since     string
+		expected  []string
+		shouldErr bool
+	}{
+		{
+			name:     "k3s",
+			distro:   "k3s",
+			since:    "24h",
+			expected: []string{"journalctl -u k3s -u k3s-agent --no-pager --since=-24h", "/var/lib/rancher/k3s/server/db", "sudo k3s crictl ps -a"},
+		},
+		{
+			name:     "rke2",
+			distro:   "rke2",
+			since:    "2h",
+			expected: []string{"journalctl -u rke2-server -u rke2-agent", "/var/lib/rancher/rke2/agent/logs/kubelet.log", "/var/lib/rancher/rke2/agent/pod-manifests"},
+		},
+		{name: "unsupported distro", distro: "microk8s", since: "24h", shouldErr: true},
+		{name: "invalid since", distro: "k3s", since: "yesterday", shouldErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmds, err := k3sCaptureCommands(test.distro, test.since, 100)
+			if err != nil {
+				if !test.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if test.shouldErr {
+				t.Fatal("expecting error, got none")
+			}
+			var all []string
+			for _, cmd := range cmds {
+				if strings.Count(cmd.cmd, "$") != strings.Count(cmd.cmd, `\$`) {
+					t.Errorf("command for %s has unescaped shell variables: %s", cmd.fileName, cmd.cmd)
+				}
+				all = append(all, cmd.cmd)
+			}
+			for _, expected := range test.expected {
+				if !strings.Contains(strings.Join(all, "\n"), expected) {
+					t.Errorf("commands do not contain %s", expected)
+				}
+			}
+		})
+	}
+}
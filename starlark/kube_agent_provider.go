@@ -0,0 +1,306 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+	"github.com/vmware-tanzu/crash-diagnostics/proc"
+)
+
+// kubeAgentTransport runs commands in the pods of a node agent DaemonSet, through the API server
+const kubeAgentTransport = "kube_agent"
+
+// nodeAgents are the node agents deployed by the script, by namespace/name, deleted when the script ends
+var nodeAgents = struct {
+	sync.Mutex
+	agents map[string]*k8s.NodeAgent
+}{agents: make(map[string]*k8s.NodeAgent)}
+
+// kubeAgentProviderFunc is a built-in starlark function that deploys a short-lived privileged DaemonSet
+// (see k8s.NodeAgent) on the selected nodes, for clusters where SSH is not allowed. For resources from
+// this provider, the commands of run() and capture() are run on the nodes, chrooted to their root file
+// system, and the files of copy_from() are streamed back, through the API server. The agent is deleted
+// when the script ends. It is refused in read-only mode, since it creates objects in the cluster.
+// Starlark format: kube_agent_provider([names=["node"]][, labels=["key=value"]][, image="busybox:1.36"]
+// [, namespace="kube-system"][, timeout="2m"][, kube_config=kube_config()])
+func kubeAgentProviderFunc(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var names, labels *starlark.List
+	var image, namespace string
+	var timeoutVal starlark.Value
+	var kubeConfig *starlarkstruct.Struct
+
+	if err := starlark.UnpackArgs(
+		identifiers.kubeAgentProvider, args, kwargs,
+		"names?", &names,
+		"labels?", &labels,
+		"image?", &image,
+		"namespace?", &namespace,
+		"timeout?", &timeoutVal,
+		"kube_config?", &kubeConfig,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.kubeAgentProvider, err)
+	}
+
+	if getExecOptions(thread).ReadOnly {
+		return starlark.None, fmt.Errorf("%s: deploying the node agent is rejected in read-only mode", identifiers.kubeAgentProvider)
+	}
+	timeout, err := toDuration(timeoutVal, defaults.kubeAgentTimeout)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: timeout: %s", identifiers.kubeAgentProvider, err)
+	}
+
+	if kubeConfig == nil {
+		kubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
+	}
+	path, err := getKubeConfigFromStruct(kubeConfig)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "failed to kubeconfig")
+	}
+	client, err := k8s.New(path)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "could not initialize search client")
+	}
+	nodeNames, err := k8s.GetNodeNames(client, toSlice(names), toSlice(labels))
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "could not fetch node names")
+	}
+	if len(nodeNames) == 0 {
+		return starlark.None, fmt.Errorf("%s: no node found", identifiers.kubeAgentProvider)
+	}
+
+	agent, err := client.DeployNodeAgent(k8s.NodeAgentOptions{
+		Namespace: namespace,
+		Image:     image,
+		Nodes:     nodeNames,
+		Timeout:   timeout,
+	})
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.kubeAgentProvider, err)
+	}
+	agentName := fmt.Sprintf("%s/%s", agent.Namespace, agent.Name)
+	nodeAgents.Lock()
+	nodeAgents.agents[agentName] = agent
+	nodeAgents.Unlock()
+	logrus.Infof("%s: node agent %s running on %d nodes", identifiers.kubeAgentProvider, agentName, len(nodeNames))
+
+	var hosts []starlark.Value
+	for _, node := range nodeNames {
+		hosts = append(hosts, starlark.String(node))
+	}
+	return starlarkstruct.FromStringDict(starlark.String(identifiers.kubeAgentProvider), starlark.StringDict{
+		"kind":        starlark.String(identifiers.kubeAgentProvider),
+		"transport":   starlark.String(kubeAgentTransport),
+		"hosts":       starlark.NewList(hosts),
+		"kube_config": starlark.String(path),
+		"agent":       starlark.String(agentName),
+	}), nil
+}
+
+// deleteNodeAgents deletes the node agents deployed by the script
+func deleteNodeAgents() {
+	nodeAgents.Lock()
+	defer nodeAgents.Unlock()
+	for name, agent := range nodeAgents.agents {
+		if err := agent.Delete(); err != nil {
+			logrus.Errorf("%s: %s (delete it with: kubectl delete daemonset -n %s %s)", identifiers.kubeAgentProvider, err, agent.Namespace, agent.Name)
+		}
+		delete(nodeAgents.agents, name)
+	}
+}
+
+// getNodeAgent returns the node agent, and the node, of a resource from kube_agent_provider()
+func getNodeAgent(res *starlarkstruct.Struct) (*k8s.NodeAgent, string, error) {
+	node := resourceHost(res)
+	name := ""
+	if val, err := res.Attr("agent"); err == nil {
+		if agent, ok := val.(starlark.String); ok {
+			name = string(agent)
+		}
+	}
+	nodeAgents.Lock()
+	agent, ok := nodeAgents.agents[name]
+	nodeAgents.Unlock()
+	if !ok || len(node) == 0 {
+		return nil, node, fmt.Errorf("%s: node agent %q of node %s is not running", identifiers.kubeAgentProvider, name, node)
+	}
+	return agent, node, nil
+}
+
+// agentCommand returns the program, and its arguments, running the command in the node agent, chrooted to
+// the root file system of the node. The command line is run by sh by default.
+func agentCommand(cmd command) ([]string, error) {
+	argv := []string{"chroot", k8s.NodeAgentHostRoot}
+	if len(cmd.env) > 0 {
+		argv = append(append(argv, "env"), cmd.env...)
+	}
+	switch cmd.shell {
+	case shellPowerShell:
+		return nil, fmt.Errorf("shell %s is not supported by the %s transport", shellPowerShell, kubeAgentTransport)
+	case shellNone:
+		words, err := cmd.words()
+		if err != nil {
+			return nil, err
+		}
+		return append(argv, words...), nil
+	case "":
+		if len(cmd.args) > 0 {
+			return append(argv, cmd.args...), nil
+		}
+		return append(argv, shellSh, "-c", cmd.line), nil
+	default:
+		return append(argv, cmd.shell, "-c", cmd.line), nil
+	}
+}
+
+// execAgent runs the command in the node agent of the resource
+func execAgent(cmd command, res *starlarkstruct.Struct) (string, proc.Result, error) {
+	agent, node, err := getNodeAgent(res)
+	if err != nil {
+		return node, proc.Result{ExitCode: -1}, err
+	}
+	argv, err := agentCommand(cmd)
+	if err != nil {
+		return node, proc.Result{ExitCode: -1}, err
+	}
+
+	var out proc.Output
+	start := time.Now()
+	exitCode, err := agent.Exec(node, argv, out.Stdout(), out.Stderr())
+	return node, out.Result(proc.Result{ExitCode: exitCode, Duration: time.Since(start)}), err
+}
+
+// execRunKubeAgent executes `run` on a node through its node agent
+func execRunKubeAgent(cmd command, res *starlarkstruct.Struct) (commandResult, error) {
+	logrus.Debugf("%s: executing command on %s using the node agent: [%s]", identifiers.run, resourceHost(res), cmd.line)
+	node, out, err := execAgent(cmd, res)
+	if err != nil {
+		return commandResult{}, err
+	}
+	return newRunResult(node, out, nil), nil
+}
+
+// execCaptureKubeAgent executes `capture` on a node through its node agent
+func execCaptureKubeAgent(cmd command, rootDir, fileName, desc string, res *starlarkstruct.Struct) (commandResult, error) {
+	if err := os.MkdirAll(rootDir, 0744); err != nil && !os.IsExist(err) {
+		return commandResult{}, err
+	}
+	if len(fileName) == 0 {
+		fileName = fmt.Sprintf("%s.txt", sanitizeStr(cmd.line))
+	}
+	filePath := filepath.Join(rootDir, fileName)
+
+	logrus.Debugf("%s: capturing output of [cmd=%s] => [%s] from %s using the node agent", identifiers.capture, cmd.line, filePath, resourceHost(res))
+	node, out, err := execAgent(cmd, res)
+	if err == nil {
+		err = out.Err()
+	}
+	if err != nil {
+		if err := captureOutput(strings.NewReader(err.Error()), filePath, fmt.Sprintf("%s: failed", cmd.line)); err != nil {
+			return commandResult{resource: node, result: filePath, err: err}, err
+		}
+		return commandResult{resource: node, result: filePath, err: err}, nil
+	}
+
+	if err := captureOutput(strings.NewReader(out.Stdout), filePath, desc); err != nil {
+		return commandResult{resource: node, result: filePath, err: err}, err
+	}
+	return commandResult{resource: node, result: filePath}, nil
+}
+
+// execCopyKubeAgent executes `copy_from` on a node through its node agent: the files matching the
+// path globs, and none of the excludes, are archived by the agent and extracted under rootDir using their full paths
+func execCopyKubeAgent(rootDir string, paths []string, res *starlarkstruct.Struct, opts copyOptions) (commandResult, error) {
+	agent, node, err := getNodeAgent(res)
+	if err != nil {
+		return commandResult{resource: node, result: rootDir, err: err}, err
+	}
+
+	// the globs are expanded by the shell of the agent under the root of the node (IFS is empty, so that
+	// paths are not split on spaces), and the files archived with the tar of the agent image
+	argv := []string{"sh", "-c", `IFS=; cd "$0" && tar cf - -- $@`, k8s.NodeAgentHostRoot}
+	for _, p := range paths {
+		argv = append(argv, strings.TrimPrefix(path.Clean("/"+p), "/"))
+	}
+
+	reader, writer := io.Pipe()
+	var stderr bytes.Buffer
+	execErr := make(chan error, 1)
+	go func() {
+		exitCode, err := agent.Exec(node, argv, writer, &stderr)
+		if err == nil && exitCode != 0 {
+			err = fmt.Errorf("tar: exit status %d: %s", exitCode, strings.TrimSpace(stderr.String()))
+		}
+		writer.CloseWithError(err)
+		execErr <- err
+	}()
+
+	err = extractAgentFiles(reader, rootDir, opts.excludes)
+	reader.CloseWithError(err)
+	if exitErr := <-execErr; exitErr != nil {
+		// tar reports the files it could not read, but archives the others
+		logrus.Warnf("%s: %s: %s", identifiers.copyFrom, node, exitErr)
+	}
+	if err != nil {
+		return commandResult{resource: node, result: rootDir, err: err}, err
+	}
+
+	if len(paths) == 1 {
+		return commandResult{resource: node, result: filepath.Join(rootDir, paths[0])}, nil
+	}
+	return commandResult{resource: node, result: rootDir}, nil
+}
+
+// extractAgentFiles extracts the regular files of the tar stream, that match none of the excludes, under rootDir
+func extractAgentFiles(source io.Reader, rootDir string, excludes []string) error {
+	tr := tar.NewReader(source)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		// the archived paths are relative to the root of the node
+		name := path.Clean("/" + hdr.Name)
+		if isExcluded(name, excludes) {
+			continue
+		}
+
+		filePath := filepath.Join(rootDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0744); err != nil && !os.IsExist(err) {
+			return err
+		}
+		file, err := os.Create(filePath)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, tr)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAgentCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmd      command
+		expected []string
+	}{
+		{
+			name:     "default shell",
+			cmd:      command{line: "journalctl -u kubelet | tail"},
+			expected: []string{"chroot", "/host", "sh", "-c", "journalctl -u kubelet | tail"},
+		},
+		{
+			name:     "words",
+			cmd:      command{args: []string{"cat", "/var/log/my app.log"}, env: []string{"A=b"}},
+			expected: []string{"chroot", "/host", "env", "A=b", "cat", "/var/log/my app.log"},
+		},
+		{
+			name:     "no shell",
+			cmd:      command{line: `ls "/var/log/my app"`, shell: shellNone},
+			expected: []string{"chroot", "/host", "ls", "/var/log/my app"},
+		},
+		{
+			name:     "bash",
+			cmd:      command{line: "echo $BASH_VERSION", shell: shellBash},
+			expected: []string{"chroot", "/host", "bash", "-c", "echo $BASH_VERSION"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			argv, err := agentCommand(test.cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(argv, "|") != strings.Join(test.expected, "|") {
+				t.Errorf("unexpected command: %q", argv)
+			}
+		})
+	}
+
+	if _, err := agentCommand(command{line: "Get-Process", shell: shellPowerShell}); err == nil {
+		t.Error("expecting error for powershell")
+	}
+}
+
+func TestExtractAgentFiles(t *testing.T) {
+	hostRoot, err := ioutil.TempDir("", "crashd-agent-host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hostRoot)
+	rootDir, err := ioutil.TempDir("", "crashd-agent-copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootDir)
+
+	for _, file := range []string{"var/log/pods/a.log", "var/log/pods/b.gz", "var/log/my app/c.log", "etc/hosts"} {
+		path := filepath.Join(hostRoot, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the archive is created like the agent does
+	var archive bytes.Buffer
+	tarCmd := exec.Command("sh", "-c", `IFS=; cd "$0" && tar cf - -- $@`, hostRoot, "var/log/pods/*", "var/log/my app")
+	tarCmd.Stdout = &archive
+	if err := tarCmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := extractAgentFiles(&archive, rootDir, []string{"*.gz"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"var/log/pods/a.log", "var/log/my app/c.log"} {
+		content, err := ioutil.ReadFile(filepath.Join(rootDir, filepath.FromSlash(file)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != file {
+			t.Errorf("unexpected content of %s: %s", file, content)
+		}
+	}
+	for _, file := range []string{"var/log/pods/b.gz", "etc/hosts"} {
+		if _, err := os.Stat(filepath.Join(rootDir, filepath.FromSlash(file))); !os.IsNotExist(err) {
+			t.Errorf("expecting %s not to be copied", file)
+		}
+	}
+}
@@ -4,6 +4,12 @@
 package starlark
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/vmware-tanzu/crash-diagnostics/k8s"
@@ -11,14 +17,21 @@ import (
 	"go.starlark.net/starlarkstruct"
 )
 
+// kubeCaptureStatsFileName is the file of the working directory where the statistics of each kube_capture are appended
+const kubeCaptureStatsFileName = "kube_capture_stats.txt"
+
 // KubeCaptureFn is the Starlark built-in for the fetching kubernetes objects
 // and returns the result as a Starlark value containing the file path and error message, if any
-// Starlark format: kube_capture(what="logs" [, groups="core", namespaces=["default"], kube_config=kube_config()])
+// Namespaces can be glob patterns, and patterns prefixed with ! (or listed in exclude_namespaces) are excluded.
+// what="related" captures the objects found with their related object graph (see k8s.Client.RelatedObjects) and pod logs.
+// Kinds in exclude_kinds are not captured, and objects larger than max_object_size are saved without their content.
+// Starlark format: kube_capture(what="logs" [, groups="core", namespaces=["default"], exclude_namespaces=["kube-node-lease"], exclude_kinds=["secrets"], max_object_size=size, kube_config=kube_config(), output_format="json|yaml", layout="kubecapture|must-gather"])
 func KubeCaptureFn(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 
-	var groups, kinds, namespaces, versions, names, labels, containers *starlark.List
+	var groups, kinds, namespaces, excludeNamespaces, excludeKinds, versions, names, labels, containers *starlark.List
 	var kubeConfig *starlarkstruct.Struct
-	var what string
+	var what, outputFormat, layout string
+	var maxObjectSize starlark.Value
 
 	if err := starlark.UnpackArgs(
 		identifiers.kubeCapture, args, kwargs,
@@ -26,15 +39,48 @@ func KubeCaptureFn(thread *starlark.Thread, _ *starlark.Builtin, args starlark.T
 		"groups?", &groups,
 		"kinds?", &kinds,
 		"namespaces?", &namespaces,
+		"exclude_namespaces?", &excludeNamespaces,
+		"exclude_kinds?", &excludeKinds,
+		"max_object_size?", &maxObjectSize,
 		"versions?", &versions,
 		"names?", &names,
 		"labels?", &labels,
 		"containers?", &containers,
 		"kube_config?", &kubeConfig,
+		"output_format?", &outputFormat,
+		"layout?", &layout,
 	); err != nil {
 		return starlark.None, errors.Wrap(err, "failed to read args")
 	}
 
+	if len(outputFormat) == 0 {
+		outputFormat = getOutputFormat(thread)
+	}
+	if err := k8s.ValidateOutputFormat(outputFormat); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.kubeCapture, err)
+	}
+
+	if len(layout) == 0 {
+		layout = k8s.LayoutKubeCapture
+	}
+	if err := k8s.ValidateLayout(layout); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.kubeCapture, err)
+	}
+
+	if _, err := k8s.NewContainerFilter(toSlice(containers)); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.kubeCapture, err)
+	}
+
+	maxObjectBytes, err := parseByteSize(maxObjectSize)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: max_object_size: %s", identifiers.kubeCapture, err)
+	}
+
+	nsList, err := restrictPolicyNamespaces(thread, identifiers.kubeCapture, toSlice(namespaces))
+	if err != nil {
+		return starlark.None, err
+	}
+
 	if kubeConfig == nil {
 		kubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
 	}
@@ -50,20 +96,36 @@ func KubeCaptureFn(thread *starlark.Thread, _ *starlark.Builtin, args starlark.T
 	data := thread.Local(identifiers.crashdCfg)
 	cfg, _ := data.(*starlarkstruct.Struct)
 	workDirVal, _ := cfg.Attr("workdir")
-	resultDir, err := write(trimQuotes(workDirVal.String()), what, client, k8s.SearchParams{
-		Groups:     toSlice(groups),
-		Kinds:      toSlice(kinds),
-		Namespaces: toSlice(namespaces),
-		Versions:   toSlice(versions),
-		Names:      toSlice(names),
-		Labels:     toSlice(labels),
-		Containers: toSlice(containers),
+	index := getCaptureIndexFromThread(thread)
+	workdir := trimQuotes(workDirVal.String())
+	start := time.Now()
+	resultDir, stats, err := write(workdir, what, outputFormat, layout, client, index, maxObjectBytes, k8s.SearchParams{
+		Groups:            toSlice(groups),
+		Kinds:             toSlice(kinds),
+		Namespaces:        nsList,
+		Versions:          toSlice(versions),
+		Names:             toSlice(names),
+		Labels:            toSlice(labels),
+		Containers:        toSlice(containers),
+		ExcludeNamespaces: toSlice(excludeNamespaces),
+		ExcludeKinds:      toSlice(excludeKinds),
 	})
+	if len(stats) > 0 {
+		if statsErr := writeCaptureStats(workdir, what, time.Since(start), stats); statsErr != nil {
+			logrus.Errorf("%s: failed to save capture statistics: %s", identifiers.kubeCapture, statsErr)
+		}
+	}
+	if index != nil && err == nil {
+		if saveErr := index.save(); saveErr != nil {
+			logrus.Errorf("%s: failed to save capture index: %s", identifiers.kubeCapture, saveErr)
+		}
+	}
 
 	return starlarkstruct.FromStringDict(
 		starlark.String(identifiers.kubeCapture),
 		starlark.StringDict{
-			"file": starlark.String(resultDir),
+			"file":  starlark.String(resultDir),
+			"stats": captureStatsList(stats),
 			"error": func() starlark.String {
 				if err != nil {
 					return starlark.String(err.Error())
@@ -73,7 +135,17 @@ func KubeCaptureFn(thread *starlark.Thread, _ *starlark.Builtin, args starlark.T
 		}), nil
 }
 
-func write(workdir, what string, client *k8s.Client, params k8s.SearchParams) (string, error) {
+// searchResultWriter saves search results in one of the layouts of kube_capture
+type searchResultWriter interface {
+	SetContainers(patterns []string) error
+	Write(searchResults []k8s.SearchResult) error
+	GetResultDir() string
+}
+
+// write searches the objects of params and saves them, or the logs of the pods found. Objects larger
+// than maxObjectSize bytes, when not zero, are saved without their content. It returns the statistics,
+// per kind, of the objects found.
+func write(workdir, what, outputFormat, layout string, client *k8s.Client, index *captureIndex, maxObjectSize int64, params k8s.SearchParams) (string, []k8s.KindStats, error) {
 
 	logrus.Debugf("kube_capture(what=%s)", what)
 	switch what {
@@ -81,23 +153,107 @@ func write(workdir, what string, client *k8s.Client, params k8s.SearchParams) (s
 		params.Groups = []string{"core"}
 		params.Kinds = []string{"pods"}
 		params.Versions = []string{}
-	case "objects", "all", "*":
+	case "objects", "all", "*", "related":
 	default:
-		return "", errors.Errorf("don't know how to get: %s", what)
+		return "", nil, errors.Errorf("don't know how to get: %s", what)
 	}
 
 	searchResults, err := client.Search(params)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	resultWriter, err := k8s.NewResultWriter(workdir, what, client.CoreRest)
+	// the objects found are the roots of the related object graph, saved with the logs of its pods
+	if what == "related" {
+		if searchResults, err = client.RelatedObjects(searchResults); err != nil {
+			return "", nil, err
+		}
+		if len(searchResults) == 0 {
+			return "", nil, errors.Errorf("no object found matching the search parameters")
+		}
+		what = "all"
+	}
+
+	// in incremental mode, objects with an unchanged resourceVersion are not saved again
+	// (logs are always collected as they change independently of the pod object)
+	if index != nil && what == "objects" {
+		searchResults = index.filterUnchangedObjects(searchResults)
+		if len(searchResults) == 0 {
+			logrus.Debugf("kube_capture(what=%s): no changed objects", what)
+			if layout == k8s.LayoutMustGather {
+				return filepath.Join(workdir, k8s.MustGatherDirname), nil, nil
+			}
+			return filepath.Join(workdir, k8s.BaseDirname), nil, nil
+		}
+	}
+
+	if maxObjectSize > 0 && what != "logs" {
+		if omitted := k8s.LimitObjectSize(searchResults, maxObjectSize); omitted > 0 {
+			logrus.Warnf("kube_capture(what=%s): content of %d objects larger than %d bytes omitted", what, omitted, maxObjectSize)
+		}
+	}
+
+	stats := k8s.CaptureStats(searchResults)
+
+	var resultWriter searchResultWriter
+	if layout == k8s.LayoutMustGather {
+		resultWriter, err = k8s.NewMustGatherWriter(workdir, what, client.CoreRest)
+	} else {
+		resultWriter, err = k8s.NewResultWriter(workdir, what, outputFormat, client.CoreRest)
+	}
 	if err != nil {
-		return "", errors.Wrap(err, "failed to initialize writer")
+		return "", stats, errors.Wrap(err, "failed to initialize writer")
+	}
+	if err := resultWriter.SetContainers(params.Containers); err != nil {
+		return "", stats, err
 	}
 	err = resultWriter.Write(searchResults)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to write search results")
+		return "", stats, errors.Wrap(err, "failed to write search results")
+	}
+	return resultWriter.GetResultDir(), stats, nil
+}
+
+// writeCaptureStats logs the totals of the statistics of a kube_capture invocation and its largest kinds,
+// and appends the statistics to <workdir>/kube_capture_stats.txt
+func writeCaptureStats(workdir, what string, duration time.Duration, stats []k8s.KindStats) error {
+	var objects int
+	var size int64
+	var largest []string
+	for i, s := range stats {
+		objects += s.Objects
+		size += s.Bytes
+		if i < 3 {
+			largest = append(largest, fmt.Sprintf("%s %s", s.Kind, k8s.FormatBytes(s.Bytes)))
+		}
+	}
+	logrus.Infof("%s(what=%s): %d objects of %d kinds, %s, in %s (largest: %s)", identifiers.kubeCapture, what, objects, len(stats),
+		k8s.FormatBytes(size), duration.Round(time.Millisecond), strings.Join(largest, ", "))
+
+	file, err := os.OpenFile(filepath.Join(workdir, kubeCaptureStatsFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fmt.Fprintf(file, "%s(what=%s) at %s, took %s\n", identifiers.kubeCapture, what, time.Now().Format(time.RFC3339), duration.Round(time.Millisecond))
+	if err := k8s.WriteCaptureStats(file, stats); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(file)
+	return err
+}
+
+// captureStatsList returns the statistics as a list of structs
+func captureStatsList(stats []k8s.KindStats) *starlark.List {
+	var list []starlark.Value
+	for _, s := range stats {
+		list = append(list, starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"kind":     starlark.String(s.Kind),
+			"objects":  starlark.MakeInt(s.Objects),
+			"bytes":    starlark.MakeInt64(s.Bytes),
+			"omitted":  starlark.MakeInt(s.Omitted),
+			"duration": durationValue(s.Duration),
+		}))
 	}
-	return resultWriter.GetResultDir(), nil
+	return starlark.NewList(list)
 }
@@ -86,6 +86,65 @@ kube_data = kube_capture(what="objects", groups=["core"], kinds=["nodes"], kube_
 		Expect(filepath.Join(kubeCaptureDir, "nodes.json")).To(BeARegularFile())
 	})
 
+	It("creates YAML files when output_format is yaml", func() {
+		crashdScript := fmt.Sprintf(`
+crashd_config(workdir="%s")
+kube_data = kube_capture(what="objects", groups=["core"], kinds=["nodes"], kube_config=kube_config(path="%s"), output_format="yaml")
+		`, workdir, k8sconfig)
+		execSetup(crashdScript)
+		Expect(err).NotTo(HaveOccurred())
+
+		dataStruct, ok := executor.result["kube_data"].(*starlarkstruct.Struct)
+		Expect(ok).To(BeTrue())
+
+		fileVal, err := dataStruct.Attr("file")
+		Expect(err).NotTo(HaveOccurred())
+
+		kubeCaptureDir := fileVal.(starlark.String).GoString()
+		Expect(filepath.Join(kubeCaptureDir, "nodes.yaml")).To(BeARegularFile())
+	})
+
+	It("captures pods matching a label selector across all namespaces", func() {
+		crashdScript := fmt.Sprintf(`
+crashd_config(workdir="%s")
+kube_data = kube_capture(what="objects", kinds=["pods"], labels=["k8s-app=kube-dns"], namespaces=["*"], kube_config=kube_config(path="%s"))
+		`, workdir, k8sconfig)
+		execSetup(crashdScript)
+		Expect(err).NotTo(HaveOccurred())
+
+		dataStruct, ok := executor.result["kube_data"].(*starlarkstruct.Struct)
+		Expect(ok).To(BeTrue())
+
+		fileVal, err := dataStruct.Attr("file")
+		Expect(err).NotTo(HaveOccurred())
+
+		kubeCaptureDir := fileVal.(starlark.String).GoString()
+		Expect(filepath.Join(kubeCaptureDir, "kube-system", "pods.json")).To(BeARegularFile())
+	})
+
+	It("captures the objects related to a deployment", func() {
+		crashdScript := fmt.Sprintf(`
+crashd_config(workdir="%s")
+set_defaults(kube_config(path="%s"))
+kube_data = kube_capture(what="related", groups=["apps"], kinds=["deployments"], names=["coredns"], namespaces=["kube-system"])
+		`, workdir, k8sconfig)
+		execSetup(crashdScript)
+		Expect(err).NotTo(HaveOccurred())
+
+		dataStruct, ok := executor.result["kube_data"].(*starlarkstruct.Struct)
+		Expect(ok).To(BeTrue())
+		errVal, err := dataStruct.Attr("error")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(errVal.(starlark.String).GoString()).To(BeEmpty())
+
+		fileVal, err := dataStruct.Attr("file")
+		Expect(err).NotTo(HaveOccurred())
+		nsDir := filepath.Join(fileVal.(starlark.String).GoString(), "kube-system")
+		for _, file := range []string{"deployments.json", "replicasets.json", "pods.json", "services.json", "endpoints.json"} {
+			Expect(filepath.Join(nsDir, file)).To(BeARegularFile())
+		}
+	})
+
 	It("creates a directory and log files for all objects in a namespace", func() {
 		crashdScript := fmt.Sprintf(`
 crashd_config(workdir="%s")
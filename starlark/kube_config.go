@@ -9,19 +9,26 @@ import (
 	"github.com/pkg/errors"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
 )
 
 // KubeConfigFn is built-in starlark function that wraps the kwargs into a dictionary value.
 // The result is also added to the thread for other built-in to access.
-// Starlark: kube_config(path=kubecf/path)
-func KubeConfigFn(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-	var path string
+// When a context or a cluster is selected, the path of the result is a kubeconfig file limited to
+// that context (the current context when only a cluster is selected), using that cluster. The
+// context set with --kube-context (ConfigOverrides.KubeContext) replaces the context of a path.
+// Starlark: kube_config(path=kubecf/path[, context=name][, cluster=name])
+func KubeConfigFn(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var path, context, cluster string
 	var provider *starlarkstruct.Struct
 
 	if err := starlark.UnpackArgs(
 		identifiers.kubeCfg, args, kwargs,
 		"path?", &path,
 		"capi_provider?", &provider,
+		"context?", &context,
+		"cluster?", &cluster,
 	); err != nil {
 		return starlark.None, fmt.Errorf("%s: %s", identifiers.kubeCfg, err)
 	}
@@ -35,7 +42,7 @@ func KubeConfigFn(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple,
 		val := provider.Constructor()
 		if constructor, ok := val.(starlark.String); ok {
 			constStr := constructor.GoString()
-			if constStr != identifiers.capvProvider && constStr != identifiers.capaProvider {
+			if constStr != identifiers.capvProvider && constStr != identifiers.capaProvider && constStr != identifiers.capzProvider {
 				return starlark.None, errors.New("unknown capi provider")
 			}
 		}
@@ -49,11 +56,27 @@ func KubeConfigFn(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple,
 			return starlark.None, errors.New("could not fetch kubeconfig")
 		}
 		path = pathStr.GoString()
+	} else if override := getExecOptions(thread).Config.KubeContext; len(override) > 0 {
+		context = override
+	}
+
+	dict := starlark.StringDict{}
+	if len(context) > 0 || len(cluster) > 0 {
+		contextPath, err := k8s.WriteContextConfig(path, context, cluster)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.kubeCfg, err)
+		}
+		path = contextPath
+		if len(context) > 0 {
+			dict["context"] = starlark.String(context)
+		}
+		if len(cluster) > 0 {
+			dict["cluster"] = starlark.String(cluster)
+		}
 	}
+	dict["path"] = starlark.String(path)
 
-	structVal := starlarkstruct.FromStringDict(starlark.String(identifiers.kubeCfg), starlark.StringDict{
-		"path": starlark.String(path),
-	})
+	structVal := starlarkstruct.FromStringDict(starlark.String(identifiers.kubeCfg), dict)
 
 	return structVal, nil
 }
@@ -4,12 +4,19 @@
 package starlark
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
+	"testing"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
 )
 
 var _ = Describe("kube_config", func() {
@@ -133,3 +140,103 @@ var _ = Describe("KubeConfigFn", func() {
 		})
 	})
 })
+
+const testMultiContextKubeConfig = `apiVersion: v1
+kind: Config
+current-context: prod
+clusters:
+- name: prod-cluster
+  cluster:
+    server: https://prod.example.com:6443
+- name: staging-cluster
+  cluster:
+    server: https://staging.example.com:6443
+contexts:
+- name: prod
+  context:
+    cluster: prod-cluster
+    user: admin
+- name: staging
+  context:
+    cluster: staging-cluster
+    user: admin
+users:
+- name: admin
+  user:
+    token: secret
+`
+
+func TestKubeConfigContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashd-kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer k8s.RemoveContextConfigs()
+	path := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(path, []byte(testMultiContextKubeConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		context   string
+		cluster   string
+		override  string
+		expected  string
+		server    string
+		shouldErr bool
+	}{
+		{name: "context", context: "staging", expected: "staging", server: "https://staging.example.com:6443"},
+		{name: "cluster of the current context", cluster: "staging-cluster", expected: "prod", server: "https://staging.example.com:6443"},
+		{name: "command line context", context: "prod", override: "staging", expected: "staging", server: "https://staging.example.com:6443"},
+		{name: "unknown context", context: "dev", shouldErr: true},
+		{name: "unknown cluster", context: "prod", cluster: "dev-cluster", shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			thread := &starlark.Thread{Name: "test.kube.config.context"}
+			thread.SetLocal(execOptionsLocal, ExecOptions{Config: ConfigOverrides{KubeContext: test.override}})
+			kwargs := []starlark.Tuple{{starlark.String("path"), starlark.String(path)}}
+			if len(test.context) > 0 {
+				kwargs = append(kwargs, starlark.Tuple{starlark.String("context"), starlark.String(test.context)})
+			}
+			if len(test.cluster) > 0 {
+				kwargs = append(kwargs, starlark.Tuple{starlark.String("cluster"), starlark.String(test.cluster)})
+			}
+			val, err := KubeConfigFn(thread, nil, nil, kwargs)
+			if test.shouldErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			contextPath, err := getKubeConfigFromStruct(val.(*starlarkstruct.Struct))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contextPath == path {
+				t.Fatal("expecting the kubeconfig of the context")
+			}
+			kubeConfig, err := clientcmd.LoadFromFile(contextPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kubeConfig.CurrentContext != test.expected || len(kubeConfig.Contexts) != 1 {
+				t.Errorf("expecting the only context %s, got %s (%d contexts)", test.expected, kubeConfig.CurrentContext, len(kubeConfig.Contexts))
+			}
+			if len(kubeConfig.Clusters) != 1 {
+				t.Fatalf("expecting a single cluster, got %d", len(kubeConfig.Clusters))
+			}
+			for _, cluster := range kubeConfig.Clusters {
+				if cluster.Server != test.server {
+					t.Errorf("expecting server %s, got %s", test.server, cluster.Server)
+				}
+			}
+		})
+	}
+}
@@ -30,6 +30,11 @@ func KubeGetFn(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple
 		return starlark.None, errors.Wrap(err, "failed to read args")
 	}
 
+	nsList, err := restrictPolicyNamespaces(thread, identifiers.kubeGet, toSlice(namespaces))
+	if err != nil {
+		return starlark.None, err
+	}
+
 	if kubeConfig == nil {
 		kubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
 	}
@@ -46,7 +51,7 @@ func KubeGetFn(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple
 	searchParams := k8s.SearchParams{
 		Groups:     toSlice(groups),
 		Kinds:      toSlice(kinds),
-		Namespaces: toSlice(namespaces),
+		Namespaces: nsList,
 		Versions:   toSlice(versions),
 		Names:      toSlice(names),
 		Labels:     toSlice(labels),
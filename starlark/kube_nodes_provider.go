@@ -12,18 +12,19 @@ import (
 )
 
 // KubeNodesProviderFn is a built-in starlark function that collects compute resources from a k8s cluster
-// Starlark format: kube_nodes_provider([kube_config=kube_config(), ssh_config=ssh_config(), names=["foo", "bar], labels=["bar", "baz"]])
+// Starlark format: kube_nodes_provider([kube_config=kube_config(), ssh_config=ssh_config()|"<name>", names=["foo", "bar], labels=["bar", "baz"]])
 func KubeNodesProviderFn(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 
 	var names, labels *starlark.List
-	var kubeConfig, sshConfig *starlarkstruct.Struct
+	var kubeConfig *starlarkstruct.Struct
+	var sshConfigVal starlark.Value
 
 	if err := starlark.UnpackArgs(
 		identifiers.kubeNodesProvider, args, kwargs,
 		"names?", &names,
 		"labels?", &labels,
 		"kube_config?", &kubeConfig,
-		"ssh_config?", &sshConfig,
+		"ssh_config?", &sshConfigVal,
 	); err != nil {
 		return starlark.None, errors.Wrap(err, "failed to read args")
 	}
@@ -36,8 +37,9 @@ func KubeNodesProviderFn(thread *starlark.Thread, _ *starlark.Builtin, args star
 		return starlark.None, errors.Wrap(err, "failed to kubeconfig")
 	}
 
-	if sshConfig == nil {
-		sshConfig = thread.Local(identifiers.sshCfg).(*starlarkstruct.Struct)
+	sshConfig, err := getSSHConfig(thread, sshConfigVal)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, identifiers.kubeNodesProvider)
 	}
 
 	return newKubeNodesProvider(path, sshConfig, toSlice(names), toSlice(labels))
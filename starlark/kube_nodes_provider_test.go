@@ -45,6 +45,21 @@ provider = kube_nodes_provider(kube_config = cfg, ssh_config = ssh_config(userna
 		Expect(list.Len()).To(Equal(1))
 	})
 
+	It("filters the nodes by labels", func() {
+		crashdScript := fmt.Sprintf(`
+cfg = kube_config(path="%s")
+provider = kube_nodes_provider(kube_config=cfg, ssh_config=ssh_config(username="uname", private_key_path="path"), labels=["kubernetes.io/os=linux"])`, k8sconfig)
+		err = execSetup(crashdScript)
+		Expect(err).NotTo(HaveOccurred())
+
+		provider, ok := executor.result["provider"].(*starlarkstruct.Struct)
+		Expect(ok).To(BeTrue())
+
+		val, err := provider.Attr("hosts")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(val.(*starlark.List).Len()).To(Equal(1))
+	})
+
 	It("returns a struct with ssh config", func() {
 		crashdScript := fmt.Sprintf(`
 cfg = kube_config(path="%s")
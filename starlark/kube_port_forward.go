@@ -0,0 +1,141 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+// portForwards are the port forwards opened by the script, closed when the script ends
+var portForwards = struct {
+	sync.Mutex
+	forwards []*k8s.PortForward
+}{}
+
+// kubePortForwardFunc is a built-in starlark function that forwards a local port, on 127.0.0.1, to a port
+// of a pod through the API server, so that endpoints only reachable in the cluster (i.e. metrics or debug
+// endpoints) can be probed by the script, with run_local() or capture() on the local_provider(). The pod
+// is named, or the first running pod matching labels. The forward is closed when the script ends.
+// Starlark format: kube_port_forward(namespace="ns", pod="name"|labels=["key=value"], port=8080
+// [, local_port=0][, timeout="30s"][, kube_config=kube_config()])
+func kubePortForwardFunc(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var namespace, pod string
+	var labels *starlark.List
+	var port, localPort int
+	var timeoutVal starlark.Value
+	var kubeConfig *starlarkstruct.Struct
+
+	if err := starlark.UnpackArgs(
+		identifiers.kubePortForward, args, kwargs,
+		"namespace?", &namespace,
+		"pod?", &pod,
+		"labels?", &labels,
+		"port?", &port,
+		"local_port?", &localPort,
+		"timeout?", &timeoutVal,
+		"kube_config?", &kubeConfig,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.kubePortForward, err)
+	}
+
+	if len(namespace) == 0 {
+		namespace = "default"
+	}
+	if (len(pod) == 0) == (labels == nil || labels.Len() == 0) {
+		return starlark.None, fmt.Errorf("%s: specify one of pod or labels", identifiers.kubePortForward)
+	}
+	if port == 0 {
+		return starlark.None, fmt.Errorf("%s: port is required", identifiers.kubePortForward)
+	}
+	if port < 0 || port > 65535 || localPort < 0 || localPort > 65535 {
+		return starlark.None, fmt.Errorf("%s: invalid port", identifiers.kubePortForward)
+	}
+	timeout, err := toDuration(timeoutVal, defaults.kubePortForwardTimeout)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: timeout: %s", identifiers.kubePortForward, err)
+	}
+
+	if kubeConfig == nil {
+		kubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
+	}
+	path, err := getKubeConfigFromStruct(kubeConfig)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "failed to kubeconfig")
+	}
+	client, err := k8s.New(path)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "could not initialize search client")
+	}
+	if len(pod) == 0 {
+		if pod, err = client.RunningPod(namespace, toSlice(labels)); err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.kubePortForward, err)
+		}
+	}
+
+	fwd, err := client.PortForward(namespace, pod, port, localPort, timeout)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.kubePortForward, err)
+	}
+	portForwards.Lock()
+	portForwards.forwards = append(portForwards.forwards, fwd)
+	portForwards.Unlock()
+
+	return starlarkstruct.FromStringDict(starlark.String(identifiers.kubePortForward), starlark.StringDict{
+		"namespace":  starlark.String(namespace),
+		"pod":        starlark.String(pod),
+		"port":       starlark.MakeInt(port),
+		"local_port": starlark.MakeInt(fwd.LocalPort),
+		"address":    starlark.String(fwd.Address()),
+		"url":        starlark.String("http://" + fwd.Address()),
+	}), nil
+}
+
+// closePortForwards closes the port forwards opened by the script
+func closePortForwards() {
+	portForwards.Lock()
+	defer portForwards.Unlock()
+	for _, fwd := range portForwards.forwards {
+		fwd.Close()
+	}
+	portForwards.forwards = nil
+}
+
+// getPodPaths returns the responses to GET requests of the paths, on a port of the pod reached through a port forward
+// (i.e. the admin endpoint of a proxy, only listening on the loopback interface of the pod)
+func getPodPaths(client *k8s.Client, namespace, pod string, port int, paths []string, timeout time.Duration) ([][]byte, error) {
+	fwd, err := client.PortForward(namespace, pod, port, 0, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer fwd.Close()
+
+	httpClient := &http.Client{Timeout: timeout}
+	var bodies [][]byte
+	for _, path := range paths {
+		resp, err := httpClient.Get("http://" + fwd.Address() + path)
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %s: %s", path, resp.Status)
+		}
+		bodies = append(bodies, body)
+	}
+	return bodies, nil
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKubePortForwardArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		err    string
+	}{
+		{name: "no pod", script: `kube_port_forward(namespace="monitoring", port=9090)`, err: "specify one of pod or labels"},
+		{name: "pod and labels", script: `kube_port_forward(pod="prometheus-0", labels=["app=prometheus"], port=9090)`, err: "specify one of pod or labels"},
+		{name: "no port", script: `kube_port_forward(pod="prometheus-0")`, err: "port is required"},
+		{name: "invalid port", script: `kube_port_forward(pod="prometheus-0", port=70000)`, err: "invalid port"},
+		{name: "invalid local port", script: `kube_port_forward(pod="prometheus-0", port=9090, local_port=-1)`, err: "invalid port"},
+		{name: "invalid timeout", script: `kube_port_forward(pod="prometheus-0", port=9090, timeout="soon")`, err: "timeout"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := New().Exec("test.star", strings.NewReader(test.script))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expecting error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
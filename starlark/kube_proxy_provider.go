@@ -0,0 +1,207 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+// kubeProxyTransport reaches node-local services through the API server proxy
+const kubeProxyTransport = "kube_proxy"
+
+// kubeProxyProviderFunc is a built-in starlark function that configures a provider for node-local
+// services (kubelet API, node-exporter, custom agents) reached through the API server proxy, for
+// clusters where neither SSH nor exec-into-host is allowed. For resources from this provider, the
+// commands of run() and capture() and the paths of copy_from() are HTTP paths fetched from the
+// service. When port is not set, the kubelet API is used.
+// Starlark format: kube_proxy_provider([names=["node"]][, labels=["key=value"]][, port=9100][, scheme="http|https"][, kube_config=kube_config()])
+func kubeProxyProviderFunc(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var names, labels *starlark.List
+	var port int
+	var scheme string
+	var kubeConfig *starlarkstruct.Struct
+
+	if err := starlark.UnpackArgs(
+		identifiers.kubeProxyProvider, args, kwargs,
+		"names?", &names,
+		"labels?", &labels,
+		"port?", &port,
+		"scheme?", &scheme,
+		"kube_config?", &kubeConfig,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.kubeProxyProvider, err)
+	}
+
+	switch scheme {
+	case "", "http", "https":
+	default:
+		return starlark.None, fmt.Errorf("%s: unsupported scheme %q (expecting http or https)", identifiers.kubeProxyProvider, scheme)
+	}
+	if port < 0 {
+		return starlark.None, fmt.Errorf("%s: port must be positive", identifiers.kubeProxyProvider)
+	}
+
+	if kubeConfig == nil {
+		kubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
+	}
+	path, err := getKubeConfigFromStruct(kubeConfig)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "failed to kubeconfig")
+	}
+	client, err := k8s.New(path)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "could not initialize search client")
+	}
+	nodeNames, err := k8s.GetNodeNames(client, toSlice(names), toSlice(labels))
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "could not fetch node names")
+	}
+
+	var hosts []starlark.Value
+	for _, node := range nodeNames {
+		hosts = append(hosts, starlark.String(node))
+	}
+	return starlarkstruct.FromStringDict(starlark.String(identifiers.kubeProxyProvider), starlark.StringDict{
+		"kind":        starlark.String(identifiers.kubeProxyProvider),
+		"transport":   starlark.String(kubeProxyTransport),
+		"hosts":       starlark.NewList(hosts),
+		"kube_config": starlark.String(path),
+		"port":        starlark.MakeInt(port),
+		"scheme":      starlark.String(scheme),
+	}), nil
+}
+
+// kubeProxyTarget is a node-local service reached through the API server proxy
+type kubeProxyTarget struct {
+	node       string
+	kubeConfig string
+	port       int
+	scheme     string
+}
+
+// getKubeProxyTarget returns the node-local service of a resource from kube_proxy_provider()
+func getKubeProxyTarget(res *starlarkstruct.Struct) (kubeProxyTarget, error) {
+	target := kubeProxyTarget{node: resourceHost(res)}
+	if val, err := res.Attr("kube_config"); err == nil {
+		if path, ok := val.(starlark.String); ok {
+			target.kubeConfig = string(path)
+		}
+	}
+	if val, err := res.Attr("port"); err == nil {
+		if port, ok := val.(starlark.Int); ok {
+			if n, ok := port.Int64(); ok {
+				target.port = int(n)
+			}
+		}
+	}
+	if val, err := res.Attr("scheme"); err == nil {
+		if scheme, ok := val.(starlark.String); ok {
+			target.scheme = string(scheme)
+		}
+	}
+	if len(target.node) == 0 || len(target.kubeConfig) == 0 {
+		return target, fmt.Errorf("%s: invalid resource: node and kube_config required", identifiers.kubeProxyProvider)
+	}
+	return target, nil
+}
+
+// get returns the content at path on the node-local service
+func (t kubeProxyTarget) get(path string) ([]byte, error) {
+	client, err := k8s.New(t.kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := client.NodeProxyGet(t.node, t.scheme, t.port, path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// execRunKubeProxy executes `run` for a node-local service: the command is the HTTP path fetched
+func execRunKubeProxy(path string, res *starlarkstruct.Struct) (commandResult, error) {
+	target, err := getKubeProxyTarget(res)
+	if err != nil {
+		return commandResult{}, err
+	}
+	logrus.Debugf("%s: fetching %s from node %s through the API server", identifiers.run, path, target.node)
+	start := time.Now()
+	data, err := target.get(path)
+	result := commandResult{resource: target.node, result: string(data), err: err, stdout: string(data), duration: time.Since(start)}
+	if err != nil {
+		result.exitCode = -1
+	}
+	return result, nil
+}
+
+// execCaptureKubeProxy executes `capture` for a node-local service: the content at the HTTP path
+// is saved in rootDir
+func execCaptureKubeProxy(path, rootDir, fileName, desc string, res *starlarkstruct.Struct) (commandResult, error) {
+	target, err := getKubeProxyTarget(res)
+	if err != nil {
+		return commandResult{}, err
+	}
+	if err := os.MkdirAll(rootDir, 0744); err != nil && !os.IsExist(err) {
+		return commandResult{}, err
+	}
+	if len(fileName) == 0 {
+		fileName = fmt.Sprintf("%s.txt", sanitizeStr(path))
+	}
+	filePath := filepath.Join(rootDir, fileName)
+
+	logrus.Debugf("%s: capturing %s from node %s => [%s]", identifiers.capture, path, target.node, filePath)
+	data, err := target.get(path)
+	if err != nil {
+		if err := captureOutput(strings.NewReader(err.Error()), filePath, fmt.Sprintf("%s: failed", path)); err != nil {
+			return commandResult{resource: target.node, result: filePath, err: err}, err
+		}
+		return commandResult{resource: target.node, result: filePath, err: err}, nil
+	}
+	if err := captureOutput(bytes.NewReader(data), filePath, desc); err != nil {
+		return commandResult{resource: target.node, result: filePath, err: err}, err
+	}
+	return commandResult{resource: target.node, result: filePath}, nil
+}
+
+// execCopyKubeProxy executes `copy_from` for a node-local service: the content at each HTTP path
+// is saved under rootDir using the path (i.e. /logs/syslog is saved in <rootDir>/logs/syslog).
+// Globs, excludes, and the tail, resume, and incremental options do not apply.
+func execCopyKubeProxy(rootDir string, paths []string, res *starlarkstruct.Struct) (commandResult, error) {
+	target, err := getKubeProxyTarget(res)
+	if err != nil {
+		return commandResult{}, err
+	}
+	for _, path := range paths {
+		filePath := filepath.Join(rootDir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0744); err != nil && !os.IsExist(err) {
+			return commandResult{resource: target.node, result: rootDir, err: err}, err
+		}
+		data, err := target.get(path)
+		if err != nil {
+			return commandResult{resource: target.node, result: rootDir, err: err}, err
+		}
+		if err := ioutil.WriteFile(filePath, data, 0644); err != nil {
+			return commandResult{resource: target.node, result: rootDir, err: err}, err
+		}
+	}
+
+	if len(paths) == 1 {
+		return commandResult{resource: target.node, result: filepath.Join(rootDir, paths[0])}, nil
+	}
+	return commandResult{resource: target.node, result: rootDir}, nil
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+// kubeadmConfigMaps are the name prefixes of the kube-system ConfigMaps holding the cluster
+// configuration of kubeadm clusters (kubelet-config is versioned, i.e. kubelet-config-1.18)
+var kubeadmConfigMaps = []string{"kubeadm-config", "kubelet-config", "kube-proxy"}
+
+// kubeadmCaptureFunc is a built-in starlark function that collects the configuration of kubeadm
+// control-plane nodes: the static pod manifests, the kubelet configuration files, and the admission,
+// encryption, and audit policy files referenced by the API server manifest. It also saves the
+// kubeadm-config, kubelet-config, and kube-proxy ConfigMaps. Encryption secrets are redacted.
+// If resources are not provided, control-plane nodes are selected from the cluster using node labels.
+// Starlark format: kubeadm_capture([resources=resources][, labels=["node-role.kubernetes.io/master"]]
+// [, workdir=path][, kube_config=kube_config()][, ssh_config=ssh_config()|"<name>"])
+func kubeadmCaptureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir string
+	var resources, labels *starlark.List
+	var kubeConfig *starlarkstruct.Struct
+	var sshConfigVal starlark.Value
+
+	if err := starlark.UnpackArgs(
+		identifiers.kubeadmCapture, args, kwargs,
+		"resources?", &resources,
+		"labels?", &labels,
+		"workdir?", &workdir,
+		"kube_config?", &kubeConfig,
+		"ssh_config?", &sshConfigVal,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.kubeadmCapture, err)
+	}
+
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+	kubeadmDir := filepath.Join(workdir, "kubeadm")
+
+	if kubeConfig == nil {
+		kubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
+	}
+	kubeConfigPath, err := getKubeConfigFromStruct(kubeConfig)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "failed to kubeconfig")
+	}
+
+	if resources == nil {
+		sshConfig, err := getSSHConfig(thread, sshConfigVal)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.kubeadmCapture, err)
+		}
+		nodeLabels := toSlice(labels)
+		if len(nodeLabels) == 0 {
+			nodeLabels = defaults.controlPlaneLabels
+		}
+		provider, err := newKubeNodesProvider(kubeConfigPath, sshConfig, nil, nodeLabels)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.kubeadmCapture, err)
+		}
+		res, err := enum(provider)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.kubeadmCapture, err)
+		}
+		resources = res
+	}
+
+	results, err := execCaptureCommands(kubeadmDir, kubeadmCaptureCommands(), resources)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.kubeadmCapture, err)
+	}
+
+	configDir, configErr := writeKubeadmConfigMaps(kubeConfigPath, kubeadmDir)
+	if configErr != nil {
+		logrus.Errorf("%s: config maps: %s", identifiers.kubeadmCapture, configErr)
+	}
+
+	return starlarkstruct.FromStringDict(
+		starlark.String(identifiers.kubeadmCapture),
+		starlark.StringDict{
+			"results":     commandResultsToList(results),
+			"config_maps": starlark.String(configDir),
+			"error": func() starlark.String {
+				if configErr != nil {
+					return starlark.String(configErr.Error())
+				}
+				return ""
+			}(),
+		}), nil
+}
+
+// kubeadmCaptureCommands returns the commands used to collect the configuration files of a
+// control-plane node. Shell variables are escaped (\$) to prevent their expansion on the local machine,
+// and double quotes are not used since the command is quoted by the ssh transport.
+func kubeadmCaptureCommands() []captureCommand {
+	kubeletFiles := []string{
+		"/var/lib/kubelet/config.yaml",
+		"/var/lib/kubelet/kubeadm-flags.env",
+		"/etc/default/kubelet",
+		"/etc/sysconfig/kubelet",
+		"/etc/systemd/system/kubelet.service.d/*",
+		"/usr/lib/systemd/system/kubelet.service.d/*",
+	}
+	return []captureCommand{
+		{
+			cmd:      "sudo ls -la /etc/kubernetes /etc/kubernetes/manifests /etc/kubernetes/pki",
+			fileName: "kubernetes-files.txt",
+			desc:     "Files under /etc/kubernetes",
+		},
+		{
+			cmd:      forEachFileCmd(`\$(sudo find /etc/kubernetes/manifests -maxdepth 1 -type f | sort)`, `sudo cat \$f`),
+			fileName: "static-pod-manifests.yaml",
+			desc:     "Static pod manifests (/etc/kubernetes/manifests)",
+		},
+		{
+			cmd:      forEachFileCmd(strings.Join(kubeletFiles, " "), `sudo cat \$f`),
+			fileName: "kubelet-config.txt",
+			desc:     "Kubelet configuration files",
+		},
+		{
+			cmd: forEachFileCmd(
+				`\$(sudo grep -hoE -- '--(admission-control-config-file|encryption-provider-config|audit-policy-file)=[^ ]+' /etc/kubernetes/manifests/kube-apiserver.yaml 2>/dev/null | cut -d= -f2)`,
+				`sudo sed -E 's/(secret: ).*/\1<redacted>/' \$f`,
+			),
+			fileName: "apiserver-config-files.yaml",
+			desc:     "Admission, encryption (secrets redacted), and audit policy files of the API server",
+		},
+	}
+}
+
+// writeKubeadmConfigMaps saves the kubeadm configuration ConfigMaps of kube-system as YAML.
+// It returns the directory where the ConfigMaps are saved.
+func writeKubeadmConfigMaps(kubeConfigPath, workdir string) (string, error) {
+	client, err := k8s.New(kubeConfigPath)
+	if err != nil {
+		return "", errors.Wrap(err, "could not initialize search client")
+	}
+
+	resultWriter, err := k8s.NewResultWriter(workdir, "objects", k8s.OutputFormatYAML, client.CoreRest)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to initialize writer")
+	}
+
+	searchResults, err := client.Search(k8s.SearchParams{
+		Groups:     []string{"core"},
+		Kinds:      []string{"configmaps"},
+		Namespaces: []string{"kube-system"},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var found []k8s.SearchResult
+	for _, result := range searchResults {
+		if result.List == nil {
+			continue
+		}
+		items := result.List.Items[:0]
+		for _, item := range result.List.Items {
+			if isKubeadmConfigMap(item.GetName()) {
+				items = append(items, item)
+			}
+		}
+		if len(items) > 0 {
+			result.List.Items = items
+			found = append(found, result)
+		}
+	}
+	if len(found) == 0 {
+		logrus.Debugf("%s: no kubeadm config maps found in kube-system", identifiers.kubeadmCapture)
+		return resultWriter.GetResultDir(), nil
+	}
+
+	if err := resultWriter.Write(found); err != nil {
+		return "", errors.Wrap(err, "failed to write search results")
+	}
+	return resultWriter.GetResultDir(), nil
+}
+
+// isKubeadmConfigMap returns true when name is one of kubeadmConfigMaps, possibly versioned
+func isKubeadmConfigMap(name string) bool {
+	for _, prefix := range kubeadmConfigMaps {
+		if name == prefix || strings.HasPrefix(name, prefix+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// forEachFileCmd returns a command running body for each existing file \$f of files, a list of
+// paths, globs, or a command substitution. The output of each file is preceded by its path.
+func forEachFileCmd(files, body string) string {
+	return fmt.Sprintf(`for f in %s; do sudo test -f \$f || continue; echo '# '\$f; %s; echo; done`, files, body)
+}
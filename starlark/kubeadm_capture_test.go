@@ -0,0 +1,42 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKubeadmCaptureCommands(t *testing.T) {
+	for _, cmd := range kubeadmCaptureCommands() {
+		if strings.Count(cmd.cmd, "$") != strings.Count(cmd.cmd, `\$`) {
+			t.Errorf("command for %s has unescaped shell variables: %s", cmd.fileName, cmd.cmd)
+		}
+		if strings.Contains(cmd.cmd, `"`) {
+			t.Errorf("command for %s contains double quotes: %s", cmd.fileName, cmd.cmd)
+		}
+	}
+}
+
+func TestIsKubeadmConfigMap(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{name: "kubeadm-config", expected: true},
+		{name: "kubelet-config-1.18", expected: true},
+		{name: "kubelet-config", expected: true},
+		{name: "kube-proxy", expected: true},
+		{name: "coredns", expected: false},
+		{name: "kube-proxyfoo", expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := isKubeadmConfigMap(test.name); actual != test.expected {
+				t.Errorf("expecting %t, got %t", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+// kubeletCaptureFunc is a built-in starlark function that queries endpoints of the kubelet API of
+// nodes and saves the responses under <workdir>/kubelet/<node>. Kubelets are reached through the
+// API server proxy, or directly at their internal IP with the credentials of the kubeconfig when
+// direct is set. Nodes are selected by names or labels, all nodes by default.
+// Starlark format: kubelet_capture([endpoints=["/pods","/stats/summary","/configz"]][, names=["node"]][, labels=["key=value"]]
+// [, direct=False][, ca_file=path][, insecure=False][, workdir=path][, kube_config=kube_config()])
+func kubeletCaptureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir, caFile string
+	var endpoints, names, labels *starlark.List
+	var direct, insecure bool
+	var kubeConfig *starlarkstruct.Struct
+
+	if err := starlark.UnpackArgs(
+		identifiers.kubeletCapture, args, kwargs,
+		"endpoints?", &endpoints,
+		"names?", &names,
+		"labels?", &labels,
+		"direct?", &direct,
+		"ca_file?", &caFile,
+		"insecure?", &insecure,
+		"workdir?", &workdir,
+		"kube_config?", &kubeConfig,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.kubeletCapture, err)
+	}
+
+	endpointList := toSlice(endpoints)
+	if len(endpointList) == 0 {
+		endpointList = defaults.kubeletEndpoints
+	}
+	if (len(caFile) > 0 || insecure) && !direct {
+		return starlark.None, fmt.Errorf("%s: ca_file and insecure require direct=True", identifiers.kubeletCapture)
+	}
+
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+
+	if kubeConfig == nil {
+		kubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
+	}
+	path, err := getKubeConfigFromStruct(kubeConfig)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "failed to kubeconfig")
+	}
+	client, err := k8s.New(path)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "could not initialize search client")
+	}
+	nodeNames, err := k8s.GetNodeNames(client, toSlice(names), toSlice(labels))
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "could not fetch node names")
+	}
+
+	get := func(node, endpoint string) (io.ReadCloser, error) {
+		return client.NodeProxyGet(node, "", 0, endpoint)
+	}
+	if direct {
+		kubelets, err := k8s.NewKubeletClient(path, caFile, insecure)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.kubeletCapture, err)
+		}
+		get = kubelets.Get
+	}
+
+	resultDir := filepath.Join(workdir, k8s.KubeletDirname)
+	err = writeKubeletResponses(get, resultDir, nodeNames, endpointList)
+
+	return starlarkstruct.FromStringDict(
+		starlark.String(identifiers.kubeletCapture),
+		starlark.StringDict{
+			"file": starlark.String(resultDir),
+			"error": func() starlark.String {
+				if err != nil {
+					return starlark.String(err.Error())
+				}
+				return ""
+			}(),
+		}), nil
+}
+
+// writeKubeletResponses saves the response of each endpoint of each node in
+// resultDir/<node>/<file> (see kubeletEndpointFile). Failed requests are logged and reported together.
+func writeKubeletResponses(get func(node, endpoint string) (io.ReadCloser, error), resultDir string, nodes, endpoints []string) error {
+	var failures []string
+	for _, node := range nodes {
+		nodeDir := filepath.Join(resultDir, sanitizeStr(node))
+		if err := os.MkdirAll(nodeDir, 0744); err != nil && !os.IsExist(err) {
+			return err
+		}
+
+		for _, endpoint := range endpoints {
+			logrus.Debugf("%s: fetching %s from node %s", identifiers.kubeletCapture, endpoint, node)
+			if err := writeKubeletResponse(get, node, endpoint, filepath.Join(nodeDir, kubeletEndpointFile(endpoint))); err != nil {
+				logrus.Errorf("%s: %s", identifiers.kubeletCapture, err)
+				failures = append(failures, err.Error())
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func writeKubeletResponse(get func(node, endpoint string) (io.ReadCloser, error), node, endpoint, filePath string) error {
+	reader, err := get(node, endpoint)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, reader); err != nil {
+		return errors.Wrapf(err, "failed to write %s", filePath)
+	}
+	return nil
+}
+
+// kubeletEndpointFile returns the name of the file saving the response of endpoint, i.e.
+// stats_summary.json for /stats/summary. Metrics and logs endpoints are saved as text.
+func kubeletEndpointFile(endpoint string) string {
+	path := strings.Trim(strings.SplitN(endpoint, "?", 2)[0], "/")
+	ext := ".json"
+	if strings.HasPrefix(path, "metrics") || strings.HasPrefix(path, "logs") || strings.HasPrefix(path, "healthz") {
+		ext = ".txt"
+	}
+	return sanitizeStr(path) + ext
+}
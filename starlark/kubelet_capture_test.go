@@ -0,0 +1,76 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("kubelet_capture", func() {
+
+	var (
+		executor *Executor
+		err      error
+	)
+
+	execSetup := func(crashdScript string) {
+		executor = New()
+		err = executor.Exec("test.kubelet.capture", strings.NewReader(crashdScript))
+	}
+
+	It("saves the kubelet API responses of each node through the API server proxy", func() {
+		crashdScript := fmt.Sprintf(`
+crashd_config(workdir="%s")
+set_defaults(kube_config(path="%s"))
+kubelet_data = kubelet_capture(endpoints=["/pods", "/configz"])
+		`, workdir, k8sconfig)
+		execSetup(crashdScript)
+		Expect(err).NotTo(HaveOccurred())
+
+		dataStruct, ok := executor.result["kubelet_data"].(*starlarkstruct.Struct)
+		Expect(ok).To(BeTrue())
+
+		errVal, err := dataStruct.Attr("error")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(errVal.(starlark.String).GoString()).To(BeEmpty())
+
+		fileVal, err := dataStruct.Attr("file")
+		Expect(err).NotTo(HaveOccurred())
+		kubeletDir := fileVal.(starlark.String).GoString()
+		nodeDirs, err := ioutil.ReadDir(kubeletDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(nodeDirs).NotTo(BeEmpty())
+		Expect(filepath.Join(kubeletDir, nodeDirs[0].Name(), "pods.json")).To(BeARegularFile())
+		Expect(filepath.Join(kubeletDir, nodeDirs[0].Name(), "configz.json")).To(BeARegularFile())
+	})
+
+	It("requires direct for ca_file and insecure", func() {
+		crashdScript := fmt.Sprintf(`
+set_defaults(kube_config(path="%s"))
+kubelet_capture(insecure=True)
+		`, k8sconfig)
+		execSetup(crashdScript)
+		Expect(err).To(HaveOccurred())
+	})
+
+	DescribeTable("naming the file of an endpoint response",
+		func(endpoint, expected string) {
+			Expect(kubeletEndpointFile(endpoint)).To(Equal(expected))
+		},
+		Entry("pods", "/pods", "pods.json"),
+		Entry("stats", "/stats/summary", "stats_summary.json"),
+		Entry("query", "/stats/summary?only_cpu_and_memory=true", "stats_summary.json"),
+		Entry("metrics", "/metrics/cadvisor", "metrics_cadvisor.txt"),
+	)
+})
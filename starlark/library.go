@@ -0,0 +1,101 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// libraryFileName is the name of the library script in backtraces
+const libraryFileName = "crashd-library.star"
+
+// libraryScript is the library of functions, written in Starlark with the crashd built-ins, available
+// to every script. Library functions compose built-ins into complete collection workflows.
+const libraryScript = `
+# commands run on each NotReady node by triage_notready_nodes, with the name of their output file
+_TRIAGE_NODE_COMMANDS = [
+    ("sudo journalctl -u kubelet --no-pager -n 5000", "kubelet.log"),
+    ("sudo systemctl status kubelet containerd docker --no-pager", "services.txt"),
+    ("sudo crictl info", "runtime-info.json"),
+    ("sudo crictl ps -a", "runtime-containers.txt"),
+    ("df -h", "disk.txt"),
+    ("df -i", "disk-inodes.txt"),
+    ("free -m", "memory.txt"),
+    ("sudo ls -l /etc/cni/net.d /opt/cni/bin", "cni-config.txt"),
+]
+
+def _notready_nodes(kube_args):
+    nodes = kube_get(groups=["core"], kinds=["nodes"], **kube_args)
+    if nodes.error:
+        fail("triage_notready_nodes: failed to get nodes: " + nodes.error)
+
+    notready = []
+    for result in nodes.objs:
+        for node in getattr(result.List, "Items", []):
+            ready = False
+            for cond in node.status.conditions:
+                if cond.type == "Ready" and cond.status == "True":
+                    ready = True
+            if not ready:
+                notready.append(node.metadata.name)
+    return notready
+
+# triage_notready_nodes finds the nodes whose Ready condition is not True, then captures the node
+# objects and runs the node-level collection (kubelet logs, container runtime status, disk and memory
+# usage, CNI configuration and agent logs) only on those nodes, reached over SSH.
+def triage_notready_nodes(ssh_config=None, kube_config=None, cni_agents="calico|cilium|flannel|weave|antrea|kube-proxy"):
+    kube_args = {}
+    if kube_config != None:
+        kube_args["kube_config"] = kube_config
+
+    notready = _notready_nodes(kube_args)
+    if len(notready) == 0:
+        print("triage_notready_nodes: all nodes are Ready")
+        return struct(nodes=[], results=[])
+    print("triage_notready_nodes: NotReady nodes: " + ", ".join(notready))
+
+    kube_capture(what="objects", groups=["core"], kinds=["nodes"], names=notready, **kube_args)
+
+    provider_args = dict(kube_args)
+    if ssh_config != None:
+        provider_args["ssh_config"] = ssh_config
+    nodes = resources(provider=kube_nodes_provider(names=notready, **provider_args))
+
+    commands = list(_TRIAGE_NODE_COMMANDS)
+    commands.append(("sudo crictl ps -a --name '" + cni_agents + "'", "cni-containers.txt"))
+    commands.append((
+        "sudo sh -c 'for id in \\$(crictl ps -a -q --name " + cni_agents + "); do echo == \\$id; crictl logs --tail 2000 \\$id 2>&1; done'",
+        "cni-logs.txt",
+    ))
+
+    results = []
+    for cmd, file_name in commands:
+        results.extend(capture(cmd=cmd, resources=nodes, file_name=file_name))
+    return struct(nodes=notready, results=results)
+`
+
+// loadLibrary adds the functions of the library script to the predeclared values. Names
+// already declared (i.e. by plugins) and private names (starting with _) are skipped.
+func (e *Executor) loadLibrary() error {
+	env := make(starlark.StringDict, len(e.predecs)+1)
+	for name, val := range e.predecs {
+		env[name] = val
+	}
+	env["struct"] = starlark.NewBuiltin("struct", starlarkstruct.Make)
+
+	globals, err := starlark.ExecFile(&starlark.Thread{Name: "crashd-library"}, libraryFileName, libraryScript, env)
+	if err != nil {
+		return fmt.Errorf("failed to load library: %s", err)
+	}
+	for name, val := range globals {
+		if _, ok := e.predecs[name]; ok || name[0] == '_' {
+			continue
+		}
+		e.predecs[name] = val
+	}
+	return nil
+}
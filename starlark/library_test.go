@@ -0,0 +1,78 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestLibraryNotReadyNodes(t *testing.T) {
+	thread := &starlark.Thread{Name: "test"}
+	structEnv := starlark.StringDict{"struct": starlark.NewBuiltin("struct", starlarkstruct.Make)}
+	nodes, err := starlark.Eval(thread, "nodes", `struct(objs=[struct(List=struct(Items=[
+	struct(metadata=struct(name="ready"), status=struct(conditions=[struct(type="MemoryPressure", status="False"), struct(type="Ready", status="True")])),
+	struct(metadata=struct(name="notready"), status=struct(conditions=[struct(type="Ready", status="False")])),
+	struct(metadata=struct(name="unknown"), status=struct(conditions=[struct(type="Ready", status="Unknown")])),
+]))], error="")`, structEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := newPredeclareds()
+	env["struct"] = structEnv["struct"]
+	env[identifiers.kubeGet] = starlark.NewBuiltin(identifiers.kubeGet, func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+		return nodes, nil
+	})
+	globals, err := starlark.ExecFile(thread, libraryFileName, libraryScript, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notready, err := starlark.Call(thread, globals["_notready_nodes"], starlark.Tuple{starlark.NewDict(0)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notready.String() != `["notready", "unknown"]` {
+		t.Errorf("unexpected NotReady nodes: %s", notready)
+	}
+}
+
+func TestLibraryLoaded(t *testing.T) {
+	exe := New()
+	if err := exe.Exec("test.star", strings.NewReader(`triage = triage_notready_nodes`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := exe.result["triage"].(*starlark.Function); !ok {
+		t.Fatalf("unexpected triage_notready_nodes: %s", exe.result["triage"])
+	}
+	if _, ok := exe.predecs["_notready_nodes"]; ok {
+		t.Error("private library functions should not be declared")
+	}
+}
+
+var _ = Describe("triage_notready_nodes", func() {
+	It("does not collect from nodes when all nodes are Ready", func() {
+		executor := New()
+		err := executor.Exec("test.triage", strings.NewReader(fmt.Sprintf(`
+crashd_config(workdir="%s")
+set_defaults(kube_config(path="%s"))
+triage = triage_notready_nodes()
+		`, workdir, k8sconfig)))
+		Expect(err).NotTo(HaveOccurred())
+
+		triage, ok := executor.result["triage"].(*starlarkstruct.Struct)
+		Expect(ok).To(BeTrue())
+		nodes, err := triage.Attr("nodes")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(nodes.(*starlark.List).Len()).To(Equal(0))
+	})
+})
@@ -0,0 +1,303 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+	"github.com/vmware-tanzu/crash-diagnostics/proc"
+	"github.com/vmware-tanzu/crash-diagnostics/ssh"
+	"github.com/vmware-tanzu/crash-diagnostics/tracing"
+)
+
+const (
+	localTransport = "local"
+	localHost      = "localhost"
+)
+
+// localProviderFunc is a built-in starlark function that configures a provider for the machine
+// running crashd. Commands for resources from this provider (run, capture, copy_from) are executed
+// directly on the local machine, without SSH.
+// Starlark format: local_provider()
+func localProviderFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(identifiers.localProvider, args, kwargs); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.localProvider, err)
+	}
+
+	return starlarkstruct.FromStringDict(starlark.String(identifiers.localProvider), starlark.StringDict{
+		"kind":      starlark.String(identifiers.localProvider),
+		"transport": starlark.String(localTransport),
+		"hosts":     starlark.NewList([]starlark.Value{starlark.String(localHost)}),
+	}), nil
+}
+
+// runLocalProc runs the command line cmdStr (see proc.Run), with the credentials of aws_config() in its
+// environment, on the local machine and records it in the audit trail. A command that does not exit with
+// status 0 returns an error.
+func runLocalProc(thread *starlark.Thread, cmdStr string) (proc.Result, error) {
+	span := tracing.Start(audit.ActionLocalRun, tracing.String("net.peer.name", localHost), tracing.String("crashd.command", cmdStr))
+	var out proc.Result
+	env, err := getAWSEnv(thread)
+	if err == nil {
+		out, err = proc.Run(cmdStr, env...)
+	}
+	if err == nil {
+		err = out.Err()
+	}
+	audit.Record(localHost, audit.LocalUser(), audit.ActionLocalRun, cmdStr, err)
+	span.End(err)
+	return out, err
+}
+
+// runLocalCommand runs the command on the local machine, capturing stdout and stderr separately, and records it in the audit trail
+func runLocalCommand(cmd command) (proc.Result, error) {
+	span := tracing.Start(audit.ActionLocalRun, tracing.String("net.peer.name", localHost), tracing.String("crashd.command", cmd.line))
+	var out proc.Result
+	name, args, err := cmd.local()
+	// the variables of the command take precedence over the AWS credentials
+	env := append(append([]string{}, cmd.localEnv...), cmd.env...)
+	switch {
+	case err != nil:
+		out.ExitCode = -1
+	case len(name) > 0:
+		out, err = proc.RunArgs(env, name, args...)
+	default:
+		out, err = proc.Run(cmd.line, env...)
+	}
+	auditErr := err
+	if auditErr == nil {
+		auditErr = out.Err()
+	}
+	audit.Record(localHost, audit.LocalUser(), audit.ActionLocalRun, cmd.line, auditErr)
+	span.End(auditErr)
+	return out, err
+}
+
+// execRunLocal executes `run` command on the local machine
+func execRunLocal(cmd command) (commandResult, error) {
+	logrus.Debugf("%s: executing command locally: [%s]", identifiers.run, cmd.line)
+	out, err := runLocalCommand(cmd)
+	return newRunResult(localHost, out, err), nil
+}
+
+// execCaptureLocal executes `capture` command on the local machine
+func execCaptureLocal(cmd command, rootDir, fileName, desc string) (commandResult, error) {
+	if err := os.MkdirAll(rootDir, 0744); err != nil && !os.IsExist(err) {
+		return commandResult{}, err
+	}
+	if len(fileName) == 0 {
+		fileName = fmt.Sprintf("%s.txt", sanitizeStr(cmd.line))
+	}
+	filePath := filepath.Join(rootDir, fileName)
+
+	logrus.Debugf("%s: capturing output of [cmd=%s] => [%s] locally", identifiers.capture, cmd.line, filePath)
+	out, err := runLocalCommand(cmd)
+	if err == nil {
+		err = out.Err()
+	}
+	if err != nil {
+		if err := captureOutput(strings.NewReader(err.Error()), filePath, fmt.Sprintf("%s: failed", cmd.line)); err != nil {
+			return commandResult{resource: localHost, result: filePath, err: err}, err
+		}
+		return commandResult{resource: localHost, result: filePath, err: err}, nil
+	}
+
+	if err := captureOutput(strings.NewReader(out.Stdout), filePath, desc); err != nil {
+		return commandResult{resource: localHost, result: filePath, err: err}, err
+	}
+	return commandResult{resource: localHost, result: filePath}, nil
+}
+
+// execCopyLocal executes `copy_from` on the local machine: files matching the paths,
+// and none of the excludes, are copied under rootDir using their full paths.
+func execCopyLocal(rootDir string, paths []string, opts copyOptions) (commandResult, error) {
+	files, err := findLocalFiles(paths, opts.excludes)
+	if err != nil {
+		return commandResult{resource: localHost, result: rootDir, err: err}, err
+	}
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return commandResult{resource: localHost, result: rootDir, err: err}, err
+		}
+
+		// incremental mode: skip unchanged files, copy appended data only
+		var offset int64
+		incremental := opts.index != nil && !opts.isTail()
+		stat := ssh.FileStat{Path: file, Size: info.Size(), ModTime: info.ModTime().Unix()}
+		if incremental {
+			off, changed := opts.index.fileOffset(localHost, stat)
+			if !changed {
+				continue
+			}
+			offset = off
+		}
+
+		err = copyLocalFile(file, localCopyPath(rootDir, file), offset, opts)
+		audit.Record(localHost, audit.LocalUser(), audit.ActionLocalCopy, file, err)
+		if err != nil {
+			return commandResult{resource: localHost, result: rootDir, err: err}, err
+		}
+		if incremental {
+			opts.index.updateFile(localHost, stat)
+		}
+	}
+
+	if len(paths) == 1 {
+		return commandResult{resource: localHost, result: filepath.Join(rootDir, paths[0])}, nil
+	}
+	return commandResult{resource: localHost, result: rootDir}, nil
+}
+
+// findLocalFiles returns the regular files under the path globs that do not match
+// any of the excludes. As with remote hosts, an exclude containing a "/" is matched
+// against the file path, otherwise against the file name.
+func findLocalFiles(paths, excludes []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			err := filepath.Walk(match, func(file string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.Mode().IsRegular() && !isExcluded(file, excludes) {
+					files = append(files, file)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
+// isExcluded returns true when the file matches one of the excludes: an exclude containing
+// a "/" is matched against the file path, otherwise against the file name
+func isExcluded(file string, excludes []string) bool {
+	for _, exclude := range excludes {
+		name := filepath.Base(file)
+		if strings.ContainsAny(exclude, `/\`) {
+			name = file
+		}
+		if match, _ := filepath.Match(filepath.FromSlash(exclude), name); match {
+			return true
+		}
+	}
+	return false
+}
+
+// localCopyPath returns the path, under rootDir, of the copy of file. The volume name of
+// Windows paths is kept as a directory (i.e. C:\k\kubelet.log is copied to <rootDir>\C\k\kubelet.log).
+func localCopyPath(rootDir, file string) string {
+	volume := filepath.VolumeName(file)
+	volumeDir := strings.Trim(strings.Replace(volume, ":", "", -1), `/\`)
+	return filepath.Join(rootDir, volumeDir, file[len(volume):])
+}
+
+// copyLocalFile copies source to target starting at offset, or only the tail
+// of source when tail options are set
+func copyLocalFile(source, target string, offset int64, opts copyOptions) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0744); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	switch {
+	case opts.tailBytes > 0:
+		offset, err = tailBytesOffset(src, int64(opts.tailBytes))
+	case opts.tailLines > 0:
+		offset, err = tailLinesOffset(src, opts.tailLines)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	dst, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, src); err != nil {
+		return err
+	}
+	return dst.Close()
+}
+
+func tailBytesOffset(file *os.File, n int64) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() <= n {
+		return 0, nil
+	}
+	return info.Size() - n, nil
+}
+
+// tailLinesOffset returns the offset of the last n lines of file by scanning it backwards
+func tailLinesOffset(file *os.File, n int) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	end := size
+	// a trailing newline terminates the last line and is not a line separator
+	if size > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, size-1); err != nil {
+			return 0, err
+		}
+		if last[0] == '\n' {
+			end = size - 1
+		}
+	}
+
+	const chunkSize = 32 * 1024
+	buf := make([]byte, chunkSize)
+	for pos := end; pos > 0; {
+		readSize := int64(chunkSize)
+		if pos < readSize {
+			readSize = pos
+		}
+		pos -= readSize
+		if _, err := file.ReadAt(buf[:readSize], pos); err != nil {
+			return 0, err
+		}
+		for i := readSize - 1; i >= 0; i-- {
+			if buf[i] != '\n' {
+				continue
+			}
+			n--
+			if n == 0 {
+				return pos + i + 1, nil
+			}
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+func TestTailLinesOffset(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		lines    int
+		expected string
+	}{
+		{name: "last line", content: "one\ntwo\nthree\n", lines: 1, expected: "three\n"},
+		{name: "no trailing newline", content: "one\ntwo\nthree", lines: 2, expected: "two\nthree"},
+		{name: "more lines than file", content: "one\ntwo\n", lines: 5, expected: "one\ntwo\n"},
+		{name: "empty file", content: "", lines: 1, expected: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := ioutil.TempFile("", "crashd-tail")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(file.Name())
+			defer file.Close()
+			if _, err := file.WriteString(test.content); err != nil {
+				t.Fatal(err)
+			}
+
+			offset, err := tailLinesOffset(file, test.lines)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.content[offset:] != test.expected {
+				t.Errorf("unexpected tail: %q", test.content[offset:])
+			}
+		})
+	}
+}
+
+func TestLocalProviderScript(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "crashd-local-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srcDir)
+	workdir, err := ioutil.TempDir("", "crashd-local-workdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+
+	for name, content := range map[string]string{"app.log": "one\ntwo\n", "istio.log": "three\n"} {
+		if err := ioutil.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	script := fmt.Sprintf(`
+crashd_config(workdir="%s")
+set_defaults(resources(provider=local_provider()))
+ran = run("echo hello")
+captured = capture("echo captured", file_name="echo.txt")
+copied = copy_from(paths=["%s/*.log"], exclude=["istio*"], tail_lines=1)
+`, workdir, srcDir)
+
+	exe := New()
+	if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+
+	ran := exe.result["ran"].(*starlarkstruct.Struct)
+	if val, _ := ran.Attr("result"); string(val.(starlark.String)) != "hello" {
+		t.Errorf("unexpected run result: %s", val)
+	}
+
+	hostDir := filepath.Join(workdir, sanitizeStr(localHost))
+	content, err := ioutil.ReadFile(filepath.Join(hostDir, "echo.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "captured") {
+		t.Errorf("unexpected captured content: %s", content)
+	}
+
+	content, err = ioutil.ReadFile(filepath.Join(hostDir, srcDir, "app.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "two\n" {
+		t.Errorf("unexpected copied content: %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(hostDir, srcDir, "istio.log")); !os.IsNotExist(err) {
+		t.Error("excluded file should not be copied")
+	}
+
+	audit, err := ioutil.ReadFile(filepath.Join(workdir, auditFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []string{
+		`action=local.run status=ok detail="echo hello"`,
+		`action=local.run status=ok detail="echo captured"`,
+		fmt.Sprintf(`action=local.copy status=ok detail="%s"`, filepath.Join(srcDir, "app.log")),
+	} {
+		if !strings.Contains(string(audit), entry) {
+			t.Errorf("audit log missing entry %s:\n%s", entry, audit)
+		}
+	}
+}
+
+func TestLocalCopyPath(t *testing.T) {
+	rootDir := filepath.Join("crashd", "localhost")
+	tests := []struct {
+		file     string
+		expected string
+	}{
+		{file: filepath.FromSlash("/var/log/kubelet.log"), expected: filepath.Join(rootDir, "var", "log", "kubelet.log")},
+		{file: filepath.FromSlash("logs/app.log"), expected: filepath.Join(rootDir, "logs", "app.log")},
+	}
+	if runtime.GOOS == "windows" {
+		tests = append(tests, struct {
+			file     string
+			expected string
+		}{file: `C:\k\kubelet.log`, expected: filepath.Join(rootDir, "C", "k", "kubelet.log")})
+	}
+
+	for _, test := range tests {
+		if result := localCopyPath(rootDir, test.file); result != test.expected {
+			t.Errorf("%s: expecting %s, got %s", test.file, test.expected, result)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"math"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// newMathModule returns the math module, modeled after the go.starlark.net/lib/math module.
+// Starlark format: math.ceil(x), math.floor(x), math.round(x), math.fabs(x), math.sqrt(x),
+// math.exp(x), math.log(x, base=e), math.pow(x, y), math.mod(x, y), and the constants math.pi, math.e.
+func newMathModule() *starlarkstruct.Module {
+	return &starlarkstruct.Module{
+		Name: identifiers.math,
+		Members: starlark.StringDict{
+			"ceil":  newMathRoundingFunc("ceil", math.Ceil),
+			"floor": newMathRoundingFunc("floor", math.Floor),
+			"round": newMathRoundingFunc("round", math.Round),
+			"fabs":  newMathUnaryFunc("fabs", math.Abs),
+			"sqrt":  newMathUnaryFunc("sqrt", math.Sqrt),
+			"exp":   newMathUnaryFunc("exp", math.Exp),
+			"log":   starlark.NewBuiltin("log", mathLog),
+			"pow":   newMathBinaryFunc("pow", math.Pow),
+			"mod":   newMathBinaryFunc("mod", math.Mod),
+
+			"pi": starlark.Float(math.Pi),
+			"e":  starlark.Float(math.E),
+		},
+	}
+}
+
+// toFloat converts an int or float argument to a float64
+func toFloat(name string, val starlark.Value) (float64, error) {
+	switch v := val.(type) {
+	case starlark.Int:
+		return float64(v.Float()), nil
+	case starlark.Float:
+		return float64(v), nil
+	default:
+		return 0, fmt.Errorf("%s: got %s, want int or float", name, val.Type())
+	}
+}
+
+// newMathRoundingFunc returns a built-in that rounds its argument to an int
+func newMathRoundingFunc(name string, fn func(float64) float64) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var x starlark.Value
+		if err := starlark.UnpackPositionalArgs(name, args, kwargs, 1, &x); err != nil {
+			return starlark.None, err
+		}
+		if i, ok := x.(starlark.Int); ok {
+			return i, nil
+		}
+		f, err := toFloat(name, x)
+		if err != nil {
+			return starlark.None, err
+		}
+		return starlark.NumberToInt(starlark.Float(fn(f)))
+	})
+}
+
+func newMathUnaryFunc(name string, fn func(float64) float64) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var x starlark.Value
+		if err := starlark.UnpackPositionalArgs(name, args, kwargs, 1, &x); err != nil {
+			return starlark.None, err
+		}
+		f, err := toFloat(name, x)
+		if err != nil {
+			return starlark.None, err
+		}
+		return starlark.Float(fn(f)), nil
+	})
+}
+
+func newMathBinaryFunc(name string, fn func(float64, float64) float64) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var x, y starlark.Value
+		if err := starlark.UnpackPositionalArgs(name, args, kwargs, 2, &x, &y); err != nil {
+			return starlark.None, err
+		}
+		fx, err := toFloat(name, x)
+		if err != nil {
+			return starlark.None, err
+		}
+		fy, err := toFloat(name, y)
+		if err != nil {
+			return starlark.None, err
+		}
+		return starlark.Float(fn(fx, fy)), nil
+	})
+}
+
+func mathLog(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x, base starlark.Value = nil, starlark.Float(math.E)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "x", &x, "base?", &base); err != nil {
+		return starlark.None, err
+	}
+	fx, err := toFloat(b.Name(), x)
+	if err != nil {
+		return starlark.None, err
+	}
+	fbase, err := toFloat(b.Name(), base)
+	if err != nil {
+		return starlark.None, err
+	}
+	return starlark.Float(math.Log(fx) / math.Log(fbase)), nil
+}
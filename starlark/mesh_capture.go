@@ -0,0 +1,464 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+const (
+	// meshReportFile is the report of the control plane and proxies saved by mesh_capture
+	meshReportFile = "report.txt"
+	// meshAuto detects the service meshes installed in the cluster
+	meshAuto = "auto"
+)
+
+// meshDump is a file saved from the admin endpoint of a proxy
+type meshDump struct {
+	path string
+	file string
+}
+
+// meshFlavor describes how the control plane and the proxies of a service mesh are found, and what is saved
+// from the admin endpoint of the proxies
+type meshFlavor struct {
+	name string
+	// controlPlaneLabels are alternative selectors of the control plane pods
+	controlPlaneLabels []string
+	// proxyLabel selects the pods with a proxy
+	proxyLabel string
+	// groups are the API groups of the custom resources of the mesh
+	groups    []string
+	adminPort int
+	dumps     []meshDump
+	// certExpiry returns the expiration of the workload certificate of a proxy, from its dumps
+	certExpiry func(dumps [][]byte) (time.Time, error)
+}
+
+// meshFlavors are the supported service meshes, in the order they are detected
+var meshFlavors = []meshFlavor{
+	{
+		name:               "istio",
+		controlPlaneLabels: []string{"app=istiod", "app=istio-ingressgateway", "app=istio-egressgateway"},
+		proxyLabel:         "security.istio.io/tlsMode=istio",
+		groups: []string{
+			"networking.istio.io", "security.istio.io", "telemetry.istio.io", "extensions.istio.io", "install.istio.io",
+		},
+		adminPort: 15000,
+		dumps:     []meshDump{{path: "/certs", file: "certs.json"}, {path: "/config_dump", file: "config_dump.json"}},
+		certExpiry: func(dumps [][]byte) (time.Time, error) {
+			return envoyCertExpiry(dumps[0])
+		},
+	},
+	{
+		name:               "linkerd",
+		controlPlaneLabels: []string{"linkerd.io/control-plane-component"},
+		proxyLabel:         "linkerd.io/control-plane-ns",
+		groups:             []string{"linkerd.io", "policy.linkerd.io", "split.smi-spec.io"},
+		adminPort:          4191,
+		dumps:              []meshDump{{path: "/metrics", file: "metrics.txt"}},
+		certExpiry: func(dumps [][]byte) (time.Time, error) {
+			return linkerdCertExpiry(dumps[0])
+		},
+	},
+}
+
+func getMeshFlavor(name string) (meshFlavor, bool) {
+	for _, flavor := range meshFlavors {
+		if flavor.name == name {
+			return flavor, true
+		}
+	}
+	return meshFlavor{}, false
+}
+
+// meshProxy is the state of the proxy of a meshed pod
+type meshProxy struct {
+	mesh       string
+	namespace  string
+	pod        string
+	certExpiry time.Time
+	err        error
+}
+
+// daysLeft returns the number of days before the expiration of the workload certificate of the proxy
+func (p meshProxy) daysLeft(now time.Time) int {
+	return int(math.Floor(p.certExpiry.Sub(now).Hours() / 24))
+}
+
+func (p meshProxy) toStarlarkStruct(now time.Time) *starlarkstruct.Struct {
+	var expiry, errMsg string
+	daysLeft := 0
+	if p.err != nil {
+		errMsg = p.err.Error()
+	} else {
+		expiry = p.certExpiry.UTC().Format(time.RFC3339)
+		daysLeft = p.daysLeft(now)
+	}
+	return starlarkstruct.FromStringDict(
+		starlark.String("mesh_proxy"),
+		starlark.StringDict{
+			"mesh":        starlark.String(p.mesh),
+			"namespace":   starlark.String(p.namespace),
+			"pod":         starlark.String(p.pod),
+			"cert_expiry": starlark.String(expiry),
+			"days_left":   starlark.MakeInt(daysLeft),
+			"error":       starlark.String(errMsg),
+		})
+}
+
+// meshCaptureFunc is a built-in starlark function that collects the state of the service meshes of the cluster:
+// the custom resources of the mesh, the logs of the control plane pods, and, for a sample of the meshed pods,
+// the configuration dumps of their proxy from its admin endpoint (the equivalent of istioctl proxy-config), and
+// the expiration of their mTLS workload certificate. Files are saved under <workdir>/mesh.
+// Starlark format: mesh_capture([mesh="auto"][, namespaces=["ns"]][, proxies=10][, timeout="30s"][, workdir=path][, kube_config=kube_config()])
+func meshCaptureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir, mesh string
+	var namespaces *starlark.List
+	proxyCount := defaults.meshProxies
+	var timeoutVal starlark.Value
+	var kubeConfig *starlarkstruct.Struct
+
+	if err := starlark.UnpackArgs(
+		identifiers.meshCapture, args, kwargs,
+		"mesh?", &mesh,
+		"namespaces?", &namespaces,
+		"proxies?", &proxyCount,
+		"timeout?", &timeoutVal,
+		"workdir?", &workdir,
+		"kube_config?", &kubeConfig,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.meshCapture, err)
+	}
+
+	if len(mesh) == 0 {
+		mesh = meshAuto
+	}
+	flavors := meshFlavors
+	if mesh != meshAuto {
+		f, ok := getMeshFlavor(mesh)
+		if !ok {
+			return starlark.None, fmt.Errorf("%s: unsupported mesh %q (expecting istio, linkerd, or auto)", identifiers.meshCapture, mesh)
+		}
+		flavors = []meshFlavor{f}
+	}
+	if proxyCount < 0 {
+		return starlark.None, fmt.Errorf("%s: proxies must not be negative", identifiers.meshCapture)
+	}
+	timeout, err := toDuration(timeoutVal, defaults.meshTimeout)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: timeout: %s", identifiers.meshCapture, err)
+	}
+
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+
+	if kubeConfig == nil {
+		kubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
+	}
+	path, err := getKubeConfigFromStruct(kubeConfig)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "failed to kubeconfig")
+	}
+	client, err := k8s.New(path)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "could not initialize search client")
+	}
+
+	resultDir := filepath.Join(workdir, "mesh")
+	var captureErrs []string
+
+	// control planes
+	type controlPlanePod struct {
+		mesh  string
+		label string
+		pod   corev1.Pod
+	}
+	var controlPlane []controlPlanePod
+	var found []meshFlavor
+	for _, f := range flavors {
+		seen := make(map[string]bool)
+		for _, label := range f.controlPlaneLabels {
+			pods, err := client.RunningPods("", []string{label})
+			if err != nil {
+				captureErrs = append(captureErrs, fmt.Sprintf("%s: %s", f.name, err))
+				continue
+			}
+			for _, pod := range pods {
+				if key := pod.Namespace + "/" + pod.Name; !seen[key] {
+					seen[key] = true
+					controlPlane = append(controlPlane, controlPlanePod{mesh: f.name, label: label, pod: pod})
+				}
+			}
+		}
+		if len(seen) > 0 {
+			found = append(found, f)
+		}
+	}
+	if len(found) == 0 {
+		captureErrs = append(captureErrs, fmt.Sprintf("no %s service mesh found", mesh))
+	}
+
+	// custom resources, and logs of the control plane
+	var groups []string
+	for _, f := range found {
+		groups = append(groups, f.groups...)
+	}
+	if len(groups) > 0 {
+		objects, err := client.Search(k8s.SearchParams{Groups: groups})
+		if err != nil {
+			captureErrs = append(captureErrs, fmt.Sprintf("objects: %s", err))
+		} else if len(objects) > 0 {
+			resultWriter, err := k8s.NewResultWriter(resultDir, "objects", getOutputFormat(thread), client.CoreRest)
+			if err != nil {
+				return starlark.None, fmt.Errorf("%s: %s", identifiers.meshCapture, err)
+			}
+			if err := resultWriter.Write(objects); err != nil {
+				captureErrs = append(captureErrs, fmt.Sprintf("objects: %s", err))
+			}
+		}
+	}
+	logged := make(map[string]bool)
+	for _, c := range controlPlane {
+		key := c.pod.Namespace + " " + c.label
+		if logged[key] {
+			continue
+		}
+		logged[key] = true
+		if _, _, err := write(resultDir, "logs", "", k8s.LayoutKubeCapture, client, nil, 0, k8s.SearchParams{
+			Namespaces: []string{c.pod.Namespace},
+			Labels:     []string{c.label},
+		}); err != nil {
+			captureErrs = append(captureErrs, fmt.Sprintf("logs (%s): %s", c.label, err))
+		}
+	}
+
+	// proxies
+	var proxies []meshProxy
+	for _, f := range found {
+		pods, err := meshProxyPods(client, f, toSlice(namespaces), proxyCount)
+		if err != nil {
+			captureErrs = append(captureErrs, fmt.Sprintf("%s proxies: %s", f.name, err))
+			continue
+		}
+		for _, pod := range pods {
+			proxy := dumpMeshProxy(client, f, pod, filepath.Join(resultDir, "proxies", f.name), timeout)
+			if proxy.err != nil {
+				logrus.Warnf("%s: proxy of %s/%s: %s", identifiers.meshCapture, proxy.namespace, proxy.pod, proxy.err)
+			}
+			proxies = append(proxies, proxy)
+		}
+	}
+
+	now := time.Now()
+	var controlPlaneVals, proxyVals, meshVals []starlark.Value
+	var controlPlanePods []corev1.Pod
+	for _, f := range found {
+		meshVals = append(meshVals, starlark.String(f.name))
+	}
+	for _, c := range controlPlane {
+		controlPlaneVals = append(controlPlaneVals, starlark.String(fmt.Sprintf("%s/%s", c.pod.Namespace, c.pod.Name)))
+		controlPlanePods = append(controlPlanePods, c.pod)
+	}
+	for _, p := range proxies {
+		if p.err == nil && p.daysLeft(now) < defaults.meshCertWarnDays {
+			logrus.Warnf("%s: workload certificate of %s/%s expires at %s", identifiers.meshCapture, p.namespace, p.pod, p.certExpiry.UTC().Format(time.RFC3339))
+		}
+		proxyVals = append(proxyVals, p.toStarlarkStruct(now))
+	}
+
+	reportFile := filepath.Join(resultDir, meshReportFile)
+	if err := writeMeshReport(reportFile, controlPlanePods, proxies, now); err != nil {
+		captureErrs = append(captureErrs, fmt.Sprintf("report: %s", err))
+	}
+	var captureErr string
+	if len(captureErrs) > 0 {
+		captureErr = strings.Join(captureErrs, "; ")
+		logrus.Errorf("%s: %s", identifiers.meshCapture, captureErr)
+	}
+
+	return starlarkstruct.FromStringDict(
+		starlark.String(identifiers.meshCapture),
+		starlark.StringDict{
+			"file":          starlark.String(resultDir),
+			"report":        starlark.String(reportFile),
+			"meshes":        starlark.NewList(meshVals),
+			"control_plane": starlark.NewList(controlPlaneVals),
+			"proxies":       starlark.NewList(proxyVals),
+			"error":         starlark.String(captureErr),
+		}), nil
+}
+
+// meshProxyPods returns up to count running pods with a proxy of the mesh, in the namespaces (all when empty)
+func meshProxyPods(client *k8s.Client, flavor meshFlavor, namespaces []string, count int) ([]corev1.Pod, error) {
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	var pods []corev1.Pod
+	for _, ns := range namespaces {
+		nsPods, err := client.RunningPods(ns, []string{flavor.proxyLabel})
+		if err != nil {
+			return nil, err
+		}
+		pods = append(pods, nsPods...)
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Namespace != pods[j].Namespace {
+			return pods[i].Namespace < pods[j].Namespace
+		}
+		return pods[i].Name < pods[j].Name
+	})
+	if len(pods) > count {
+		logrus.Infof("%s: capturing %d of %d %s proxies", identifiers.meshCapture, count, len(pods), flavor.name)
+		pods = pods[:count]
+	}
+	return pods, nil
+}
+
+// dumpMeshProxy saves the dumps of the admin endpoint of the proxy of the pod under dir/<namespace>_<pod>,
+// and returns the expiration of its workload certificate
+func dumpMeshProxy(client *k8s.Client, flavor meshFlavor, pod corev1.Pod, dir string, timeout time.Duration) meshProxy {
+	proxy := meshProxy{mesh: flavor.name, namespace: pod.Namespace, pod: pod.Name}
+	var paths []string
+	for _, dump := range flavor.dumps {
+		paths = append(paths, dump.path)
+	}
+	bodies, err := getPodPaths(client, pod.Namespace, pod.Name, flavor.adminPort, paths, timeout)
+	if err != nil {
+		proxy.err = err
+		return proxy
+	}
+
+	proxyDir := filepath.Join(dir, fmt.Sprintf("%s_%s", pod.Namespace, pod.Name))
+	if err := os.MkdirAll(proxyDir, 0744); err != nil && !os.IsExist(err) {
+		proxy.err = err
+		return proxy
+	}
+	for i, dump := range flavor.dumps {
+		if err := captureOutput(bytes.NewReader(bodies[i]), filepath.Join(proxyDir, dump.file), ""); err != nil {
+			proxy.err = err
+			return proxy
+		}
+	}
+	proxy.certExpiry, proxy.err = flavor.certExpiry(bodies)
+	return proxy
+}
+
+// envoyCertExpiry returns the earliest expiration of the certificate chains of the /certs dump of an Envoy proxy
+func envoyCertExpiry(certs []byte) (time.Time, error) {
+	var dump struct {
+		Certificates []struct {
+			CertChain []struct {
+				ExpirationTime string `json:"expiration_time"`
+			} `json:"cert_chain"`
+		} `json:"certificates"`
+	}
+	if err := json.Unmarshal(certs, &dump); err != nil {
+		return time.Time{}, errors.Wrap(err, "invalid certs dump")
+	}
+	var expiry time.Time
+	for _, cert := range dump.Certificates {
+		for _, chain := range cert.CertChain {
+			t, err := time.Parse(time.RFC3339, chain.ExpirationTime)
+			if err != nil {
+				return time.Time{}, errors.Wrap(err, "invalid certificate expiration")
+			}
+			if expiry.IsZero() || t.Before(expiry) {
+				expiry = t
+			}
+		}
+	}
+	if expiry.IsZero() {
+		return expiry, fmt.Errorf("no workload certificate loaded")
+	}
+	return expiry, nil
+}
+
+// linkerdCertExpiry returns the expiration of the workload certificate of a Linkerd proxy, from its metrics
+func linkerdCertExpiry(metrics []byte) (time.Time, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(metrics))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "identity_cert_expiration_timestamp_seconds" {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return time.Time{}, errors.Wrap(err, "invalid certificate expiration")
+		}
+		return time.Unix(int64(seconds), 0), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, fmt.Errorf("no workload certificate loaded")
+}
+
+// writeMeshReport saves the report of the control plane pods and proxies at path
+func writeMeshReport(path string, controlPlane []corev1.Pod, proxies []meshProxy, now time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0744); err != nil && !os.IsExist(err) {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return printMeshReport(file, controlPlane, proxies, now)
+}
+
+func printMeshReport(w io.Writer, controlPlane []corev1.Pod, proxies []meshProxy, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTROL PLANE")
+	fmt.Fprintln(tw, "NAMESPACE\tPOD\tREADY\tRESTARTS")
+	for _, pod := range controlPlane {
+		var ready int
+		var restarts int32
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Ready {
+				ready++
+			}
+			restarts += status.RestartCount
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d/%d\t%d\n", pod.Namespace, pod.Name, ready, len(pod.Spec.Containers), restarts)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "PROXIES")
+	fmt.Fprintln(tw, "MESH\tNAMESPACE\tPOD\tCERT EXPIRY\tDAYS LEFT\tERROR")
+	for _, p := range proxies {
+		if p.err != nil {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t-\t-\t%s\n", p.mesh, p.namespace, p.pod, p.err)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t\n", p.mesh, p.namespace, p.pod, p.certExpiry.UTC().Format(time.RFC3339), p.daysLeft(now))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEnvoyCertExpiry(t *testing.T) {
+	certs := `{"certificates": [
+		{"ca_cert": [{"expiration_time": "2030-01-01T00:00:00Z"}], "cert_chain": [{"expiration_time": "2020-06-02T10:00:00Z"}]},
+		{"cert_chain": [{"expiration_time": "2020-06-01T10:00:00Z"}]}
+	]}`
+	expiry, err := envoyCertExpiry([]byte(certs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expiry.Format(time.RFC3339) != "2020-06-01T10:00:00Z" {
+		t.Errorf("unexpected expiry: %s", expiry)
+	}
+
+	if _, err := envoyCertExpiry([]byte(`{"certificates": [{"ca_cert": [{"expiration_time": "2030-01-01T00:00:00Z"}]}]}`)); err == nil {
+		t.Error("expecting error without workload certificate")
+	}
+}
+
+func TestLinkerdCertExpiry(t *testing.T) {
+	metrics := "# HELP identity_cert_expiration_timestamp_seconds Time when the this proxy's current mTLS identity certificate will expire (in seconds since the UNIX epoch).\n" +
+		"# TYPE identity_cert_expiration_timestamp_seconds gauge\n" +
+		"identity_cert_expiration_timestamp_seconds 1591005600\n" +
+		"request_total{direction=\"inbound\"} 12\n"
+	expiry, err := linkerdCertExpiry([]byte(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expiry.Unix() != 1591005600 {
+		t.Errorf("unexpected expiry: %s", expiry)
+	}
+
+	if _, err := linkerdCertExpiry([]byte("request_total 12\n")); err == nil {
+		t.Error("expecting error without certificate metric")
+	}
+}
+
+func TestMeshReport(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	controlPlane := []corev1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "istiod-5c7f"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "discovery"}}},
+		Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Ready: true, RestartCount: 2}}},
+	}}
+	proxies := []meshProxy{
+		{mesh: "istio", namespace: "shop", pod: "cart-0", certExpiry: now.Add(36 * time.Hour)},
+		{mesh: "istio", namespace: "shop", pod: "cart-1", err: fmt.Errorf("no workload certificate loaded")},
+	}
+	if days := proxies[0].daysLeft(now); days != 1 {
+		t.Errorf("expecting 1 day left, got %d", days)
+	}
+
+	var out bytes.Buffer
+	if err := printMeshReport(&out, controlPlane, proxies, now); err != nil {
+		t.Fatal(err)
+	}
+	report := out.String()
+	for _, expected := range []string{"istiod-5c7f  1/1    2", "2020-06-02T12:00:00Z", "no workload certificate loaded"} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("expecting %q in report:\n%s", expected, report)
+		}
+	}
+}
+
+func TestMeshCaptureArgs(t *testing.T) {
+	err := New().Exec("test.star", strings.NewReader(`mesh_capture(mesh="consul")`))
+	if err == nil || !strings.Contains(err.Error(), "unsupported mesh") {
+		t.Errorf("expecting unsupported mesh error, got %v", err)
+	}
+	err = New().Exec("test.star", strings.NewReader(`mesh_capture(proxies=-1)`))
+	if err == nil || !strings.Contains(err.Error(), "proxies") {
+		t.Errorf("expecting proxies error, got %v", err)
+	}
+}
@@ -0,0 +1,352 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// mtuPingOverhead is the size of the IPv4 and ICMP headers added to the payload of a ping
+const mtuPingOverhead = 28
+
+// overlayOverheads is the encapsulation overhead, in bytes, of the overlay interfaces of common CNIs
+var overlayOverheads = map[string]int{
+	"flannel.1":      50, // VXLAN
+	"vxlan.calico":   50,
+	"cilium_vxlan":   50,
+	"cilium_geneve":  50, // Geneve, without options
+	"genev_sys_6081": 50,
+	"tunl0":          20, // IP-in-IP
+	"wireguard.cali": 60,
+	"flannel-wg":     60,
+	"cilium_wg0":     60,
+}
+
+// mtuProbe returns a script that prints the MTU of each interface of a POSIX host, its default interface, then
+// the path MTU to each target, found with do-not-fragment pings of increasing size (requires iputils ping), as
+// name=value lines:
+//
+//	link=<interface> <mtu>
+//	default=<interface>
+//	path=<target> <interface> <mtu> <path mtu>|unreachable
+func mtuProbe(targets []string) string {
+	lines := []string{
+		`ip -o link show | awk '{name = $2; sub(/:$/, "", name); sub(/@.*/, "", name); for (i = 3; i < NF; i++) if ($i == "mtu") print "link=" name " " $(i + 1)}'`,
+		`echo "default=$(ip -o route show default 2>/dev/null | sed -n 's/.* dev \([^ ]*\).*/\1/p' | head -n 1)"`,
+	}
+	if len(targets) == 0 {
+		return strings.Join(lines, "\n")
+	}
+	quoted := make([]string, len(targets))
+	for i, target := range targets {
+		quoted[i] = shellQuote(target)
+	}
+	return strings.Join(append(lines,
+		`if ! ping -c 1 -W 1 -M do -s 0 127.0.0.1 >/dev/null 2>&1; then echo "error=ping does not support do-not-fragment pings (-M do)"; exit 0; fi`,
+		fmt.Sprintf(`df_ping() { ping -c 1 -W 1 -M do -s $(($2 - %d)) "$1" >/dev/null 2>&1; }`, mtuPingOverhead),
+		fmt.Sprintf(`for target in %s; do`, strings.Join(quoted, " ")),
+		`  addr=$(getent ahostsv4 "$target" 2>/dev/null | awk '{print $1; exit}'); [ -n "$addr" ] || addr=$target`,
+		`  dev=$(ip -o route get "$addr" 2>/dev/null | sed -n 's/.* dev \([^ ]*\).*/\1/p')`,
+		`  mtu=$(cat "/sys/class/net/$dev/mtu" 2>/dev/null || echo 1500)`,
+		fmt.Sprintf(`  if ! df_ping "$addr" %d; then echo "path=$target ${dev:--} $mtu unreachable"; continue; fi`, mtuPingOverhead),
+		fmt.Sprintf(`  ok=%d; bad=$mtu`, mtuPingOverhead),
+		`  if df_ping "$addr" "$mtu"; then ok=$mtu; fi`,
+		`  while [ "$ok" -ne "$mtu" ] && [ $((bad - ok)) -gt 1 ]; do`,
+		`    mid=$(((ok + bad) / 2)); if df_ping "$addr" "$mid"; then ok=$mid; else bad=$mid; fi`,
+		`  done`,
+		`  echo "path=$target ${dev:--} $mtu $ok"`,
+		`done`,
+	), "\n")
+}
+
+// mtuPath is the path MTU from a compute resource to a target
+type mtuPath struct {
+	source    string
+	target    string
+	iface     string
+	mtu       int
+	pathMTU   int
+	reachable bool
+}
+
+func (p mtuPath) toStarlarkStruct() *starlarkstruct.Struct {
+	var pathMTU starlark.Value = starlark.None
+	if p.reachable {
+		pathMTU = starlark.MakeInt(p.pathMTU)
+	}
+	return starlarkstruct.FromStringDict(
+		starlark.String("mtu_path"),
+		starlark.StringDict{
+			"source":    starlark.String(p.source),
+			"target":    starlark.String(p.target),
+			"interface": starlark.String(p.iface),
+			"mtu":       starlark.MakeInt(p.mtu),
+			"path_mtu":  pathMTU,
+			"reachable": starlark.Bool(p.reachable),
+		})
+}
+
+// mtuNode is the interfaces of a compute resource, and the path MTUs to the targets
+type mtuNode struct {
+	resource string
+	links    map[string]int
+	// defaultLink is the interface of the default route, the underlay of the overlay interfaces
+	defaultLink string
+	paths       []mtuPath
+	err         error
+}
+
+func (n mtuNode) toStarlarkStruct() *starlarkstruct.Struct {
+	links := new(starlark.Dict)
+	for _, name := range sortedLinks(n.links) {
+		links.SetKey(starlark.String(name), starlark.MakeInt(n.links[name]))
+	}
+	var errMsg string
+	if n.err != nil {
+		errMsg = n.err.Error()
+	}
+	return starlarkstruct.FromStringDict(
+		starlark.String("mtu_node"),
+		starlark.StringDict{
+			"resource":  starlark.String(n.resource),
+			"links":     links,
+			"interface": starlark.String(n.defaultLink),
+			"error":     starlark.String(errMsg),
+		})
+}
+
+// mtuCheckFunc is a built-in starlark function that collects the MTU of the interfaces of each compute resource,
+// and measures the path MTU from each resource to the others (or to the targets) with do-not-fragment pings of
+// increasing size. Mismatches are reported: paths dropping packets smaller than the MTU of their interface, the
+// MTU of the default interfaces or of the overlay interfaces differing between resources, and overlay
+// interfaces with an MTU leaving no room for their encapsulation overhead on the default interface.
+// Starlark format: mtu_check([resources=resources][, targets=["address"]][, ssh_config=ssh_config()])
+func mtuCheckFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var resources, targetList *starlark.List
+	var sshCfgVal starlark.Value
+	if err := starlark.UnpackArgs(
+		identifiers.mtuCheck, args, kwargs,
+		"resources?", &resources,
+		"targets?", &targetList,
+		"ssh_config?", &sshCfgVal,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.mtuCheck, err)
+	}
+
+	if resources == nil {
+		res, err := getResourcesFromThread(thread)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.mtuCheck, err)
+		}
+		resources = res
+	}
+	if sshCfgVal != nil {
+		sshCfg, err := getSSHConfig(thread, sshCfgVal)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.mtuCheck, err)
+		}
+		resources = withSSHConfig(resources, sshCfg)
+	}
+
+	// by default, each resource pings the others
+	targets := toSlice(targetList)
+	peers := targetList == nil
+	if peers {
+		for i := 0; i < resources.Len(); i++ {
+			if res, ok := resources.Index(i).(*starlarkstruct.Struct); ok {
+				if host := resourceHost(res); len(host) > 0 {
+					targets = append(targets, host)
+				}
+			}
+		}
+	}
+
+	var nodes []mtuNode
+	for i := 0; i < resources.Len(); i++ {
+		res := resources.Index(i)
+		probeTargets := targets
+		if peers {
+			probeTargets = nil
+			host := ""
+			if s, ok := res.(*starlarkstruct.Struct); ok {
+				host = resourceHost(s)
+			}
+			for _, target := range targets {
+				if target != host {
+					probeTargets = append(probeTargets, target)
+				}
+			}
+		}
+		results, err := execRun(command{line: mtuProbe(probeTargets), shell: shellSh}, starlark.NewList([]starlark.Value{res}))
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.mtuCheck, err)
+		}
+		for _, result := range results {
+			node := mtuNode{resource: result.resource, err: result.err}
+			if result.err == nil {
+				node = parseMTUProbe(result.resource, result.stdout)
+			}
+			if node.err != nil {
+				logrus.Errorf("%s: %s: %s", identifiers.mtuCheck, node.resource, node.err)
+			}
+			nodes = append(nodes, node)
+		}
+	}
+
+	var nodeVals, pathVals, mismatches []starlark.Value
+	for _, node := range nodes {
+		nodeVals = append(nodeVals, node.toStarlarkStruct())
+		for _, path := range node.paths {
+			if !path.reachable {
+				logrus.Warnf("%s: %s cannot reach %s", identifiers.mtuCheck, path.source, path.target)
+			}
+			pathVals = append(pathVals, path.toStarlarkStruct())
+		}
+	}
+	for _, mismatch := range findMTUMismatches(nodes) {
+		logrus.Warnf("%s: %s", identifiers.mtuCheck, mismatch)
+		mismatches = append(mismatches, starlark.String(mismatch))
+	}
+
+	return starlarkstruct.FromStringDict(
+		starlark.String(identifiers.mtuCheck),
+		starlark.StringDict{
+			"nodes":      starlark.NewList(nodeVals),
+			"paths":      starlark.NewList(pathVals),
+			"mismatches": starlark.NewList(mismatches),
+		}), nil
+}
+
+// parseMTUProbe returns the interfaces and path MTUs printed by the MTU probe
+func parseMTUProbe(resource, output string) mtuNode {
+	node := mtuNode{resource: resource, links: make(map[string]int)}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(scanner.Text()), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name, value := parts[0], strings.TrimSpace(parts[1])
+		fields := strings.Fields(value)
+		switch name {
+		case "link":
+			if len(fields) == 2 {
+				if mtu, err := strconv.Atoi(fields[1]); err == nil {
+					node.links[fields[0]] = mtu
+				}
+			}
+		case "default":
+			node.defaultLink = value
+		case "path":
+			if len(fields) != 4 {
+				continue
+			}
+			path := mtuPath{source: resource, target: fields[0], iface: fields[1]}
+			path.mtu, _ = strconv.Atoi(fields[2])
+			if pathMTU, err := strconv.Atoi(fields[3]); err == nil {
+				path.pathMTU, path.reachable = pathMTU, true
+			}
+			node.paths = append(node.paths, path)
+		case "error":
+			node.err = fmt.Errorf("%s", value)
+		}
+	}
+	if len(node.links) == 0 && node.err == nil {
+		node.err = fmt.Errorf("no interface found in probe output")
+	}
+	return node
+}
+
+// findMTUMismatches returns the paths with a path MTU below the MTU of their interface, the interfaces with
+// different MTUs between resources, and the overlay interfaces too large for their underlay
+func findMTUMismatches(nodes []mtuNode) []string {
+	var mismatches []string
+	for _, node := range nodes {
+		for _, path := range node.paths {
+			if path.reachable && path.pathMTU < path.mtu {
+				mismatches = append(mismatches, fmt.Sprintf(
+					"%s -> %s: path MTU %d is below the MTU of %s (%d), larger packets are dropped",
+					path.source, path.target, path.pathMTU, path.iface, path.mtu))
+			}
+		}
+	}
+
+	// MTU of the default and overlay interfaces of each resource
+	underlay := make(map[string]string)
+	overlays := make(map[string]map[string]int)
+	for _, node := range nodes {
+		if mtu, ok := node.links[node.defaultLink]; ok {
+			underlay[fmt.Sprintf("%s %s", node.resource, node.defaultLink)] = strconv.Itoa(mtu)
+		}
+		for _, name := range sortedLinks(node.links) {
+			overhead, ok := overlayOverheads[name]
+			if !ok {
+				continue
+			}
+			if overlays[name] == nil {
+				overlays[name] = make(map[string]int)
+			}
+			overlays[name][node.resource] = node.links[name]
+			if underlayMTU, ok := node.links[node.defaultLink]; ok && node.links[name]+overhead > underlayMTU {
+				mismatches = append(mismatches, fmt.Sprintf(
+					"%s: MTU of %s (%d) exceeds the MTU of %s (%d) minus the %d bytes of encapsulation overhead",
+					node.resource, name, node.links[name], node.defaultLink, underlayMTU, overhead))
+			}
+		}
+	}
+	if mismatch := mtuDifference("default interfaces", underlay); len(mismatch) > 0 {
+		mismatches = append(mismatches, mismatch)
+	}
+	var overlayNames []string
+	for name := range overlays {
+		overlayNames = append(overlayNames, name)
+	}
+	sort.Strings(overlayNames)
+	for _, name := range overlayNames {
+		mtus := make(map[string]string)
+		for resource, mtu := range overlays[name] {
+			mtus[resource] = strconv.Itoa(mtu)
+		}
+		if mismatch := mtuDifference(name, mtus); len(mismatch) > 0 {
+			mismatches = append(mismatches, mismatch)
+		}
+	}
+	return mismatches
+}
+
+// mtuDifference returns a mismatch listing the MTUs, when they are not all the same
+func mtuDifference(desc string, mtus map[string]string) string {
+	distinct := make(map[string]bool)
+	var keys []string
+	for key, mtu := range mtus {
+		distinct[mtu] = true
+		keys = append(keys, key)
+	}
+	if len(distinct) < 2 {
+		return ""
+	}
+	sort.Strings(keys)
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = fmt.Sprintf("%s %s", key, mtus[key])
+	}
+	return fmt.Sprintf("MTU of %s differs between resources: %s", desc, strings.Join(values, ", "))
+}
+
+func sortedLinks(links map[string]int) []string {
+	var names []string
+	for name := range links {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
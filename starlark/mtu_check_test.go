@@ -0,0 +1,106 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMTUProbe(t *testing.T) {
+	output := strings.Join([]string{
+		"link=lo 65536",
+		"link=eth0 1500",
+		"link=flannel.1 1450",
+		"default=eth0",
+		"path=10.0.0.11 eth0 1500 1500",
+		"path=10.0.0.12 eth0 1500 1400",
+		"path=10.0.0.13 - 1500 unreachable",
+	}, "\n")
+	node := parseMTUProbe("10.0.0.10", output)
+	if node.err != nil {
+		t.Fatalf("unexpected error: %s", node.err)
+	}
+	if len(node.links) != 3 || node.links["flannel.1"] != 1450 {
+		t.Errorf("unexpected links: %v", node.links)
+	}
+	if node.defaultLink != "eth0" {
+		t.Errorf("unexpected default interface: %s", node.defaultLink)
+	}
+	if len(node.paths) != 3 {
+		t.Fatalf("expected 3 paths, got %d", len(node.paths))
+	}
+	if p := node.paths[1]; !p.reachable || p.pathMTU != 1400 || p.mtu != 1500 || p.iface != "eth0" {
+		t.Errorf("unexpected path: %+v", p)
+	}
+	if node.paths[2].reachable {
+		t.Errorf("expected %s to be unreachable", node.paths[2].target)
+	}
+
+	if node := parseMTUProbe("10.0.0.10", "error=ping does not support do-not-fragment pings (-M do)\n"); node.err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestFindMTUMismatches(t *testing.T) {
+	tests := []struct {
+		name       string
+		nodes      []mtuNode
+		mismatches []string
+	}{
+		{
+			name: "consistent",
+			nodes: []mtuNode{
+				{
+					resource:    "node-1",
+					links:       map[string]int{"eth0": 1500, "flannel.1": 1450},
+					defaultLink: "eth0",
+					paths:       []mtuPath{{source: "node-1", target: "node-2", iface: "eth0", mtu: 1500, pathMTU: 1500, reachable: true}},
+				},
+				{
+					resource:    "node-2",
+					links:       map[string]int{"eth0": 1500, "flannel.1": 1450},
+					defaultLink: "eth0",
+					paths:       []mtuPath{{source: "node-2", target: "node-1", iface: "eth0", mtu: 1500, reachable: false}},
+				},
+			},
+		},
+		{
+			name: "black hole and overlay too large",
+			nodes: []mtuNode{
+				{
+					resource:    "node-1",
+					links:       map[string]int{"ens5": 9001, "vxlan.calico": 8951},
+					defaultLink: "ens5",
+					paths:       []mtuPath{{source: "node-1", target: "node-2", iface: "ens5", mtu: 9001, pathMTU: 1500, reachable: true}},
+				},
+				{
+					resource:    "node-2",
+					links:       map[string]int{"ens5": 1500, "vxlan.calico": 1500},
+					defaultLink: "ens5",
+				},
+			},
+			mismatches: []string{
+				"node-1 -> node-2: path MTU 1500 is below the MTU of ens5 (9001), larger packets are dropped",
+				"node-2: MTU of vxlan.calico (1500) exceeds the MTU of ens5 (1500) minus the 50 bytes of encapsulation overhead",
+				"MTU of default interfaces differs between resources: node-1 ens5 9001, node-2 ens5 1500",
+				"MTU of vxlan.calico differs between resources: node-1 8951, node-2 1500",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mismatches := findMTUMismatches(test.nodes)
+			if len(mismatches) != len(test.mismatches) {
+				t.Fatalf("expected mismatches %q, got %q", test.mismatches, mismatches)
+			}
+			for i := range mismatches {
+				if mismatches[i] != test.mismatches[i] {
+					t.Errorf("expected mismatch %q, got %q", test.mismatches[i], mismatches[i])
+				}
+			}
+		})
+	}
+}
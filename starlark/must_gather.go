@@ -0,0 +1,125 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+// mustGatherFunc is a built-in starlark function that collects the resource set of `oc adm must-gather`
+// on OpenShift clusters: the cluster operators, cluster versions, machine configs, machine config pools,
+// and nodes, and the objects and pod logs of the openshift-* namespaces. Files are saved in the
+// must-gather layout (see k8s.MustGatherWriter) under <workdir>/must-gather so that bundles can be
+// read by the tools consuming must-gather archives. Kinds not served by the cluster are skipped.
+// Starlark format: must_gather([namespaces=["openshift-*"]][, kinds=["clusteroperators"]][, logs=True][, workdir=path][, kube_config=kube_config()])
+func mustGatherFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir string
+	var namespaces, kinds *starlark.List
+	var kubeConfig *starlarkstruct.Struct
+	logs := true
+
+	if err := starlark.UnpackArgs(
+		identifiers.mustGather, args, kwargs,
+		"namespaces?", &namespaces,
+		"kinds?", &kinds,
+		"logs?", &logs,
+		"workdir?", &workdir,
+		"kube_config?", &kubeConfig,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.mustGather, err)
+	}
+
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+
+	if kubeConfig == nil {
+		kubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
+	}
+	path, err := getKubeConfigFromStruct(kubeConfig)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "failed to kubeconfig")
+	}
+	client, err := k8s.New(path)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "could not initialize search client")
+	}
+
+	clusterKinds := toSlice(kinds)
+	if len(clusterKinds) == 0 {
+		clusterKinds = defaults.mustGatherKinds
+	}
+	nsPatterns := toSlice(namespaces)
+	if len(nsPatterns) == 0 {
+		nsPatterns = defaults.mustGatherNamespaces
+	}
+	what := "objects"
+	if logs {
+		what = "all"
+	}
+
+	resultDir, err := writeMustGather(workdir, what, client, clusterKinds, nsPatterns)
+	if err != nil {
+		logrus.Errorf("%s: %s", identifiers.mustGather, err)
+	}
+
+	return starlarkstruct.FromStringDict(
+		starlark.String(identifiers.mustGather),
+		starlark.StringDict{
+			"file": starlark.String(resultDir),
+			"error": func() starlark.String {
+				if err != nil {
+					return starlark.String(err.Error())
+				}
+				return ""
+			}(),
+		}), nil
+}
+
+// writeMustGather saves the cluster-scoped kinds and the objects (and pod logs when what is "all")
+// of the namespaces matching the patterns in the must-gather layout
+func writeMustGather(workdir, what string, client *k8s.Client, clusterKinds, namespaces []string) (string, error) {
+	resultWriter, err := k8s.NewMustGatherWriter(workdir, what, client.CoreRest)
+	if err != nil {
+		return filepath.Join(workdir, k8s.MustGatherDirname), errors.Wrap(err, "failed to initialize writer")
+	}
+
+	searches := []k8s.SearchParams{
+		{Kinds: clusterKinds},
+		{Namespaces: namespaces},
+	}
+	for _, params := range searches {
+		searchResults, err := client.Search(params)
+		if err != nil {
+			return resultWriter.GetResultDir(), err
+		}
+		var found []k8s.SearchResult
+		for _, result := range searchResults {
+			if result.List != nil && len(result.List.Items) > 0 {
+				found = append(found, result)
+			}
+		}
+		if len(found) == 0 {
+			logrus.Debugf("%s: no objects found for kinds %v, namespaces %v", identifiers.mustGather, params.Kinds, params.Namespaces)
+			continue
+		}
+		if err := resultWriter.Write(found); err != nil {
+			return resultWriter.GetResultDir(), errors.Wrap(err, "failed to write search results")
+		}
+	}
+	return resultWriter.GetResultDir(), nil
+}
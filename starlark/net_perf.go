@@ -0,0 +1,324 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// pingRTT matches the round-trip times summary of ping (iputils: rtt min/avg/max/mdev = ..., busybox:
+// round-trip min/avg/max = ...), capturing the average
+var pingRTT = regexp.MustCompile(`min/avg/max[^=]*= *[0-9.]+/([0-9.]+)/`)
+
+// iperfReport is the part of the JSON report of an iperf3 client used by net_perf
+type iperfReport struct {
+	End struct {
+		SumSent struct {
+			BitsPerSecond float64 `json:"bits_per_second"`
+			Retransmits   int     `json:"retransmits"`
+		} `json:"sum_sent"`
+		SumReceived struct {
+			BitsPerSecond float64 `json:"bits_per_second"`
+		} `json:"sum_received"`
+	} `json:"end"`
+	Error string `json:"error"`
+}
+
+// netPerfResult is the throughput and latency measured from a source to a target compute resource
+type netPerfResult struct {
+	source        string
+	target        string
+	bitsPerSecond float64
+	retransmits   int
+	// latency is the average round-trip time, in milliseconds, when hasLatency
+	latency    float64
+	hasLatency bool
+	err        error
+	// report is the JSON report of the iperf3 client, when it ran
+	report string
+}
+
+// netPerfRecord is a result of net_perf saved in <workdir>/net_perf/results.json
+type netPerfRecord struct {
+	Source        string   `json:"source"`
+	Target        string   `json:"target"`
+	BitsPerSecond float64  `json:"bits_per_second"`
+	Retransmits   int      `json:"retransmits"`
+	LatencyMS     *float64 `json:"latency_ms,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+func (r netPerfResult) record() netPerfRecord {
+	record := netPerfRecord{Source: r.source, Target: r.target, BitsPerSecond: r.bitsPerSecond, Retransmits: r.retransmits}
+	if r.hasLatency {
+		latency := r.latency
+		record.LatencyMS = &latency
+	}
+	if r.err != nil {
+		record.Error = r.err.Error()
+	}
+	return record
+}
+
+func (r netPerfResult) toStarlarkStruct() *starlarkstruct.Struct {
+	var latency starlark.Value = starlark.None
+	if r.hasLatency {
+		latency = starlark.Float(r.latency)
+	}
+	var errMsg string
+	if r.err != nil {
+		errMsg = r.err.Error()
+	}
+	return starlarkstruct.FromStringDict(
+		starlark.String("net_perf_result"),
+		starlark.StringDict{
+			"source":          starlark.String(r.source),
+			"target":          starlark.String(r.target),
+			"bits_per_second": starlark.Float(r.bitsPerSecond),
+			"retransmits":     starlark.MakeInt(r.retransmits),
+			"latency_ms":      latency,
+			"error":           starlark.String(errMsg),
+		})
+}
+
+// netPerfFunc is a built-in starlark function that measures the throughput and latency between pairs of
+// compute resources: for each pair, a one-off iperf3 server is started on the target, and an iperf3 client
+// sends data for the duration from the source, after pinging the target. iperf3 must be installed on the
+// resources. Pairs are either each resource and the next one ("ring"), or every pair of resources ("all").
+// The results, and the iperf3 report of each pair, are saved under <workdir>/net_perf. Since it starts
+// servers on the resources, net_perf is rejected in read-only mode.
+// Starlark format: net_perf([resources=resources][, pairs="ring"][, duration=10][, port=5201][, ssh_config=ssh_config()][, workdir=path])
+func netPerfFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir string
+	var resources *starlark.List
+	pairsMode := "ring"
+	duration := defaults.netPerfDuration
+	port := defaults.netPerfPort
+	var sshCfgVal starlark.Value
+	if err := starlark.UnpackArgs(
+		identifiers.netPerf, args, kwargs,
+		"resources?", &resources,
+		"pairs?", &pairsMode,
+		"duration?", &duration,
+		"port?", &port,
+		"ssh_config?", &sshCfgVal,
+		"workdir?", &workdir,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.netPerf, err)
+	}
+
+	if getExecOptions(thread).ReadOnly {
+		return starlark.None, fmt.Errorf("%s: starting iperf3 servers on the resources is rejected in read-only mode", identifiers.netPerf)
+	}
+
+	if duration <= 0 {
+		return starlark.None, fmt.Errorf("%s: duration must be a positive number of seconds", identifiers.netPerf)
+	}
+	if resources == nil {
+		res, err := getResourcesFromThread(thread)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.netPerf, err)
+		}
+		resources = res
+	}
+	if sshCfgVal != nil {
+		sshCfg, err := getSSHConfig(thread, sshCfgVal)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.netPerf, err)
+		}
+		resources = withSSHConfig(resources, sshCfg)
+	}
+	pairs, err := netPerfPairs(pairsMode, resources.Len())
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.netPerf, err)
+	}
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+	dir := filepath.Join(workdir, "net_perf")
+	if err := os.MkdirAll(dir, 0744); err != nil && !os.IsExist(err) {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.netPerf, err)
+	}
+
+	var results []starlark.Value
+	var records []netPerfRecord
+	for _, pair := range pairs {
+		source, ok := resources.Index(pair[0]).(*starlarkstruct.Struct)
+		if !ok {
+			return starlark.None, fmt.Errorf("%s: unexpected resource type", identifiers.netPerf)
+		}
+		target, ok := resources.Index(pair[1]).(*starlarkstruct.Struct)
+		if !ok {
+			return starlark.None, fmt.Errorf("%s: unexpected resource type", identifiers.netPerf)
+		}
+		logrus.Infof("%s: measuring %s -> %s for %ds", identifiers.netPerf, resourceHost(source), resourceHost(target), duration)
+		result, err := measureNetPerf(source, target, duration, port)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.netPerf, err)
+		}
+		if result.err != nil {
+			logrus.Errorf("%s: %s -> %s: %s", identifiers.netPerf, result.source, result.target, result.err)
+		}
+		if len(result.report) > 0 {
+			report := filepath.Join(dir, fmt.Sprintf("%s_%s.json", sanitizeStr(result.source), sanitizeStr(result.target)))
+			if err := ioutil.WriteFile(report, []byte(result.report), 0644); err != nil {
+				return starlark.None, fmt.Errorf("%s: %s", identifiers.netPerf, err)
+			}
+		}
+		results = append(results, result.toStarlarkStruct())
+		records = append(records, result.record())
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.netPerf, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "results.json"), data, 0644); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.netPerf, err)
+	}
+
+	return starlarkstruct.FromStringDict(
+		starlark.String(identifiers.netPerf),
+		starlark.StringDict{
+			"results":  starlark.NewList(results),
+			"duration": starlark.MakeInt(duration),
+		}), nil
+}
+
+// netPerfPairs returns the indexes of the source and target of each pair of n resources: each resource and
+// the next one, the last one with the first (ring), or every pair once (all)
+func netPerfPairs(mode string, n int) ([][2]int, error) {
+	if n < 2 {
+		return nil, fmt.Errorf("at least 2 resources are required, got %d", n)
+	}
+	var pairs [][2]int
+	switch mode {
+	case "ring":
+		count := n
+		if n == 2 {
+			// the pair of the last resource with the first is the first pair
+			count = 1
+		}
+		for i := 0; i < count; i++ {
+			pairs = append(pairs, [2]int{i, (i + 1) % n})
+		}
+	case "all":
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				pairs = append(pairs, [2]int{i, j})
+			}
+		}
+	default:
+		return nil, fmt.Errorf(`pairs must be "ring" or "all", got %q`, mode)
+	}
+	return pairs, nil
+}
+
+// measureNetPerf starts a one-off iperf3 server on the target, then pings it and runs the iperf3 client
+// from the source. An error is only returned when the commands could not be run on the resources.
+func measureNetPerf(source, target *starlarkstruct.Struct, duration, port int) (netPerfResult, error) {
+	result := netPerfResult{source: resourceHost(source), target: resourceHost(target)}
+	targetList := starlark.NewList([]starlark.Value{target})
+	sourceList := starlark.NewList([]starlark.Value{source})
+	address := netPerfAddress(target)
+
+	server := fmt.Sprintf("command -v iperf3 >/dev/null 2>&1 || { echo 'iperf3 not found' >&2; exit 127; }; iperf3 -s -1 -D -p %d && sleep 1", port)
+	serverResults, err := execRun(command{line: server, shell: shellSh}, targetList)
+	if err != nil {
+		return result, err
+	}
+	if len(serverResults) == 0 {
+		return result, fmt.Errorf("unsupported resource %s", result.target)
+	}
+	if serverResults[0].err != nil {
+		result.err = fmt.Errorf("iperf3 server on %s: %s", result.target, commandError(serverResults[0]))
+		return result, nil
+	}
+
+	pingResults, err := execRun(command{line: fmt.Sprintf("ping -c 5 -q %s", shellQuote(address)), shell: shellSh}, sourceList)
+	if err != nil {
+		return result, err
+	}
+	if len(pingResults) > 0 && pingResults[0].err == nil {
+		result.latency, result.hasLatency = parsePingRTT(pingResults[0].stdout)
+	}
+
+	client := fmt.Sprintf("iperf3 -c %s -p %d -t %d -J", shellQuote(address), port, duration)
+	clientResults, err := execRun(command{line: client, shell: shellSh}, sourceList)
+	if err != nil {
+		return result, err
+	}
+	if len(clientResults) == 0 {
+		return result, fmt.Errorf("unsupported resource %s", result.source)
+	}
+	if clientResults[0].err != nil && len(strings.TrimSpace(clientResults[0].stdout)) == 0 {
+		result.err = fmt.Errorf("iperf3 client on %s: %s", result.source, commandError(clientResults[0]))
+	} else {
+		// the report describes the errors of the client
+		result.report = clientResults[0].stdout
+		result.bitsPerSecond, result.retransmits, result.err = parseIperfReport(result.report)
+	}
+	if result.err != nil {
+		// the one-off server is still waiting for a client
+		stop := fmt.Sprintf("pkill -f '[i]perf3 -s -1 -D -p %d'; true", port)
+		if _, err := execRun(command{line: stop, shell: shellSh}, targetList); err != nil {
+			logrus.Errorf("%s: failed to stop the iperf3 server on %s: %s", identifiers.netPerf, result.target, err)
+		}
+	}
+	return result, nil
+}
+
+// netPerfAddress returns the address pinged and measured with iperf3 for the target: its host, without
+// the SSH port of host:port resources
+func netPerfAddress(target *starlarkstruct.Struct) string {
+	host, _ := splitHostPort(resourceHost(target))
+	return host
+}
+
+// parseIperfReport returns the throughput received by the server, and the retransmits, of an iperf3 client
+// JSON report
+func parseIperfReport(output string) (float64, int, error) {
+	var report iperfReport
+	if err := json.Unmarshal([]byte(output), &report); err != nil {
+		return 0, 0, fmt.Errorf("invalid iperf3 report: %s", err)
+	}
+	if len(report.Error) > 0 {
+		return 0, 0, fmt.Errorf("iperf3: %s", report.Error)
+	}
+	return report.End.SumReceived.BitsPerSecond, report.End.SumSent.Retransmits, nil
+}
+
+// parsePingRTT returns the average round-trip time, in milliseconds, of the output of ping
+func parsePingRTT(output string) (float64, bool) {
+	match := pingRTT.FindStringSubmatch(output)
+	if match == nil {
+		return 0, false
+	}
+	rtt, err := strconv.ParseFloat(match[1], 64)
+	return rtt, err == nil
+}
+
+// commandError returns the error of a command, with its standard error when any
+func commandError(result commandResult) string {
+	if stderr := strings.TrimSpace(result.stderr); len(stderr) > 0 {
+		return fmt.Sprintf("%s: %s", result.err, stderr)
+	}
+	return result.err.Error()
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+func TestNetPerfPairs(t *testing.T) {
+	tests := []struct {
+		mode      string
+		n         int
+		pairs     [][2]int
+		shouldErr bool
+	}{
+		{mode: "ring", n: 3, pairs: [][2]int{{0, 1}, {1, 2}, {2, 0}}},
+		{mode: "ring", n: 2, pairs: [][2]int{{0, 1}}},
+		{mode: "all", n: 3, pairs: [][2]int{{0, 1}, {0, 2}, {1, 2}}},
+		{mode: "ring", n: 1, shouldErr: true},
+		{mode: "mesh", n: 3, shouldErr: true},
+	}
+	for _, test := range tests {
+		pairs, err := netPerfPairs(test.mode, test.n)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("%s/%d: expected an error", test.mode, test.n)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s/%d: unexpected error: %s", test.mode, test.n, err)
+		}
+		if !reflect.DeepEqual(pairs, test.pairs) {
+			t.Errorf("%s/%d: expected pairs %v, got %v", test.mode, test.n, test.pairs, pairs)
+		}
+	}
+}
+
+func TestParseIperfReport(t *testing.T) {
+	report := `{"start": {}, "end": {"sum_sent": {"bits_per_second": 9.41e9, "retransmits": 12}, "sum_received": {"bits_per_second": 9.38e9}}}`
+	bps, retransmits, err := parseIperfReport(report)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if bps != 9.38e9 || retransmits != 12 {
+		t.Errorf("unexpected throughput %f and retransmits %d", bps, retransmits)
+	}
+
+	if _, _, err := parseIperfReport(`{"start": {}, "end": {}, "error": "unable to connect to server: Connection refused"}`); err == nil {
+		t.Error("expected the error of the report")
+	}
+	if _, _, err := parseIperfReport("iperf3: command not found"); err == nil {
+		t.Error("expected an error for an invalid report")
+	}
+}
+
+func TestParsePingRTT(t *testing.T) {
+	tests := []struct {
+		output string
+		rtt    float64
+		ok     bool
+	}{
+		{output: "5 packets transmitted, 5 received, 0% packet loss, time 4005ms\nrtt min/avg/max/mdev = 0.045/0.251/0.560/0.105 ms\n", rtt: 0.251, ok: true},
+		{output: "5 packets transmitted, 5 packets received, 0% packet loss\nround-trip min/avg/max = 1.120/1.534/2.001 ms\n", rtt: 1.534, ok: true},
+		{output: "5 packets transmitted, 0 received, 100% packet loss, time 4094ms\n"},
+	}
+	for _, test := range tests {
+		rtt, ok := parsePingRTT(test.output)
+		if ok != test.ok || rtt != test.rtt {
+			t.Errorf("expected %f (%t), got %f (%t)", test.rtt, test.ok, rtt, ok)
+		}
+	}
+}
+
+func TestNetPerfReadOnly(t *testing.T) {
+	exe := New()
+	exe.SetOptions(ExecOptions{ReadOnly: true})
+	err := exe.Exec("test.star", strings.NewReader(`net_perf(resources=resources(provider=local_provider()))`))
+	if err == nil || !strings.Contains(err.Error(), "rejected in read-only mode") {
+		t.Errorf("expecting net_perf to be rejected in read-only mode, got %v", err)
+	}
+}
+
+func TestNetPerfAddress(t *testing.T) {
+	tests := []struct {
+		host     string
+		expected string
+	}{
+		{host: "10.0.0.2", expected: "10.0.0.2"},
+		{host: "10.0.0.2:2222", expected: "10.0.0.2"},
+		{host: "[fd00::2]:22", expected: "fd00::2"},
+		{host: "fd00::2", expected: "fd00::2"},
+	}
+	for _, test := range tests {
+		target := starlarkstruct.FromStringDict(starlark.String(identifiers.hostResource), starlark.StringDict{
+			"host":      starlark.String(test.host),
+			"transport": starlark.String("ssh"),
+		})
+		if address := netPerfAddress(target); address != test.expected {
+			t.Errorf("%s: expecting %s, got %s", test.host, test.expected, address)
+		}
+	}
+}
+
+func TestNetPerfRecord(t *testing.T) {
+	result := netPerfResult{source: "10.0.0.1", target: "10.0.0.2", bitsPerSecond: 9.38e9, latency: 0.25, hasLatency: true}
+	data, err := json.Marshal(result.record())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"source":"10.0.0.1","target":"10.0.0.2","bits_per_second":9380000000,"retransmits":0,"latency_ms":0.25}`
+	if string(data) != expected {
+		t.Errorf("unexpected record: %s", data)
+	}
+}
@@ -17,7 +17,7 @@ func setupOSStruct() *starlarkstruct.Struct {
 		starlark.StringDict{
 			"name":     starlark.String(runtime.GOOS),
 			"username": starlark.String(getUsername()),
-			"home":     starlark.String(os.Getenv("HOME")),
+			"home":     starlark.String(homeDir()),
 			"getenv":   starlark.NewBuiltin("getenv", getEnvFunc),
 		},
 	)
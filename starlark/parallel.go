@@ -0,0 +1,112 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+)
+
+// parallelLocals are the thread local keys copied to the threads running the steps of parallel()
+var parallelLocals = []string{
+	identifiers.crashdCfg,
+	identifiers.kubeCfg,
+	identifiers.sshCfg,
+	identifiers.resources,
+	sshConfigsLocal,
+	captureIndexLocal,
+	stepTimingsLocal,
+	stepSpanLocal,
+	bundleQuotaLocal,
+	findingsLocal,
+	secretValuesLocal,
+	provenanceLocal,
+	awsConfigLocal,
+	runLockLocal,
+	execOptionsLocal,
+	contextLocal,
+	runStartLocal,
+}
+
+// parallelFunc is a built-in starlark function that runs steps, functions called without arguments
+// (i.e. lambda: kube_capture(what="logs")), concurrently, each in its own thread, and returns the list
+// of their results once all of them have completed. At most max_workers steps run at once (all of
+// them by default). Steps see the configuration of the script (crashd_config, kube_config, ssh_config,
+// and default resources), but the configuration they set is not seen by the script. Steps must not
+// modify variables shared with the script or with the other steps.
+// Starlark format: parallel(steps=[fn, ...][, max_workers=n])
+func parallelFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var steps *starlark.List
+	var maxWorkers int
+
+	if err := starlark.UnpackArgs(
+		identifiers.parallel, args, kwargs,
+		"steps", &steps,
+		"max_workers?", &maxWorkers,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.parallel, err)
+	}
+
+	var fns []starlark.Callable
+	for i := 0; i < steps.Len(); i++ {
+		fn, ok := steps.Index(i).(starlark.Callable)
+		if !ok {
+			return starlark.None, fmt.Errorf("%s: step %d: expecting a function, got %s", identifiers.parallel, i, steps.Index(i).Type())
+		}
+		fns = append(fns, fn)
+	}
+	if maxWorkers < 0 {
+		return starlark.None, fmt.Errorf("%s: max_workers must be positive", identifiers.parallel)
+	}
+	if maxWorkers == 0 || maxWorkers > len(fns) {
+		maxWorkers = len(fns)
+	}
+
+	logrus.Debugf("%s: running %d steps with %d workers", identifiers.parallel, len(fns), maxWorkers)
+	results := make([]starlark.Value, len(fns))
+	errs := make([]error, len(fns))
+	workers := make(chan struct{}, maxWorkers)
+	var wg sync.WaitGroup
+	for i, fn := range fns {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(i int, fn starlark.Callable) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			results[i], errs[i] = starlark.Call(newStepThread(thread, i), fn, nil, nil)
+		}(i, fn)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("step %d (%s): %s", i, fns[i].Name(), err))
+			results[i] = starlark.None
+		}
+	}
+	if len(failed) > 0 {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.parallel, strings.Join(failed, "; "))
+	}
+	return starlark.NewList(results), nil
+}
+
+// newStepThread returns the thread running the step i of parallel(), with the thread locals of the script thread
+func newStepThread(thread *starlark.Thread, i int) *starlark.Thread {
+	stepThread := &starlark.Thread{
+		Name:  fmt.Sprintf("%s/%s-%d", thread.Name, identifiers.parallel, i),
+		Print: thread.Print,
+		Load:  thread.Load,
+	}
+	for _, key := range parallelLocals {
+		if val := thread.Local(key); val != nil {
+			stepThread.SetLocal(key, val)
+		}
+	}
+	return stepThread
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParallelScript(t *testing.T) {
+	defer os.RemoveAll(defaults.workdir)
+	script := `
+def slow():
+    return run_local("sh -c 'sleep 1; echo slow'")
+
+results = parallel(steps=[slow, lambda: run_local("sh -c 'sleep 1; echo fast'"), slow])
+`
+	exe := New()
+	start := time.Now()
+	if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("steps did not run concurrently: %s", elapsed)
+	}
+	if results := exe.result["results"].String(); results != `["slow", "fast", "slow"]` {
+		t.Errorf("unexpected results: %s", results)
+	}
+
+	exe = New()
+	start = time.Now()
+	if err := exe.Exec("test.star", strings.NewReader(`parallel(steps=[lambda: run_local("sleep 1"), lambda: run_local("sleep 1")], max_workers=1)`)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Errorf("max_workers not applied: %s", elapsed)
+	}
+}
+
+func TestParallelErrors(t *testing.T) {
+	defer os.RemoveAll(defaults.workdir)
+	tests := []struct {
+		name   string
+		script string
+		err    string
+	}{
+		{name: "failed step", script: `parallel(steps=[lambda: run_local("echo ok"), lambda: fail("broken")])`, err: "step 1 (lambda): fail: broken"},
+		{name: "not a function", script: `parallel(steps=["echo"])`, err: "expecting a function"},
+		{name: "negative workers", script: `parallel(steps=[], max_workers=-1)`, err: "max_workers"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := New().Exec("test.star", strings.NewReader(test.script))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expecting error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+
+	"github.com/vmware-tanzu/crash-diagnostics/archiver"
+)
+
+// partialArchiveFile is the archive of an interrupted run, unless an output file is set on the command line
+const partialArchiveFile = "archive-partial.tar.gz"
+
+// archivePartial bundles the working directory of an interrupted run, with its provenance marked as
+// interrupted, so that what was collected before the interruption is usable. The timing report, the error
+// report, and the run index are already up to date in the working directory, since they are written after
+// each step. It returns the archive file, or the output file set on the command line.
+func archivePartial(thread *starlark.Thread) (string, error) {
+	// the working directory may be left from a previous run
+	if timings, ok := thread.Local(stepTimingsLocal).(*stepTimings); !ok || len(timings.list()) == 0 {
+		return "", fmt.Errorf("no step executed")
+	}
+	workdir, err := getWorkdirFromThread(thread)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(workdir); err != nil {
+		return "", fmt.Errorf("nothing collected: %s", err)
+	}
+
+	outputFile := partialArchiveFile
+	if output := getExecOptions(thread).Config.Output; len(output) > 0 {
+		outputFile = output
+	}
+
+	var files []archiver.File
+	if prov, ok := thread.Local(provenanceLocal).(*provenance); ok {
+		prov.Interrupted = true
+	}
+	if prov, err := makeProvenanceFile(thread, supportCase{}); err != nil {
+		logrus.Warnf("partial archive: skipping %s: %s", provenanceFileName, err)
+	} else {
+		files = append(files, archiver.File{Name: provenanceFileName, Data: prov})
+	}
+
+	opts := archiver.Options{Files: files, Workers: getArchiveWorkers(thread)}
+	if err := archiver.TarWithOptions(outputFile, opts, workdir); err != nil {
+		return "", err
+	}
+	return outputFile, nil
+}
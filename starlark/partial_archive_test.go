@@ -0,0 +1,77 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecInterrupted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashd-partial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "partial.tar.gz")
+
+	script := `
+set_defaults(resources(provider=local_provider()))
+capture("echo collected", file_name="collected.txt")
+run("sleep 10")
+run("echo never")
+`
+	exe := New()
+	exe.SetOptions(ExecOptions{Config: ConfigOverrides{Workdir: filepath.Join(dir, "workdir"), Output: output}})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(500*time.Millisecond, cancel)
+	start := time.Now()
+	err = exe.ExecWithContext(ctx, "test.star", strings.NewReader(script))
+	if err == nil || !strings.Contains(err.Error(), "partial archive saved in "+output) {
+		t.Fatalf("expecting the run to be stopped, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("running command not killed after %s", time.Since(start))
+	}
+
+	file, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[filepath.Base(header.Name)] = string(data)
+	}
+	if !strings.Contains(files["collected.txt"], "collected") {
+		t.Errorf("expecting the captured file in the partial archive, got files %v", files)
+	}
+	if !strings.Contains(files[provenanceFileName], "interrupted: true") {
+		t.Errorf("expecting an interrupted provenance, got: %s", files[provenanceFileName])
+	}
+}
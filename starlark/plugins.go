@@ -0,0 +1,61 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"go.starlark.net/starlark"
+)
+
+var (
+	builtinNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	pluginsMu sync.Mutex
+	plugins   = make(map[string]BuiltinFunc)
+)
+
+// RegisterBuiltin adds a Go function as a script built-in named name, allowing vendors to
+// provide product-specific built-ins (i.e. myapp_capture()) from their own module. It is
+// meant to be called from an init function, before executors are created. Registered
+// built-ins, like core collection functions, can be tagged and are subject to the bundle
+// size quota. Names of crashd built-ins and of previously registered built-ins are rejected.
+func RegisterBuiltin(name string, fn BuiltinFunc) error {
+	if !builtinNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid built-in name %q", name)
+	}
+	if fn == nil {
+		return fmt.Errorf("built-in %s: nil function", name)
+	}
+	if _, ok := corePredeclareds()[name]; ok {
+		return fmt.Errorf("built-in %s: name is reserved by crashd", name)
+	}
+
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	if _, ok := plugins[name]; ok {
+		return fmt.Errorf("built-in %s: already registered", name)
+	}
+	plugins[name] = fn
+	return nil
+}
+
+// registeredBuiltins returns a copy of the registered plugin built-ins
+func registeredBuiltins() map[string]BuiltinFunc {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	builtins := make(map[string]BuiltinFunc, len(plugins))
+	for name, fn := range plugins {
+		builtins[name] = fn
+	}
+	return builtins
+}
+
+// Workdir returns the working directory (crashd_config.workdir) of the script running
+// on thread. Plugin built-ins should save the files they collect under this directory.
+func Workdir(thread *starlark.Thread) (string, error) {
+	return getWorkdirFromThread(thread)
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestRegisterBuiltin(t *testing.T) {
+	fn := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		workdir, err := Workdir(thread)
+		if err != nil {
+			return starlark.None, err
+		}
+		return starlark.String(workdir), nil
+	}
+
+	if err := RegisterBuiltin("test_registered_capture", fn); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		pluginsMu.Lock()
+		delete(plugins, "test_registered_capture")
+		pluginsMu.Unlock()
+	}()
+
+	if err := RegisterBuiltin("test_registered_capture", fn); err == nil {
+		t.Error("expecting error for duplicate registration")
+	}
+	if err := RegisterBuiltin(identifiers.capture, fn); err == nil {
+		t.Error("expecting error for reserved name")
+	}
+	if err := RegisterBuiltin("my-capture", fn); err == nil {
+		t.Error("expecting error for invalid name")
+	}
+
+	exe := New()
+	if err := exe.Exec("test.star", strings.NewReader(`result = test_registered_capture()`)); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(defaults.workdir)
+	if result := exe.result["result"].(starlark.String); string(result) != defaults.workdir {
+		t.Errorf("unexpected result: %s", result)
+	}
+}
+
+func TestExecPlugin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashd-plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the plugin returns its call request as result
+	plugin := `#!/bin/sh
+case "$1" in
+describe) echo '{"builtins": ["echo_plugin"]}' ;;
+call) printf '{"result": %s}' "$(cat)" ;;
+esac
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "crashd-plugin-echo"), []byte(plugin), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	exe := New()
+	exe.SetOptions(ExecOptions{PluginDirs: []string{dir}})
+	script := `
+result = echo_plugin("pods", namespaces=["default", "kube-system"], limit=10)
+`
+	if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(defaults.workdir)
+
+	result, ok := exe.result["result"].(*starlark.Dict)
+	if !ok {
+		t.Fatalf("unexpected result type %T", exe.result["result"])
+	}
+	for key, expected := range map[string]string{
+		"builtin": `"echo_plugin"`,
+		"args":    `["pods"]`,
+		"kwargs":  `{"limit": 10, "namespaces": ["default", "kube-system"]}`,
+	} {
+		val, found, err := result.Get(starlark.String(key))
+		if err != nil || !found {
+			t.Fatalf("result missing %s", key)
+		}
+		if val.String() != expected {
+			t.Errorf("unexpected %s: %s", key, val)
+		}
+	}
+}
+
+func TestExecPluginDescribe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashd-plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the plugin records that it was described, the broken plugin fails to
+	marker := filepath.Join(dir, "described")
+	plugin := fmt.Sprintf(`#!/bin/sh
+case "$1" in
+describe) touch %s; echo '{"builtins": ["marked_plugin"]}' ;;
+call) echo '{"result": "called"}' ;;
+esac
+`, marker)
+	if err := ioutil.WriteFile(filepath.Join(dir, "crashd-plugin-marked"), []byte(plugin), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "crashd-plugin-broken"), []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(defaults.workdir)
+
+	// plugins are not described for scripts using declared names only
+	exe := New()
+	exe.SetOptions(ExecOptions{PluginDirs: []string{dir}})
+	if err := exe.Exec("test.star", strings.NewReader(`conf = crashd_config()`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("expecting the plugins not to be described, got %v", err)
+	}
+
+	// the broken plugin is skipped
+	exe = New()
+	exe.SetOptions(ExecOptions{PluginDirs: []string{dir}})
+	if err := exe.Exec("test.star", strings.NewReader(`result = marked_plugin()`)); err != nil {
+		t.Fatal(err)
+	}
+	if result := exe.result["result"]; result != starlark.String("called") {
+		t.Errorf("unexpected result: %v", result)
+	}
+}
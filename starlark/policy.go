@@ -0,0 +1,126 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"sync"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+	"github.com/vmware-tanzu/crash-diagnostics/policy"
+)
+
+// execGuard holds the options of the running script. The shared execution helpers (execRun,
+// execCapture, and the pod exec of the presets) check the commands, and copy_from the transports,
+// against them, so that the commands run by the presets and providers on behalf of the script are subject
+// to the read-only mode and to the policy, like the commands of run and capture.
+var execGuard struct {
+	sync.RWMutex
+	opts *ExecOptions
+}
+
+// setExecGuard guards the execution helpers, and the Kubernetes clients, with the read-only mode and the
+// policy of opts. It returns the function restoring the previous guard.
+func setExecGuard(opts ExecOptions) func() {
+	execGuard.Lock()
+	previous := execGuard.opts
+	execGuard.opts = &opts
+	execGuard.Unlock()
+
+	restoreNamespaces := func() {}
+	if opts.Policy != nil && len(opts.Policy.Namespaces) > 0 {
+		restoreNamespaces = k8s.SetNamespacePolicy(opts.Policy.CheckNamespace)
+	}
+	return func() {
+		restoreNamespaces()
+		execGuard.Lock()
+		execGuard.opts = previous
+		execGuard.Unlock()
+	}
+}
+
+func guardOptions() (ExecOptions, bool) {
+	execGuard.RLock()
+	defer execGuard.RUnlock()
+	if execGuard.opts == nil {
+		return ExecOptions{}, false
+	}
+	return *execGuard.opts, true
+}
+
+// guardCommand returns an error when cmdStr, run by the step name, is rejected in read-only mode or is
+// not allowed by the policy of the running script
+func guardCommand(name, cmdStr string) error {
+	opts, ok := guardOptions()
+	if !ok {
+		return nil
+	}
+	return checkCommandOptions(opts, name, cmdStr)
+}
+
+// guardTransport returns an error when the transport, used by the step name, is not allowed by the policy
+// of the running script
+func guardTransport(name, transport string) error {
+	opts, ok := guardOptions()
+	if !ok || opts.Policy == nil {
+		return nil
+	}
+	if err := opts.Policy.CheckTransport(transport); err != nil {
+		return policy.Refuse(name, fmt.Errorf("%s: %s", name, err))
+	}
+	return nil
+}
+
+// checkCommand returns an error when cmdStr is rejected in read-only mode, or is not allowed by
+// the policy of the run. Policy refusals are recorded in the audit trail.
+func checkCommand(thread *starlark.Thread, name, cmdStr string) error {
+	return checkCommandOptions(getExecOptions(thread), name, cmdStr)
+}
+
+func checkCommandOptions(opts ExecOptions, name, cmdStr string) error {
+	if err := checkReadOnly(opts, name, cmdStr); err != nil {
+		return err
+	}
+	if err := opts.Policy.CheckCommand(cmdStr); err != nil {
+		return policy.Refuse(name, fmt.Errorf("%s: %s", name, err))
+	}
+	return nil
+}
+
+// checkPolicyResources returns an error when the transport of one of the resources is not allowed
+// by the policy of the run
+func checkPolicyResources(thread *starlark.Thread, name string, resources *starlark.List) error {
+	p := getExecOptions(thread).Policy
+	if p == nil {
+		return nil
+	}
+	for i := 0; i < resources.Len(); i++ {
+		res, ok := resources.Index(i).(*starlarkstruct.Struct)
+		if !ok {
+			continue
+		}
+		val, err := res.Attr("transport")
+		if err != nil {
+			continue
+		}
+		transport, _ := val.(starlark.String)
+		if err := p.CheckTransport(string(transport)); err != nil {
+			return policy.Refuse(name, fmt.Errorf("%s: %s", name, err))
+		}
+	}
+	return nil
+}
+
+// restrictPolicyNamespaces returns the namespaces, restricted to the namespaces allowed by the policy
+// of the run when empty, or an error when one of them is not allowed
+func restrictPolicyNamespaces(thread *starlark.Thread, name string, namespaces []string) ([]string, error) {
+	namespaces, err := getExecOptions(thread).Policy.RestrictNamespaces(namespaces)
+	if err != nil {
+		return nil, policy.Refuse(name, fmt.Errorf("%s: %s", name, err))
+	}
+	return namespaces, nil
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/vmware-tanzu/crash-diagnostics/policy"
+)
+
+func TestPolicyScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashd-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.yaml")
+	if err := ioutil.WriteFile(path, []byte("transports: [ssh]\ncommands: ['^echo ']\nmax_bundle_size: 1Ki\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := policy.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		script  string
+		refused bool
+	}{
+		{name: "allowed command", script: `result = run_local("echo policy")`},
+		{name: "command not allowed", script: `run_local("uptime")`, refused: true},
+		{name: "transport not allowed", script: `resources(provider=local_provider())`, refused: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exe := New()
+			exe.SetOptions(ExecOptions{Policy: p})
+			err := exe.Exec("test.star", strings.NewReader(test.script))
+			if test.refused != (err != nil) {
+				t.Fatalf("unexpected result: %v", err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "not allowed by policy") {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+
+	exe := New()
+	exe.SetOptions(ExecOptions{Policy: p})
+	if err := exe.Exec("test.star", strings.NewReader(`crashd_config(workdir="`+dir+`", max_bundle_size="1Gi")`)); err != nil {
+		t.Fatal(err)
+	}
+	if size := getMaxBundleSizeFromThread(exe.thread); size != 1024 {
+		t.Errorf("expecting the max_bundle_size of the policy, got %d", size)
+	}
+}
+
+func TestPolicyPresets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashd-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.yaml")
+	if err := ioutil.WriteFile(path, []byte("transports: [local]\ncommands: ['^echo ']\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := policy.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the commands of the presets are subject to the policy, like the commands of run and capture
+	exe := New()
+	exe.SetOptions(ExecOptions{Policy: p})
+	script := `proc_capture(resources=resources(provider=local_provider()), workdir="` + dir + `")`
+	err = exe.Exec("test.star", strings.NewReader(script))
+	if err == nil || !strings.Contains(err.Error(), "not allowed by policy") {
+		t.Fatalf("expecting the preset command to be refused, got %v", err)
+	}
+
+	// transports are checked whatever built the resources
+	restore := setExecGuard(ExecOptions{Policy: p})
+	defer restore()
+	if err := guardTransport(identifiers.run, "ssh"); err == nil {
+		t.Error("expecting transport ssh not allowed")
+	}
+	if err := guardTransport(identifiers.run, localTransport); err != nil {
+		t.Error(err)
+	}
+
+	// the commands run in pods (ingress configuration dump, DNS probe) are checked before they are run
+	if err := execIngressConfig(nil, corev1.Pod{}, []string{"nginx", "-T"}, ioutil.Discard); err == nil || !strings.Contains(err.Error(), "not allowed by policy") {
+		t.Errorf("expecting nginx -T to be refused, got %v", err)
+	}
+	if _, err := execProbe(nil, []string{"nslookup", "kubernetes.default"}, ioutil.Discard); err == nil || !strings.Contains(err.Error(), "not allowed by policy") {
+		t.Errorf("expecting nslookup to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+// PprofCaptureFn is a built-in starlark function that fetches pprof profiles from the API server,
+// the kubelets, or arbitrary pods (via the API server proxy) and saves them under workdir.
+// Starlark format: pprof_capture(target="apiserver|kubelet|pod" [, names=["name"], namespace="ns", port=6060,
+// profiles=["heap","goroutine","profile"], seconds=30, kube_config=kube_config()])
+func PprofCaptureFn(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var target, namespace, workdir string
+	var names, labels, profiles *starlark.List
+	var port, seconds int
+	var kubeConfig *starlarkstruct.Struct
+
+	if err := starlark.UnpackArgs(
+		identifiers.pprofCapture, args, kwargs,
+		"target", &target,
+		"names?", &names,
+		"labels?", &labels,
+		"namespace?", &namespace,
+		"port?", &port,
+		"profiles?", &profiles,
+		"seconds?", &seconds,
+		"workdir?", &workdir,
+		"kube_config?", &kubeConfig,
+	); err != nil {
+		return starlark.None, errors.Wrap(err, "failed to read args")
+	}
+
+	if port == 0 {
+		port = defaults.pprofPort
+	}
+	if seconds == 0 {
+		seconds = defaults.pprofSeconds
+	}
+	profileList := toSlice(profiles)
+	if len(profileList) == 0 {
+		profileList = defaults.pprofProfiles
+	}
+
+	if len(workdir) == 0 {
+		dir, err := getWorkdirFromThread(thread)
+		if err != nil {
+			return starlark.None, err
+		}
+		workdir = dir
+	}
+
+	if kubeConfig == nil {
+		kubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
+	}
+	path, err := getKubeConfigFromStruct(kubeConfig)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "failed to kubeconfig")
+	}
+	client, err := k8s.New(path)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "could not initialize search client")
+	}
+
+	targetNames := toSlice(names)
+	switch target {
+	case k8s.PprofTargetAPIServer:
+		targetNames = []string{k8s.PprofTargetAPIServer}
+	case k8s.PprofTargetKubelet:
+		if len(targetNames) == 0 {
+			nodeNames, err := k8s.GetNodeNames(client, nil, toSlice(labels))
+			if err != nil {
+				return starlark.None, errors.Wrap(err, "could not fetch node names")
+			}
+			targetNames = nodeNames
+		}
+	case k8s.PprofTargetPod:
+		if len(targetNames) == 0 {
+			return starlark.None, fmt.Errorf("%s: names required for target %s", identifiers.pprofCapture, target)
+		}
+	default:
+		return starlark.None, fmt.Errorf("%s: unsupported target %s", identifiers.pprofCapture, target)
+	}
+
+	resultDir := filepath.Join(workdir, k8s.PprofDirname, target)
+	err = writePprofProfiles(client, resultDir, target, namespace, port, seconds, targetNames, profileList)
+
+	return starlarkstruct.FromStringDict(
+		starlark.String(identifiers.pprofCapture),
+		starlark.StringDict{
+			"file": starlark.String(resultDir),
+			"error": func() starlark.String {
+				if err != nil {
+					return starlark.String(err.Error())
+				}
+				return ""
+			}(),
+		}), nil
+}
+
+// writePprofProfiles fetches each profile for each named target and saves it in
+// resultDir/<name>/<profile>.pprof, or resultDir/<profile>.pprof for the API server (its only name).
+// Failed profiles are logged and reported together.
+func writePprofProfiles(client *k8s.Client, resultDir, target, namespace string, port, seconds int, names, profiles []string) error {
+	var failures []string
+	for _, name := range names {
+		profileDir := filepath.Join(resultDir, sanitizeStr(name))
+		if target == k8s.PprofTargetAPIServer {
+			profileDir = resultDir
+		}
+		if err := os.MkdirAll(profileDir, 0744); err != nil && !os.IsExist(err) {
+			return err
+		}
+
+		for _, profile := range profiles {
+			path, err := k8s.PprofPath(target, namespace, name, port, profile)
+			if err != nil {
+				return err
+			}
+
+			logrus.Debugf("%s: fetching %s", identifiers.pprofCapture, path)
+			if err := writePprofProfile(client, path, profile, seconds, filepath.Join(profileDir, fmt.Sprintf("%s.pprof", profile))); err != nil {
+				logrus.Errorf("%s: %s", identifiers.pprofCapture, err)
+				failures = append(failures, err.Error())
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func writePprofProfile(client *k8s.Client, path, profile string, seconds int, filePath string) error {
+	reader, err := client.FetchPprof(path, profile, seconds)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, reader); err != nil {
+		return errors.Wrapf(err, "failed to write pprof %s", filePath)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("pprof_capture", func() {
+
+	var (
+		executor *Executor
+		err      error
+	)
+
+	execSetup := func(crashdScript string) {
+		executor = New()
+		err = executor.Exec("test.pprof.capture", strings.NewReader(crashdScript))
+	}
+
+	It("saves the API server profiles in the workdir", func() {
+		crashdScript := fmt.Sprintf(`
+crashd_config(workdir="%s")
+set_defaults(kube_config(path="%s"))
+pprof_data = pprof_capture(target="apiserver", profiles=["heap", "goroutine"])
+		`, workdir, k8sconfig)
+		execSetup(crashdScript)
+		Expect(err).NotTo(HaveOccurred())
+
+		data := executor.result["pprof_data"]
+		Expect(data).NotTo(BeNil())
+
+		dataStruct, ok := data.(*starlarkstruct.Struct)
+		Expect(ok).To(BeTrue())
+
+		errVal, err := dataStruct.Attr("error")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(errVal.(starlark.String).GoString()).To(BeEmpty())
+
+		fileVal, err := dataStruct.Attr("file")
+		Expect(err).NotTo(HaveOccurred())
+
+		pprofDir := fileVal.(starlark.String).GoString()
+		Expect(pprofDir).To(BeADirectory())
+		Expect(pprofDir).To(Equal(filepath.Join(workdir, "pprof", "apiserver")))
+		Expect(filepath.Join(pprofDir, "heap.pprof")).To(BeARegularFile())
+		Expect(filepath.Join(pprofDir, "goroutine.pprof")).To(BeARegularFile())
+	})
+
+	It("returns an error for an unsupported target", func() {
+		crashdScript := fmt.Sprintf(`
+set_defaults(kube_config(path="%s"))
+pprof_capture(target="etcd")
+		`, k8sconfig)
+		execSetup(crashdScript)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("requires names for the pod target", func() {
+		crashdScript := fmt.Sprintf(`
+set_defaults(kube_config(path="%s"))
+pprof_capture(target="pod", namespace="kube-system")
+		`, k8sconfig)
+		execSetup(crashdScript)
+		Expect(err).To(HaveOccurred())
+	})
+})
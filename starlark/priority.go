@@ -0,0 +1,89 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+)
+
+const (
+	// priorityArg is the keyword argument, accepted by collection steps, setting the priority of a step
+	priorityArg = "priority"
+	// runStartLocal is the thread local key used to store the start time of the run
+	runStartLocal = "crashd_run_start"
+
+	priorityHigh   = "high"
+	priorityNormal = "normal"
+	priorityLow    = "low"
+
+	// budgetReserve is the fraction of the time budget kept for higher priority steps
+	// and the archive: low priority steps are skipped once less remains
+	budgetReserve = 0.2
+)
+
+// withPriority wraps a collection built-in, including run(), so that it accepts a priority="high|normal|low"
+// keyword argument. When the run has a time budget (--time-budget), low priority steps are
+// skipped (returning an empty list, like the steps skipped by the bundle quota, so that scripts iterating
+// over their results keep working) once the budget is nearly exhausted, and normal priority steps
+// once it is exhausted. High priority steps always run, so some bundle is always produced.
+func withPriority(name string, fn BuiltinFunc) BuiltinFunc {
+	return func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		priority, kwargs, err := popPriority(kwargs)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", name, err)
+		}
+
+		budget := getExecOptions(thread).TimeBudget
+		start, ok := thread.Local(runStartLocal).(time.Time)
+		if budget > 0 && ok {
+			remaining := budget - time.Since(start)
+			if !prioritySelected(priority, remaining, budget) {
+				logrus.Warnf("%s: skipped (priority %s, %s left of the %s time budget)", name, priority, remaining.Round(time.Second), budget)
+				return starlark.NewList(nil), nil
+			}
+		}
+		return fn(thread, b, args, kwargs)
+	}
+}
+
+// popPriority removes the priority keyword argument from kwargs and returns its value,
+// priorityNormal when not specified
+func popPriority(kwargs []starlark.Tuple) (string, []starlark.Tuple, error) {
+	priority := priorityNormal
+	var remaining []starlark.Tuple
+	for _, kwarg := range kwargs {
+		if key, ok := kwarg[0].(starlark.String); !ok || string(key) != priorityArg {
+			remaining = append(remaining, kwarg)
+			continue
+		}
+		val, ok := kwarg[1].(starlark.String)
+		if !ok {
+			return "", nil, fmt.Errorf("%s: expecting string, got %s", priorityArg, kwarg[1].Type())
+		}
+		switch string(val) {
+		case priorityHigh, priorityNormal, priorityLow:
+			priority = string(val)
+		default:
+			return "", nil, fmt.Errorf("%s: unsupported value %q (expecting %s, %s, or %s)", priorityArg, val, priorityHigh, priorityNormal, priorityLow)
+		}
+	}
+	return priority, remaining, nil
+}
+
+// prioritySelected returns true if a step with priority should run with remaining time
+// left of the budget
+func prioritySelected(priority string, remaining, budget time.Duration) bool {
+	switch priority {
+	case priorityHigh:
+		return true
+	case priorityNormal:
+		return remaining > 0
+	default:
+		return float64(remaining) > float64(budget)*budgetReserve
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrioritySelected(t *testing.T) {
+	budget := 10 * time.Minute
+	tests := []struct {
+		name      string
+		priority  string
+		remaining time.Duration
+		selected  bool
+	}{
+		{name: "low with time left", priority: priorityLow, remaining: 5 * time.Minute, selected: true},
+		{name: "low nearly exhausted", priority: priorityLow, remaining: time.Minute},
+		{name: "normal nearly exhausted", priority: priorityNormal, remaining: time.Minute, selected: true},
+		{name: "normal exhausted", priority: priorityNormal, remaining: -time.Second},
+		{name: "high exhausted", priority: priorityHigh, remaining: -time.Minute, selected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if selected := prioritySelected(test.priority, test.remaining, budget); selected != test.selected {
+				t.Errorf("expecting selected %t, got %t", test.selected, selected)
+			}
+		})
+	}
+}
+
+func TestPriorityScript(t *testing.T) {
+	workdir, err := ioutil.TempDir("", "crashd-priority")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+
+	script := fmt.Sprintf(`
+crashd_config(workdir="%s")
+high = capture_local("echo high", priority="high")
+normal = capture_local("echo normal")
+low = capture_local("echo low", priority="low")
+ran = run(cmd="echo run", resources=resources(provider=local_provider()), priority="low")
+`, workdir)
+	exe := New()
+	exe.SetOptions(ExecOptions{TimeBudget: time.Nanosecond})
+	if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+
+	if exe.result["high"].String() == "[]" {
+		t.Error("high priority step should run once the budget is exhausted")
+	}
+	// run() is a step too
+	for _, name := range []string{"normal", "low", "ran"} {
+		if exe.result[name].String() != "[]" {
+			t.Errorf("%s priority step should be skipped once the budget is exhausted, got %s", name, exe.result[name])
+		}
+	}
+
+	exe = New()
+	if err := exe.Exec("test.star", strings.NewReader(`capture_local("echo", priority="urgent")`)); err == nil {
+		t.Error("expecting error for unsupported priority")
+	}
+}
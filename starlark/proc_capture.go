@@ -0,0 +1,105 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"go.starlark.net/starlark"
+)
+
+// procCaptureFunc is a built-in starlark function that captures the process tree, and for
+// processes whose command line matches filter (an extended regex), their RSS/CPU usage,
+// open file descriptor counts, and cgroup memory/cpu limits on each compute resource.
+// Starlark format: proc_capture([filter="kube|containerd"][, resources=resources][, workdir=path])
+func procCaptureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir, filter string
+	var resources *starlark.List
+
+	if err := starlark.UnpackArgs(
+		identifiers.procCapture, args, kwargs,
+		"filter?", &filter,
+		"resources?", &resources,
+		"workdir?", &workdir,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.procCapture, err)
+	}
+
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+	if len(filter) == 0 {
+		filter = defaults.procFilter
+	}
+
+	cmds, err := procCaptureCommands(filter)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.procCapture, err)
+	}
+
+	if resources == nil {
+		res, err := getResourcesFromThread(thread)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.procCapture, err)
+		}
+		resources = res
+	}
+
+	results, err := execCaptureCommands(filepath.Join(workdir, "proc"), cmds, resources)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.procCapture, err)
+	}
+
+	return commandResultsToList(results), nil
+}
+
+// procCaptureCommands returns the commands used to snapshot the processes matching filter.
+// Shell variables are escaped (\$) to prevent their expansion on the local machine.
+func procCaptureCommands(filter string) ([]captureCommand, error) {
+	if strings.ContainsAny(filter, `'"`) {
+		return nil, fmt.Errorf("filter cannot contain quotes: %s", filter)
+	}
+	filter = strings.Replace(filter, "$", `\$`, -1)
+
+	forEach := func(body string) string {
+		return fmt.Sprintf(`for p in \$(pgrep -f '%s'); do %s; done`, filter, body)
+	}
+
+	return []captureCommand{
+		{cmd: "ps -eo pid,ppid,user,etime,args --forest", fileName: "process-tree.txt", desc: "Process tree"},
+		{
+			cmd:      forEach(`ps -o pid,ppid,rss,vsz,pcpu,pmem,nlwp,etime,comm --no-headers -p \$p`),
+			fileName: "process-usage.txt",
+			desc:     "PID PPID RSS VSZ %CPU %MEM THREADS ELAPSED COMMAND",
+		},
+		{
+			cmd:      forEach(`echo \$p \$(sudo ls /proc/\$p/fd 2>/dev/null | wc -l) \$(cat /proc/\$p/comm)`),
+			fileName: "process-fds.txt",
+			desc:     "PID OPEN_FDS COMMAND",
+		},
+		{
+			cmd:      forEach(`echo == \$p \$(cat /proc/\$p/comm); grep -E 'VmRSS|VmHWM|Threads' /proc/\$p/status`),
+			fileName: "process-status.txt",
+		},
+		{
+			cmd: forEach(
+				`echo == \$p \$(cat /proc/\$p/comm); cat /proc/\$p/cgroup; ` +
+					`u=\$(grep -E '^0::' /proc/\$p/cgroup | cut -d: -f3); m=\$(grep -E ':memory:' /proc/\$p/cgroup | cut -d: -f3); ` +
+					`c=\$(grep -E ':cpu,cpuacct:|:cpu:' /proc/\$p/cgroup | cut -d: -f3); ` +
+					`grep -H . /sys/fs/cgroup\$u/memory.max /sys/fs/cgroup\$u/memory.current /sys/fs/cgroup\$u/cpu.max ` +
+					`/sys/fs/cgroup/memory\$m/memory.limit_in_bytes /sys/fs/cgroup/memory\$m/memory.usage_in_bytes ` +
+					`/sys/fs/cgroup/cpu,cpuacct\$c/cpu.cfs_quota_us /sys/fs/cgroup/cpu,cpuacct\$c/cpu.cfs_period_us 2>/dev/null`,
+			),
+			fileName: "process-cgroups.txt",
+			desc:     "Process cgroups and limits",
+		},
+	}, nil
+}
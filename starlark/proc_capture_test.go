@@ -0,0 +1,42 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProcCaptureCommands(t *testing.T) {
+	tests := []struct {
+		name      string
+		filter    string
+		expected  string
+		shouldErr bool
+	}{
+		{name: "default filter", filter: "kube|containerd", expected: `pgrep -f 'kube|containerd'`},
+		{name: "anchored filter", filter: "kubelet$", expected: `pgrep -f 'kubelet\$'`},
+		{name: "quoted filter", filter: "kube'; rm -rf /", shouldErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmds, err := procCaptureCommands(test.filter)
+			if err != nil {
+				if !test.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if test.shouldErr {
+				t.Fatal("expecting error, got none")
+			}
+			for _, cmd := range cmds[1:] {
+				if !strings.Contains(cmd.cmd, test.expected) {
+					t.Errorf("command for %s does not contain %s", cmd.fileName, test.expected)
+				}
+			}
+		})
+	}
+}
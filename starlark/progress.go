@@ -0,0 +1,111 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+	"github.com/vmware-tanzu/crash-diagnostics/tracing"
+)
+
+// stepProgress is the progress of a running step: the files and bytes it wrote in the working directory
+type stepProgress struct {
+	step    string
+	elapsed time.Duration
+	files   int64
+	bytes   int64
+	// recent is the number of bytes written since the previous report
+	recent int64
+}
+
+// withProgress wraps a long-running built-in (i.e. copy_from, kube_capture) to report its progress every
+// ExecOptions.ProgressInterval while it runs: the elapsed time, and the files and bytes written in the working
+// directory since the step started, so that a slow step can be told from a stuck one. Reports are logged, with
+// structured fields, and added as events to the span of the step when tracing is enabled.
+func withProgress(name string, fn BuiltinFunc) BuiltinFunc {
+	return func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		interval := getExecOptions(thread).ProgressInterval
+		workdir, err := getWorkdirFromThread(thread)
+		if interval <= 0 || err != nil {
+			return fn(thread, b, args, kwargs)
+		}
+		span, _ := thread.Local(stepSpanLocal).(*tracing.Span)
+		stop := startProgress(name, workdir, interval, func(p stepProgress) { reportProgress(span, p) })
+		defer stop()
+		return fn(thread, b, args, kwargs)
+	}
+}
+
+// startProgress calls report with the progress of the step writing in dir every interval, until stop is called
+func startProgress(step, dir string, interval time.Duration, report func(stepProgress)) (stop func()) {
+	start := time.Now()
+	baseFiles, baseBytes := dirStats(dir)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var last int64
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			files, bytes := dirStats(dir)
+			p := stepProgress{step: step, elapsed: time.Since(start), files: files - baseFiles, bytes: bytes - baseBytes}
+			p.recent, last = p.bytes-last, p.bytes
+			report(p)
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// reportProgress logs the progress of a step, and adds it to the span of the step
+func reportProgress(span *tracing.Span, p stepProgress) {
+	elapsed := p.elapsed.Round(time.Second)
+	recent := fmt.Sprintf("+%s since the last report", k8s.FormatBytes(p.recent))
+	if p.recent <= 0 {
+		recent = "no new data since the last report"
+	}
+	logrus.WithFields(logrus.Fields{
+		"step":            p.step,
+		"elapsed_seconds": int64(elapsed.Seconds()),
+		"files":           p.files,
+		"bytes":           p.bytes,
+		"recent_bytes":    p.recent,
+	}).Infof("%s: running for %s, %d files (%s) written, %s", p.step, elapsed, p.files, k8s.FormatBytes(p.bytes), recent)
+	span.AddEvent("progress",
+		tracing.String("crashd.elapsed_seconds", strconv.FormatInt(int64(elapsed.Seconds()), 10)),
+		tracing.String("crashd.files", strconv.FormatInt(p.files, 10)),
+		tracing.String("crashd.bytes", strconv.FormatInt(p.bytes, 10)),
+	)
+}
+
+// dirStats returns the number and total size of the regular files under dir
+func dirStats(dir string) (int64, int64) {
+	var files, size int64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			files++
+			size += info.Size()
+		}
+		return nil
+	})
+	return files, size
+}
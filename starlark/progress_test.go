@@ -0,0 +1,63 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStartProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashd-progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// files written before the step are not reported
+	if err := ioutil.WriteFile(filepath.Join(dir, "before.txt"), make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var reports []stepProgress
+	stop := startProgress("copy_from", dir, 20*time.Millisecond, func(p stepProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+		reports = append(reports, p)
+	})
+	if err := os.MkdirAll(filepath.Join(dir, "node"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "node", "kubelet.log"), make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reports) < 2 {
+		t.Fatalf("expecting periodic reports, got %d", len(reports))
+	}
+	last := reports[len(reports)-1]
+	if last.step != "copy_from" || last.files != 1 || last.bytes != 1000 {
+		t.Errorf("unexpected progress: %+v", last)
+	}
+	if last.recent != 0 {
+		t.Errorf("expecting no new data in the last report, got %d bytes", last.recent)
+	}
+	if last.elapsed < reports[0].elapsed {
+		t.Errorf("unexpected elapsed time %s after %s", last.elapsed, reports[0].elapsed)
+	}
+
+	count := len(reports)
+	time.Sleep(60 * time.Millisecond)
+	if len(reports) != count {
+		t.Errorf("progress reported after the step ended")
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var (
+	// promptInput and promptOutput are the console used by prompt and confirm
+	promptInput  io.Reader = os.Stdin
+	promptOutput io.Writer = os.Stderr
+
+	// readSecret reads a line from the console without echoing it
+	readSecret = func() (string, error) {
+		secret, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(promptOutput)
+		return string(secret), err
+	}
+
+	// stdinIsTerminal reports whether the script runs with a console attached
+	stdinIsTerminal = func() bool {
+		return terminal.IsTerminal(int(os.Stdin.Fd()))
+	}
+)
+
+// isInteractive returns false when prompts are disabled: with the --non-interactive flag,
+// in CI environments (CI variable set), or when stdin is not a terminal
+func isInteractive(thread *starlark.Thread) bool {
+	if getExecOptions(thread).NonInteractive {
+		return false
+	}
+	if ci := os.Getenv("CI"); len(ci) > 0 && ci != "false" {
+		return false
+	}
+	return stdinIsTerminal()
+}
+
+// promptFunc is a built-in starlark function that prints a message and returns the line entered
+// by the user. When secret is True, the input is not echoed, and redacted from the records of the
+// run (see secretValues). In non-interactive mode, the default value is returned or, when there is
+// none, the script fails.
+// Starlark format: prompt(<message> [, secret=False, default=<value>])
+func promptFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var message string
+	var secret bool
+	var defaultVal starlark.Value
+	if err := starlark.UnpackArgs(
+		identifiers.prompt, args, kwargs,
+		"message", &message,
+		"secret?", &secret,
+		"default?", &defaultVal,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.prompt, err)
+	}
+
+	if !isInteractive(thread) {
+		if defaultVal == nil {
+			return starlark.None, fmt.Errorf("%s: %q requires input but the script runs non-interactively", identifiers.prompt, message)
+		}
+		if str, ok := defaultVal.(starlark.String); ok && secret {
+			getSecretValues(thread).add(string(str))
+		}
+		return defaultVal, nil
+	}
+
+	fmt.Fprint(promptOutput, message)
+	var input string
+	var err error
+	if secret {
+		input, err = readSecret()
+	} else {
+		input, err = readLine()
+	}
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.prompt, err)
+	}
+	if len(input) == 0 && defaultVal != nil {
+		if str, ok := defaultVal.(starlark.String); ok && secret {
+			getSecretValues(thread).add(string(str))
+		}
+		return defaultVal, nil
+	}
+	if secret {
+		getSecretValues(thread).add(input)
+	}
+	return starlark.String(input), nil
+}
+
+// confirmFunc is a built-in starlark function that asks the user a yes/no question and returns
+// True when the answer is yes. In non-interactive mode, True is only returned with the AssumeYes
+// option: a default of False is returned so that the step it gates is skipped, and a default of True
+// fails the script rather than approving the step unattended.
+// Starlark format: confirm(<message> [, default=False])
+func confirmFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var message string
+	var defaultVal bool
+	if err := starlark.UnpackArgs(
+		identifiers.confirm, args, kwargs,
+		"message", &message,
+		"default?", &defaultVal,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.confirm, err)
+	}
+
+	if !isInteractive(thread) {
+		switch {
+		case getExecOptions(thread).AssumeYes:
+			return starlark.True, nil
+		case defaultVal:
+			return starlark.None, fmt.Errorf("%s: %q requires an answer but the script runs non-interactively (use --yes to approve)", identifiers.confirm, message)
+		}
+		return starlark.False, nil
+	}
+
+	choices := "[y/N]"
+	if defaultVal {
+		choices = "[Y/n]"
+	}
+	for {
+		fmt.Fprintf(promptOutput, "%s %s ", message, choices)
+		input, err := readLine()
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.confirm, err)
+		}
+		switch strings.ToLower(input) {
+		case "":
+			return starlark.Bool(defaultVal), nil
+		case "y", "yes":
+			return starlark.True, nil
+		case "n", "no":
+			return starlark.False, nil
+		}
+	}
+}
+
+// readLine reads a line, without its line ending, from the prompt input
+func readLine() (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	// read one byte at a time to avoid consuming input beyond the line
+	for {
+		n, err := promptInput.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				break
+			}
+			line = append(line, buf[0])
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				break
+			}
+			return "", err
+		}
+	}
+	return strings.TrimRight(string(line), "\r"), nil
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestPromptScript(t *testing.T) {
+	tests := []struct {
+		name        string
+		script      string
+		input       string
+		interactive bool
+		expected    map[string]string
+		shouldErr   bool
+	}{
+		{
+			name:        "interactive prompt and confirm",
+			script:      "user = prompt('user: ')\nsecret = prompt('password: ', secret=True)\nok = confirm('restart kubelet?')",
+			input:       "capv\ny\n",
+			interactive: true,
+			expected:    map[string]string{"user": `"capv"`, "secret": `"s3cret"`, "ok": "True"},
+		},
+		{
+			name:        "interactive defaults",
+			script:      "port = prompt('port: ', default='22')\nok = confirm('proceed?', default=True)",
+			input:       "\n\n",
+			interactive: true,
+			expected:    map[string]string{"port": `"22"`, "ok": "True"},
+		},
+		{
+			name:        "confirm asks again",
+			script:      "ok = confirm('proceed?')",
+			input:       "maybe\nno\n",
+			interactive: true,
+			expected:    map[string]string{"ok": "False"},
+		},
+		{
+			name:     "non-interactive defaults",
+			script:   "port = prompt('port: ', default='22')\nok = confirm('restart kubelet?')",
+			expected: map[string]string{"port": `"22"`, "ok": "False"},
+		},
+		{
+			name:      "non-interactive confirm approved by default",
+			script:    "ok = confirm('restart kubelet?', default=True)",
+			shouldErr: true,
+		},
+		{
+			name:      "non-interactive prompt without default",
+			script:    "user = prompt('user: ')",
+			shouldErr: true,
+		},
+	}
+
+	savedInput, savedOutput, savedSecret, savedTerminal := promptInput, promptOutput, readSecret, stdinIsTerminal
+	defer func() {
+		promptInput, promptOutput, readSecret, stdinIsTerminal = savedInput, savedOutput, savedSecret, savedTerminal
+	}()
+	os.Unsetenv("CI")
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			interactive := test.interactive
+			promptInput = strings.NewReader(test.input)
+			promptOutput = ioutil.Discard
+			readSecret = func() (string, error) { return "s3cret", nil }
+			stdinIsTerminal = func() bool { return interactive }
+
+			exe := New()
+			err := exe.Exec("test.star", strings.NewReader(test.script))
+			defer os.RemoveAll(defaults.workdir)
+			if err != nil {
+				if !test.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if test.shouldErr {
+				t.Fatal("expecting error")
+			}
+			for name, expected := range test.expected {
+				if val := exe.result[name]; val == nil || val.String() != expected {
+					t.Errorf("unexpected %s: got %v, want %s", name, val, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestNonInteractiveOption(t *testing.T) {
+	savedTerminal, savedOutput := stdinIsTerminal, promptOutput
+	defer func() { stdinIsTerminal, promptOutput = savedTerminal, savedOutput }()
+	stdinIsTerminal = func() bool { return true }
+	var output bytes.Buffer
+	promptOutput = &output
+
+	exe := New()
+	exe.SetOptions(ExecOptions{NonInteractive: true})
+	if err := exe.Exec("test.star", strings.NewReader("ok = confirm('proceed?')")); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(defaults.workdir)
+	if exe.result["ok"] != starlark.False {
+		t.Errorf("unexpected result: %v", exe.result["ok"])
+	}
+	if output.Len() > 0 {
+		t.Errorf("unexpected prompt output: %s", output.String())
+	}
+
+	// with AssumeYes, confirm approves the steps it gates
+	exe = New()
+	exe.SetOptions(ExecOptions{NonInteractive: true, AssumeYes: true})
+	if err := exe.Exec("test.star", strings.NewReader("ok = confirm('proceed?')\napproved = confirm('restart kubelet?', default=True)")); err != nil {
+		t.Fatal(err)
+	}
+	if exe.result["ok"] != starlark.True || exe.result["approved"] != starlark.True {
+		t.Errorf("unexpected results: %v, %v", exe.result["ok"], exe.result["approved"])
+	}
+}
+
+func TestPromptSecretRedacted(t *testing.T) {
+	savedInput, savedOutput, savedSecret, savedTerminal := promptInput, promptOutput, readSecret, stdinIsTerminal
+	defer func() {
+		promptInput, promptOutput, readSecret, stdinIsTerminal = savedInput, savedOutput, savedSecret, savedTerminal
+	}()
+	os.Unsetenv("CI")
+	promptOutput = ioutil.Discard
+	readSecret = func() (string, error) { return "s3cret", nil }
+	stdinIsTerminal = func() bool { return true }
+
+	exe := New()
+	script := `
+password = prompt('password: ', secret=True)
+res = run_local("echo " + password)
+`
+	if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(defaults.workdir)
+
+	// the script gets the secret, the step records do not
+	if exe.result["password"] != starlark.String("s3cret") {
+		t.Errorf("unexpected password: %v", exe.result["password"])
+	}
+	for _, step := range exe.Steps() {
+		if strings.Contains(fmt.Sprint(step.Result), "s3cret") {
+			t.Errorf("secret recorded in step %s: %v", step.Name, step.Result)
+		}
+	}
+	if res := exe.result["res"]; res != starlark.String("s3cret") {
+		t.Errorf("unexpected run_local result: %v", res)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"gopkg.in/yaml.v2"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/vmware-tanzu/crash-diagnostics/buildinfo"
+)
+
+const (
+	// provenanceLocal is the thread local key used to store the run provenance
+	provenanceLocal = "crashd_provenance"
+	// provenanceFileName is the provenance file embedded in archives
+	provenanceFileName = "provenance.yaml"
+)
+
+// secretArgPattern matches the names of the script arguments whose values are not recorded in the
+// provenance (i.e. vcenter_password, api-token, aws_secret_access_key, license_key)
+var secretArgPattern = regexp.MustCompile(`(?i)(^|[_.-])(password|passwd|passphrase|token|secret|credentials?|apikey)([_.-]|$)|(^|[_.-])key$`)
+
+// provenance describes how and where a bundle was produced
+type provenance struct {
+	CrashdVersion string            `yaml:"crashdVersion"`
+	GitSHA        string            `yaml:"gitSHA,omitempty"`
+	Script        string            `yaml:"script"`
+	ScriptSHA256  string            `yaml:"scriptSHA256"`
+	Args          map[string]string `yaml:"args,omitempty"`
+	KubeContext   string            `yaml:"kubeContext,omitempty"`
+	KubeCluster   string            `yaml:"kubeCluster,omitempty"`
+	Operator      string            `yaml:"operator"`
+	StartTime     time.Time         `yaml:"startTime"`
+	EndTime       time.Time         `yaml:"endTime"`
+	// Interrupted is set when the run was stopped before the end of the script (see archivePartial)
+	Interrupted bool         `yaml:"interrupted,omitempty"`
+	Case        *supportCase `yaml:"case,omitempty"`
+}
+
+// supportCase is the support case metadata of an archive, used to route uploaded bundles
+type supportCase struct {
+	ID       string `yaml:"id,omitempty"`
+	Customer string `yaml:"customer,omitempty"`
+	Notes    string `yaml:"notes,omitempty"`
+}
+
+// isEmpty returns true when no case metadata is set
+func (c supportCase) isEmpty() bool {
+	return len(c.ID) == 0 && len(c.Customer) == 0 && len(c.Notes) == 0
+}
+
+// fileName returns the archive file name prefixed with the case ID and customer (i.e.
+// /tmp/12345_ACME_Corp_archive.tar.gz for /tmp/archive.tar.gz)
+func (c supportCase) fileName(outputFile string) string {
+	var prefix string
+	for _, val := range []string{c.ID, c.Customer} {
+		if len(val) > 0 {
+			prefix += sanitizeStr(val) + "_"
+		}
+	}
+	dir, file := filepath.Split(outputFile)
+	return filepath.Join(dir, prefix+file)
+}
+
+func newProvenance(script string, source []byte, args map[string]string) *provenance {
+	return &provenance{
+		CrashdVersion: buildinfo.Version,
+		GitSHA:        buildinfo.GitSHA,
+		Script:        script,
+		ScriptSHA256:  fmt.Sprintf("%x", sha256.Sum256(source)),
+		Args:          args,
+		Operator:      getUsername(),
+		StartTime:     time.Now().UTC(),
+	}
+}
+
+// makeProvenanceFile returns the provenance, as of now, of the run on thread, with the
+// support case metadata of the archive, if any. The kubeconfig context and cluster are
+// those of the kube_config in use.
+func makeProvenanceFile(thread *starlark.Thread, sc supportCase) ([]byte, error) {
+	prov, ok := thread.Local(provenanceLocal).(*provenance)
+	if !ok {
+		return nil, fmt.Errorf("run provenance not found")
+	}
+
+	record := *prov
+	record.EndTime = time.Now().UTC()
+	if len(prov.Args) > 0 {
+		secrets := getSecretValues(thread)
+		record.Args = make(map[string]string, len(prov.Args))
+		for name, value := range prov.Args {
+			if secretArgPattern.MatchString(name) {
+				record.Args[name] = redactedValue
+				continue
+			}
+			record.Args[name] = secrets.redactString(value)
+		}
+	}
+	if !sc.isEmpty() {
+		record.Case = &sc
+	}
+	if kubeConfig, ok := thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct); ok {
+		if path, err := getKubeConfigFromStruct(kubeConfig); err == nil {
+			record.KubeContext, record.KubeCluster = kubeConfigContext(path)
+		}
+	}
+
+	return yaml.Marshal(record)
+}
+
+// kubeConfigContext returns the current context, and its cluster, of the kubeconfig file
+func kubeConfigContext(path string) (string, string) {
+	cfg, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		logrus.Debugf("provenance: failed to load kubeconfig %s: %s", path, err)
+		return "", ""
+	}
+	var cluster string
+	if ctx, ok := cfg.Contexts[cfg.CurrentContext]; ok {
+		cluster = ctx.Cluster
+	}
+	return cfg.CurrentContext, cluster
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+// rbacCaptureFunc is a built-in starlark function that saves the Roles, ClusterRoles, RoleBindings, and
+// ClusterRoleBindings of the cluster under <workdir>/rbac, and the effective permissions of the users,
+// groups, and service accounts (namespace/name) provided, in the format of kubectl auth can-i --list,
+// under <workdir>/rbac/permissions.
+// Starlark format: rbac_capture([users=["user"]][, groups=["group"]][, service_accounts=["namespace/name"]][, workdir=path][, kube_config=kube_config()])
+func rbacCaptureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir string
+	var users, groups, serviceAccounts *starlark.List
+	var kubeConfig *starlarkstruct.Struct
+
+	if err := starlark.UnpackArgs(
+		identifiers.rbacCapture, args, kwargs,
+		"users?", &users,
+		"groups?", &groups,
+		"service_accounts?", &serviceAccounts,
+		"workdir?", &workdir,
+		"kube_config?", &kubeConfig,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.rbacCapture, err)
+	}
+
+	subjects, err := rbacSubjects(toSlice(users), toSlice(groups), toSlice(serviceAccounts))
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.rbacCapture, err)
+	}
+
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+
+	if kubeConfig == nil {
+		kubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
+	}
+	path, err := getKubeConfigFromStruct(kubeConfig)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "failed to kubeconfig")
+	}
+	client, err := k8s.New(path)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "could not initialize search client")
+	}
+
+	resultDir := filepath.Join(workdir, k8s.RBACDirname)
+	snapshot, err := client.GetRBACSnapshot()
+	if err == nil {
+		err = writeRBACSnapshot(snapshot, resultDir, subjects)
+	}
+
+	return starlarkstruct.FromStringDict(
+		starlark.String(identifiers.rbacCapture),
+		starlark.StringDict{
+			"file": starlark.String(resultDir),
+			"error": func() starlark.String {
+				if err != nil {
+					return starlark.String(err.Error())
+				}
+				return ""
+			}(),
+		}), nil
+}
+
+// rbacSubjects returns the subjects of the users, groups, and service accounts (namespace/name)
+func rbacSubjects(users, groups, serviceAccounts []string) ([]k8s.RBACSubject, error) {
+	var subjects []k8s.RBACSubject
+	for _, user := range users {
+		subjects = append(subjects, k8s.RBACSubject{Kind: rbacv1.UserKind, Name: user})
+	}
+	for _, group := range groups {
+		subjects = append(subjects, k8s.RBACSubject{Kind: rbacv1.GroupKind, Name: group})
+	}
+	for _, sa := range serviceAccounts {
+		parts := strings.Split(sa, "/")
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("service account %q: expecting namespace/name", sa)
+		}
+		subjects = append(subjects, k8s.RBACSubject{Kind: rbacv1.ServiceAccountKind, Namespace: parts[0], Name: parts[1]})
+	}
+	return subjects, nil
+}
+
+// writeRBACSnapshot saves the RBAC objects of the snapshot as JSON in resultDir, and the effective
+// permissions of each subject in resultDir/permissions/<kind>_<name>.txt
+func writeRBACSnapshot(snapshot *k8s.RBACSnapshot, resultDir string, subjects []k8s.RBACSubject) error {
+	permissionsDir := filepath.Join(resultDir, "permissions")
+	if err := os.MkdirAll(permissionsDir, 0744); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	objects := map[string]interface{}{
+		"roles.json":               snapshot.Roles,
+		"clusterroles.json":        snapshot.ClusterRoles,
+		"rolebindings.json":        snapshot.RoleBindings,
+		"clusterrolebindings.json": snapshot.ClusterRoleBindings,
+	}
+	for name, items := range objects {
+		data, err := json.MarshalIndent(items, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(resultDir, name), data, 0644); err != nil {
+			return err
+		}
+	}
+
+	for _, subject := range subjects {
+		fileName := fmt.Sprintf("%s_%s.txt", strings.ToLower(subject.Kind), sanitizeStr(subject.String()))
+		file, err := os.Create(filepath.Join(permissionsDir, fileName))
+		if err != nil {
+			return err
+		}
+		err = k8s.WritePermissions(file, subject, snapshot.Permissions(subject))
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("rbac_capture", func() {
+
+	var (
+		executor *Executor
+		err      error
+	)
+
+	execSetup := func(crashdScript string) {
+		executor = New()
+		err = executor.Exec("test.rbac.capture", strings.NewReader(crashdScript))
+	}
+
+	It("saves the RBAC objects and the effective permissions of subjects", func() {
+		crashdScript := fmt.Sprintf(`
+crashd_config(workdir="%s")
+set_defaults(kube_config(path="%s"))
+rbac_data = rbac_capture(groups=["system:masters"], service_accounts=["kube-system/coredns"])
+		`, workdir, k8sconfig)
+		execSetup(crashdScript)
+		Expect(err).NotTo(HaveOccurred())
+
+		dataStruct, ok := executor.result["rbac_data"].(*starlarkstruct.Struct)
+		Expect(ok).To(BeTrue())
+
+		errVal, err := dataStruct.Attr("error")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(errVal.(starlark.String).GoString()).To(BeEmpty())
+
+		fileVal, err := dataStruct.Attr("file")
+		Expect(err).NotTo(HaveOccurred())
+		rbacDir := fileVal.(starlark.String).GoString()
+		Expect(filepath.Join(rbacDir, "clusterrolebindings.json")).To(BeARegularFile())
+
+		masters, err := ioutil.ReadFile(filepath.Join(rbacDir, "permissions", "group_system_masters.txt"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(masters)).To(ContainSubstring("Cluster-wide"))
+		Expect(filepath.Join(rbacDir, "permissions", "serviceaccount_system_serviceaccount_kube_system_coredns.txt")).To(BeARegularFile())
+	})
+
+	It("requires service accounts as namespace/name", func() {
+		execSetup(`rbac_capture(service_accounts=["coredns"])`)
+		Expect(err).To(HaveOccurred())
+	})
+})
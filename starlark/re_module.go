@@ -0,0 +1,94 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"regexp"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// newReModule returns the re module used to post-process command output with regular
+// expressions (Go RE2 syntax).
+// Starlark format: re.match(pattern, string), re.findall(pattern, string), re.replace(pattern, string, repl)
+func newReModule() *starlarkstruct.Module {
+	return &starlarkstruct.Module{
+		Name: identifiers.re,
+		Members: starlark.StringDict{
+			"match":   starlark.NewBuiltin("match", reMatch),
+			"findall": starlark.NewBuiltin("findall", reFindAll),
+			"replace": starlark.NewBuiltin("replace", reReplace),
+		},
+	}
+}
+
+func unpackRegexp(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple, extra ...interface{}) (*regexp.Regexp, string, error) {
+	var pattern, str string
+	pairs := append([]interface{}{"pattern", &pattern, "string", &str}, extra...)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, pairs...); err != nil {
+		return nil, "", err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %s", b.Name(), err)
+	}
+	return re, str, nil
+}
+
+// reMatch returns the first match in string as a list containing the matched text followed
+// by the text of each group, or None when there is no match
+func reMatch(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	re, str, err := unpackRegexp(b, args, kwargs)
+	if err != nil {
+		return starlark.None, err
+	}
+	match := re.FindStringSubmatch(str)
+	if match == nil {
+		return starlark.None, nil
+	}
+	var groups []starlark.Value
+	for _, group := range match {
+		groups = append(groups, starlark.String(group))
+	}
+	return starlark.NewList(groups), nil
+}
+
+// reFindAll returns all the matches in string. When the pattern has no group, each match
+// is the matched text; with one group, the text of the group; otherwise a tuple of the groups.
+func reFindAll(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	re, str, err := unpackRegexp(b, args, kwargs)
+	if err != nil {
+		return starlark.None, err
+	}
+
+	var matches []starlark.Value
+	for _, match := range re.FindAllStringSubmatch(str, -1) {
+		switch len(match) {
+		case 1:
+			matches = append(matches, starlark.String(match[0]))
+		case 2:
+			matches = append(matches, starlark.String(match[1]))
+		default:
+			var groups starlark.Tuple
+			for _, group := range match[1:] {
+				groups = append(groups, starlark.String(group))
+			}
+			matches = append(matches, groups)
+		}
+	}
+	return starlark.NewList(matches), nil
+}
+
+// reReplace replaces the matches in string with repl, where $1 or ${name} are
+// replaced by the text of the corresponding group
+func reReplace(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var repl string
+	re, str, err := unpackRegexp(b, args, kwargs, "repl", &repl)
+	if err != nil {
+		return starlark.None, err
+	}
+	return starlark.String(re.ReplaceAllString(str, repl)), nil
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestReModule(t *testing.T) {
+	tests := []struct {
+		name      string
+		script    string
+		expected  string
+		shouldErr bool
+	}{
+		{
+			name:     "match with groups",
+			script:   `result = re.match(r"(\w+)=(\d+)", "cpu=4 mem=16")`,
+			expected: `["cpu=4", "cpu", "4"]`,
+		},
+		{
+			name:     "no match",
+			script:   `result = re.match("^disk", "cpu=4")`,
+			expected: `None`,
+		},
+		{
+			name:     "findall without group",
+			script:   `result = re.findall(r"\d+\.\d+\.\d+\.\d+", "10.0.0.1 node-a\n10.0.0.2 node-b")`,
+			expected: `["10.0.0.1", "10.0.0.2"]`,
+		},
+		{
+			name:     "findall with one group",
+			script:   `result = re.findall(r"(\S+) NotReady", "node-a Ready\nnode-b NotReady\nnode-c NotReady")`,
+			expected: `["node-b", "node-c"]`,
+		},
+		{
+			name:     "findall with groups",
+			script:   `result = re.findall(r"(\w+)=(\d+)", "cpu=4 mem=16")`,
+			expected: `[("cpu", "4"), ("mem", "16")]`,
+		},
+		{
+			name:     "replace",
+			script:   `result = re.replace(r"token=\S+", "GET /api?token=abc123 200", "token=REDACTED")`,
+			expected: `"GET /api?token=REDACTED 200"`,
+		},
+		{
+			name:     "replace with group",
+			script:   `result = re.replace(pattern=r"(\w+)@example.com", string="admin@example.com", repl="$1@redacted")`,
+			expected: `"admin@redacted"`,
+		},
+		{
+			name:      "invalid pattern",
+			script:    `result = re.match("(unclosed", "text")`,
+			shouldErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			thread := &starlark.Thread{Name: "test"}
+			globals, err := starlark.ExecFile(thread, "test.star", test.script, starlark.StringDict{"re": newReModule()})
+			if err != nil {
+				if !test.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if test.shouldErr {
+				t.Fatal("expecting error")
+			}
+			if globals["result"].String() != test.expected {
+				t.Errorf("unexpected result: got %s, want %s", globals["result"], test.expected)
+			}
+		})
+	}
+}
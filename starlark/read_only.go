@@ -0,0 +1,228 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// DefaultDenyList are the commands rejected in read-only mode. An entry is a command name
+// followed by the arguments that, in the same order, make the command mutate the host or cluster.
+var DefaultDenyList = []string{
+	"rm", "rmdir", "mv", "cp", "install", "dd", "shred", "truncate", "mkfs", "fdisk", "parted", "wipefs",
+	"chmod", "chown", "chgrp", "ln", "tee", "touch", "mkdir", "kill", "pkill", "killall",
+	"sed -i", "sed --in-place", "find -delete", "sysctl -w", "rsync", "scp",
+	"reboot", "shutdown", "poweroff", "halt", "init",
+	"systemctl start", "systemctl stop", "systemctl restart", "systemctl reload", "systemctl enable",
+	"systemctl disable", "systemctl mask", "systemctl kill", "systemctl daemon-reload",
+	"service restart", "service stop", "service start",
+	"kubectl delete", "kubectl apply", "kubectl create", "kubectl replace", "kubectl patch", "kubectl edit",
+	"kubectl scale", "kubectl drain", "kubectl cordon", "kubectl uncordon", "kubectl taint", "kubectl label",
+	"kubectl annotate", "kubectl rollout restart", "kubectl set", "kubectl cp",
+	"crictl rm", "crictl rmi", "crictl rmp", "crictl stop", "crictl stopp",
+	"docker rm", "docker rmi", "docker stop", "docker kill", "docker restart",
+	"iptables -F", "iptables -D", "iptables -A", "iptables -I",
+	"ip link set", "ip addr add", "ip addr del", "ip route add", "ip route del",
+	"apt install", "apt remove", "apt-get install", "apt-get remove", "yum install", "yum remove",
+	"dnf install", "dnf remove", "rpm -e", "pip install",
+}
+
+// redirectDenyEntry is reported for commands redirecting their output to a file
+const redirectDenyEntry = ">"
+
+var (
+	// shellSeparators split a command line into the commands it runs
+	shellSeparators = regexp.MustCompile("&&|\\|\\||[;&|\n`]|\\$\\(|\\)")
+	// fileRedirect matches output redirections, capturing their target. Numbers, and =, are the
+	// operands of comparisons (i.e. in awk programs) rather than files.
+	fileRedirect = regexp.MustCompile(`>>?\s*([^&=\s;|)][^\s;|&)]*)`)
+	numeric      = regexp.MustCompile(`^[0-9.]+$`)
+	// quoted matches the quoted strings of a command line, and shellScript the quoted strings run by
+	// a shell (sh -c '...')
+	quoted      = regexp.MustCompile(`'[^']*'|"[^"]*"`)
+	shellScript = regexp.MustCompile(`(^|[\s;&|(])(sh|bash|dash|ksh|zsh)(\s+-[a-z]+)*\s+-[a-z]*c[a-z]*\s*$`)
+)
+
+// commandWrappers are the commands running the command of their operands (i.e. sudo -u root rm x), with
+// their short options taking an argument
+var commandWrappers = map[string]string{
+	"sudo": "CDghpRrTtUu", "doas": "Cu", "env": "Cu", "nice": "n", "ionice": "cnp", "timeout": "ks",
+	"stdbuf": "eio", "xargs": "aEdILnPs", "nohup": "", "exec": "a", "command": "", "chroot": "", "time": "fo",
+	"setsid": "", "chrt": "", "taskset": "", "unbuffer": "",
+}
+
+// wrapperOperands are the number of operands of the wrappers preceding the command they run
+var wrapperOperands = map[string]int{"timeout": 1, "chroot": 1, "chrt": 1, "taskset": 1}
+
+// wrapperLongOptions are the long options of the wrappers taking an argument, when it is not joined
+// with =
+var wrapperLongOptions = map[string]bool{
+	"--user": true, "--group": true, "--host": true, "--prompt": true, "--role": true, "--type": true,
+	"--other-user": true, "--close-from": true, "--command-timeout": true, "--chdir": true, "--unset": true,
+	"--adjustment": true, "--class": true, "--classdata": true, "--pid": true, "--signal": true,
+	"--kill-after": true, "--input": true, "--output": true, "--error": true, "--arg-file": true,
+	"--delimiter": true, "--max-args": true, "--max-procs": true, "--max-chars": true, "--max-lines": true,
+	"--userspec": true, "--groups": true, "--format": true,
+}
+
+// shellKeywords are the reserved words of the shell preceding a command
+var shellKeywords = map[string]bool{
+	"if": true, "then": true, "else": true, "elif": true, "do": true, "while": true, "until": true, "!": true,
+}
+
+// redirectTargets are the files output can be redirected to in read-only mode
+var redirectTargets = map[string]bool{"/dev/null": true, "/dev/stdout": true, "/dev/stderr": true}
+
+// checkReadOnly returns an error when the run is read-only (--read-only) and cmdStr runs a command
+// of the deny list (DefaultDenyList, and --deny entries)
+func checkReadOnly(opts ExecOptions, name, cmdStr string) error {
+	if !opts.ReadOnly {
+		return nil
+	}
+	denyList := append(append([]string{}, DefaultDenyList...), opts.DenyList...)
+	if entry, ok := deniedCommand(cmdStr, denyList); ok {
+		return fmt.Errorf("%s: command [%s] rejected in read-only mode (denied: %s)", name, cmdStr, entry)
+	}
+	return nil
+}
+
+// deniedCommand returns the deny list entry matched by one of the commands of the command line
+func deniedCommand(cmdStr string, denyList []string) (string, bool) {
+	// quoted strings are arguments (i.e. the comparisons of awk programs), unless they are run by a shell
+	unquoted := cmdStr
+	for _, loc := range quoted.FindAllStringIndex(cmdStr, -1) {
+		if shellScript.MatchString(cmdStr[:loc[0]]) {
+			if entry, denied := deniedCommand(cmdStr[loc[0]+1:loc[1]-1], denyList); denied {
+				return entry, true
+			}
+		}
+		unquoted = unquoted[:loc[0]] + strings.Repeat(" ", loc[1]-loc[0]) + unquoted[loc[1]:]
+	}
+	for _, match := range fileRedirect.FindAllStringSubmatch(unquoted, -1) {
+		target := strings.Trim(match[1], `'"`)
+		if !redirectTargets[target] && !numeric.MatchString(target) {
+			return redirectDenyEntry, true
+		}
+	}
+	for _, segment := range shellSeparators.Split(cmdStr, -1) {
+		words := commandWords(segment)
+		if len(words) == 0 {
+			continue
+		}
+		for _, entry := range denyList {
+			if matchDenyEntry(words, strings.Fields(entry)) {
+				return entry, true
+			}
+		}
+	}
+	return "", false
+}
+
+// commandWords returns the words of a simple command, without the leading variable assignments, shell
+// keywords, and wrappers (sudo, env, timeout, nohup, ...) with their options and operands, with the
+// command name stripped of its directory
+func commandWords(segment string) []string {
+	words := strings.Fields(strings.NewReplacer("'", " ", "\"", " ", "(", " ", "{", " ", "}", " ").Replace(segment))
+	for len(words) > 0 {
+		word := words[0]
+		switch {
+		case strings.Contains(word, "=") && !strings.HasPrefix(word, "-"):
+			words = words[1:]
+		case shellKeywords[word]:
+			words = words[1:]
+		case word == "sh" || word == "bash" || word == "dash" || word == "ksh" || word == "zsh":
+			// shells running their -c argument
+			words = words[1:]
+			for len(words) > 0 && strings.HasPrefix(words[0], "-") {
+				words = words[1:]
+			}
+		default:
+			name := path.Base(word)
+			options, wrapper := commandWrappers[name]
+			if !wrapper {
+				words[0] = name
+				return words
+			}
+			words = skipWrapperArgs(name, options, words[1:])
+		}
+	}
+	return nil
+}
+
+// skipWrapperArgs returns the words following the options and operands of a command wrapper, that is the
+// command it runs
+func skipWrapperArgs(name, options string, words []string) []string {
+	for len(words) > 0 && strings.HasPrefix(words[0], "-") && words[0] != "-" {
+		word := words[0]
+		words = words[1:]
+		switch {
+		case word == "--":
+			return skipOperands(name, words)
+		case strings.HasPrefix(word, "--"):
+			if wrapperLongOptions[word] && len(words) > 0 {
+				words = words[1:]
+			}
+		default:
+			// grouped short options (-iu root), the last one taking the next word as argument unless the
+			// argument is joined (-uroot)
+			for i := 1; i < len(word); i++ {
+				if strings.IndexByte(options, word[i]) >= 0 {
+					if i == len(word)-1 && len(words) > 0 {
+						words = words[1:]
+					}
+					break
+				}
+			}
+		}
+	}
+	return skipOperands(name, words)
+}
+
+func skipOperands(name string, words []string) []string {
+	for i := 0; i < wrapperOperands[name] && len(words) > 0; i++ {
+		words = words[1:]
+	}
+	return words
+}
+
+// matchDenyEntry returns true when the command name is the first word of the entry, and the other words
+// of the entry are arguments of the command, in the same order
+func matchDenyEntry(words, entry []string) bool {
+	if len(entry) == 0 || words[0] != entry[0] {
+		return false
+	}
+	next := 1
+	for _, word := range words[1:] {
+		if next < len(entry) && matchDenyWord(word, entry[next]) {
+			next++
+		}
+	}
+	return next == len(entry)
+}
+
+// matchDenyWord returns true when the word is the word of the entry, when it is the option of the entry
+// with its argument (i.e. --in-place=.bak), or, for a short option of the entry (i.e. -i), when the
+// option is grouped with other options (i.e. -ni) or joined with its argument (i.e. -i.bak)
+func matchDenyWord(word, entry string) bool {
+	switch {
+	case word == entry:
+		return true
+	case strings.HasPrefix(entry, "--"):
+		return strings.HasPrefix(word, entry+"=")
+	case len(entry) == 2 && entry[0] == '-' && len(word) > 2 && word[0] == '-' && word[1] != '-':
+		for _, c := range word[1:] {
+			if c == rune(entry[1]) {
+				return true
+			}
+			if !unicode.IsLetter(c) {
+				break
+			}
+		}
+	}
+	return false
+}
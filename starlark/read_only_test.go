@@ -0,0 +1,79 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestDeniedCommand(t *testing.T) {
+	tests := []struct {
+		cmd    string
+		denied string
+	}{
+		{cmd: "journalctl -u kubelet --no-pager 2>&1"},
+		{cmd: "df -h > /dev/null 2>/dev/null; uptime"},
+		{cmd: "ps aux | awk '$3 > 80 {print}'"},
+		{cmd: "kubectl get pods -A"},
+		{cmd: "systemctl status kubelet"},
+		{cmd: "rm -rf /var/lib/kubelet", denied: "rm"},
+		{cmd: "sudo /bin/rm -f /tmp/x", denied: "rm"},
+		{cmd: "uptime && sudo systemctl --no-block restart kubelet", denied: "systemctl restart"},
+		{cmd: "kubectl -n kube-system delete pod coredns-1", denied: "kubectl delete"},
+		{cmd: "sh -c 'FOO=1 rm /etc/hosts'", denied: "rm"},
+		{cmd: "echo $(reboot)", denied: "reboot"},
+		{cmd: "echo data > /etc/hosts", denied: redirectDenyEntry},
+		{cmd: "curl -X DELETE http://localhost", denied: "curl -X"},
+		{cmd: "sudo -u root rm -rf /", denied: "rm"},
+		{cmd: "sudo --user root -- rm -rf /", denied: "rm"},
+		{cmd: "timeout 5 rm x", denied: "rm"},
+		{cmd: "timeout -s KILL 5s env -u HOME FOO=1 nice -n 10 rm x", denied: "rm"},
+		{cmd: "if true; then rm -rf /tmp/x; fi", denied: "rm"},
+		{cmd: "sudo cp /etc/hosts /etc/hosts.bak", denied: "cp"},
+		{cmd: "install -m 0755 crashd /usr/local/bin", denied: "install"},
+		{cmd: "sed -i.bak 's/a/b/' /etc/hosts", denied: "sed -i"},
+		{cmd: "sed -ni 's/a/b/p' /etc/hosts", denied: "sed -i"},
+		{cmd: "find /var/log -name '*.gz' -delete", denied: "find -delete"},
+		{cmd: "sh -c 'echo data > /etc/hosts'", denied: redirectDenyEntry},
+		{cmd: "awk '$1 > max {max=$1} END {print max}' /proc/loadavg"},
+		{cmd: "sed -n 's/x/y/p' /etc/hosts"},
+		{cmd: "timeout 5 journalctl -u kubelet"},
+		{cmd: "command -v rc-service >/dev/null 2>&1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.cmd, func(t *testing.T) {
+			entry, denied := deniedCommand(test.cmd, append(DefaultDenyList, "curl -X"))
+			if denied != (len(test.denied) > 0) || entry != test.denied {
+				t.Errorf("unexpected result for [%s]: denied=%t (%s), expecting %q", test.cmd, denied, entry, test.denied)
+			}
+		})
+	}
+}
+
+func TestReadOnlyScript(t *testing.T) {
+	exe := New()
+	exe.SetOptions(ExecOptions{ReadOnly: true})
+	err := exe.Exec("test.star", strings.NewReader(`run_local("rm -rf /tmp/crashd-read-only")`))
+	if err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Fatalf("expecting command rejected in read-only mode, got: %v", err)
+	}
+
+	exe = New()
+	exe.SetOptions(ExecOptions{ReadOnly: true})
+	if err := exe.Exec("test.star", strings.NewReader(`result = run_local("echo read-only")`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the commands run by the collection functions are checked too
+	restore := setExecGuard(ExecOptions{ReadOnly: true})
+	defer restore()
+	_, err = execRun(command{line: "pkill -f iperf3"}, starlark.NewList(nil))
+	if err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Fatalf("expecting command rejected in read-only mode, got: %v", err)
+	}
+}
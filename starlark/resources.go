@@ -5,20 +5,26 @@ package starlark
 
 import (
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
 
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
 )
 
 // resourcesFunc is a built-in starlark function that prepares returns compute list of resources.
-// Starlark format: resources(provider=<provider-function>)
+// When ssh_config is provided, it is used instead of the default (hosts) or the provider ssh_config.
+// Starlark format: resources(provider=<provider-function> [, ssh_config=ssh_config()|"<name>"])
 func resourcesFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var hosts *starlark.List
 	var provider *starlarkstruct.Struct
+	var sshCfgVal starlark.Value
 	if err := starlark.UnpackArgs(
 		identifiers.crashdCfg, args, kwargs,
 		"hosts?", &hosts,
 		"provider?", &provider,
+		"ssh_config?", &sshCfgVal,
 	); err != nil {
 		return starlark.None, fmt.Errorf("%s: %s", identifiers.hostListProvider, err)
 	}
@@ -32,7 +38,11 @@ func resourcesFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.T
 	}
 
 	if hosts != nil {
-		prov, err := hostListProvider(thread, nil, nil, []starlark.Tuple{{starlark.String("hosts"), hosts}})
+		providerArgs := []starlark.Tuple{{starlark.String("hosts"), hosts}}
+		if sshCfgVal != nil {
+			providerArgs = append(providerArgs, starlark.Tuple{starlark.String("ssh_config"), sshCfgVal})
+		}
+		prov, err := hostListProvider(thread, nil, nil, providerArgs)
 		if err != nil {
 			return starlark.None, err
 		}
@@ -44,6 +54,17 @@ func resourcesFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.T
 	if err != nil {
 		return starlark.None, err
 	}
+	if err := checkPolicyResources(thread, identifiers.resources, resources); err != nil {
+		return starlark.None, err
+	}
+
+	if sshCfgVal != nil && hosts == nil {
+		sshCfg, err := getSSHConfig(thread, sshCfgVal)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.resources, err)
+		}
+		resources = withSSHConfig(resources, sshCfg)
+	}
 
 	return resources, nil
 }
@@ -65,7 +86,7 @@ func enum(provider *starlarkstruct.Struct) (*starlark.List, error) {
 	kind := trimQuotes(kindVal.String())
 
 	switch kind {
-	case identifiers.hostListProvider, identifiers.kubeNodesProvider, identifiers.capvProvider:
+	case identifiers.hostListProvider, identifiers.kubeNodesProvider, identifiers.capvProvider, identifiers.capzProvider:
 		hosts, err := provider.Attr("hosts")
 		if err != nil {
 			return nil, fmt.Errorf("hosts not found in %s", identifiers.hostListProvider)
@@ -87,12 +108,61 @@ func enum(provider *starlarkstruct.Struct) (*starlark.List, error) {
 		}
 
 		for i := 0; i < hostList.Len(); i++ {
+			host, hostSSHCfg := hostList.Index(i), sshCfg
+			// a host specified as host:port overrides the ssh_config port
+			if hostStr, ok := host.(starlark.String); ok {
+				hostName, port := splitHostPort(string(hostStr))
+				host = starlark.String(hostName)
+				if len(port) > 0 {
+					cfg, err := withSSHPort(sshCfg, port)
+					if err != nil {
+						return nil, err
+					}
+					hostSSHCfg = cfg
+				}
+			}
+
 			dict := starlark.StringDict{
 				"kind":       starlark.String(identifiers.hostResource),
 				"provider":   starlark.String(identifiers.hostListProvider),
-				"host":       hostList.Index(i),
+				"host":       host,
 				"transport":  transport,
-				"ssh_config": sshCfg,
+				"ssh_config": hostSSHCfg,
+			}
+			resources = append(resources, starlarkstruct.FromStringDict(starlark.String(identifiers.hostResource), dict))
+		}
+	case identifiers.localProvider:
+		dict := starlark.StringDict{
+			"kind":      starlark.String(identifiers.hostResource),
+			"provider":  starlark.String(identifiers.localProvider),
+			"host":      starlark.String(localHost),
+			"transport": starlark.String(localTransport),
+		}
+		resources = append(resources, starlarkstruct.FromStringDict(starlark.String(identifiers.hostResource), dict))
+	case identifiers.kubeProxyProvider, identifiers.kubeAgentProvider:
+		hosts, err := provider.Attr("hosts")
+		if err != nil {
+			return nil, fmt.Errorf("hosts not found in %s", kind)
+		}
+		hostList, ok := hosts.(*starlark.List)
+		if !ok {
+			return nil, fmt.Errorf("%s: unexpected type for hosts: %T", kind, hosts)
+		}
+		transport, err := provider.Attr("transport")
+		if err != nil {
+			return nil, fmt.Errorf("transport not found in %s", kind)
+		}
+		for i := 0; i < hostList.Len(); i++ {
+			dict := starlark.StringDict{
+				"kind":      starlark.String(identifiers.hostResource),
+				"provider":  starlark.String(kind),
+				"host":      hostList.Index(i),
+				"transport": transport,
+			}
+			for _, name := range []string{"kube_config", "port", "scheme", "agent"} {
+				if val, err := provider.Attr(name); err == nil {
+					dict[name] = val
+				}
 			}
 			resources = append(resources, starlarkstruct.FromStringDict(starlark.String(identifiers.hostResource), dict))
 		}
@@ -100,3 +170,28 @@ func enum(provider *starlarkstruct.Struct) (*starlark.List, error) {
 
 	return starlark.NewList(resources), nil
 }
+
+// splitHostPort splits a host:port (or [ipv6]:port) address. Hosts without
+// a port, including bare IPv6 addresses, are returned with an empty port.
+func splitHostPort(addr string) (string, string) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return strings.Trim(addr, "[]"), ""
+	}
+	return host, port
+}
+
+// withSSHPort returns a copy of the ssh_config struct using the specified port
+func withSSHPort(sshCfg starlark.Value, port string) (*starlarkstruct.Struct, error) {
+	cfg, ok := sshCfg.(*starlarkstruct.Struct)
+	if !ok {
+		return nil, fmt.Errorf("%s: unexpected type %T", identifiers.sshCfg, sshCfg)
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		return nil, fmt.Errorf("%s: invalid port %s", identifiers.sshCfg, port)
+	}
+	dict := starlark.StringDict{}
+	cfg.ToStringDict(dict)
+	dict[identifiers.port] = starlark.String(port)
+	return starlarkstruct.FromStringDict(starlark.String(identifiers.sshCfg), dict), nil
+}
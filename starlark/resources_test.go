@@ -276,3 +276,51 @@ res = resources(hosts=["foo.host.1", "local.host", "10.10.10.1"])`,
 		})
 	}
 }
+
+func TestResourcesHostPort(t *testing.T) {
+	thread := newTestThreadLocal(t)
+	thread.SetLocal(identifiers.sshCfg, starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"username": starlark.String("uname"),
+		"port":     starlark.String("22"),
+	}))
+	hosts := starlark.NewList([]starlark.Value{
+		starlark.String("foo.host.1"),
+		starlark.String("foo.host.2:2222"),
+		starlark.String("[fe80::1]:2200"),
+		starlark.String("fe80::2"),
+	})
+	res, err := resourcesFunc(thread, nil, nil, []starlark.Tuple{{starlark.String("hosts"), hosts}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources := res.(*starlark.List)
+
+	expected := []struct{ host, port string }{
+		{"foo.host.1", "22"}, {"foo.host.2", "2222"}, {"fe80::1", "2200"}, {"fe80::2", "22"},
+	}
+	if resources.Len() != len(expected) {
+		t.Fatalf("expecting %d resources, got %d", len(expected), resources.Len())
+	}
+	for i, exp := range expected {
+		resStruct := resources.Index(i).(*starlarkstruct.Struct)
+		val, err := resStruct.Attr("host")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(val.(starlark.String)) != exp.host {
+			t.Errorf("unexpected host: %s", val)
+		}
+
+		cfgVal, err := resStruct.Attr(identifiers.sshCfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		args, err := getSSHArgsFromCfg(cfgVal.(*starlarkstruct.Struct))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if args.Port != exp.port {
+			t.Errorf("host %s: expecting port %s, got %s", exp.host, exp.port, args.Port)
+		}
+	}
+}
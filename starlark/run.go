@@ -5,11 +5,14 @@ package starlark
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
 
+	"github.com/vmware-tanzu/crash-diagnostics/proc"
 	"github.com/vmware-tanzu/crash-diagnostics/ssh"
 )
 
@@ -17,6 +20,30 @@ type commandResult struct {
 	resource string
 	result   string
 	err      error
+
+	// output and exit status of the commands of run()
+	stdout   string
+	stderr   string
+	exitCode int
+	duration time.Duration
+}
+
+// newRunResult returns the result of a command of run() executed on resource. The result is the
+// trimmed output, standard output and error combined, and the error, when the command was executed,
+// its exit status.
+func newRunResult(resource string, out proc.Result, err error) commandResult {
+	if err == nil {
+		err = out.Err()
+	}
+	return commandResult{
+		resource: resource,
+		result:   strings.TrimSpace(out.Output),
+		err:      err,
+		stdout:   out.Stdout,
+		stderr:   out.Stderr,
+		exitCode: out.ExitCode,
+		duration: out.Duration,
+	}
 }
 
 func (r commandResult) toStarlarkStruct() *starlarkstruct.Struct {
@@ -35,21 +62,57 @@ func (r commandResult) toStarlarkStruct() *starlarkstruct.Struct {
 	)
 }
 
+// toRunStruct returns the result of a command of run(), with its output and exit status
+func (r commandResult) toRunStruct() *starlarkstruct.Struct {
+	result := r.toStarlarkStruct()
+	fields := starlark.StringDict{
+		"exit_code": starlark.MakeInt(r.exitCode),
+		"stdout":    starlark.String(r.stdout),
+		"stderr":    starlark.String(r.stderr),
+		"duration":  durationValue(r.duration),
+	}
+	for _, name := range result.AttrNames() {
+		fields[name], _ = result.Attr(name)
+	}
+	return starlarkstruct.FromStringDict(starlark.String("command_result"), fields)
+}
+
 // runFunc is a built-in starlark function that runs a provided command.
 // It returns the result of the command as struct containing  information
 // about the executed command on the provided compute resources.  If resources
 // is not provided, runFunc uses the default resources found in the starlark thread.
-// Starlark format: run(cmd="command" [,resources=resources])
+// When echo is True, the command output is also printed to the console. When ssh_config is provided,
+// it is used instead of the ssh_config of the resources. Each result holds the exit status, stdout, and
+// stderr of the command on its resource, so that scripts can handle the failures of each host.
+// The command, a command line or a list of words, is run by shell (see newCommand) with the variables
+// of env set in its environment.
+// Starlark format: run(cmd="command"|["word", ...] [,resources=resources][,echo=bool][,ssh_config=ssh_config()|"<name>"]
+// [,shell="bash|sh|powershell|none"][,env={"NAME": "value"}])
 func runFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-	var cmdStr string
+	var cmdVal starlark.Value
 	var resources *starlark.List
+	var echo bool
+	var sshCfgVal starlark.Value
+	var shell string
+	var envDict *starlark.Dict
 	if err := starlark.UnpackArgs(
 		identifiers.crashdCfg, args, kwargs,
-		"cmd", &cmdStr,
+		"cmd", &cmdVal,
 		"resources?", &resources,
+		"echo?", &echo,
+		"ssh_config?", &sshCfgVal,
+		"shell?", &shell,
+		"env?", &envDict,
 	); err != nil {
 		return starlark.None, fmt.Errorf("%s: %s", identifiers.run, err)
 	}
+	cmd, err := newCommand(thread, cmdVal, shell, envDict)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.run, err)
+	}
+	if err := checkCommand(thread, identifiers.run, cmd.line); err != nil {
+		return starlark.None, err
+	}
 
 	if resources == nil {
 		res := thread.Local(identifiers.resources)
@@ -62,29 +125,42 @@ func runFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple,
 		}
 		resources = resList
 	}
+	if sshCfgVal != nil {
+		sshCfg, err := getSSHConfig(thread, sshCfgVal)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.run, err)
+		}
+		resources = withSSHConfig(resources, sshCfg)
+	}
 
-	results, err := execRun(cmdStr, resources)
+	results, err := execRun(cmd, resources)
 	if err != nil {
 		return starlark.None, err
 	}
+	teeRunResults(getTeeWriter(thread, echo), cmd.line, results)
 
 	// build list of struct as result
 	var resultList []starlark.Value
 	for _, result := range results {
 		if len(results) == 1 {
-			return result.toStarlarkStruct(), nil
+			return result.toRunStruct(), nil
 		}
-		resultList = append(resultList, result.toStarlarkStruct())
+		resultList = append(resultList, result.toRunStruct())
 	}
 
 	return starlark.NewList(resultList), nil
 }
 
-func execRun(cmdStr string, resources *starlark.List) ([]commandResult, error) {
+// execRun runs the command on each resource
+func execRun(cmd command, resources *starlark.List) ([]commandResult, error) {
 	if resources == nil {
 		return nil, fmt.Errorf("%s: missing resources", identifiers.run)
 	}
 
+	if err := guardCommand(identifiers.run, cmd.line); err != nil {
+		return nil, err
+	}
+
 	logrus.Debugf("%s: executing command on %d resources", identifiers.run, resources.Len())
 	var results []commandResult
 	for i := 0; i < resources.Len(); i++ {
@@ -105,13 +181,41 @@ func execRun(cmdStr string, resources *starlark.List) ([]commandResult, error) {
 			return nil, fmt.Errorf("%s: resource.transport: %s", identifiers.run, err)
 		}
 		transport := val.(starlark.String)
+		if err := guardTransport(identifiers.run, string(transport)); err != nil {
+			return nil, err
+		}
 
 		switch {
 		case string(kind) == identifiers.hostResource && string(transport) == "ssh":
-			result, err := execRunSSH(cmdStr, res)
+			result, err := execRunSSH(cmd, res)
+			if err != nil {
+				// keep the failed host in the results so that it is part of the error report
+				logrus.Error(err)
+				result = commandResult{resource: resourceHost(res), err: err, exitCode: -1}
+			}
+			results = append(results, result)
+		case string(kind) == identifiers.hostResource && string(transport) == localTransport:
+			result, err := execRunLocal(cmd)
+			if err != nil {
+				logrus.Error(err)
+				result = commandResult{resource: localHost, err: err, exitCode: -1}
+			}
+			results = append(results, result)
+		case string(kind) == identifiers.hostResource && string(transport) == kubeProxyTransport:
+			if len(cmd.env) > 0 {
+				logrus.Warnf("%s: env is ignored by the %s transport", identifiers.run, kubeProxyTransport)
+			}
+			result, err := execRunKubeProxy(cmd.line, res)
+			if err != nil {
+				logrus.Error(err)
+				result = commandResult{resource: resourceHost(res), err: err, exitCode: -1}
+			}
+			results = append(results, result)
+		case string(kind) == identifiers.hostResource && string(transport) == kubeAgentTransport:
+			result, err := execRunKubeAgent(cmd, res)
 			if err != nil {
 				logrus.Error(err)
-				continue
+				result = commandResult{resource: resourceHost(res), err: err, exitCode: -1}
 			}
 			results = append(results, result)
 		default:
@@ -123,8 +227,18 @@ func execRun(cmdStr string, resources *starlark.List) ([]commandResult, error) {
 	return results, nil
 }
 
+// resourceHost returns the host of a host resource, or an empty string when it is not set
+func resourceHost(res *starlarkstruct.Struct) string {
+	if val, err := res.Attr("host"); err == nil {
+		if host, ok := val.(starlark.String); ok {
+			return string(host)
+		}
+	}
+	return ""
+}
+
 // execRunSSH executes `run` command for a Host Resource using SSH
-func execRunSSH(cmdStr string, res *starlarkstruct.Struct) (commandResult, error) {
+func execRunSSH(cmd command, res *starlarkstruct.Struct) (commandResult, error) {
 	sshCfg := starlarkstruct.FromKeywords(starlarkstruct.Default, makeDefaultSSHConfig())
 	if val, err := res.Attr(identifiers.sshCfg); err == nil {
 		if cfg, ok := val.(*starlarkstruct.Struct); ok {
@@ -148,9 +262,14 @@ func execRunSSH(cmdStr string, res *starlarkstruct.Struct) (commandResult, error
 	}
 	args.Host = string(host)
 
-	logrus.Debugf("%s: executing command on %s using ssh: [%s]", identifiers.run, args.Host, cmdStr)
-	cmdResult, err := ssh.Run(args, cmdStr)
-	return commandResult{resource: args.Host, result: cmdResult, err: err}, nil
+	remoteCmd, err := cmd.remote()
+	if err != nil {
+		return commandResult{}, fmt.Errorf("%s: %s", identifiers.run, err)
+	}
+
+	logrus.Debugf("%s: executing command on %s using ssh: [%s]", identifiers.run, args.Host, remoteCmd)
+	out, err := ssh.RunCommand(args, remoteCmd)
+	return newRunResult(args.Host, out, err), nil
 
 }
 
@@ -200,12 +319,45 @@ func getSSHArgsFromCfg(sshCfg *starlarkstruct.Struct) (ssh.SSHArgs, error) {
 		privateKeyPath = pkPath.GoString()
 	}
 
+	var hostKeyChecking, knownHostsFile string
+	if val, err := sshCfg.Attr("host_key_checking"); err == nil {
+		if mode, ok := val.(starlark.String); ok {
+			hostKeyChecking = string(mode)
+		}
+	}
+	if val, err := sshCfg.Attr("known_hosts_file"); err == nil {
+		if path, ok := val.(starlark.String); ok {
+			knownHostsFile = string(path)
+		}
+	}
+
+	var multiplex bool
+	if val, err := sshCfg.Attr("multiplex"); err == nil {
+		multiplex = bool(val.Truth())
+	}
+
+	intAttr := func(name string) int {
+		if val, err := sshCfg.Attr(name); err == nil {
+			if i, ok := val.(starlark.Int); ok {
+				return int(i.BigInt().Int64())
+			}
+		}
+		return 0
+	}
+
 	args := ssh.SSHArgs{
-		User:           string(user),
-		Port:           port,
-		MaxRetries:     maxRetries,
-		ProxyJump:      jumpProxy,
-		PrivateKeyPath: privateKeyPath,
+		User:            string(user),
+		Port:            port,
+		MaxRetries:      maxRetries,
+		ProxyJump:       jumpProxy,
+		PrivateKeyPath:  privateKeyPath,
+		HostKeyChecking: hostKeyChecking,
+		KnownHostsFile:  knownHostsFile,
+		Multiplex:       multiplex,
+
+		ConnectTimeout:    intAttr("conn_timeout"),
+		KeepAliveInterval: intAttr("keepalive_interval"),
+		KeepAliveCount:    intAttr("keepalive_count"),
 	}
 	return args, nil
 }
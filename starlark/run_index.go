@@ -0,0 +1,110 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// indexFileName is the index of the run steps and their output files saved in the working directory
+const indexFileName = "index.json"
+
+// runIndex is the content of the index file: every step of the run, in invocation order,
+// with the files it produced, so that tools processing bundles do not depend on their layout
+type runIndex struct {
+	Steps []indexStep `json:"steps"`
+}
+
+// indexStep is a step of the run index
+type indexStep struct {
+	Step string `json:"step"`
+	// Position is the location, in the script, of the invocation
+	Position string    `json:"position,omitempty"`
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"duration_seconds"`
+	// Status is ok, or failed when the step itself failed
+	Status  string        `json:"status"`
+	Error   string        `json:"error,omitempty"`
+	Outputs []indexOutput `json:"outputs,omitempty"`
+}
+
+// indexOutput is a result of a step for one of its targets
+type indexOutput struct {
+	Target string `json:"target,omitempty"`
+	Status string `json:"status"`
+	// Files are the output files, relative to the working directory when under it
+	Files []string `json:"files,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// newRunIndex returns the index of the steps
+func newRunIndex(steps []stepTiming, workdir string) runIndex {
+	index := runIndex{Steps: []indexStep{}}
+	for _, step := range steps {
+		index.Steps = append(index.Steps, newIndexStep(step, workdir))
+	}
+	return index
+}
+
+// newIndexStep returns the index entry of the step. Output directories (i.e. of kube_capture) are
+// expanded to the files they contain.
+func newIndexStep(step stepTiming, workdir string) indexStep {
+	entry := indexStep{
+		Step:     step.name,
+		Position: step.pos,
+		Start:    step.start,
+		Duration: step.duration.Seconds(),
+		Status:   "ok",
+	}
+	if step.err != nil {
+		entry.Status, entry.Error = "failed", step.err.Error()
+	}
+
+	for _, row := range summaryRowsOf(step.result) {
+		output := indexOutput{Target: row.target, Status: "ok"}
+		if row.failed {
+			output.Status, output.Error = "failed", row.output
+		} else {
+			output.Files = indexFiles(row.output, workdir)
+		}
+		entry.Outputs = append(entry.Outputs, output)
+	}
+	return entry
+}
+
+// indexFiles returns the regular files of path, a file or a directory
+func indexFiles(path, workdir string) []string {
+	if len(path) == 0 {
+		return nil
+	}
+	var files []string
+	filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		if rel, err := filepath.Rel(workdir, file); err == nil && !strings.HasPrefix(rel, "..") {
+			file = filepath.ToSlash(rel)
+		}
+		files = append(files, file)
+		return nil
+	})
+	return files
+}
+
+// writeRunIndex saves the index, as JSON, in the working directory
+func writeRunIndex(index runIndex, workdir string) error {
+	if _, err := os.Stat(workdir); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(workdir, indexFileName), data, 0644)
+}
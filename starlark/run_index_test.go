@@ -0,0 +1,82 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+func TestRunIndexFile(t *testing.T) {
+	workdir, err := ioutil.TempDir("", "crashd-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+
+	dfFile := filepath.Join(workdir, "10_0_0_1", "df.txt")
+	podsFile := filepath.Join(workdir, "kubecapture", "core_v1", "pods.json")
+	for _, file := range []string{dfFile, podsFile} {
+		if err := os.MkdirAll(filepath.Dir(file), 0744); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := []stepTiming{
+		{name: "ssh_config", start: time.Now()},
+		{name: "capture", pos: "test.star:3:8", start: time.Now(), duration: 2 * time.Second, result: commandResultsToList([]commandResult{
+			{resource: "10.0.0.1", result: dfFile},
+			{resource: "10.0.0.2", err: errors.New("connection refused")},
+		})},
+		{name: "kube_capture", start: time.Now(), result: starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"file":  starlark.String(filepath.Join(workdir, "kubecapture")),
+			"error": starlark.String(""),
+		})},
+		{name: "kube_get", start: time.Now(), err: errors.New("kube_get: failed to kubeconfig")},
+	}
+	if err := writeRunIndex(newRunIndex(steps, workdir), workdir); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(workdir, indexFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index runIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Steps) != 4 {
+		t.Fatalf("expecting 4 steps, got %s", data)
+	}
+
+	capture := index.Steps[1]
+	if capture.Step != "capture" || capture.Position != "test.star:3:8" || capture.Duration != 2 || len(capture.Outputs) != 2 {
+		t.Fatalf("unexpected capture step: %#v", capture)
+	}
+	if capture.Outputs[0].Target != "10.0.0.1" || strings.Join(capture.Outputs[0].Files, ",") != "10_0_0_1/df.txt" {
+		t.Errorf("unexpected capture output: %#v", capture.Outputs[0])
+	}
+	if capture.Outputs[1].Status != "failed" || capture.Outputs[1].Error != "connection refused" {
+		t.Errorf("unexpected failed capture output: %#v", capture.Outputs[1])
+	}
+	if outputs := index.Steps[2].Outputs; len(outputs) != 1 || strings.Join(outputs[0].Files, ",") != "kubecapture/core_v1/pods.json" {
+		t.Errorf("unexpected kube_capture outputs: %#v", outputs)
+	}
+	if step := index.Steps[3]; step.Status != "failed" || step.Error != "kube_get: failed to kubeconfig" {
+		t.Errorf("unexpected failed step: %#v", step)
+	}
+}
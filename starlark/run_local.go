@@ -5,8 +5,8 @@ package starlark
 
 import (
 	"fmt"
+	"strings"
 
-	"github.com/vladimirvivien/echo"
 	"go.starlark.net/starlark"
 )
 
@@ -22,10 +22,14 @@ func runLocalFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tu
 		return starlark.None, fmt.Errorf("%s: %s", identifiers.run, err)
 	}
 
-	p := echo.New().RunProc(cmdStr)
-	if p.Err() != nil {
-		return starlark.None, fmt.Errorf("%s: %s: %s", identifiers.runLocal, p.Err(), p.Result())
+	if err := checkCommand(thread, identifiers.runLocal, cmdStr); err != nil {
+		return starlark.None, err
 	}
 
-	return starlark.String(p.Result()), nil
+	out, err := runLocalProc(thread, cmdStr)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.runLocal, err)
+	}
+
+	return starlark.String(strings.TrimSpace(out.Output)), nil
 }
@@ -0,0 +1,228 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+const (
+	// runLockLocal is the thread local key used to store the run locker of a locked run
+	runLockLocal = "crashd_run_lock"
+	// runLeaseNamespace is the namespace of the leases of locked runs
+	runLeaseNamespace = "kube-system"
+	// runLeaseDuration is the duration after which the lease of a run that stopped renewing it expires
+	runLeaseDuration = time.Minute
+)
+
+// runLockDir is the directory of the lock files of locked runs
+var runLockDir = filepath.Join(os.TempDir(), "crashd-locks")
+
+// runLock is the content of the lock file of a locked run
+type runLock struct {
+	Holder string    `json:"holder"`
+	Host   string    `json:"host"`
+	PID    int       `json:"pid"`
+	Script string    `json:"script"`
+	Since  time.Time `json:"since"`
+}
+
+// runLocker acquires the lock of a locked run (--lock) when its first step runs, once the script has
+// declared the kube config it uses (see set_defaults), and releases it at the end of the run
+type runLocker struct {
+	name     string
+	src      []byte
+	force    bool
+	readOnly bool
+
+	once    sync.Once
+	err     error
+	release func()
+}
+
+// acquire acquires the lock, for the cluster of the kube config of the script, the first time it is called.
+// It returns the error of the first acquisition.
+func (l *runLocker) acquire(thread *starlark.Thread) error {
+	l.once.Do(func() {
+		var kubeconfig string
+		if kubeConfig, ok := thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct); ok {
+			kubeconfig, _ = getKubeConfigFromStruct(kubeConfig)
+		}
+		l.release, l.err = acquireRunLock(l.name, l.src, kubeconfig, l.force, l.readOnly)
+	})
+	return l.err
+}
+
+// close releases the lock, if it was acquired
+func (l *runLocker) close() {
+	if l.release != nil {
+		l.release()
+	}
+}
+
+// withRunLock wraps a step built-in so that the lock of a locked run is acquired before the step runs
+func withRunLock(fn BuiltinFunc) BuiltinFunc {
+	return func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if locker, ok := thread.Local(runLockLocal).(*runLocker); ok {
+			if err := locker.acquire(thread); err != nil {
+				return starlark.None, err
+			}
+		}
+		return fn(thread, b, args, kwargs)
+	}
+}
+
+// acquireRunLock prevents concurrent runs of the script against the cluster of kubeconfig (the kube config
+// used by the script, limited to the --kube-context context when set):
+// the run holds a lock file on this machine and, when the cluster can be reached, a Lease in the cluster
+// (so that runs from other machines are excluded too). In read-only mode, no Lease is created, only runs
+// from this machine are excluded. A lock held by another run that is still alive
+// fails the run, unless force is set. It returns the function releasing the locks.
+func acquireRunLock(name string, src []byte, kubeconfig string, force, readOnly bool) (release func(), err error) {
+	host, _ := os.Hostname()
+	holder := fmt.Sprintf("%s@%s", audit.LocalUser(), host)
+	scriptSum := sha256.Sum256(src)
+	scriptID := hex.EncodeToString(scriptSum[:8])
+
+	var client *k8s.Client
+	if len(kubeconfig) > 0 {
+		if _, err := os.Stat(kubeconfig); err == nil {
+			client, _ = k8s.New(kubeconfig)
+		}
+	}
+	var cluster string
+	if client != nil {
+		cluster = client.Host
+	}
+
+	lockSum := sha256.Sum256([]byte(scriptID + "\x00" + cluster))
+	lockFile := filepath.Join(runLockDir, hex.EncodeToString(lockSum[:8])+".lock")
+	lock := runLock{Holder: holder, Host: host, PID: os.Getpid(), Script: name, Since: time.Now()}
+	if err := acquireLockFile(lockFile, lock, force); err != nil {
+		return nil, err
+	}
+	release = func() { releaseLockFile(lockFile, lock) }
+
+	if client == nil {
+		return release, nil
+	}
+	if readOnly {
+		logrus.Infof("run lock: read-only mode, no Lease created in %s, only runs from this machine are excluded", cluster)
+		return release, nil
+	}
+	leaseName := "crashd-run-" + scriptID
+	lease, err := client.AcquireLease(runLeaseNamespace, leaseName, fmt.Sprintf("%s/%d", holder, lock.PID), runLeaseDuration, force)
+	if err != nil {
+		if _, held := err.(*k8s.LeaseHeldError); held {
+			release()
+			return nil, fmt.Errorf("script %s is already running against %s: lease %s/%s %s (use --force to run anyway)", name, cluster, runLeaseNamespace, leaseName, err)
+		}
+		// the cluster may be unreachable, which is when crashd is most needed
+		logrus.Warnf("run lock: cluster lease not acquired, only runs from this machine are excluded: %s", err)
+		return release, nil
+	}
+	return func() {
+		if err := lease.Release(); err != nil {
+			logrus.Warnf("run lock: %s", err)
+		}
+		release()
+	}, nil
+}
+
+// acquireLockFile creates the lock file, replacing the lock file of a run that is no longer running (or any
+// lock file, when force is set)
+func acquireLockFile(path string, lock runLock, force bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("run lock: %s", err)
+	}
+	data, err := json.Marshal(lock)
+	if err != nil {
+		return fmt.Errorf("run lock: %s", err)
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = file.Write(data)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return fmt.Errorf("run lock: %s", err)
+			}
+			return nil
+		}
+		if !os.IsExist(err) {
+			return fmt.Errorf("run lock: %s", err)
+		}
+
+		held, err := readLockFile(path)
+		switch {
+		case err != nil:
+			logrus.Warnf("run lock: replacing invalid lock file %s: %s", path, err)
+		case !held.alive():
+			logrus.Warnf("run lock: replacing the lock of a run that is no longer running (%s, pid %d)", held.Holder, held.PID)
+		case force:
+			logrus.Warnf("run lock: script %s is already running (%s, pid %d, since %s), running anyway (forced)",
+				held.Script, held.Holder, held.PID, held.Since.Format(time.RFC3339))
+		default:
+			return fmt.Errorf("script %s is already running (%s, pid %d, since %s), lock file %s (use --force to run anyway)",
+				held.Script, held.Holder, held.PID, held.Since.Format(time.RFC3339), path)
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("run lock: %s", err)
+		}
+	}
+	return fmt.Errorf("run lock: failed to create %s", path)
+}
+
+// releaseLockFile removes the lock file, unless it was replaced by another run
+func releaseLockFile(path string, lock runLock) {
+	held, err := readLockFile(path)
+	if err != nil || held.PID != lock.PID || held.Holder != lock.Holder {
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		logrus.Warnf("run lock: %s", err)
+	}
+}
+
+func readLockFile(path string) (runLock, error) {
+	var lock runLock
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return lock, err
+	}
+	err = json.Unmarshal(data, &lock)
+	return lock, err
+}
+
+// alive returns whether the process holding the lock is still running. Locks held from another host (i.e. a
+// shared temporary directory) are assumed to be alive.
+func (l runLock) alive() bool {
+	if host, _ := os.Hostname(); l.Host != host {
+		return true
+	}
+	process, err := os.FindProcess(l.PID)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+func TestAcquireLockFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashd-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "locks", "script.lock")
+	host, _ := os.Hostname()
+
+	first := runLock{Holder: "alice@" + host, Host: host, PID: os.Getpid(), Script: "diagnostics.crsh", Since: time.Now()}
+	if err := acquireLockFile(path, first, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	second := first
+	second.Holder = "bob@" + host
+	err = acquireLockFile(path, second, false)
+	if err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Fatalf("expecting the script to be locked, got %v", err)
+	}
+	if err := acquireLockFile(path, second, true); err != nil {
+		t.Fatalf("expecting the lock to be forced, got %s", err)
+	}
+	// the lock replaced by the forced run is not released by the first run
+	releaseLockFile(path, first)
+	if held, err := readLockFile(path); err != nil || held.Holder != second.Holder {
+		t.Fatalf("expecting the lock of the forced run, got %+v (%v)", held, err)
+	}
+	releaseLockFile(path, second)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expecting the lock file to be removed, got %v", err)
+	}
+}
+
+func TestAcquireLockFileStale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashd-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "script.lock")
+	host, _ := os.Hostname()
+
+	// the lock of a process that exited
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("true: %s", err)
+	}
+	stale := runLock{Holder: "alice@" + host, Host: host, PID: cmd.ProcessState.Pid(), Script: "diagnostics.crsh", Since: time.Now()}
+	if err := acquireLockFile(path, stale, false); err != nil {
+		t.Fatal(err)
+	}
+	lock := runLock{Holder: "bob@" + host, Host: host, PID: os.Getpid(), Script: "diagnostics.crsh", Since: time.Now()}
+	if err := acquireLockFile(path, lock, false); err != nil {
+		t.Fatalf("expecting the stale lock to be replaced, got %s", err)
+	}
+
+	// the lock of a process on another host cannot be checked
+	remote := runLock{Holder: "alice@elsewhere", Host: "elsewhere", PID: stale.PID, Script: "diagnostics.crsh", Since: time.Now()}
+	if !remote.alive() {
+		t.Error("expecting the lock of another host to be assumed alive")
+	}
+}
+
+func TestRunLockScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashd-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(lockDir string) { runLockDir = lockDir }(runLockDir)
+	runLockDir = filepath.Join(dir, "locks")
+
+	kubeconfig := filepath.Join(dir, "kubeconfig")
+	if err := ioutil.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: prod
+  cluster:
+    server: https://127.0.0.1:1
+contexts:
+- name: prod
+  context:
+    cluster: prod
+current-context: prod
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lock is acquired by the first step, for the cluster of the kube config declared by the script
+	script := fmt.Sprintf(`
+set_defaults(kube_config(path="%s"))
+locks = run_local("ls %s")
+`, kubeconfig, runLockDir)
+	scriptSum := sha256.Sum256([]byte(script))
+	lockSum := sha256.Sum256([]byte(hex.EncodeToString(scriptSum[:8]) + "\x00" + "https://127.0.0.1:1"))
+	lockFile := hex.EncodeToString(lockSum[:8]) + ".lock"
+
+	exe := New()
+	// no Lease is created in read-only mode: the cluster is not reachable, it would fail after a timeout
+	exe.SetOptions(ExecOptions{Lock: true, ReadOnly: true})
+	if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+	if locks := exe.result["locks"].(starlark.String); string(locks) != lockFile {
+		t.Errorf("expecting lock file %s while the script runs, got %s", lockFile, locks)
+	}
+	if _, err := os.Stat(filepath.Join(runLockDir, lockFile)); !os.IsNotExist(err) {
+		t.Errorf("expecting the lock file to be released, got %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+
+	// summaryMessageSize is the maximum length of the error messages printed in the summary
+	summaryMessageSize = 80
+)
+
+var (
+	// summaryOutput is where the run summary is printed
+	summaryOutput io.Writer = os.Stderr
+
+	// summaryColors reports whether the run summary is printed with colors
+	summaryColors = func() bool {
+		_, noColor := os.LookupEnv("NO_COLOR")
+		return !noColor && terminal.IsTerminal(int(os.Stderr.Fd()))
+	}
+)
+
+// summaryRow is a line of the run summary: the outcome of a step on a target
+type summaryRow struct {
+	step     string
+	target   string
+	failed   bool
+	duration time.Duration
+	output   string
+}
+
+// runSummary is the outcome of the collection steps of a run
+type runSummary struct {
+	rows     []summaryRow
+	steps    int
+	failed   int
+	findings int
+}
+
+// newRunSummary summarizes the steps that produced results (command results, captured
+// files, findings) or failed. Configuration steps (i.e. ssh_config) are omitted.
+func newRunSummary(steps []stepTiming) runSummary {
+	var summary runSummary
+	for _, step := range steps {
+		var rows []summaryRow
+		if step.err != nil {
+			rows = []summaryRow{{failed: true, output: step.err.Error()}}
+		} else {
+			rows = summaryRowsOf(step.result)
+			summary.findings += countFindings(step.result)
+		}
+		if len(rows) == 0 {
+			continue
+		}
+
+		summary.steps++
+		for i := range rows {
+			rows[i].step = step.name
+			if rows[i].failed {
+				summary.failed++
+			}
+		}
+		rows[0].duration = step.duration
+		summary.rows = append(summary.rows, rows...)
+	}
+	return summary
+}
+
+// summaryRowsOf returns a row for each result (i.e. command_result) found in the value
+func summaryRowsOf(val starlark.Value) []summaryRow {
+	switch v := val.(type) {
+	case *starlark.List:
+		var rows []summaryRow
+		for i := 0; i < v.Len(); i++ {
+			rows = append(rows, summaryRowsOf(v.Index(i))...)
+		}
+		return rows
+	case *starlarkstruct.Struct:
+		if results, err := v.Attr("results"); err == nil {
+			return summaryRowsOf(results)
+		}
+		errMsg := structString(v, "err") + structString(v, "error")
+		output := structString(v, "file")
+		if path := structString(v, "result"); len(output) == 0 && isOutputPath(path) {
+			output = path
+		}
+		if !hasAttr(v, "err") && !hasAttr(v, "error") && len(output) == 0 {
+			return nil
+		}
+		row := summaryRow{target: structString(v, "resource"), output: output}
+		if len(errMsg) > 0 {
+			row.failed, row.output = true, errMsg
+		}
+		return []summaryRow{row}
+	default:
+		return nil
+	}
+}
+
+// countFindings returns the number of findings reported in the value
+func countFindings(val starlark.Value) int {
+	if v, ok := val.(*starlarkstruct.Struct); ok {
+		if findings, err := v.Attr("findings"); err == nil {
+			if list, ok := findings.(*starlark.List); ok {
+				return list.Len()
+			}
+		}
+	}
+	return 0
+}
+
+func hasAttr(s *starlarkstruct.Struct, name string) bool {
+	_, err := s.Attr(name)
+	return err == nil
+}
+
+func structString(s *starlarkstruct.Struct, name string) string {
+	if val, err := s.Attr(name); err == nil {
+		if str, ok := val.(starlark.String); ok {
+			return string(str)
+		}
+	}
+	return ""
+}
+
+// isOutputPath returns true when str is the path of a file or directory (i.e. a capture file)
+func isOutputPath(str string) bool {
+	if len(str) == 0 || strings.ContainsAny(str, "\n") {
+		return false
+	}
+	_, err := os.Stat(str)
+	return err == nil
+}
+
+// write prints the summary table, with colored statuses when color is true
+func (s runSummary) write(w io.Writer, color bool) error {
+	if len(s.rows) == 0 {
+		return nil
+	}
+	paint := func(code, str string) string {
+		if !color {
+			return str
+		}
+		return code + str + colorReset
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tTARGET\tSTATUS\tDURATION\tOUTPUT")
+	for _, row := range s.rows {
+		// statuses are padded before coloring so that escape codes do not break the alignment
+		status := paint(colorGreen, "ok    ")
+		if row.failed {
+			status = paint(colorRed, "FAILED")
+		}
+		duration := ""
+		if row.duration > 0 {
+			duration = row.duration.Round(time.Millisecond).String()
+		}
+		target := row.target
+		if len(target) == 0 {
+			target = "-"
+		}
+		output := strings.Join(strings.Fields(row.output), " ")
+		if len(output) > summaryMessageSize {
+			output = output[:summaryMessageSize-3] + "..."
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", row.step, target, status, duration, output)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	failed := fmt.Sprintf("%d failed", s.failed)
+	if s.failed > 0 {
+		failed = paint(colorRed, failed)
+	}
+	findings := fmt.Sprintf("%d findings", s.findings)
+	if s.findings > 0 {
+		findings = paint(colorYellow, findings)
+	}
+	_, err := fmt.Fprintf(w, "\n%d steps, %d results, %s, %s\n", s.steps, len(s.rows), failed, findings)
+	return err
+}
+
+// printRunSummary prints the summary of the run steps, including the findings
+// recorded by the script with finding() and assert(), and the slowest steps
+func printRunSummary(thread *starlark.Thread) {
+	timings, ok := thread.Local(stepTimingsLocal).(*stepTimings)
+	if !ok {
+		return
+	}
+	summary := newRunSummary(timings.list())
+	if findings, ok := thread.Local(findingsLocal).(*findingList); ok {
+		summary.findings += len(findings.list())
+	}
+	summary.write(summaryOutput, summaryColors())
+	logTimingsSummary(timings)
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+func TestRunSummary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashd-summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	captureFile := filepath.Join(dir, "df.txt")
+	if err := ioutil.WriteFile(captureFile, []byte("ok"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []stepTiming{
+		{name: "ssh_config", duration: time.Millisecond, result: starlarkstruct.FromStringDict(starlark.String("ssh_config"), starlark.StringDict{
+			"username": starlark.String("capv"),
+		})},
+		{name: "capture", duration: 2 * time.Second, result: commandResultsToList([]commandResult{
+			{resource: "10.0.0.1", result: captureFile},
+			{resource: "10.0.0.2", err: errors.New("connection refused")},
+		})},
+		{name: "disk_capture", duration: time.Second, result: starlarkstruct.FromStringDict(starlark.String("disk_capture"), starlark.StringDict{
+			"results":  commandResultsToList([]commandResult{{resource: "10.0.0.1", result: captureFile}}),
+			"findings": starlark.NewList([]starlark.Value{diskFinding{resource: "10.0.0.1", mount: "/", kind: "space", usedPct: 95}.toStarlarkStruct()}),
+		})},
+		{name: "kube_capture", duration: time.Second, err: errors.New("kube_capture: failed to kubeconfig")},
+	}
+
+	summary := newRunSummary(steps)
+	if summary.steps != 3 || len(summary.rows) != 4 || summary.failed != 2 || summary.findings != 1 {
+		t.Fatalf("unexpected summary: steps=%d rows=%d failed=%d findings=%d", summary.steps, len(summary.rows), summary.failed, summary.findings)
+	}
+
+	var out bytes.Buffer
+	if err := summary.write(&out, false); err != nil {
+		t.Fatal(err)
+	}
+	report := out.String()
+	for _, expected := range []string{"STEP", "10.0.0.2", "FAILED", "connection refused", captureFile, "3 steps, 4 results, 2 failed, 1 findings"} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("summary missing %q:\n%s", expected, report)
+		}
+	}
+	if strings.Contains(report, "ssh_config") || strings.Contains(report, colorRed) {
+		t.Errorf("unexpected summary content:\n%s", report)
+	}
+
+	out.Reset()
+	if err := summary.write(&out, true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), colorRed+"FAILED"+colorReset) {
+		t.Errorf("expecting colored status:\n%s", out.String())
+	}
+}
@@ -242,3 +242,41 @@ func TestRunFuncSSHAll(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) { test.test(t, port, privateKey, username) })
 	}
 }
+
+func TestRunExitStatus(t *testing.T) {
+	script := `
+set_defaults(resources(provider=local_provider()))
+failed = run("sh -c 'echo partial; echo broken >&2; exit 2'")
+status = "failed" if failed.exit_code != 0 else "ok"
+`
+	exe := New()
+	if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+	if status := exe.result["status"]; status != starlark.String("failed") {
+		t.Errorf("unexpected status: %s", status)
+	}
+
+	result := exe.result["failed"].(*starlarkstruct.Struct)
+	for name, expected := range map[string]starlark.Value{
+		"exit_code": starlark.MakeInt(2),
+		"stdout":    starlark.String("partial\n"),
+		"stderr":    starlark.String("broken\n"),
+		"err":       starlark.String("exit status 2: broken"),
+	} {
+		val, err := result.Attr(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if eq, _ := starlark.Equal(val, expected); !eq {
+			t.Errorf("unexpected %s: %s", name, val)
+		}
+	}
+	// the result combines stdout and stderr, in the order they were read
+	if val, _ := result.Attr("result"); !strings.Contains(string(val.(starlark.String)), "partial") || !strings.Contains(string(val.(starlark.String)), "broken") {
+		t.Errorf("unexpected result: %s", val)
+	}
+	if _, err := result.Attr("duration"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+// schedulingReportFile is the report of the node allocations and pending pods saved by scheduling_capture
+const schedulingReportFile = "report.txt"
+
+// schedulingCaptureFunc is a built-in starlark function that collects what is needed to triage pods stuck
+// Pending: the pending pods with the reasons of their scheduling events (from the scheduler and the
+// autoscalers), the allocatable and requested resources of each node, the PriorityClasses, the logs of the
+// cluster-autoscaler or Karpenter pods, and their custom resources and status. Files are saved under
+// <workdir>/scheduling, and the nodes and pending pods are reported in <workdir>/scheduling/report.txt.
+// Starlark format: scheduling_capture([labels=["app=cluster-autoscaler"]][, workdir=path][, kube_config=kube_config()])
+func schedulingCaptureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir string
+	var labelList *starlark.List
+	var kubeConfig *starlarkstruct.Struct
+
+	if err := starlark.UnpackArgs(
+		identifiers.schedulingCapture, args, kwargs,
+		"labels?", &labelList,
+		"workdir?", &workdir,
+		"kube_config?", &kubeConfig,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.schedulingCapture, err)
+	}
+
+	labels := toSlice(labelList)
+	if len(labels) == 0 {
+		labels = defaults.autoscalerLabels
+	}
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+
+	if kubeConfig == nil {
+		kubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
+	}
+	path, err := getKubeConfigFromStruct(kubeConfig)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "failed to kubeconfig")
+	}
+	client, err := k8s.New(path)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "could not initialize search client")
+	}
+
+	resultDir := filepath.Join(workdir, k8s.SchedulingDirname)
+	var captureErrs []string
+
+	// PriorityClasses, autoscaler custom resources and status
+	objects, err := client.Search(k8s.SearchParams{Groups: defaults.autoscalerGroups})
+	if err != nil {
+		captureErrs = append(captureErrs, fmt.Sprintf("objects: %s", err))
+	}
+	status, err := client.Search(k8s.SearchParams{
+		Groups:     []string{"core"},
+		Kinds:      []string{"configmaps"},
+		Namespaces: []string{"kube-system"},
+		Names:      []string{"cluster-autoscaler-status"},
+	})
+	if err != nil {
+		captureErrs = append(captureErrs, fmt.Sprintf("autoscaler status: %s", err))
+	}
+	objects = append(objects, status...)
+	if len(objects) > 0 {
+		resultWriter, err := k8s.NewResultWriter(resultDir, "objects", getOutputFormat(thread), client.CoreRest)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.schedulingCapture, err)
+		}
+		if err := resultWriter.Write(objects); err != nil {
+			captureErrs = append(captureErrs, fmt.Sprintf("objects: %s", err))
+		}
+	}
+
+	// autoscaler logs
+	var autoscalers []starlark.Value
+	for _, label := range labels {
+		pods, err := client.RunningPods("", []string{label})
+		if err != nil {
+			captureErrs = append(captureErrs, fmt.Sprintf("autoscaler pods: %s", err))
+			continue
+		}
+		logged := make(map[string]bool)
+		for _, pod := range pods {
+			autoscalers = append(autoscalers, starlark.String(fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)))
+			if logged[pod.Namespace] {
+				continue
+			}
+			logged[pod.Namespace] = true
+			if _, _, err := write(resultDir, "logs", "", k8s.LayoutKubeCapture, client, nil, 0, k8s.SearchParams{
+				Namespaces: []string{pod.Namespace},
+				Labels:     []string{label},
+			}); err != nil {
+				captureErrs = append(captureErrs, fmt.Sprintf("logs (%s): %s", label, err))
+			}
+		}
+	}
+	if len(autoscalers) == 0 {
+		logrus.Infof("%s: no cluster autoscaler found", identifiers.schedulingCapture)
+	}
+
+	// nodes and pending pods
+	reportFile := filepath.Join(resultDir, schedulingReportFile)
+	var nodeVals, pendingVals []starlark.Value
+	snapshot, err := client.GetSchedulingSnapshot()
+	if err != nil {
+		captureErrs = append(captureErrs, err.Error())
+	} else {
+		if err := writeSchedulingReport(reportFile, snapshot, time.Now()); err != nil {
+			captureErrs = append(captureErrs, fmt.Sprintf("report: %s", err))
+		}
+		for _, n := range snapshot.Nodes {
+			nodeVals = append(nodeVals, starlarkstruct.FromStringDict(starlark.String("node_allocation"), starlark.StringDict{
+				"name":           starlark.String(n.Name),
+				"cpu_percent":    starlark.MakeInt64(n.CPUPercent()),
+				"memory_percent": starlark.MakeInt64(n.MemoryPercent()),
+				"pods":           starlark.MakeInt64(n.Pods),
+				"max_pods":       starlark.MakeInt64(n.AllocatablePods),
+				"schedulable":    starlark.Bool(!n.Unschedulable),
+			}))
+		}
+		for _, p := range snapshot.Pending {
+			var reasons []starlark.Value
+			for _, reason := range p.Reasons {
+				reasons = append(reasons, starlark.String(reason))
+			}
+			pendingVals = append(pendingVals, starlarkstruct.FromStringDict(starlark.String("pending_pod"), starlark.StringDict{
+				"namespace":      starlark.String(p.Namespace),
+				"name":           starlark.String(p.Name),
+				"priority_class": starlark.String(p.PriorityClass),
+				"priority":       starlark.MakeInt(int(p.Priority)),
+				"reasons":        starlark.NewList(reasons),
+			}))
+		}
+		if len(snapshot.Pending) > 0 {
+			logrus.Warnf("%s: %d pending pods", identifiers.schedulingCapture, len(snapshot.Pending))
+		}
+	}
+
+	var captureErr string
+	if len(captureErrs) > 0 {
+		captureErr = strings.Join(captureErrs, "; ")
+		logrus.Errorf("%s: %s", identifiers.schedulingCapture, captureErr)
+	}
+
+	return starlarkstruct.FromStringDict(
+		starlark.String(identifiers.schedulingCapture),
+		starlark.StringDict{
+			"file":        starlark.String(resultDir),
+			"report":      starlark.String(reportFile),
+			"nodes":       starlark.NewList(nodeVals),
+			"pending":     starlark.NewList(pendingVals),
+			"autoscalers": starlark.NewList(autoscalers),
+			"error":       starlark.String(captureErr),
+		}), nil
+}
+
+// writeSchedulingReport saves the node allocations and the pending pods of the snapshot at path
+func writeSchedulingReport(path string, snapshot *k8s.SchedulingSnapshot, now time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0744); err != nil && !os.IsExist(err) {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fmt.Fprintln(file, "NODES")
+	if err := k8s.WriteNodeAllocations(file, snapshot.Nodes); err != nil {
+		return err
+	}
+	fmt.Fprintf(file, "\nPENDING PODS (%d)\n", len(snapshot.Pending))
+	return k8s.WritePendingPods(file, snapshot.Pending, now)
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+func TestWriteSchedulingReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashd-scheduling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	snapshot := &k8s.SchedulingSnapshot{
+		Nodes: []k8s.NodeAllocation{{Name: "worker-1", AllocatableCPU: 4000, RequestedCPU: 3000, AllocatablePods: 110, Pods: 12}},
+		Pending: []k8s.PendingPod{{
+			Namespace: "shop",
+			Name:      "batch-0",
+			Created:   now.Add(-time.Minute),
+			Reasons:   []string{"FailedScheduling: 0/1 nodes are available: 1 Insufficient cpu."},
+		}},
+	}
+	path := filepath.Join(dir, k8s.SchedulingDirname, schedulingReportFile)
+	if err := writeSchedulingReport(path, snapshot, now); err != nil {
+		t.Fatal(err)
+	}
+	report, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"3/4 (75%)", "12/110", "PENDING PODS (1)", "1 Insufficient cpu."} {
+		if !strings.Contains(string(report), expected) {
+			t.Errorf("expecting %q in report:\n%s", expected, report)
+		}
+	}
+}
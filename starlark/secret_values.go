@@ -0,0 +1,132 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+const (
+	// secretValuesLocal is the thread local key used to store the secret values entered during the run
+	secretValuesLocal = "crashd_secret_values"
+	// redactedValue replaces the secret values in the records of the run
+	redactedValue = "<redacted>"
+)
+
+// secretValues are the values entered with prompt(secret=True). The script gets them as entered, but
+// they are redacted from the records of the run: step results (timings, run index, exec.Run results)
+// and provenance.
+type secretValues struct {
+	mu     sync.RWMutex
+	values []string
+}
+
+func (s *secretValues) add(value string) {
+	if len(value) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = append(s.values, value)
+}
+
+// redactString returns str with the secret values replaced
+func (s *secretValues) redactString(str string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, value := range s.values {
+		str = strings.Replace(str, value, redactedValue, -1)
+	}
+	return str
+}
+
+// redactErr returns err, or an error with the secret values of its message replaced
+func (s *secretValues) redactErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if msg := s.redactString(err.Error()); msg != err.Error() {
+		return errors.New(msg)
+	}
+	return err
+}
+
+// redact returns val with the secret values of its strings replaced. Containers are only copied when
+// they hold a secret value.
+func (s *secretValues) redact(val starlark.Value) starlark.Value {
+	s.mu.RLock()
+	empty := len(s.values) == 0
+	s.mu.RUnlock()
+	if empty {
+		return val
+	}
+	redacted, _ := s.redactValue(val)
+	return redacted
+}
+
+func (s *secretValues) redactValue(val starlark.Value) (starlark.Value, bool) {
+	switch v := val.(type) {
+	case starlark.String:
+		if str := s.redactString(string(v)); str != string(v) {
+			return starlark.String(str), true
+		}
+	case *starlark.List:
+		if elems, changed := s.redactElems(v); changed {
+			return starlark.NewList(elems), true
+		}
+	case starlark.Tuple:
+		if elems, changed := s.redactElems(v); changed {
+			return starlark.Tuple(elems), true
+		}
+	case *starlark.Dict:
+		redacted := starlark.NewDict(v.Len())
+		changed := false
+		for _, item := range v.Items() {
+			key, keyChanged := s.redactValue(item[0])
+			value, valueChanged := s.redactValue(item[1])
+			changed = changed || keyChanged || valueChanged
+			redacted.SetKey(key, value)
+		}
+		if changed {
+			return redacted, true
+		}
+	case *starlarkstruct.Struct:
+		fields := starlark.StringDict{}
+		changed := false
+		for _, name := range v.AttrNames() {
+			attr, _ := v.Attr(name)
+			value, valueChanged := s.redactValue(attr)
+			changed = changed || valueChanged
+			fields[name] = value
+		}
+		if changed {
+			return starlarkstruct.FromStringDict(v.Constructor(), fields), true
+		}
+	}
+	return val, false
+}
+
+func (s *secretValues) redactElems(iterable starlark.Indexable) ([]starlark.Value, bool) {
+	elems := make([]starlark.Value, iterable.Len())
+	changed := false
+	for i := range elems {
+		elem, elemChanged := s.redactValue(iterable.Index(i))
+		changed = changed || elemChanged
+		elems[i] = elem
+	}
+	return elems, changed
+}
+
+// getSecretValues returns the secret values of the run on thread
+func getSecretValues(thread *starlark.Thread) *secretValues {
+	if values, ok := thread.Local(secretValuesLocal).(*secretValues); ok {
+		return values
+	}
+	return &secretValues{}
+}
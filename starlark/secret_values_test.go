@@ -0,0 +1,43 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"testing"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+func TestSecretValuesRedact(t *testing.T) {
+	secrets := &secretValues{}
+	dict := starlark.NewDict(1)
+	dict.SetKey(starlark.String("token"), starlark.String("Bearer s3cret"))
+	val := starlark.NewList([]starlark.Value{
+		starlark.String("public"),
+		starlarkstruct.FromStringDict(starlark.String("command_result"), starlark.StringDict{
+			"result": starlark.String("login s3cret"),
+			"err":    starlark.String(""),
+		}),
+		dict,
+	})
+
+	// nothing is redacted before a secret is entered
+	if redacted := secrets.redact(val); redacted != val {
+		t.Errorf("expecting the value unchanged, got %s", redacted)
+	}
+
+	secrets.add("s3cret")
+	expected := `["public", "command_result"(err = "", result = "login <redacted>"), {"token": "Bearer <redacted>"}]`
+	if redacted := secrets.redact(val); redacted.String() != expected {
+		t.Errorf("unexpected redacted value: %s", redacted)
+	}
+	if val.String() == expected {
+		t.Error("expecting the value of the script unchanged")
+	}
+	if err := secrets.redactErr(fmt.Errorf("auth failed for s3cret")); err.Error() != "auth failed for <redacted>" {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
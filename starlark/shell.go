@@ -0,0 +1,198 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"go.starlark.net/starlark"
+
+	"github.com/vmware-tanzu/crash-diagnostics/proc"
+)
+
+// Shells running the commands of run() and capture(). A shell can also be the absolute path of a
+// POSIX shell (i.e. /bin/dash). By default, commands are run by the shell of the user on remote
+// hosts, and without a shell on the local machine.
+const (
+	shellNone       = "none"
+	shellSh         = "sh"
+	shellBash       = "bash"
+	shellPowerShell = "powershell"
+)
+
+// safeWordPattern matches the words that do not need to be quoted for POSIX shells
+var safeWordPattern = regexp.MustCompile(`^[a-zA-Z0-9_@%+:,./-]+$`)
+
+// command is a command executed by run() or capture() on each resource
+type command struct {
+	// line is the command line, the words of args quoted when the command is a list
+	line string
+	// args are the words of the command, when it is a list
+	args []string
+	// shell is the shell running the command, empty for the default shell
+	shell string
+	// env are the variables (NAME=value) set for the command
+	env []string
+	// localEnv are the variables set for the command when it runs on the local machine, the credentials of
+	// aws_config(), which are not sent to remote hosts
+	localEnv []string
+}
+
+// newCommand returns the command of cmd, a command line or a list of words (i.e. ["journalctl", "-u", "kubelet"]),
+// run by shell, or crashd_config.default_shell when empty, with the variables of the env dict set
+func newCommand(thread *starlark.Thread, cmd starlark.Value, shell string, envDict *starlark.Dict) (command, error) {
+	var c command
+	switch val := cmd.(type) {
+	case starlark.String:
+		c.line = string(val)
+	case *starlark.List:
+		for i := 0; i < val.Len(); i++ {
+			word, ok := val.Index(i).(starlark.String)
+			if !ok {
+				return command{}, fmt.Errorf("cmd: expecting a list of strings, got %s", val.Index(i).Type())
+			}
+			c.args = append(c.args, string(word))
+		}
+		if len(c.args) == 0 {
+			return command{}, fmt.Errorf("cmd: empty command")
+		}
+		c.line = joinWords(c.args)
+	default:
+		return command{}, fmt.Errorf("cmd: expecting a string or a list of strings, got %s", cmd.Type())
+	}
+
+	if len(shell) == 0 {
+		shell = getDefaultShell(thread)
+	}
+	if err := validateShell(shell); err != nil {
+		return command{}, err
+	}
+	c.shell = shell
+
+	env, err := toEnv(envDict)
+	if err != nil {
+		return command{}, err
+	}
+	c.env = env
+	if c.localEnv, err = getAWSEnv(thread); err != nil {
+		return command{}, fmt.Errorf("aws_config: %s", err)
+	}
+	return c, nil
+}
+
+// validateShell returns an error when shell is not a supported shell
+func validateShell(shell string) error {
+	switch shell {
+	case "", shellNone, shellSh, shellBash, shellPowerShell:
+		return nil
+	}
+	if !filepath.IsAbs(shell) {
+		return fmt.Errorf("unsupported shell %s (supported: %s, %s, %s, %s, or the path of a shell)", shell, shellBash, shellSh, shellPowerShell, shellNone)
+	}
+	return nil
+}
+
+// words returns the words of the command: its args, or its line split on spaces
+func (c command) words() ([]string, error) {
+	if len(c.args) > 0 {
+		return c.args, nil
+	}
+	words, err := proc.Split(c.line)
+	if err == nil && len(words) == 0 {
+		err = fmt.Errorf("empty command")
+	}
+	return words, err
+}
+
+// remote returns the command line sent to remote hosts, run by the shell of the user on the host:
+//   - by default, the line is run by the shell of the user (with $NAME variables expanded by crashd), or, with env, by sh
+//     (the variables of env are expanded on the host)
+//   - with shell none, the words are quoted, so that the program is run with its arguments verbatim
+//   - with a POSIX shell, the quoted line is run by the shell (i.e. sh -c 'cat /proc/mounts | grep nfs')
+//   - with powershell, the line is encoded, so that it does not need to be quoted for the shell of the user
+func (c command) remote() (string, error) {
+	envPrefix := ""
+	if len(c.env) > 0 && c.shell != shellPowerShell {
+		envPrefix = "env " + envAssignments(c.env) + " "
+	}
+
+	switch c.shell {
+	case "":
+		if len(c.args) > 0 {
+			return envPrefix + c.line, nil
+		}
+		return withRemoteEnv(expandRemote(c.line, c.env), c.env), nil
+	case shellNone:
+		words, err := c.words()
+		if err != nil {
+			return "", err
+		}
+		return envPrefix + joinWords(words), nil
+	case shellPowerShell:
+		var script strings.Builder
+		for _, pair := range c.env {
+			parts := strings.SplitN(pair, "=", 2)
+			fmt.Fprintf(&script, "$env:%s = %s; ", parts[0], powerShellQuote(parts[1]))
+		}
+		script.WriteString(c.powerShellLine())
+		return powershellCmd(script.String()), nil
+	default:
+		return envPrefix + joinWords([]string{c.shell, "-c", c.line}), nil
+	}
+}
+
+// local returns the program, and its arguments, run on the local machine, or an empty program when the line
+// is run by default, without a shell (with $NAME variables expanded, see proc.Run)
+func (c command) local() (string, []string, error) {
+	switch c.shell {
+	case "":
+		if len(c.args) > 0 {
+			return c.args[0], c.args[1:], nil
+		}
+		return "", nil, nil
+	case shellNone:
+		words, err := c.words()
+		if err != nil {
+			return "", nil, err
+		}
+		return words[0], words[1:], nil
+	case shellPowerShell:
+		return "powershell", []string{"-NoProfile", "-NonInteractive", "-Command", c.powerShellLine()}, nil
+	default:
+		return c.shell, []string{"-c", c.line}, nil
+	}
+}
+
+// powerShellLine returns the line of the command, or its args called with the quoted words
+func (c command) powerShellLine() string {
+	if len(c.args) == 0 {
+		return c.line
+	}
+	words := make([]string, len(c.args))
+	for i, word := range c.args {
+		words[i] = powerShellQuote(word)
+	}
+	return "& " + strings.Join(words, " ")
+}
+
+// joinWords returns the words, quoted for POSIX shells when needed, separated by spaces
+func joinWords(words []string) string {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		if safeWordPattern.MatchString(word) {
+			quoted[i] = word
+			continue
+		}
+		quoted[i] = shellQuote(word)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// powerShellQuote returns s quoted as a verbatim PowerShell string
+func powerShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
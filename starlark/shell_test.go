@@ -0,0 +1,165 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+func TestNewCommand(t *testing.T) {
+	thread := &starlark.Thread{}
+	words := starlark.NewList([]starlark.Value{starlark.String("grep"), starlark.String("-r"), starlark.String("it's"), starlark.String("/var/log/my dir")})
+	cmd, err := newCommand(thread, words, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.line != `grep -r 'it'\''s' '/var/log/my dir'` {
+		t.Errorf("unexpected line: %s", cmd.line)
+	}
+
+	thread.SetLocal(identifiers.crashdCfg, starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"default_shell": starlark.String(shellBash),
+	}))
+	cmd, err = newCommand(thread, starlark.String("uptime"), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.shell != shellBash {
+		t.Errorf("expecting crashd_config.default_shell, got %s", cmd.shell)
+	}
+
+	for name, test := range map[string]struct {
+		cmd   starlark.Value
+		shell string
+	}{
+		"empty list":        {cmd: starlark.NewList(nil)},
+		"not a string":      {cmd: starlark.NewList([]starlark.Value{starlark.String("ls"), starlark.MakeInt(1)})},
+		"not a command":     {cmd: starlark.MakeInt(1)},
+		"unsupported shell": {cmd: starlark.String("uptime"), shell: "zsh"},
+	} {
+		if _, err := newCommand(thread, test.cmd, test.shell, nil); err == nil {
+			t.Errorf("%s: expecting error", name)
+		}
+	}
+}
+
+func TestCommandRemote(t *testing.T) {
+	tests := []struct {
+		name   string
+		cmd    command
+		remote string
+		output string
+	}{
+		{
+			name:   "default shell",
+			cmd:    command{line: "echo hello"},
+			remote: "echo hello",
+			output: "hello\n",
+		},
+		{
+			name:   "sh",
+			cmd:    command{line: `printf '%s\n' "$GREETING" | tr a-z A-Z`, shell: shellSh, env: []string{"GREETING=it's me"}},
+			remote: `env GREETING='it'\''s me' sh -c 'printf '\''%s\n'\'' "$GREETING" | tr a-z A-Z'`,
+			output: "IT'S ME\n",
+		},
+		{
+			name:   "none",
+			cmd:    command{args: []string{"echo", "$HOME", "a;b"}, line: joinWords([]string{"echo", "$HOME", "a;b"}), shell: shellNone},
+			remote: `echo '$HOME' 'a;b'`,
+			output: "$HOME a;b\n",
+		},
+		{
+			name:   "none with a line",
+			cmd:    command{line: `echo "x | y"`, shell: shellNone},
+			remote: `echo 'x | y'`,
+			output: "x | y\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			remote, err := test.cmd.remote()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if remote != test.remote {
+				t.Errorf("unexpected remote command: %s", remote)
+			}
+			output, err := exec.Command("sh", "-c", remote).CombinedOutput()
+			if err != nil {
+				t.Fatalf("%s: %s", err, output)
+			}
+			if string(output) != test.output {
+				t.Errorf("unexpected output: %q", output)
+			}
+		})
+	}
+}
+
+func TestCommandRemotePowerShell(t *testing.T) {
+	cmd := command{args: []string{"Get-Content", "C:\\it's.log"}, shell: shellPowerShell, env: []string{"A=b"}}
+	remote, err := cmd.remote()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := "powershell.exe -NoProfile -NonInteractive -EncodedCommand "
+	if !strings.HasPrefix(remote, prefix) {
+		t.Fatalf("unexpected remote command: %s", remote)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(remote, prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes := make([]uint16, len(data)/2)
+	for i := range codes {
+		codes[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+	}
+	if script := string(utf16.Decode(codes)); script != `$env:A = 'b'; & 'Get-Content' 'C:\it''s.log'` {
+		t.Errorf("unexpected script: %s", script)
+	}
+}
+
+func TestRunShell(t *testing.T) {
+	workdir, err := ioutil.TempDir("", "crashd-shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+
+	script := `
+crashd_config(workdir="` + workdir + `")
+set_defaults(resources(provider=local_provider()))
+piped = run("echo crashd | tr a-z A-Z", shell="sh")
+words = run(["echo", "a  b", "$HOME"])
+unexpanded = run("echo '$HOME'", shell="none")
+capture(["printf", "%s;%s", "x", "y"], file_name="words.txt")
+`
+	exe := New()
+	if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]string{"piped": "CRASHD", "words": "a  b $HOME", "unexpanded": "$HOME"} {
+		result := exe.result[name].(*starlarkstruct.Struct)
+		if val, _ := result.Attr("result"); val != starlark.String(expected) {
+			t.Errorf("%s: unexpected result: %s", name, val)
+		}
+	}
+	content, err := ioutil.ReadFile(filepath.Join(workdir, sanitizeStr(localHost), "words.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(content)) != "x;y" {
+		t.Errorf("unexpected captured content: %q", content)
+	}
+}
@@ -5,11 +5,19 @@ package starlark
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/secrets"
+	"github.com/vmware-tanzu/crash-diagnostics/ssh"
 )
 
+// sshConfigsLocal is the thread local holding the named ssh_config() structs, keyed by name
+const sshConfigsLocal = "crashd_ssh_configs"
+
 // addDefaultSshConf initalizes a Starlark Dict with default
 // ssh_config configuration data
 func addDefaultSSHConf(thread *starlark.Thread) error {
@@ -22,20 +30,32 @@ func addDefaultSSHConf(thread *starlark.Thread) error {
 }
 
 // sshConfigFn is the backing built-in fn that saves and returns its argument as struct value.
-// Starlark format: ssh_config(username=name[, port][, private_key_path][,max_retries][,conn_timeout][,jump_user][,jump_host])
-func sshConfigFn(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-	var uname, port, pkPath, jUser, jHost string
-	var maxRetries, connTimeout int
+// A named config is registered in the thread so that providers and exec built-ins can select it
+// by name with ssh_config="<name>". Declaring a name again replaces the previous config.
+// Starlark format: ssh_config(username=name[, name][, port][, private_key_path][,max_retries][,conn_timeout][,jump_user][,jump_host]
+// [,host_key_checking="strict|accept-new|off"][,known_hosts_file][,multiplex=True][,keepalive_interval][,keepalive_count]
+// [,key_secret="vault://path#field"])
+func sshConfigFn(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name, uname, port, pkPath, jUser, jHost, hostKeyChecking, knownHostsFile, keySecret string
+	var maxRetries, connTimeout, keepAliveInterval, keepAliveCount int
+	var multiplex bool
 
 	if err := starlark.UnpackArgs(
 		identifiers.crashdCfg, args, kwargs,
 		"username", &uname,
+		"name?", &name,
 		"port?", &port,
 		"private_key_path?", &pkPath,
 		"jump_user?", &jUser,
 		"jump_host?", &jHost,
 		"max_retries?", &maxRetries,
 		"conn_timeout?", &connTimeout,
+		"host_key_checking?", &hostKeyChecking,
+		"known_hosts_file?", &knownHostsFile,
+		"multiplex?", &multiplex,
+		"keepalive_interval?", &keepAliveInterval,
+		"keepalive_count?", &keepAliveCount,
+		"key_secret?", &keySecret,
 	); err != nil {
 		return starlark.None, fmt.Errorf("%s: %s", identifiers.hostListProvider, err)
 	}
@@ -53,9 +73,25 @@ func sshConfigFn(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, k
 	if connTimeout == 0 {
 		connTimeout = defaults.connTimeout
 	}
+	if len(keySecret) > 0 {
+		if len(pkPath) > 0 {
+			return starlark.None, fmt.Errorf("%s: only one of private_key_path or key_secret can be specified", identifiers.sshCfg)
+		}
+		keyFile, err := secrets.FetchKeyFile(keySecret)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.sshCfg, err)
+		}
+		pkPath = keyFile
+	}
 	if len(pkPath) == 0 {
 		pkPath = defaults.pkPath
 	}
+	if connTimeout < 0 || keepAliveInterval < 0 || keepAliveCount < 0 {
+		return starlark.None, fmt.Errorf("%s: conn_timeout, keepalive_interval, and keepalive_count must be positive", identifiers.sshCfg)
+	}
+	if err := ssh.ValidateHostKeyChecking(hostKeyChecking); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.sshCfg, err)
+	}
 
 	sshConfigDict := starlark.StringDict{
 		"username":         starlark.String(uname),
@@ -70,11 +106,95 @@ func sshConfigFn(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, k
 	if len(jHost) != 0 {
 		sshConfigDict["jump_host"] = starlark.String(jHost)
 	}
+	if len(hostKeyChecking) != 0 {
+		sshConfigDict["host_key_checking"] = starlark.String(hostKeyChecking)
+	}
+	if len(knownHostsFile) != 0 {
+		sshConfigDict["known_hosts_file"] = starlark.String(knownHostsFile)
+	}
+	if multiplex {
+		sshConfigDict["multiplex"] = starlark.True
+	}
+	if keepAliveInterval > 0 {
+		sshConfigDict["keepalive_interval"] = starlark.MakeInt(keepAliveInterval)
+	}
+	if keepAliveCount > 0 {
+		sshConfigDict["keepalive_count"] = starlark.MakeInt(keepAliveCount)
+	}
+	if len(keySecret) > 0 {
+		sshConfigDict["key_secret"] = starlark.String(keySecret)
+	}
+	if len(name) > 0 {
+		sshConfigDict["name"] = starlark.String(name)
+	}
 	structVal := starlarkstruct.FromStringDict(starlark.String(identifiers.sshCfg), sshConfigDict)
 
+	if len(name) > 0 && thread != nil {
+		configs, _ := thread.Local(sshConfigsLocal).(map[string]*starlarkstruct.Struct)
+		if configs == nil {
+			configs = make(map[string]*starlarkstruct.Struct)
+			thread.SetLocal(sshConfigsLocal, configs)
+		}
+		configs[name] = structVal
+	}
+
 	return structVal, nil
 }
 
+// getSSHConfig returns the ssh_config selected by the ssh_config argument of a built-in: an
+// ssh_config() struct, or the name of a config declared with ssh_config(name=...). When val is
+// not set, the default set with set_defaults() is returned. A name that was not declared, or a
+// missing default, is an error rather than a fallback to another config.
+func getSSHConfig(thread *starlark.Thread, val starlark.Value) (*starlarkstruct.Struct, error) {
+	switch v := val.(type) {
+	case nil, starlark.NoneType:
+		cfg, ok := thread.Local(identifiers.sshCfg).(*starlarkstruct.Struct)
+		if !ok {
+			return nil, fmt.Errorf("default ssh_config not found: use set_defaults(ssh_config(...)) or the ssh_config argument")
+		}
+		return cfg, nil
+	case starlark.String:
+		configs, _ := thread.Local(sshConfigsLocal).(map[string]*starlarkstruct.Struct)
+		cfg, ok := configs[string(v)]
+		if !ok {
+			var names []string
+			for name := range configs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("ssh_config %q not found (declared: [%s])", string(v), strings.Join(names, ", "))
+		}
+		return cfg, nil
+	case *starlarkstruct.Struct:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("ssh_config: expecting %s() or a name, got %s", identifiers.sshCfg, val.Type())
+	}
+}
+
+// withSSHConfig returns a copy of the SSH resources using sshCfg instead of the ssh_config of
+// their provider. Other resources are returned as is.
+func withSSHConfig(resources *starlark.List, sshCfg *starlarkstruct.Struct) *starlark.List {
+	var values []starlark.Value
+	for i := 0; i < resources.Len(); i++ {
+		val := resources.Index(i)
+		res, ok := val.(*starlarkstruct.Struct)
+		if !ok {
+			values = append(values, val)
+			continue
+		}
+		if _, err := res.Attr(identifiers.sshCfg); err != nil {
+			values = append(values, val)
+			continue
+		}
+		dict := starlark.StringDict{}
+		res.ToStringDict(dict)
+		dict[identifiers.sshCfg] = sshCfg
+		values = append(values, starlarkstruct.FromStringDict(res.Constructor(), dict))
+	}
+	return starlark.NewList(values)
+}
+
 func makeDefaultSSHConfig() []starlark.Tuple {
 	return []starlark.Tuple{
 		starlark.Tuple{starlark.String("username"), starlark.String(getUsername())},
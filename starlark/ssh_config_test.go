@@ -7,6 +7,7 @@ import (
 	"strings"
 	"testing"
 
+	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
 )
 
@@ -75,6 +76,109 @@ func TestSSHConfigFunc(t *testing.T) {
 			},
 		},
 
+		{
+			name:   "ssh_config host key checking",
+			script: `cfg = ssh_config(username="uname", host_key_checking="strict", known_hosts_file="/crashd/known_hosts")`,
+			eval: func(t *testing.T, script string) {
+				exe := New()
+				if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+					t.Fatal(err)
+				}
+				cfg, ok := exe.result["cfg"].(*starlarkstruct.Struct)
+				if !ok {
+					t.Fatalf("unexpected type for ssh_config: %T", exe.result["cfg"])
+				}
+				args, err := getSSHArgsFromCfg(cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if args.HostKeyChecking != "strict" || args.KnownHostsFile != "/crashd/known_hosts" {
+					t.Fatalf("unexpected host key settings: %s, %s", args.HostKeyChecking, args.KnownHostsFile)
+				}
+			},
+		},
+
+		{
+			name:   "ssh_config bad host key checking",
+			script: `cfg = ssh_config(username="uname", host_key_checking="sometimes")`,
+			eval: func(t *testing.T, script string) {
+				exe := New()
+				if err := exe.Exec("test.star", strings.NewReader(script)); err == nil {
+					t.Fatal("expecting error for unsupported host_key_checking")
+				}
+			},
+		},
+
+		{
+			name:   "ssh_config key_secret with private_key_path",
+			script: `cfg = ssh_config(username="uname", private_key_path="/tmp/key", key_secret="vault://secret/ssh/prod")`,
+			eval: func(t *testing.T, script string) {
+				exe := New()
+				if err := exe.Exec("test.star", strings.NewReader(script)); err == nil {
+					t.Fatal("expecting error for both private_key_path and key_secret")
+				}
+			},
+		},
+
+		{
+			name: "ssh_config selected by name",
+			script: `
+ssh_config(name="bastion", username="jump", jump_host="10.0.0.1", jump_user="ubuntu")
+ssh_config(name="direct", username="direct")
+set_defaults(ssh_config(username="default"))
+res = resources(hosts=["127.0.0.1"], ssh_config="bastion")
+default_res = resources(hosts=["127.0.0.1"])
+override_res = resources(provider=host_list_provider(hosts=["127.0.0.2"], ssh_config="bastion"), ssh_config="direct")`,
+			eval: func(t *testing.T, script string) {
+				exe := New()
+				if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+					t.Fatal(err)
+				}
+				for name, expected := range map[string]string{"res": "jump", "default_res": "default", "override_res": "direct"} {
+					res := exe.result[name].(*starlark.List).Index(0).(*starlarkstruct.Struct)
+					cfg, err := res.Attr(identifiers.sshCfg)
+					if err != nil {
+						t.Fatal(err)
+					}
+					uname, err := cfg.(*starlarkstruct.Struct).Attr("username")
+					if err != nil {
+						t.Fatal(err)
+					}
+					if trimQuotes(uname.String()) != expected {
+						t.Errorf("%s: unexpected ssh_config username: %s", name, uname)
+					}
+				}
+			},
+		},
+
+		{
+			name: "ssh_config name not found",
+			script: `
+ssh_config(name="bastion", username="jump")
+res = resources(hosts=["127.0.0.1"], ssh_config="bastionless")`,
+			eval: func(t *testing.T, script string) {
+				exe := New()
+				err := exe.Exec("test.star", strings.NewReader(script))
+				if err == nil {
+					t.Fatal("expecting error for undeclared ssh_config name")
+				}
+				if !strings.Contains(err.Error(), `ssh_config "bastionless" not found (declared: [bastion])`) {
+					t.Errorf("unexpected error: %s", err)
+				}
+			},
+		},
+
+		{
+			name:   "ssh_config default not set",
+			script: `res = resources(hosts=["127.0.0.1"])`,
+			eval: func(t *testing.T, script string) {
+				exe := New()
+				if err := exe.Exec("test.star", strings.NewReader(script)); err == nil {
+					t.Fatal("expecting error for missing default ssh_config")
+				}
+			},
+		},
+
 		{
 			name:   "crash_config default",
 			script: `one = 1`,
@@ -4,26 +4,123 @@
 package starlark
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"time"
 
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/resolve"
 	"go.starlark.net/starlark"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+	"github.com/vmware-tanzu/crash-diagnostics/metrics"
+	"github.com/vmware-tanzu/crash-diagnostics/policy"
+	"github.com/vmware-tanzu/crash-diagnostics/proc"
+	"github.com/vmware-tanzu/crash-diagnostics/secrets"
+	"github.com/vmware-tanzu/crash-diagnostics/ssh"
 )
 
+func init() {
+	// scripts can use floats (i.e. math module), sets (i.e. to de-duplicate hosts), and
+	// lambdas (i.e. steps of parallel())
+	resolve.AllowFloat = true
+	resolve.AllowSet = true
+	resolve.AllowLambda = true
+}
+
 type Executor struct {
 	thread  *starlark.Thread
 	predecs starlark.StringDict
 	result  starlark.StringDict
 }
 
+// ExecOptions are the run-wide settings, usually from command-line flags,
+// applied to the script execution
+type ExecOptions struct {
+	// Tee, when set, receives the output of run and capture commands as they complete
+	Tee io.Writer
+	// Console receives the output of run and capture commands called with echo=True
+	// (os.Stdout when nil)
+	Console io.Writer
+	// Only and Skip select the tagged steps to execute
+	Only []string
+	Skip []string
+	// Args are the script arguments, recorded in the run provenance
+	Args map[string]string
+	// PluginDirs are searched for exec plugins providing additional built-ins
+	PluginDirs []string
+	// NonInteractive disables the prompt and confirm built-ins
+	NonInteractive bool
+	// AssumeYes answers yes to the confirm built-in when prompts are disabled. Without it, confirm
+	// fails when its default is True, so that unattended runs do not approve the steps it gates.
+	AssumeYes bool
+	// FailFast stops the script at the first step reporting an error for one of its
+	// targets. By default, such errors are recorded in the error report and the script continues.
+	FailFast bool
+	// TimeBudget, when set, is the expected duration of the run: lower priority steps are
+	// skipped when it is nearly exhausted
+	TimeBudget time.Duration
+	// ProgressInterval, when set, is the interval at which the progress of long-running steps
+	// (copy_from, kube_capture) is reported
+	ProgressInterval time.Duration
+	// ReadOnly rejects the commands of run(), run_local(), capture(), and capture_local() that
+	// match the deny list (DefaultDenyList and DenyList) or redirect their output to files
+	ReadOnly bool
+	// DenyList are the commands rejected in read-only mode, in addition to DefaultDenyList
+	DenyList []string
+	// Policy, when set, restricts the transports, namespaces, commands, and bundle size of the run
+	Policy *policy.Policy
+	// Lock prevents concurrent runs of the same script against the same cluster (see acquireRunLock)
+	Lock bool
+	// Force runs the script even when it is locked by another run
+	Force bool
+	// Config overrides the configuration declared by the script
+	Config ConfigOverrides
+}
+
+// ConfigOverrides are crashd_config() values, the archive() output file, and the kube_config()
+// context, set on the command line. Values that are set take precedence over the values declared by the script.
+type ConfigOverrides struct {
+	Workdir string
+	UID     string
+	GID     string
+	// Output replaces the output_file of archive()
+	Output string
+	// KubeContext replaces the context of kube_config(path=...)
+	KubeContext string
+}
+
+// BuiltinFunc is the Go implementation of a script built-in function
+type BuiltinFunc func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error)
+
+const (
+	// execOptionsLocal is the thread local key used to store the ExecOptions
+	execOptionsLocal = "crashd_exec_options"
+	// contextLocal is the thread local key used to store the context of the run
+	contextLocal = "crashd_context"
+)
+
 func New() *Executor {
+	thread := &starlark.Thread{Name: "crashd"}
+	thread.SetLocal(stepTimingsLocal, &stepTimings{})
+	thread.SetLocal(bundleQuotaLocal, &bundleQuota{})
+	thread.SetLocal(findingsLocal, &findingList{})
+	thread.SetLocal(secretValuesLocal, &secretValues{})
 	return &Executor{
-		thread:  &starlark.Thread{Name: "crashd"},
+		thread:  thread,
 		predecs: newPredeclareds(),
 	}
 }
 
+// SetOptions applies the run-wide options to the script execution
+func (e *Executor) SetOptions(opts ExecOptions) {
+	e.thread.SetLocal(execOptionsLocal, opts)
+}
+
 // AddPredeclared predeclared
 func (e *Executor) AddPredeclared(name string, value starlark.Value) {
 	if e.predecs != nil {
@@ -32,11 +129,65 @@ func (e *Executor) AddPredeclared(name string, value starlark.Value) {
 }
 
 func (e *Executor) Exec(name string, source io.Reader) error {
+	return e.ExecWithContext(context.Background(), name, source)
+}
+
+// ExecWithContext executes the script, stopping at the next step once ctx is done. The programs still
+// running (i.e. ssh clients) are killed once ctx is done and, when the script is stopped, the multiplexed
+// SSH connections are closed and the working directory is archived (see archivePartial).
+func (e *Executor) ExecWithContext(ctx context.Context, name string, source io.Reader) error {
+	e.thread.SetLocal(contextLocal, ctx)
+	defer proc.SetContext(ctx)()
+	defer k8s.RemoveContextConfigs()
+	defer setExecGuard(getExecOptions(e.thread))()
+	e.thread.SetLocal(runStartLocal, time.Now())
 	if err := setupLocalDefaults(e.thread); err != nil {
 		return fmt.Errorf("failed to setup defaults: %s", err)
 	}
 
-	result, err := starlark.ExecFile(e.thread, name, source, e.predecs)
+	defer printRunSummary(e.thread)
+	defer audit.SetOutput(nil)
+	defer secrets.Cleanup()
+	defer secrets.Register(secrets.AWSSecretScheme, secrets.NewAWSSecretsProvider())
+	defer k8s.SetExecEnv(nil)()
+	defer deleteNodeAgents()
+	defer closePortForwards()
+
+	src, err := ioutil.ReadAll(source)
+	if err != nil {
+		return fmt.Errorf("failed to read script %s: %s", name, err)
+	}
+	e.thread.SetLocal(provenanceLocal, newProvenance(name, src, getExecOptions(e.thread).Args))
+
+	if opts := getExecOptions(e.thread); opts.Lock {
+		// acquired by the first step, once the script has declared its kube config
+		locker := &runLocker{name: name, src: src, force: opts.Force, readOnly: opts.ReadOnly}
+		e.thread.SetLocal(runLockLocal, locker)
+		defer locker.close()
+	}
+
+	if err := e.loadExecPlugins(getExecOptions(e.thread).PluginDirs, name, src); err != nil {
+		return fmt.Errorf("failed to load plugins: %s", err)
+	}
+	if err := e.loadLibrary(); err != nil {
+		return err
+	}
+
+	result, err := starlark.ExecFile(e.thread, name, src, e.predecs)
+	if workdir, wdErr := getWorkdirFromThread(e.thread); wdErr == nil {
+		if size, sizeErr := dirSize(workdir); sizeErr == nil {
+			metrics.AddCapturedBytes(size)
+		}
+	}
+	if err != nil && ctx.Err() != nil {
+		ssh.CloseMasters()
+		archive, archiveErr := archivePartial(e.thread)
+		if archiveErr != nil {
+			return fmt.Errorf("run stopped: %s (no partial archive: %s)", ctx.Err(), archiveErr)
+		}
+		logrus.Warnf("run stopped: what was collected is saved in %s", archive)
+		return fmt.Errorf("run stopped: %s, partial archive saved in %s", ctx.Err(), archive)
+	}
 	if err != nil {
 		if evalErr, ok := err.(*starlark.EvalError); ok {
 			return fmt.Errorf(evalErr.Backtrace())
@@ -45,6 +196,13 @@ func (e *Executor) Exec(name string, source io.Reader) error {
 	}
 	e.result = result
 
+	// findings with the fail severity fail the run
+	if findings, ok := e.thread.Local(findingsLocal).(*findingList); ok {
+		if failed := findings.failed(); failed > 0 {
+			return fmt.Errorf("%d finding(s) with severity %s, see %s", failed, severityFail, findingsFileName)
+		}
+	}
+
 	return nil
 }
 
@@ -71,26 +229,112 @@ func setupLocalDefaults(thread *starlark.Thread) error {
 
 // newPredeclareds creates string dictionary containing the
 // global built-ins values and functions available to the
-// runing script.
+// runing script, including registered plugin built-ins.
 func newPredeclareds() starlark.StringDict {
+	predecs := corePredeclareds()
+	for name, fn := range registeredBuiltins() {
+		if _, ok := predecs[name]; ok {
+			continue
+		}
+		predecs[name] = newStepBuiltin(name, fn)
+	}
+
+	// record the duration of every built-in invocation
+	for name, val := range predecs {
+		if b, ok := val.(*starlark.Builtin); ok {
+			predecs[name] = withTiming(b)
+		}
+	}
+	return predecs
+}
+
+// corePredeclareds returns the built-ins provided by crashd
+func corePredeclareds() starlark.StringDict {
 	return starlark.StringDict{
 		identifiers.os:                setupOSStruct(),
+		identifiers.time:              newTimeModule(),
+		identifiers.math:              newMathModule(),
+		identifiers.re:                newReModule(),
 		identifiers.crashdCfg:         starlark.NewBuiltin(identifiers.crashdCfg, crashdConfigFn),
 		identifiers.sshCfg:            starlark.NewBuiltin(identifiers.sshCfg, sshConfigFn),
+		identifiers.awsCfg:            starlark.NewBuiltin(identifiers.awsCfg, awsConfigFn),
 		identifiers.hostListProvider:  starlark.NewBuiltin(identifiers.hostListProvider, hostListProvider),
 		identifiers.resources:         starlark.NewBuiltin(identifiers.resources, resourcesFunc),
 		identifiers.archive:           starlark.NewBuiltin(identifiers.archive, archiveFunc),
-		identifiers.run:               starlark.NewBuiltin(identifiers.run, runFunc),
-		identifiers.runLocal:          starlark.NewBuiltin(identifiers.runLocal, runLocalFunc),
-		identifiers.capture:           starlark.NewBuiltin(identifiers.capture, captureFunc),
-		identifiers.captureLocal:      starlark.NewBuiltin(identifiers.capture, captureLocalFunc),
-		identifiers.copyFrom:          starlark.NewBuiltin(identifiers.copyFrom, copyFromFunc),
+		identifiers.run:               newStepBuiltin(identifiers.run, runFunc),
+		identifiers.runLocal:          newStepBuiltin(identifiers.runLocal, runLocalFunc),
+		identifiers.capture:           newStepBuiltin(identifiers.capture, captureFunc),
+		identifiers.captureLocal:      newStepBuiltin(identifiers.captureLocal, captureLocalFunc),
+		identifiers.copyFrom:          newStepBuiltin(identifiers.copyFrom, withProgress(identifiers.copyFrom, copyFromFunc)),
 		identifiers.kubeCfg:           starlark.NewBuiltin(identifiers.kubeCfg, KubeConfigFn),
-		identifiers.kubeCapture:       starlark.NewBuiltin(identifiers.kubeGet, KubeCaptureFn),
-		identifiers.kubeGet:           starlark.NewBuiltin(identifiers.kubeGet, KubeGetFn),
+		identifiers.kubeCapture:       newStepBuiltin(identifiers.kubeCapture, withProgress(identifiers.kubeCapture, KubeCaptureFn)),
+		identifiers.kubeGet:           newStepBuiltin(identifiers.kubeGet, KubeGetFn),
 		identifiers.kubeNodesProvider: starlark.NewBuiltin(identifiers.kubeNodesProvider, KubeNodesProviderFn),
 		identifiers.capvProvider:      starlark.NewBuiltin(identifiers.capvProvider, CapvProviderFn),
 		identifiers.capaProvider:      starlark.NewBuiltin(identifiers.capvProvider, CapaProviderFn),
+		identifiers.capzProvider:      starlark.NewBuiltin(identifiers.capzProvider, CapzProviderFn),
+		identifiers.localProvider:     starlark.NewBuiltin(identifiers.localProvider, localProviderFunc),
+		identifiers.kubeProxyProvider: starlark.NewBuiltin(identifiers.kubeProxyProvider, kubeProxyProviderFunc),
+		identifiers.kubeAgentProvider: starlark.NewBuiltin(identifiers.kubeAgentProvider, kubeAgentProviderFunc),
+		identifiers.kubePortForward:   starlark.NewBuiltin(identifiers.kubePortForward, kubePortForwardFunc),
 		identifiers.setDefaults:       starlark.NewBuiltin(identifiers.setDefaults, SetDefaultsFunc),
+		identifiers.pprofCapture:      newStepBuiltin(identifiers.pprofCapture, PprofCaptureFn),
+		identifiers.windowsCapture:    newStepBuiltin(identifiers.windowsCapture, windowsCaptureFunc),
+		identifiers.gpuCapture:        newStepBuiltin(identifiers.gpuCapture, gpuCaptureFunc),
+		identifiers.diskCapture:       newStepBuiltin(identifiers.diskCapture, diskCaptureFunc),
+		identifiers.conntrackCapture:  newStepBuiltin(identifiers.conntrackCapture, conntrackCaptureFunc),
+		identifiers.procCapture:       newStepBuiltin(identifiers.procCapture, procCaptureFunc),
+		identifiers.kubeadmCapture:    newStepBuiltin(identifiers.kubeadmCapture, kubeadmCaptureFunc),
+		identifiers.mustGather:        newStepBuiltin(identifiers.mustGather, mustGatherFunc),
+		identifiers.k3sCapture:        newStepBuiltin(identifiers.k3sCapture, k3sCaptureFunc),
+		identifiers.capiCapture:       newStepBuiltin(identifiers.capiCapture, capiCaptureFunc),
+		identifiers.vsphereCapture:    newStepBuiltin(identifiers.vsphereCapture, vsphereCaptureFunc),
+		identifiers.ec2Capture:        newStepBuiltin(identifiers.ec2Capture, ec2CaptureFunc),
+		identifiers.azureCapture:      newStepBuiltin(identifiers.azureCapture, azureCaptureFunc),
+		identifiers.auditLogCapture:   newStepBuiltin(identifiers.auditLogCapture, auditLogCaptureFunc),
+		identifiers.kubeletCapture:    newStepBuiltin(identifiers.kubeletCapture, kubeletCaptureFunc),
+		identifiers.rbacCapture:       newStepBuiltin(identifiers.rbacCapture, rbacCaptureFunc),
+		identifiers.webhookCapture:    newStepBuiltin(identifiers.webhookCapture, webhookCaptureFunc),
+		identifiers.veleroCapture:     newStepBuiltin(identifiers.veleroCapture, veleroCaptureFunc),
+		identifiers.ingressCapture:    newStepBuiltin(identifiers.ingressCapture, ingressCaptureFunc),
+		identifiers.meshCapture:       newStepBuiltin(identifiers.meshCapture, meshCaptureFunc),
+		identifiers.schedulingCapture: newStepBuiltin(identifiers.schedulingCapture, schedulingCaptureFunc),
+		identifiers.corednsCapture:    newStepBuiltin(identifiers.corednsCapture, corednsCaptureFunc),
+		identifiers.detectPlatform:    newStepBuiltin(identifiers.detectPlatform, detectPlatformFunc),
+		identifiers.osFamily:          starlark.NewBuiltin(identifiers.osFamily, osFamilyFunc),
+		identifiers.timeSyncCheck:     newStepBuiltin(identifiers.timeSyncCheck, timeSyncCheckFunc),
+		identifiers.mtuCheck:          newStepBuiltin(identifiers.mtuCheck, mtuCheckFunc),
+		identifiers.netPerf:           newStepBuiltin(identifiers.netPerf, netPerfFunc),
+		identifiers.crashdVersion:     starlark.NewBuiltin(identifiers.crashdVersion, crashdVersionFunc),
+		identifiers.requireVersion:    starlark.NewBuiltin(identifiers.requireVersion, requireVersionFunc),
+		identifiers.template:          starlark.NewBuiltin(identifiers.template, templateFunc),
+		identifiers.prompt:            starlark.NewBuiltin(identifiers.prompt, promptFunc),
+		identifiers.confirm:           starlark.NewBuiltin(identifiers.confirm, confirmFunc),
+		identifiers.assert:            starlark.NewBuiltin(identifiers.assert, assertFunc),
+		identifiers.finding:           starlark.NewBuiltin(identifiers.finding, findingFunc),
+		identifiers.waitFor:           starlark.NewBuiltin(identifiers.waitFor, waitForFunc),
+		identifiers.parallel:          starlark.NewBuiltin(identifiers.parallel, parallelFunc),
+		identifiers.declareArgs:       starlark.NewBuiltin(identifiers.declareArgs, declareArgsFunc),
+		identifiers.argSpec:           starlark.NewBuiltin(identifiers.argSpec, argSpecFunc),
 	}
 }
+
+// getContext returns the context of the run on thread, or a background context
+func getContext(thread *starlark.Thread) context.Context {
+	if ctx, ok := thread.Local(contextLocal).(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+// getExecOptions returns the ExecOptions set on the thread, if any
+func getExecOptions(thread *starlark.Thread) ExecOptions {
+	opts, _ := thread.Local(execOptionsLocal).(ExecOptions)
+	return opts
+}
+
+// newStepBuiltin creates a built-in for a collection step: the step can be
+// tagged and prioritized, is subject to the bundle size quota, and acquires the lock of locked runs
+func newStepBuiltin(name string, fn BuiltinFunc) *starlark.Builtin {
+	return starlark.NewBuiltin(name, withTags(name, withPriority(name, withBundleQuota(name, withRunLock(fn)))))
+}
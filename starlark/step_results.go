@@ -0,0 +1,64 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+)
+
+// StepResult is the outcome of a built-in function invoked by the script
+type StepResult struct {
+	// Name is the name of the built-in (i.e. kube_capture)
+	Name string
+	// Position is the location, in the script, of the invocation
+	Position string
+	Start    time.Time
+	Duration time.Duration
+	// Result is the value returned by the built-in, converted to Go values: nil, bool,
+	// int64, float64, string, []interface{}, or map[string]interface{}
+	Result interface{}
+	Err    error
+}
+
+// Steps returns the results of the built-in invocations, in order, of the last execution
+func (e *Executor) Steps() []StepResult {
+	timings, ok := e.thread.Local(stepTimingsLocal).(*stepTimings)
+	if !ok {
+		return nil
+	}
+
+	var results []StepResult
+	for _, step := range timings.list() {
+		result := StepResult{Name: step.name, Position: step.pos, Start: step.start, Duration: step.duration, Err: step.err}
+		if step.result != nil {
+			val, err := starlarkToGoValue(step.result)
+			if err != nil {
+				logrus.Debugf("step %s: result not converted: %s", step.name, err)
+			}
+			result.Result = val
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// Globals returns the global variables of the executed script converted to Go values.
+// Variables that cannot be converted (i.e. functions) are omitted.
+func (e *Executor) Globals() map[string]interface{} {
+	globals := make(map[string]interface{})
+	for name, val := range e.result {
+		if _, ok := val.(starlark.Callable); ok {
+			continue
+		}
+		goVal, err := starlarkToGoValue(val)
+		if err != nil {
+			continue
+		}
+		globals[name] = goVal
+	}
+	return globals
+}
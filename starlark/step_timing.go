@@ -0,0 +1,184 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+
+	"github.com/vmware-tanzu/crash-diagnostics/metrics"
+	"github.com/vmware-tanzu/crash-diagnostics/tracing"
+)
+
+const (
+	// stepTimingsLocal is the thread local key used to store the step timings
+	stepTimingsLocal = "crashd_step_timings"
+	// stepSpanLocal is the thread local key used to store the tracing span of the running step
+	stepSpanLocal = "crashd_step_span"
+	// timingsFileName is the timing report saved in the working directory
+	timingsFileName = "timings.txt"
+	// timingsSummarySize is the number of slowest steps printed at the end of a run
+	timingsSummarySize = 10
+)
+
+// stepTiming is the wall-clock duration of a built-in invocation
+type stepTiming struct {
+	name     string
+	pos      string
+	start    time.Time
+	duration time.Duration
+	result   starlark.Value
+	err      error
+}
+
+// stepTimings records the duration of every built-in invocation during a run
+type stepTimings struct {
+	mu    sync.Mutex
+	steps []stepTiming
+	// reportMu serializes the writes of the reports by steps running in parallel
+	reportMu sync.Mutex
+	// index is the run index, updated with each step so that the outputs of the previous steps
+	// are not listed again (guarded by reportMu)
+	index runIndex
+}
+
+func (t *stepTimings) add(step stepTiming) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.steps = append(t.steps, step)
+}
+
+// list returns the steps in invocation order
+func (t *stepTimings) list() []stepTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	steps := make([]stepTiming, len(t.steps))
+	copy(steps, t.steps)
+	return steps
+}
+
+// sorted returns the steps sorted by decreasing duration
+func (t *stepTimings) sorted() []stepTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	steps := make([]stepTiming, len(t.steps))
+	copy(steps, t.steps)
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].duration > steps[j].duration
+	})
+	return steps
+}
+
+// write writes the timing report, slowest steps first, to w
+func (t *stepTimings) write(w io.Writer) error {
+	var total time.Duration
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DURATION\tSTEP\tLOCATION\tSTARTED\tSTATUS")
+	for _, step := range t.sorted() {
+		total += step.duration
+		status := "ok"
+		if step.err != nil {
+			status = "error"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			step.duration.Round(time.Millisecond), step.name, step.pos, step.start.Format(time.RFC3339), status)
+	}
+	fmt.Fprintf(tw, "%s\tTOTAL\t\t\t\n", total.Round(time.Millisecond))
+	return tw.Flush()
+}
+
+// logTimingsSummary prints the slowest steps of the run
+func logTimingsSummary(timings *stepTimings) {
+	steps := timings.sorted()
+	if len(steps) == 0 {
+		return
+	}
+	if len(steps) > timingsSummarySize {
+		steps = steps[:timingsSummarySize]
+	}
+	logrus.Info("slowest steps:")
+	for _, step := range steps {
+		logrus.Infof("  %-10s %s (%s)", step.duration.Round(time.Millisecond), step.name, step.pos)
+	}
+}
+
+// withTiming wraps a built-in to record the duration of each of its invocations.
+// The timing report is updated in the working directory after each invocation so that
+// it is included in archives created by the script, along with the error report and the run index. When
+// fail-fast is set, a step reporting errors for any of its targets stops the script. Invocations fail once the
+// context of the run is done, which stops the script at its next step.
+func withTiming(b *starlark.Builtin) *starlark.Builtin {
+	return starlark.NewBuiltin(b.Name(), func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := getContext(thread).Err(); err != nil {
+			return starlark.None, fmt.Errorf("%s: run stopped: %s", b.Name(), err)
+		}
+
+		timings, ok := thread.Local(stepTimingsLocal).(*stepTimings)
+		if !ok {
+			return b.CallInternal(thread, args, kwargs)
+		}
+
+		pos := ""
+		if thread.CallStackDepth() > 1 {
+			pos = thread.CallFrame(1).Pos.String()
+		}
+		// the current step span is kept per thread, and copied to the threads of parallel(), so that
+		// nested steps are children of the step calling them
+		parent, _ := thread.Local(stepSpanLocal).(*tracing.Span)
+		span := tracing.StartStep(parent, b.Name(), tracing.String("crashd.position", pos))
+		thread.SetLocal(stepSpanLocal, span)
+		start := time.Now()
+		result, err := b.CallInternal(thread, args, kwargs)
+		thread.SetLocal(stepSpanLocal, parent)
+		span.End(err)
+		duration := time.Since(start)
+		metrics.RecordStep(b.Name(), duration, err)
+		// the step is recorded without the values entered with prompt(secret=True)
+		secrets := getSecretValues(thread)
+		step := stepTiming{name: b.Name(), pos: pos, start: start, duration: duration, result: secrets.redact(result), err: secrets.redactErr(err)}
+		timings.add(step)
+
+		if workdir, wdErr := getWorkdirFromThread(thread); wdErr == nil {
+			timings.reportMu.Lock()
+			if err := writeTimingsFile(timings, workdir); err != nil {
+				logrus.Debugf("failed to write timing report: %s", err)
+			}
+			if err := writeErrorReport(timings.list(), workdir); err != nil {
+				logrus.Debugf("failed to write error report: %s", err)
+			}
+			timings.index.Steps = append(timings.index.Steps, newIndexStep(step, workdir))
+			if err := writeRunIndex(timings.index, workdir); err != nil {
+				logrus.Debugf("failed to write run index: %s", err)
+			}
+			timings.reportMu.Unlock()
+		}
+
+		// errors of individual targets do not stop the script, unless fail-fast is set
+		if err == nil && getExecOptions(thread).FailFast {
+			err = failFastError(stepErrorsOf(step))
+		}
+		return result, err
+	})
+}
+
+func writeTimingsFile(timings *stepTimings, workdir string) error {
+	if _, err := os.Stat(workdir); err != nil {
+		return err
+	}
+	file, err := os.Create(filepath.Join(workdir, timingsFileName))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return timings.write(file)
+}
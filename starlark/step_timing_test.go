@@ -0,0 +1,110 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestStepTimingsWrite(t *testing.T) {
+	timings := &stepTimings{}
+	timings.add(stepTiming{name: "run", pos: "test.star:2:4", duration: 10 * time.Millisecond})
+	timings.add(stepTiming{name: "capture", pos: "test.star:3:8", duration: 2 * time.Second})
+	timings.add(stepTiming{name: "copy_from", pos: "test.star:4:10", duration: time.Second, err: fmt.Errorf("failed")})
+
+	var buf bytes.Buffer
+	if err := timings.write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("unexpected report: %s", buf.String())
+	}
+	for i, name := range []string{"capture", "copy_from", "run", "TOTAL"} {
+		if !strings.Contains(lines[i+1], name) {
+			t.Errorf("expecting %s at line %d, got: %s", name, i+1, lines[i+1])
+		}
+	}
+	if !strings.Contains(lines[2], "error") {
+		t.Errorf("expecting error status: %s", lines[2])
+	}
+	if !strings.HasPrefix(lines[4], "3.01s") {
+		t.Errorf("unexpected total: %s", lines[4])
+	}
+}
+
+func TestLogTimingsSummary(t *testing.T) {
+	timings := &stepTimings{}
+	for i := 0; i < timingsSummarySize+2; i++ {
+		timings.add(stepTiming{name: fmt.Sprintf("step%d", i), duration: time.Duration(i) * time.Second})
+	}
+
+	var buf bytes.Buffer
+	logrus.SetOutput(&buf)
+	defer logrus.SetOutput(os.Stderr)
+	logTimingsSummary(timings)
+
+	out := buf.String()
+	if !strings.Contains(out, "slowest steps") || strings.Index(out, "step11") > strings.Index(out, "step10") {
+		t.Errorf("expecting the slowest steps first: %s", out)
+	}
+	if strings.Contains(out, "step0 ") || strings.Contains(out, "step1 ") {
+		t.Errorf("expecting the %d slowest steps only: %s", timingsSummarySize, out)
+	}
+}
+
+func TestStepTimingsScript(t *testing.T) {
+	workdir, err := ioutil.TempDir("", "crashd-timing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+
+	script := fmt.Sprintf(`
+crashd_config(workdir="%s")
+set_defaults(resources(provider=local_provider()))
+run("echo timing")
+`, workdir)
+	exe := New()
+	if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := ioutil.ReadFile(filepath.Join(workdir, timingsFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []string{"crashd_config", "resources", "run", "test.star:4:4"} {
+		if !strings.Contains(string(report), step) {
+			t.Errorf("timing report missing %s: %s", step, report)
+		}
+	}
+
+	// the run index is updated with each step
+	data, err := ioutil.ReadFile(filepath.Join(workdir, indexFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index runIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatal(err)
+	}
+	var steps []string
+	for _, step := range index.Steps {
+		steps = append(steps, step.Step)
+	}
+	if strings.Join(steps, ",") != "crashd_config,local_provider,resources,set_defaults,run" {
+		t.Errorf("unexpected index steps: %v", steps)
+	}
+}
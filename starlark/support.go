@@ -9,10 +9,14 @@ import (
 	"os/user"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strconv"
+	"strings"
+	"time"
 
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 var (
@@ -21,6 +25,7 @@ var (
 	identifiers = struct {
 		crashdCfg string
 		kubeCfg   string
+		awsCfg    string
 
 		sshCfg         string
 		port           string
@@ -47,9 +52,56 @@ var (
 		kubeNodesProvider string
 		capvProvider      string
 		capaProvider      string
+		capzProvider      string
+		pprofCapture      string
+		windowsCapture    string
+		gpuCapture        string
+		diskCapture       string
+		conntrackCapture  string
+		procCapture       string
+		kubeadmCapture    string
+		mustGather        string
+		k3sCapture        string
+		capiCapture       string
+		vsphereCapture    string
+		ec2Capture        string
+		azureCapture      string
+		auditLogCapture   string
+		kubeletCapture    string
+		rbacCapture       string
+		webhookCapture    string
+		kubeAgentProvider string
+		kubePortForward   string
+		veleroCapture     string
+		ingressCapture    string
+		meshCapture       string
+		schedulingCapture string
+		corednsCapture    string
+		timeSyncCheck     string
+		mtuCheck          string
+		netPerf           string
+		detectPlatform    string
+		osFamily          string
+		localProvider     string
+		kubeProxyProvider string
+		crashdVersion     string
+		requireVersion    string
+		time              string
+		math              string
+		re                string
+		template          string
+		prompt            string
+		confirm           string
+		assert            string
+		finding           string
+		waitFor           string
+		declareArgs       string
+		argSpec           string
+		parallel          string
 	}{
 		crashdCfg: "crashd_config",
 		kubeCfg:   "kube_config",
+		awsCfg:    "aws_config",
 		sshCfg:    "ssh_config",
 
 		port:           "port",
@@ -76,6 +128,52 @@ var (
 		kubeNodesProvider: "kube_nodes_provider",
 		capvProvider:      "capv_provider",
 		capaProvider:      "capa_provider",
+		capzProvider:      "capz_provider",
+		pprofCapture:      "pprof_capture",
+		windowsCapture:    "windows_capture",
+		gpuCapture:        "gpu_capture",
+		diskCapture:       "disk_capture",
+		conntrackCapture:  "conntrack_capture",
+		procCapture:       "proc_capture",
+		kubeadmCapture:    "kubeadm_capture",
+		mustGather:        "must_gather",
+		k3sCapture:        "k3s_capture",
+		capiCapture:       "capi_capture",
+		vsphereCapture:    "vsphere_capture",
+		ec2Capture:        "ec2_capture",
+		azureCapture:      "azure_capture",
+		auditLogCapture:   "audit_log_capture",
+		kubeletCapture:    "kubelet_capture",
+		rbacCapture:       "rbac_capture",
+		webhookCapture:    "webhook_capture",
+		kubeAgentProvider: "kube_agent_provider",
+		kubePortForward:   "kube_port_forward",
+		veleroCapture:     "velero_capture",
+		ingressCapture:    "ingress_capture",
+		meshCapture:       "mesh_capture",
+		schedulingCapture: "scheduling_capture",
+		corednsCapture:    "coredns_capture",
+		timeSyncCheck:     "time_sync_check",
+		mtuCheck:          "mtu_check",
+		netPerf:           "net_perf",
+		detectPlatform:    "detect_platform",
+		osFamily:          "os_family",
+		localProvider:     "local_provider",
+		kubeProxyProvider: "kube_proxy_provider",
+		crashdVersion:     "crashd_version",
+		requireVersion:    "require_version",
+		time:              "time",
+		math:              "math",
+		re:                "re",
+		template:          "template",
+		prompt:            "prompt",
+		confirm:           "confirm",
+		assert:            "assert",
+		finding:           "finding",
+		waitFor:           "wait_for",
+		declareArgs:       "declare_args",
+		argSpec:           "arg_spec",
+		parallel:          "parallel",
 	}
 
 	defaults = struct {
@@ -87,22 +185,169 @@ var (
 		outPath     string
 		connRetries int
 		connTimeout int // seconds
+
+		pprofPort     int
+		pprofSeconds  int
+		pprofProfiles []string
+
+		kubeletEndpoints    []string
+		webhookProbeTimeout time.Duration
+		discoveryCacheTTL   time.Duration
+
+		tailLines        int
+		logSince         string
+		windowsLogDir    string
+		windowsMaxEvents int
+
+		gpuNodeLabels         []string
+		gpuDevicePluginLabels []string
+
+		diskWarnPct int
+		diskPaths   []string
+
+		conntrackMaxEntries int
+
+		procFilter string
+
+		controlPlaneLabels []string
+		auditLogMaxLines   int
+
+		mustGatherKinds      []string
+		mustGatherNamespaces []string
+
+		capiGroups        []string
+		capiKinds         []string
+		capiProviderLabel string
+
+		vsphereMaxEvents int
+		ec2MaxEvents     int
+
+		waitTimeout  time.Duration
+		waitInterval time.Duration
+
+		kubeAgentTimeout       time.Duration
+		kubePortForwardTimeout time.Duration
+
+		veleroNamespace    string
+		veleroKinds        []string
+		veleroPodLabels    []string
+		veleroProbeTimeout time.Duration
+
+		ingressGroups  []string
+		ingressKinds   []string
+		ingressTimeout time.Duration
+
+		meshProxies      int
+		meshTimeout      time.Duration
+		meshCertWarnDays int
+
+		autoscalerLabels []string
+		autoscalerGroups []string
+
+		dnsConfigMaps   []string
+		dnsLabels       []string
+		dnsLookupNames  []string
+		dnsProbeTimeout time.Duration
+
+		timeSyncThreshold time.Duration
+
+		netPerfDuration int
+		netPerfPort     int
 	}{
-		crashdir: filepath.Join(os.Getenv("HOME"), ".crashd"),
-		workdir:  "/tmp/crashd",
+		crashdir: filepath.Join(homeDir(), ".crashd"),
+		workdir:  defaultWorkdir(),
 		kubeconfig: func() string {
 			kubecfg := os.Getenv("KUBECONFIG")
 			if kubecfg == "" {
-				kubecfg = filepath.Join(os.Getenv("HOME"), ".kube", "config")
+				kubecfg = filepath.Join(homeDir(), ".kube", "config")
 			}
 			return kubecfg
 		}(),
 		sshPort: "22",
 		pkPath: func() string {
-			return filepath.Join(os.Getenv("HOME"), ".ssh", "id_rsa")
+			return filepath.Join(homeDir(), ".ssh", "id_rsa")
 		}(),
 		connRetries: 30,
 		connTimeout: 30,
+
+		pprofPort:     6060,
+		pprofSeconds:  30,
+		pprofProfiles: []string{"heap", "goroutine", "profile"},
+
+		kubeletEndpoints:    []string{"/pods", "/stats/summary", "/configz"},
+		webhookProbeTimeout: 5 * time.Second,
+		discoveryCacheTTL:   10 * time.Minute,
+
+		tailLines:        5000,
+		logSince:         "24h",
+		windowsLogDir:    `C:\k`,
+		windowsMaxEvents: 500,
+
+		gpuNodeLabels:         []string{"nvidia.com/gpu.present=true"},
+		gpuDevicePluginLabels: []string{"app=nvidia-device-plugin-daemonset", "name=nvidia-device-plugin-ds"},
+
+		diskWarnPct: 85,
+		diskPaths:   []string{"/var/lib/containerd", "/var/lib/docker", "/var/lib/kubelet", "/var/log"},
+
+		conntrackMaxEntries: 10000,
+
+		procFilter: "kube|containerd",
+
+		controlPlaneLabels: []string{"node-role.kubernetes.io/master"},
+		auditLogMaxLines:   50000,
+
+		mustGatherKinds:      []string{"clusteroperators", "clusterversions", "machineconfigpools", "machineconfigs", "nodes"},
+		mustGatherNamespaces: []string{"openshift-*"},
+
+		capiGroups:        []string{"cluster.x-k8s.io", "controlplane.cluster.x-k8s.io"},
+		capiKinds:         []string{"clusters", "machines", "machinesets", "machinedeployments", "machinehealthchecks", "kubeadmcontrolplanes"},
+		capiProviderLabel: "cluster.x-k8s.io/provider",
+
+		vsphereMaxEvents: 50,
+		ec2MaxEvents:     50,
+
+		waitTimeout:  5 * time.Minute,
+		waitInterval: 10 * time.Second,
+
+		kubeAgentTimeout:       2 * time.Minute,
+		kubePortForwardTimeout: 30 * time.Second,
+
+		veleroNamespace: "velero",
+		veleroKinds: []string{
+			"backups", "restores", "schedules", "backupstoragelocations", "volumesnapshotlocations",
+			"podvolumebackups", "podvolumerestores", "backuprepositories", "resticrepositories",
+			"datauploads", "datadownloads", "deletebackuprequests",
+		},
+		veleroPodLabels:    []string{"component=velero", "name=node-agent", "name=restic"},
+		veleroProbeTimeout: 5 * time.Second,
+
+		ingressGroups: []string{"networking.k8s.io", "extensions", "gateway.networking.k8s.io"},
+		ingressKinds: []string{
+			"ingresses", "ingressclasses", "gateways", "gatewayclasses",
+			"httproutes", "grpcroutes", "tlsroutes", "tcproutes", "udproutes", "referencegrants",
+		},
+		ingressTimeout: 30 * time.Second,
+
+		meshProxies:      10,
+		meshTimeout:      30 * time.Second,
+		meshCertWarnDays: 1,
+
+		autoscalerLabels: []string{
+			"app=cluster-autoscaler", "k8s-app=cluster-autoscaler", "app.kubernetes.io/name=cluster-autoscaler",
+			"app.kubernetes.io/name=aws-cluster-autoscaler", "app.kubernetes.io/name=clusterapi-cluster-autoscaler",
+			"app.kubernetes.io/name=karpenter",
+		},
+		autoscalerGroups: []string{"scheduling.k8s.io", "karpenter.sh", "karpenter.k8s.aws", "autoscaling.x-k8s.io"},
+
+		dnsConfigMaps:   []string{"coredns", "coredns-custom", "kube-dns", "node-local-dns"},
+		dnsLabels:       []string{"k8s-app=kube-dns", "k8s-app=node-local-dns"},
+		dnsLookupNames:  []string{"kubernetes.default.svc.cluster.local", "kube-dns.kube-system.svc.cluster.local", "kubernetes.io"},
+		dnsProbeTimeout: 2 * time.Minute,
+
+		timeSyncThreshold: 500 * time.Millisecond,
+
+		netPerfDuration: 10,
+		netPerfPort:     5201,
 	}
 )
 
@@ -152,11 +397,33 @@ func trimQuotes(val string) string {
 func getUsername() string {
 	usr, err := user.Current()
 	if err != nil {
-		return ""
+		// USER on Linux and macOS, USERNAME on Windows
+		if name := os.Getenv("USER"); len(name) > 0 {
+			return name
+		}
+		return os.Getenv("USERNAME")
 	}
 	return usr.Username
 }
 
+// homeDir returns the home directory of the current user ($HOME on Linux and macOS,
+// %USERPROFILE% on Windows)
+func homeDir() string {
+	if home, err := os.UserHomeDir(); err == nil {
+		return home
+	}
+	return os.Getenv("HOME")
+}
+
+// defaultWorkdir returns /tmp/crashd, or crashd in the temporary directory of the
+// user on Windows
+func defaultWorkdir() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.TempDir(), "crashd")
+	}
+	return "/tmp/crashd"
+}
+
 func getUid() string {
 	usr, err := user.Current()
 	if err != nil {
@@ -176,3 +443,29 @@ func getGid() string {
 func sanitizeStr(str string) string {
 	return strSanitization.ReplaceAllString(str, "_")
 }
+
+// parseByteSize converts a size value, either a number of bytes or a quantity
+// string such as "500Mi", "1GiB", or "2G", to bytes. An unset value returns zero.
+func parseByteSize(val starlark.Value) (int64, error) {
+	switch size := val.(type) {
+	case nil, starlark.NoneType:
+		return 0, nil
+	case starlark.Int:
+		bytes, ok := size.Int64()
+		if !ok || bytes < 0 {
+			return 0, fmt.Errorf("invalid size %s", size)
+		}
+		return bytes, nil
+	case starlark.String:
+		qty, err := resource.ParseQuantity(strings.TrimSuffix(string(size), "B"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid size %s: %s", size, err)
+		}
+		if qty.Sign() < 0 {
+			return 0, fmt.Errorf("invalid size %s", size)
+		}
+		return qty.Value(), nil
+	default:
+		return 0, fmt.Errorf("expecting int or string, got %s", val.Type())
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+)
+
+// tagsArg is the keyword argument, accepted by all step built-ins, used to tag a step
+const tagsArg = "tags"
+
+// withTags wraps a step built-in so that it accepts a tags=[...] keyword argument.
+// The step is skipped (returning None) when its tags are not selected by the
+// run-wide --only and --skip options.
+func withTags(name string, fn BuiltinFunc) BuiltinFunc {
+	return func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		tags, kwargs, err := popTags(kwargs)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", name, err)
+		}
+
+		opts := getExecOptions(thread)
+		if !tagsSelected(tags, opts.Only, opts.Skip) {
+			logrus.Infof("%s: skipped (tags %v)", name, tags)
+			return starlark.None, nil
+		}
+		return fn(thread, b, args, kwargs)
+	}
+}
+
+// popTags removes the tags keyword argument from kwargs and returns its value.
+// Tags can be specified as a string or a list of strings.
+func popTags(kwargs []starlark.Tuple) ([]string, []starlark.Tuple, error) {
+	var tags []string
+	var remaining []starlark.Tuple
+	for _, kwarg := range kwargs {
+		if key, ok := kwarg[0].(starlark.String); !ok || string(key) != tagsArg {
+			remaining = append(remaining, kwarg)
+			continue
+		}
+		switch val := kwarg[1].(type) {
+		case starlark.String:
+			tags = append(tags, string(val))
+		case *starlark.List:
+			for i := 0; i < val.Len(); i++ {
+				tag, ok := val.Index(i).(starlark.String)
+				if !ok {
+					return nil, nil, fmt.Errorf("%s: expecting string, got %s", tagsArg, val.Index(i).Type())
+				}
+				tags = append(tags, string(tag))
+			}
+		default:
+			return nil, nil, fmt.Errorf("%s: expecting string or list, got %s", tagsArg, val.Type())
+		}
+	}
+	return tags, remaining, nil
+}
+
+// tagsSelected returns true if a step with the tags should run: when only is set, the
+// step must have at least one of the tags in only; a step with any tag in skip is skipped.
+func tagsSelected(tags, only, skip []string) bool {
+	hasAny := func(set []string) bool {
+		for _, tag := range tags {
+			for _, s := range set {
+				if tag == s {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	if len(only) > 0 && !hasAny(only) {
+		return false
+	}
+	return !hasAny(skip)
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTagsSelected(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     []string
+		only     []string
+		skip     []string
+		selected bool
+	}{
+		{name: "no selection", tags: []string{"network"}, selected: true},
+		{name: "untagged no selection", selected: true},
+		{name: "only matching", tags: []string{"network", "logs"}, only: []string{"network"}, selected: true},
+		{name: "only not matching", tags: []string{"logs"}, only: []string{"network"}},
+		{name: "only untagged", only: []string{"network"}},
+		{name: "skip matching", tags: []string{"logs"}, skip: []string{"logs"}},
+		{name: "only and skip", tags: []string{"network", "logs"}, only: []string{"network"}, skip: []string{"logs"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if selected := tagsSelected(test.tags, test.only, test.skip); selected != test.selected {
+				t.Errorf("expecting selected %t, got %t", test.selected, selected)
+			}
+		})
+	}
+}
+
+func TestTagsScript(t *testing.T) {
+	script := `
+set_defaults(resources(provider=local_provider()))
+net = run("echo network", tags=["network"])
+logs = run("echo logs", tags="logs")
+untagged = run("echo untagged")
+`
+	var tee bytes.Buffer
+	exe := New()
+	exe.SetOptions(ExecOptions{Tee: &tee, Skip: []string{"logs"}})
+	if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+
+	output := tee.String()
+	if !strings.Contains(output, "echo network") || !strings.Contains(output, "echo untagged") {
+		t.Errorf("expected steps not executed: %s", output)
+	}
+	if strings.Contains(output, "echo logs") {
+		t.Errorf("skipped step executed: %s", output)
+	}
+	if exe.result["logs"].String() != "None" {
+		t.Errorf("skipped step should return None, got %s", exe.result["logs"])
+	}
+}
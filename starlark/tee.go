@@ -0,0 +1,65 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+)
+
+// getTeeWriter returns where command output is echoed: the console when echo is
+// set on the call, the run-wide tee (--tee) otherwise, or nil when output is not echoed.
+func getTeeWriter(thread *starlark.Thread, echo bool) io.Writer {
+	opts := getExecOptions(thread)
+	if echo {
+		if opts.Console != nil {
+			return opts.Console
+		}
+		return os.Stdout
+	}
+	return opts.Tee
+}
+
+// teeRunResults writes the output of a command, for each resource, to w
+func teeRunResults(w io.Writer, cmdStr string, results []commandResult) {
+	if w == nil {
+		return
+	}
+	for _, result := range results {
+		output := result.result
+		if result.err != nil {
+			output = result.err.Error()
+		}
+		teeOutput(w, result.resource, cmdStr, strings.NewReader(output))
+	}
+}
+
+// teeCaptureResults writes the content of the files captured, for each resource, to w
+func teeCaptureResults(w io.Writer, cmdStr string, results []commandResult) {
+	if w == nil {
+		return
+	}
+	for _, result := range results {
+		file, err := os.Open(result.result)
+		if err != nil {
+			logrus.Debugf("tee: %s", err)
+			continue
+		}
+		teeOutput(w, result.resource, cmdStr, file)
+		file.Close()
+	}
+}
+
+func teeOutput(w io.Writer, resource, cmdStr string, output io.Reader) {
+	fmt.Fprintf(w, "[%s] $ %s\n", resource, cmdStr)
+	if _, err := io.Copy(w, output); err != nil {
+		logrus.Debugf("tee: %s", err)
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTeeOutput(t *testing.T) {
+	workdir, err := ioutil.TempDir("", "crashd-tee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+
+	script := fmt.Sprintf(`
+crashd_config(workdir="%s")
+set_defaults(resources(provider=local_provider()))
+run("echo tee-run")
+capture("echo tee-capture", file_name="tee.txt")
+`, workdir)
+
+	var tee bytes.Buffer
+	exe := New()
+	exe.SetOptions(ExecOptions{Tee: &tee})
+	if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+
+	output := tee.String()
+	for _, expected := range []string{"[localhost] $ echo tee-run", "tee-run", "[localhost] $ echo tee-capture", "tee-capture"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("tee output missing %q: %s", expected, output)
+		}
+	}
+}
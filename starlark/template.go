@@ -0,0 +1,87 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"go.starlark.net/starlark"
+)
+
+// templateFunc is a built-in starlark function that renders a Go text/template with the
+// provided vars (a dict or struct). The source is a template file, when it names an existing
+// file, or the template text otherwise. When output_file is provided, the rendered text is saved,
+// relative to the working directory, and the path of the file is returned.
+// Starlark format: template(<file or string>, vars={...} [, output_file=path])
+func templateFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var source, outputFile string
+	var vars starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(
+		identifiers.template, args, kwargs,
+		"source", &source,
+		"vars?", &vars,
+		"output_file?", &outputFile,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.template, err)
+	}
+
+	name, text, err := readTemplateSource(source)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.template, err)
+	}
+
+	data, err := starlarkToGoValue(vars)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: vars: %s", identifiers.template, err)
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.template, err)
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.template, err)
+	}
+
+	if len(outputFile) == 0 {
+		return starlark.String(rendered.String()), nil
+	}
+
+	if !filepath.IsAbs(outputFile) {
+		workdir, err := getWorkdirFromThread(thread)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.template, err)
+		}
+		outputFile = filepath.Join(workdir, outputFile)
+	}
+	if err := os.MkdirAll(filepath.Dir(outputFile), 0744); err != nil && !os.IsExist(err) {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.template, err)
+	}
+	if err := ioutil.WriteFile(outputFile, rendered.Bytes(), 0644); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.template, err)
+	}
+	return starlark.String(outputFile), nil
+}
+
+// readTemplateSource returns the name and text of the template: the content of the file
+// named by source, when it exists, or source itself
+func readTemplateSource(source string) (string, string, error) {
+	if !strings.ContainsAny(source, "\n{") {
+		if info, err := os.Stat(source); err == nil && !info.IsDir() {
+			text, err := ioutil.ReadFile(source)
+			if err != nil {
+				return "", "", err
+			}
+			return filepath.Base(source), string(text), nil
+		}
+	}
+	return identifiers.template, source, nil
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestTemplateScript(t *testing.T) {
+	tmplFile, err := ioutil.TempFile("", "crashd-template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmplFile.Name())
+	if _, err := tmplFile.WriteString("{{range .nodes}}{{.}}\n{{end}}"); err != nil {
+		t.Fatal(err)
+	}
+	tmplFile.Close()
+
+	tests := []struct {
+		name      string
+		script    string
+		eval      func(t *testing.T, result starlark.Value)
+		shouldErr bool
+	}{
+		{
+			name:   "template string with dict",
+			script: `result = template("kubectl logs -n {{.ns}} {{.pod}} --tail={{.lines}}", vars={"ns": "kube-system", "pod": "etcd-0", "lines": 100})`,
+			eval: func(t *testing.T, result starlark.Value) {
+				if string(result.(starlark.String)) != "kubectl logs -n kube-system etcd-0 --tail=100" {
+					t.Errorf("unexpected result: %s", result)
+				}
+			},
+		},
+		{
+			name:   "template file",
+			script: fmt.Sprintf(`result = template("%s", vars={"nodes": ["node-a", "node-b"]})`, tmplFile.Name()),
+			eval: func(t *testing.T, result starlark.Value) {
+				if string(result.(starlark.String)) != "node-a\nnode-b\n" {
+					t.Errorf("unexpected result: %q", result)
+				}
+			},
+		},
+		{
+			name:   "template output file",
+			script: `result = template("host: {{.host}}", vars={"host": "10.0.0.1"}, output_file="reports/host.yaml")`,
+			eval: func(t *testing.T, result starlark.Value) {
+				path := string(result.(starlark.String))
+				if path != filepath.Join(defaults.workdir, "reports/host.yaml") {
+					t.Errorf("unexpected output file: %s", path)
+				}
+				content, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(content) != "host: 10.0.0.1" {
+					t.Errorf("unexpected content: %s", content)
+				}
+			},
+		},
+		{
+			name:      "missing var",
+			script:    `result = template("{{.missing}}", vars={})`,
+			shouldErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exe := New()
+			err := exe.Exec("test.star", strings.NewReader(test.script))
+			defer os.RemoveAll(defaults.workdir)
+			if err != nil {
+				if !test.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if test.shouldErr {
+				t.Fatal("expecting error")
+			}
+			test.eval(t, exe.result["result"])
+		})
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// newTimeModule returns the time module, modeled after the go.starlark.net/lib/time module.
+// Starlark format: time.now(), time.parse_time(x, format=, location=), time.parse_duration(d),
+// time.from_timestamp(sec, nsec=0), time.time(year=, month=, day=, hour=, minute=, second=, nanosecond=, location=),
+// and the duration constants time.nanosecond, ..., time.hour.
+func newTimeModule() *starlarkstruct.Module {
+	return &starlarkstruct.Module{
+		Name: identifiers.time,
+		Members: starlark.StringDict{
+			"now":            starlark.NewBuiltin("now", timeNow),
+			"parse_time":     starlark.NewBuiltin("parse_time", timeParseTime),
+			"parse_duration": starlark.NewBuiltin("parse_duration", timeParseDuration),
+			"from_timestamp": starlark.NewBuiltin("from_timestamp", timeFromTimestamp),
+			"time":           starlark.NewBuiltin("time", timeTime),
+
+			"nanosecond":  durationValue(time.Nanosecond),
+			"microsecond": durationValue(time.Microsecond),
+			"millisecond": durationValue(time.Millisecond),
+			"second":      durationValue(time.Second),
+			"minute":      durationValue(time.Minute),
+			"hour":        durationValue(time.Hour),
+		},
+	}
+}
+
+func timeNow(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+		return starlark.None, err
+	}
+	return timeValue(time.Now()), nil
+}
+
+func timeParseTime(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x, location string
+	format := time.RFC3339
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "x", &x, "format?", &format, "location?", &location); err != nil {
+		return starlark.None, err
+	}
+
+	loc, err := loadLocation(location)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", b.Name(), err)
+	}
+	t, err := time.ParseInLocation(format, x, loc)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", b.Name(), err)
+	}
+	return timeValue(t), nil
+}
+
+func timeParseDuration(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var d string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "d", &d); err != nil {
+		return starlark.None, err
+	}
+	dur, err := time.ParseDuration(d)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", b.Name(), err)
+	}
+	return durationValue(dur), nil
+}
+
+func timeFromTimestamp(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var sec, nsec int
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "sec", &sec, "nsec?", &nsec); err != nil {
+		return starlark.None, err
+	}
+	return timeValue(time.Unix(int64(sec), int64(nsec))), nil
+}
+
+func timeTime(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var year, month, day, hour, minute, second, nanosecond int
+	var location string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"year?", &year, "month?", &month, "day?", &day,
+		"hour?", &hour, "minute?", &minute, "second?", &second, "nanosecond?", &nanosecond,
+		"location?", &location,
+	); err != nil {
+		return starlark.None, err
+	}
+	loc, err := loadLocation(location)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", b.Name(), err)
+	}
+	return timeValue(time.Date(year, time.Month(month), day, hour, minute, second, nanosecond, loc)), nil
+}
+
+// loadLocation returns the named location, UTC when name is empty
+func loadLocation(name string) (*time.Location, error) {
+	if len(name) == 0 {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// durationValue is a starlark value for a time.Duration
+type durationValue time.Duration
+
+var (
+	_ starlark.HasBinary  = durationValue(0)
+	_ starlark.HasAttrs   = durationValue(0)
+	_ starlark.Comparable = durationValue(0)
+)
+
+func (d durationValue) String() string        { return time.Duration(d).String() }
+func (d durationValue) Type() string          { return "time.duration" }
+func (d durationValue) Freeze()               {}
+func (d durationValue) Truth() starlark.Bool  { return d != 0 }
+func (d durationValue) Hash() (uint32, error) { return uint32(d) ^ uint32(int64(d)>>32), nil }
+
+var durationAttrs = map[string]func(time.Duration) starlark.Value{
+	"hours":        func(d time.Duration) starlark.Value { return starlark.Float(d.Hours()) },
+	"minutes":      func(d time.Duration) starlark.Value { return starlark.Float(d.Minutes()) },
+	"seconds":      func(d time.Duration) starlark.Value { return starlark.Float(d.Seconds()) },
+	"milliseconds": func(d time.Duration) starlark.Value { return starlark.MakeInt64(int64(d / time.Millisecond)) },
+	"nanoseconds":  func(d time.Duration) starlark.Value { return starlark.MakeInt64(int64(d)) },
+}
+
+func (d durationValue) Attr(name string) (starlark.Value, error) {
+	if attr, ok := durationAttrs[name]; ok {
+		return attr(time.Duration(d)), nil
+	}
+	return nil, nil
+}
+
+func (d durationValue) AttrNames() []string {
+	names := make([]string, 0, len(durationAttrs))
+	for name := range durationAttrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (d durationValue) CompareSameType(op syntax.Token, y starlark.Value, depth int) (bool, error) {
+	return compareInt64(op, int64(d), int64(y.(durationValue)))
+}
+
+// Binary implements duration arithmetic: duration +/- duration, duration * int,
+// duration / int, duration / duration, and duration + time
+func (d durationValue) Binary(op syntax.Token, y starlark.Value, side starlark.Side) (starlark.Value, error) {
+	x := time.Duration(d)
+	switch y := y.(type) {
+	case durationValue:
+		if side == starlark.Right {
+			x, y = time.Duration(y), durationValue(x)
+		}
+		switch op {
+		case syntax.PLUS:
+			return durationValue(x + time.Duration(y)), nil
+		case syntax.MINUS:
+			return durationValue(x - time.Duration(y)), nil
+		case syntax.SLASH, syntax.SLASHSLASH:
+			if y == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return starlark.MakeInt64(int64(x / time.Duration(y))), nil
+		}
+	case starlark.Int:
+		i, ok := y.Int64()
+		if !ok {
+			return nil, fmt.Errorf("int %s out of range", y)
+		}
+		switch {
+		case op == syntax.STAR:
+			return durationValue(x * time.Duration(i)), nil
+		case (op == syntax.SLASH || op == syntax.SLASHSLASH) && side == starlark.Left:
+			if i == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return durationValue(x / time.Duration(i)), nil
+		}
+	case timeValue:
+		if op == syntax.PLUS && side == starlark.Left {
+			return timeValue(time.Time(y).Add(x)), nil
+		}
+	}
+	return nil, nil // unsupported
+}
+
+// timeValue is a starlark value for a time.Time
+type timeValue time.Time
+
+var (
+	_ starlark.HasBinary  = timeValue{}
+	_ starlark.HasAttrs   = timeValue{}
+	_ starlark.Comparable = timeValue{}
+)
+
+func (t timeValue) String() string        { return time.Time(t).String() }
+func (t timeValue) Type() string          { return "time.time" }
+func (t timeValue) Freeze()               {}
+func (t timeValue) Truth() starlark.Bool  { return starlark.Bool(!time.Time(t).IsZero()) }
+func (t timeValue) Hash() (uint32, error) { return uint32(time.Time(t).UnixNano()), nil }
+
+var timeAttrs = map[string]func(time.Time) starlark.Value{
+	"year":        func(t time.Time) starlark.Value { return starlark.MakeInt(t.Year()) },
+	"month":       func(t time.Time) starlark.Value { return starlark.MakeInt(int(t.Month())) },
+	"day":         func(t time.Time) starlark.Value { return starlark.MakeInt(t.Day()) },
+	"hour":        func(t time.Time) starlark.Value { return starlark.MakeInt(t.Hour()) },
+	"minute":      func(t time.Time) starlark.Value { return starlark.MakeInt(t.Minute()) },
+	"second":      func(t time.Time) starlark.Value { return starlark.MakeInt(t.Second()) },
+	"nanosecond":  func(t time.Time) starlark.Value { return starlark.MakeInt(t.Nanosecond()) },
+	"unix":        func(t time.Time) starlark.Value { return starlark.MakeInt64(t.Unix()) },
+	"unix_nano":   func(t time.Time) starlark.Value { return starlark.MakeInt64(t.UnixNano()) },
+	"format":      func(t time.Time) starlark.Value { return timeMethod(t, "format") },
+	"in_location": func(t time.Time) starlark.Value { return timeMethod(t, "in_location") },
+}
+
+func (t timeValue) Attr(name string) (starlark.Value, error) {
+	if attr, ok := timeAttrs[name]; ok {
+		return attr(time.Time(t)), nil
+	}
+	return nil, nil
+}
+
+func (t timeValue) AttrNames() []string {
+	names := make([]string, 0, len(timeAttrs))
+	for name := range timeAttrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// timeMethod returns the built-in for the method name of t
+func timeMethod(t time.Time, name string) starlark.Value {
+	switch name {
+	case "format":
+		return starlark.NewBuiltin(name, func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			layout := time.RFC3339
+			if err := starlark.UnpackArgs(name, args, kwargs, "format?", &layout); err != nil {
+				return starlark.None, err
+			}
+			return starlark.String(t.Format(layout)), nil
+		})
+	default:
+		return starlark.NewBuiltin(name, func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var location string
+			if err := starlark.UnpackArgs(name, args, kwargs, "location", &location); err != nil {
+				return starlark.None, err
+			}
+			loc, err := loadLocation(location)
+			if err != nil {
+				return starlark.None, fmt.Errorf("%s: %s", name, err)
+			}
+			return timeValue(t.In(loc)), nil
+		})
+	}
+}
+
+func (t timeValue) CompareSameType(op syntax.Token, y starlark.Value, depth int) (bool, error) {
+	x, yt := time.Time(t), time.Time(y.(timeValue))
+	cmp := 0
+	switch {
+	case x.Before(yt):
+		cmp = -1
+	case x.After(yt):
+		cmp = 1
+	}
+	return compareInt64(op, int64(cmp), 0)
+}
+
+// Binary implements time arithmetic: time +/- duration and time - time
+func (t timeValue) Binary(op syntax.Token, y starlark.Value, side starlark.Side) (starlark.Value, error) {
+	x := time.Time(t)
+	switch y := y.(type) {
+	case durationValue:
+		switch {
+		case op == syntax.PLUS:
+			return timeValue(x.Add(time.Duration(y))), nil
+		case op == syntax.MINUS && side == starlark.Left:
+			return timeValue(x.Add(-time.Duration(y))), nil
+		}
+	case timeValue:
+		if op == syntax.MINUS {
+			if side == starlark.Left {
+				return durationValue(x.Sub(time.Time(y))), nil
+			}
+			return durationValue(time.Time(y).Sub(x)), nil
+		}
+	}
+	return nil, nil // unsupported
+}
+
+func compareInt64(op syntax.Token, x, y int64) (bool, error) {
+	switch op {
+	case syntax.EQL:
+		return x == y, nil
+	case syntax.NEQ:
+		return x != y, nil
+	case syntax.LT:
+		return x < y, nil
+	case syntax.LE:
+		return x <= y, nil
+	case syntax.GT:
+		return x > y, nil
+	case syntax.GE:
+		return x >= y, nil
+	}
+	return false, fmt.Errorf("unsupported comparison %s", op)
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestTimeModuleScript(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		expected map[string]string
+	}{
+		{
+			name: "duration arithmetic",
+			script: `
+window = time.parse_duration("1h30m") + 15 * time.minute
+minutes = window.minutes
+ratio = window // time.minute
+longer = window > time.hour
+`,
+			expected: map[string]string{"window": "1h45m0s", "minutes": "105", "ratio": "105", "longer": "True"},
+		},
+		{
+			name: "time arithmetic",
+			script: `
+start = time.parse_time("2020-10-15T07:00:00Z")
+end = time.time(year=2020, month=10, day=15, hour=9)
+elapsed = end - start
+since = (end - 30 * time.minute).format("15:04")
+unix = time.from_timestamp(start.unix).format()
+before = start < end
+`,
+			expected: map[string]string{"elapsed": "2h0m0s", "since": `"08:30"`, "unix": `"2020-10-15T07:00:00Z"`, "before": "True"},
+		},
+		{
+			name: "math and sets",
+			script: `
+hosts = sorted(set(["10.0.0.1", "10.0.0.2", "10.0.0.1"]))
+parts = math.ceil(2.1 * 5)
+root = math.sqrt(16)
+log = math.log(8, 2)
+`,
+			expected: map[string]string{"hosts": `["10.0.0.1", "10.0.0.2"]`, "parts": "11", "root": "4", "log": "3"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exe := New()
+			if err := exe.Exec("test.star", strings.NewReader(test.script)); err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(defaults.workdir)
+
+			for name, expected := range test.expected {
+				val, ok := exe.result[name]
+				if !ok {
+					t.Fatalf("script result missing %s", name)
+				}
+				if val.String() != expected {
+					t.Errorf("unexpected %s: got %s, want %s", name, val, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestTimeModuleErrors(t *testing.T) {
+	scripts := []string{
+		`time.parse_duration("10 minutes")`,
+		`time.parse_time("yesterday")`,
+		`time.time(year=2020, location="Nowhere/Invalid")`,
+		`time.hour / 0`,
+	}
+	for _, script := range scripts {
+		thread := &starlark.Thread{Name: "test"}
+		if _, err := starlark.ExecFile(thread, "test.star", script, starlark.StringDict{"time": newTimeModule()}); err == nil {
+			t.Errorf("expecting error for %s", script)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// timeSyncProbe prints the clock of POSIX hosts, first, then the state of its time synchronization service, as
+// name=value lines
+var timeSyncProbe = strings.Join([]string{
+	`echo "time=$(date +%s.%N)"`,
+	`if command -v chronyc >/dev/null 2>&1 && chronyc -n tracking >/dev/null 2>&1; then echo source=chrony; chronyc -n tracking | sed -n -e 's/^System time *: */system_time=/p' -e 's/^Leap status *: */leap=/p'`,
+	`elif command -v ntpq >/dev/null 2>&1 && ntpq -pn >/dev/null 2>&1; then echo source=ntp; ntpq -pn | awk '/^\*/ {print "peer_offset_ms=" $9}'`,
+	`elif command -v timedatectl >/dev/null 2>&1; then echo source=timesyncd; echo "synchronized=$(timedatectl show -p NTPSynchronized --value 2>/dev/null)"`,
+	`fi`,
+}, "\n")
+
+// clockSample is the clock of a compute resource compared to the clock of the crashd host
+type clockSample struct {
+	resource string
+	// offset is the distance of the clock of the resource outside of the window the probe ran in, according
+	// to the clock of the crashd host: a lower bound of the actual skew, 0 when the clock is within the window
+	offset time.Duration
+	// window is the duration of the probe, the uncertainty of the comparison
+	window time.Duration
+	// source is the time synchronization service of the resource (chrony, ntp, timesyncd), empty when unknown
+	source string
+	// synchronized is "yes" or "no" when reported by the source
+	synchronized string
+	// ntpOffset is the offset to NTP time reported by the source, when hasNTPOffset
+	ntpOffset    time.Duration
+	hasNTPOffset bool
+	skewed       bool
+	err          error
+}
+
+func (s clockSample) toStarlarkStruct() *starlarkstruct.Struct {
+	var ntpOffset, synchronized starlark.Value = starlark.None, starlark.None
+	if s.hasNTPOffset {
+		ntpOffset = starlark.Float(s.ntpOffset.Seconds())
+	}
+	if len(s.synchronized) > 0 {
+		synchronized = starlark.Bool(s.synchronized == "yes")
+	}
+	var errMsg string
+	if s.err != nil {
+		errMsg = s.err.Error()
+	}
+	return starlarkstruct.FromStringDict(
+		starlark.String("clock_sample"),
+		starlark.StringDict{
+			"resource":     starlark.String(s.resource),
+			"offset":       starlark.Float(s.offset.Seconds()),
+			"uncertainty":  starlark.Float(s.window.Seconds()),
+			"source":       starlark.String(s.source),
+			"synchronized": synchronized,
+			"ntp_offset":   ntpOffset,
+			"skewed":       starlark.Bool(s.skewed),
+			"error":        starlark.String(errMsg),
+		})
+}
+
+// timeSyncCheckFunc is a built-in starlark function that samples the clock of each compute resource: its time
+// is compared to the clock of the crashd host, and the state of its synchronization service (chrony, ntpd, or
+// systemd-timesyncd) is collected. A resource is flagged as skewed when its offset, or the offset reported by
+// its synchronization service, is beyond the threshold, or when the service reports it is not synchronized.
+// Starlark format: time_sync_check([resources=resources][, threshold="500ms"][, ssh_config=ssh_config()])
+func timeSyncCheckFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var resources *starlark.List
+	var thresholdVal, sshCfgVal starlark.Value
+	if err := starlark.UnpackArgs(
+		identifiers.timeSyncCheck, args, kwargs,
+		"resources?", &resources,
+		"threshold?", &thresholdVal,
+		"ssh_config?", &sshCfgVal,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.timeSyncCheck, err)
+	}
+
+	threshold, err := toDuration(thresholdVal, defaults.timeSyncThreshold)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: threshold: %s", identifiers.timeSyncCheck, err)
+	}
+	if resources == nil {
+		res, err := getResourcesFromThread(thread)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.timeSyncCheck, err)
+		}
+		resources = res
+	}
+	if sshCfgVal != nil {
+		sshCfg, err := getSSHConfig(thread, sshCfgVal)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.timeSyncCheck, err)
+		}
+		resources = withSSHConfig(resources, sshCfg)
+	}
+
+	var samples, skewed []starlark.Value
+	for i := 0; i < resources.Len(); i++ {
+		resource := starlark.NewList([]starlark.Value{resources.Index(i)})
+		start := time.Now()
+		results, err := execRun(command{line: timeSyncProbe, shell: shellSh}, resource)
+		end := time.Now()
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.timeSyncCheck, err)
+		}
+
+		for _, result := range results {
+			sample := clockSample{resource: result.resource, err: result.err}
+			if result.err == nil {
+				sample = parseClockSample(result.resource, result.stdout, start, end)
+			}
+			sample.skewed = sample.err == nil && isSkewed(sample, threshold)
+			if sample.skewed {
+				logrus.Warnf("%s: clock of %s is skewed: offset %s (±%s), %s offset %s, synchronized: %s",
+					identifiers.timeSyncCheck, sample.resource, sample.offset, sample.window, sample.source, sample.ntpOffset, sample.synchronized)
+				skewed = append(skewed, starlark.String(sample.resource))
+			}
+			if sample.err != nil {
+				logrus.Errorf("%s: %s: %s", identifiers.timeSyncCheck, sample.resource, sample.err)
+			}
+			samples = append(samples, sample.toStarlarkStruct())
+		}
+	}
+
+	return starlarkstruct.FromStringDict(
+		starlark.String(identifiers.timeSyncCheck),
+		starlark.StringDict{
+			"samples":   starlark.NewList(samples),
+			"skewed":    starlark.NewList(skewed),
+			"threshold": starlark.Float(threshold.Seconds()),
+		}), nil
+}
+
+// parseClockSample returns the sample printed by the time sync probe, run between start and end
+func parseClockSample(resource, output string, start, end time.Time) clockSample {
+	sample := clockSample{resource: resource, window: end.Sub(start)}
+	var remote time.Time
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(scanner.Text()), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name, value := parts[0], strings.TrimSpace(parts[1])
+		switch name {
+		case "time":
+			t, err := parseEpoch(value)
+			if err != nil {
+				sample.err = fmt.Errorf("invalid time %q: %s", value, err)
+				return sample
+			}
+			remote = t
+		case "source":
+			sample.source = value
+		case "system_time":
+			// chrony: 0.000012345 seconds fast of NTP time
+			if fields := strings.Fields(value); len(fields) >= 3 {
+				if seconds, err := strconv.ParseFloat(fields[0], 64); err == nil {
+					if fields[2] == "slow" {
+						seconds = -seconds
+					}
+					sample.ntpOffset, sample.hasNTPOffset = secondsToDuration(seconds), true
+				}
+			}
+		case "leap":
+			sample.synchronized = "yes"
+			if value == "Not synchronised" {
+				sample.synchronized = "no"
+			}
+		case "peer_offset_ms":
+			if ms, err := strconv.ParseFloat(value, 64); err == nil {
+				sample.ntpOffset, sample.hasNTPOffset = secondsToDuration(ms/1000), true
+				sample.synchronized = "yes"
+			}
+		case "synchronized":
+			if value == "yes" || value == "no" {
+				sample.synchronized = value
+			}
+		}
+	}
+	if remote.IsZero() {
+		sample.err = fmt.Errorf("time not found in probe output")
+		return sample
+	}
+	// ntpq only prints the system peer (*) when synchronized
+	if sample.source == "ntp" && !sample.hasNTPOffset {
+		sample.synchronized = "no"
+	}
+
+	switch {
+	case remote.Before(start):
+		sample.offset = remote.Sub(start)
+	case remote.After(end):
+		sample.offset = remote.Sub(end)
+	}
+	return sample
+}
+
+// parseEpoch parses seconds since the epoch, with an optional fraction (date +%s.%N prints a literal N
+// when nanoseconds are not supported)
+func parseEpoch(value string) (time.Time, error) {
+	parts := strings.SplitN(value, ".", 2)
+	seconds, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var nanos int64
+	if len(parts) == 2 {
+		if frac, err := strconv.ParseFloat("0."+parts[1], 64); err == nil {
+			nanos = int64(frac * 1e9)
+		}
+	}
+	return time.Unix(seconds, nanos), nil
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// isSkewed returns true when the clock of the sample is off by more than threshold, or not synchronized
+func isSkewed(sample clockSample, threshold time.Duration) bool {
+	abs := func(d time.Duration) time.Duration { return time.Duration(math.Abs(float64(d))) }
+	return abs(sample.offset) > threshold ||
+		(sample.hasNTPOffset && abs(sample.ntpOffset) > threshold) ||
+		sample.synchronized == "no"
+}
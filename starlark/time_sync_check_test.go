@@ -0,0 +1,106 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseClockSample(t *testing.T) {
+	start := time.Unix(1591000000, 0)
+	end := start.Add(200 * time.Millisecond)
+	tests := []struct {
+		name         string
+		output       string
+		offset       time.Duration
+		source       string
+		synchronized string
+		ntpOffset    time.Duration
+		skewed       bool
+	}{
+		{
+			name:         "chrony in sync",
+			output:       "time=1591000000.100000000\nsource=chrony\nsystem_time=0.000012000 seconds fast of NTP time\nleap=Normal\n",
+			source:       "chrony",
+			synchronized: "yes",
+			ntpOffset:    12 * time.Microsecond,
+		},
+		{
+			name:         "chrony not synchronized",
+			output:       "time=1591000000.100000000\nsource=chrony\nsystem_time=0.000000000 seconds slow of NTP time\nleap=Not synchronised\n",
+			source:       "chrony",
+			synchronized: "no",
+			skewed:       true,
+		},
+		{
+			name:   "clock ahead",
+			output: "time=1591000003.200000000\nsource=timesyncd\nsynchronized=\n",
+			offset: 3 * time.Second,
+			source: "timesyncd",
+			skewed: true,
+		},
+		{
+			name:   "clock behind without nanoseconds",
+			output: "time=1590999998.N\n",
+			offset: -2 * time.Second,
+			skewed: true,
+		},
+		{
+			name:         "ntp peer offset",
+			output:       "time=1591000000.050000000\nsource=ntp\npeer_offset_ms=-812.5\n",
+			source:       "ntp",
+			synchronized: "yes",
+			ntpOffset:    -812500 * time.Microsecond,
+			skewed:       true,
+		},
+		{
+			name:         "ntp without system peer",
+			output:       "time=1591000000.050000000\nsource=ntp\n",
+			source:       "ntp",
+			synchronized: "no",
+			skewed:       true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sample := parseClockSample("node-1", test.output, start, end)
+			if sample.err != nil {
+				t.Fatal(sample.err)
+			}
+			if sample.offset != test.offset || sample.source != test.source || sample.synchronized != test.synchronized || sample.ntpOffset != test.ntpOffset {
+				t.Errorf("unexpected sample: %+v", sample)
+			}
+			if sample.window != 200*time.Millisecond {
+				t.Errorf("unexpected window: %s", sample.window)
+			}
+			if skewed := isSkewed(sample, 500*time.Millisecond); skewed != test.skewed {
+				t.Errorf("expecting skewed %t", test.skewed)
+			}
+		})
+	}
+
+	if sample := parseClockSample("node-1", "source=chrony\n", start, end); sample.err == nil {
+		t.Error("expecting error without time")
+	}
+}
+
+func TestTimeSyncCheckLocal(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	// the local clock is the clock of the crashd host
+	script := `
+def check(sample):
+    if sample.error != "" or sample.offset != 0.0:
+        fail("unexpected sample: {}".format(sample))
+
+check(time_sync_check(resources=resources(provider=local_provider())).samples[0])
+`
+	if err := New().Exec("test.star", strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,365 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+// veleroReportFile is the report of the backup storage locations and failed operations saved by velero_capture
+const veleroReportFile = "report.txt"
+
+// veleroLocation is the state of a Velero BackupStorageLocation, and whether its object store is reachable
+type veleroLocation struct {
+	name          string
+	provider      string
+	bucket        string
+	phase         string
+	lastValidated string
+	message       string
+	// endpoint is the host:port of the object store, empty when it cannot be derived from the location
+	endpoint  string
+	probed    bool
+	reachErr  error
+	isDefault bool
+}
+
+func (l veleroLocation) reachable() string {
+	switch {
+	case !l.probed:
+		return "-"
+	case l.reachErr != nil:
+		return "no: " + l.reachErr.Error()
+	default:
+		return "yes"
+	}
+}
+
+func (l veleroLocation) toStarlarkStruct() *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(
+		starlark.String("backup_storage_location"),
+		starlark.StringDict{
+			"name":           starlark.String(l.name),
+			"provider":       starlark.String(l.provider),
+			"bucket":         starlark.String(l.bucket),
+			"phase":          starlark.String(l.phase),
+			"last_validated": starlark.String(l.lastValidated),
+			"message":        starlark.String(l.message),
+			"endpoint":       starlark.String(l.endpoint),
+			"reachable":      starlark.String(l.reachable()),
+			"default":        starlark.Bool(l.isDefault),
+		})
+}
+
+// veleroOperation is a Velero Backup or Restore that did not complete
+type veleroOperation struct {
+	kind          string
+	name          string
+	phase         string
+	errors        int64
+	warnings      int64
+	failureReason string
+	started       string
+	completed     string
+}
+
+func (o veleroOperation) toStarlarkStruct() *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(
+		starlark.String("velero_operation"),
+		starlark.StringDict{
+			"kind":           starlark.String(o.kind),
+			"name":           starlark.String(o.name),
+			"phase":          starlark.String(o.phase),
+			"errors":         starlark.MakeInt64(o.errors),
+			"warnings":       starlark.MakeInt64(o.warnings),
+			"failure_reason": starlark.String(o.failureReason),
+			"started":        starlark.String(o.started),
+			"completed":      starlark.String(o.completed),
+		})
+}
+
+// veleroCaptureFunc is a built-in starlark function that collects the state of Velero backups: the
+// Backups, Restores, Schedules, BackupStorageLocations, VolumeSnapshotLocations, and pod volume and
+// data mover objects of the Velero namespace, and the logs of the Velero server and node agent pods.
+// Objects and logs are saved under <workdir>/velero. The object store of each BackupStorageLocation is checked
+// for reachability from crashd (when probe is set), and the locations and the failed backups and restores
+// are reported in <workdir>/velero/report.txt.
+// Starlark format: velero_capture([namespace="velero"][, probe=True][, timeout="5s"][, workdir=path][, kube_config=kube_config()])
+func veleroCaptureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir, namespace string
+	probe := true
+	var timeoutVal starlark.Value
+	var kubeConfig *starlarkstruct.Struct
+
+	if err := starlark.UnpackArgs(
+		identifiers.veleroCapture, args, kwargs,
+		"namespace?", &namespace,
+		"probe?", &probe,
+		"timeout?", &timeoutVal,
+		"workdir?", &workdir,
+		"kube_config?", &kubeConfig,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.veleroCapture, err)
+	}
+
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+	if len(namespace) == 0 {
+		namespace = defaults.veleroNamespace
+	}
+	timeout, err := toDuration(timeoutVal, defaults.veleroProbeTimeout)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: timeout: %s", identifiers.veleroCapture, err)
+	}
+
+	if kubeConfig == nil {
+		kubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
+	}
+	path, err := getKubeConfigFromStruct(kubeConfig)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "failed to kubeconfig")
+	}
+	client, err := k8s.New(path)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "could not initialize search client")
+	}
+
+	searchResults, err := client.Search(k8s.SearchParams{
+		Groups:     []string{"velero.io"},
+		Kinds:      defaults.veleroKinds,
+		Namespaces: []string{namespace},
+	})
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.veleroCapture, err)
+	}
+
+	var captureErrs []string
+	resultDir := filepath.Join(workdir, "velero")
+	if len(searchResults) > 0 {
+		resultWriter, err := k8s.NewResultWriter(resultDir, "objects", getOutputFormat(thread), client.CoreRest)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.veleroCapture, err)
+		}
+		if err := resultWriter.Write(searchResults); err != nil {
+			captureErrs = append(captureErrs, fmt.Sprintf("objects: %s", err))
+		}
+	}
+
+	for _, label := range defaults.veleroPodLabels {
+		if _, _, err := write(resultDir, "logs", "", k8s.LayoutKubeCapture, client, nil, 0, k8s.SearchParams{
+			Namespaces: []string{namespace},
+			Labels:     []string{label},
+		}); err != nil {
+			captureErrs = append(captureErrs, fmt.Sprintf("logs (%s): %s", label, err))
+		}
+	}
+
+	locations := veleroLocations(searchResults)
+	if len(locations) == 0 {
+		logrus.Warnf("%s: no BackupStorageLocation found in namespace %s", identifiers.veleroCapture, namespace)
+	}
+	for i := range locations {
+		l := &locations[i]
+		if probe && len(l.endpoint) > 0 {
+			l.probed = true
+			if l.reachErr = probeEndpoint(l.endpoint, timeout); l.reachErr != nil {
+				logrus.Warnf("%s: object store %s of location %s unreachable: %s", identifiers.veleroCapture, l.endpoint, l.name, l.reachErr)
+			}
+		}
+		if l.phase != "Available" {
+			logrus.Warnf("%s: location %s is %s %s", identifiers.veleroCapture, l.name, l.phase, l.message)
+		}
+	}
+	failed := veleroFailedOperations(searchResults)
+
+	reportFile := filepath.Join(resultDir, veleroReportFile)
+	if err := writeVeleroReport(reportFile, locations, failed); err != nil {
+		captureErrs = append(captureErrs, fmt.Sprintf("report: %s", err))
+	}
+
+	var locationVals, failedVals []starlark.Value
+	for _, l := range locations {
+		locationVals = append(locationVals, l.toStarlarkStruct())
+	}
+	for _, o := range failed {
+		failedVals = append(failedVals, o.toStarlarkStruct())
+	}
+	var captureErr string
+	if len(captureErrs) > 0 {
+		captureErr = strings.Join(captureErrs, "; ")
+		logrus.Errorf("%s: %s", identifiers.veleroCapture, captureErr)
+	}
+
+	return starlarkstruct.FromStringDict(
+		starlark.String(identifiers.veleroCapture),
+		starlark.StringDict{
+			"file":      starlark.String(resultDir),
+			"report":    starlark.String(reportFile),
+			"locations": starlark.NewList(locationVals),
+			"failed":    starlark.NewList(failedVals),
+			"error":     starlark.String(captureErr),
+		}), nil
+}
+
+// veleroLocations returns the BackupStorageLocations of the search results, sorted by name
+func veleroLocations(results []k8s.SearchResult) []veleroLocation {
+	var locations []veleroLocation
+	for _, result := range results {
+		if result.List == nil || result.ResourceKind != "BackupStorageLocation" {
+			continue
+		}
+		for _, item := range result.List.Items {
+			l := veleroLocation{name: item.GetName()}
+			l.provider, _, _ = unstructured.NestedString(item.Object, "spec", "provider")
+			l.bucket, _, _ = unstructured.NestedString(item.Object, "spec", "objectStorage", "bucket")
+			l.isDefault, _, _ = unstructured.NestedBool(item.Object, "spec", "default")
+			l.phase, _, _ = unstructured.NestedString(item.Object, "status", "phase")
+			l.lastValidated, _, _ = unstructured.NestedString(item.Object, "status", "lastValidationTime")
+			l.message, _, _ = unstructured.NestedString(item.Object, "status", "message")
+			config, _, _ := unstructured.NestedStringMap(item.Object, "spec", "config")
+			l.endpoint = objectStoreEndpoint(l.provider, config)
+			locations = append(locations, l)
+		}
+	}
+	sort.Slice(locations, func(i, j int) bool { return locations[i].name < locations[j].name })
+	return locations
+}
+
+// objectStoreEndpoint returns the host:port of the object store of a location, from its s3Url, or from
+// the public endpoint of its provider, or an empty string when it is unknown
+func objectStoreEndpoint(provider string, config map[string]string) string {
+	if s3URL := config["s3Url"]; len(s3URL) > 0 {
+		u, err := url.Parse(s3URL)
+		if err != nil || len(u.Host) == 0 {
+			return ""
+		}
+		if len(u.Port()) > 0 {
+			return u.Host
+		}
+		if u.Scheme == "http" {
+			return net.JoinHostPort(u.Hostname(), "80")
+		}
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+
+	switch strings.TrimPrefix(provider, "velero.io/") {
+	case "aws":
+		if region := config["region"]; len(region) > 0 {
+			return fmt.Sprintf("s3.%s.amazonaws.com:443", region)
+		}
+	case "azure":
+		if account := config["storageAccount"]; len(account) > 0 {
+			return fmt.Sprintf("%s.blob.core.windows.net:443", account)
+		}
+	case "gcp":
+		return "storage.googleapis.com:443"
+	}
+	return ""
+}
+
+// probeEndpoint returns an error when a TCP connection to endpoint cannot be opened within timeout
+func probeEndpoint(endpoint string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", endpoint, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// veleroFailedOperations returns the Backups and Restores of the search results in a failed phase
+// (i.e. Failed, PartiallyFailed, FailedValidation), sorted by kind and name
+func veleroFailedOperations(results []k8s.SearchResult) []veleroOperation {
+	var ops []veleroOperation
+	for _, result := range results {
+		if result.List == nil || (result.ResourceKind != "Backup" && result.ResourceKind != "Restore") {
+			continue
+		}
+		for _, item := range result.List.Items {
+			o := veleroOperation{kind: result.ResourceKind, name: item.GetName()}
+			o.phase, _, _ = unstructured.NestedString(item.Object, "status", "phase")
+			if !strings.Contains(o.phase, "Failed") {
+				continue
+			}
+			o.errors, _, _ = unstructured.NestedInt64(item.Object, "status", "errors")
+			o.warnings, _, _ = unstructured.NestedInt64(item.Object, "status", "warnings")
+			o.failureReason, _, _ = unstructured.NestedString(item.Object, "status", "failureReason")
+			if len(o.failureReason) == 0 {
+				validationErrors, _, _ := unstructured.NestedStringSlice(item.Object, "status", "validationErrors")
+				o.failureReason = strings.Join(validationErrors, "; ")
+			}
+			o.started, _, _ = unstructured.NestedString(item.Object, "status", "startTimestamp")
+			o.completed, _, _ = unstructured.NestedString(item.Object, "status", "completionTimestamp")
+			ops = append(ops, o)
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].kind != ops[j].kind {
+			return ops[i].kind < ops[j].kind
+		}
+		return ops[i].name < ops[j].name
+	})
+	return ops
+}
+
+// writeVeleroReport saves the report of the locations and failed operations at path
+func writeVeleroReport(path string, locations []veleroLocation, failed []veleroOperation) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0744); err != nil && !os.IsExist(err) {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return printVeleroReport(file, locations, failed)
+}
+
+func printVeleroReport(w io.Writer, locations []veleroLocation, failed []veleroOperation) error {
+	dash := func(s string) string {
+		if len(s) == 0 {
+			return "-"
+		}
+		return s
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "BACKUP STORAGE LOCATIONS")
+	fmt.Fprintln(tw, "NAME\tPROVIDER\tBUCKET\tPHASE\tLAST VALIDATED\tENDPOINT\tREACHABLE\tMESSAGE")
+	for _, l := range locations {
+		name := l.name
+		if l.isDefault {
+			name += " (default)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", name, dash(l.provider), dash(l.bucket), dash(l.phase), dash(l.lastValidated), dash(l.endpoint), l.reachable(), l.message)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "FAILED BACKUPS AND RESTORES")
+	fmt.Fprintln(tw, "KIND\tNAME\tPHASE\tERRORS\tWARNINGS\tSTARTED\tCOMPLETED\tFAILURE")
+	for _, o := range failed {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\n", o.kind, o.name, o.phase, o.errors, o.warnings, dash(o.started), dash(o.completed), o.failureReason)
+	}
+	return tw.Flush()
+}
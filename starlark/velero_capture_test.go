@@ -0,0 +1,134 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+func TestVeleroReport(t *testing.T) {
+	object := func(name string, fields map[string]interface{}) unstructured.Unstructured {
+		obj := unstructured.Unstructured{Object: fields}
+		obj.SetNamespace("velero")
+		obj.SetName(name)
+		return obj
+	}
+	results := []k8s.SearchResult{
+		{
+			ResourceKind: "BackupStorageLocation",
+			List: &unstructured.UnstructuredList{Items: []unstructured.Unstructured{
+				object("minio", map[string]interface{}{
+					"spec": map[string]interface{}{
+						"provider":      "aws",
+						"objectStorage": map[string]interface{}{"bucket": "backups"},
+						"config":        map[string]interface{}{"region": "minio", "s3Url": "http://minio.velero.svc:9000"},
+					},
+					"status": map[string]interface{}{"phase": "Unavailable", "message": "BackupStorageLocation is unavailable"},
+				}),
+				object("default", map[string]interface{}{
+					"spec": map[string]interface{}{
+						"provider":      "velero.io/aws",
+						"default":       true,
+						"objectStorage": map[string]interface{}{"bucket": "prod"},
+						"config":        map[string]interface{}{"region": "us-west-2"},
+					},
+					"status": map[string]interface{}{"phase": "Available", "lastValidationTime": "2020-06-01T10:00:00Z"},
+				}),
+			}},
+		},
+		{
+			ResourceKind: "Backup",
+			List: &unstructured.UnstructuredList{Items: []unstructured.Unstructured{
+				object("daily-1", map[string]interface{}{"status": map[string]interface{}{"phase": "Completed"}}),
+				object("daily-2", map[string]interface{}{"status": map[string]interface{}{"phase": "PartiallyFailed", "errors": int64(2), "warnings": int64(1)}}),
+				object("bad", map[string]interface{}{"status": map[string]interface{}{"phase": "FailedValidation", "validationErrors": []interface{}{"invalid location"}}}),
+			}},
+		},
+		{
+			ResourceKind: "Restore",
+			List: &unstructured.UnstructuredList{Items: []unstructured.Unstructured{
+				object("restore-1", map[string]interface{}{"status": map[string]interface{}{"phase": "Failed", "failureReason": "timeout"}}),
+			}},
+		},
+	}
+
+	locations := veleroLocations(results)
+	if len(locations) != 2 {
+		t.Fatalf("expecting 2 locations, got %d", len(locations))
+	}
+	if l := locations[0]; l.name != "default" || !l.isDefault || l.bucket != "prod" || l.endpoint != "s3.us-west-2.amazonaws.com:443" {
+		t.Errorf("unexpected location: %+v", l)
+	}
+	if l := locations[1]; l.endpoint != "minio.velero.svc:9000" || l.phase != "Unavailable" {
+		t.Errorf("unexpected location: %+v", l)
+	}
+
+	failed := veleroFailedOperations(results)
+	if len(failed) != 3 {
+		t.Fatalf("expecting 3 failed operations, got %d", len(failed))
+	}
+	if o := failed[0]; o.kind != "Backup" || o.name != "bad" || o.failureReason != "invalid location" {
+		t.Errorf("unexpected operation: %+v", o)
+	}
+	if o := failed[1]; o.name != "daily-2" || o.errors != 2 || o.warnings != 1 {
+		t.Errorf("unexpected operation: %+v", o)
+	}
+	if o := failed[2]; o.kind != "Restore" || o.failureReason != "timeout" {
+		t.Errorf("unexpected operation: %+v", o)
+	}
+
+	var out bytes.Buffer
+	if err := printVeleroReport(&out, locations, failed); err != nil {
+		t.Fatal(err)
+	}
+	report := out.String()
+	for _, expected := range []string{"default (default)", "BackupStorageLocation is unavailable", "daily-2", "restore-1"} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("expecting %q in report:\n%s", expected, report)
+		}
+	}
+}
+
+func TestObjectStoreEndpoint(t *testing.T) {
+	tests := []struct {
+		provider string
+		config   map[string]string
+		expected string
+	}{
+		{provider: "aws", config: map[string]string{"s3Url": "https://s3.example.com"}, expected: "s3.example.com:443"},
+		{provider: "aws", config: map[string]string{"s3Url": "http://10.0.0.1"}, expected: "10.0.0.1:80"},
+		{provider: "aws", config: map[string]string{"region": "eu-west-1"}, expected: "s3.eu-west-1.amazonaws.com:443"},
+		{provider: "velero.io/azure", config: map[string]string{"storageAccount": "crashd"}, expected: "crashd.blob.core.windows.net:443"},
+		{provider: "gcp", expected: "storage.googleapis.com:443"},
+		{provider: "example.com/custom", expected: ""},
+	}
+	for _, test := range tests {
+		if endpoint := objectStoreEndpoint(test.provider, test.config); endpoint != test.expected {
+			t.Errorf("%s %v: expecting endpoint %q, got %q", test.provider, test.config, test.expected, endpoint)
+		}
+	}
+}
+
+func TestProbeEndpoint(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	if err := probeEndpoint(addr, time.Second); err != nil {
+		t.Errorf("expecting %s to be reachable: %s", addr, err)
+	}
+	listener.Close()
+	if err := probeEndpoint(addr, time.Second); err == nil {
+		t.Errorf("expecting %s to be unreachable", addr)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"k8s.io/apimachinery/pkg/util/version"
+
+	"github.com/vmware-tanzu/crash-diagnostics/buildinfo"
+)
+
+// crashdVersionFunc is a built-in starlark function that returns the version of the running crashd binary
+// Starlark format: crashd_version()
+func crashdVersionFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(identifiers.crashdVersion, args, kwargs); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.crashdVersion, err)
+	}
+	return starlark.String(buildinfo.Version), nil
+}
+
+// requireVersionFunc is a built-in starlark function that aborts the script when the version of the
+// running crashd binary does not satisfy the constraint. A constraint is a comma-separated list of
+// comparisons (i.e. ">=0.4.0, <1.0.0") that must all be satisfied.
+// Starlark format: require_version(<constraint>)
+func requireVersionFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var constraint string
+	if err := starlark.UnpackArgs(
+		identifiers.requireVersion, args, kwargs,
+		"constraint", &constraint,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.requireVersion, err)
+	}
+
+	current, err := parseVersion(buildinfo.Version)
+	if err != nil {
+		logrus.Warnf("%s: unable to check crashd version %q: %s", identifiers.requireVersion, buildinfo.Version, err)
+		return starlark.None, nil
+	}
+
+	ok, err := versionSatisfies(current, constraint)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.requireVersion, err)
+	}
+	if !ok {
+		return starlark.None, fmt.Errorf("%s: script requires crashd %s, but running version is %s: upgrade crashd to run this script",
+			identifiers.requireVersion, constraint, buildinfo.Version)
+	}
+	return starlark.None, nil
+}
+
+// versionSatisfies returns true when ver satisfies all the comparisons of the constraint.
+// Supported operators are >=, >, <=, <, ==, and =; a version without operator must match exactly.
+func versionSatisfies(ver *version.Version, constraint string) (bool, error) {
+	if len(strings.TrimSpace(constraint)) == 0 {
+		return false, fmt.Errorf("empty version constraint")
+	}
+
+	for _, cond := range strings.Split(constraint, ",") {
+		cond = strings.TrimSpace(cond)
+		op := strings.TrimRight(cond, "v0123456789.-+abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+		other, err := parseVersion(strings.TrimSpace(strings.TrimPrefix(cond, op)))
+		if err != nil {
+			return false, fmt.Errorf("invalid version constraint %q: %s", cond, err)
+		}
+		cmp := 0
+		switch {
+		case ver.LessThan(other):
+			cmp = -1
+		case other.LessThan(ver):
+			cmp = 1
+		}
+
+		var ok bool
+		switch strings.TrimSpace(op) {
+		case ">=":
+			ok = cmp >= 0
+		case ">":
+			ok = cmp > 0
+		case "<=":
+			ok = cmp <= 0
+		case "<":
+			ok = cmp < 0
+		case "==", "=", "":
+			ok = cmp == 0
+		default:
+			return false, fmt.Errorf("invalid version constraint %q: unsupported operator %q", cond, op)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// parseVersion parses a semantic version (i.e. v0.4.0-rc.1) or, failing that,
+// a generic version (i.e. 0.4)
+func parseVersion(str string) (*version.Version, error) {
+	if ver, err := version.ParseSemantic(str); err == nil {
+		return ver, nil
+	}
+	return version.ParseGeneric(str)
+}
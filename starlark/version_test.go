@@ -0,0 +1,78 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+
+	"github.com/vmware-tanzu/crash-diagnostics/buildinfo"
+)
+
+func TestVersionSatisfies(t *testing.T) {
+	tests := []struct {
+		version    string
+		constraint string
+		expected   bool
+		shouldErr  bool
+	}{
+		{version: "v0.4.0", constraint: ">=0.4.0", expected: true},
+		{version: "v0.4.1", constraint: ">= 0.4", expected: true},
+		{version: "v0.3.2", constraint: ">=0.4.0", expected: false},
+		{version: "v0.4.0-alpha.1", constraint: ">=0.4.0", expected: false},
+		{version: "v0.4.0", constraint: ">=0.3.0, <0.4.0", expected: false},
+		{version: "v0.3.5", constraint: ">0.3.0,<=0.4.0", expected: true},
+		{version: "v0.3.5", constraint: "0.3.5", expected: true},
+		{version: "v0.3.5", constraint: "~0.3", shouldErr: true},
+		{version: "v0.3.5", constraint: ">=abc", shouldErr: true},
+		{version: "v0.3.5", constraint: "", shouldErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.version+" "+test.constraint, func(t *testing.T) {
+			ver, err := parseVersion(test.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ok, err := versionSatisfies(ver, test.constraint)
+			if err != nil {
+				if !test.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if test.shouldErr {
+				t.Fatal("expecting error")
+			}
+			if ok != test.expected {
+				t.Errorf("expecting %t, got %t", test.expected, ok)
+			}
+		})
+	}
+}
+
+func TestRequireVersionScript(t *testing.T) {
+	saved := buildinfo.Version
+	buildinfo.Version = "v0.4.2"
+	defer func() { buildinfo.Version = saved }()
+
+	exe := New()
+	script := `
+require_version(">=0.4.0")
+ver = crashd_version()
+`
+	if err := exe.Exec("test.star", strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+	if ver := exe.result["ver"].(starlark.String); string(ver) != "v0.4.2" {
+		t.Errorf("unexpected version: %s", ver)
+	}
+
+	err := New().Exec("test.star", strings.NewReader(`require_version(">=0.5.0")`))
+	if err == nil || !strings.Contains(err.Error(), "requires crashd >=0.5.0") {
+		t.Errorf("expecting version requirement error, got %v", err)
+	}
+}
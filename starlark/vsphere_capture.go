@@ -0,0 +1,107 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/vsphere"
+)
+
+// vsphereCaptureFunc is a built-in starlark function that collects the hypervisor-side state of
+// vSphere virtual machines (i.e. the VMs of CAPV nodes) with govc: the vm.info document and power
+// state, the recent vCenter events and tasks, and a console screenshot of each VM. Files are saved
+// under <workdir>/vsphere/<vm>. The vCenter is selected with url, username, and password, or with
+// the GOVC_* environment variables.
+// Starlark format: vsphere_capture(vms=["name"][, url=url][, username=name][, password=secret][, insecure=False][, max_events=50][, screenshots=True][, workdir=path])
+func vsphereCaptureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir string
+	var vms *starlark.List
+	var cfg vsphere.Config
+	maxEvents := defaults.vsphereMaxEvents
+	screenshots := true
+
+	if err := starlark.UnpackArgs(
+		identifiers.vsphereCapture, args, kwargs,
+		"vms", &vms,
+		"url?", &cfg.URL,
+		"username?", &cfg.Username,
+		"password?", &cfg.Password,
+		"insecure?", &cfg.Insecure,
+		"max_events?", &maxEvents,
+		"screenshots?", &screenshots,
+		"workdir?", &workdir,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.vsphereCapture, err)
+	}
+
+	names := toSlice(vms)
+	if len(names) == 0 {
+		return starlark.None, fmt.Errorf("%s: vms required", identifiers.vsphereCapture)
+	}
+	for _, name := range names {
+		if err := vsphere.ValidateName(name); err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.vsphereCapture, err)
+		}
+	}
+	if maxEvents <= 0 {
+		return starlark.None, fmt.Errorf("%s: max_events must be positive", identifiers.vsphereCapture)
+	}
+	if err := cfg.Validate(); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.vsphereCapture, err)
+	}
+
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+
+	var results []starlark.Value
+	for _, name := range names {
+		results = append(results, captureVSphereVM(cfg, filepath.Join(workdir, "vsphere", sanitizeStr(name)), name, maxEvents, screenshots))
+	}
+	return starlark.NewList(results), nil
+}
+
+// captureVSphereVM saves the state of the VM named name in dir
+func captureVSphereVM(cfg vsphere.Config, dir, name string, maxEvents int, screenshot bool) *starlarkstruct.Struct {
+	var path, powerState string
+	c, ok := newInfraCapture(identifiers.vsphereCapture, "vm", name, dir)
+	result := func() *starlarkstruct.Struct {
+		return c.result("vsphere_vm", starlark.StringDict{
+			"path":        starlark.String(path),
+			"power_state": starlark.String(powerState),
+		})
+	}
+	if !ok {
+		return result()
+	}
+	path, err := cfg.FindVM(name)
+	if err != nil {
+		c.fail("lookup", err)
+		return result()
+	}
+
+	if info, ok := c.save("info", "info.json", func() (string, error) { return cfg.Info(path) }); ok {
+		if powerState, err = vsphere.PowerState(info); err != nil {
+			c.fail("power state", err)
+		}
+	}
+	c.save("events", "events.txt", func() (string, error) { return cfg.Events(path, maxEvents) })
+	c.save("tasks", "tasks.txt", func() (string, error) { return cfg.Tasks(path, maxEvents) })
+	if screenshot {
+		if err := cfg.Screenshot(path, c.path("console.png")); err != nil {
+			c.fail("screenshot", err)
+		}
+	}
+	return result()
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+// waitForFunc is a built-in starlark function that polls until a condition is met or the
+// timeout expires. The condition is either a predicate function (fn), called without arguments
+// until it returns a true value, or the condition of the Kubernetes objects matching kind,
+// name, namespace, and labels (i.e. Ready, Available, Running, or Deleted).
+// A timeout is not an error: the returned struct reports whether the condition was met.
+// Starlark format: wait_for([fn=func][, kind=kind, name=name, namespace=ns, labels=labels, condition="Ready", kube_config=cfg][, timeout="5m"][, interval="10s"])
+func waitForFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var fn starlark.Callable
+	var kind, name, namespace, labels string
+	var timeoutVal, intervalVal starlark.Value
+	var kubeConfig *starlarkstruct.Struct
+	condition := "Ready"
+
+	if err := starlark.UnpackArgs(
+		identifiers.waitFor, args, kwargs,
+		"fn?", &fn,
+		"kind?", &kind,
+		"name?", &name,
+		"namespace?", &namespace,
+		"labels?", &labels,
+		"condition?", &condition,
+		"timeout?", &timeoutVal,
+		"interval?", &intervalVal,
+		"kube_config?", &kubeConfig,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.waitFor, err)
+	}
+
+	timeout, err := toDuration(timeoutVal, defaults.waitTimeout)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: timeout: %s", identifiers.waitFor, err)
+	}
+	interval, err := toDuration(intervalVal, defaults.waitInterval)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: interval: %s", identifiers.waitFor, err)
+	}
+	if timeout <= 0 || interval <= 0 {
+		return starlark.None, fmt.Errorf("%s: timeout and interval must be positive", identifiers.waitFor)
+	}
+
+	var check func() (bool, string, error)
+	switch {
+	case fn != nil && len(kind) > 0:
+		return starlark.None, fmt.Errorf("%s: specify only one of fn or kind", identifiers.waitFor)
+	case fn != nil:
+		check = func() (bool, string, error) {
+			val, err := starlark.Call(thread, fn, nil, nil)
+			if err != nil {
+				return false, "", err
+			}
+			return bool(val.Truth()), "", nil
+		}
+	case len(kind) > 0:
+		if kubeConfig == nil {
+			kubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
+		}
+		path, err := getKubeConfigFromStruct(kubeConfig)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: failed to get kubeconfig: %s", identifiers.waitFor, err)
+		}
+		client, err := k8s.New(path)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: could not initialize client: %s", identifiers.waitFor, err)
+		}
+		params := k8s.SearchParams{Kinds: []string{kind}}
+		if len(name) > 0 {
+			params.Names = []string{name}
+		}
+		if len(namespace) > 0 {
+			params.Namespaces = []string{namespace}
+		}
+		if len(labels) > 0 {
+			params.Labels = []string{labels}
+		}
+		check = func() (bool, string, error) {
+			results, err := client.Search(params)
+			if err != nil {
+				// the API server may be unavailable while the cluster recovers
+				return false, err.Error(), nil
+			}
+			met, pending := k8s.ResultsMeetCondition(results, condition)
+			if len(pending) > 0 {
+				return met, fmt.Sprintf("waiting for %s: %s", condition, strings.Join(pending, ", ")), nil
+			}
+			return met, "", nil
+		}
+	default:
+		return starlark.None, fmt.Errorf("%s: fn or kind is required", identifiers.waitFor)
+	}
+
+	result, err := pollCondition(thread, check, timeout, interval)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.waitFor, err)
+	}
+	if !result.met {
+		logrus.Warnf("%s: condition not met after %s: %s", identifiers.waitFor, timeout, result.status)
+	}
+	return result.toStarlarkStruct(), nil
+}
+
+// waitResult is the outcome of a wait_for call
+type waitResult struct {
+	met      bool
+	attempts int
+	elapsed  time.Duration
+	status   string
+}
+
+func (r waitResult) toStarlarkStruct() *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(
+		starlark.String(identifiers.waitFor),
+		starlark.StringDict{
+			"met":      starlark.Bool(r.met),
+			"attempts": starlark.MakeInt(r.attempts),
+			"elapsed":  durationValue(r.elapsed),
+			"status":   starlark.String(r.status),
+		},
+	)
+}
+
+// pollCondition calls check every interval until it reports the condition as met or the
+// timeout expires. Polling stops early when the context of the run is done.
+func pollCondition(thread *starlark.Thread, check func() (bool, string, error), timeout, interval time.Duration) (waitResult, error) {
+	ctx := getContext(thread)
+	start := time.Now()
+	deadline := start.Add(timeout)
+
+	var result waitResult
+	for {
+		met, status, err := check()
+		result.attempts++
+		result.elapsed = time.Since(start)
+		if err != nil {
+			return result, err
+		}
+		result.met, result.status = met, status
+		if met || !time.Now().Add(interval).Before(deadline) {
+			return result, nil
+		}
+
+		logrus.Debugf("%s: condition not met (attempt %d): %s", identifiers.waitFor, result.attempts, status)
+		select {
+		case <-ctx.Done():
+			return result, fmt.Errorf("run stopped: %s", ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
+
+// toDuration converts a duration string (i.e. "5m"), a time.duration value, or
+// a number of seconds to a duration. It returns def when val is not set.
+func toDuration(val starlark.Value, def time.Duration) (time.Duration, error) {
+	switch v := val.(type) {
+	case nil, starlark.NoneType:
+		return def, nil
+	case starlark.String:
+		return time.ParseDuration(string(v))
+	case durationValue:
+		return time.Duration(v), nil
+	case starlark.Int:
+		secs, ok := v.Int64()
+		if !ok {
+			return 0, fmt.Errorf("invalid duration %s", v)
+		}
+		return time.Duration(secs) * time.Second, nil
+	default:
+		return 0, fmt.Errorf("expecting string, duration, or int, got %s", val.Type())
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+func TestWaitForFunc(t *testing.T) {
+	tests := []struct {
+		name       string
+		script     string
+		met        bool
+		attempts   int
+		shouldFail bool
+	}{
+		{
+			name: "predicate met after polling",
+			script: `
+state = {"calls": 0}
+def ready():
+    state["calls"] += 1
+    return state["calls"] >= 3
+
+result = wait_for(fn=ready, timeout="1s", interval="10ms")`,
+			met:      true,
+			attempts: 3,
+		},
+		{
+			name: "timeout",
+			script: `
+def never():
+    return False
+
+result = wait_for(never, timeout="50ms", interval="20ms")`,
+			met: false,
+		},
+		{
+			name:       "missing condition",
+			script:     `result = wait_for(timeout="1s")`,
+			shouldFail: true,
+		},
+		{
+			name: "fn and kind",
+			script: `
+def ready():
+    return True
+
+result = wait_for(fn=ready, kind="pod")`,
+			shouldFail: true,
+		},
+		{
+			name: "predicate error",
+			script: `
+def broken():
+    return 1 // 0
+
+result = wait_for(fn=broken, timeout="1s", interval="10ms")`,
+			shouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exe := New()
+			err := exe.Exec("test.star", strings.NewReader(test.script))
+			if err != nil && !test.shouldFail {
+				t.Fatal(err)
+			}
+			if err == nil && test.shouldFail {
+				t.Fatal("expecting failure, but got none")
+			}
+			if test.shouldFail {
+				return
+			}
+
+			result, ok := exe.result["result"].(*starlarkstruct.Struct)
+			if !ok {
+				t.Fatalf("unexpected result type %T", exe.result["result"])
+			}
+			if met, _ := result.Attr("met"); met != starlark.Bool(test.met) {
+				t.Errorf("expecting met=%t, got %s", test.met, met)
+			}
+			if attempts, _ := result.Attr("attempts"); test.attempts > 0 && attempts.String() != starlark.MakeInt(test.attempts).String() {
+				t.Errorf("expecting %d attempts, got %s", test.attempts, attempts)
+			}
+		})
+	}
+}
+
+func TestToDuration(t *testing.T) {
+	tests := []struct {
+		val        starlark.Value
+		expected   time.Duration
+		shouldFail bool
+	}{
+		{val: nil, expected: time.Minute},
+		{val: starlark.String("5m"), expected: 5 * time.Minute},
+		{val: starlark.MakeInt(30), expected: 30 * time.Second},
+		{val: durationValue(time.Second), expected: time.Second},
+		{val: starlark.String("soon"), shouldFail: true},
+		{val: starlark.True, shouldFail: true},
+	}
+	for _, test := range tests {
+		d, err := toDuration(test.val, time.Minute)
+		if err != nil && !test.shouldFail {
+			t.Fatal(err)
+		}
+		if err == nil && test.shouldFail {
+			t.Fatalf("expecting failure for %v, but got none", test.val)
+		}
+		if d != test.expected {
+			t.Errorf("expecting %s for %v, got %s", test.expected, test.val, d)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/vmware-tanzu/crash-diagnostics/k8s"
+)
+
+// webhookReportFile is the health report of the admission webhooks saved by webhook_capture
+const webhookReportFile = "report.txt"
+
+// webhookCaptureFunc is a built-in starlark function that saves the validating and mutating webhook
+// configurations under <workdir>/webhooks, checks each webhook (ready endpoints of its service,
+// expiration of its CA bundle, and, when probe is set, whether it answers within timeout), saves the
+// health report in <workdir>/webhooks/report.txt and, when logs is set, the pods, and their logs,
+// backing the webhook services.
+// Starlark format: webhook_capture([probe=True][, timeout="5s"][, logs=True][, workdir=path][, kube_config=kube_config()])
+func webhookCaptureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir string
+	var timeoutVal starlark.Value
+	probe, logs := true, true
+	var kubeConfig *starlarkstruct.Struct
+
+	if err := starlark.UnpackArgs(
+		identifiers.webhookCapture, args, kwargs,
+		"probe?", &probe,
+		"timeout?", &timeoutVal,
+		"logs?", &logs,
+		"workdir?", &workdir,
+		"kube_config?", &kubeConfig,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.webhookCapture, err)
+	}
+
+	timeout, err := toDuration(timeoutVal, defaults.webhookProbeTimeout)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: timeout: %s", identifiers.webhookCapture, err)
+	}
+
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+
+	if kubeConfig == nil {
+		kubeConfig = thread.Local(identifiers.kubeCfg).(*starlarkstruct.Struct)
+	}
+	path, err := getKubeConfigFromStruct(kubeConfig)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "failed to kubeconfig")
+	}
+	client, err := k8s.New(path)
+	if err != nil {
+		return starlark.None, errors.Wrap(err, "could not initialize search client")
+	}
+
+	resultDir := filepath.Join(workdir, k8s.WebhooksDirname)
+	statuses, err := writeWebhooks(client, resultDir, probe, logs, timeout)
+
+	var failing []starlark.Value
+	for _, status := range statuses {
+		if len(status.Problems) > 0 {
+			failing = append(failing, starlark.String(fmt.Sprintf("%s/%s", status.Configuration, status.Webhook)))
+		}
+	}
+
+	return starlarkstruct.FromStringDict(
+		starlark.String(identifiers.webhookCapture),
+		starlark.StringDict{
+			"file":    starlark.String(resultDir),
+			"failing": starlark.NewList(failing),
+			"error": func() starlark.String {
+				if err != nil {
+					return starlark.String(err.Error())
+				}
+				return ""
+			}(),
+		}), nil
+}
+
+// writeWebhooks saves the webhook configurations, the health report of the webhooks, and the pods
+// backing the webhook services, with their logs when logs is set, under resultDir
+func writeWebhooks(client *k8s.Client, resultDir string, probe, logs bool, timeout time.Duration) ([]k8s.WebhookStatus, error) {
+	configurations, err := client.GetWebhookConfigurations()
+	if err != nil {
+		return nil, err
+	}
+	objWriter, err := k8s.NewResultWriter(resultDir, "objects", "", client.CoreRest)
+	if err != nil {
+		return nil, err
+	}
+	if err := objWriter.Write(configurations); err != nil {
+		return nil, err
+	}
+
+	statuses, err := client.CheckWebhooks(configurations, probe, timeout)
+	if err != nil {
+		return nil, err
+	}
+	report, err := os.Create(filepath.Join(resultDir, webhookReportFile))
+	if err != nil {
+		return nil, err
+	}
+	err = k8s.WriteWebhookReport(report, statuses)
+	report.Close()
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range statuses {
+		if len(status.Problems) > 0 {
+			logrus.Warnf("%s: %s/%s (failure policy %s) is failing: %v", identifiers.webhookCapture, status.Configuration, status.Webhook, status.FailurePolicy, status.Problems)
+		}
+	}
+
+	if !logs {
+		return statuses, nil
+	}
+	pods, err := client.WebhookPods(statuses)
+	if err != nil || len(pods) == 0 {
+		return statuses, err
+	}
+	logWriter, err := k8s.NewResultWriter(resultDir, "all", "", client.CoreRest)
+	if err != nil {
+		return statuses, err
+	}
+	return statuses, logWriter.Write(pods)
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("webhook_capture", func() {
+
+	var (
+		executor *Executor
+		err      error
+	)
+
+	execSetup := func(crashdScript string) {
+		executor = New()
+		err = executor.Exec("test.webhook.capture", strings.NewReader(crashdScript))
+	}
+
+	It("saves the webhook configurations and the webhook report", func() {
+		crashdScript := fmt.Sprintf(`
+crashd_config(workdir="%s")
+set_defaults(kube_config(path="%s"))
+webhook_data = webhook_capture(timeout="2s")
+		`, workdir, k8sconfig)
+		execSetup(crashdScript)
+		Expect(err).NotTo(HaveOccurred())
+
+		dataStruct, ok := executor.result["webhook_data"].(*starlarkstruct.Struct)
+		Expect(ok).To(BeTrue())
+
+		errVal, err := dataStruct.Attr("error")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(errVal.(starlark.String).GoString()).To(BeEmpty())
+
+		fileVal, err := dataStruct.Attr("file")
+		Expect(err).NotTo(HaveOccurred())
+		webhookDir := fileVal.(starlark.String).GoString()
+		Expect(filepath.Join(webhookDir, "report.txt")).To(BeARegularFile())
+		Expect(filepath.Join(webhookDir, "kubecapture", "validatingwebhookconfigurations.json")).To(BeARegularFile())
+		Expect(filepath.Join(webhookDir, "kubecapture", "mutatingwebhookconfigurations.json")).To(BeARegularFile())
+	})
+
+	It("rejects an invalid timeout", func() {
+		execSetup(`webhook_capture(timeout="soon")`)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,105 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"unicode/utf16"
+
+	"go.starlark.net/starlark"
+)
+
+// windowsCaptureFunc is a built-in starlark function that collects Windows event logs, kubelet,
+// kube-proxy, and containerd logs, and HNS network state from Windows nodes. Commands are sent to
+// the nodes (running OpenSSH) as encoded PowerShell scripts to avoid remote shell quoting issues.
+// Starlark format: windows_capture([resources=resources][, workdir=path][, log_dir=path][, max_events=500][, tail_lines=5000])
+func windowsCaptureFunc(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var workdir, logDir string
+	var maxEvents, tailLines int
+	var resources *starlark.List
+
+	if err := starlark.UnpackArgs(
+		identifiers.windowsCapture, args, kwargs,
+		"resources?", &resources,
+		"workdir?", &workdir,
+		"log_dir?", &logDir,
+		"max_events?", &maxEvents,
+		"tail_lines?", &tailLines,
+	); err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.windowsCapture, err)
+	}
+
+	if len(workdir) == 0 {
+		if dir, err := getWorkdirFromThread(thread); err == nil {
+			workdir = dir
+		}
+	}
+	if len(workdir) == 0 {
+		workdir = defaults.workdir
+	}
+	if len(logDir) == 0 {
+		logDir = defaults.windowsLogDir
+	}
+	if maxEvents == 0 {
+		maxEvents = defaults.windowsMaxEvents
+	}
+	if tailLines == 0 {
+		tailLines = defaults.tailLines
+	}
+
+	if resources == nil {
+		res, err := getResourcesFromThread(thread)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %s", identifiers.windowsCapture, err)
+		}
+		resources = res
+	}
+
+	cmds := windowsCaptureCommands(logDir, maxEvents, tailLines)
+	results, err := execCaptureCommands(filepath.Join(workdir, "windows"), cmds, resources)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %s", identifiers.windowsCapture, err)
+	}
+
+	return commandResultsToList(results), nil
+}
+
+func windowsCaptureCommands(logDir string, maxEvents, tailLines int) []captureCommand {
+	events := func(logName string) string {
+		return fmt.Sprintf(
+			"Get-WinEvent -LogName %s -MaxEvents %d | Format-List TimeCreated,Id,LevelDisplayName,ProviderName,Message",
+			logName, maxEvents,
+		)
+	}
+	logs := func(filter string) string {
+		return fmt.Sprintf("Get-ChildItem -Path '%s' -Filter '%s' | ForEach-Object { '==> ' + $_.FullName; Get-Content -Path $_.FullName -Tail %d }", logDir, filter, tailLines)
+	}
+
+	return []captureCommand{
+		{cmd: events("System"), fileName: "system-events.txt", desc: "System event log", shell: shellPowerShell},
+		{cmd: events("Application"), fileName: "application-events.txt", desc: "Application event log", shell: shellPowerShell},
+		{cmd: logs("kubelet*.log"), fileName: "kubelet.log", desc: "kubelet logs", shell: shellPowerShell},
+		{cmd: logs("kube-proxy*.log"), fileName: "kube-proxy.log", desc: "kube-proxy logs", shell: shellPowerShell},
+		{cmd: logs("containerd*.log"), fileName: "containerd.log", desc: "containerd logs", shell: shellPowerShell},
+		{cmd: "Get-Service -Name kubelet,kube-proxy,containerd | Format-List", fileName: "services.txt", desc: "Kubernetes node services", shell: shellPowerShell},
+		{cmd: "Get-HnsNetwork | ConvertTo-Json -Depth 10", fileName: "hns-networks.json", shell: shellPowerShell},
+		{cmd: "Get-HnsEndpoint | ConvertTo-Json -Depth 10", fileName: "hns-endpoints.json", shell: shellPowerShell},
+		{cmd: "Get-HnsPolicyList | ConvertTo-Json -Depth 10", fileName: "hns-policies.json", shell: shellPowerShell},
+		{cmd: "ipconfig /all", fileName: "ipconfig.txt", desc: "Network interfaces", shell: shellPowerShell},
+	}
+}
+
+// powershellCmd returns a command that runs script with PowerShell using -EncodedCommand
+// (base64 of UTF-16LE) so the script survives any quoting done by the remote shell.
+func powershellCmd(script string) string {
+	codes := utf16.Encode([]rune(script))
+	buf := make([]byte, len(codes)*2)
+	for i, c := range codes {
+		binary.LittleEndian.PutUint16(buf[i*2:], c)
+	}
+	return fmt.Sprintf("powershell.exe -NoProfile -NonInteractive -EncodedCommand %s", base64.StdEncoding.EncodeToString(buf))
+}
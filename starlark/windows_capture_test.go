@@ -0,0 +1,63 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package starlark
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestPowershellCmd(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+	}{
+		{name: "simple script", script: "ipconfig /all"},
+		{name: "quoted script", script: `Get-ChildItem -Path 'C:\k' | ForEach-Object { "$_" }`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmd := powershellCmd(test.script)
+			parts := strings.Fields(cmd)
+			if parts[0] != "powershell.exe" {
+				t.Fatalf("unexpected program: %s", parts[0])
+			}
+
+			encoded, err := base64.StdEncoding.DecodeString(parts[len(parts)-1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			codes := make([]uint16, len(encoded)/2)
+			for i := range codes {
+				codes[i] = uint16(encoded[i*2]) | uint16(encoded[i*2+1])<<8
+			}
+			if decoded := string(utf16.Decode(codes)); decoded != test.script {
+				t.Errorf("unexpected decoded script: %s", decoded)
+			}
+		})
+	}
+}
+
+func TestWindowsCaptureCommands(t *testing.T) {
+	cmds := windowsCaptureCommands(`C:\k`, 10, 100)
+	fileNames := make(map[string]bool)
+	for _, cmd := range cmds {
+		if cmd.shell != shellPowerShell {
+			t.Errorf("command for %s is not a powershell command", cmd.fileName)
+		}
+		if fileNames[cmd.fileName] {
+			t.Errorf("duplicate file name %s", cmd.fileName)
+		}
+		fileNames[cmd.fileName] = true
+	}
+
+	for _, name := range []string{"kubelet.log", "kube-proxy.log", "containerd.log", "hns-networks.json", "system-events.txt"} {
+		if !fileNames[name] {
+			t.Errorf("missing capture for %s", name)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package tracing records the spans of a crashd run (the run, each built-in invocation, and
+// each command or copy on a host) and exports them to an OpenTelemetry collector, or to a
+// backend such as Jaeger or Tempo, using the OTLP/HTTP JSON protocol.
+package tracing
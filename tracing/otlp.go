@@ -0,0 +1,158 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vmware-tanzu/crash-diagnostics/buildinfo"
+)
+
+// OTLP span kinds and status codes
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+	statusCodeError  = 2
+)
+
+var exportClient = &http.Client{Timeout: 10 * time.Second}
+
+// OTLP/HTTP JSON encoding of an ExportTraceServiceRequest
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Events            []otlpEvent     `json:"events,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string          `json:"timeUnixNano"`
+	Name         string          `json:"name"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func toOTLPAttributes(attrs []Attribute) []otlpAttribute {
+	var result []otlpAttribute
+	for _, attr := range attrs {
+		result = append(result, otlpAttribute{Key: attr.Key, Value: otlpValue{StringValue: attr.Value}})
+	}
+	return result
+}
+
+func (t *Tracer) makeRequest(spans []*Span) otlpRequest {
+	var otlpSpans []otlpSpan
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           t.traceID,
+			SpanID:            span.spanID,
+			ParentSpanID:      span.parentID,
+			Name:              span.name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+			Attributes:        toOTLPAttributes(span.attrs),
+		}
+		if span.client {
+			s.Kind = spanKindClient
+		}
+		for _, e := range span.events {
+			s.Events = append(s.Events, otlpEvent{
+				TimeUnixNano: strconv.FormatInt(e.time.UnixNano(), 10),
+				Name:         e.name,
+				Attributes:   toOTLPAttributes(e.attrs),
+			})
+		}
+		if span.err != nil {
+			s.Status = otlpStatus{Code: statusCodeError, Message: span.err.Error()}
+		}
+		otlpSpans = append(otlpSpans, s)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: toOTLPAttributes([]Attribute{
+			String("service.name", "crashd"),
+			String("service.version", buildinfo.Version),
+		})},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/vmware-tanzu/crash-diagnostics", Version: buildinfo.Version},
+			Spans: otlpSpans,
+		}},
+	}}}
+}
+
+// export posts the spans to the OTLP endpoint
+func (t *Tracer) export(spans []*Span) error {
+	body, err := json.Marshal(t.makeRequest(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, val := range t.headers {
+		req.Header.Set(key, val)
+	}
+
+	resp, err := exportClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export spans: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed to export spans: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package tracing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// exportBatchSize is the number of ended spans that triggers an export during a run
+const exportBatchSize = 256
+
+var (
+	mu     sync.Mutex
+	tracer *Tracer
+)
+
+// Attribute is a span attribute
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// String returns a span attribute
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Tracer records the spans of a run under a root span and exports them to an OTLP endpoint
+type Tracer struct {
+	endpoint string
+	headers  map[string]string
+	traceID  string
+	root     *Span
+
+	mu sync.Mutex
+	// steps are the open step spans of each goroutine, innermost last
+	steps   map[uint64][]*Span
+	pending []*Span
+}
+
+// Span is a timed operation of a run. A nil span, returned when tracing is disabled, is valid.
+type Span struct {
+	tracer   *Tracer
+	name     string
+	spanID   string
+	parentID string
+	client   bool
+	// goroutine is the goroutine running a step span
+	goroutine uint64
+	start     time.Time
+	end       time.Time
+	attrs     []Attribute
+	events    []event
+	err       error
+}
+
+// event is a point in time of a span, i.e. the progress of a long step
+type event struct {
+	name  string
+	time  time.Time
+	attrs []Attribute
+}
+
+// NewTracer returns a tracer exporting to endpoint (i.e. http://localhost:4318), and starts
+// the root span of the run. The OTLP traces path (/v1/traces) is added when endpoint has no path.
+// Headers (i.e. authentication tokens) are sent with each export.
+func NewTracer(endpoint, rootName string, headers map[string]string, attrs ...Attribute) (*Tracer, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || len(u.Host) == 0 {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q", endpoint)
+	}
+	if len(strings.Trim(u.Path, "/")) == 0 {
+		u.Path = "/v1/traces"
+	}
+
+	t := &Tracer{endpoint: u.String(), headers: headers, traceID: newID(16), steps: make(map[uint64][]*Span)}
+	t.root = &Span{tracer: t, name: rootName, spanID: newID(8), start: time.Now(), attrs: attrs}
+	return t, nil
+}
+
+// TraceID returns the hex-encoded ID of the run trace
+func (t *Tracer) TraceID() string {
+	return t.traceID
+}
+
+// SetTracer sets the tracer used by StartStep and Start. A nil tracer disables tracing.
+func SetTracer(t *Tracer) {
+	mu.Lock()
+	defer mu.Unlock()
+	tracer = t
+}
+
+func getTracer() *Tracer {
+	mu.Lock()
+	defer mu.Unlock()
+	return tracer
+}
+
+// StartStep starts the span of a script step (a built-in invocation), a child of parent (the span of the
+// step calling it, i.e. parallel()), or of the run span when parent is nil. Callers keep the current step
+// span of each script thread, so that steps running concurrently have their own parent. Spans started with
+// Start by the goroutine running the step, until the step span ends, are children of the step span.
+func StartStep(parent *Span, name string, attrs ...Attribute) *Span {
+	t := getTracer()
+	if t == nil {
+		return nil
+	}
+	parentID := t.root.spanID
+	if parent != nil && parent.tracer == t {
+		parentID = parent.spanID
+	}
+	span := &Span{tracer: t, name: name, spanID: newID(8), parentID: parentID, goroutine: goroutineID(), start: time.Now(), attrs: attrs}
+	t.mu.Lock()
+	t.steps[span.goroutine] = append(t.steps[span.goroutine], span)
+	t.mu.Unlock()
+	return span
+}
+
+// Start starts the span of an operation on a host (i.e. a command run over SSH), a child of the innermost
+// step span of the calling goroutine. Operations run by other goroutines (i.e. workers of a step) are
+// children of the only open step when steps are not running concurrently, of the run span otherwise.
+func Start(name string, attrs ...Attribute) *Span {
+	t := getTracer()
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	parent := t.root
+	if steps := t.steps[goroutineID()]; len(steps) > 0 {
+		parent = steps[len(steps)-1]
+	} else if len(t.steps) == 1 {
+		for _, steps := range t.steps {
+			parent = steps[len(steps)-1]
+		}
+	}
+	t.mu.Unlock()
+	return &Span{tracer: t, name: name, spanID: newID(8), parentID: parent.spanID, client: true, start: time.Now(), attrs: attrs}
+}
+
+// AddEvent adds an event, with attributes, to the span (i.e. the progress of a long step). It does nothing
+// on a nil span, that is when tracing is disabled.
+func (s *Span) AddEvent(name string, attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.events = append(s.events, event{name: name, time: time.Now(), attrs: attrs})
+}
+
+// End ends the span; a non-nil err marks the span as failed
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+
+	t := s.tracer
+	t.mu.Lock()
+	if steps := t.steps[s.goroutine]; len(steps) > 0 {
+		for i := range steps {
+			if steps[i] == s {
+				steps = append(steps[:i], steps[i+1:]...)
+				break
+			}
+		}
+		if len(steps) == 0 {
+			delete(t.steps, s.goroutine)
+		} else {
+			t.steps[s.goroutine] = steps
+		}
+	}
+	t.pending = append(t.pending, s)
+	var batch []*Span
+	if len(t.pending) >= exportBatchSize {
+		batch, t.pending = t.pending, nil
+	}
+	t.mu.Unlock()
+
+	if len(batch) > 0 {
+		if err := t.export(batch); err != nil {
+			logrus.Warnf("tracing: %s", err)
+		}
+	}
+}
+
+// Shutdown ends the run span, with err as its outcome, and exports the pending spans
+func (t *Tracer) Shutdown(err error) error {
+	t.root.End(err)
+	t.mu.Lock()
+	batch := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	return t.export(batch)
+}
+
+// goroutineID returns the ID of the calling goroutine, read from the header of its stack trace
+// ("goroutine 42 [running]:"), so that host operations are attached to the step of their goroutine
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		buf = buf[:i]
+	}
+	id, _ := strconv.ParseUint(string(buf), 10, 64)
+	return id
+}
+
+func newID(size int) string {
+	id := make([]byte, size)
+	if _, err := rand.Read(id); err != nil {
+		// IDs only need to be unique, fall back to the clock
+		copy(id, []byte(fmt.Sprintf("%0*d", size, time.Now().UnixNano())))
+	}
+	return hex.EncodeToString(id)
+}
+
+// ParseHeaders parses export headers formatted as key1=value1,key2=value2
+// (the format of the OTEL_EXPORTER_OTLP_HEADERS environment variable)
+func ParseHeaders(str string) map[string]string {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(str, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		if val, err := url.QueryUnescape(strings.TrimSpace(parts[1])); err == nil {
+			headers[key] = val
+		}
+	}
+	return headers
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTracerExport(t *testing.T) {
+	var received otlpRequest
+	var path, auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	tracer, err := NewTracer(server.URL, "crashd run", ParseHeaders("Authorization=Bearer%20token"), String("crashd.script", "diagnostics.crsh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	step := StartStep(nil, "capture", String("crashd.position", "diagnostics.crsh:3:8"))
+	Start("ssh.run", String("net.peer.name", "10.0.0.1")).End(nil)
+	Start("ssh.run", String("net.peer.name", "10.0.0.2")).End(errors.New("connection refused"))
+	step.AddEvent("progress", String("crashd.bytes", "1024"))
+	step.End(nil)
+	Start("local.run").End(nil)
+
+	if err := tracer.Shutdown(nil); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/v1/traces" || auth != "Bearer token" {
+		t.Errorf("unexpected export request: path=%s authorization=%s", path, auth)
+	}
+
+	if len(received.ResourceSpans) != 1 || len(received.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected export request: %+v", received)
+	}
+	spans := make(map[string]otlpSpan)
+	var hostSpans []otlpSpan
+	for _, span := range received.ResourceSpans[0].ScopeSpans[0].Spans {
+		if span.TraceID != tracer.TraceID() {
+			t.Errorf("span %s: unexpected trace ID %s", span.Name, span.TraceID)
+		}
+		if span.Name == "ssh.run" {
+			hostSpans = append(hostSpans, span)
+			continue
+		}
+		spans[span.Name] = span
+	}
+	if len(spans) != 3 || len(hostSpans) != 2 {
+		t.Fatalf("unexpected spans: %+v", received.ResourceSpans[0].ScopeSpans[0].Spans)
+	}
+
+	root, step2 := spans["crashd run"], spans["capture"]
+	if root.ParentSpanID != "" || step2.ParentSpanID != root.SpanID || spans["local.run"].ParentSpanID != root.SpanID {
+		t.Error("unexpected step span hierarchy")
+	}
+	for _, span := range hostSpans {
+		if span.ParentSpanID != step2.SpanID || span.Kind != spanKindClient {
+			t.Errorf("unexpected host span: %+v", span)
+		}
+	}
+	if len(step2.Events) != 1 || step2.Events[0].Name != "progress" || step2.Events[0].Attributes[0].Value.StringValue != "1024" {
+		t.Errorf("unexpected step events: %+v", step2.Events)
+	}
+	if len(root.Events) != 0 {
+		t.Errorf("unexpected events outside of steps: %+v", root.Events)
+	}
+	if hostSpans[1].Status.Code != statusCodeError || hostSpans[1].Status.Message != "connection refused" {
+		t.Errorf("unexpected failed span status: %+v", hostSpans[1].Status)
+	}
+}
+
+func TestTracerConcurrentSteps(t *testing.T) {
+	tracer, err := NewTracer("localhost:4318", "crashd run", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	// parallel() runs its steps concurrently, each of them in its own goroutine
+	parallel := StartStep(nil, "parallel")
+	var wg sync.WaitGroup
+	steps := make([]*Span, 4)
+	hosts := make([]*Span, 4)
+	for i := range steps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			steps[i] = StartStep(parallel, "capture")
+			time.Sleep(time.Millisecond)
+			hosts[i] = Start("ssh.run")
+			hosts[i].End(nil)
+			steps[i].End(nil)
+		}(i)
+	}
+	wg.Wait()
+	after := Start("local.run")
+	parallel.End(nil)
+
+	for i := range steps {
+		if steps[i].parentID != parallel.spanID {
+			t.Errorf("step %d: expecting the parallel span as parent", i)
+		}
+		if hosts[i].parentID != steps[i].spanID {
+			t.Errorf("step %d: expecting the host span to be a child of its step", i)
+		}
+	}
+	if after.parentID != parallel.spanID {
+		t.Error("expecting the parallel span to remain the current step once its steps ended")
+	}
+}
+
+func TestTracingDisabled(t *testing.T) {
+	SetTracer(nil)
+	span := StartStep(nil, "capture")
+	if span != nil {
+		t.Fatal("expecting nil span when tracing is disabled")
+	}
+	span.AddEvent("progress")
+	span.End(nil)
+	Start("ssh.run").End(errors.New("failed"))
+}
+
+func TestNewTracerEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint  string
+		expected  string
+		shouldErr bool
+	}{
+		{endpoint: "localhost:4318", expected: "http://localhost:4318/v1/traces"},
+		{endpoint: "https://tempo.example.com/", expected: "https://tempo.example.com/v1/traces"},
+		{endpoint: "http://collector:4318/otlp/v1/traces", expected: "http://collector:4318/otlp/v1/traces"},
+		{endpoint: "http://", shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.endpoint, func(t *testing.T) {
+			tracer, err := NewTracer(test.endpoint, "crashd run", nil)
+			if err != nil {
+				if !test.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if test.shouldErr {
+				t.Fatal("expecting error")
+			}
+			if tracer.endpoint != test.expected {
+				t.Errorf("unexpected endpoint %s", tracer.endpoint)
+			}
+		})
+	}
+}
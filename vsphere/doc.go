@@ -0,0 +1,8 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package vsphere collects the hypervisor-side state of vSphere virtual machines (power state,
+// vCenter events and tasks, and console screenshots) with govc, the govmomi command line client.
+// The vCenter and its credentials are selected with a Config, passed to the govc commands only, or with
+// the GOVC_* variables of the process environment.
+package vsphere
@@ -0,0 +1,157 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package vsphere
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/vladimirvivien/echo"
+
+	"github.com/vmware-tanzu/crash-diagnostics/audit"
+	"github.com/vmware-tanzu/crash-diagnostics/proc"
+)
+
+// runGovc runs govc with args, and the variables of env (NAME=value) added to its environment, and
+// returns its output
+var runGovc = func(env []string, args ...string) (string, error) {
+	prog := echo.New().Prog.Avail("govc")
+	if len(prog) == 0 {
+		return "", fmt.Errorf("govc program not found")
+	}
+	result, err := proc.RunArgs(env, prog, args...)
+	if err == nil {
+		err = result.Err()
+	}
+	if err != nil {
+		return "", err
+	}
+	return result.Stdout, nil
+}
+
+// Config selects the vCenter used by govc. Empty fields keep the GOVC_* variables of the environment.
+// The configuration is only passed to the govc commands, the environment of crashd is not changed.
+type Config struct {
+	URL      string
+	Username string
+	Password string
+	Insecure bool
+}
+
+// Validate returns an error when a field cannot be passed to govc
+func (c Config) Validate() error {
+	for name, val := range map[string]string{"url": c.URL, "username": c.Username} {
+		if strings.ContainsAny(val, `'"$`+"` \t\n") {
+			return fmt.Errorf("invalid %s %q", name, val)
+		}
+	}
+	return nil
+}
+
+// env returns the GOVC_* variables of the configuration
+func (c Config) env() []string {
+	var env []string
+	for _, v := range []struct{ name, val string }{{"GOVC_URL", c.URL}, {"GOVC_USERNAME", c.Username}, {"GOVC_PASSWORD", c.Password}} {
+		if len(v.val) > 0 {
+			env = append(env, v.name+"="+v.val)
+		}
+	}
+	if c.Insecure {
+		env = append(env, "GOVC_INSECURE=1")
+	}
+	return env
+}
+
+// govc runs a govc command, each argument passed as is (inventory paths can contain spaces), and records
+// it in the audit trail
+func (c Config) govc(args ...string) (string, error) {
+	output, err := runGovc(c.env(), args...)
+	url, username := c.URL, c.Username
+	if len(url) == 0 {
+		url = os.Getenv("GOVC_URL")
+	}
+	if len(username) == 0 {
+		username = os.Getenv("GOVC_USERNAME")
+	}
+	audit.Record(url, username, audit.ActionVSphere, strings.Join(args, " "), err)
+	return output, err
+}
+
+// ValidateName returns an error when a VM name cannot be passed to govc
+func ValidateName(name string) error {
+	if len(name) == 0 || strings.ContainsAny(name, `'"$`+"` \t\n") {
+		return fmt.Errorf("invalid vm name %q", name)
+	}
+	return nil
+}
+
+// FindVM returns the inventory path of the virtual machine named name
+func (c Config) FindVM(name string) (string, error) {
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	output, err := c.govc("find", "/", "-type", "m", "-name", name)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if path := strings.TrimSpace(line); len(path) > 0 {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("vm %s not found", name)
+}
+
+// Info returns the govc vm.info JSON document of the virtual machine at path
+func (c Config) Info(path string) (string, error) {
+	return c.govc("vm.info", "-json", path)
+}
+
+// Events returns the last count vCenter events of the object at path
+func (c Config) Events(path string, count int) (string, error) {
+	return c.govc("events", "-n", strconv.Itoa(count), path)
+}
+
+// Tasks returns the last count vCenter tasks of the object at path
+func (c Config) Tasks(path string, count int) (string, error) {
+	return c.govc("tasks", "-n", strconv.Itoa(count), path)
+}
+
+// Screenshot saves the console screenshot of the virtual machine at path as a PNG file
+func (c Config) Screenshot(path, file string) error {
+	_, err := c.govc("vm.console", "-capture", file, path)
+	return err
+}
+
+// PowerState returns the power state (i.e. poweredOn) of the vm.info JSON document of a virtual
+// machine. Field names are matched case insensitively since they differ between govc versions.
+func PowerState(info string) (string, error) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(info), &doc); err != nil {
+		return "", err
+	}
+	vms, _ := field(doc, "virtualMachines").([]interface{})
+	if len(vms) == 0 {
+		return "", fmt.Errorf("no virtual machine found")
+	}
+	state, _ := field(field(vms[0], "runtime"), "powerState").(string)
+	return state, nil
+}
+
+// field returns the value of the field of obj, a JSON object, matched case insensitively
+func field(obj interface{}, name string) interface{} {
+	m, ok := obj.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	for key, val := range m {
+		if strings.EqualFold(key, name) {
+			return val
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package vsphere
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindVM(t *testing.T) {
+	defer func(run func([]string, ...string) (string, error)) { runGovc = run }(runGovc)
+
+	var called string
+	runGovc = func(env []string, args ...string) (string, error) {
+		called = strings.Join(args, " ")
+		if called == "find / -type m -name missing" {
+			return "", nil
+		}
+		return "/dc1/vm/prod/prod-md-0-a\n", nil
+	}
+
+	var cfg Config
+	path, err := cfg.FindVM("prod-md-0-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if called != "find / -type m -name prod-md-0-a" || path != "/dc1/vm/prod/prod-md-0-a" {
+		t.Errorf("unexpected call %q, path %q", called, path)
+	}
+	if _, err := cfg.FindVM("missing"); err == nil {
+		t.Error("expecting error for a missing vm")
+	}
+	if _, err := cfg.FindVM("vm; rm -rf /"); err == nil {
+		t.Error("expecting error for an invalid name")
+	}
+}
+
+func TestPowerState(t *testing.T) {
+	tests := []struct {
+		name      string
+		info      string
+		expected  string
+		shouldErr bool
+	}{
+		{name: "govc field names", info: `{"VirtualMachines":[{"Runtime":{"PowerState":"poweredOn"}}]}`, expected: "poweredOn"},
+		{name: "json field names", info: `{"virtualMachines":[{"runtime":{"powerState":"poweredOff"}}]}`, expected: "poweredOff"},
+		{name: "no vm", info: `{"virtualMachines":null}`, shouldErr: true},
+		{name: "not json", info: `govc: vm not found`, shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state, err := PowerState(test.info)
+			if err != nil {
+				if !test.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if test.shouldErr {
+				t.Fatal("expecting error, got none")
+			}
+			if state != test.expected {
+				t.Errorf("expecting %s, got %s", test.expected, state)
+			}
+		})
+	}
+}
+
+func TestEventsAndTasks(t *testing.T) {
+	defer func(run func([]string, ...string) (string, error)) { runGovc = run }(runGovc)
+
+	var calls [][]string
+	var envs []string
+	runGovc = func(env []string, args ...string) (string, error) {
+		calls = append(calls, args)
+		envs = env
+		return "", nil
+	}
+	cfg := Config{URL: "vcenter.local", Username: "admin", Password: "secret", Insecure: true}
+	cfg.Events("/dc1/vm/Prod VMs/a", 50)
+	cfg.Tasks("/dc1/vm/a", 20)
+	cfg.Screenshot("/dc1/vm/a", "/tmp/a.png")
+	expected := [][]string{
+		{"events", "-n", "50", "/dc1/vm/Prod VMs/a"},
+		{"tasks", "-n", "20", "/dc1/vm/a"},
+		{"vm.console", "-capture", "/tmp/a.png", "/dc1/vm/a"},
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expecting %q, got %q", expected, calls)
+	}
+
+	// the credentials are only passed to govc
+	expectedEnv := []string{"GOVC_URL=vcenter.local", "GOVC_USERNAME=admin", "GOVC_PASSWORD=secret", "GOVC_INSECURE=1"}
+	if !reflect.DeepEqual(envs, expectedEnv) {
+		t.Errorf("expecting env %v, got %v", expectedEnv, envs)
+	}
+	if _, set := os.LookupEnv("GOVC_PASSWORD"); set {
+		t.Error("expecting GOVC_PASSWORD not to be set in the environment of crashd")
+	}
+}